@@ -0,0 +1,70 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"tier.run/control"
+	"tier.run/trweb"
+)
+
+// clientFor returns the client to serve r with; see Handler.Accounts.
+func (h *Handler) clientFor(r *http.Request) (control.Provider, error) {
+	name := r.Header.Get("Tier-Account")
+	if name == "" && len(h.OrgPrefixes) > 0 {
+		org, err := requestOrg(r)
+		if err != nil {
+			return nil, err
+		}
+		var prefix string
+		for p := range h.OrgPrefixes {
+			if strings.HasPrefix(org, p) && len(p) > len(prefix) {
+				prefix = p
+			}
+		}
+		name = h.OrgPrefixes[prefix]
+	}
+	if name != "" {
+		c := h.Accounts[name]
+		if c == nil {
+			return nil, &trweb.HTTPError{
+				Status:  400,
+				Code:    "unknown_account",
+				Message: "unknown account " + name,
+			}
+		}
+		return c, nil
+	}
+	if h.c == nil {
+		return nil, &trweb.HTTPError{
+			Status:  400,
+			Code:    "account_required",
+			Message: "no account selected by Tier-Account header or org",
+		}
+	}
+	return h.c, nil
+}
+
+// requestOrg returns the org r is for, as given in its query, or else in
+// the "org" field of its JSON body, leaving the body to be read again.
+func requestOrg(r *http.Request) (string, error) {
+	if org := r.URL.Query().Get("org"); org != "" {
+		return org, nil
+	}
+	if r.Method != "POST" || r.Body == nil {
+		return "", nil
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return "", err
+	}
+	r.Body = io.NopCloser(bytes.NewReader(data))
+	var v struct {
+		Org string `json:"org"`
+	}
+	json.Unmarshal(data, &v) // invalid bodies are rejected when served
+	return v.Org, nil
+}
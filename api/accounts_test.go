@@ -0,0 +1,99 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"tier.run/api/apitypes"
+	"tier.run/control"
+	"tier.run/fetch"
+	"tier.run/fetch/fetchtest"
+	"tier.run/stripe/stripefake"
+)
+
+func TestAccounts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	newClient := func() *control.Client {
+		return &control.Client{Stripe: stripefake.Client(t), Logf: t.Logf}
+	}
+	acme, globex := newClient(), newClient()
+
+	h := NewHandler(nil, t.Logf)
+	h.helper = t.Helper
+	h.Accounts = map[string]control.Provider{
+		"acme":   acme,
+		"globex": globex,
+	}
+	h.OrgPrefixes = map[string]string{
+		"org:acme-":       "acme",
+		"org:acme-globex": "globex",
+	}
+	c := fetchtest.NewTLSServer(t, h.ServeHTTP)
+
+	subscribe := func(org string, header ...string) error {
+		t.Helper()
+		var opts []any
+		if len(header) > 0 {
+			opts = append(opts, http.Header{"Tier-Account": header})
+		}
+		_, err := fetch.OK[struct{}, *apitypes.Error](ctx, c, "POST", "/v1/subscribe", apitypes.ScheduleRequest{
+			Org:  org,
+			Info: &apitypes.OrgInfo{Email: org + "@example.com"},
+		}, opts...)
+		return err
+	}
+	whois := func(cc *control.Client, org string) string {
+		t.Helper()
+		id, err := cc.WhoIs(ctx, org)
+		if err != nil {
+			return ""
+		}
+		return id
+	}
+
+	if err := subscribe("org:acme-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := subscribe("org:acme-globex-1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := subscribe("org:other", "globex"); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		c    *control.Client
+		org  string
+		want bool
+	}{
+		{acme, "org:acme-1", true},
+		{globex, "org:acme-1", false},
+		{globex, "org:acme-globex-1", true},
+		{acme, "org:acme-globex-1", false},
+		{globex, "org:other", true},
+		{acme, "org:other", false},
+	} {
+		if got := whois(tt.c, tt.org) != ""; got != tt.want {
+			t.Errorf("org %q in account: %v; want %v", tt.org, got, tt.want)
+		}
+	}
+
+	for _, tt := range []struct {
+		org, account, code string
+	}{
+		{"org:other", "", "account_required"},
+		{"org:acme-2", "initech", "unknown_account"},
+	} {
+		var header []string
+		if tt.account != "" {
+			header = []string{tt.account}
+		}
+		err := subscribe(tt.org, header...)
+		if e, ok := err.(*apitypes.Error); !ok || e.Code != tt.code {
+			t.Errorf("subscribe(%q, %q) = %v; want %s", tt.org, tt.account, err, tt.code)
+		}
+	}
+}
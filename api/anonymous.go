@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"tier.run/api/apitypes"
+	"tier.run/control"
+	"tier.run/refs"
+	"tier.run/trweb"
+)
+
+// isAnonymous reports whether org names an anonymous subject, such as a
+// device or a session not yet signed up, rather than an org.
+func isAnonymous(org string) bool {
+	return strings.HasPrefix(org, "anon:")
+}
+
+var errAnonymousDisabled = &trweb.HTTPError{
+	Status:  501,
+	Code:    "not_supported",
+	Message: "anonymous subjects require a store and an anonymous plan",
+}
+
+// anonymousPlan holds the features of Handler.AnonymousPlan once pulled.
+type anonymousPlan struct {
+	mu sync.Mutex
+	fs []control.Feature
+}
+
+// anonymousFeatures returns the features of h.AnonymousPlan. Pushed plans
+// do not change, so they are pulled only once.
+func (h *Handler) anonymousFeatures(ctx context.Context) ([]control.Feature, error) {
+	if h.Store == nil || h.AnonymousPlan.IsZero() {
+		return nil, errAnonymousDisabled
+	}
+	h.anon.mu.Lock()
+	defer h.anon.mu.Unlock()
+	if h.anon.fs == nil {
+		fs, err := h.c.PullWithOptions(ctx, control.PullOptions{
+			Plan:     h.AnonymousPlan,
+			Archived: true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if len(fs) == 0 {
+			return nil, fmt.Errorf("anonymous plan %s: %w", h.AnonymousPlan, control.ErrFeatureNotFound)
+		}
+		h.anon.fs = fs
+	}
+	return h.anon.fs, nil
+}
+
+func (h *Handler) anonymousPhase(ctx context.Context) (apitypes.PhaseResponse, error) {
+	fs, err := h.anonymousFeatures(ctx)
+	if err != nil {
+		return apitypes.PhaseResponse{}, err
+	}
+	return apitypes.PhaseResponse{
+		Features: control.FeaturePlans(fs),
+		Plans:    []refs.Plan{h.AnonymousPlan},
+	}, nil
+}
+
+// anonymousLimits returns the limits of the features of h.AnonymousPlan,
+// and their use by the anonymous subject org since it was first reported.
+func (h *Handler) anonymousLimits(ctx context.Context, org string) (apitypes.UsageResponse, error) {
+	fs, err := h.anonymousFeatures(ctx)
+	if err != nil {
+		return apitypes.UsageResponse{}, err
+	}
+	used := h.Store.lookup(org).Used
+	rr := apitypes.UsageResponse{Org: org}
+	for _, f := range fs {
+		limit := f.Limit()
+		if !f.InRollout(org) {
+			limit = 0
+		}
+		rr.Usage = append(rr.Usage, apitypes.Usage{
+			Feature: f.Name(),
+			Limit:   limit,
+			Used:    used[f.Name().String()],
+		})
+	}
+	return rr, nil
+}
+
+func (h *Handler) reportAnonymous(ctx context.Context, rr apitypes.ReportRequest) error {
+	fs, err := h.anonymousFeatures(ctx)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(fs, func(f control.Feature) bool {
+		return f.Name() == rr.Feature
+	})
+	if i < 0 {
+		return &control.OrgError{Org: rr.Org, Phase: -1, Feature: rr.Feature.String(), Err: control.ErrFeatureNotFound}
+	}
+	if !fs[i].IsMetered() {
+		return control.ErrFeatureNotMetered
+	}
+	h.Store.putUsage(rr.Org, rr.Feature, rr.N, rr.Clobber)
+	return nil
+}
+
+// serveMergeAnonymous reports the usage of an anonymous subject to the org
+// it signed up as, and forgets the subject. Usage of features the org is
+// not subscribed to, or that are not metered, is dropped.
+func (h *Handler) serveMergeAnonymous(w http.ResponseWriter, r *http.Request) error {
+	var mr apitypes.MergeAnonymousRequest
+	if err := trweb.DecodeStrict(r, &mr); err != nil {
+		return err
+	}
+	if !isAnonymous(mr.Anonymous) || mr.Org == "" {
+		return trweb.InvalidRequest
+	}
+	if h.Store == nil {
+		return errAnonymousDisabled
+	}
+
+	used := h.Store.lookup(mr.Anonymous).Used
+	names := maps.Keys(used)
+	slices.Sort(names)
+	for _, name := range names {
+		fn, err := refs.ParseName(name)
+		if err != nil {
+			return err
+		}
+		err = h.c.ReportUsage(r.Context(), mr.Org, fn, control.Report{
+			N:  used[name],
+			At: time.Now(),
+		})
+		if errors.Is(err, control.ErrFeatureNotFound) || errors.Is(err, control.ErrFeatureNotMetered) {
+			h.Logf("merge: dropping use of %s by %q: %v", name, mr.Anonymous, err)
+		} else if err != nil {
+			return err
+		}
+		// Forget each use once reported, so that retrying a failed
+		// merge does not report it twice.
+		h.Store.putUsage(mr.Anonymous, fn, 0, true)
+	}
+	return nil
+}
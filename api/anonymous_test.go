@@ -0,0 +1,123 @@
+package api
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/api/apitypes"
+	"tier.run/client/tier"
+	"tier.run/control"
+	"tier.run/fetch/fetchtest"
+	"tier.run/refs"
+	"tier.run/stripe/stripefake"
+)
+
+func TestAnonymous(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cc := &control.Client{Stripe: stripefake.Client(t), Logf: t.Logf}
+
+	m := []control.Feature{{
+		FeaturePlan: mpf("feature:x@plan:free@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Mode:        "graduated",
+		Aggregate:   "sum",
+		Tiers:       []control.Tier{{Upto: 10}},
+	}, {
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Mode:        "graduated",
+		Aggregate:   "sum",
+		Tiers:       []control.Tier{{Upto: 100}},
+	}}
+	if err := cc.Push(ctx, m, func(f control.Feature, err error) {
+		if err != nil {
+			t.Fatalf("error pushing %q: %v", f.FeaturePlan, err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenStore(filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(cc, t.Logf)
+	h.helper = t.Helper
+	h.Store = s
+	h.AnonymousPlan = refs.MustParsePlan("plan:free@0")
+	tc := &tier.Client{HTTPClient: fetchtest.NewTLSServer(t, h.ServeHTTP)}
+
+	code := func(err error) string {
+		if e, ok := err.(*apitypes.Error); ok {
+			return e.Code
+		}
+		return ""
+	}
+	limits := func(org string) []apitypes.Usage {
+		t.Helper()
+		u, err := tc.LookupLimits(ctx, org)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u.Usage
+	}
+
+	diff.Test(t, t.Errorf, limits("anon:device"), []apitypes.Usage{{
+		Feature: mpn("feature:x"),
+		Limit:   10,
+	}})
+	for i := 0; i < 2; i++ {
+		if err := tc.Report(ctx, "anon:device", "feature:x", 3); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tc.Report(ctx, "anon:device", "feature:y", 1); code(err) != "feature_not_found" {
+		t.Errorf("reporting feature not in plan: err = %v; want feature_not_found", err)
+	}
+	diff.Test(t, t.Errorf, limits("anon:device"), []apitypes.Usage{{
+		Feature: mpn("feature:x"),
+		Limit:   10,
+		Used:    6,
+	}})
+	p, err := tc.LookupPhase(ctx, "anon:device")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, p.Plans, []refs.Plan{refs.MustParsePlan("plan:free@0")})
+
+	// Anonymous subjects are not refreshed from Stripe.
+	diff.Test(t, t.Errorf, s.Orgs(), []string(nil))
+
+	if err := cc.SubscribeTo(ctx, "org:signedup", []refs.FeaturePlan{mpf("feature:x@plan:pro@0")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.MergeAnonymous(ctx, "org:signedup", "org:signedup"); code(err) != "invalid_request" {
+		t.Errorf("merging org: err = %v; want invalid_request", err)
+	}
+	if err := tc.MergeAnonymous(ctx, "anon:device", "org:signedup"); err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, limits("org:signedup"), []apitypes.Usage{{
+		Feature: mpn("feature:x"),
+		Limit:   100,
+		Used:    6,
+	}}, diff.ZeroFields[apitypes.Usage]("PeriodStart", "PeriodEnd"))
+	diff.Test(t, t.Errorf, limits("anon:device"), []apitypes.Usage{{
+		Feature: mpn("feature:x"),
+		Limit:   10,
+	}})
+
+	// Anonymous subjects are not served without a store.
+	h2 := NewHandler(cc, t.Logf)
+	h2.AnonymousPlan = h.AnonymousPlan
+	tc2 := &tier.Client{HTTPClient: fetchtest.NewTLSServer(t, h2.ServeHTTP)}
+	if _, err := tc2.LookupLimits(ctx, "anon:device"); code(err) != "not_supported" {
+		t.Errorf("limits without store: err = %v; want not_supported", err)
+	}
+}
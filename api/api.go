@@ -1,10 +1,13 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/kr/pretty"
@@ -12,7 +15,11 @@ import (
 	"tier.run/api/apitypes"
 	"tier.run/api/materialize"
 	"tier.run/control"
+	"tier.run/metrics"
+	"tier.run/notify"
+	"tier.run/refs"
 	"tier.run/stripe"
+	"tier.run/trace"
 	"tier.run/trweb"
 	"tier.run/values"
 )
@@ -29,6 +36,16 @@ var errorLookup = map[error]error{
 		Code:    "feature_not_found",
 		Message: "feature not found",
 	},
+	control.ErrInvalidPhase: &trweb.HTTPError{
+		Status:  400,
+		Code:    "invalid_phase",
+		Message: "invalid phase",
+	},
+	control.ErrTooManyItems: &trweb.HTTPError{
+		Status:  400,
+		Code:    "too_many_items",
+		Message: "too many subscription items",
+	},
 	control.ErrFeatureNotMetered: &trweb.HTTPError{ // TODO(bmizerany): this may be relaxed if we decide to log and accept
 		Status:  400,
 		Code:    "invalid_request",
@@ -44,6 +61,56 @@ var errorLookup = map[error]error{
 		Code:    "invalid_metadata",
 		Message: "metadata keys must not use reserved prefix ('tier.')",
 	},
+	control.ErrLiveClock: &trweb.HTTPError{
+		Status:  400,
+		Code:    "invalid_request",
+		Message: "test clocks are not available in live mode",
+	},
+	control.ErrInvoiceNotFound: &trweb.HTTPError{
+		Status:  404,
+		Code:    "invoice_not_found",
+		Message: "invoice not found",
+	},
+	control.ErrInvoiceStatus: &trweb.HTTPError{
+		Status:  409,
+		Code:    "invalid_invoice_status",
+		Message: "invoice status does not allow operation",
+	},
+	control.ErrChargeNotFound: &trweb.HTTPError{
+		Status:  404,
+		Code:    "charge_not_found",
+		Message: "charge not found",
+	},
+	control.ErrNoSubscription: &trweb.HTTPError{
+		Status:  400,
+		Code:    "no_subscription",
+		Message: "org has no subscription",
+	},
+	control.ErrPlanNotFound: &trweb.HTTPError{
+		Status:  400,
+		Code:    "plan_not_found",
+		Message: "plan not found",
+	},
+	control.ErrScheduleConflict: &trweb.HTTPError{
+		Status:  409,
+		Code:    "schedule_conflict",
+		Message: "schedule changed while being updated; try again",
+	},
+	control.ErrOrgExists: &trweb.HTTPError{
+		Status:  409,
+		Code:    "org_exists",
+		Message: "test clocks can only be attached to new orgs",
+	},
+	stripe.ErrLiveModeGuard: &trweb.HTTPError{
+		Status:  403,
+		Code:    "live_mode_guard",
+		Message: "destructive operations in live mode are not allowed",
+	},
+	stripe.ErrStripeUnavailable: &trweb.HTTPError{
+		Status:  503,
+		Code:    "stripe_unavailable",
+		Message: "stripe is unavailable; try again later",
+	},
 	stripe.ErrInvalidAPIKey: &trweb.HTTPError{
 		Status:  401,
 		Code:    "invalid_api_key",
@@ -63,13 +130,81 @@ func lookupErr(err error) error {
 }
 
 type Handler struct {
-	Logf   func(format string, args ...any)
-	c      *control.Client
+	Logf func(format string, args ...any)
+
+	// Store, if non-nil, records the phases and limits served for each
+	// org, and serves them instead when Stripe is unavailable.
+	Store *Store
+
+	// Tracer, if non-nil, traces each request served as a span named for
+	// its path. Set the Tracer of the control.Client too, to trace the
+	// work done for each request within it.
+	Tracer trace.Tracer
+
+	// Accounts, if non-nil, maps the names of accounts to the providers
+	// serving them, so that one Handler may serve many accounts. Each
+	// request is served by the provider for the account named by its
+	// Tier-Account header, or else by the account mapped to the longest
+	// prefix in OrgPrefixes of the org it is for, or else by the provider
+	// given to NewHandler, if not nil. Requests naming an unknown account
+	// are rejected.
+	//
+	// Store holds the orgs of the default provider only, and is not used
+	// for requests served by Accounts.
+	Accounts map[string]control.Provider
+
+	// OrgPrefixes maps org prefixes, such as "org:acme-", to the names of
+	// accounts in Accounts.
+	OrgPrefixes map[string]string
+
+	// AnonymousPlan, if not zero, is the plan anonymous subjects, such as
+	// devices or sessions not yet signed up, are entitled to. Anonymous
+	// subjects are named like orgs, but prefixed "anon:" instead of
+	// "org:". Their limits and phase are those of AnonymousPlan, and their
+	// usage is tracked in Store, so no customer is created for them, until
+	// merged into the org they sign up as by /v1/anonymous/merge.
+	// Anonymous subjects require a Store.
+	AnonymousPlan refs.Plan
+
+	// WebhookSecret, if set, is the signing secret of the Stripe webhook
+	// endpoint served at /v1/webhook, passing the events received to
+	// control.Client.HandleEvent to notify its Notifier.
+	WebhookSecret string
+
+	// Events, if non-nil, is the stream of the lifecycle events of orgs
+	// served from /v1/events. It must also be notified of the events, by
+	// being, or being among, the Notifier of the control.Client.
+	Events *notify.Stream
+
+	// Metrics, if non-nil, records the duration of each request served,
+	// labeled by path and status, in the histogram
+	// tier_api_request_duration_seconds. Requests for unknown paths are
+	// labeled with the path "other".
+	Metrics metrics.Sink
+
+	// HealthPath, if set, is the path served with a 200 response, for
+	// load balancers and orchestrators to check the sidecar is up. It
+	// makes no requests to the billing provider, so it reports the
+	// sidecar up while the provider is unavailable.
+	HealthPath string
+
+	// RequestLogf, if non-nil, logs the requests selected by RequestLog,
+	// one line each, with the method, path, org, status, bytes written,
+	// and duration of the request, and the error serving it, if any.
+	// Requests to HealthPath are not logged.
+	RequestLogf func(format string, args ...any)
+	RequestLog  LogLevel
+
+	c      control.Provider
+	anon   *anonymousPlan
 	helper func()
 }
 
-func NewHandler(c *control.Client, logf func(string, ...any)) *Handler {
-	return &Handler{c: c, Logf: logf, helper: func() {}}
+// NewHandler returns a Handler serving the API using c. Endpoints specific
+// to Stripe, such as those for test clocks, are served only if c is a
+// *control.Client.
+func NewHandler(c control.Provider, logf func(string, ...any)) *Handler {
+	return &Handler{c: c, Logf: logf, anon: &anonymousPlan{}, helper: func() {}}
 }
 
 func isInvalidAccount(err error) bool {
@@ -77,11 +212,104 @@ func isInvalidAccount(err error) bool {
 	return errors.As(err, &e) && e.Code == "account_invalid"
 }
 
+// cardError returns an HTTPError describing why a card was declined if err
+// is a card error from Stripe; otherwise it returns nil.
+func cardError(err error) error {
+	var e *stripe.Error
+	if !errors.Is(err, stripe.ErrCardDeclined) || !errors.As(err, &e) {
+		return nil
+	}
+	return &trweb.HTTPError{
+		Status:  402,
+		Code:    values.Coalesce(e.DeclineCode, e.Code, "card_declined"),
+		Message: values.Coalesce(e.Message, "card declined"),
+	}
+}
+
+// checkoutError returns an HTTPError with the URL where the org completes
+// checkout, if err is a *control.CheckoutRequiredError; otherwise it
+// returns nil.
+func checkoutError(err error) error {
+	var e *control.CheckoutRequiredError
+	if !errors.As(err, &e) {
+		return nil
+	}
+	return &trweb.HTTPError{
+		Status:  402,
+		Code:    "checkout_required",
+		Message: "org must complete checkout",
+		Details: &apitypes.ErrorDetails{Org: e.Org, URL: e.URL},
+	}
+}
+
+// parseError returns an HTTPError describing the invalid ref if err is a
+// *refs.ParseError; otherwise it returns nil.
+func parseError(err error) error {
+	var e *refs.ParseError
+	if !errors.As(err, &e) {
+		return nil
+	}
+	return &trweb.HTTPError{
+		Status:  400,
+		Code:    "invalid_ref",
+		Message: e.Error(),
+		Details: &apitypes.ErrorDetails{
+			Input:      e.ID,
+			Offset:     e.Offset,
+			Expected:   e.Expected,
+			Suggestion: e.Suggestion,
+		},
+	}
+}
+
+// orgError returns the HTTPError for the kind of error err wraps, with the
+// org, phase, and feature at fault as details, if err is a
+// *control.OrgError; otherwise it returns nil. The message is that of the
+// kind of error alone, as it was before details were given, so clients
+// matching on it keep working.
+func orgError(err error) error {
+	var e *control.OrgError
+	if !errors.As(err, &e) {
+		return nil
+	}
+	he, ok := lookupErr(e.Err).(*trweb.HTTPError)
+	if !ok {
+		return nil
+	}
+	d := &apitypes.ErrorDetails{
+		Org:     e.Org,
+		Feature: e.Feature,
+		Reason:  e.Reason,
+	}
+	if e.Phase >= 0 {
+		d.Phase = &e.Phase
+	}
+	return &trweb.HTTPError{
+		Status:  he.Status,
+		Code:    he.Code,
+		Message: he.Message,
+		Details: d,
+	}
+}
+
 func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, span := trace.Start(r.Context(), h.Tracer, "api "+r.URL.Path, trace.String("http.method", r.Method))
+	defer span.End()
+	if org := r.URL.Query().Get("org"); org != "" {
+		span.SetAttributes(trace.String("tier.org", org))
+	}
+	if actor := r.Header.Get("Tier-Actor"); actor != "" {
+		ctx = control.WithActor(ctx, actor)
+	}
+	r = r.WithContext(ctx)
+
 	var err error
 	bw := &byteCountResponseWriter{ResponseWriter: w}
+	w = bw
+	defer h.observe(r, bw, time.Now(), &err)
 	err = h.serve(bw, r)
 	if err != nil {
+		span.RecordError(err)
 		h.Logf("%s %s %s: %v", r.RemoteAddr, r.Method, r.URL, err)
 	}
 
@@ -92,6 +320,18 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	if e := cardError(err); e != nil {
+		trweb.WriteError(w, e)
+		return
+	}
+	if e := orgError(err); e != nil {
+		trweb.WriteError(w, e)
+		return
+	}
+	if e := checkoutError(err); e != nil {
+		trweb.WriteError(w, e)
+		return
+	}
 	if trweb.WriteError(w, lookupErr(err)) || trweb.WriteError(w, err) {
 		return
 	}
@@ -104,21 +344,45 @@ func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		})
 		return
 	}
+	if e := parseError(err); e != nil {
+		trweb.WriteError(w, e)
+		return
+	}
 	if err != nil {
 		trweb.WriteError(w, trweb.InternalError)
 		return
 	}
-	if bw.n == 0 {
+	if bw.n == 0 && !bw.wroteHeader {
 		io.WriteString(w, "{}")
 	}
 }
 
 func (h *Handler) serve(w http.ResponseWriter, r *http.Request) error {
+	if h.HealthPath != "" && r.URL.Path == h.HealthPath {
+		return nil
+	}
+	if r.URL.Path == "/v1/events" {
+		// The events of all accounts are streamed together.
+		return h.serveEvents(w, r)
+	}
+	c, err := h.clientFor(r)
+	if err != nil {
+		return err
+	}
+	if c != h.c {
+		ah := *h
+		ah.c = c
+		ah.Store = nil
+		h = &ah
+	}
+
 	switch r.URL.Path {
 	case "/v1/whoami":
 		return h.serveWhoAmI(w, r)
 	case "/v1/whois":
 		return h.serveWhoIs(w, r)
+	case "/v1/orgs":
+		return h.serveOrgs(w, r)
 	case "/v1/limits":
 		return h.serveLimits(w, r)
 	case "/v1/report":
@@ -127,12 +391,52 @@ func (h *Handler) serve(w http.ResponseWriter, r *http.Request) error {
 		return h.serveSubscribe(w, r)
 	case "/v1/phase":
 		return h.servePhase(w, r)
+	case "/v1/phases":
+		return h.servePhases(w, r)
 	case "/v1/pull":
 		return h.servePull(w, r)
 	case "/v1/push":
 		return h.servePush(w, r)
+	case "/v1/validate":
+		return h.serveValidate(w, r)
+	case "/v1/diff":
+		return h.serveDiff(w, r)
+	case "/v1/clock":
+		return h.serveClock(w, r)
+	case "/v1/clock/attach":
+		return h.serveClockAttach(w, r)
+	case "/v1/audit":
+		return h.serveAudit(w, r)
+	case "/v1/invoices":
+		return h.serveInvoices(w, r)
+	case "/v1/invoices/finalize", "/v1/invoices/void", "/v1/invoices/mark_uncollectible":
+		return h.serveInvoiceAction(w, r)
+	case "/v1/refunds":
+		return h.serveRefund(w, r)
+	case "/v1/credit_notes":
+		return h.serveCreditNote(w, r)
+	case "/v1/credit":
+		return h.serveCredit(w, r)
+	case "/v1/reports/revenue":
+		return h.serveRevenue(w, r)
+	case "/v1/anonymous/merge":
+		return h.serveMergeAnonymous(w, r)
+	case "/v1/parent":
+		return h.serveParent(w, r)
+	case "/v1/checkout":
+		return h.serveCheckout(w, r)
+	case "/v1/cancel":
+		return h.serveCancel(w, r)
+	case "/v1/pause", "/v1/resume":
+		return h.servePause(w, r)
+	case "/v1/webhook":
+		return h.serveWebhook(w, r)
+	case "/v1/rollout":
+		return h.serveRollout(w, r)
+	case "/v1/migrate":
+		return h.serveMigrate(w, r)
 	default:
-		return trweb.NotFound
+		return errNoRoute
 	}
 }
 
@@ -144,20 +448,48 @@ func (h *Handler) serveSubscribe(w http.ResponseWriter, r *http.Request) error {
 
 	var phases []control.Phase
 	if len(sr.Phases) > 0 {
-		m, err := h.c.Pull(r.Context(), 0)
+		m, err := h.c.PullWithOptions(r.Context(), control.PullOptions{})
 		if err != nil {
 			return err
 		}
 
+		if sr.AtPeriodEnd {
+			if !sr.Phases[0].Effective.IsZero() {
+				return &control.ValidationError{Message: "the first phase must not have an effective time at period end"}
+			}
+			info, err := h.c.LookupOrg(r.Context(), sr.Org)
+			if err != nil {
+				return err
+			}
+			if info.PeriodEnd == nil {
+				return &control.ValidationError{Message: "org has no current billing period to end"}
+			}
+			sr.Phases[0].Effective = *info.PeriodEnd
+		}
 		for _, p := range sr.Phases {
 			fs, err := control.Expand(m, p.Features...)
 			if err != nil {
 				return err
 			}
-			phases = append(phases, control.Phase{
+			if p.TrialDays < 0 {
+				return &control.ValidationError{Message: "trial days must not be negative"}
+			}
+			phase := control.Phase{
 				Effective: p.Effective,
 				Features:  fs,
-			})
+				Interval:  p.Interval,
+			}
+			if p.TrialDays > 0 {
+				phase.TrialEnd = values.Coalesce(p.Effective, time.Now()).AddDate(0, 0, p.TrialDays)
+			}
+			phases = append(phases, phase)
+		}
+	}
+	if len(phases) > 0 && !phases[0].Effective.IsZero() {
+		var err error
+		phases, err = h.keepCurrentPhase(r.Context(), sr.Org, phases)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -165,11 +497,32 @@ func (h *Handler) serveSubscribe(w http.ResponseWriter, r *http.Request) error {
 	return h.c.ScheduleNow(r.Context(), sr.Org, info, phases)
 }
 
+// keepCurrentPhase returns phases, which begin later than now, preceded by
+// the current phase of org, so that org stays in it until they begin.
+func (h *Handler) keepCurrentPhase(ctx context.Context, org string, phases []control.Phase) ([]control.Phase, error) {
+	cps, err := h.c.LookupPhases(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+	i := slices.IndexFunc(cps, func(p control.Phase) bool { return p.Current })
+	if i < 0 {
+		return nil, &control.ValidationError{Message: "org has no current phase to stay in until the first phase begins"}
+	}
+	current := control.Phase{
+		Features: cps[i].Features,
+		Interval: cps[i].Interval,
+	}
+	return append([]control.Phase{current}, phases...), nil
+}
+
 func (h *Handler) serveReport(w http.ResponseWriter, r *http.Request) error {
 	var rr apitypes.ReportRequest
 	if err := trweb.DecodeStrict(r, &rr); err != nil {
 		return err
 	}
+	if isAnonymous(rr.Org) {
+		return h.reportAnonymous(r.Context(), rr)
+	}
 
 	return h.c.ReportUsage(r.Context(), rr.Org, rr.Feature, control.Report{
 		N:       rr.N,
@@ -198,8 +551,26 @@ func (h *Handler) serveWhoIs(w http.ResponseWriter, r *http.Request) error {
 	return httpJSON(w, res)
 }
 
+// stripeClient returns the client of h for endpoints specific to Stripe,
+// or an error if h is not served by Stripe.
+func (h *Handler) stripeClient() (*control.Client, error) {
+	c, ok := h.c.(*control.Client)
+	if !ok {
+		return nil, &trweb.HTTPError{
+			Status:  501,
+			Code:    "not_supported",
+			Message: "not supported by the billing provider",
+		}
+	}
+	return c, nil
+}
+
 func (h *Handler) serveWhoAmI(w http.ResponseWriter, r *http.Request) error {
-	who, err := h.c.WhoAmI(r.Context())
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	who, err := sc.WhoAmI(r.Context())
 	if err != nil {
 		return err
 	}
@@ -215,49 +586,148 @@ func (h *Handler) serveWhoAmI(w http.ResponseWriter, r *http.Request) error {
 
 func (h *Handler) servePhase(w http.ResponseWriter, r *http.Request) error {
 	org := r.FormValue("org")
-	ps, err := h.c.LookupPhases(r.Context(), org)
+	if isAnonymous(org) {
+		p, err := h.anonymousPhase(r.Context())
+		if err != nil {
+			return err
+		}
+		return httpJSON(w, p)
+	}
+	p, err := h.lookupPhase(r.Context(), org)
+	if h.Store != nil {
+		if err == nil {
+			h.Store.putPhase(org, p)
+		} else if e := h.Store.lookup(org); e.Phase != nil && stripe.IsUnavailable(err) {
+			h.Logf("serving phase of %q stored at %v: %v", org, e.Changed, err)
+			p, err = *e.Phase, nil
+		}
+	}
 	if err != nil {
 		return err
 	}
+	return httpJSON(w, p)
+}
+
+// lookupPhase returns the current phase of org.
+func (h *Handler) lookupPhase(ctx context.Context, org string) (apitypes.PhaseResponse, error) {
+	ps, err := h.c.LookupPhases(ctx, org)
+	if err != nil {
+		return apitypes.PhaseResponse{}, err
+	}
 
 	h.Logf("lookup phases: %# v", pretty.Formatter(ps))
 
 	for _, p := range ps {
 		if p.Current {
-			return httpJSON(w, apitypes.PhaseResponse{
-				Effective: p.Effective,
-				Features:  p.Features,
-				Plans:     p.Plans,
-				Fragments: p.Fragments(),
-			})
+			return phaseResponse(p), nil
 		}
 	}
 
-	return trweb.NotFound
+	return apitypes.PhaseResponse{}, trweb.NotFound
+}
+
+func phaseResponse(p control.Phase) apitypes.PhaseResponse {
+	return apitypes.PhaseResponse{
+		Effective: p.Effective,
+		Features:  p.Features,
+		Plans:     p.Plans,
+		Fragments: p.Fragments(),
+		AddOns:    p.AddOns(),
+		Interval:  p.Interval,
+		TrialEnd:  timeOrNil(p.TrialEnd),
+	}
+}
+
+// servePhases serves the timeline of the phases of an org. If the "all"
+// parameter is true, phases of canceled subscriptions are included.
+func (h *Handler) servePhases(w http.ResponseWriter, r *http.Request) error {
+	org := r.FormValue("org")
+	if org == "" {
+		return trweb.InvalidRequest
+	}
+	lookup := h.c.LookupPhases
+	if r.FormValue("all") == "true" {
+		sc, err := h.stripeClient()
+		if err != nil {
+			return err
+		}
+		lookup = sc.LookupPhaseHistory
+	}
+	ps, err := lookup(r.Context(), org)
+	if err != nil {
+		return err
+	}
+	res := apitypes.PhasesResponse{Org: org, Phases: []apitypes.TimelinePhase{}}
+	for _, p := range ps {
+		res.Phases = append(res.Phases, apitypes.TimelinePhase{
+			PhaseResponse: phaseResponse(p),
+			Current:       p.Current,
+			Canceled:      p.Canceled,
+		})
+	}
+	return httpJSON(w, res)
 }
 
 func (h *Handler) serveLimits(w http.ResponseWriter, r *http.Request) error {
 	org := r.FormValue("org")
-	usage, err := h.c.LookupLimits(r.Context(), org)
+	if isAnonymous(org) {
+		u, err := h.anonymousLimits(r.Context(), org)
+		if err != nil {
+			return err
+		}
+		return httpJSON(w, u)
+	}
+	u, err := h.lookupLimits(r.Context(), org)
+	if h.Store != nil {
+		if err == nil {
+			h.Store.putLimits(org, u)
+		} else if e := h.Store.lookup(org); e.Limits != nil && stripe.IsUnavailable(err) {
+			h.Logf("serving limits of %q stored at %v: %v", org, e.Changed, err)
+			u, err = *e.Limits, nil
+		}
+	}
 	if err != nil {
 		return err
 	}
+	return httpJSON(w, u)
+}
+
+// lookupLimits returns the limits and usage of org.
+func (h *Handler) lookupLimits(ctx context.Context, org string) (apitypes.UsageResponse, error) {
+	usage, err := h.c.LookupLimits(ctx, org)
+	if err != nil {
+		return apitypes.UsageResponse{}, err
+	}
 
 	var rr apitypes.UsageResponse
 	rr.Org = org
 	for _, u := range usage {
 		rr.Usage = append(rr.Usage, apitypes.Usage{
-			Feature: u.Feature.Name(),
-			Limit:   u.Limit,
-			Used:    u.Used,
+			Feature:     u.Feature.Name(),
+			Limit:       u.Limit,
+			Used:        u.Used,
+			PeriodStart: timeOrNil(u.Start),
+			PeriodEnd:   timeOrNil(u.End),
+			ByOrg:       u.ByOrg,
 		})
 	}
-
-	return httpJSON(w, rr)
+	return rr, nil
 }
 
 func (h *Handler) servePull(w http.ResponseWriter, r *http.Request) error {
-	m, err := h.c.Pull(r.Context(), 0)
+	opts := control.PullOptions{
+		FeaturePrefix: r.FormValue("prefix"),
+		Tag:           r.FormValue("tag"),
+		Archived:      r.FormValue("archived") == "true",
+	}
+	if plan := r.FormValue("plan"); plan != "" {
+		p, err := refs.ParsePlan(plan)
+		if err != nil {
+			return err
+		}
+		opts.Plan = p
+	}
+	m, err := h.c.PullWithOptions(r.Context(), opts)
 	if err != nil {
 		return err
 	}
@@ -279,15 +749,19 @@ func (h *Handler) servePush(w http.ResponseWriter, r *http.Request) error {
 	if err != nil {
 		return err
 	}
+	push, created := h.c.Push, "created"
+	if r.FormValue("dryrun") == "true" {
+		push, created = h.c.PushDryRun, "would be created"
+	}
 	var ee []apitypes.PushResult
-	_ = h.c.Push(r.Context(), fs, func(f control.Feature, err error) {
+	_ = push(r.Context(), fs, func(f control.Feature, err error) {
 		pr := apitypes.PushResult{
 			Feature: f.FeaturePlan,
 		}
 		switch err {
 		case nil:
 			pr.Status = "ok"
-			pr.Reason = "created"
+			pr.Reason = created
 		case control.ErrFeatureExists:
 			pr.Status = "ok"
 			pr.Reason = "feature already exists"
@@ -300,6 +774,122 @@ func (h *Handler) servePush(w http.ResponseWriter, r *http.Request) error {
 	return httpJSON(w, apitypes.PushResponse{Results: ee})
 }
 
+// readModel reads the pricing model in the body of r, as sent to
+// /v1/push.
+func readModel(r *http.Request) ([]control.Feature, error) {
+	if r.Method != "POST" {
+		return nil, trweb.MethodNotAllowed
+	}
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	fs, err := materialize.FromPricingHuJSON(data)
+	if err != nil {
+		if parseError(err) != nil {
+			return nil, err
+		}
+		return nil, &trweb.HTTPError{
+			Status:  400,
+			Code:    "invalid_request",
+			Message: err.Error(),
+		}
+	}
+	return fs, nil
+}
+
+func (h *Handler) serveValidate(w http.ResponseWriter, r *http.Request) error {
+	fs, err := readModel(r)
+	if err != nil {
+		return err
+	}
+	ds := control.Validate(fs)
+	vr := apitypes.ValidateResponse{Valid: !control.HasErrors(ds)}
+	for _, d := range ds {
+		vr.Diagnostics = append(vr.Diagnostics, apitypes.Diagnostic(d))
+	}
+	return httpJSON(w, vr)
+}
+
+func (h *Handler) serveDiff(w http.ResponseWriter, r *http.Request) error {
+	fs, err := readModel(r)
+	if err != nil {
+		return err
+	}
+	cs, err := h.c.Diff(r.Context(), fs)
+	if err != nil {
+		return err
+	}
+	dr := apitypes.DiffResponse{SchemaVersion: apitypes.SchemaVersion}
+	for _, c := range cs {
+		dr.Changes = append(dr.Changes, apitypes.Change(c))
+	}
+	return httpJSON(w, dr)
+}
+
+func (h *Handler) serveClock(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	var c control.Clock
+	if r.Method == "DELETE" {
+		id := r.FormValue("id")
+		if id == "" {
+			return trweb.InvalidRequest
+		}
+		return sc.DeleteClock(r.Context(), id)
+	}
+	if r.Method == "GET" {
+		id := r.FormValue("id")
+		if id == "" {
+			return trweb.InvalidRequest
+		}
+		c, err = sc.SyncClock(r.Context(), id)
+	} else {
+		var cr apitypes.ClockRequest
+		if err := trweb.DecodeStrict(r, &cr); err != nil {
+			return err
+		}
+		if cr.ID == "" {
+			c, err = sc.CreateClock(r.Context(), cr.Name, cr.Present)
+		} else {
+			c, err = sc.AdvanceClock(r.Context(), cr.ID, cr.Present)
+			if err == nil && cr.Wait {
+				c, err = sc.WaitClock(r.Context(), cr.ID)
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	link, err := stripe.Link(sc.Live(), sc.Stripe.AccountID, "test-clocks", c.ID)
+	if err != nil {
+		return err
+	}
+	return httpJSON(w, apitypes.ClockResponse{
+		ID:      c.ID,
+		Link:    link,
+		Present: c.Present,
+		Status:  c.Status,
+	})
+}
+
+func (h *Handler) serveClockAttach(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	var ar apitypes.ClockAttachRequest
+	if err := trweb.DecodeStrict(r, &ar); err != nil {
+		return err
+	}
+	if ar.ID == "" || ar.Org == "" {
+		return trweb.InvalidRequest
+	}
+	return sc.AttachClock(r.Context(), ar.Org, ar.ID, (*control.OrgInfo)(ar.Info))
+}
+
 func httpJSON(w http.ResponseWriter, v any) error {
 	w.Header().Set("Content-Type", "application/json")
 	enc := json.NewEncoder(w)
@@ -307,13 +897,489 @@ func httpJSON(w http.ResponseWriter, v any) error {
 	return enc.Encode(v)
 }
 
+// timeOrNil returns a pointer to t, or nil if t is zero, for use in
+// responses with optional times.
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
 type byteCountResponseWriter struct {
 	http.ResponseWriter
-	n int
+	n           int
+	wroteHeader bool
+	status      int
+}
+
+func (w *byteCountResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.status = code
+	}
+	w.wroteHeader = true
+	w.ResponseWriter.WriteHeader(code)
 }
 
 func (w *byteCountResponseWriter) Write(p []byte) (int, error) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.status = http.StatusOK
+	}
 	n, err := w.ResponseWriter.Write(p)
 	w.n += n
 	return n, err
 }
+
+func (w *byteCountResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// serveEvents streams the recent events of the org in the query, or of all
+// orgs if none, as newline-delimited JSON. If follow is true, events are
+// streamed as they happen, until the client goes away or the Handler shuts
+// down.
+func (h *Handler) serveEvents(w http.ResponseWriter, r *http.Request) error {
+	if h.Events == nil {
+		return &trweb.HTTPError{
+			Status:  404,
+			Code:    "events_disabled",
+			Message: "event stream not enabled",
+		}
+	}
+	org := r.FormValue("org")
+	follow := r.FormValue("follow") == "true"
+
+	var (
+		recent []notify.Event
+		events <-chan notify.Event
+	)
+	if follow {
+		var cancel func()
+		recent, events, cancel = h.Events.Subscribe(org)
+		defer cancel()
+	} else {
+		recent = h.Events.Recent(org)
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(200)
+	enc := json.NewEncoder(w)
+	for _, e := range recent {
+		if err := enc.Encode(e); err != nil {
+			return nil // client went away
+		}
+	}
+	flush := func() {
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	flush()
+	if !follow {
+		return nil
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return nil
+		case e, ok := <-events:
+			if !ok {
+				return nil
+			}
+			if err := enc.Encode(e); err != nil {
+				return nil
+			}
+			flush()
+		}
+	}
+}
+
+func (h *Handler) serveAudit(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	if sc.Audit == nil {
+		return &trweb.HTTPError{
+			Status:  404,
+			Code:    "audit_disabled",
+			Message: "audit log not enabled",
+		}
+	}
+	es, err := sc.Audit.List(r.Context(), r.FormValue("org"))
+	if err != nil {
+		return err
+	}
+	res := apitypes.AuditResponse{Events: []apitypes.AuditEvent{}}
+	for _, e := range es {
+		res.Events = append(res.Events, apitypes.AuditEvent(e))
+	}
+	return httpJSON(w, res)
+}
+
+// serveOrgs lists a page of orgs, filtered by the limit, starting_after,
+// created_after, created_before, and metadata[key] parameters in the
+// query.
+func (h *Handler) serveOrgs(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	invalid := func(msg string) error {
+		return &trweb.HTTPError{
+			Status:  400,
+			Code:    "invalid_request",
+			Message: msg,
+		}
+	}
+	q := r.URL.Query()
+	opts := control.ListOrgsOptions{StartingAfter: q.Get("starting_after")}
+	if s := q.Get("limit"); s != "" {
+		opts.Limit, err = strconv.Atoi(s)
+		if err != nil || opts.Limit < 1 || opts.Limit > 100 {
+			return invalid("limit must be from 1 to 100")
+		}
+	}
+	for _, t := range []struct {
+		param string
+		v     *time.Time
+	}{
+		{"created_after", &opts.CreatedAfter},
+		{"created_before", &opts.CreatedBefore},
+	} {
+		if s := q.Get(t.param); s != "" {
+			*t.v, err = time.Parse(time.RFC3339, s)
+			if err != nil {
+				return invalid(t.param + " must be an RFC 3339 time")
+			}
+		}
+	}
+	for k := range q {
+		if !strings.HasPrefix(k, "metadata[") || !strings.HasSuffix(k, "]") {
+			continue
+		}
+		key := k[len("metadata[") : len(k)-1]
+		if key == "" {
+			return invalid("metadata keys must not be empty")
+		}
+		if opts.Metadata == nil {
+			opts.Metadata = map[string]string{}
+		}
+		opts.Metadata[key] = q.Get(k)
+	}
+
+	p, err := sc.ListOrgs(r.Context(), opts)
+	if err != nil {
+		return err
+	}
+	res := apitypes.OrgsResponse{Orgs: []apitypes.OrgItem{}, Next: p.Next}
+	for _, o := range p.Orgs {
+		res.Orgs = append(res.Orgs, apitypes.OrgItem{
+			Org:      o.ID,
+			StripeID: o.ProviderID,
+			Email:    o.Email,
+			Created:  o.Created,
+			Metadata: o.Metadata,
+		})
+	}
+	return httpJSON(w, res)
+}
+
+func (h *Handler) serveInvoices(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	ins, err := sc.LookupInvoices(r.Context(), r.FormValue("org"))
+	if err != nil {
+		return err
+	}
+	res := apitypes.InvoicesResponse{Invoices: []apitypes.Invoice{}}
+	for _, in := range ins {
+		res.Invoices = append(res.Invoices, apitypes.Invoice(in))
+	}
+	return httpJSON(w, res)
+}
+
+func (h *Handler) serveInvoiceAction(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	var ir apitypes.InvoiceRequest
+	if err := trweb.DecodeStrict(r, &ir); err != nil {
+		return err
+	}
+	if ir.Org == "" || ir.ID == "" {
+		return trweb.InvalidRequest
+	}
+	move := map[string]func(context.Context, string, string) (control.Invoice, error){
+		"/v1/invoices/finalize":           sc.FinalizeInvoice,
+		"/v1/invoices/void":               sc.VoidInvoice,
+		"/v1/invoices/mark_uncollectible": sc.MarkInvoiceUncollectible,
+	}[r.URL.Path]
+	in, err := move(r.Context(), ir.Org, ir.ID)
+	if err != nil {
+		return err
+	}
+	return httpJSON(w, apitypes.Invoice(in))
+}
+
+func (h *Handler) serveRefund(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	var rr apitypes.RefundRequest
+	if err := trweb.DecodeStrict(r, &rr); err != nil {
+		return err
+	}
+	if rr.Org == "" || rr.ID == "" {
+		return trweb.InvalidRequest
+	}
+	ref, err := sc.Refund(r.Context(), rr.Org, rr.ID, rr.Amount, rr.Reason)
+	if err != nil {
+		return err
+	}
+	return httpJSON(w, apitypes.Refund(ref))
+}
+
+func (h *Handler) serveCreditNote(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	var cr apitypes.CreditNoteRequest
+	if err := trweb.DecodeStrict(r, &cr); err != nil {
+		return err
+	}
+	if cr.Org == "" || cr.Invoice == "" {
+		return trweb.InvalidRequest
+	}
+	cn, err := sc.CreateCreditNote(r.Context(), cr.Org, cr.Invoice, cr.Amount, cr.Reason, cr.Memo)
+	if err != nil {
+		return err
+	}
+	return httpJSON(w, apitypes.CreditNote(cn))
+}
+
+func (h *Handler) serveCredit(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	var cr apitypes.CreditRequest
+	if err := trweb.DecodeStrict(r, &cr); err != nil {
+		return err
+	}
+	if cr.Org == "" {
+		return trweb.InvalidRequest
+	}
+	t, err := sc.AdjustCredit(r.Context(), cr.Org, cr.Amount, cr.Currency, cr.Description)
+	if err != nil {
+		return err
+	}
+	return httpJSON(w, apitypes.CreditTransaction(t))
+}
+
+func (h *Handler) serveParent(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	var pr apitypes.ParentRequest
+	if err := trweb.DecodeStrict(r, &pr); err != nil {
+		return err
+	}
+	if pr.Org == "" {
+		return trweb.InvalidRequest
+	}
+	return sc.SetParent(r.Context(), pr.Org, pr.Parent)
+}
+
+func (h *Handler) serveCheckout(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	var cr apitypes.CheckoutRequest
+	if err := trweb.DecodeStrict(r, &cr); err != nil {
+		return err
+	}
+	if cr.Org == "" {
+		return trweb.InvalidRequest
+	}
+	p := &control.CheckoutParams{
+		TrialDays:             cr.TrialDays,
+		CancelURL:             cr.CancelURL,
+		RequireBillingAddress: cr.RequireBillingAddress,
+	}
+	if len(cr.Features) > 0 {
+		m, err := sc.PullWithOptions(r.Context(), control.PullOptions{})
+		if err != nil {
+			return err
+		}
+		p.Features, err = control.Expand(m, cr.Features...)
+		if err != nil {
+			return err
+		}
+	}
+	link, err := sc.Checkout(r.Context(), cr.Org, cr.SuccessURL, p)
+	if err != nil {
+		return err
+	}
+	return httpJSON(w, apitypes.CheckoutResponse{URL: link})
+}
+
+func (h *Handler) serveCancel(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	var cr apitypes.CancelRequest
+	if err := trweb.DecodeStrict(r, &cr); err != nil {
+		return err
+	}
+	if cr.Org == "" {
+		return trweb.InvalidRequest
+	}
+	return sc.Cancel(r.Context(), cr.Org, cr.AtPeriodEnd)
+}
+
+func (h *Handler) servePause(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	var pr apitypes.PauseRequest
+	if err := trweb.DecodeStrict(r, &pr); err != nil {
+		return err
+	}
+	if pr.Org == "" {
+		return trweb.InvalidRequest
+	}
+	if r.URL.Path == "/v1/resume" {
+		return sc.Resume(r.Context(), pr.Org)
+	}
+	return sc.Pause(r.Context(), pr.Org)
+}
+
+func (h *Handler) serveRollout(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	var rr apitypes.RolloutRequest
+	if err := trweb.DecodeStrict(r, &rr); err != nil {
+		return err
+	}
+	if rr.Feature.IsZero() {
+		return trweb.InvalidRequest
+	}
+	return sc.SetRollout(r.Context(), rr.Feature, rr.Percent)
+}
+
+// serveMigrate migrates the orgs on a plan to another, streaming a result
+// for each org as newline-delimited JSON as it is migrated.
+func (h *Handler) serveMigrate(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	var mr apitypes.MigrateRequest
+	if err := trweb.DecodeStrict(r, &mr); err != nil {
+		return err
+	}
+	if mr.From.IsZero() || mr.To.IsZero() {
+		return trweb.InvalidRequest
+	}
+
+	// The response starts with the first result, so that errors before
+	// any org is migrated are reported as usual.
+	var enc *json.Encoder
+	send := func(res apitypes.MigrateResult) {
+		if enc == nil {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(200)
+			enc = json.NewEncoder(w)
+		}
+		enc.Encode(res) // the client may have gone away; keep migrating
+		if f, ok := w.(http.Flusher); ok {
+			f.Flush()
+		}
+	}
+	opts := control.MigrateOptions{AtRenewal: mr.AtRenewal, DryRun: mr.DryRun}
+	err = sc.Migrate(r.Context(), mr.From, mr.To, opts, func(mr control.MigrateResult) {
+		res := apitypes.MigrateResult{Org: mr.Org}
+		if !mr.Effective.IsZero() {
+			res.Effective = &mr.Effective
+		}
+		if mr.Err != nil {
+			res.Error = mr.Err.Error()
+		}
+		send(res)
+	})
+	if err != nil && enc != nil {
+		send(apitypes.MigrateResult{Error: err.Error()})
+		return nil
+	}
+	return err
+}
+
+func (h *Handler) serveWebhook(w http.ResponseWriter, r *http.Request) error {
+	if h.WebhookSecret == "" {
+		return trweb.NotFound
+	}
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	if r.Method != "POST" {
+		return trweb.MethodNotAllowed
+	}
+	payload, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	if err := stripe.VerifyWebhook(payload, r.Header.Get("Stripe-Signature"), h.WebhookSecret); err != nil {
+		return &trweb.HTTPError{
+			Status:  400,
+			Code:    "invalid_signature",
+			Message: err.Error(),
+		}
+	}
+	e, err := stripe.ParseEvent(payload)
+	if err != nil {
+		return &trweb.HTTPError{
+			Status:  400,
+			Code:    "invalid_request",
+			Message: err.Error(),
+		}
+	}
+	return sc.HandleEvent(r.Context(), e)
+}
+
+func (h *Handler) serveRevenue(w http.ResponseWriter, r *http.Request) error {
+	sc, err := h.stripeClient()
+	if err != nil {
+		return err
+	}
+	rr, err := sc.Revenue(r.Context())
+	if err != nil {
+		return err
+	}
+	res := apitypes.RevenueResponse{
+		Currencies: []apitypes.CurrencyRevenue{},
+		Plans:      []apitypes.PlanRevenue{},
+	}
+	for _, c := range rr.Currencies {
+		res.Currencies = append(res.Currencies, apitypes.CurrencyRevenue(c))
+	}
+	for _, p := range rr.Plans {
+		res.Plans = append(res.Plans, apitypes.PlanRevenue(p))
+	}
+	return httpJSON(w, res)
+}
@@ -2,8 +2,16 @@ package api
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
@@ -14,8 +22,13 @@ import (
 	"tier.run/control"
 	"tier.run/fetch"
 	"tier.run/fetch/fetchtest"
+	"tier.run/notify"
 	"tier.run/refs"
+	"tier.run/stripe"
+	"tier.run/stripe/stripefake"
 	"tier.run/stripe/stroke"
+	"tier.run/trweb"
+	"tier.run/values"
 )
 
 var (
@@ -59,7 +72,7 @@ func TestAPISubscribe(t *testing.T) {
 			Aggregate:   "sum",
 			Mode:        "graduated",
 			Tiers: []control.Tier{
-				{Upto: control.Inf, Price: 100},
+				{Upto: control.Inf, Price: values.MustParseDecimal("100")},
 			},
 		},
 	}
@@ -118,7 +131,7 @@ func TestAPISubscribe(t *testing.T) {
 		diff.Test(t, t.Errorf, got, apitypes.UsageResponse{
 			Org:   org,
 			Usage: want,
-		})
+		}, diff.ZeroFields[apitypes.Usage]("PeriodStart", "PeriodEnd"))
 	}
 
 	checkPhase := func(org string, want apitypes.PhaseResponse) {
@@ -171,6 +184,10 @@ func TestAPISubscribe(t *testing.T) {
 		Status:  400,
 		Code:    "feature_not_found",
 		Message: "feature not found",
+		Details: &apitypes.ErrorDetails{
+			Org:     "org:test",
+			Feature: "feature:nope",
+		},
 	})
 
 	report("org:nope", "feature:t", 9, &apitypes.Error{
@@ -188,6 +205,23 @@ func TestAPISubscribe(t *testing.T) {
 		Status:  400,
 		Code:    "feature_not_found",
 		Message: "feature not found",
+		Details: &apitypes.ErrorDetails{
+			Org:     "org:test",
+			Phase:   new(int),
+			Feature: "feature:nope@0",
+		},
+	})
+
+	sub("org:test", []string{"Plan:Test@0"}, &apitypes.Error{
+		Status:  400,
+		Code:    "invalid_ref",
+		Message: `plan name must start with 'plan:': Plan:Test@0 (did you mean "plan:test@0"?)`,
+		Details: &apitypes.ErrorDetails{
+			Input:      "Plan:Test@0",
+			Offset:     0,
+			Expected:   "'plan:'",
+			Suggestion: "plan:test@0",
+		},
 	})
 }
 
@@ -230,7 +264,7 @@ func TestPhaseFragments(t *testing.T) {
 			Aggregate:   "sum",
 			Mode:        "graduated",
 			Tiers: []control.Tier{
-				{Upto: control.Inf, Price: 100},
+				{Upto: control.Inf, Price: values.MustParseDecimal("100")},
 			},
 		},
 	}
@@ -326,7 +360,7 @@ func TestPushInvalidPrice(t *testing.T) {
 				Features: map[refs.Name]apitypes.Feature{
 					mpn("feature:t"): {
 						Tiers: []apitypes.Tier{{
-							Price: 0.1111111111111111,
+							Price: values.MustParseDecimal("0.1111111111111111"),
 						}},
 					},
 				},
@@ -343,12 +377,117 @@ func TestPushInvalidPrice(t *testing.T) {
 			{
 				Feature: mpf("feature:t@plan:test@0"),
 				Status:  "failed",
-				Reason:  "invalid price: 0.1111111111111; tier prices must not exceed 12 decimal places",
+				Reason:  "invalid price: 0.1111111111111111; tier prices must not exceed 12 decimal places",
 			},
 		},
 	})
 }
 
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c, _ := newTestClient(t)
+
+	tc := &tier.Client{HTTPClient: c}
+
+	in := apitypes.Model{
+		Plans: map[refs.Plan]apitypes.Plan{
+			mpp("plan:test@0"): {
+				Title: "plan:test@0",
+				Features: map[refs.Name]apitypes.Feature{
+					mpn("feature:t"): {
+						Tiers: []apitypes.Tier{{Upto: 10}, {Upto: 5}},
+					},
+				},
+			},
+		},
+	}
+
+	got, err := tc.Validate(ctx, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, apitypes.ValidateResponse{
+		Valid: false,
+		Diagnostics: []apitypes.Diagnostic{{
+			Severity: "error",
+			Code:     "tiers_out_of_order",
+			Plan:     mpp("plan:test@0"),
+			Feature:  mpf("feature:t@plan:test@0"),
+			Message:  "tiers[1]: upto 5 must be greater than upto 10 of the previous tier",
+		}},
+	})
+
+	in.Plans[mpp("plan:test@0")].Features[mpn("feature:t")] = apitypes.Feature{
+		Tiers: []apitypes.Tier{{Upto: 5}, {Upto: 10}},
+	}
+	got, err = tc.Validate(ctx, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, apitypes.ValidateResponse{Valid: true})
+}
+
+func TestDiff(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c, _ := newTestClient(t)
+
+	tc := &tier.Client{HTTPClient: c}
+
+	in := apitypes.Model{
+		Plans: map[refs.Plan]apitypes.Plan{
+			mpp("plan:test@0"): {
+				Title: "plan:test@0",
+				Features: map[refs.Name]apitypes.Feature{
+					mpn("feature:t"): {Base: 100},
+				},
+			},
+		},
+	}
+	if _, err := tc.Push(ctx, in); err != nil {
+		t.Fatal(err)
+	}
+
+	in.Plans[mpp("plan:test@0")].Features[mpn("feature:t")] = apitypes.Feature{Base: 200}
+	in.Plans[mpp("plan:test@0")].Features[mpn("feature:u")] = apitypes.Feature{}
+
+	in.Plans[mpp("plan:test@1")] = apitypes.Plan{
+		Features: map[refs.Name]apitypes.Feature{
+			mpn("feature:t"): {Base: 200},
+		},
+	}
+	pr, err := tc.PushDryRun(ctx, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(pr.Results, func(a, b apitypes.PushResult) bool {
+		return a.Feature.Less(b.Feature)
+	})
+	diff.Test(t, t.Errorf, pr, apitypes.PushResponse{
+		Results: []apitypes.PushResult{
+			{Feature: mpf("feature:t@plan:test@0"), Status: "failed", Reason: "plan already exists"},
+			{Feature: mpf("feature:t@plan:test@1"), Status: "ok", Reason: "would be created"},
+			{Feature: mpf("feature:u@plan:test@0"), Status: "failed", Reason: "plan already exists"},
+		},
+	})
+	delete(in.Plans, mpp("plan:test@1"))
+
+	got, err := tc.Diff(ctx, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, apitypes.DiffResponse{
+		SchemaVersion: apitypes.SchemaVersion,
+		Changes: []apitypes.Change{
+			{Op: "change", Feature: mpf("feature:t@plan:test@0"), Fields: []string{"base"}},
+			{Op: "add", Feature: mpf("feature:u@plan:test@0")},
+		},
+	})
+}
+
 func TestWhoAmI(t *testing.T) {
 	t.Parallel()
 
@@ -497,6 +636,103 @@ func TestScheduleWithCustomerInfoNoPhases(t *testing.T) {
 	if e.Code != "not_found" {
 		t.Errorf("unexpected error: %v", e)
 	}
+
+	// Empty values remove metadata keys, leaving the others.
+	for _, md := range []map[string]string{
+		{"crm": "42", "region": "eu"},
+		{"crm": ""},
+	} {
+		if err := tc.Schedule(ctx, "org:test", &tier.ScheduleParams{
+			Info: &tier.OrgInfo{Metadata: md},
+		}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err = tc.LookupOrg(ctx, "org:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got.Metadata, map[string]string{"region": "eu"})
+}
+
+func TestClock(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	c, _ := newTestClient(t)
+	tc := &tier.Client{HTTPClient: c}
+
+	now := time.Now().Truncate(time.Second).UTC()
+	got, err := tc.CreateClock(ctx, "test", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ID == "" {
+		t.Fatal("unexpected empty clock id")
+	}
+	if !got.Present.Equal(now) {
+		t.Errorf("present = %v, want %v", got.Present, now)
+	}
+
+	next := now.Add(time.Hour)
+	if _, err := tc.AdvanceClock(ctx, got.ID, next); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		got, err = tc.SyncClock(ctx, got.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status == "ready" {
+			break
+		}
+		time.Sleep(time.Second)
+	}
+	if !got.Present.Equal(next) {
+		t.Errorf("present = %v, want %v", got.Present, next)
+	}
+
+	if err := tc.AttachClock(ctx, got.ID, "org:test", &apitypes.OrgInfo{Email: "test@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	later := next.Add(time.Hour)
+	got, err = tc.AdvanceClockAndWait(ctx, got.ID, later)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != "ready" || !got.Present.Equal(later) {
+		t.Errorf("clock = %+v; want ready at %v", got, later)
+	}
+
+	other, err := tc.CreateClock(ctx, "other", now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = tc.AttachClock(ctx, other.ID, "org:test", nil)
+	if e, ok := err.(*apitypes.Error); !ok || e.Code != "org_exists" {
+		t.Errorf("attaching to other clock: err = %v; want org_exists", err)
+	}
+	if err := tc.DeleteClock(ctx, other.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCardError(t *testing.T) {
+	err := fmt.Errorf("schedule: %w", &stripe.Error{
+		Type:        "card_error",
+		Code:        "card_declined",
+		DeclineCode: "insufficient_funds",
+		Message:     "Your card has insufficient funds.",
+	})
+	diff.Test(t, t.Errorf, cardError(err), &trweb.HTTPError{
+		Status:  402,
+		Code:    "insufficient_funds",
+		Message: "Your card has insufficient funds.",
+	})
+
+	if got := cardError(&stripe.Error{Type: "invalid_request_error"}); got != nil {
+		t.Errorf("got %v; want nil", got)
+	}
 }
 
 func maybeFailNow(t *testing.T) {
@@ -505,3 +741,367 @@ func maybeFailNow(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+type cancelNotifier struct {
+	control.NopNotifier
+	canceled chan string
+}
+
+func (n *cancelNotifier) OnCancel(_ context.Context, org string) {
+	n.canceled <- org
+}
+
+func TestWebhook(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	n := &cancelNotifier{canceled: make(chan string, 1)}
+	cc := &control.Client{Stripe: stripefake.Client(t), Logf: t.Logf, Notifier: n}
+	if err := cc.PutCustomer(ctx, "org:a", &control.OrgInfo{Email: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	cid, err := cc.WhoIs(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewHandler(cc, t.Logf)
+	h.helper = t.Helper
+	post := func(payload, secret string) int {
+		t.Helper()
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(ts + "." + payload))
+		r := httptest.NewRequest("POST", "/v1/webhook", strings.NewReader(payload))
+		r.Header.Set("Stripe-Signature", "t="+ts+",v1="+hex.EncodeToString(mac.Sum(nil)))
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w.Code
+	}
+
+	payload := `{"id":"evt_1","type":"customer.subscription.deleted","data":{"object":{"customer":"` + cid + `"}}}`
+	if code := post(payload, "whsec_test"); code != 404 {
+		t.Errorf("without secret: status = %d; want 404", code)
+	}
+	h.WebhookSecret = "whsec_test"
+	if code := post(payload, "whsec_other"); code != 400 {
+		t.Errorf("signed with other secret: status = %d; want 400", code)
+	}
+	if code := post(payload, "whsec_test"); code != 200 {
+		t.Fatalf("status = %d; want 200", code)
+	}
+	if got := <-n.canceled; got != "org:a" {
+		t.Errorf("canceled %q; want %q", got, "org:a")
+	}
+}
+
+func TestScheduleAtPeriodEnd(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cc := &control.Client{Stripe: stripefake.Client(t), Logf: t.Logf}
+	h := NewHandler(cc, t.Logf)
+	h.helper = t.Helper
+	tc := &tier.Client{HTTPClient: fetchtest.NewTLSServer(t, h.ServeHTTP)}
+
+	m := []control.Feature{
+		{
+			FeaturePlan: mpf("feature:x@plan:a@0"),
+			Interval:    "@monthly",
+			Currency:    "usd",
+			Base:        100,
+		},
+		{
+			FeaturePlan: mpf("feature:x@plan:b@0"),
+			Interval:    "@monthly",
+			Currency:    "usd",
+			Base:        200,
+		},
+	}
+	if err := cc.Push(ctx, m, func(f control.Feature, err error) {
+		if err != nil {
+			t.Logf("error pushing %q: %v", f.FeaturePlan, err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	schedule := func(p tier.ScheduleParams) error {
+		t.Helper()
+		return tc.Schedule(ctx, "org:test", &p)
+	}
+	code := func(err error) string {
+		if e, ok := err.(*apitypes.Error); ok {
+			return e.Code
+		}
+		return ""
+	}
+
+	b := []tier.Phase{{Features: []string{"plan:b@0"}}}
+	if err := schedule(tier.ScheduleParams{Phases: b, AtPeriodEnd: true}); code(err) != "org_not_found" {
+		t.Errorf("scheduling at end of no period: err = %v; want org_not_found", err)
+	}
+	if err := schedule(tier.ScheduleParams{Phases: []tier.Phase{{Features: []string{"plan:a@0"}, TrialDays: -1}}}); code(err) != "invalid_request" {
+		t.Errorf("scheduling negative trial: err = %v; want invalid_request", err)
+	}
+	if err := schedule(tier.ScheduleParams{Phases: []tier.Phase{{Features: []string{"plan:a@0"}, TrialDays: 14}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := schedule(tier.ScheduleParams{Phases: b, AtPeriodEnd: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err := cc.LookupPhases(ctx, "org:test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 2 {
+		t.Fatalf("got %d phases; want 2", len(ps))
+	}
+	if !ps[0].Current || ps[0].TrialEnd.IsZero() {
+		t.Errorf("first phase = %+v; want current phase in trial", ps[0])
+	}
+	diff.Test(t, t.Errorf, ps[0].Features, mpfs("feature:x@plan:a@0"))
+	diff.Test(t, t.Errorf, ps[1].Features, mpfs("feature:x@plan:b@0"))
+	if !ps[1].Effective.After(time.Now()) {
+		t.Errorf("second phase effective %v; want after now", ps[1].Effective)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	stream := &notify.Stream{}
+	cc := &control.Client{Stripe: stripefake.Client(t), Logf: t.Logf, Notifier: stream}
+	h := NewHandler(cc, t.Logf)
+	h.helper = t.Helper
+	tc := &tier.Client{HTTPClient: fetchtest.NewTLSServer(t, h.ServeHTTP)}
+
+	err := tc.StreamEvents(ctx, "", false, func(apitypes.Event) error { return nil })
+	if e, ok := err.(*apitypes.Error); !ok || e.Code != "events_disabled" {
+		t.Fatalf("err = %v; want events_disabled", err)
+	}
+	h.Events = stream
+
+	m := []control.Feature{{
+		FeaturePlan: mpf("feature:x@plan:a@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Mode:        "graduated",
+		Aggregate:   "sum",
+		Tiers:       []control.Tier{{Upto: control.Inf}},
+	}}
+	if err := cc.Push(ctx, m, func(f control.Feature, err error) {
+		if err != nil {
+			t.Logf("error pushing %q: %v", f.FeaturePlan, err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, org := range []string{"org:a", "org:b"} {
+		if err := tc.Subscribe(ctx, org, "plan:a@0"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	record := func(e apitypes.Event) error {
+		s := e.Type + " " + e.Org
+		if e.Report != nil {
+			s += fmt.Sprintf(" %s %d", e.Report.Feature, e.Report.N)
+		}
+		got = append(got, s)
+		return nil
+	}
+	if err := tc.StreamEvents(ctx, "org:a", false, record); err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, []string{"subscribe org:a"})
+
+	got = nil
+	done := make(chan error, 1)
+	events := make(chan string)
+	go func() {
+		done <- tc.StreamEvents(ctx, "org:b", true, func(e apitypes.Event) error {
+			record(e)
+			events <- e.Type
+			return nil
+		})
+	}()
+	// The recent events are sent once following, so the report follows
+	// them.
+	<-events
+	if err := tc.Report(ctx, "org:b", "feature:x", 3); err != nil {
+		t.Fatal(err)
+	}
+	<-events
+	stream.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, []string{
+		"subscribe org:b",
+		"report org:b feature:x 3",
+	})
+}
+
+func TestOrgErrorDetails(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cc := &control.Client{Stripe: stripefake.Client(t), Logf: t.Logf}
+	h := NewHandler(cc, t.Logf)
+	h.helper = t.Helper
+	tc := &tier.Client{HTTPClient: fetchtest.NewTLSServer(t, h.ServeHTTP)}
+
+	m := []control.Feature{
+		{FeaturePlan: mpf("feature:x@plan:a@0"), Interval: "@monthly", Currency: "usd"},
+		{FeaturePlan: mpf("feature:y@plan:b@0"), Interval: "@monthly", Currency: "eur"},
+	}
+	if err := cc.Push(ctx, m, func(f control.Feature, err error) {
+		if err != nil {
+			t.Logf("error pushing %q: %v", f.FeaturePlan, err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	err := tc.Subscribe(ctx, "org:a", "feature:x@plan:a@0", "feature:nope@plan:a@0")
+	diff.Test(t, t.Errorf, err, &apitypes.Error{
+		Status:  400,
+		Code:    "feature_not_found",
+		Message: "feature not found",
+		Details: &apitypes.ErrorDetails{
+			Org:     "org:a",
+			Phase:   new(int),
+			Feature: "feature:nope@plan:a@0",
+		},
+	})
+
+	err = tc.Subscribe(ctx, "org:a", "feature:x@plan:a@0", "feature:y@plan:b@0")
+	diff.Test(t, t.Errorf, err, &apitypes.Error{
+		Status:  400,
+		Code:    "invalid_phase",
+		Message: "invalid phase",
+		Details: &apitypes.ErrorDetails{
+			Org:     "org:a",
+			Phase:   new(int),
+			Feature: "feature:y@plan:b@0",
+			Reason:  `billed in "eur", not "usd"`,
+		},
+	})
+}
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cc := &control.Client{Stripe: stripefake.Client(t), Logf: t.Logf}
+	h := NewHandler(cc, t.Logf)
+	h.helper = t.Helper
+	tc := &tier.Client{HTTPClient: fetchtest.NewTLSServer(t, h.ServeHTTP)}
+
+	var m []control.Feature
+	for _, fp := range []string{"feature:x@plan:a@0", "feature:x@plan:a@1"} {
+		m = append(m, control.Feature{
+			FeaturePlan: mpf(fp),
+			Interval:    "@monthly",
+			Currency:    "usd",
+		})
+	}
+	if err := cc.Push(ctx, m, func(f control.Feature, err error) {
+		if err != nil {
+			t.Logf("error pushing %q: %v", f.FeaturePlan, err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, org := range []string{"org:a", "org:b"} {
+		if err := cc.SubscribeTo(ctx, org, mpfs("feature:x@plan:a@0")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrate := func(from, to string) ([]string, error) {
+		t.Helper()
+		var got []string
+		err := tc.Migrate(ctx, apitypes.MigrateRequest{
+			From: mpp(from),
+			To:   mpp(to),
+		}, func(r apitypes.MigrateResult) error {
+			got = append(got, r.Org+r.Error)
+			return nil
+		})
+		sort.Strings(got)
+		return got, err
+	}
+	_, err := migrate("plan:a@0", "plan:a@9")
+	if e, ok := err.(*apitypes.Error); !ok || e.Code != "plan_not_found" {
+		t.Errorf("migrating to unknown plan: err = %v; want plan_not_found", err)
+	}
+	got, err := migrate("plan:a@0", "plan:a@1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, []string{"org:a", "org:b"})
+
+	ps, err := cc.LookupPhases(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) == 0 || !ps[len(ps)-1].Current {
+		t.Fatalf("phases = %+v; want current phase last", ps)
+	}
+	diff.Test(t, t.Errorf, ps[len(ps)-1].Features, mpfs("feature:x@plan:a@1"))
+}
+
+func TestListOrgs(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cc := &control.Client{Stripe: stripefake.Client(t), Logf: t.Logf}
+	h := NewHandler(cc, t.Logf)
+	h.helper = t.Helper
+	tc := &tier.Client{HTTPClient: fetchtest.NewTLSServer(t, h.ServeHTTP)}
+
+	for _, org := range []string{"org:a", "org:b", "org:c"} {
+		info := &control.OrgInfo{Metadata: map[string]string{"team": org[len("org:"):]}}
+		if err := cc.PutCustomer(ctx, org, info); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	p := tier.ListOrgsParams{Limit: 2}
+	for {
+		r, err := tc.ListOrgs(ctx, p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, o := range r.Orgs {
+			got = append(got, o.Org)
+		}
+		if r.Next == "" {
+			break
+		}
+		p.StartingAfter = r.Next
+	}
+	diff.Test(t, t.Errorf, got, []string{"org:c", "org:b", "org:a"})
+
+	r, err := tc.ListOrgs(ctx, tier.ListOrgsParams{
+		Metadata:     map[string]string{"team": "b"},
+		CreatedAfter: time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Orgs) != 1 || r.Orgs[0].Org != "org:b" || r.Orgs[0].StripeID == "" || r.Next != "" {
+		t.Errorf("orgs = %+v; want only org:b", r)
+	}
+
+	_, err = tc.ListOrgs(ctx, tier.ListOrgsParams{Limit: 500})
+	if e, ok := err.(*apitypes.Error); !ok || e.Code != "invalid_request" {
+		t.Errorf("listing 500 orgs: err = %v; want invalid_request", err)
+	}
+}
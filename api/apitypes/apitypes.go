@@ -1,6 +1,7 @@
 package apitypes
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -8,9 +9,29 @@ import (
 )
 
 type Error struct {
-	Status  int    `json:"status"`
-	Code    string `json:"code"` // (e.g. "invalid_request")
-	Message string `json:"message"`
+	Status  int           `json:"status"`
+	Code    string        `json:"code"` // (e.g. "invalid_request")
+	Message string        `json:"message"`
+	Details *ErrorDetails `json:"details,omitempty"`
+}
+
+// ErrorDetails describes an invalid feature, plan, or pattern in a request
+// that failed with the code "invalid_ref", or the org, phase, and feature
+// at fault in a request that failed with a code such as
+// "feature_not_found", "invalid_phase", or "too_many_items", or the
+// checkout URL of a request that failed with "checkout_required".
+type ErrorDetails struct {
+	Input      string `json:"input,omitempty"`      // the invalid input
+	Offset     int    `json:"offset,omitempty"`     // byte offset in Input at which parsing failed
+	Expected   string `json:"expected,omitempty"`   // what was expected at Offset
+	Suggestion string `json:"suggestion,omitempty"` // a valid ref Input may have meant
+
+	Org     string `json:"org,omitempty"`
+	Phase   *int   `json:"phase,omitempty"`   // index of the phase at fault
+	Feature string `json:"feature,omitempty"` // the feature or feature plan at fault
+	Reason  string `json:"reason,omitempty"`  // why it is at fault, if known
+
+	URL string `json:"url,omitempty"` // the page where the org completes checkout
 }
 
 func (e *Error) Error() string {
@@ -21,6 +42,14 @@ func (e *Error) Error() string {
 type Phase struct {
 	Effective time.Time
 	Features  []string
+
+	// Interval, if set, selects the variants of the features billed at
+	// that interval (e.g. "@yearly").
+	Interval string
+
+	// TrialDays, if set, begins the phase with a free trial of that many
+	// days, instead of any trial given by its plans.
+	TrialDays int
 }
 
 type PhaseResponse struct {
@@ -28,6 +57,25 @@ type PhaseResponse struct {
 	Features  []refs.FeaturePlan `json:"features,omitempty"`
 	Plans     []refs.Plan        `json:"plans,omitempty"`
 	Fragments []refs.FeaturePlan `json:"fragments,omitempty"`
+	AddOns    []refs.FeaturePlan `json:"addons,omitempty"`
+	Interval  string             `json:"interval,omitempty"`
+	TrialEnd  *time.Time         `json:"trialEnd,omitempty"`
+}
+
+// PhasesResponse is the timeline of the phases of Org: those past, current,
+// and scheduled, ordered by when they take effect.
+type PhasesResponse struct {
+	Org    string          `json:"org"`
+	Phases []TimelinePhase `json:"phases"`
+}
+
+// A TimelinePhase is a phase in the timeline of an org. Current reports
+// whether it is the current phase of the org, and Canceled whether it is
+// of a canceled subscription, which are only included if requested.
+type TimelinePhase struct {
+	PhaseResponse
+	Current  bool `json:"current,omitempty"`
+	Canceled bool `json:"canceled,omitempty"`
 }
 
 type OrgInfo struct {
@@ -36,12 +84,40 @@ type OrgInfo struct {
 	Description string            `json:"description"`
 	Phone       string            `json:"phone"`
 	Metadata    map[string]string `json:"metadata"`
+
+	// Credit and CreditCurrency report the credit balance of the org,
+	// which is negative if the org owes an amount to be added to its
+	// next invoice. They are ignored in requests.
+	Credit         int    `json:"credit,omitempty"`
+	CreditCurrency string `json:"credit_currency,omitempty"`
+
+	// Parent is the org the usage of the org is billed to, if any. It is
+	// ignored in requests; use a ParentRequest to change it.
+	Parent string `json:"parent,omitempty"`
+
+	// SubscriptionStatus, PeriodEnd, Delinquent, HasPaymentMethod,
+	// CancelAtPeriodEnd, and Paused report the billing state of the org,
+	// such as to ask it to update its payment details. They are ignored in
+	// requests.
+	SubscriptionStatus string     `json:"subscription_status,omitempty"` // e.g. "active" or "past_due"; empty if none
+	PeriodEnd          *time.Time `json:"period_end,omitempty"`          // end of the current billing period
+	Delinquent         bool       `json:"delinquent,omitempty"`          // the last payment failed
+	HasPaymentMethod   bool       `json:"has_payment_method,omitempty"`
+	CancelAtPeriodEnd  bool       `json:"cancel_at_period_end,omitempty"` // canceled at PeriodEnd
+	Paused             bool       `json:"paused,omitempty"`               // payment collection is paused
 }
 
 type ScheduleRequest struct {
 	Org    string
 	Info   *OrgInfo
 	Phases []Phase
+
+	// AtPeriodEnd, if true, makes the first phase effective at the end of
+	// the org's current billing period, instead of now.
+	//
+	// If the first phase is not effective now, the org is kept in its
+	// current phase until it is.
+	AtPeriodEnd bool
 }
 
 type ReportRequest struct {
@@ -52,6 +128,78 @@ type ReportRequest struct {
 	Clobber bool
 }
 
+// A MergeAnonymousRequest reports the usage of the anonymous subject
+// Anonymous, such as "anon:device-1234", to Org, which it signed up as.
+type MergeAnonymousRequest struct {
+	Anonymous string `json:"anonymous"`
+	Org       string `json:"org"`
+}
+
+// A ParentRequest makes Parent the parent of Org, so that the usage of Org
+// is billed to Parent. If Parent is empty, Org is detached from its parent.
+// A CheckoutRequest creates a Stripe Checkout session for Org, which
+// subscribes it to Features, plans or features as in Phase.Features, once
+// completed. If Features is empty, checkout only collects the payment
+// details of Org.
+type CheckoutRequest struct {
+	Org                   string   `json:"org"`
+	SuccessURL            string   `json:"success_url"`
+	CancelURL             string   `json:"cancel_url,omitempty"` // defaults to SuccessURL
+	Features              []string `json:"features,omitempty"`
+	TrialDays             int      `json:"trial_days,omitempty"`
+	RequireBillingAddress bool     `json:"require_billing_address,omitempty"`
+}
+
+type CheckoutResponse struct {
+	URL string `json:"url"` // the page where the org completes checkout
+}
+
+// A CancelRequest cancels the subscription of Org, immediately or, if
+// AtPeriodEnd is true, at the end of its current billing period.
+type CancelRequest struct {
+	Org         string `json:"org"`
+	AtPeriodEnd bool   `json:"at_period_end,omitempty"`
+}
+
+// A PauseRequest pauses or resumes collecting payments for the
+// subscription of Org.
+type PauseRequest struct {
+	Org string `json:"org"`
+}
+
+type ParentRequest struct {
+	Org    string `json:"org"`
+	Parent string `json:"parent"`
+}
+
+// A RolloutRequest enables Feature for Percent of the orgs on its plan,
+// from 1 to 100, or for all of them if Percent is zero.
+type RolloutRequest struct {
+	Feature refs.FeaturePlan `json:"feature"`
+	Percent int              `json:"percent"`
+}
+
+// A MigrateRequest moves the orgs subscribed to plan From to plan To, now,
+// or at the end of their current billing periods if AtRenewal is true. If
+// DryRun is true, the orgs that would be migrated are reported, but not
+// migrated.
+type MigrateRequest struct {
+	From      refs.Plan `json:"from"`
+	To        refs.Plan `json:"to"`
+	AtRenewal bool      `json:"at_renewal,omitempty"`
+	DryRun    bool      `json:"dry_run,omitempty"`
+}
+
+// A MigrateResult reports the migration of Org. Effective is when Org moves
+// to the new plan, if at renewal. Error, if not empty, is why Org was not
+// migrated. A result with an empty Org reports an error that stopped the
+// migration.
+type MigrateResult struct {
+	Org       string     `json:"org"`
+	Effective *time.Time `json:"effective,omitempty"`
+	Error     string     `json:"error,omitempty"`
+}
+
 type WhoIsResponse struct {
 	*OrgInfo
 	Org      string `json:"org"`
@@ -67,6 +215,15 @@ type Usage struct {
 	Feature refs.Name `json:"feature"`
 	Used    int       `json:"used"`
 	Limit   int       `json:"limit"`
+
+	// PeriodStart and PeriodEnd bound the billing period Used is counted
+	// in. They are not set for orgs without a subscription.
+	PeriodStart *time.Time `json:"period_start,omitempty"`
+	PeriodEnd   *time.Time `json:"period_end,omitempty"`
+
+	// ByOrg is the part of Used reported by each descendant of the org,
+	// if it has children.
+	ByOrg map[string]int `json:"by_org,omitempty"`
 }
 
 func UsageByFeature(a, b Usage) bool {
@@ -83,6 +240,35 @@ type PushResponse struct {
 	Results []PushResult `json:"results,omitempty"`
 }
 
+type Diagnostic struct {
+	Severity string           `json:"severity"` // "error" or "warning"
+	Code     string           `json:"code"`
+	Plan     refs.Plan        `json:"plan"`
+	Feature  refs.FeaturePlan `json:"feature"`
+	Message  string           `json:"message"`
+}
+
+// A Change describes how a feature in a pricing model differs from the
+// feature pushed to the billing provider, if any.
+type Change struct {
+	Op       string           `json:"op"` // "add", "change", or "remove"
+	Feature  refs.FeaturePlan `json:"feature"`
+	Interval string           `json:"interval,omitempty"` // set for interval variants
+	Fields   []string         `json:"fields,omitempty"`   // for "change", the fields that differ (e.g. "plan.currency")
+}
+
+// A DiffResponse lists how a model differs from the pushed model, with
+// changes ordered by plan, then by feature.
+type DiffResponse struct {
+	SchemaVersion int      `json:"schemaVersion"` // always SchemaVersion
+	Changes       []Change `json:"changes,omitempty"`
+}
+
+type ValidateResponse struct {
+	Valid       bool         `json:"valid"` // true if there are no errors
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+}
+
 type WhoAmIResponse struct {
 	ProviderID string    `json:"id"`
 	Email      string    `json:"email"`
@@ -91,3 +277,177 @@ type WhoAmIResponse struct {
 	Isolated   bool      `json:"isolated"`
 	URL        string    `json:"url"`
 }
+
+type ClockRequest struct {
+	ID      string    `json:"id,omitempty"`
+	Name    string    `json:"name,omitempty"`
+	Present time.Time `json:"present"`
+	Wait    bool      `json:"wait,omitempty"` // wait for the clock to finish advancing
+}
+
+type ClockAttachRequest struct {
+	ID   string   `json:"id"`
+	Org  string   `json:"org"`
+	Info *OrgInfo `json:"info,omitempty"`
+}
+
+type ClockResponse struct {
+	ID      string    `json:"id"`
+	Link    string    `json:"link"`
+	Present time.Time `json:"present"`
+	Status  string    `json:"status"`
+}
+
+type Invoice struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"` // "draft", "open", "paid", "uncollectible", or "void"
+	Currency  string    `json:"currency"`
+	Total     int       `json:"total"`
+	AmountDue int       `json:"amount_due"`
+	Created   time.Time `json:"created"`
+	URL       string    `json:"url,omitempty"`
+}
+
+// An OrgsResponse is a page of orgs, newest first. Next, if not empty, is
+// the cursor to request the next page with, as starting_after.
+type OrgsResponse struct {
+	Orgs []OrgItem `json:"orgs"`
+	Next string    `json:"next,omitempty"`
+}
+
+// An OrgItem is an org listed in an OrgsResponse.
+type OrgItem struct {
+	Org      string            `json:"org"`
+	StripeID string            `json:"stripe_id"`
+	Email    string            `json:"email,omitempty"`
+	Created  time.Time         `json:"created"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+type InvoicesResponse struct {
+	Invoices []Invoice `json:"invoices"`
+}
+
+// An InvoiceRequest selects an invoice of an org to finalize, void, or mark
+// uncollectible.
+type InvoiceRequest struct {
+	Org string `json:"org"`
+	ID  string `json:"id"`
+}
+
+// A RefundRequest refunds Amount of the charge ID of Org, or the rest of
+// the charge if Amount is zero. If ID is the ID of a paid invoice, its
+// charge is refunded.
+type RefundRequest struct {
+	Org    string `json:"org"`
+	ID     string `json:"id"`
+	Amount int    `json:"amount,omitempty"`
+	Reason string `json:"reason,omitempty"` // "duplicate", "fraudulent", or "requested_by_customer"
+}
+
+type Refund struct {
+	ID       string    `json:"id"`
+	Charge   string    `json:"charge"`
+	Amount   int       `json:"amount"`
+	Currency string    `json:"currency"`
+	Reason   string    `json:"reason,omitempty"`
+	Status   string    `json:"status"`
+	Created  time.Time `json:"created"`
+}
+
+// A CreditNoteRequest credits Amount of the open or paid invoice Invoice of
+// Org.
+type CreditNoteRequest struct {
+	Org     string `json:"org"`
+	Invoice string `json:"invoice"`
+	Amount  int    `json:"amount"`
+	Reason  string `json:"reason,omitempty"` // "duplicate", "fraudulent", "order_change", or "product_unsatisfactory"
+	Memo    string `json:"memo,omitempty"`
+}
+
+type CreditNote struct {
+	ID       string    `json:"id"`
+	Invoice  string    `json:"invoice"`
+	Amount   int       `json:"amount"`
+	Currency string    `json:"currency"`
+	Reason   string    `json:"reason,omitempty"`
+	Memo     string    `json:"memo,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// A CreditRequest grants Amount of credit to Org, or deducts it if Amount
+// is negative, in the smallest unit of Currency. If Currency is empty, the
+// currency of the org's balance is used, or "usd" if it has none.
+type CreditRequest struct {
+	Org         string `json:"org"`
+	Amount      int    `json:"amount"`
+	Currency    string `json:"currency,omitempty"`
+	Description string `json:"description,omitempty"` // shown on invoices
+}
+
+type CreditTransaction struct {
+	ID          string    `json:"id"`
+	Amount      int       `json:"amount"`
+	Currency    string    `json:"currency"`
+	Description string    `json:"description,omitempty"`
+	Credit      int       `json:"credit"` // the org's credit balance after the transaction
+	Created     time.Time `json:"created"`
+}
+
+// A RevenueResponse reports the recurring revenue of all subscriptions, in
+// the smallest unit of each currency.
+type RevenueResponse struct {
+	Currencies []CurrencyRevenue `json:"currencies"`
+	Plans      []PlanRevenue     `json:"plans"`
+}
+
+type CurrencyRevenue struct {
+	Currency    string `json:"currency"`
+	MRR         int    `json:"mrr"`
+	ARR         int    `json:"arr"`
+	Subscribers int    `json:"subscribers"`
+}
+
+type PlanRevenue struct {
+	Plan        refs.Plan `json:"plan"`
+	Currency    string    `json:"currency"`
+	MRR         int       `json:"mrr"`
+	Subscribers int       `json:"subscribers"`
+}
+
+type AuditEvent struct {
+	At     time.Time       `json:"at"`
+	Actor  string          `json:"actor,omitempty"`
+	Op     string          `json:"op"`
+	Org    string          `json:"org"`
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+	Error  string          `json:"error,omitempty"`
+}
+
+type AuditResponse struct {
+	Events []AuditEvent `json:"events"`
+}
+
+// An Event is a lifecycle event of an org, as posted by the notifiers of a
+// sidecar and streamed from /v1/events.
+type Event struct {
+	Type string    `json:"type"`
+	Org  string    `json:"org"`
+	At   time.Time `json:"at"`
+
+	// Features are the features of the first phase scheduled, for
+	// subscribe events.
+	Features []refs.FeaturePlan `json:"features,omitempty"`
+
+	Invoice *Invoice     `json:"invoice,omitempty"` // for payment_failed events
+	Usage   *Usage       `json:"usage,omitempty"`   // for limit_reached events
+	Report  *EventReport `json:"report,omitempty"`  // for report events
+}
+
+// An EventReport is the use of a feature reported, as in a report Event.
+type EventReport struct {
+	Feature refs.Name `json:"feature"`
+	N       int       `json:"n"`
+	Clobber bool      `json:"clobber,omitempty"`
+}
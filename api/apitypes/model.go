@@ -9,20 +9,41 @@ import (
 
 const Inf = 1<<63 - 1
 
+// SchemaVersion is the version of the JSON schema of pulled models and
+// diffs, as reported in their "schemaVersion" fields, for tools such as
+// CI policy checks that consume them.
+//
+// Within a version, fields are only ever added, never renamed, removed,
+// or given a different meaning, so consumers should ignore fields they do
+// not know. Any other change increments the version. Models read as input
+// may omit the version, but are rejected if it is newer than this one.
+const SchemaVersion = 1
+
+// A Tier is a pricing tier of a feature.
+//
+// Price is the price of each unit in the tier, in the smallest currency
+// unit, and may be fractional, such as 0.07 for $0.0007. It is kept as an
+// exact decimal, so sub-cent prices are not rounded through floating
+// point. In JSON it may be given as a number or a decimal string, such as
+// "0.07", and is always written as a decimal without an exponent.
 type Tier struct {
-	Upto  int     `json:"upto,omitempty"`
-	Price float64 `json:"price,omitempty"`
-	Base  int     `json:"base,omitempty"`
+	Upto  int            `json:"upto,omitempty"`
+	Price values.Decimal `json:"price,omitempty"`
+	Base  int            `json:"base,omitempty"`
 }
 
 func (t *Tier) MarshalJSON() ([]byte, error) {
+	var price json.Number
+	if !t.Price.IsZero() {
+		price = json.Number(t.Price.String())
+	}
 	return json.Marshal(struct {
-		Upto  int     `json:"upto,omitempty"`
-		Price float64 `json:"price,omitempty"`
-		Base  int     `json:"base,omitempty"`
+		Upto  int         `json:"upto,omitempty"`
+		Price json.Number `json:"price,omitempty"`
+		Base  int         `json:"base,omitempty"`
 	}{
 		Upto:  values.ZeroIf(t.Upto, Inf),
-		Price: t.Price,
+		Price: price,
 		Base:  t.Base,
 	})
 }
@@ -31,7 +52,7 @@ func (t *Tier) UnmarshalJSON(data []byte) error {
 	*t = Tier{}
 	var v struct {
 		Upto  *int
-		Price float64
+		Price values.Decimal // accepts numbers and decimal strings
 		Base  int
 	}
 	if err := json.Unmarshal(data, &v); err != nil {
@@ -54,15 +75,92 @@ type Feature struct {
 	Aggregate string `json:"aggregate,omitempty"`
 	Tiers     []Tier `json:"tiers,omitempty"`
 	PermLink  string `json:"permLink,omitempty"`
+
+	// Unit, Description, and DisplayOrder are for display only, such as
+	// on pricing pages generated from a pulled model. Unit labels what is
+	// counted, such as "requests", and features are meant to be shown in
+	// ascending DisplayOrder.
+	Unit         string `json:"unit,omitempty"`
+	Description  string `json:"description,omitempty"`
+	DisplayOrder int    `json:"displayOrder,omitempty"`
+
+	// PackageSize is the number of units in each package when Mode is
+	// "package", such as 1000 for a price per 1,000 API calls.
+	PackageSize int `json:"packageSize,omitempty"`
+
+	// Aliases are alternate names for the feature, accepted in its place
+	// when reporting and looking up usage.
+	Aliases []refs.Name `json:"aliases,omitempty"`
+
+	// Intervals holds the prices of the feature when billed at intervals
+	// other than the plan's, keyed by interval (e.g. "@yearly").
+	Intervals map[string]IntervalPrice `json:"intervals,omitempty"`
+
+	// OneTime, if true, bills Base once when a subscription to the plan
+	// begins, instead of each interval. It is used for charges such as
+	// setup fees.
+	OneTime bool `json:"oneTime,omitempty"`
+
+	// Flag, if true, makes the feature an entitlement included with the
+	// plan, such as SSO, with no price. Flags must have no base, tiers,
+	// or intervals, and are reported by limits with no limit.
+	Flag bool `json:"flag,omitempty"`
+
+	// Rollout, if not zero, is the percentage of orgs on the plan, from 1
+	// to 100, the feature is enabled for. Limits report a limit of zero to
+	// other orgs. Unlike the rest of the model, it may be changed after
+	// push, with a RolloutRequest.
+	Rollout int `json:"rollout,omitempty"`
+}
+
+// IntervalPrice is the price of a feature billed at an interval other
+// than its plan's. Mode and aggregate are the same as the feature's.
+type IntervalPrice struct {
+	Base  int    `json:"base,omitempty"`
+	Tiers []Tier `json:"tiers,omitempty"`
 }
 
 type Plan struct {
 	Title    string                `json:"title,omitempty"`
 	Interval string                `json:"interval,omitempty"`
 	Currency string                `json:"currency,omitempty"`
+	Tags     []string              `json:"tags,omitempty"`
 	Features map[refs.Name]Feature `json:"features,omitempty"`
+
+	// TrialDays is the length of the free trial given to orgs the first
+	// time they subscribe to the plan. Zero means no trial.
+	TrialDays int `json:"trialDays,omitempty"`
+
+	// Base, if set, names another plan in the model whose features the
+	// plan inherits, unless it defines features of the same name itself.
+	// Bases are expanded before push, so pulled models have none.
+	Base *refs.Plan `json:"base,omitempty"`
+
+	// Archived reports whether the plan has been archived. It is only
+	// set in models pulled with archived plans included, and is ignored
+	// on push.
+	Archived bool `json:"archived,omitempty"`
 }
 
+// An AddOn is a feature that is not in any plan, such as
+// "feature:support@1", which may be subscribed to alongside plans.
+type AddOn struct {
+	Feature
+	Interval string `json:"interval,omitempty"`
+	Currency string `json:"currency,omitempty"`
+}
+
+// A Model is a pricing model, as pushed from and pulled to JSON files.
 type Model struct {
-	Plans map[refs.Plan]Plan `json:"plans"`
+	// SchemaVersion is the SchemaVersion of the model. It is always set
+	// in pulled models.
+	SchemaVersion int `json:"schemaVersion,omitempty"`
+
+	Plans  map[refs.Plan]Plan         `json:"plans"`
+	AddOns map[refs.FeaturePlan]AddOn `json:"addons,omitempty"`
+
+	// Include lists other model files to merge into this one, relative
+	// to this file. Includes are only supported when reading models from
+	// files, and a plan or add-on may be defined in only one file.
+	Include []string `json:"include,omitempty"`
 }
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"tier.run/api/apitypes"
+	"tier.run/control"
+	"tier.run/fetch"
+	"tier.run/fetch/fetchtest"
+	"tier.run/stripe/stripefake"
+)
+
+func TestAudit(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sc := stripefake.Client(t)
+	tc := &control.Client{Stripe: sc, Logf: t.Logf}
+	h := NewHandler(tc, t.Logf)
+	h.helper = t.Helper
+	c := fetchtest.NewTLSServer(t, h.ServeHTTP)
+
+	_, err := fetch.OK[apitypes.AuditResponse, *apitypes.Error](ctx, c, "GET", "/v1/audit", nil)
+	if e, ok := err.(*apitypes.Error); !ok || e.Status != 404 {
+		t.Fatalf("err = %v; want 404 with audit disabled", err)
+	}
+
+	tc.Audit = &control.MemoryAuditLog{}
+	req, err := http.NewRequestWithContext(ctx, "POST", "/v1/subscribe", strings.NewReader(`{"org":"org:test","info":{"email":"a@example.com"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Tier-Actor", "ops@example.com")
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 200 {
+		t.Fatalf("subscribe: status = %d; want 200", res.StatusCode)
+	}
+
+	got, err := fetch.OK[apitypes.AuditResponse, *apitypes.Error](ctx, c, "GET", "/v1/audit?org=org:test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Events) != 1 {
+		t.Fatalf("got %d events; want 1: %+v", len(got.Events), got.Events)
+	}
+	e := got.Events[0]
+	if e.Op != control.AuditSchedule || e.Org != "org:test" || e.Actor != "ops@example.com" {
+		t.Errorf("event = %+v; want schedule of org:test by ops@example.com", e)
+	}
+	if !strings.Contains(string(e.After), "a@example.com") {
+		t.Errorf("After = %s; want info with email", e.After)
+	}
+}
@@ -0,0 +1,51 @@
+package api
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"tier.run/api/apitypes"
+	"tier.run/client/tier"
+	"tier.run/control"
+	"tier.run/fetch/fetchtest"
+	"tier.run/stripe/stripefake"
+)
+
+func TestCheckout(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cc := &control.Client{Stripe: stripefake.Client(t), Logf: t.Logf}
+	h := NewHandler(cc, t.Logf)
+	h.helper = t.Helper
+	tc := &tier.Client{HTTPClient: fetchtest.NewTLSServer(t, h.ServeHTTP)}
+
+	m := []control.Feature{{
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Base:        1000,
+	}}
+	if err := cc.Push(ctx, m, func(f control.Feature, err error) {
+		if err != nil {
+			t.Fatalf("error pushing %q: %v", f.FeaturePlan, err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := tc.Checkout(ctx, "org:a", "", nil)
+	if e, ok := err.(*apitypes.Error); !ok || e.Code != "invalid_request" {
+		t.Errorf("checkout without success URL: err = %v; want invalid_request", err)
+	}
+	r, err := tc.Checkout(ctx, "org:a", "https://example.com/ok", &tier.CheckoutParams{
+		Features: []string{"plan:pro@0"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(r.URL, "https://") {
+		t.Errorf("URL = %q; want a URL", r.URL)
+	}
+}
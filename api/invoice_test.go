@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"tier.run/api/apitypes"
+	"tier.run/client/tier"
+	"tier.run/control"
+	"tier.run/fetch/fetchtest"
+	"tier.run/stripe"
+	"tier.run/stripe/stripefake"
+)
+
+func TestInvoices(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sc := stripefake.Client(t)
+	cc := &control.Client{Stripe: sc, Logf: t.Logf}
+	h := NewHandler(cc, t.Logf)
+	h.helper = t.Helper
+	tc := &tier.Client{HTTPClient: fetchtest.NewTLSServer(t, h.ServeHTTP)}
+
+	for _, org := range []string{"org:a", "org:b"} {
+		if err := cc.PutCustomer(ctx, org, &control.OrgInfo{Email: "a@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cid, err := cc.WhoIs(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f stripe.Form
+	f.Set("customer", cid)
+	var draft struct{ stripe.ID }
+	if err := sc.Do(ctx, "POST", "/v1/invoices", f, &draft); err != nil {
+		t.Fatal(err)
+	}
+	id := draft.ProviderID()
+
+	code := func(err error) string {
+		if e, ok := err.(*apitypes.Error); ok {
+			return e.Code
+		}
+		return ""
+	}
+	if _, err := tc.FinalizeInvoice(ctx, "org:b", id); code(err) != "invoice_not_found" {
+		t.Errorf("finalizing invoice of other org: err = %v; want invoice_not_found", err)
+	}
+	if _, err := tc.VoidInvoice(ctx, "org:a", id); code(err) != "invalid_invoice_status" {
+		t.Errorf("voiding draft: err = %v; want invalid_invoice_status", err)
+	}
+	in, err := tc.FinalizeInvoice(ctx, "org:a", id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.ID != id || in.Status != "open" {
+		t.Errorf("finalized = %+v; want open invoice %s", in, id)
+	}
+	if _, err := tc.MarkInvoiceUncollectible(ctx, "org:a", id); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := tc.LookupInvoices(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Invoices) != 1 || got.Invoices[0].Status != "uncollectible" {
+		t.Errorf("invoices = %+v; want one uncollectible invoice", got.Invoices)
+	}
+
+	_, err = tc.Refund(ctx, apitypes.RefundRequest{Org: "org:a", ID: id})
+	if code(err) != "invalid_invoice_status" {
+		t.Errorf("refunding unpaid invoice: err = %v; want invalid_invoice_status", err)
+	}
+	_, err = tc.CreateCreditNote(ctx, apitypes.CreditNoteRequest{Org: "org:a", Invoice: id, Amount: -1})
+	if code(err) != "invalid_request" {
+		t.Errorf("crediting negative amount: err = %v; want invalid_request", err)
+	}
+}
+
+func TestCredit(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cc := &control.Client{Stripe: stripefake.Client(t), Logf: t.Logf}
+	h := NewHandler(cc, t.Logf)
+	h.helper = t.Helper
+	tc := &tier.Client{HTTPClient: fetchtest.NewTLSServer(t, h.ServeHTTP)}
+
+	if err := cc.PutCustomer(ctx, "org:a", &control.OrgInfo{Email: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	ct, err := tc.AdjustCredit(ctx, apitypes.CreditRequest{Org: "org:a", Amount: 5000, Description: "Promotional credit"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct.Credit != 5000 {
+		t.Errorf("credit = %d; want 5000", ct.Credit)
+	}
+	_, err = tc.AdjustCredit(ctx, apitypes.CreditRequest{Org: "org:a", Amount: -6000})
+	if e, ok := err.(*apitypes.Error); !ok || e.Code != "invalid_request" {
+		t.Errorf("deducting too much: err = %v; want invalid_request", err)
+	}
+
+	got, err := tc.LookupOrg(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Credit != 5000 || got.CreditCurrency != "usd" {
+		t.Errorf("org = %+v; want 5000 usd of credit", got.OrgInfo)
+	}
+}
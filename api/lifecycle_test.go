@@ -0,0 +1,114 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"tier.run/api/apitypes"
+	"tier.run/client/tier"
+	"tier.run/control"
+	"tier.run/fetch/fetchtest"
+	"tier.run/refs"
+	"tier.run/stripe/stripefake"
+)
+
+func TestCancelAndPause(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	cc := &control.Client{Stripe: stripefake.Client(t), Logf: t.Logf}
+	h := NewHandler(cc, t.Logf)
+	h.helper = t.Helper
+	tc := &tier.Client{HTTPClient: fetchtest.NewTLSServer(t, h.ServeHTTP)}
+
+	m := []control.Feature{{
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}}
+	if err := cc.Push(ctx, m, func(f control.Feature, err error) {
+		if err != nil {
+			t.Fatalf("error pushing %q: %v", f.FeaturePlan, err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.PutCustomer(ctx, "org:a", &control.OrgInfo{Email: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	code := func(err error) string {
+		if e, ok := err.(*apitypes.Error); ok {
+			return e.Code
+		}
+		return ""
+	}
+	if err := tc.Pause(ctx, "org:a"); code(err) != "no_subscription" {
+		t.Errorf("pausing unsubscribed org: err = %v; want no_subscription", err)
+	}
+	if err := tc.Cancel(ctx, "org:missing", false); code(err) != "org_not_found" {
+		t.Errorf("canceling unknown org: err = %v; want org_not_found", err)
+	}
+	if err := cc.SubscribeTo(ctx, "org:a", []refs.FeaturePlan{mpf("feature:x@plan:pro@0")}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.Pause(ctx, "org:a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Cancel(ctx, "org:a", true); err != nil {
+		t.Fatal(err)
+	}
+	got, err := tc.LookupOrg(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Paused || !got.CancelAtPeriodEnd {
+		t.Errorf("org = %+v; want paused and canceled at period end", got.OrgInfo)
+	}
+
+	if err := tc.Resume(ctx, "org:a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Cancel(ctx, "org:a", false); err != nil {
+		t.Fatal(err)
+	}
+	got, err = tc.LookupOrg(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.SubscriptionStatus != "" {
+		t.Errorf("SubscriptionStatus = %q; want none", got.SubscriptionStatus)
+	}
+
+	// The phase of org:a was released from its schedule before it was
+	// canceled, so it is in the past, but not canceled.
+	ps, err := tc.LookupPhases(ctx, "org:a", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps.Phases) != 1 || ps.Phases[0].Current || ps.Phases[0].Canceled {
+		t.Errorf("phases = %+v; want one past", ps.Phases)
+	}
+
+	if err := cc.SubscribeTo(ctx, "org:b", []refs.FeaturePlan{mpf("feature:x@plan:pro@0")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Cancel(ctx, "org:b", false); err != nil {
+		t.Fatal(err)
+	}
+	ps, err = tc.LookupPhases(ctx, "org:b", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps.Phases) != 0 {
+		t.Errorf("phases = %+v; want none", ps.Phases)
+	}
+	ps, err = tc.LookupPhases(ctx, "org:b", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps.Phases) != 1 || !ps.Phases[0].Canceled {
+		t.Errorf("all phases = %+v; want one canceled", ps.Phases)
+	}
+}
@@ -0,0 +1,152 @@
+package materialize
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tailscale/hujson"
+	"tier.run/control"
+	"tier.run/refs"
+	"tier.run/values"
+)
+
+// A Problem is a problem found in a model by Check, with its position in
+// the file it was found in.
+type Problem struct {
+	control.Diagnostic
+	File         string
+	Line, Column int // from 1; zero if the position is unknown
+}
+
+func (p Problem) String() string {
+	if p.Line == 0 {
+		return fmt.Sprintf("%s: %s", p.File, p.Diagnostic)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", p.File, p.Line, p.Column, p.Diagnostic)
+}
+
+// Check loads the model in the file name in fsys, and the files it
+// includes, as FromPricingHuJSONFile does, but instead of stopping at the
+// first problem, it reports every problem found in the model, ordered by
+// file and position. Once the model is otherwise valid, its features are
+// checked with control.Validate too. Check makes no calls to Stripe.
+//
+// It returns an error only if the model cannot be loaded, such as if a
+// file is missing or is not valid HuJSON.
+func Check(fsys fs.FS, name string) ([]Problem, error) {
+	name = path.Clean(name)
+	m, l, err := loadModel(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var ps []Problem
+	for _, err := range check(m) {
+		file, ptrs := l.locate(name, err.Error())
+		ps = append(ps, l.problem(control.Diagnostic{
+			Severity: control.SeverityError,
+			Code:     "invalid_model",
+			Message:  err.Error(),
+		}, file, ptrs...))
+	}
+	if len(ps) == 0 {
+		for _, d := range control.Validate(fromModel(m)) {
+			file, ptrs := l.locateDiagnostic(name, d)
+			ps = append(ps, l.problem(d, file, ptrs...))
+		}
+	}
+	sort.SliceStable(ps, func(i, j int) bool {
+		a, b := ps[i], ps[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		if a.Column != b.Column {
+			return a.Column < b.Column
+		}
+		return a.Message < b.Message
+	})
+	return ps, nil
+}
+
+// modelPath matches the path at the start of the messages of the errors
+// reported by check.
+var modelPath = regexp.MustCompile(`^(?:plans\["([^"]*)"\](?:\.features\["([^"]*)"\])?|addons\["([^"]*)"\])`)
+
+// locate returns the file defining the plan or add-on msg, the message of
+// an error reported by check, begins with the path of, and JSON pointers
+// into the file to try, most specific first. Problems not in a plan or
+// add-on are in root.
+func (l *loader) locate(root, msg string) (file string, ptrs []string) {
+	sm := modelPath.FindStringSubmatch(msg)
+	switch {
+	case sm == nil:
+		return root, nil
+	case sm[3] != "":
+		fp, _ := refs.ParseFeaturePlan(sm[3])
+		return values.Coalesce(l.addons[fp], root), []string{"/addons/" + escapePointer(sm[3])}
+	default:
+		plan, _ := refs.ParsePlan(sm[1])
+		ptr := "/plans/" + escapePointer(sm[1])
+		if sm[2] != "" {
+			ptrs = append(ptrs, ptr+"/features/"+escapePointer(sm[2]))
+		}
+		return values.Coalesce(l.plans[plan], root), append(ptrs, ptr)
+	}
+}
+
+// locateDiagnostic is like locate, but for the plan or feature d was
+// found in. Features inherited from a base plan are located at the plan
+// inheriting them.
+func (l *loader) locateDiagnostic(root string, d control.Diagnostic) (file string, ptrs []string) {
+	plan := d.Plan
+	if !d.Feature.IsZero() {
+		plan = d.Feature.Plan()
+		if plan.IsZero() {
+			return values.Coalesce(l.addons[d.Feature], root), []string{"/addons/" + escapePointer(d.Feature.String())}
+		}
+		ptrs = append(ptrs, "/plans/"+escapePointer(plan.String())+"/features/"+escapePointer(d.Feature.Name().String()))
+	}
+	if plan.IsZero() {
+		return root, nil
+	}
+	return values.Coalesce(l.plans[plan], root), append(ptrs, "/plans/"+escapePointer(plan.String()))
+}
+
+// problem returns d as a Problem in file, at the value of the first of
+// ptrs found in it.
+func (l *loader) problem(d control.Diagnostic, file string, ptrs ...string) Problem {
+	p := Problem{Diagnostic: d, File: file}
+	data := l.files[file]
+	v, err := hujson.Parse(data)
+	if err != nil {
+		return p // already loaded, so unreachable
+	}
+	for _, ptr := range ptrs {
+		if fv := v.Find(ptr); fv != nil {
+			p.Line, p.Column = lineColumn(data, fv.StartOffset)
+			break
+		}
+	}
+	return p
+}
+
+// lineColumn returns the line and column, from 1, of offset n in data.
+func lineColumn(data []byte, n int) (line, column int) {
+	line = 1 + bytes.Count(data[:n], []byte("\n"))
+	column = 1 + n - (bytes.LastIndexByte(data[:n], '\n') + 1)
+	return line, column
+}
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+func escapePointer(s string) string {
+	return pointerEscaper.Replace(s)
+}
@@ -0,0 +1,70 @@
+package materialize
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"kr.dev/diff"
+)
+
+func TestCheck(t *testing.T) {
+	file := func(s string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(s)}
+	}
+	check := func(fsys fstest.MapFS, want []string) {
+		t.Helper()
+		ps, err := Check(fsys, "pricing.json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, p := range ps {
+			got = append(got, p.String())
+		}
+		diff.Test(t, t.Errorf, got, want)
+	}
+
+	// Problems with the shape of the model are all reported, in the files
+	// they were found in.
+	check(fstest.MapFS{
+		"pricing.json": file(`{
+	"plans": {
+		"plan:free@1": {
+			"features": {
+				"feature:seats": {"tiers": [{"upto": 0}]}
+			}
+		}
+	},
+	"include": ["pro.json"]
+}`),
+		"pro.json": file(`{
+	"plans": {
+		"plan:pro@1": {"trialDays": -1, "features": {"feature:sso": {"flag": true}}}
+	}
+}`),
+	}, []string{
+		`pricing.json:5:22: error: plans["plan:free@1"].features["feature:seats"].tiers[0]: upto must be greater than zero (invalid_model)`,
+		`pro.json:3:17: error: plans["plan:pro@1"].trialDays: must not be negative (invalid_model)`,
+	})
+
+	// Once the shape is valid, the features are validated too.
+	check(fstest.MapFS{
+		"pricing.json": file(`{
+	"plans": {
+		"plan:free@1": {
+			"features": {
+				"feature:seats": {"mode": "volume"},
+				"feature:calls": {"tiers": [{"upto": 10}, {"upto": 5}]}
+			}
+		}
+	}
+}`),
+	}, []string{
+		`pricing.json:5:22: warning: feature:seats@plan:free@1: mode "volume" is ignored for features without tiers (mode_ignored)`,
+		`pricing.json:6:22: error: feature:calls@plan:free@1: tiers[1]: upto 5 must be greater than upto 10 of the previous tier (tiers_out_of_order)`,
+	})
+
+	if _, err := Check(fstest.MapFS{"pricing.json": file(`{"plans": {"plan:free@1": {"features": {"feature:x": {"base": "1"}}}}}`)}, "pricing.json"); err == nil {
+		t.Error("checking model of wrong type: err = nil; want error")
+	}
+}
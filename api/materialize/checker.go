@@ -5,36 +5,58 @@ import (
 
 	"tailscale.com/util/multierr"
 	"tier.run/api/apitypes"
+	"tier.run/refs"
+	"tier.run/values"
 )
 
 func validate(m apitypes.Model) error {
+	return multierr.New(check(m)...)
+}
+
+// check returns the problems with m. Each is reported as an error whose
+// message begins with the path in the model of the plan, feature, or add-on
+// it was found in, such as plans["plan:pro@1"].features["feature:x"];
+// see locate.
+func check(m apitypes.Model) errors {
 	var e errors
 	for plan, p := range m.Plans {
-		if len(p.Features) == 0 {
+		if len(p.Features) == 0 && p.Base == nil {
 			e.reportf("plans[%q]: plans must have at least one feature", plan)
 		}
-		for feature, f := range p.Features {
-			if f.Base > 0 && len(f.Tiers) > 0 {
-				e.reportf("plans[%q].features[%q]: base must be zero with tiers", plan, feature)
-			}
-			if f.Base < 0 {
-				e.reportf("plans[%q].features[%q]: base must be positive", plan, feature)
+		if p.Base != nil {
+			e.checkBase(m, plan)
+		}
+		for _, tag := range p.Tags {
+			if !refs.IsValidTag(tag) {
+				e.reportf("plans[%q].tags: tag %q must match [a-z0-9-]+", plan, tag)
 			}
-
-			for i, t := range f.Tiers {
-				if t.Upto < 1 {
-					e.reportf("plans[%q].features[%q].tiers[%d]: upto must be greater than zero", plan, feature, i)
-				}
-				if t.Price < 0 {
-					e.reportf("plans[%q].features[%q].tiers[%d]: price must be positive", plan, feature, i)
-				}
-				if t.Base < 0 {
-					e.reportf("plans[%q].features[%q].tiers[%d]: base must be positive", plan, feature, i)
+		}
+		if p.TrialDays < 0 {
+			e.reportf("plans[%q].trialDays: must not be negative", plan)
+		}
+		aliased := map[refs.Name]refs.Name{}
+		for feature, f := range p.Features {
+			for _, a := range f.Aliases {
+				if _, ok := p.Features[a]; ok {
+					e.reportf("plans[%q].features[%q].aliases: alias %q is also a feature", plan, feature, a)
+				} else if other, ok := aliased[a]; ok && other != feature {
+					e.reportf("plans[%q].features[%q].aliases: alias %q is also an alias of %q", plan, feature, a, other)
 				}
+				aliased[a] = feature
 			}
+			e.checkFeature(fmt.Sprintf("plans[%q].features[%q]", plan, feature), f, p.Interval)
 		}
 	}
-	return multierr.New(e...)
+	for fp, a := range m.AddOns {
+		if !fp.Plan().IsZero() {
+			e.reportf("addons[%q]: add-ons must not be in a plan", fp)
+		}
+		if a.Flag {
+			e.reportf("addons[%q]: add-ons must not be flags", fp)
+		}
+		e.checkFeature(fmt.Sprintf("addons[%q]", fp), a.Feature, a.Interval)
+	}
+	return e
 }
 
 type errors []error
@@ -48,3 +70,87 @@ func (e *errors) report(err error) {
 func (e *errors) reportf(format string, args ...any) {
 	e.report(fmt.Errorf(format, args...))
 }
+
+// checkBase reports problems with the base of plan in m, which must be a
+// plan in m that does not, directly or through its own base, have plan as
+// its base.
+func (e *errors) checkBase(m apitypes.Model, plan refs.Plan) {
+	seen := map[refs.Plan]bool{plan: true}
+	for p := *m.Plans[plan].Base; ; {
+		b, ok := m.Plans[p]
+		if !ok {
+			e.reportf("plans[%q].base: plan %q not found", plan, p)
+			return
+		}
+		if seen[p] {
+			e.reportf("plans[%q].base: plan %q inherits from itself", plan, plan)
+			return
+		}
+		seen[p] = true
+		if b.Base == nil {
+			return
+		}
+		p = *b.Base
+	}
+}
+
+// checkFeature reports problems with the feature f at path, billed at
+// interval unless overridden by its intervals.
+func (e *errors) checkFeature(path string, f apitypes.Feature, interval string) {
+	if f.Base > 0 && len(f.Tiers) > 0 {
+		e.reportf("%s: base must be zero with tiers", path)
+	}
+	if f.Base < 0 {
+		e.reportf("%s: base must be positive", path)
+	}
+	if f.OneTime && len(f.Tiers) > 0 {
+		e.reportf("%s: one-time features must not have tiers", path)
+	}
+	if f.OneTime && len(f.Intervals) > 0 {
+		e.reportf("%s: one-time features must not have intervals", path)
+	}
+	if f.Flag && (f.Base != 0 || len(f.Tiers) > 0 || len(f.Intervals) > 0 || f.OneTime) {
+		e.reportf("%s: flags must not have a price", path)
+	}
+	if f.Rollout < 0 || f.Rollout > 100 {
+		e.reportf("%s: rollout must be from 0 to 100 percent", path)
+	}
+	if f.Mode == "package" {
+		if f.PackageSize < 1 {
+			e.reportf("%s: packageSize must be greater than zero in package mode", path)
+		}
+		if len(f.Tiers) != 1 {
+			e.reportf("%s: package mode requires exactly one tier", path)
+		}
+	} else if f.PackageSize != 0 {
+		e.reportf("%s: packageSize requires package mode", path)
+	}
+
+	for i, t := range f.Tiers {
+		if t.Upto < 1 {
+			e.reportf("%s.tiers[%d]: upto must be greater than zero", path, i)
+		}
+		if t.Price.Sign() < 0 {
+			e.reportf("%s.tiers[%d]: price must be positive", path, i)
+		}
+		if t.Base < 0 {
+			e.reportf("%s.tiers[%d]: base must be positive", path, i)
+		}
+	}
+
+	interval = values.Coalesce(interval, "@monthly")
+	for iv, ip := range f.Intervals {
+		if iv == interval {
+			e.reportf("%s.intervals[%q]: interval must differ from the default interval %q", path, iv, interval)
+		}
+		if (len(f.Tiers) > 0) != (len(ip.Tiers) > 0) {
+			e.reportf("%s.intervals[%q]: tiers must be set if and only if the feature has tiers", path, iv)
+		}
+		if ip.Base > 0 && len(ip.Tiers) > 0 {
+			e.reportf("%s.intervals[%q]: base must be zero with tiers", path, iv)
+		}
+		if ip.Base < 0 {
+			e.reportf("%s.intervals[%q]: base must be positive", path, iv)
+		}
+	}
+}
@@ -41,3 +41,103 @@ func TestValidatePlanID(t *testing.T) {
 		})
 	}
 }
+
+func TestValidateAliases(t *testing.T) {
+	mpn := refs.MustParseName
+	check := func(features map[refs.Name]apitypes.Feature, valid bool) {
+		t.Helper()
+		err := validate(apitypes.Model{
+			Plans: map[refs.Plan]apitypes.Plan{
+				refs.MustParsePlan("plan:a@0"): {Features: features},
+			},
+		})
+		if valid && err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if !valid && err == nil {
+			t.Errorf("expected error")
+		}
+	}
+
+	check(map[refs.Name]apitypes.Feature{
+		mpn("feature:seats"): {Aliases: []refs.Name{mpn("feature:users")}},
+		mpn("feature:x"):     {},
+	}, true)
+	check(map[refs.Name]apitypes.Feature{
+		mpn("feature:seats"): {Aliases: []refs.Name{mpn("feature:x")}},
+		mpn("feature:x"):     {},
+	}, false)
+	check(map[refs.Name]apitypes.Feature{
+		mpn("feature:seats"): {Aliases: []refs.Name{mpn("feature:users")}},
+		mpn("feature:x"):     {Aliases: []refs.Name{mpn("feature:users")}},
+	}, false)
+}
+
+func TestValidateTags(t *testing.T) {
+	for _, tc := range []struct {
+		tags  []string
+		valid bool
+	}{
+		{nil, true},
+		{[]string{"beta", "eu-west-1"}, true},
+		{[]string{"Beta"}, false},
+		{[]string{""}, false},
+		{[]string{"a,b"}, false},
+	} {
+		err := validate(apitypes.Model{
+			Plans: map[refs.Plan]apitypes.Plan{
+				refs.MustParsePlan("plan:a@0"): {
+					Tags: tc.tags,
+					Features: map[refs.Name]apitypes.Feature{
+						refs.MustParseName("feature:x"): {},
+					},
+				},
+			},
+		})
+		if tc.valid && err != nil {
+			t.Errorf("%q: unexpected error: %v", tc.tags, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%q: expected error", tc.tags)
+		}
+	}
+}
+
+func TestValidateBase(t *testing.T) {
+	mpp := refs.MustParsePlan
+	plan := func(base string) apitypes.Plan {
+		p := apitypes.Plan{}
+		if base != "" {
+			b := mpp(base)
+			p.Base = &b
+		} else {
+			p.Features = map[refs.Name]apitypes.Feature{
+				refs.MustParseName("feature:x"): {},
+			}
+		}
+		return p
+	}
+	check := func(plans map[refs.Plan]apitypes.Plan, valid bool) {
+		t.Helper()
+		err := validate(apitypes.Model{Plans: plans})
+		if valid && err != nil {
+			t.Errorf("unexpected error: %v", err)
+		}
+		if !valid && err == nil {
+			t.Errorf("expected error")
+		}
+	}
+
+	check(map[refs.Plan]apitypes.Plan{
+		mpp("plan:free@0"):       plan(""),
+		mpp("plan:pro@0"):        plan("plan:free@0"),
+		mpp("plan:enterprise@0"): plan("plan:pro@0"),
+	}, true)
+	check(map[refs.Plan]apitypes.Plan{
+		mpp("plan:pro@0"): plan("plan:free@0"),
+	}, false)
+	check(map[refs.Plan]apitypes.Plan{
+		mpp("plan:free@0"): plan("plan:pro@0"),
+		mpp("plan:pro@0"):  plan("plan:free@0"),
+	}, false)
+}
@@ -0,0 +1,104 @@
+package materialize
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+
+	"tier.run/api/apitypes"
+	"tier.run/control"
+	"tier.run/refs"
+)
+
+// FromPricingHuJSONFile is like FromPricingHuJSON, but reads the model in
+// the file name in fsys, merged with the files it includes. See LoadModel.
+func FromPricingHuJSONFile(fsys fs.FS, name string) ([]control.Feature, error) {
+	m, err := LoadModel(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	if err := validate(m); err != nil {
+		return nil, err
+	}
+	return fromModel(m), nil
+}
+
+// LoadModel reads the model in the file name in fsys, and merges into it
+// the models in the files it includes, and in the files they include, so
+// that large catalogs may be split across files by plan or product area.
+// Include paths are relative to the directory of the including file.
+//
+// It reports an error if a plan or add-on is defined in more than one
+// file, or if a file is included more than once. The returned model has
+// not been validated, and its Include field is nil.
+func LoadModel(fsys fs.FS, name string) (apitypes.Model, error) {
+	m, _, err := loadModel(fsys, name)
+	return m, err
+}
+
+// loadModel is LoadModel, but also returns the loader, which records the
+// files read and what they define.
+func loadModel(fsys fs.FS, name string) (apitypes.Model, *loader, error) {
+	l := &loader{
+		fsys:   fsys,
+		seen:   map[string]bool{},
+		files:  map[string][]byte{},
+		plans:  map[refs.Plan]string{},
+		addons: map[refs.FeaturePlan]string{},
+	}
+	m := apitypes.Model{Plans: map[refs.Plan]apitypes.Plan{}}
+	if err := l.load(&m, path.Clean(name)); err != nil {
+		return apitypes.Model{}, nil, err
+	}
+	return m, l, nil
+}
+
+type loader struct {
+	fsys  fs.FS
+	seen  map[string]bool
+	files map[string][]byte // the contents of each file read
+
+	// the files defining each plan and add-on loaded so far
+	plans  map[refs.Plan]string
+	addons map[refs.FeaturePlan]string
+}
+
+func (l *loader) load(m *apitypes.Model, name string) error {
+	if l.seen[name] {
+		return fmt.Errorf("%s: included more than once", name)
+	}
+	l.seen[name] = true
+
+	data, err := fs.ReadFile(l.fsys, name)
+	if err != nil {
+		return err
+	}
+	l.files[name] = data
+	fm, err := decodeModel(data)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	for plan, p := range fm.Plans {
+		if other, ok := l.plans[plan]; ok {
+			return fmt.Errorf("%s: plan %q is also defined in %s", name, plan, other)
+		}
+		l.plans[plan] = name
+		m.Plans[plan] = p
+	}
+	for fp, a := range fm.AddOns {
+		if other, ok := l.addons[fp]; ok {
+			return fmt.Errorf("%s: add-on %q is also defined in %s", name, fp, other)
+		}
+		l.addons[fp] = name
+		if m.AddOns == nil {
+			m.AddOns = map[refs.FeaturePlan]apitypes.AddOn{}
+		}
+		m.AddOns[fp] = a
+	}
+	for _, inc := range fm.Include {
+		if err := l.load(m, path.Join(path.Dir(name), inc)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package materialize
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"golang.org/x/exp/slices"
+	"kr.dev/diff"
+	"tier.run/refs"
+)
+
+func TestFromPricingHuJSONFile(t *testing.T) {
+	file := func(s string) *fstest.MapFile {
+		return &fstest.MapFile{Data: []byte(s)}
+	}
+	fsys := fstest.MapFS{
+		"pricing.json": file(`{
+			// shared by all plans
+			"plans": {
+				"plan:free@1": {"features": {"feature:seats": {}}}
+			},
+			"include": ["plans/pro.json", "addons.json"]
+		}`),
+		"plans/pro.json": file(`{
+			"plans": {
+				"plan:pro@1": {"base": "plan:free@1", "features": {"feature:sso": {"flag": true}}}
+			}
+		}`),
+		"addons.json": file(`{
+			"plans": {},
+			"addons": {"feature:support@1": {"base": 100}}
+		}`),
+	}
+
+	got, err := FromPricingHuJSONFile(fsys, "pricing.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fps []refs.FeaturePlan
+	for _, f := range got {
+		fps = append(fps, f.FeaturePlan)
+	}
+	slices.SortFunc(fps, refs.FeaturePlan.Less)
+	diff.Test(t, t.Errorf, fps, []refs.FeaturePlan{
+		refs.MustParseFeaturePlan("feature:seats@plan:free@1"),
+		refs.MustParseFeaturePlan("feature:seats@plan:pro@1"),
+		refs.MustParseFeaturePlan("feature:sso@plan:pro@1"),
+		refs.MustParseFeaturePlan("feature:support@1"),
+	})
+
+	cases := []struct {
+		name    string
+		fsys    fstest.MapFS
+		wantErr string
+	}{
+		{
+			name: "duplicate plan",
+			fsys: fstest.MapFS{
+				"pricing.json": file(`{"plans": {"plan:free@1": {"features": {"feature:x": {}}}}, "include": ["a/b.json"]}`),
+				"a/b.json":     file(`{"plans": {"plan:free@1": {"features": {"feature:y": {}}}}}`),
+			},
+			wantErr: `a/b.json: plan "plan:free@1" is also defined in pricing.json`,
+		},
+		{
+			name: "duplicate add-on",
+			fsys: fstest.MapFS{
+				"pricing.json": file(`{"plans": {}, "addons": {"feature:x@1": {}}, "include": ["b.json"]}`),
+				"b.json":       file(`{"plans": {}, "addons": {"feature:x@1": {}}}`),
+			},
+			wantErr: `b.json: add-on "feature:x@1" is also defined in pricing.json`,
+		},
+		{
+			name: "cycle",
+			fsys: fstest.MapFS{
+				"pricing.json": file(`{"plans": {}, "include": ["a/b.json"]}`),
+				"a/b.json":     file(`{"plans": {}, "include": ["../pricing.json"]}`),
+			},
+			wantErr: "pricing.json: included more than once",
+		},
+		{
+			name: "invalid",
+			fsys: fstest.MapFS{
+				"pricing.json": file(`{"plans": {}, "include": ["b.json"]}`),
+				"b.json":       file(`{"plans": {"plan:free@1": {"features": {}}}}`),
+			},
+			wantErr: "plans must have at least one feature",
+		},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := FromPricingHuJSONFile(tt.fsys, "pricing.json")
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v; want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	_, err = FromPricingHuJSON(fsys["pricing.json"].Data)
+	if err == nil {
+		t.Error("expected error for include outside of a file")
+	}
+}
@@ -5,8 +5,10 @@ package materialize
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 
 	"github.com/tailscale/hujson"
+	"golang.org/x/exp/maps"
 	"tier.run/api/apitypes"
 	"tier.run/control"
 	"tier.run/refs"
@@ -14,99 +16,251 @@ import (
 )
 
 func FromPricingHuJSON(data []byte) (fs []control.Feature, err error) {
-	var debug []string
-	dbg := func(k string) {
-		debug = append(debug, k)
+	m, err := decodeModel(data)
+	if err != nil {
+		return nil, err
 	}
+	if len(m.Include) > 0 {
+		return nil, fmt.Errorf("include is only supported when reading models from files")
+	}
+	if err := validate(m); err != nil {
+		return nil, err
+	}
+	return fromModel(m), nil
+}
 
-	data, err = hujson.Standardize(data)
+// decodeModel decodes the HuJSON model in data, without validating it.
+func decodeModel(data []byte) (apitypes.Model, error) {
+	data, err := hujson.Standardize(data)
 	if err != nil {
-		return nil, err
+		return apitypes.Model{}, err
 	}
 
 	var m apitypes.Model
 	dec := json.NewDecoder(bytes.NewReader(data))
 	dec.DisallowUnknownFields() // we use a Decoder to get the DisallowUnknownFields method
 	if err := dec.Decode(&m); err != nil {
-		dbg("decodeerr")
-		return nil, err
+		if te, ok := err.(*json.UnmarshalTypeError); ok {
+			// Standardize keeps offsets, so they are offsets into the
+			// original data too.
+			line, column := lineColumn(data, int(te.Offset))
+			return apitypes.Model{}, fmt.Errorf("line %d, column %d: %w", line, column, err)
+		}
+		return apitypes.Model{}, err
 	}
-
-	if err := validate(m); err != nil {
-		return nil, err
+	if v := m.SchemaVersion; v < 0 || v > apitypes.SchemaVersion {
+		return apitypes.Model{}, fmt.Errorf("schemaVersion: unsupported version %d; want at most %d", v, apitypes.SchemaVersion)
 	}
+	return m, nil
+}
 
+// fromModel returns the features in the validated model m.
+func fromModel(m apitypes.Model) (fs []control.Feature) {
 	for plan, p := range m.Plans {
-		for feature, f := range p.Features {
-			fn := feature.WithPlan(plan)
-			ff := control.Feature{
-				FeaturePlan: fn,
+		for feature, f := range inheritedFeatures(m, plan) {
+			fs = appendFeature(fs, control.Feature{
+				FeaturePlan: feature.WithPlan(plan),
 
 				Currency: values.Coalesce(p.Currency, "usd"),
 				Interval: values.Coalesce(p.Interval, "@monthly"),
 
 				PlanTitle: values.Coalesce(p.Title, plan.String()),
-				Title:     values.Coalesce(f.Title, fn.String()),
+				PlanTags:  p.Tags,
+				TrialDays: p.TrialDays,
+			}, f)
+		}
+	}
+	for fp, a := range m.AddOns {
+		fs = appendFeature(fs, control.Feature{
+			FeaturePlan: fp,
 
-				Base: f.Base,
+			Currency: values.Coalesce(a.Currency, "usd"),
+			Interval: values.Coalesce(a.Interval, "@monthly"),
+		}, a.Feature)
+	}
+	return fs
+}
 
-				Mode:      values.Coalesce(f.Mode, "graduated"),
-				Aggregate: values.Coalesce(f.Aggregate, "sum"),
-			}
+// inheritedFeatures returns the features of plan in m, including those
+// inherited from its base, if any. The model must have been validated so
+// that bases exist and have no cycles.
+func inheritedFeatures(m apitypes.Model, plan refs.Plan) map[refs.Name]apitypes.Feature {
+	p := m.Plans[plan]
+	if p.Base == nil {
+		return p.Features
+	}
+	fs := maps.Clone(inheritedFeatures(m, *p.Base))
+	maps.Copy(fs, p.Features)
+	return fs
+}
 
-			if len(f.Tiers) > 0 {
-				ff.Tiers = make([]control.Tier, len(f.Tiers))
-				for i, t := range f.Tiers {
-					ff.Tiers[i] = control.Tier{
-						Upto:  t.Upto,
-						Price: t.Price,
-						Base:  t.Base,
-					}
-				}
-			}
+// appendFeature appends to fs the feature ff, with its plan or add-on
+// fields already set, completed using f, followed by its variants.
+func appendFeature(fs []control.Feature, ff control.Feature, f apitypes.Feature) []control.Feature {
+	ff.Title = values.Coalesce(f.Title, ff.FeaturePlan.String())
+	ff.Unit = f.Unit
+	ff.Description = f.Description
+	ff.DisplayOrder = f.DisplayOrder
+	ff.Base = f.Base
+	ff.Mode = values.Coalesce(f.Mode, "graduated")
+	ff.Aggregate = values.Coalesce(f.Aggregate, "sum")
+	ff.PackageSize = f.PackageSize
+	ff.Aliases = f.Aliases
+	ff.OneTime = f.OneTime
+	if f.OneTime {
+		ff.Interval = ""
+	}
+	ff.Flag = f.Flag
+	ff.Rollout = f.Rollout
+	if f.Flag {
+		ff.Interval = ""
+		ff.Currency = ""
+		ff.Mode = ""
+		ff.Aggregate = ""
+	}
+	ff.Tiers = fromTiers(f.Tiers)
+	fs = append(fs, ff)
+
+	for interval, ip := range f.Intervals {
+		v := ff
+		v.Variant = true
+		v.Interval = interval
+		v.Base = ip.Base
+		v.Tiers = fromTiers(ip.Tiers)
+		fs = append(fs, v)
+	}
+	return fs
+}
+
+func fromTiers(ts []apitypes.Tier) []control.Tier {
+	if len(ts) == 0 {
+		return nil
+	}
+	out := make([]control.Tier, len(ts))
+	for i, t := range ts {
+		out[i] = control.Tier{
+			Upto:  t.Upto,
+			Price: t.Price,
+			Base:  t.Base,
+		}
+	}
+	return out
+}
 
-			fs = append(fs, ff)
+func toTiers(ts []control.Tier) []apitypes.Tier {
+	// TODO(bmizerany): find generic way to clone slices of type
+	// types with the same underlying type
+	out := make([]apitypes.Tier, len(ts))
+	for i, t := range ts {
+		out[i] = apitypes.Tier{
+			Upto:  t.Upto,
+			Price: t.Price,
+			Base:  t.Base,
 		}
 	}
-	return fs, nil
+	return out
 }
 
+// ToPricingJSON returns the model of fs as indented JSON, with its schema
+// version set to apitypes.SchemaVersion.
 func ToPricingJSON(fs []control.Feature) ([]byte, error) {
 	m := apitypes.Model{
-		Plans: make(map[refs.Plan]apitypes.Plan),
+		SchemaVersion: apitypes.SchemaVersion,
+		Plans:         make(map[refs.Plan]apitypes.Plan),
 	}
+	var variants []control.Feature
 	for _, f := range fs {
-		p := m.Plans[f.Plan()]
-		p.Title = f.PlanTitle
-		p.Currency = f.Currency
-		p.Interval = f.Interval
+		if f.Variant {
+			// Added to their features below, once all plans and
+			// features are known.
+			variants = append(variants, f)
+			continue
+		}
+		if f.Plan().IsZero() {
+			if m.AddOns == nil {
+				m.AddOns = make(map[refs.FeaturePlan]apitypes.AddOn)
+			}
+			m.AddOns[f.FeaturePlan] = apitypes.AddOn{
+				Feature:  toFeature(f),
+				Currency: values.ZeroIf(f.Currency, "usd"),
+				Interval: values.ZeroIf(f.Interval, "@monthly"),
+			}
+			continue
+		}
+		p, ok := m.Plans[f.Plan()]
+		if !ok || !f.Flag {
+			// Flags only know their plan's title, so the plan is
+			// described by its priced features where it has any.
+			p.Title = f.PlanTitle
+			p.Currency = f.Currency
+			if !f.OneTime {
+				// One-time features have no interval.
+				p.Interval = f.Interval
+			}
+			p.Tags = f.PlanTags
+			p.TrialDays = f.TrialDays
+			p.Archived = f.Archived
 
-		values.MaybeZero(&p.Currency, "usd")
-		values.MaybeZero(&p.Interval, "@monthly")
+			values.MaybeZero(&p.Currency, "usd")
+			values.MaybeZero(&p.Interval, "@monthly")
+		}
 
 		if p.Features == nil {
 			p.Features = make(map[refs.Name]apitypes.Feature)
 		}
 
-		// TODO(bmizerany): find generic way to clone slices of type
-		// types with the same underlying type
-		tiers := make([]apitypes.Tier, len(f.Tiers))
-		for i, t := range f.Tiers {
-			tiers[i] = apitypes.Tier{
-				Upto:  t.Upto,
-				Price: t.Price,
-				Base:  t.Base,
+		p.Features[f.FeaturePlan.Name()] = toFeature(f)
+		m.Plans[f.Plan()] = p
+	}
+	for _, v := range variants {
+		ip := apitypes.IntervalPrice{
+			Base:  v.Base,
+			Tiers: toTiers(v.Tiers),
+		}
+		if v.Plan().IsZero() {
+			a, ok := m.AddOns[v.FeaturePlan]
+			if !ok {
+				return nil, fmt.Errorf("variant %s billed %s has no default price", v.FeaturePlan, v.Interval)
 			}
+			a.Intervals = withInterval(a.Intervals, v.Interval, ip)
+			m.AddOns[v.FeaturePlan] = a
+			continue
 		}
-
-		p.Features[f.FeaturePlan.Name()] = apitypes.Feature{
-			Title:     values.ZeroIf(f.Title, f.FeaturePlan.String()),
-			Base:      f.Base,
-			Mode:      values.ZeroIf(f.Mode, "graduated"),
-			Aggregate: values.ZeroIf(f.Aggregate, "sum"),
-			Tiers:     tiers,
+		p := m.Plans[v.Plan()]
+		f, ok := p.Features[v.FeaturePlan.Name()]
+		if !ok {
+			return nil, fmt.Errorf("variant %s billed %s has no default price", v.FeaturePlan, v.Interval)
 		}
-		m.Plans[f.Plan()] = p
+		f.Intervals = withInterval(f.Intervals, v.Interval, ip)
+		p.Features[v.FeaturePlan.Name()] = f
 	}
 	return json.MarshalIndent(m, "", "  ")
 }
+
+// toFeature returns the model of f, without its plan or add-on fields.
+func toFeature(f control.Feature) apitypes.Feature {
+	return apitypes.Feature{
+		Title:       values.ZeroIf(f.Title, f.FeaturePlan.String()),
+		Unit:        f.Unit,
+		Description: f.Description,
+		Base:        f.Base,
+		Mode:        values.ZeroIf(f.Mode, "graduated"),
+		Aggregate:   values.ZeroIf(f.Aggregate, "sum"),
+		Tiers:       toTiers(f.Tiers),
+		Aliases:     f.Aliases,
+		OneTime:     f.OneTime,
+		Flag:        f.Flag,
+		PackageSize: f.PackageSize,
+		Rollout:     f.Rollout,
+
+		DisplayOrder: f.DisplayOrder,
+	}
+}
+
+func withInterval(m map[string]apitypes.IntervalPrice, interval string, ip apitypes.IntervalPrice) map[string]apitypes.IntervalPrice {
+	if m == nil {
+		m = make(map[string]apitypes.IntervalPrice)
+	}
+	m[interval] = ip
+	return m
+}
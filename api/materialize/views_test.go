@@ -3,14 +3,17 @@ package materialize
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"testing"
 
 	"github.com/tailscale/hujson"
 	"golang.org/x/exp/slices"
 	"kr.dev/diff"
+	"tier.run/api/apitypes"
 	"tier.run/client/tier"
 	"tier.run/control"
 	"tier.run/refs"
+	"tier.run/values"
 )
 
 func TestPricingHuJSON(t *testing.T) {
@@ -69,9 +72,9 @@ func TestPricingHuJSON(t *testing.T) {
 			Mode:        "volume",
 			Aggregate:   "perpetual",
 			Tiers: []control.Tier{
-				{Upto: 10, Price: 0, Base: 0},
-				{Upto: 20, Price: 100, Base: 0},
-				{Upto: tier.Inf, Price: 50, Base: 0},
+				{Upto: 10, Price: values.MustParseDecimal("0"), Base: 0},
+				{Upto: 20, Price: values.MustParseDecimal("100"), Base: 0},
+				{Upto: tier.Inf, Price: values.MustParseDecimal("50"), Base: 0},
 			},
 		},
 	}
@@ -84,6 +87,7 @@ func TestPricingHuJSON(t *testing.T) {
 	}
 
 	wantJSON := []byte(`{
+		"schemaVersion": 1,
 		"plans": {
 			"plan:example@1": {
 				"title": "Just an example plan to show off features",
@@ -113,6 +117,477 @@ func TestPricingHuJSON(t *testing.T) {
 	diffJSON(t, gotJSON, wantJSON)
 }
 
+func TestPricingHuJSONIntervals(t *testing.T) {
+	data := []byte(`{
+		"plans": {
+			"plan:pro@1": {
+				"features": {
+					"feature:seats": {
+						"base": 100,
+						"intervals": {
+							"@yearly": { "base": 1000 }
+						}
+					},
+					"feature:calls": {
+						"tiers": [{ "price": 1 }],
+						"intervals": {
+							"@yearly": { "tiers": [{ "price": 10 }] }
+						}
+					}
+				}
+			}
+		}
+	}`)
+
+	got, err := FromPricingHuJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(got, func(a, b control.Feature) bool {
+		return a.ID() < b.ID()
+	})
+
+	f := func(s, interval string, variant bool) control.Feature {
+		fp := refs.MustParseFeaturePlan(s)
+		return control.Feature{
+			FeaturePlan: fp,
+			PlanTitle:   "plan:pro@1",
+			Title:       fp.String(),
+			Currency:    "usd",
+			Interval:    interval,
+			Variant:     variant,
+			Mode:        "graduated",
+			Aggregate:   "sum",
+		}
+	}
+	calls := f("feature:calls@plan:pro@1", "@monthly", false)
+	calls.Tiers = []control.Tier{{Upto: tier.Inf, Price: values.MustParseDecimal("1")}}
+	callsYearly := f("feature:calls@plan:pro@1", "@yearly", true)
+	callsYearly.Tiers = []control.Tier{{Upto: tier.Inf, Price: values.MustParseDecimal("10")}}
+	seats := f("feature:seats@plan:pro@1", "@monthly", false)
+	seats.Base = 100
+	seatsYearly := f("feature:seats@plan:pro@1", "@yearly", true)
+	seatsYearly.Base = 1000
+
+	diff.Test(t, t.Errorf, got, []control.Feature{calls, callsYearly, seats, seatsYearly})
+
+	gotJSON, err := ToPricingJSON(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffJSON(t, gotJSON, []byte(`{
+		"schemaVersion": 1,
+		"plans": {
+			"plan:pro@1": {
+				"title": "plan:pro@1",
+				"features": {
+					"feature:calls": {
+						"tiers": [{ "price": 1 }],
+						"intervals": {
+							"@yearly": { "tiers": [{ "price": 10 }] }
+						}
+					},
+					"feature:seats": {
+						"base": 100,
+						"intervals": {
+							"@yearly": { "base": 1000 }
+						}
+					}
+				}
+			}
+		}
+	}`))
+
+	_, err = FromPricingHuJSON([]byte(`{
+		"plans": {
+			"plan:pro@1": {
+				"features": {
+					"feature:seats": {
+						"intervals": { "@monthly": { "base": 1 } }
+					}
+				}
+			}
+		}
+	}`))
+	if err == nil {
+		t.Error("expected error for variant billed at the plan interval")
+	}
+}
+
+func TestPricingHuJSONTrialAndDisplay(t *testing.T) {
+	data := []byte(`{
+		"schemaVersion": 1,
+		"plans": {
+			"plan:pro@1": {
+				"title": "Pro",
+				"features": {
+					"feature:seats": {
+						"base": 100,
+						"unit": "seats",
+						"description": "Members of the team.",
+						"displayOrder": 1
+					}
+				},
+				"trialDays": 14
+			}
+		}
+	}`)
+
+	got, err := FromPricingHuJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, []control.Feature{{
+		PlanTitle:   "Pro",
+		Title:       "feature:seats@plan:pro@1",
+		FeaturePlan: refs.MustParseFeaturePlan("feature:seats@plan:pro@1"),
+		Currency:    "usd",
+		Interval:    "@monthly",
+		Mode:        "graduated",
+		Aggregate:   "sum",
+		Base:        100,
+		TrialDays:   14,
+
+		Unit:         "seats",
+		Description:  "Members of the team.",
+		DisplayOrder: 1,
+	}})
+
+	gotJSON, err := ToPricingJSON(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffJSON(t, gotJSON, data)
+
+	_, err = FromPricingHuJSON([]byte(`{"plans": {"plan:pro@1": {"features": {"feature:x": {}}, "trialDays": -1}}}`))
+	if err == nil {
+		t.Error("expected error for negative trialDays")
+	}
+}
+
+func TestPricingHuJSONFlags(t *testing.T) {
+	data := []byte(`{
+		"schemaVersion": 1,
+		"plans": {
+			"plan:pro@1": {
+				"title": "Pro",
+				"features": {
+					"feature:seats": {
+						"base": 100
+					},
+					"feature:sso": {
+						"flag": true
+					}
+				}
+			}
+		}
+	}`)
+
+	got, err := FromPricingHuJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(got, func(a, b control.Feature) bool {
+		return a.Less(b.FeaturePlan)
+	})
+	diff.Test(t, t.Errorf, got, []control.Feature{{
+		PlanTitle:   "Pro",
+		Title:       "feature:seats@plan:pro@1",
+		FeaturePlan: refs.MustParseFeaturePlan("feature:seats@plan:pro@1"),
+		Currency:    "usd",
+		Interval:    "@monthly",
+		Mode:        "graduated",
+		Aggregate:   "sum",
+		Base:        100,
+	}, {
+		PlanTitle:   "Pro",
+		Title:       "feature:sso@plan:pro@1",
+		FeaturePlan: refs.MustParseFeaturePlan("feature:sso@plan:pro@1"),
+		Flag:        true,
+	}})
+
+	// The flag sorts last, so must not overwrite the plan's currency and
+	// interval.
+	gotJSON, err := ToPricingJSON(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffJSON(t, gotJSON, data)
+
+	_, err = FromPricingHuJSON([]byte(`{"plans": {"plan:pro@1": {"features": {"feature:sso": {"flag": true, "base": 1}}}}}`))
+	if err == nil {
+		t.Error("expected error for priced flag")
+	}
+}
+
+func TestPricingHuJSONBase(t *testing.T) {
+	got, err := FromPricingHuJSON([]byte(`{
+		"plans": {
+			"plan:free@1": {
+				"features": {
+					"feature:seats": {},
+					"feature:calls": { "tiers": [{ "upto": 100 }] }
+				}
+			},
+			"plan:pro@1": {
+				"base": "plan:free@1",
+				"features": {
+					"feature:seats": { "base": 100 },
+					"feature:sso": {}
+				}
+			},
+			"plan:enterprise@1": {
+				"base": "plan:pro@1"
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(got, func(a, b control.Feature) bool {
+		return a.Less(b.FeaturePlan)
+	})
+	var gotPrices []string
+	for _, f := range got {
+		gotPrices = append(gotPrices, fmt.Sprintf("%s %d %d", f.FeaturePlan, f.Base, f.Limit()))
+	}
+	diff.Test(t, t.Errorf, gotPrices, []string{
+		"feature:calls@plan:enterprise@1 0 100",
+		"feature:calls@plan:free@1 0 100",
+		"feature:calls@plan:pro@1 0 100",
+		"feature:seats@plan:enterprise@1 100 " + fmt.Sprint(control.Inf),
+		"feature:seats@plan:free@1 0 " + fmt.Sprint(control.Inf),
+		"feature:seats@plan:pro@1 100 " + fmt.Sprint(control.Inf),
+		"feature:sso@plan:enterprise@1 0 " + fmt.Sprint(control.Inf),
+		"feature:sso@plan:pro@1 0 " + fmt.Sprint(control.Inf),
+	})
+}
+
+func TestPricingHuJSONOneTime(t *testing.T) {
+	data := []byte(`{
+		"schemaVersion": 1,
+		"plans": {
+			"plan:pro@1": {
+				"title": "Pro",
+				"interval": "@yearly",
+				"features": {
+					"feature:seats": { "base": 100 },
+					"feature:setup": { "base": 5000, "oneTime": true }
+				}
+			}
+		}
+	}`)
+
+	got, err := FromPricingHuJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(got, func(a, b control.Feature) bool {
+		return a.Less(b.FeaturePlan)
+	})
+	diff.Test(t, t.Errorf, got, []control.Feature{
+		{
+			PlanTitle:   "Pro",
+			Title:       "feature:seats@plan:pro@1",
+			FeaturePlan: refs.MustParseFeaturePlan("feature:seats@plan:pro@1"),
+			Currency:    "usd",
+			Interval:    "@yearly",
+			Mode:        "graduated",
+			Aggregate:   "sum",
+			Base:        100,
+		},
+		{
+			PlanTitle:   "Pro",
+			Title:       "feature:setup@plan:pro@1",
+			FeaturePlan: refs.MustParseFeaturePlan("feature:setup@plan:pro@1"),
+			Currency:    "usd",
+			Mode:        "graduated",
+			Aggregate:   "sum",
+			Base:        5000,
+			OneTime:     true,
+		},
+	})
+
+	// The plan interval is kept whichever feature comes last.
+	for _, fs := range [][]control.Feature{got, {got[1], got[0]}} {
+		gotJSON, err := ToPricingJSON(fs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		diffJSON(t, gotJSON, data)
+	}
+
+	_, err = FromPricingHuJSON([]byte(`{
+		"plans": {
+			"plan:pro@1": {
+				"features": {
+					"feature:setup": { "oneTime": true, "tiers": [{ "price": 1 }] }
+				}
+			}
+		}
+	}`))
+	if err == nil {
+		t.Error("expected error for one-time feature with tiers")
+	}
+}
+
+func TestPricingHuJSONAddOns(t *testing.T) {
+	data := []byte(`{
+		"plans": {
+			"plan:pro@1": {
+				"features": {
+					"feature:seats": { "base": 100 }
+				}
+			}
+		},
+		"addons": {
+			"feature:support@1": {
+				"title": "Priority Support",
+				"interval": "@yearly",
+				"base": 5000
+			}
+		}
+	}`)
+
+	got, err := FromPricingHuJSON(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(got, func(a, b control.Feature) bool {
+		return a.Less(b.FeaturePlan)
+	})
+	diff.Test(t, t.Errorf, got, []control.Feature{
+		{
+			PlanTitle:   "plan:pro@1",
+			Title:       "feature:seats@plan:pro@1",
+			FeaturePlan: refs.MustParseFeaturePlan("feature:seats@plan:pro@1"),
+			Currency:    "usd",
+			Interval:    "@monthly",
+			Mode:        "graduated",
+			Aggregate:   "sum",
+			Base:        100,
+		},
+		{
+			Title:       "Priority Support",
+			FeaturePlan: refs.MustParseFeaturePlan("feature:support@1"),
+			Currency:    "usd",
+			Interval:    "@yearly",
+			Mode:        "graduated",
+			Aggregate:   "sum",
+			Base:        5000,
+		},
+	})
+
+	gotJSON, err := ToPricingJSON(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffJSON(t, gotJSON, []byte(`{
+		"schemaVersion": 1,
+		"plans": {
+			"plan:pro@1": {
+				"title": "plan:pro@1",
+				"features": {
+					"feature:seats": { "base": 100 }
+				}
+			}
+		},
+		"addons": {
+			"feature:support@1": {
+				"title": "Priority Support",
+				"base": 5000,
+				"interval": "@yearly"
+			}
+		}
+	}`))
+
+	_, err = FromPricingHuJSON([]byte(`{
+		"plans": {},
+		"addons": {
+			"feature:support@plan:pro@1": { "base": 1 }
+		}
+	}`))
+	if err == nil {
+		t.Error("expected error for add-on in a plan")
+	}
+}
+
+func TestPricingHuJSONDecimalPrices(t *testing.T) {
+	got, err := FromPricingHuJSON([]byte(`{
+		"plans": {
+			"plan:pro@1": {
+				"features": {
+					"feature:events": {
+						"tiers": [
+							{ "upto": 1000000, "price": "0.07" },
+							{ "price": 1e-7 }
+						]
+					}
+				}
+			}
+		}
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got[0].Tiers, []control.Tier{
+		{Upto: 1000000, Price: values.MustParseDecimal("0.07")},
+		{Upto: tier.Inf, Price: values.MustParseDecimal("0.0000001")},
+	})
+
+	gotJSON, err := ToPricingJSON(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diffJSON(t, gotJSON, []byte(`{
+		"schemaVersion": 1,
+		"plans": {
+			"plan:pro@1": {
+				"title": "plan:pro@1",
+				"features": {
+					"feature:events": {
+						"tiers": [
+							{ "upto": 1000000, "price": 0.07 },
+							{ "price": 0.0000001 }
+						]
+					}
+				}
+			}
+		}
+	}`))
+
+	_, err = FromPricingHuJSON([]byte(`{
+		"plans": {
+			"plan:pro@1": {
+				"features": {
+					"feature:events": { "tiers": [{ "price": "$0.07" }] }
+				}
+			}
+		}
+	}`))
+	if err == nil {
+		t.Error("expected error for invalid decimal price")
+	}
+}
+
+func TestPricingHuJSONSchemaVersion(t *testing.T) {
+	for _, tt := range []struct {
+		version int
+		ok      bool
+	}{
+		{0, true},
+		{apitypes.SchemaVersion, true},
+		{apitypes.SchemaVersion + 1, false},
+		{-1, false},
+	} {
+		data := fmt.Sprintf(`{"schemaVersion": %d, "plans": {"plan:pro@1": {"features": {"feature:x": {}}}}}`, tt.version)
+		_, err := FromPricingHuJSON([]byte(data))
+		if (err == nil) != tt.ok {
+			t.Errorf("schemaVersion %d: err = %v; want ok = %v", tt.version, err, tt.ok)
+		}
+	}
+}
+
 func diffJSON(t *testing.T, got, want []byte) {
 	t.Helper()
 
@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/api/apitypes"
+	"tier.run/control"
+	"tier.run/fetch"
+	"tier.run/fetch/fetchtest"
+	"tier.run/refs"
+)
+
+// memProvider is a Provider for one org subscribed to fixed features. Its
+// other methods panic.
+type memProvider struct {
+	control.Provider
+	org string
+	fs  []refs.FeaturePlan
+}
+
+func (p *memProvider) LookupPhases(ctx context.Context, org string) ([]control.Phase, error) {
+	if org != p.org {
+		return nil, nil
+	}
+	return []control.Phase{{Org: org, Features: p.fs, Current: true}}, nil
+}
+
+func (p *memProvider) LookupLimits(ctx context.Context, org string) ([]control.Usage, error) {
+	if org != p.org {
+		return nil, nil
+	}
+	var us []control.Usage
+	for _, f := range p.fs {
+		us = append(us, control.Usage{Feature: f, Limit: 10, Used: 3})
+	}
+	return us, nil
+}
+
+func TestProvider(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	fp := mpf("feature:x@plan:test@0")
+	h := NewHandler(&memProvider{org: "org:test", fs: []refs.FeaturePlan{fp}}, t.Logf)
+	h.helper = t.Helper
+	c := fetchtest.NewTLSServer(t, h.ServeHTTP)
+
+	got, err := fetch.OK[apitypes.UsageResponse, *apitypes.Error](ctx, c, "GET", "/v1/limits?org=org:test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, apitypes.UsageResponse{
+		Org: "org:test",
+		Usage: []apitypes.Usage{{
+			Feature: fp.Name(),
+			Limit:   10,
+			Used:    3,
+		}},
+	})
+
+	_, err = fetch.OK[apitypes.WhoAmIResponse, *apitypes.Error](ctx, c, "GET", "/v1/whoami", nil)
+	if e, ok := err.(*apitypes.Error); !ok || e.Status != 501 {
+		t.Errorf("whoami: err = %v; want 501", err)
+	}
+}
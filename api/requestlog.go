@@ -0,0 +1,78 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"tier.run/metrics"
+	"tier.run/trweb"
+)
+
+// errNoRoute is returned by Handler.serve for requests for unknown paths. It
+// is served like trweb.NotFound, but tells observe not to label metrics with
+// the path, which any client could otherwise add series for.
+var errNoRoute = &trweb.HTTPError{Status: 404, Code: "not_found", Message: "Not Found"}
+
+// A LogLevel selects the requests logged by a Handler.
+type LogLevel int
+
+const (
+	LogNone   LogLevel = iota // log no requests
+	LogErrors                 // log requests failing with a 4xx or 5xx status
+	LogAll                    // log all requests
+)
+
+var logLevelNames = []string{"none", "errors", "all"}
+
+func (l LogLevel) String() string {
+	if l < 0 || int(l) >= len(logLevelNames) {
+		return "LogLevel(" + strconv.Itoa(int(l)) + ")"
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel returns the LogLevel named s: "none", "errors", or "all".
+func ParseLogLevel(s string) (LogLevel, error) {
+	for i, name := range logLevelNames {
+		if s == name {
+			return LogLevel(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q; want none, errors, or all", s)
+}
+
+// observe records the request r, served to w since start, in h.Metrics, and
+// logs it with h.RequestLogf if selected by h.RequestLog. *errp is the
+// error serving it, if any.
+func (h *Handler) observe(r *http.Request, w *byteCountResponseWriter, start time.Time, errp *error) {
+	if h.HealthPath != "" && r.URL.Path == h.HealthPath {
+		return
+	}
+	err := *errp
+	status := w.status
+	if status == 0 {
+		// No response was written, as when the client went away.
+		status = http.StatusOK
+	}
+	path := r.URL.Path
+	if errors.Is(err, errNoRoute) {
+		path = "other"
+	}
+	metrics.Since(h.Metrics, "tier_api_request_duration_seconds", start,
+		metrics.L("path", path),
+		metrics.L("status", strconv.Itoa(status)),
+	)
+
+	if h.RequestLogf == nil || h.RequestLog == LogNone || (h.RequestLog == LogErrors && status < 400) {
+		return
+	}
+	line := fmt.Sprintf("%s %s org=%q status=%d bytes=%d duration=%v",
+		r.Method, r.URL.Path, r.URL.Query().Get("org"), status, w.n, time.Since(start).Round(time.Microsecond))
+	if err != nil {
+		line += ": " + err.Error()
+	}
+	h.RequestLogf("%s", line)
+}
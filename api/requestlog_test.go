@@ -0,0 +1,89 @@
+package api
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"tier.run/control"
+	"tier.run/metrics"
+	"tier.run/stripe/stripefake"
+)
+
+func TestObserve(t *testing.T) {
+	t.Parallel()
+
+	cc := &control.Client{Stripe: stripefake.Client(t), Logf: t.Logf}
+	var reg metrics.Registry
+	var (
+		mu    sync.Mutex
+		lines []string
+	)
+	h := NewHandler(cc, t.Logf)
+	h.helper = t.Helper
+	h.Metrics = &reg
+	h.HealthPath = "/healthz"
+	h.RequestLog = LogErrors
+	h.RequestLogf = func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		lines = append(lines, fmt.Sprintf(format, args...))
+	}
+
+	get := func(path string, wantStatus int) {
+		t.Helper()
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		if w.Code != wantStatus {
+			t.Errorf("GET %s: status = %d; want %d", path, w.Code, wantStatus)
+		}
+	}
+	get("/healthz", 200)
+	get("/v1/whoami", 200)
+	get("/v1/whois?org=org:missing", 400)
+	get("/v1/nope", 404)
+
+	if len(lines) != 2 ||
+		!strings.HasPrefix(lines[0], `GET /v1/whois org="org:missing" status=400 `) ||
+		!strings.HasPrefix(lines[1], `GET /v1/nope org="" status=404 `) {
+		t.Errorf("logged %q; want the two failed requests", lines)
+	}
+
+	var b strings.Builder
+	if _, err := reg.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		`tier_api_request_duration_seconds_count{path="/v1/whoami",status="200"} 1`,
+		`tier_api_request_duration_seconds_count{path="/v1/whois",status="400"} 1`,
+		`tier_api_request_duration_seconds_count{path="other",status="404"} 1`,
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("metrics missing %s:\n%s", want, b.String())
+		}
+	}
+	if strings.Contains(b.String(), "healthz") || strings.Contains(b.String(), "/v1/nope") {
+		t.Errorf("metrics include health checks or unknown paths:\n%s", b.String())
+	}
+
+	h.RequestLog = LogAll
+	lines = nil
+	get("/v1/whoami", 200)
+	if len(lines) != 1 {
+		t.Errorf("logged %q; want one line", lines)
+	}
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for _, l := range []LogLevel{LogNone, LogErrors, LogAll} {
+		got, err := ParseLogLevel(l.String())
+		if err != nil || got != l {
+			t.Errorf("ParseLogLevel(%q) = %v, %v; want %v", l, got, err, l)
+		}
+	}
+	if _, err := ParseLogLevel("debug"); err == nil {
+		t.Error("ParseLogLevel(debug) = nil error; want error")
+	}
+}
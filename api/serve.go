@@ -0,0 +1,141 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+
+	"tier.run/values"
+)
+
+// listenFDsStart is the first file descriptor of the sockets passed to a
+// process by socket activation.
+const listenFDsStart = 3
+
+// Listen returns the listener to serve on. If the process was passed a
+// socket by systemd socket activation, or by Restart, it returns a
+// listener on the first such socket; otherwise it listens for TCP
+// connections on addr.
+func Listen(addr string) (net.Listener, error) {
+	ln, err := activatedListener()
+	if ln != nil || err != nil {
+		return ln, err
+	}
+	return net.Listen("tcp", addr)
+}
+
+// activatedListener returns a listener on the first socket passed to the
+// process by socket activation, as described by the LISTEN_FDS and
+// LISTEN_PID environment variables, or nil if none was. The variables are
+// cleared, so that they are not inherited by child processes.
+//
+// Unlike systemd, it accepts LISTEN_FDS without LISTEN_PID, as set by
+// Restart, which cannot know the pid of the process it starts.
+func activatedListener() (net.Listener, error) {
+	pid, fds := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if fds == "" || (pid != "" && pid != strconv.Itoa(os.Getpid())) {
+		return nil, nil
+	}
+	if n, err := strconv.Atoi(fds); err != nil || n < 1 {
+		return nil, fmt.Errorf("api: invalid LISTEN_FDS %q", fds)
+	}
+	f := os.NewFile(listenFDsStart, "listener")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("api: socket activation: %w", err)
+	}
+	return ln, nil
+}
+
+// ServeOptions holds the options for Handler.Serve. The zero value serves
+// plain HTTP.
+type ServeOptions struct {
+	// CertFile and KeyFile, if set, are the files holding the TLS
+	// certificate and key to serve HTTPS with. Both must be set, or
+	// neither.
+	CertFile string
+	KeyFile  string
+
+	// ShutdownTimeout is how long to wait for requests in flight to
+	// finish when shutting down. If zero, 30 seconds is used.
+	ShutdownTimeout time.Duration
+}
+
+// Serve serves h on ln until ctx is done, then shuts down gracefully: it
+// stops accepting connections and waits for requests in flight to finish,
+// for up to opts.ShutdownTimeout. It returns nil once shut down, or the
+// error that stopped it serving before then.
+func (h *Handler) Serve(ctx context.Context, ln net.Listener, opts ServeOptions) error {
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return errors.New("api: TLS requires both a certificate and a key")
+	}
+	s := &http.Server{
+		Handler:           h,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	if h.Events != nil {
+		// End the streams of events being followed, which would
+		// otherwise hold up shutting down.
+		s.RegisterOnShutdown(h.Events.Close)
+	}
+	errc := make(chan error, 1)
+	go func() {
+		if opts.CertFile != "" {
+			errc <- s.ServeTLS(ln, opts.CertFile, opts.KeyFile)
+		} else {
+			errc <- s.Serve(ln)
+		}
+	}()
+	select {
+	case err := <-errc:
+		return err
+	case <-ctx.Done():
+	}
+	sctx, cancel := context.WithTimeout(context.Background(), values.Coalesce(opts.ShutdownTimeout, 30*time.Second))
+	defer cancel()
+	return s.Shutdown(sctx)
+}
+
+// Restart starts a new copy of the running program, with the same
+// arguments and environment, passing it ln to serve on by calling Listen.
+// The caller should then stop serving, by canceling the context passed to
+// Serve, so that the new process takes over without refusing any
+// connections.
+//
+// Processes managed by systemd should be restarted by systemd instead,
+// using socket activation to hold connections while they restart.
+func Restart(ln net.Listener) (*os.Process, error) {
+	fl, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, errors.New("api: listener cannot be passed to a new process")
+	}
+	f, err := fl.File()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "LISTEN_FDS=1")
+	cmd.ExtraFiles = []*os.File{f} // listenFDsStart
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd.Process, nil
+}
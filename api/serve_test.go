@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+
+	"tier.run/control"
+	"tier.run/stripe/stripefake"
+)
+
+func TestServe(t *testing.T) {
+	t.Parallel()
+
+	cc := &control.Client{Stripe: stripefake.Client(t), Logf: t.Logf}
+	h := NewHandler(cc, t.Logf)
+	h.helper = t.Helper
+
+	ln, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	if err := h.Serve(context.Background(), ln, ServeOptions{CertFile: "cert.pem"}); err == nil {
+		t.Error("serving with certificate but no key: err = nil; want error")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- h.Serve(ctx, ln, ServeOptions{}) }()
+
+	res, err := http.Get("http://" + ln.Addr().String() + "/v1/nope")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != 404 {
+		t.Errorf("status = %d; want 404", res.StatusCode)
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("shutting down: %v", err)
+	}
+	if _, err := net.Dial("tcp", ln.Addr().String()); err == nil {
+		t.Error("listener still accepting after shutdown")
+	}
+}
@@ -0,0 +1,387 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"golang.org/x/sync/errgroup"
+	"tier.run/api/apitypes"
+	"tier.run/refs"
+	"tier.run/values"
+)
+
+// A Store persists the phases and limits served by a Handler for each org,
+// so they can be served while Stripe is unavailable, including after the
+// sidecar restarts. It also tracks the usage of anonymous subjects, which
+// have no customer in Stripe; see Handler.AnonymousPlan.
+//
+// Entries are held in memory and saved to a single JSON file in the
+// background, shortly after they change, so that requests do not wait on
+// the file and changes made together are saved at once. Changes not yet
+// saved when the process exits are lost unless Close is called.
+type Store struct {
+	path string
+
+	// AnonymousTTL is how long the usage of an anonymous subject is kept
+	// after it last changed. If zero, it is kept until merged. OpenStore
+	// sets it to DefaultAnonymousTTL.
+	AnonymousTTL time.Duration
+
+	// OrgTTL is how long the phase and limits of an org are kept, and
+	// refreshed, after they were last served, so that orgs no longer
+	// served stop costing lookups. If zero, they are kept forever.
+	// OpenStore sets it to DefaultOrgTTL.
+	OrgTTL time.Duration
+
+	// Logf, if not nil, reports errors saving in the background.
+	Logf func(format string, args ...any)
+
+	saveDelay time.Duration
+	saveMu    sync.Mutex // serializes writes to path
+
+	mu     sync.Mutex
+	orgs   map[string]storeEntry
+	dirty  bool        // changed since last saved
+	timer  *time.Timer // pending save, if any
+	closed bool
+}
+
+// DefaultAnonymousTTL is the default AnonymousTTL of a Store.
+const DefaultAnonymousTTL = 30 * 24 * time.Hour
+
+// DefaultOrgTTL is the default OrgTTL of a Store.
+const DefaultOrgTTL = 7 * 24 * time.Hour
+
+// servedResolution is how stale the time an org was last served may be
+// before it is updated, so that serving an org does not change its entry,
+// and cause a save, on every request.
+const servedResolution = time.Hour
+
+type storeEntry struct {
+	Phase  *apitypes.PhaseResponse `json:"phase,omitempty"`
+	Limits *apitypes.UsageResponse `json:"limits,omitempty"`
+
+	// Used is the usage of each feature by an anonymous subject, by
+	// feature name.
+	Used map[string]int `json:"used,omitempty"`
+
+	// Changed is when the phase, limits, or usage last changed.
+	Changed time.Time `json:"changed"`
+
+	// Served is about when the phase or limits of the org were last
+	// served, to within servedResolution.
+	Served time.Time `json:"served,omitempty"`
+}
+
+// OpenStore opens the Store saved in the file at path, or returns an empty
+// Store to be saved there if the file does not exist.
+func OpenStore(path string) (*Store, error) {
+	s := &Store{
+		path:         path,
+		AnonymousTTL: DefaultAnonymousTTL,
+		OrgTTL:       DefaultOrgTTL,
+		saveDelay:    time.Second,
+		orgs:         map[string]storeEntry{},
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.orgs); err != nil {
+		return nil, fmt.Errorf("store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Orgs returns the orgs with phases or limits in s, sorted. Anonymous
+// subjects are not included.
+func (s *Store) Orgs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var orgs []string
+	for org := range s.orgs {
+		if !isAnonymous(org) {
+			orgs = append(orgs, org)
+		}
+	}
+	slices.Sort(orgs)
+	return orgs
+}
+
+func (s *Store) lookup(org string) storeEntry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, _ := s.get(org, time.Now())
+	return e
+}
+
+// get returns the entry for org, removing it instead if it is expired.
+// s.mu must be held.
+func (s *Store) get(org string, now time.Time) (storeEntry, bool) {
+	e, ok := s.orgs[org]
+	if ok && s.expired(org, e, now) {
+		delete(s.orgs, org)
+		s.changed()
+		return storeEntry{}, false
+	}
+	return e, ok
+}
+
+// expired reports if e, the entry for org, is that of an anonymous subject
+// unchanged for AnonymousTTL, or of an org not served for OrgTTL.
+func (s *Store) expired(org string, e storeEntry, now time.Time) bool {
+	if isAnonymous(org) {
+		return s.AnonymousTTL > 0 && now.Sub(e.Changed) > s.AnonymousTTL
+	}
+	// Entries saved before Served was kept were served when changed.
+	served := values.Coalesce(e.Served, e.Changed)
+	return s.OrgTTL > 0 && now.Sub(served) > s.OrgTTL
+}
+
+// expire removes the entries that have expired; see AnonymousTTL and
+// OrgTTL.
+func (s *Store) expire(now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for org := range s.orgs {
+		s.get(org, now)
+	}
+}
+
+// putPhase and putLimits store the phase and limits of org as served.
+func (s *Store) putPhase(org string, p apitypes.PhaseResponse) {
+	s.update(org, true, func(e *storeEntry) { e.Phase = &p })
+}
+
+func (s *Store) putLimits(org string, u apitypes.UsageResponse) {
+	s.update(org, true, func(e *storeEntry) { e.Limits = &u })
+}
+
+// refresh stores the phase and limits of org, if not nil, as looked up
+// again rather than served. Orgs not in s, as when expired since they
+// were looked up, are not added.
+func (s *Store) refresh(org string, p *apitypes.PhaseResponse, u *apitypes.UsageResponse) {
+	s.update(org, false, func(e *storeEntry) {
+		if e.Phase == nil && e.Limits == nil {
+			return
+		}
+		e.Phase = values.Coalesce(p, e.Phase)
+		e.Limits = values.Coalesce(u, e.Limits)
+	})
+}
+
+// putUsage adds n to the usage of feature by the anonymous subject org, or
+// sets it to n if clobber is true.
+func (s *Store) putUsage(org string, feature refs.Name, n int, clobber bool) {
+	s.update(org, false, func(e *storeEntry) {
+		e.Used = maps.Clone(e.Used)
+		if e.Used == nil {
+			e.Used = map[string]int{}
+		}
+		if !clobber {
+			n += e.Used[feature.String()]
+		}
+		if n == 0 {
+			delete(e.Used, feature.String())
+		} else {
+			e.Used[feature.String()] = n
+		}
+	})
+}
+
+// update applies f to the entry for org, and schedules s to be saved if
+// the entry changed. If served is true, the entry is marked as served.
+// Entries left empty are removed.
+func (s *Store) update(org string, served bool, f func(*storeEntry)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	old, ok := s.get(org, now)
+	e := old
+	f(&e)
+	touched := served && now.Sub(e.Served) > servedResolution
+	if reflect.DeepEqual(old.Phase, e.Phase) && reflect.DeepEqual(old.Limits, e.Limits) && maps.Equal(old.Used, e.Used) {
+		if !touched || !ok {
+			return
+		}
+	} else {
+		e.Changed = now
+	}
+	if touched {
+		e.Served = now
+	}
+	if e.Phase == nil && e.Limits == nil && len(e.Used) == 0 {
+		if !ok {
+			return
+		}
+		delete(s.orgs, org)
+	} else {
+		s.orgs[org] = e
+	}
+	s.changed()
+}
+
+// changed marks s as changed, and schedules it to be saved unless a save
+// is already scheduled or s is closed. s.mu must be held.
+func (s *Store) changed() {
+	s.dirty = true
+	if s.timer != nil || s.closed {
+		return
+	}
+	s.timer = time.AfterFunc(s.saveDelay, func() {
+		if err := s.Flush(); err != nil && s.Logf != nil {
+			s.Logf("store: saving %s: %v", s.path, err)
+		}
+	})
+}
+
+// Flush saves the changes to s not yet saved, if any.
+func (s *Store) Flush() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+
+	s.mu.Lock()
+	if s.timer != nil {
+		s.timer.Stop()
+		s.timer = nil
+	}
+	if !s.dirty {
+		s.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(s.orgs)
+	s.dirty = false
+	s.mu.Unlock()
+	if err == nil {
+		err = s.save(data)
+	}
+	if err != nil {
+		// Try again with the next save.
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// Close saves the changes to s not yet saved, if any, and stops saving
+// in the background. Changes made after Close are not saved unless Flush
+// is called.
+func (s *Store) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	s.mu.Unlock()
+	return s.Flush()
+}
+
+// save writes data to the file of s, replacing the file only once written
+// in full so that a crash mid-write does not lose the previous contents.
+func (s *Store) save(data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(s.path), ".tier-store-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) // fails harmlessly once renamed
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), s.path)
+}
+
+// refreshConcurrency is the most orgs refreshStore looks up at once.
+const refreshConcurrency = 4
+
+// RefreshStore looks up the phase and limits of each org in h.Store every
+// interval, until ctx is done, so that those served while Stripe is
+// unavailable are recent, and removes expired entries. The lookups of each
+// interval are spread over its first half, and a few orgs are looked up at
+// once. It returns ctx.Err().
+func (h *Handler) RefreshStore(ctx context.Context, interval time.Duration) error {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+		h.refreshStore(ctx, interval/2)
+	}
+}
+
+// refreshStore looks up the phase and limits of each org in h.Store, each
+// starting at a random time within spread.
+func (h *Handler) refreshStore(ctx context.Context, spread time.Duration) {
+	h.Store.expire(time.Now())
+
+	type job struct {
+		org string
+		at  time.Duration // since start
+	}
+	var jobs []job
+	for _, org := range h.Store.Orgs() {
+		var at time.Duration
+		if spread > 0 {
+			at = time.Duration(rand.Int63n(int64(spread)))
+		}
+		jobs = append(jobs, job{org, at})
+	}
+	slices.SortFunc(jobs, func(a, b job) bool { return a.at < b.at })
+
+	start := time.Now()
+	var g errgroup.Group
+	g.SetLimit(refreshConcurrency)
+	for _, j := range jobs {
+		if d := time.Until(start.Add(j.at)); d > 0 {
+			t := time.NewTimer(d)
+			select {
+			case <-ctx.Done():
+				t.Stop()
+			case <-t.C:
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		org := j.org
+		g.Go(func() error {
+			h.refreshOrg(ctx, org)
+			return nil
+		})
+	}
+	g.Wait()
+}
+
+func (h *Handler) refreshOrg(ctx context.Context, org string) {
+	var pp *apitypes.PhaseResponse
+	if p, err := h.lookupPhase(ctx, org); err != nil {
+		h.Logf("store: refreshing phase of %q: %v", org, err)
+	} else {
+		pp = &p
+	}
+	var up *apitypes.UsageResponse
+	if u, err := h.lookupLimits(ctx, org); err != nil {
+		h.Logf("store: refreshing limits of %q: %v", org, err)
+	} else {
+		up = &u
+	}
+	h.Store.refresh(org, pp, up)
+}
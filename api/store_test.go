@@ -0,0 +1,306 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+	"tier.run/api/apitypes"
+	"tier.run/control"
+	"tier.run/fetch"
+	"tier.run/fetch/fetchtest"
+	"tier.run/stripe/stripefake"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sc := stripefake.Client(t)
+	tc := &control.Client{Stripe: sc, Logf: t.Logf}
+
+	m := []control.Feature{{
+		FeaturePlan: mpf("feature:x@plan:test@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Mode:        "graduated",
+		Aggregate:   "sum",
+		Tiers:       []control.Tier{{Upto: 10}},
+	}}
+	if err := tc.Push(ctx, m, func(f control.Feature, err error) {
+		if err != nil {
+			t.Fatalf("error pushing %q: %v", f.FeaturePlan, err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SubscribeTo(ctx, "org:test", control.FeaturePlans(m)); err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "store.json")
+	var s *Store
+	start := func() *http.Client {
+		t.Helper()
+		if s != nil {
+			// Restart as serve does, saving the store on the way out.
+			if err := s.Close(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var err error
+		s, err = OpenStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h := NewHandler(tc, t.Logf)
+		h.helper = t.Helper
+		h.Store = s
+		return fetchtest.NewTLSServer(t, h.ServeHTTP)
+	}
+	lookup := func(c *http.Client) (apitypes.PhaseResponse, apitypes.UsageResponse) {
+		t.Helper()
+		p, err := fetch.OK[apitypes.PhaseResponse, *apitypes.Error](ctx, c, "GET", "/v1/phase?org=org:test", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := fetch.OK[apitypes.UsageResponse, *apitypes.Error](ctx, c, "GET", "/v1/limits?org=org:test", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return p, u
+	}
+
+	wantPhase, wantLimits := lookup(start())
+	if len(wantLimits.Usage) > 0 && wantLimits.Usage[0].PeriodEnd == nil {
+		t.Error("unexpected nil period end")
+	}
+	diff.Test(t, t.Errorf, wantLimits.Usage, []apitypes.Usage{{
+		Feature: mpn("feature:x"),
+		Limit:   10,
+	}}, diff.ZeroFields[apitypes.Usage]("PeriodStart", "PeriodEnd"))
+
+	// Take Stripe down, and restart.
+	sc.HTTPClient = &http.Client{
+		Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("network down")
+		}),
+	}
+	c := start()
+	gotPhase, gotLimits := lookup(c)
+	diff.Test(t, t.Errorf, gotPhase, wantPhase)
+	diff.Test(t, t.Errorf, gotLimits, wantLimits)
+
+	// Orgs never served are not in the store.
+	_, err := fetch.OK[apitypes.UsageResponse, *apitypes.Error](ctx, c, "GET", "/v1/limits?org=org:other", nil)
+	if err == nil {
+		t.Error("expected error for org not in store")
+	}
+}
+
+func TestStoreSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.saveDelay = time.Hour
+
+	// Changes are not saved on the request path.
+	s.putPhase("org:a", apitypes.PhaseResponse{Interval: "@monthly"})
+	s.putPhase("org:b", apitypes.PhaseResponse{Interval: "@monthly"})
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("store saved before flush: %v", err)
+	}
+	if err := s.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	reopened, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, reopened.Orgs(), []string{"org:a", "org:b"})
+
+	// Changes are saved in the background.
+	s.saveDelay = 0
+	s.putPhase("org:c", apitypes.PhaseResponse{Interval: "@monthly"})
+	for i := 0; ; i++ {
+		reopened, err := OpenStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(reopened.Orgs()) == 3 {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("orgs = %q; want org:c saved in the background", reopened.Orgs())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreAnonymousTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.saveDelay = time.Hour
+	s.putUsage("anon:a", mpn("feature:x"), 1, false)
+	s.putUsage("anon:b", mpn("feature:x"), 2, false)
+	s.putPhase("org:a", apitypes.PhaseResponse{Interval: "@monthly"})
+
+	// Age all entries past the TTL; only those of anonymous subjects
+	// expire.
+	s.mu.Lock()
+	for org, e := range s.orgs {
+		e.Changed = e.Changed.Add(-2 * s.AnonymousTTL)
+		s.orgs[org] = e
+	}
+	s.mu.Unlock()
+
+	if used := s.lookup("anon:a").Used; used != nil {
+		t.Errorf("used = %v; want expired", used)
+	}
+	s.putUsage("anon:a", mpn("feature:x"), 1, false) // starts over
+	s.expire(time.Now())
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, s.lookup("anon:a").Used, map[string]int{"feature:x": 1})
+	if used := s.lookup("anon:b").Used; used != nil {
+		t.Errorf("anon:b used = %v; want expired", used)
+	}
+	diff.Test(t, t.Errorf, s.Orgs(), []string{"org:a"})
+}
+
+func TestStoreOrgTTL(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	s, err := OpenStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.saveDelay = time.Hour
+	defer s.Close()
+
+	monthly := apitypes.PhaseResponse{Interval: "@monthly"}
+	s.putPhase("org:a", monthly)
+	s.putPhase("org:b", monthly)
+	s.putUsage("anon:a", mpn("feature:x"), 1, false)
+	served := func(org string) time.Time {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.orgs[org].Served
+	}
+	age := func(org string, d time.Duration) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		e := s.orgs[org]
+		e.Changed = e.Changed.Add(-d)
+		e.Served = e.Served.Add(-d)
+		s.orgs[org] = e
+	}
+
+	// Serving an org again soon after does not change its entry.
+	a := served("org:a")
+	if a.IsZero() {
+		t.Fatal("org:a not marked served")
+	}
+	s.putPhase("org:a", monthly)
+	diff.Test(t, t.Errorf, served("org:a"), a)
+
+	// Refreshing an org is not serving it, and does not keep it.
+	age("org:a", 2*s.OrgTTL)
+	age("org:b", 2*s.OrgTTL)
+	age("anon:a", 2*s.OrgTTL)
+	s.refresh("org:a", &apitypes.PhaseResponse{Interval: "@yearly"}, nil)
+	s.putPhase("org:b", monthly) // served
+	s.expire(time.Now())
+	diff.Test(t, t.Errorf, s.Orgs(), []string{"org:b"})
+	if used := s.lookup("anon:a").Used; used == nil {
+		t.Error("anon:a expired with the OrgTTL; want AnonymousTTL")
+	}
+
+	// Refreshing an org no longer stored does not add it.
+	s.refresh("org:a", &monthly, nil)
+	diff.Test(t, t.Errorf, s.Orgs(), []string{"org:b"})
+}
+
+// countingProvider is a control.Provider looking up the same limits for
+// every org, recording the most lookups in flight at once.
+type countingProvider struct {
+	control.Provider
+
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+	lookups     int
+}
+
+func (p *countingProvider) LookupPhases(context.Context, string) ([]control.Phase, error) {
+	return nil, nil
+}
+
+func (p *countingProvider) LookupLimits(context.Context, string) ([]control.Usage, error) {
+	p.mu.Lock()
+	p.inFlight++
+	p.lookups++
+	if p.inFlight > p.maxInFlight {
+		p.maxInFlight = p.inFlight
+	}
+	p.mu.Unlock()
+	time.Sleep(5 * time.Millisecond)
+	p.mu.Lock()
+	p.inFlight--
+	p.mu.Unlock()
+	return []control.Usage{{Feature: mpf("feature:x@plan:test@0"), Limit: 10}}, nil
+}
+
+func TestRefreshStore(t *testing.T) {
+	s, err := OpenStore(filepath.Join(t.TempDir(), "store.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.saveDelay = time.Hour
+	defer s.Close()
+	const orgs = 20
+	for i := 0; i < orgs; i++ {
+		s.putLimits(fmt.Sprintf("org:%d", i), apitypes.UsageResponse{})
+	}
+
+	p := &countingProvider{}
+	h := NewHandler(p, t.Logf)
+	h.Store = s
+	h.refreshStore(context.Background(), 20*time.Millisecond)
+	if p.lookups != orgs {
+		t.Errorf("lookups = %d; want %d", p.lookups, orgs)
+	}
+	if p.maxInFlight > refreshConcurrency {
+		t.Errorf("max lookups at once = %d; want at most %d", p.maxInFlight, refreshConcurrency)
+	}
+	diff.Test(t, t.Errorf, s.lookup("org:3").Limits.Usage, []apitypes.Usage{{
+		Feature: mpn("feature:x"),
+		Limit:   10,
+	}})
+}
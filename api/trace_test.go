@@ -0,0 +1,78 @@
+package api
+
+import (
+	"context"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/api/apitypes"
+	"tier.run/control"
+	"tier.run/fetch"
+	"tier.run/fetch/fetchtest"
+	"tier.run/stripe/stripefake"
+	"tier.run/trace/tracetest"
+)
+
+func TestTracing(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	sc := stripefake.Client(t)
+	tc := &control.Client{Stripe: sc, Logf: t.Logf}
+
+	m := []control.Feature{{
+		FeaturePlan: mpf("feature:x@plan:test@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}}
+	if err := tc.Push(ctx, m, func(f control.Feature, err error) {
+		if err != nil {
+			t.Fatalf("error pushing %q: %v", f.FeaturePlan, err)
+		}
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SubscribeTo(ctx, "org:test", control.FeaturePlans(m)); err != nil {
+		t.Fatal(err)
+	}
+
+	var r tracetest.Recorder
+	sc.Tracer = &r
+	tc.Tracer = &r
+	h := NewHandler(tc, t.Logf)
+	h.helper = t.Helper
+	h.Tracer = &r
+	c := fetchtest.NewTLSServer(t, h.ServeHTTP)
+
+	if _, err := fetch.OK[apitypes.UsageResponse, *apitypes.Error](ctx, c, "GET", "/v1/limits?org=org:test", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	spans := r.Spans()
+	if len(spans) < 3 {
+		t.Fatalf("got %d spans; want at least 3", len(spans))
+	}
+	for _, s := range spans {
+		if !s.Ended {
+			t.Errorf("span %q not ended", s.Name)
+		}
+	}
+	diff.Test(t, t.Errorf, spans[:2], []tracetest.Span{{
+		Name:  "api /v1/limits",
+		Attrs: map[string]any{"http.method": "GET", "tier.org": "org:test"},
+		Ended: true,
+	}, {
+		Name:   "control.LookupLimits",
+		Parent: "api /v1/limits",
+		Attrs:  map[string]any{"tier.org": "org:test"},
+		Ended:  true,
+	}})
+	for _, s := range spans[2:] {
+		if s.Name != "stripe GET" || s.Parent != "control.LookupLimits" {
+			t.Errorf("got span %q in %q; want stripe GET in control.LookupLimits", s.Name, s.Parent)
+		}
+		if s.Attrs["stripe.request_id"] == "" || s.Attrs["http.status_code"] != 200 {
+			t.Errorf("span %q: attrs = %v; want request ID and status", s.Name, s.Attrs)
+		}
+	}
+}
@@ -7,7 +7,12 @@ package tier
 import (
 	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"tier.run/api/apitypes"
@@ -19,7 +24,12 @@ const Inf = 1<<63 - 1
 
 type Client struct {
 	HTTPClient *http.Client
-	sidecar    string
+
+	// Account, if set, names the Stripe account requests are made for,
+	// for sidecars serving many accounts.
+	Account string
+
+	sidecar string
 }
 
 func NewTierSidecarClient(sidecarBase string) *Client {
@@ -30,10 +40,32 @@ func NewTierSidecarClient(sidecarBase string) *Client {
 }
 
 func (c *Client) client() *http.Client {
-	if c.HTTPClient == nil {
-		return http.DefaultClient
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	if c.Account == "" {
+		return hc
+	}
+	ahc := *hc
+	ahc.Transport = &accountTransport{account: c.Account, base: hc.Transport}
+	return &ahc
+}
+
+// accountTransport sets the Tier-Account header of each request.
+type accountTransport struct {
+	account string
+	base    http.RoundTripper // if nil, http.DefaultTransport is used
+}
+
+func (t *accountTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context()) // per RoundTrip contract
+	r.Header.Set("Tier-Account", t.account)
+	base := t.base
+	if base == nil {
+		base = http.DefaultTransport
 	}
-	return c.HTTPClient
+	return base.RoundTrip(r)
 }
 
 // Push pushes the provided pricing model to Stripe.
@@ -41,15 +73,65 @@ func (c *Client) Push(ctx context.Context, m apitypes.Model) (apitypes.PushRespo
 	return fetch.OK[apitypes.PushResponse, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/push", m)
 }
 
+// PushDryRun reports what Push would do with m, without making any changes
+// in Stripe.
+func (c *Client) PushDryRun(ctx context.Context, m apitypes.Model) (apitypes.PushResponse, error) {
+	return fetch.OK[apitypes.PushResponse, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/push?dryrun=true", m)
+}
+
 func (c *Client) PushJSON(ctx context.Context, m []byte) (apitypes.PushResponse, error) {
 	return fetch.OK[apitypes.PushResponse, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/push", json.RawMessage(m))
 }
 
+// Validate checks m for problems before it is pushed, without making any
+// changes in Stripe.
+func (c *Client) Validate(ctx context.Context, m apitypes.Model) (apitypes.ValidateResponse, error) {
+	return fetch.OK[apitypes.ValidateResponse, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/validate", m)
+}
+
+// Diff reports how m differs from the pricing model in Stripe, as a preview
+// of what Push would do.
+func (c *Client) Diff(ctx context.Context, m apitypes.Model) (apitypes.DiffResponse, error) {
+	return fetch.OK[apitypes.DiffResponse, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/diff", m)
+}
+
 // Pull fetches the complete pricing model from Stripe.
 func (c *Client) Pull(ctx context.Context) (apitypes.Model, error) {
 	return fetch.OK[apitypes.Model, *apitypes.Error](ctx, c.client(), "GET", c.sidecar+"/v1/pull", nil)
 }
 
+// PullOptions selects the part of the pricing model pulled by
+// PullWithOptions. The zero value selects the same model as Pull.
+type PullOptions struct {
+	Plan          string // if set, only features in the plan, such as "plan:pro@1"
+	FeaturePrefix string // if set, only features with names starting with it, such as "feature:api:"
+	Tag           string // if set, only features in plans with the tag
+	Archived      bool   // include archived plans
+}
+
+// PullWithOptions is like Pull, but only fetches the part of the pricing
+// model selected by opts.
+func (c *Client) PullWithOptions(ctx context.Context, opts PullOptions) (apitypes.Model, error) {
+	q := url.Values{}
+	if opts.Plan != "" {
+		q.Set("plan", opts.Plan)
+	}
+	if opts.FeaturePrefix != "" {
+		q.Set("prefix", opts.FeaturePrefix)
+	}
+	if opts.Tag != "" {
+		q.Set("tag", opts.Tag)
+	}
+	if opts.Archived {
+		q.Set("archived", "true")
+	}
+	path := "/v1/pull"
+	if len(q) > 0 {
+		path += "?" + q.Encode()
+	}
+	return fetch.OK[apitypes.Model, *apitypes.Error](ctx, c.client(), "GET", c.sidecar+path, nil)
+}
+
 // PullJSON fetches the complete pricing model from Stripe and returns the raw
 // JSON response.
 func (c *Client) PullJSON(ctx context.Context) ([]byte, error) {
@@ -73,31 +155,53 @@ func (c *Client) LookupPhase(ctx context.Context, org string) (apitypes.PhaseRes
 	return fetch.OK[apitypes.PhaseResponse, *apitypes.Error](ctx, c.client(), "GET", c.sidecar+"/v1/phase?org="+org, nil)
 }
 
+// LookupPhases reports the timeline of the phases of the provided org: those
+// past, current, and scheduled. If all is true, phases of canceled
+// subscriptions are included.
+func (c *Client) LookupPhases(ctx context.Context, org string, all bool) (apitypes.PhasesResponse, error) {
+	v := url.Values{"org": {org}}
+	if all {
+		v.Set("all", "true")
+	}
+	return fetch.OK[apitypes.PhasesResponse, *apitypes.Error](ctx, c.client(), "GET", c.sidecar+"/v1/phases?"+v.Encode(), nil)
+}
+
 // LookupLimits reports the current usage and limits for the provided org.
 func (c *Client) LookupLimits(ctx context.Context, org string) (apitypes.UsageResponse, error) {
 	return fetch.OK[apitypes.UsageResponse, *apitypes.Error](ctx, c.client(), "GET", c.sidecar+"/v1/limits?org="+org, nil)
 }
 
 // LookupLimit reports the current usage and limits for the provided org and
-// feature. If the feature is not currently available to the org, both limit
-// and used are zero and no error is reported.
+// feature. If the feature is not currently available to the org, the limit
+// and usage of its nearest available ancestor, if any, are reported; see
+// refs.Name.Ancestors. Otherwise, both limit and used are zero and no error
+// is reported.
 //
 // It reports an error if any.
 func (c *Client) LookupLimit(ctx context.Context, org, feature string) (limit, used int, err error) {
-	fn, err := refs.ParseName(feature)
+	_, limit, used, err = c.lookupLimit(ctx, org, feature)
+	return limit, used, err
+}
+
+// lookupLimit is like LookupLimit, but also reports the name of the feature
+// the limit is defined on.
+func (c *Client) lookupLimit(ctx context.Context, org, feature string) (fn refs.Name, limit, used int, err error) {
+	fn, err = refs.ParseName(feature)
 	if err != nil {
-		return 0, 0, err
+		return refs.Name{}, 0, 0, err
 	}
 	limits, err := c.LookupLimits(ctx, org)
 	if err != nil {
-		return 0, 0, err
+		return refs.Name{}, 0, 0, err
 	}
-	for _, u := range limits.Usage {
-		if u.Feature == fn {
-			return u.Limit, u.Used, nil
+	for _, n := range append([]refs.Name{fn}, fn.Ancestors()...) {
+		for _, u := range limits.Usage {
+			if u.Feature == n {
+				return n, u.Limit, u.Used, nil
+			}
 		}
 	}
-	return 0, 0, nil
+	return fn, 0, 0, nil
 }
 
 // An Answer is the response to any question for Can. It can be used in a few
@@ -145,8 +249,19 @@ func (c Answer) ReportN(n int) error {
 //	}
 //	defer ans.Report() // or ReportN
 //	return convert(temp)
+//
+// Limits defined on an ancestor of feature apply if feature itself is not
+// available to the org, in which case usage is reported to the ancestor.
+//
+// The feature may be a pattern, such as "feature:reports:*", to check a
+// group of features at once; see refs.Pattern. The answer is OK if any
+// feature in the group is available to the org and under its limit. Usage
+// cannot be reported for a pattern, so Report returns an error.
 func (c *Client) Can(ctx context.Context, org, feature string) Answer {
-	limit, used, err := c.LookupLimit(ctx, org, feature)
+	if strings.Contains(feature, "*") {
+		return c.canAny(ctx, org, feature)
+	}
+	fn, limit, used, err := c.lookupLimit(ctx, org, feature)
 	if err != nil {
 		// TODO(bmizerany): caching of usage and limits in imminent and
 		// the cache can be consulted before failing to "allow by
@@ -157,11 +272,33 @@ func (c *Client) Can(ctx context.Context, org, feature string) Answer {
 		return Answer{}
 	}
 	report := func(n int) error {
-		return c.Report(ctx, org, feature, 1)
+		// Report to the feature the limit is defined on, which may be
+		// an ancestor of feature.
+		return c.Report(ctx, org, fn.String(), 1)
 	}
 	return Answer{ok: true, report: report}
 }
 
+func (c *Client) canAny(ctx context.Context, org, pattern string) Answer {
+	p, err := refs.ParsePattern(pattern)
+	if err != nil {
+		return Answer{ok: true, err: err}
+	}
+	limits, err := c.LookupLimits(ctx, org)
+	if err != nil {
+		return Answer{ok: true, err: err}
+	}
+	report := func(int) error {
+		return fmt.Errorf("tier: cannot report usage for pattern %q", pattern)
+	}
+	for _, u := range limits.Usage {
+		if p.Match(u.Feature) && u.Used < u.Limit {
+			return Answer{ok: true, report: report}
+		}
+	}
+	return Answer{}
+}
+
 // Report reports a usage of n for the provided org and feature at the current
 // time.
 func (c *Client) Report(ctx context.Context, org, feature string, n int) error {
@@ -184,6 +321,126 @@ func (c *Client) ReportUsage(ctx context.Context, r apitypes.ReportRequest) erro
 	return err
 }
 
+// SetRollout enables the feature plan featurePlan, such as
+// "feature:x@plan:pro@1", for percent of the orgs on its plan, from 1 to
+// 100, or for all of them if percent is zero. Orgs the feature is not
+// enabled for have a limit of zero, so Can reports false for them.
+func (c *Client) SetRollout(ctx context.Context, featurePlan string, percent int) error {
+	fp, err := refs.ParseFeaturePlan(featurePlan)
+	if err != nil {
+		return err
+	}
+	_, err = fetch.OK[struct{}, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/rollout", apitypes.RolloutRequest{
+		Feature: fp,
+		Percent: percent,
+	})
+	return err
+}
+
+// Migrate moves the orgs subscribed to plan r.From to plan r.To, calling f
+// with the result for each org as it is migrated. Orgs that fail to migrate
+// are reported to f with an error, and the migration continues. If the
+// migration stops early, or f returns an error, Migrate returns it.
+func (c *Client) Migrate(ctx context.Context, r apitypes.MigrateRequest, f func(apitypes.MigrateResult) error) error {
+	res, err := fetch.OK[*http.Response, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/migrate", r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	dec := json.NewDecoder(res.Body)
+	for {
+		var mr apitypes.MigrateResult
+		if err := dec.Decode(&mr); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if mr.Org == "" {
+			return fmt.Errorf("tier: migration stopped: %s", mr.Error)
+		}
+		if err := f(mr); err != nil {
+			return err
+		}
+	}
+}
+
+// SetParent makes parent the parent of org, so that the usage org reports
+// is billed to the subscription of parent, and broken down by org in the
+// limits of parent. If parent is empty, org is detached from its parent.
+func (c *Client) SetParent(ctx context.Context, org, parent string) error {
+	_, err := fetch.OK[struct{}, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/parent", apitypes.ParentRequest{
+		Org:    org,
+		Parent: parent,
+	})
+	return err
+}
+
+// CheckoutParams are the parameters of Checkout.
+type CheckoutParams struct {
+	Features              []string // plans or features, as in Phase.Features
+	TrialDays             int
+	CancelURL             string // defaults to the success URL
+	RequireBillingAddress bool
+}
+
+// Checkout returns the URL of a Stripe Checkout page where org subscribes
+// to p.Features by entering its payment details, after which it is sent to
+// successURL. If p.Features is empty, the page only collects payment
+// details, for org to be subscribed later.
+func (c *Client) Checkout(ctx context.Context, org, successURL string, p *CheckoutParams) (apitypes.CheckoutResponse, error) {
+	if p == nil {
+		p = &CheckoutParams{}
+	}
+	return fetch.OK[apitypes.CheckoutResponse, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/checkout", &apitypes.CheckoutRequest{
+		Org:                   org,
+		SuccessURL:            successURL,
+		CancelURL:             p.CancelURL,
+		Features:              p.Features,
+		TrialDays:             p.TrialDays,
+		RequireBillingAddress: p.RequireBillingAddress,
+	})
+}
+
+// Cancel cancels the subscription of org, immediately, or at the end of its
+// current billing period if atPeriodEnd is true. Scheduling the org again
+// before then resumes it.
+func (c *Client) Cancel(ctx context.Context, org string, atPeriodEnd bool) error {
+	_, err := fetch.OK[struct{}, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/cancel", apitypes.CancelRequest{
+		Org:         org,
+		AtPeriodEnd: atPeriodEnd,
+	})
+	return err
+}
+
+// Pause pauses collecting payments for the subscription of org until Resume
+// is called. Invoices of org are voided while it is paused.
+func (c *Client) Pause(ctx context.Context, org string) error {
+	_, err := fetch.OK[struct{}, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/pause", apitypes.PauseRequest{Org: org})
+	return err
+}
+
+// Resume resumes collecting payments for the subscription of org.
+func (c *Client) Resume(ctx context.Context, org string) error {
+	_, err := fetch.OK[struct{}, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/resume", apitypes.PauseRequest{Org: org})
+	return err
+}
+
+// MergeAnonymous reports the usage of the anonymous subject anonymous, such
+// as "anon:device-1234", to org, which it signed up as, and forgets the
+// subject. Org should be subscribed first, since usage of features org is
+// not subscribed to is dropped.
+func (c *Client) MergeAnonymous(ctx context.Context, anonymous, org string) error {
+	_, err := fetch.OK[struct{}, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/anonymous/merge", apitypes.MergeAnonymousRequest{
+		Anonymous: anonymous,
+		Org:       org,
+	})
+	return err
+}
+
 // Subscribe subscribes the provided org to the provided feature or plan,
 // effective immediately.
 //
@@ -197,12 +454,26 @@ func (c *Client) Subscribe(ctx context.Context, org string, featuresAndPlans ...
 	return err
 }
 
+// SubscribeToInterval is like Subscribe, but bills each feature using its
+// variant for interval (e.g. "@yearly").
+func (c *Client) SubscribeToInterval(ctx context.Context, org, interval string, featuresAndPlans ...string) error {
+	_, err := fetch.OK[struct{}, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/subscribe", apitypes.ScheduleRequest{
+		Org:    org,
+		Phases: []apitypes.Phase{{Features: featuresAndPlans, Interval: interval}},
+	})
+	return err
+}
+
 type Phase = apitypes.Phase
 type OrgInfo = apitypes.OrgInfo
 
 type ScheduleParams struct {
 	Info   *OrgInfo
 	Phases []Phase
+
+	// AtPeriodEnd, if true, makes the first phase effective at the end of
+	// the org's current billing period, instead of now.
+	AtPeriodEnd bool
 }
 
 func (c *Client) Schedule(ctx context.Context, org string, p *ScheduleParams) error {
@@ -210,6 +481,8 @@ func (c *Client) Schedule(ctx context.Context, org string, p *ScheduleParams) er
 		Org:    org,
 		Info:   (*apitypes.OrgInfo)(p.Info),
 		Phases: copyPhases(p.Phases),
+
+		AtPeriodEnd: p.AtPeriodEnd,
 	})
 	return err
 }
@@ -222,6 +495,192 @@ func copyPhases(phases []Phase) []apitypes.Phase {
 	return c
 }
 
+// CreateClock creates a new test clock with the provided name, frozen at
+// start. Test clocks are only available in test mode.
+func (c *Client) CreateClock(ctx context.Context, name string, start time.Time) (apitypes.ClockResponse, error) {
+	return fetch.OK[apitypes.ClockResponse, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/clock", apitypes.ClockRequest{
+		Name:    name,
+		Present: start,
+	})
+}
+
+// AdvanceClock advances the test clock with the provided id to t. The clock
+// advances asynchronously; use SyncClock to check when its status is "ready".
+func (c *Client) AdvanceClock(ctx context.Context, id string, t time.Time) (apitypes.ClockResponse, error) {
+	return fetch.OK[apitypes.ClockResponse, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/clock", apitypes.ClockRequest{
+		ID:      id,
+		Present: t,
+	})
+}
+
+// AdvanceClockAndWait is like AdvanceClock, but returns once the clock has
+// finished advancing, such as past the end of a billing period.
+func (c *Client) AdvanceClockAndWait(ctx context.Context, id string, t time.Time) (apitypes.ClockResponse, error) {
+	return fetch.OK[apitypes.ClockResponse, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/clock", apitypes.ClockRequest{
+		ID:      id,
+		Present: t,
+		Wait:    true,
+	})
+}
+
+// AttachClock creates org, with info if not nil, attached to the test clock
+// with the provided id, so that its subscriptions follow the clock as it
+// advances. Only new orgs can be attached to clocks.
+func (c *Client) AttachClock(ctx context.Context, id, org string, info *apitypes.OrgInfo) error {
+	_, err := fetch.OK[struct{}, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/clock/attach", apitypes.ClockAttachRequest{
+		ID:   id,
+		Org:  org,
+		Info: info,
+	})
+	return err
+}
+
+// DeleteClock deletes the test clock with the provided id, along with the
+// orgs attached to it.
+func (c *Client) DeleteClock(ctx context.Context, id string) error {
+	_, err := fetch.OK[struct{}, *apitypes.Error](ctx, c.client(), "DELETE", c.sidecar+"/v1/clock?id="+url.QueryEscape(id), nil)
+	return err
+}
+
+// SyncClock reports the current state of the test clock with the provided id.
+func (c *Client) SyncClock(ctx context.Context, id string) (apitypes.ClockResponse, error) {
+	return fetch.OK[apitypes.ClockResponse, *apitypes.Error](ctx, c.client(), "GET", c.sidecar+"/v1/clock?id="+url.QueryEscape(id), nil)
+}
+
+// Audit reports the billing-affecting operations recorded in the sidecar's
+// audit log for org, or for all orgs if org is empty, newest first.
+func (c *Client) Audit(ctx context.Context, org string) (apitypes.AuditResponse, error) {
+	return fetch.OK[apitypes.AuditResponse, *apitypes.Error](ctx, c.client(), "GET", c.sidecar+"/v1/audit?org="+url.QueryEscape(org), nil)
+}
+
+// LookupInvoices reports the invoices of org, newest first.
+// ListOrgsParams selects the page of orgs listed by ListOrgs.
+type ListOrgsParams struct {
+	Limit         int    // the most orgs to list, from 1 to 100; 100 if zero
+	StartingAfter string // the Next cursor of the page before, if any
+
+	// CreatedAfter and CreatedBefore, if not zero, list only the orgs
+	// created at or after CreatedAfter, and before CreatedBefore.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	// Metadata, if not empty, lists only the orgs with each key in
+	// Metadata set to its value.
+	Metadata map[string]string
+}
+
+// ListOrgs lists a page of orgs, newest first, as selected by p. To list
+// the next page, call ListOrgs again with p.StartingAfter set to the Next
+// cursor of the response, until it is empty.
+func (c *Client) ListOrgs(ctx context.Context, p ListOrgsParams) (apitypes.OrgsResponse, error) {
+	v := url.Values{}
+	if p.Limit != 0 {
+		v.Set("limit", strconv.Itoa(p.Limit))
+	}
+	if p.StartingAfter != "" {
+		v.Set("starting_after", p.StartingAfter)
+	}
+	if !p.CreatedAfter.IsZero() {
+		v.Set("created_after", p.CreatedAfter.Format(time.RFC3339))
+	}
+	if !p.CreatedBefore.IsZero() {
+		v.Set("created_before", p.CreatedBefore.Format(time.RFC3339))
+	}
+	for k, val := range p.Metadata {
+		v.Set("metadata["+k+"]", val)
+	}
+	return fetch.OK[apitypes.OrgsResponse, *apitypes.Error](ctx, c.client(), "GET", c.sidecar+"/v1/orgs?"+v.Encode(), nil)
+}
+
+func (c *Client) LookupInvoices(ctx context.Context, org string) (apitypes.InvoicesResponse, error) {
+	return fetch.OK[apitypes.InvoicesResponse, *apitypes.Error](ctx, c.client(), "GET", c.sidecar+"/v1/invoices?org="+url.QueryEscape(org), nil)
+}
+
+// FinalizeInvoice finalizes the draft invoice id of org, so that it may be
+// paid.
+func (c *Client) FinalizeInvoice(ctx context.Context, org, id string) (apitypes.Invoice, error) {
+	return c.invoiceAction(ctx, "finalize", org, id)
+}
+
+// VoidInvoice voids the open invoice id of org, so that it can no longer
+// be paid.
+func (c *Client) VoidInvoice(ctx context.Context, org, id string) (apitypes.Invoice, error) {
+	return c.invoiceAction(ctx, "void", org, id)
+}
+
+// MarkInvoiceUncollectible marks the open invoice id of org as not
+// expected to be paid.
+func (c *Client) MarkInvoiceUncollectible(ctx context.Context, org, id string) (apitypes.Invoice, error) {
+	return c.invoiceAction(ctx, "mark_uncollectible", org, id)
+}
+
+func (c *Client) invoiceAction(ctx context.Context, action, org, id string) (apitypes.Invoice, error) {
+	return fetch.OK[apitypes.Invoice, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/invoices/"+action, apitypes.InvoiceRequest{
+		Org: org,
+		ID:  id,
+	})
+}
+
+// Refund refunds part or all of a charge or paid invoice, as described by
+// rr.
+func (c *Client) Refund(ctx context.Context, rr apitypes.RefundRequest) (apitypes.Refund, error) {
+	return fetch.OK[apitypes.Refund, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/refunds", rr)
+}
+
+// CreateCreditNote credits part or all of an open or paid invoice, as
+// described by cr.
+func (c *Client) CreateCreditNote(ctx context.Context, cr apitypes.CreditNoteRequest) (apitypes.CreditNote, error) {
+	return fetch.OK[apitypes.CreditNote, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/credit_notes", cr)
+}
+
+// AdjustCredit grants credit to an org, or deducts it, as described by cr.
+// The org's credit balance is reported by LookupOrg.
+func (c *Client) AdjustCredit(ctx context.Context, cr apitypes.CreditRequest) (apitypes.CreditTransaction, error) {
+	return fetch.OK[apitypes.CreditTransaction, *apitypes.Error](ctx, c.client(), "POST", c.sidecar+"/v1/credit", cr)
+}
+
+// Revenue reports the monthly and annual recurring revenue of all
+// subscriptions by currency, and the revenue and subscribers of each plan.
+func (c *Client) Revenue(ctx context.Context) (apitypes.RevenueResponse, error) {
+	return fetch.OK[apitypes.RevenueResponse, *apitypes.Error](ctx, c.client(), "GET", c.sidecar+"/v1/reports/revenue", nil)
+}
+
+// StreamEvents calls f with each of the recent lifecycle events of org, or
+// of all orgs if org is empty, oldest first, as kept by a sidecar serving
+// events. If follow is true, it then calls f with each event as it
+// happens, until ctx is done or the sidecar shuts down. If f returns an
+// error, StreamEvents stops and returns it.
+func (c *Client) StreamEvents(ctx context.Context, org string, follow bool, f func(apitypes.Event) error) error {
+	q := url.Values{}
+	if org != "" {
+		q.Set("org", org)
+	}
+	if follow {
+		q.Set("follow", "true")
+	}
+	res, err := fetch.OK[*http.Response, *apitypes.Error](ctx, c.client(), "GET", c.sidecar+"/v1/events?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	dec := json.NewDecoder(res.Body)
+	for {
+		var e apitypes.Event
+		if err := dec.Decode(&e); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+		if err := f(e); err != nil {
+			return err
+		}
+	}
+}
+
 func (c *Client) WhoAmI(ctx context.Context) (apitypes.WhoAmIResponse, error) {
 	return fetch.OK[apitypes.WhoAmIResponse, *apitypes.Error](ctx, c.client(), "GET", c.sidecar+"/v1/whoami", nil)
 }
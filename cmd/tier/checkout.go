@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"tier.run/client/tier"
+)
+
+// runCheckout runs the checkout subcommand with args, printing the URL of a
+// Stripe Checkout page for an org.
+func runCheckout(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("checkout", flag.ExitOnError)
+	successURL := fs.String("success-url", "", "where to send the org after it completes checkout (required)")
+	cancelURL := fs.String("cancel-url", "", "where to send the org if it leaves checkout (default the success URL)")
+	trialDays := fs.Int("trial-days", 0, "begin with a free trial of this many days")
+	billingAddress := fs.Bool("require-billing-address", false, "collect the billing address of the org")
+	pos, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) == 0 {
+		return errUsage
+	}
+	if *successURL == "" {
+		return errors.New("--success-url is required")
+	}
+	org := pos[0]
+	vlogf("creating checkout for %s to %v", org, pos[1:])
+	r, err := tc().Checkout(ctx, org, *successURL, &tier.CheckoutParams{
+		Features:              pos[1:],
+		TrialDays:             *trialDays,
+		CancelURL:             *cancelURL,
+		RequireBillingAddress: *billingAddress,
+	})
+	if err != nil {
+		return err
+	}
+	if *flagJSON {
+		return printJSON(r)
+	}
+	fmt.Fprintln(stdout, r.URL)
+	return nil
+}
+
+// parseInterspersed parses the flags in args with fs, allowing them to
+// follow positional arguments, which it returns in order.
+func parseInterspersed(fs *flag.FlagSet, args []string) ([]string, error) {
+	var pos []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+		if fs.NArg() == 0 {
+			return pos, nil
+		}
+		pos = append(pos, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
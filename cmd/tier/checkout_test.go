@@ -0,0 +1,22 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	"kr.dev/diff"
+)
+
+func TestParseInterspersed(t *testing.T) {
+	fs := flag.NewFlagSet("checkout", flag.ContinueOnError)
+	success := fs.String("success-url", "", "")
+	days := fs.Int("trial-days", 0, "")
+	pos, err := parseInterspersed(fs, []string{"--trial-days=7", "org:a", "plan:pro@0", "--success-url", "https://example.com", "plan:team@1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, pos, []string{"org:a", "plan:pro@0", "plan:team@1"})
+	if *success != "https://example.com" || *days != 7 {
+		t.Errorf("success-url, trial-days = %q, %d; want https://example.com, 7", *success, *days)
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"tier.run/control"
+	"tier.run/stripe"
+)
+
+// runClock runs the clock subcommand in args, which manages the test
+// clocks of named test environments.
+func runClock(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	fs := flag.NewFlagSet("clock "+args[0], flag.ExitOnError)
+	switch args[0] {
+	case "new":
+		start := fs.String("start", "", "the time to start the clock at, in RFC 3339 format (default now)")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errUsage
+		}
+		name := fs.Arg(0)
+		t := time.Now()
+		if *start != "" {
+			var err error
+			t, err = time.Parse(time.RFC3339, *start)
+			if err != nil {
+				return fmt.Errorf("--start: %w", err)
+			}
+		}
+		_, err := cc().LookupClock(ctx, name)
+		if err == nil {
+			return fmt.Errorf("test clock %q already exists", name)
+		}
+		if !errors.Is(err, control.ErrClockNotFound) {
+			return err
+		}
+		c, err := cc().CreateClock(ctx, name, t)
+		if err != nil {
+			return err
+		}
+		return printClock(c)
+	case "advance":
+		noWait := fs.Bool("no-wait", false, "return without waiting for the clock to finish advancing")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 2 {
+			return errUsage
+		}
+		c, err := cc().LookupClock(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		t, err := parseClockTime(c.Present, fs.Arg(1))
+		if err != nil {
+			return err
+		}
+		c, err = cc().AdvanceClock(ctx, c.ID, t)
+		if err != nil {
+			return err
+		}
+		if !*noWait {
+			c, err = cc().WaitClock(ctx, c.ID)
+			if err != nil {
+				return err
+			}
+		}
+		return printClock(c)
+	case "status":
+		if len(args) != 2 {
+			return errUsage
+		}
+		c, err := cc().LookupClock(ctx, args[1])
+		if err != nil {
+			return err
+		}
+		return printClock(c)
+	case "attach":
+		email := fs.String("email", "", "sets the customer email address")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 2 {
+			return errUsage
+		}
+		c, err := cc().LookupClock(ctx, fs.Arg(0))
+		if err != nil {
+			return err
+		}
+		return cc().AttachClock(ctx, fs.Arg(1), c.ID, &control.OrgInfo{Email: *email})
+	default:
+		return errUsage
+	}
+}
+
+// parseClockTime parses s as the time to advance a clock at present to,
+// either in RFC 3339 format, or relative to present as a plus sign
+// followed by a number of days, such as "+30d", or a duration, such as
+// "+36h".
+func parseClockTime(present time.Time, s string) (time.Time, error) {
+	if !strings.HasPrefix(s, "+") {
+		return time.Parse(time.RFC3339, s)
+	}
+	rel := s[1:]
+	if strings.HasSuffix(rel, "d") {
+		n, err := strconv.Atoi(strings.TrimSuffix(rel, "d"))
+		if err != nil || n < 1 {
+			return time.Time{}, fmt.Errorf("invalid number of days %q", s)
+		}
+		return present.AddDate(0, 0, n), nil
+	}
+	d, err := time.ParseDuration(rel)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return present.Add(d), nil
+}
+
+func printClock(c control.Clock) error {
+	link, err := stripe.Link(cc().Live(), cc().Stripe.AccountID, "test-clocks", c.ID)
+	if err != nil {
+		return err
+	}
+	if *flagJSON {
+		return printJSON(struct {
+			ID      string    `json:"id"`
+			Name    string    `json:"name"`
+			Present time.Time `json:"present"`
+			Status  string    `json:"status"`
+			URL     string    `json:"url"`
+		}{c.ID, c.Name, c.Present, c.Status, link})
+	}
+	tw := newTabWriter()
+	defer tw.Flush()
+	fmt.Fprintf(tw, "ID:\t%v\n", c.ID)
+	fmt.Fprintf(tw, "Name:\t%v\n", c.Name)
+	fmt.Fprintf(tw, "Present:\t%v\n", c.Present.Format(time.RFC3339))
+	fmt.Fprintf(tw, "Status:\t%v\n", c.Status)
+	fmt.Fprintf(tw, "URL:\t%v\n", link)
+	return nil
+}
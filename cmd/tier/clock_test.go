@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseClockTime(t *testing.T) {
+	present := time.Date(2024, 1, 31, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		in   string
+		want time.Time
+	}{
+		{"2024-03-01T00:00:00Z", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)},
+		{"+30d", time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)},
+		{"+36h", time.Date(2024, 2, 2, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range cases {
+		got, err := parseClockTime(present, tt.in)
+		if err != nil || !got.Equal(tt.want) {
+			t.Errorf("parseClockTime(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "tomorrow", "+", "+0d", "+xd", "+1y"} {
+		if _, err := parseClockTime(present, in); err == nil {
+			t.Errorf("parseClockTime(%q): err = nil; want error", in)
+		}
+	}
+}
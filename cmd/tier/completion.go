@@ -0,0 +1,247 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// A command is a command of tier, as listed by tier help, with the
+// subcommands and flags shown in its help topic, for completion.
+type command struct {
+	name, desc  string
+	subcommands []string
+	flags       []string // without dashes
+}
+
+// flagName returns f with the dashes it is written with: one if a single
+// letter, such as "-c", and two otherwise.
+func flagName(f string) string {
+	if len(f) == 1 {
+		return "-" + f
+	}
+	return "--" + f
+}
+
+// globalFlag is a flag of tier itself, as listed by tier help.
+type globalFlag struct {
+	name, desc string
+}
+
+// helpListRx matches the entries of the command and flag lists of tier
+// help.
+var helpListRx = regexp.MustCompile(`(?m)^\t(-?[a-z]+) +(.+)$`)
+
+// helpList returns the entries of the list in errUsage that follows the
+// line header.
+func helpList(header string) [][2]string {
+	usage := errUsage.Error()
+	_, list, _ := strings.Cut(usage, header+"\n\n")
+	list, _, _ = strings.Cut(list, "\n\n")
+	var out [][2]string
+	for _, m := range helpListRx.FindAllStringSubmatch(list, -1) {
+		out = append(out, [2]string{m[1], m[2]})
+	}
+	return out
+}
+
+// flagRx matches the flags in the synopsis of a help topic, such as
+// "--dry-run", "-switchaccounts", or "-c".
+var flagRx = regexp.MustCompile(`(?:^|[\s\[|])--?([a-z](?:[a-z-]*[a-z])?)\b`)
+
+// subcommandRx matches the subcommand of a usage line, if any, which
+// follows the command, such as "new" in "tier clock new <name>", or "ls" in
+// "tier profile [ls]".
+var subcommandRx = regexp.MustCompile(`^\[?([a-z]+)\]?$`)
+
+// completionGlobals returns the flags of tier itself.
+func completionGlobals() []globalFlag {
+	var fs []globalFlag
+	for _, e := range helpList("The flags are:") {
+		fs = append(fs, globalFlag{strings.TrimPrefix(e[0], "-"), e[1]})
+	}
+	return fs
+}
+
+// completionCommands returns the commands of tier, in the order listed by
+// tier help, with the subcommands and flags in the synopses of their help
+// topics.
+func completionCommands() []command {
+	var cmds []command
+	for _, e := range helpList("The commands are:") {
+		c := command{name: e[0], desc: e[1]}
+		_, synopsis, _ := strings.Cut(topics[c.name], "Usage:\n\n")
+		synopsis, _, _ = strings.Cut(synopsis, "\n\n")
+		for _, line := range strings.Split(synopsis, "\n") {
+			// Flags before the command, such as [--live], are
+			// global; lines not starting with tier continue the
+			// previous one.
+			rest := line
+			if fields := strings.Fields(line); len(fields) > 0 && fields[0] == "tier" {
+				i := slices.Index(fields, c.name)
+				if i < 0 {
+					continue
+				}
+				if i+1 < len(fields) {
+					if m := subcommandRx.FindStringSubmatch(fields[i+1]); m != nil && !slices.Contains(c.subcommands, m[1]) {
+						c.subcommands = append(c.subcommands, m[1])
+					}
+				}
+				rest = strings.Join(fields[i+1:], " ")
+			}
+			for _, m := range flagRx.FindAllStringSubmatch(rest, -1) {
+				if !slices.Contains(c.flags, m[1]) {
+					c.flags = append(c.flags, m[1])
+				}
+			}
+		}
+		sort.Strings(c.flags)
+		switch c.name {
+		case "help":
+			for _, t := range helpList("The commands are:") {
+				c.subcommands = append(c.subcommands, t[0])
+			}
+		case "completion":
+			c.subcommands = []string{"bash", "zsh", "fish"}
+		}
+		cmds = append(cmds, c)
+	}
+	return cmds
+}
+
+// printCompletion prints the completion script for shell to w.
+func printCompletion(w io.Writer, shell string) error {
+	cmds, globals := completionCommands(), completionGlobals()
+	switch shell {
+	case "bash":
+		return printBashCompletion(w, cmds, globals)
+	case "zsh":
+		return printZshCompletion(w, cmds, globals)
+	case "fish":
+		return printFishCompletion(w, cmds, globals)
+	default:
+		return fmt.Errorf("unknown shell %q; want bash, zsh, or fish", shell)
+	}
+}
+
+// words returns the subcommands and flags of c, for bash and zsh.
+func (c command) words() []string {
+	ws := slices.Clone(c.subcommands)
+	for _, f := range c.flags {
+		ws = append(ws, flagName(f))
+	}
+	return ws
+}
+
+func printBashCompletion(w io.Writer, cmds []command, globals []globalFlag) error {
+	var b strings.Builder
+	b.WriteString(`# bash completion for tier; load with: source <(tier completion bash)
+_tier() {
+	local cur=${COMP_WORDS[COMP_CWORD]} cmd="" i words
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		if [[ ${COMP_WORDS[i]} != -* ]]; then
+			cmd=${COMP_WORDS[i]}
+			break
+		fi
+	done
+	case $cmd in
+`)
+	var top []string
+	for _, f := range globals {
+		top = append(top, "-"+f.name)
+	}
+	for _, c := range cmds {
+		top = append(top, c.name)
+	}
+	fmt.Fprintf(&b, "\t\"\") words=%q ;;\n", strings.Join(top, " "))
+	for _, c := range cmds {
+		if ws := c.words(); len(ws) > 0 {
+			fmt.Fprintf(&b, "\t%s) words=%q ;;\n", c.name, strings.Join(ws, " "))
+		}
+	}
+	b.WriteString(`	*) words="" ;;
+	esac
+	COMPREPLY=($(compgen -W "$words" -- "$cur"))
+}
+complete -o default -F _tier tier
+`)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func printZshCompletion(w io.Writer, cmds []command, globals []globalFlag) error {
+	var b strings.Builder
+	b.WriteString(`#compdef tier
+# zsh completion for tier; load with: source <(tier completion zsh)
+_tier() {
+	local -a commands flags
+	commands=(
+`)
+	for _, c := range cmds {
+		fmt.Fprintf(&b, "\t\t%s\n", shellQuote(c.name+":"+c.desc))
+	}
+	b.WriteString("\t)\n\tflags=(\n")
+	for _, f := range globals {
+		fmt.Fprintf(&b, "\t\t%s\n", shellQuote("-"+f.name+":"+f.desc))
+	}
+	b.WriteString(`	)
+	local cmd=${${words[2,CURRENT-1]:#-*}[1]}
+	if [[ -z $cmd ]]; then
+		_describe -t commands 'tier command' commands
+		_describe -t flags 'tier flag' flags
+		return
+	fi
+	case $cmd in
+`)
+	for _, c := range cmds {
+		if ws := c.words(); len(ws) > 0 {
+			fmt.Fprintf(&b, "\t%s) compadd -- %s ;;\n", c.name, strings.Join(ws, " "))
+		}
+	}
+	b.WriteString(`	esac
+	_files
+}
+if [[ $funcstack[1] == _tier ]]; then
+	_tier "$@"
+else
+	compdef _tier tier
+fi
+`)
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func printFishCompletion(w io.Writer, cmds []command, globals []globalFlag) error {
+	var b strings.Builder
+	b.WriteString("# fish completion for tier; load with: tier completion fish | source\n")
+	for _, f := range globals {
+		fmt.Fprintf(&b, "complete -c tier -n __fish_use_subcommand -o %s -d %s\n", f.name, shellQuote(f.desc))
+	}
+	for _, c := range cmds {
+		fmt.Fprintf(&b, "complete -c tier -n __fish_use_subcommand -f -a %s -d %s\n", c.name, shellQuote(c.desc))
+	}
+	for _, c := range cmds {
+		cond := shellQuote("__fish_seen_subcommand_from " + c.name)
+		if len(c.subcommands) > 0 {
+			fmt.Fprintf(&b, "complete -c tier -n %s -f -a %s\n", cond, shellQuote(strings.Join(c.subcommands, " ")))
+		}
+		for _, f := range c.flags {
+			opt := "-l"
+			if len(f) == 1 {
+				opt = "-s"
+			}
+			fmt.Fprintf(&b, "complete -c tier -n %s %s %s\n", cond, opt, f)
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// shellQuote quotes s in single quotes for bash, zsh, and fish.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/exp/slices"
+)
+
+func TestCompletionCommands(t *testing.T) {
+	cmds := completionCommands()
+	find := func(name string) command {
+		t.Helper()
+		i := slices.IndexFunc(cmds, func(c command) bool { return c.name == name })
+		if i < 0 {
+			t.Fatalf("no completion for %q", name)
+		}
+		return cmds[i]
+	}
+
+	if c := find("push"); !slices.Contains(c.flags, "dry-run") {
+		t.Errorf("push flags = %q; want dry-run", c.flags)
+	}
+	if c := find("migrate"); !slices.Contains(c.flags, "at-renewal") || !slices.Contains(c.flags, "from") {
+		t.Errorf("migrate flags = %q; want from and at-renewal", c.flags)
+	}
+	if c := find("clock"); !slices.Contains(c.subcommands, "new") || !slices.Contains(c.subcommands, "advance") {
+		t.Errorf("clock subcommands = %q; want new and advance", c.subcommands)
+	}
+	if c := find("completion"); !slices.Equal(c.subcommands, []string{"bash", "zsh", "fish"}) {
+		t.Errorf("completion subcommands = %q; want bash zsh fish", c.subcommands)
+	}
+	if c := find("switch"); !slices.Contains(c.words(), "-c") {
+		t.Errorf("switch words = %q; want -c", c.words())
+	}
+
+	for _, shell := range []string{"bash", "zsh", "fish"} {
+		var b strings.Builder
+		if err := printCompletion(&b, shell); err != nil {
+			t.Fatal(err)
+		}
+		for _, c := range cmds {
+			if !strings.Contains(b.String(), c.name) {
+				t.Errorf("%s completion missing %q", shell, c.name)
+			}
+		}
+	}
+	if err := printCompletion(new(strings.Builder), "tcsh"); err == nil {
+		t.Error("printCompletion(tcsh) = nil; want error")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/tailscale/hujson"
+	"tier.run/values"
+)
+
+// A serveConfig configures the sidecar, as read from the file given to
+// serve --config, so deployments need not pass everything as flags. Flags
+// given on the command line override it, and so do the environment
+// variables named for its keys, so secrets may be kept out of the file.
+type serveConfig struct {
+	path string // of the file read
+
+	Addr          string `json:"addr"`
+	TLSCert       string `json:"tls_cert"`
+	TLSKey        string `json:"tls_key"`
+	Provider      string `json:"provider"`
+	Accounts      string `json:"accounts"`
+	Audit         string `json:"audit"`
+	AnonymousPlan string `json:"anonymous_plan"`
+
+	Stripe struct {
+		Key           string `json:"key"`            // STRIPE_API_KEY
+		SecondaryKey  string `json:"secondary_key"`  // STRIPE_API_KEY_SECONDARY
+		BaseURL       string `json:"base_url"`       // STRIPE_BASE_API_URL
+		WebhookSecret string `json:"webhook_secret"` // STRIPE_WEBHOOK_SECRET
+	} `json:"stripe"`
+
+	Paddle struct {
+		Key string `json:"key"` // PADDLE_API_KEY
+	} `json:"paddle"`
+
+	// Store caches the phases and limits of orgs, as --store and
+	// --refresh do.
+	Store struct {
+		Path    string `json:"path"`
+		Refresh string `json:"refresh"` // such as "1m"
+	} `json:"store"`
+
+	Notify struct {
+		Webhook        string `json:"webhook"`
+		Secret         string `json:"secret"` // TIER_NOTIFY_SECRET
+		Slack          string `json:"slack"`
+		SlackTemplates string `json:"slack_templates"`
+		Events         bool   `json:"events"`
+	} `json:"notify"`
+
+	MetricsAddr string `json:"metrics_addr"`
+	HealthPath  string `json:"health_path"`
+	LogRequests string `json:"log_requests"`
+}
+
+// loadServeConfig reads the config file at path, in JSON, allowing comments
+// and trailing commas.
+func loadServeConfig(path string) (*serveConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data, err = hujson.Standardize(data)
+	if err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	c := &serveConfig{path: path}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("config %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// apply sets the options in opts not given by the flags named in set, and
+// the keys in opts not set in the environment, from c.
+func (c *serveConfig) apply(opts *serveOptions, set map[string]bool) error {
+	str := func(flag string, opt *string, v string) {
+		if v != "" && !set[flag] {
+			*opt = v
+		}
+	}
+	str("addr", &opts.addr, c.Addr)
+	str("tls-cert", &opts.tlsCert, c.TLSCert)
+	str("tls-key", &opts.tlsKey, c.TLSKey)
+	str("provider", &opts.provider, c.Provider)
+	str("accounts", &opts.accounts, c.Accounts)
+	str("audit", &opts.audit, c.Audit)
+	str("anonymous-plan", &opts.anonPlan, c.AnonymousPlan)
+	str("store", &opts.store, c.Store.Path)
+	str("notify-webhook", &opts.notifyWebhook, c.Notify.Webhook)
+	str("notify-slack", &opts.notifySlack, c.Notify.Slack)
+	str("notify-slack-templates", &opts.slackTemplates, c.Notify.SlackTemplates)
+	str("metrics-addr", &opts.metricsAddr, c.MetricsAddr)
+	str("health-path", &opts.healthPath, c.HealthPath)
+	str("log-requests", &opts.logRequests, c.LogRequests)
+	if c.Store.Refresh != "" && !set["refresh"] {
+		d, err := time.ParseDuration(c.Store.Refresh)
+		if err != nil {
+			return fmt.Errorf("config: store refresh: %w", err)
+		}
+		opts.refresh = d
+	}
+	if c.Notify.Events && !set["events"] {
+		opts.events = true
+	}
+
+	k := &opts.keys
+	if k.stripeKey == "" && c.Stripe.Key != "" {
+		k.stripeKey = c.Stripe.Key
+		k.stripeKeySource = c.path
+	}
+	k.stripeSecondaryKey = values.Coalesce(k.stripeSecondaryKey, c.Stripe.SecondaryKey)
+	k.stripeBaseURL = values.Coalesce(k.stripeBaseURL, c.Stripe.BaseURL)
+	k.webhookSecret = values.Coalesce(k.webhookSecret, c.Stripe.WebhookSecret)
+	k.paddleKey = values.Coalesce(k.paddleKey, c.Paddle.Key)
+	k.notifySecret = values.Coalesce(k.notifySecret, c.Notify.Secret)
+	return nil
+}
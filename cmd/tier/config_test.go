@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+)
+
+func TestServeConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tier.json")
+	err := os.WriteFile(path, []byte(`{
+		// comments and trailing commas are allowed
+		"addr": ":9000",
+		"provider": "stripe",
+		"stripe": {"key": "sk_test_file", "webhook_secret": "whsec_file"},
+		"store": {"path": "tier.db", "refresh": "5m"},
+		"notify": {"webhook": "https://example.com/hook", "secret": "notify_file", "events": true},
+		"health_path": "/healthz",
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := loadServeConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("STRIPE_WEBHOOK_SECRET", "")
+	t.Setenv("TIER_NOTIFY_SECRET", "")
+
+	opts := serveOptions{
+		addr:        ":8080",
+		refresh:     time.Minute,
+		healthPath:  "/ping",
+		logRequests: "none",
+		keys: serveKeys{
+			stripeKey:       "sk_test_env",
+			stripeKeySource: "STRIPE_API_KEY",
+		},
+	}
+	if err := c.apply(&opts, map[string]bool{"health-path": true}); err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, opts, serveOptions{
+		addr:          ":9000",
+		provider:      "stripe",
+		store:         "tier.db",
+		refresh:       5 * time.Minute,
+		notifyWebhook: "https://example.com/hook",
+		events:        true,
+		healthPath:    "/ping", // set by flag
+		logRequests:   "none",
+		keys: serveKeys{
+			stripeKey:       "sk_test_env", // set in the environment
+			stripeKeySource: "STRIPE_API_KEY",
+			webhookSecret:   "whsec_file",
+			notifySecret:    "notify_file",
+		},
+	})
+
+	// Keys are not set in the environment, so that processes started by
+	// the sidecar do not inherit them.
+	for _, name := range []string{"STRIPE_WEBHOOK_SECRET", "TIER_NOTIFY_SECRET"} {
+		if v := os.Getenv(name); v != "" {
+			t.Errorf("$%s = %q; want unset", name, v)
+		}
+	}
+
+	// Keys in the file are used if not in the environment.
+	opts.keys = serveKeys{}
+	if err := c.apply(&opts, nil); err != nil {
+		t.Fatal(err)
+	}
+	if opts.keys.stripeKey != "sk_test_file" || opts.keys.stripeKeySource != path {
+		t.Errorf("stripe key = %q from %q; want sk_test_file from %s", opts.keys.stripeKey, opts.keys.stripeKeySource, path)
+	}
+
+	c.Store.Refresh = "soon"
+	if err := c.apply(&opts, nil); err == nil {
+		t.Error("invalid refresh accepted")
+	}
+}
@@ -47,7 +47,7 @@ func connect() error {
 		return err
 	}
 
-	if err := profile.Save("tier", p); err != nil {
+	if err := profile.Save(profile.CurrentName(), p); err != nil {
 		return err
 	}
 
@@ -114,7 +114,7 @@ func getKey() (key, source string, err error) {
 	if envAPIKey != "" {
 		return envAPIKey, "STRIPE_API_KEY", nil
 	}
-	p, err := profile.Load("tier")
+	p, err := profile.Load(profile.CurrentName())
 	if err != nil {
 		return "", "", err
 	}
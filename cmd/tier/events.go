@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"tier.run/api/apitypes"
+	"tier.run/client/tier"
+	"tier.run/notify"
+	"tier.run/values"
+)
+
+// runEvents runs the events subcommand with args, printing the lifecycle
+// events of orgs streamed from a running sidecar.
+func runEvents(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	follow := fs.Bool("follow", false, "print events as they happen, until interrupted")
+	org := fs.String("org", "", "print only the events of org")
+	sidecar := fs.String("sidecar", "", "URL of the sidecar to stream events from (default the profile's sidecar)")
+	asJSON := fs.Bool("json", false, "print each event as a line of JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+	u := values.Coalesce(*sidecar, loadProfile().SidecarURL)
+	if u == "" {
+		return errors.New("no sidecar to stream events from; provide --sidecar, or set the profile's with tier profile set --sidecar")
+	}
+	c := tier.NewTierSidecarClient(strings.TrimSuffix(u, "/"))
+	return c.StreamEvents(ctx, *org, *follow, func(e apitypes.Event) error {
+		if *asJSON || *flagJSON {
+			data, err := json.Marshal(e)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(stdout, "%s\n", data)
+			return err
+		}
+		return printEvent(stdout, e)
+	})
+}
+
+// printEvent prints e to w as a line of its time, type, org, and the
+// details of its type.
+func printEvent(w io.Writer, e apitypes.Event) error {
+	var details string
+	switch e.Type {
+	case notify.EventSubscribe:
+		details = joinOrDash(e.Features)
+	case notify.EventPaymentFailed:
+		if in := e.Invoice; in != nil {
+			details = fmt.Sprintf("%s %d %s due", in.ID, in.AmountDue, in.Currency)
+		}
+	case notify.EventLimitReached:
+		if u := e.Usage; u != nil {
+			details = fmt.Sprintf("%s %d/%d", u.Feature, u.Used, u.Limit)
+		}
+	case notify.EventReport:
+		if r := e.Report; r != nil {
+			op := "+"
+			if r.Clobber {
+				op = "="
+			}
+			details = fmt.Sprintf("%s %s%d", r.Feature, op, r.N)
+		}
+	}
+	line := fmt.Sprintf("%s  %-14s  %s", e.At.Format(time.RFC3339), e.Type, e.Org)
+	if details != "" {
+		line += "  " + details
+	}
+	_, err := fmt.Fprintln(w, line)
+	return err
+}
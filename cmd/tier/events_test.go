@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+	"tier.run/api/apitypes"
+	"tier.run/refs"
+)
+
+func TestPrintEvent(t *testing.T) {
+	at := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	var b strings.Builder
+	for _, e := range []apitypes.Event{
+		{Type: "subscribe", Org: "org:a", At: at, Features: refs.MustParseFeaturePlans("feature:x@plan:pro@0", "feature:y@plan:pro@0")},
+		{Type: "report", Org: "org:a", At: at, Report: &apitypes.EventReport{Feature: refs.MustParseName("feature:x"), N: 3}},
+		{Type: "report", Org: "org:a", At: at, Report: &apitypes.EventReport{Feature: refs.MustParseName("feature:x"), N: 10, Clobber: true}},
+		{Type: "limit_reached", Org: "org:a", At: at, Usage: &apitypes.Usage{Feature: refs.MustParseName("feature:x"), Used: 10, Limit: 10}},
+		{Type: "payment_failed", Org: "org:a", At: at, Invoice: &apitypes.Invoice{ID: "in_1", AmountDue: 1050, Currency: "usd"}},
+		{Type: "cancel", Org: "org:a", At: at},
+	} {
+		if err := printEvent(&b, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+	diff.Test(t, t.Errorf, strings.Split(b.String(), "\n"), []string{
+		"2024-02-01T12:00:00Z  subscribe       org:a  feature:x@plan:pro@0 feature:y@plan:pro@0",
+		"2024-02-01T12:00:00Z  report          org:a  feature:x +3",
+		"2024-02-01T12:00:00Z  report          org:a  feature:x =10",
+		"2024-02-01T12:00:00Z  limit_reached   org:a  feature:x 10/10",
+		"2024-02-01T12:00:00Z  payment_failed  org:a  in_1 1050 usd due",
+		"2024-02-01T12:00:00Z  cancel          org:a",
+		"",
+	})
+}
@@ -17,27 +17,41 @@ var (
 
 The commands are:
 
-	connect    connect your Stripe account
-	push       push pricing plans to Stripe
-	pull       pull pricing plans from Stripe
-	ls         list pricing plans
-	version    display the current CLI version
-	subscribe  subscribe an org to a pricing plan
-	phases     list scheduled phases for an org
-	limits     list feature limits for an org
-	report     report usage for metered features
-	whoami     display the current account information
-	switch     create and switch to clean rooms
-	whois      display the Stripe customer ID for an org
-	serve      run the sidecar API
-	clean      remove objects in Stripe Test Mode
-	help       display this help message
+	init        create a pricing.json to start from
+	import      adopt the prices of a Stripe account made before tier
+	connect     connect your Stripe account
+	push        push pricing plans to Stripe
+	validate    check pricing plans for problems
+	pull        pull pricing plans from Stripe
+	ls          list pricing plans
+	version     display the current CLI version
+	subscribe   subscribe an org to a pricing plan
+	checkout    print a Stripe Checkout link for an org to subscribe with
+	cancel      cancel the subscription of an org
+	pause       pause collecting payments from an org
+	resume      resume collecting payments from an org
+	phases      list the past, current, and scheduled phases of an org
+	migrate     move the orgs on a plan to another plan
+	limits      list feature limits for an org
+	report      report usage for metered features
+	whoami      display the current account information
+	switch      create and switch to clean rooms, or switch profiles
+	profile     manage named profiles for staging, production, etc.
+	whois       display the Stripe customer ID for an org
+	org         display the billing state of an org, or set its metadata
+	clock       manage test clocks of test environments
+	events      print the lifecycle events of orgs from a sidecar
+	serve       run the sidecar API
+	clean       remove objects in Stripe Test Mode
+	completion  print a completion script for bash, zsh, or fish
+	help        display this help message
 
 The flags are:
 
-	-live      use live Stripe key (default is false)
-	-v         verbose output
-	-h         show this message
+	-live       use live Stripe key (default is false)
+	-v          verbose output
+	-json       print output as JSON, for scripts
+	-h          show this message
 `)
 )
 
@@ -51,11 +65,25 @@ Print the version of the Tier CLI.
 
 	"push": `Usage:
 
-	tier [--live] push <filename | - >
+	tier [--live] push [--dry-run] [--diff] <filename | - >
 
 Tier push pushes the pricing JSON in the provided filename to Stripe. If the
 filename is ("-") then stdin is read.
 
+If --dry-run is provided, the pricing JSON is validated and compared with
+Stripe, and what push would do with each feature is printed, without pushing
+anything: create, update, remove, or noop. Since features in Stripe are
+immutable, push skips updates and removals; push a new plan version instead.
+Tier exits with status 2 if anything would change, for use as a CI check.
+
+If --diff is provided, the same is printed before pushing.
+
+Output is colored when written to a terminal, unless NO_COLOR is set.
+
+The pricing JSON may be split across files by listing the other files in its
+"include" field, relative to the file including them. Each plan and add-on
+must be defined in only one file. Includes are not supported on stdin.
+
 To learn more about how this works, please visit: https://tier.run/docs/cli/push
 
 If the --live flag is provided, your accounts live mode will be used.
@@ -63,9 +91,20 @@ If the --live flag is provided, your accounts live mode will be used.
 
 	"pull": `Usage:
 
-	tier [--live] pull 
+	tier [--live] pull [--format json|table|pretty] [--plan <plan>] [--include-archived]
 
 Tier pull pulls the pricing JSON from Stripe and writes it to stdout.
+The output includes a "schemaVersion" field, which changes only when the
+format changes incompatibly, so that it may be consumed by other tools.
+
+If --plan is provided, only the features of the plan are pulled. If
+--include-archived is provided, archived plans are pulled too, marked
+"archived".
+
+If --format is table, a row is written for each feature instead, with its
+billing interval, currency, and price; if pretty, an outline of each plan
+and its features is written, with their prices described. Only json is
+meant to be read by other tools.
 
 If the --live flag is provided, your accounts live mode will be used.
 `,
@@ -101,55 +140,297 @@ The output is in the format:
 `,
 	"phases": `Usage:
 
-	tier [--live] phases <org>
+	tier [--live] phases <org> [--all]
+
+Tier phases prints the timeline of the phases of the provided org: those it
+was in, the one it is in now, and those scheduled, in the order they take
+effect. Each phase is listed with its plans, its add-ons, the features it
+has from plans it does not have all of (fragments), and when its free trial
+ends, if it has one.
 
-Tier phases lists all phases scheduled by Tier for the provided org.
+If the --all flag is provided, the phases of subscriptions the org canceled
+are included, with the status "canceled".
 
 If the --live flag is provided, your accounts live mode will be used.
 
 The output is in the format:
 
-	EFFECTIVE                  FEATURE                 PLAN
-	2022-10-10T23:26:10-07:00  feature:convert:temp    plan:pro@0
-	2022-10-10T23:26:10-07:00  feature:convert:volume  plan:pro@0
-	2022-10-10T23:26:10-07:00  feature:convert:weight  plan:pro@0
+	EFFECTIVE             STATUS     PLANS        ADDONS             FRAGMENTS  TRIAL END
+	2024-01-01T00:00:00Z  past       plan:free@1  -                  -          -
+	2024-02-01T00:00:00Z  current    plan:pro@0   feature:support@1  -          2024-02-15T00:00:00Z
+	2024-03-01T00:00:00Z  scheduled  plan:pro@1   -                  -          -
 `,
 	"subscribe": `Usage:
 
-	tier [--live] subscribe [--email=<email>] <org> [plan|featurePlan]...
+	tier [--live] subscribe [--email=<email>] [--trial-days=<n>]
+	                        [--effective=<time> | --at-period-end] <org> [plan|featurePlan]...
 
 Tier subscribe creates or updates a subscription for the provided org, applying
 the features in the plan.
 
+If the --trial-days flag is provided, the subscription begins with a free
+trial of that many days, instead of any trial given by the plans.
+
+If the --effective flag is provided, the plans take effect at that time, in
+RFC 3339 format, such as 2024-01-01T00:00:00Z, instead of now. If the
+--at-period-end flag is provided, they take effect at the end of the org's
+current billing period. Either way, the org stays on its current plans until
+then.
+
 If the --live flag is provided, your accounts live mode will be used.
 
 If the --email flag is provided, the org's email address will be set to the
 provided email address.
+`,
+	"checkout": `Usage:
+
+	tier [--live] checkout --success-url=<url> [--cancel-url=<url>] [--trial-days=<n>]
+	                       [--require-billing-address] <org> [plan|featurePlan]...
+
+Tier checkout prints the URL of a Stripe Checkout page where the provided org
+subscribes to the provided plans and features by entering its payment
+details, creating the org if needed. Once it completes checkout, the org is
+sent to the --success-url. If it leaves without completing it, the org is
+sent to the --cancel-url, which defaults to the --success-url. Flags may
+also follow the org and plans.
+
+If no plans or features are provided, the page only collects the org's
+payment details, for it to be subscribed later with tier subscribe.
+
+If the --trial-days flag is provided, the subscription begins with a free
+trial of that many days, instead of any trial given by the plans.
+
+Orgs that are already subscribed must be changed with tier subscribe.
+
+Example:
+
+	tier checkout org:acme plan:pro@2 --success-url=https://example.com/welcome
+`,
+	"cancel": `Usage:
+
+	tier [--live] cancel [--at-period-end] <org>
+
+Tier cancel cancels the subscription of the provided org immediately, along
+with any phases scheduled for it.
+
+If the --at-period-end flag is provided, the org keeps its current plans until
+the end of its current billing period instead, and phases scheduled after
+then are dropped. Subscribing the org again before then resumes its
+subscription.
+`,
+	"pause": `Usage:
+
+	tier [--live] pause <org>
+
+Tier pause pauses collecting payments for the subscription of the provided
+org. The org keeps its plans, and usage is still reported, but its invoices
+are voided until it is resumed with tier resume.
+`,
+	"resume": `Usage:
+
+	tier [--live] resume <org>
+
+Tier resume resumes collecting payments for the subscription of the provided
+org, paused with tier pause. Invoices voided while it was paused are not
+collected.
 `,
 	"limits": `Usage:
 
-	tier [--live] limits <org>
+	tier [--live] limits [--json] <org>
+
+Tier limits lists the provided orgs limits and usage per feature subscribed to,
+with the start and end of the billing period the usage is counted in. Orgs
+without a subscription have no billing period, which is shown as "-".
 
-Tier limits lists the provided orgs limits and usage per feature subscribed to.
+If the --json flag is provided, the limits are printed as JSON instead, for
+use by scripts.
 
 If the --live flag is provided, your accounts live mode will be used.
 `,
 	"report": `Usage:
 
-	tier [--live] report <org> <feature> <n>
+	tier [--live] report [--at=<time>] [--set] <org> <feature> <n>
 
 Tier report reports that n units of feature were used by org to Stripe.
+It is useful for backfilling usage and correcting it by hand.
+
+If the --at flag is provided, the usage is reported as occurring at that time,
+in RFC 3339 format, such as 2024-01-01T00:00:00Z, instead of now.
+
+If the --set flag is provided, the usage of feature in the current billing
+period is set to n, instead of increased by n.
 
 For a report of usage, see the ("tier limits") command.
 
 If the --live flag is provided, your accounts live mode will be used.
+`,
+	"init": `Usage:
+
+	tier init [--currency=<code>] [--interval=<interval>] [--price=<n>]
+	          [--trial-days=<n>] [--yes] [--force] [<pricing.json>]
+
+Tier init creates a pricing model in the provided file, or pricing.json, to
+start from. It has a free and a pro plan, with examples of a licensed feature
+with a base price, metered features with free and paid tiers, and a flag.
+The model always validates, so it can be pushed as is.
+
+When run in a terminal, tier init asks for the currency and billing interval
+of the plans, and the price and trial days of the pro plan, suggesting the
+values of the flags. If the --yes flag is provided, or tier init is not run in
+a terminal, the flags are used without asking. The price is in the smallest
+unit of the currency, such as cents.
+
+Tier init does not overwrite an existing file, unless the --force flag is
+provided. If the file is "-", the model is written to stdout.
+`,
+	"validate": `Usage:
+
+	tier validate [<pricing.json>]
+
+Tier validate checks the pricing model in the provided file, and the files it
+includes, for problems, without calling Stripe, so that broken models are
+caught before they are pushed. The file defaults to pricing.json.
+
+Every problem found is printed with its position in the file it was found in,
+as file:line:column, followed by its severity: "error" if the model cannot be
+pushed, or "warning" if it can but likely not as intended. Tier validate exits
+with a non-zero status if any problem is an error.
+`,
+	"clock": `Usage:
+
+	tier clock new [--start=<time>] <name>
+	tier clock advance [--no-wait] <name> <time>
+	tier clock status <name>
+	tier clock attach [--email=<email>] <name> <org>
+
+Tier clock manages Stripe test clocks, one for each named test environment,
+so that renewals and trial expiry can be simulated without waiting for them.
+Test clocks are only available with test keys.
+
+Tier clock new creates a test clock for the environment name, frozen at the
+time provided by --start, in RFC 3339 format, or now.
+
+Tier clock advance moves the clock of the environment name forward to time,
+in RFC 3339 format, or relative to the clock's present time as a plus sign
+followed by a number of days, such as +30d, or a duration, such as +36h. It
+waits for Stripe to finish advancing the clock, unless --no-wait is
+provided.
+
+Tier clock status reports the present time and status of the clock of the
+environment name.
+
+Tier clock attach creates org on the clock of the environment name, so that
+its subscriptions, trials, and invoices follow the clock. Orgs can only be
+attached to a clock when they are created.
+`,
+	"import": `Usage:
+
+	tier [--live] import [--dry-run] [--yes] [--mapping <filename>]
+	                     [--write-mapping <filename>] [-o <filename>]
+
+Tier import adopts the active prices of a Stripe account that were made
+before tier, or otherwise outside of it, as features, so that orgs already
+subscribed to them can be managed with tier. Prices keep their products,
+amounts, and subscriptions; tier sets the lookup keys and metadata tier push
+would have set.
+
+Tier import lists the prices and the feature proposed for each: a plan is
+proposed for each product, and a feature for each of its prices, named for
+the price's nickname, or else its product. Prices of a product billed at
+different intervals or in different currencies are proposed in different
+plans. Prices tier cannot import, such as those billed every other week, are
+skipped. Tier then asks for confirmation before importing, unless --yes is
+provided.
+
+If --dry-run is provided, nothing is imported.
+
+If --write-mapping is provided, the proposed mapping of price IDs to
+features is written to the file as JSON, such as:
+
+	{
+		"price_1MhXa2": "feature:seats@plan:pro@0",
+		"price_1MhXb7": "feature:seats@plan:pro:yearly@0"
+	}
+
+Edit it, and pass it back with --mapping, to import prices as features of
+your choosing instead. Prices not in the mapping are skipped.
+
+If -o is provided, the pricing JSON of the imported features is written to
+the file, to keep with the pricing JSON of the plans pushed later.
+
+Each plan must be imported at once, and plans already pushed or imported
+cannot be imported again.
+`,
+	"events": `Usage:
+
+	tier events [--follow] [--org <org>] [--sidecar <url>] [--json]
+
+Tier events prints the recent lifecycle events of orgs kept by a running
+sidecar started with serve --events, oldest first, one a line: the time,
+type, and org of each event, and its details. The types of events are
+"subscribe", "cancel", "payment_failed", "limit_reached", and "report".
+
+If the --follow flag is provided, events are printed as they happen, until
+interrupted, such as to watch orgs while debugging an incident.
+
+If the --org flag is provided, only the events of that org are printed.
+
+If the --sidecar flag is provided, events are streamed from the sidecar at
+that URL, instead of the sidecar of the current profile; see ("tier
+profile").
+
+If the --json flag is provided, each event is printed as a line of JSON, in
+the format posted by serve --notify-webhook.
 `,
 	"whois": `Usage:
 
-	tier [--live] whois <org>
+	tier [--live] whois [--info] <org>
 
 Tier whois reports the Stripe customer ID for the provided org.
 
+If the --info flag is provided, the org is shown as by ("tier org get")
+instead.
+
+If the --live flag is provided, your accounts live mode will be used.
+`,
+	"org": `Usage:
+
+	tier [--live] org get <org>
+	tier [--live] org set <org> [key=value]... [--unset <key>]...
+
+Tier org get shows the provided org in one view: its Stripe customer ID,
+email, name, and metadata, the status of its subscription, and the plans,
+add-ons, and fragments of its current phase.
+
+Tier org set sets the metadata of the provided org to the values given as
+key=value, and removes the keys given to --unset, which may be repeated.
+A key given with an empty value, as key=, is removed likewise. Other keys
+are left as they are, and so is the subscription of the org. The org is
+created if it does not exist. Keys beginning with "tier." are reserved.
+
+If the --live flag is provided, your accounts live mode will be used.
+`,
+	"migrate": `Usage:
+
+	tier [--live] migrate --from <plan> --to <plan> [--at-renewal] [--dry-run]
+
+Tier migrate moves every org subscribed to the plan given to --from onto the
+plan given to --to, such as from plan:pro@0 to plan:pro@1. The features of
+the old plan are replaced by those of the new one in the current phase of
+each org, and its other plans, add-ons, and billing interval are kept.
+
+Orgs are migrated now, unless the --at-renewal flag is provided, in which
+case each org moves at the end of its current billing period. Orgs with
+phases scheduled after their current phase are not migrated, so that those
+phases are not lost, and are reported as failed.
+
+If the --dry-run flag is provided, the orgs that would be migrated are
+reported, but not migrated.
+
+A line is printed for each org as it is migrated, followed by a summary and
+the orgs that failed, if any. If any org failed, tier migrate exits with a
+non-zero status. With --json, each result is printed as a line of JSON.
+
 If the --live flag is provided, your accounts live mode will be used.
 `,
 	"whoami": `Usage:
@@ -164,21 +445,152 @@ in use as a result of "tier connect", "tier switch", or the STRIPE_API_KEY.
 
 	`serve`: `Usage:
 
-	tier serve [--addr <addr>]
+	tier serve [--addr <addr>] [--tls-cert <filename> --tls-key <filename>]
+	           [--store <filename>] [--refresh <duration>] [--audit <filename>]
+	           [--accounts <filename>] [--provider <stripe|paddle>] [--anonymous-plan <plan>]
+	           [--notify-webhook <url>] [--notify-slack <url>] [--notify-slack-templates <filename>]
+	           [--events] [--metrics-addr <addr>] [--health-path <path>]
+	           [--log-requests <none|errors|all>] [--config <filename>]
 
 Tier serve starts a web server that exposes the Tier API over HTTP listening on
 the provided service address.
 
 The default service address is "localhost:8080".
+
+If --tls-cert and --tls-key are provided, the sidecar serves HTTPS using the
+certificate and key in the files.
+
+If the sidecar is started by systemd socket activation, it serves on the
+socket passed to it instead of --addr.
+
+On SIGINT or SIGTERM, the sidecar stops accepting connections and exits once
+the requests in flight are done. On SIGHUP, it restarts: it starts a new
+sidecar with the same arguments, hands it the listening socket, and then
+shuts down in the same way, so no connections are refused. Sidecars managed
+by systemd should instead be restarted by systemd, with socket activation.
+
+If --store is provided, the phases and limits served for each org are saved
+to the file, and served from it when Stripe is unavailable, including after
+a restart. Changes are saved within a second, and on shutdown. Saved orgs
+are looked up again every --refresh interval (default 1m) to keep them
+recent, a few at a time, spread over the first half of the interval. Orgs
+not served for 7 days are removed from the store.
+
+If --anonymous-plan is provided with --store, subjects prefixed "anon:"
+instead of "org:", such as devices or sessions not yet signed up, are
+entitled to the features of the plan without a Stripe customer. Their usage
+is saved in the store until merged into the org they sign up as by posting
+to /v1/anonymous/merge, or until unchanged for 30 days.
+
+If --audit is provided, each schedule and customer change made through the
+sidecar is appended to the file as a JSON line, with the actor given in the
+Tier-Actor request header, and the state of the org before and after it. The
+log is served at /v1/audit.
+
+If --accounts is provided, the sidecar serves the Stripe accounts in the
+file instead of the connected account. The file is a JSON object mapping
+account names to the environment variable holding each account's key, and
+optionally the prefixes of its orgs:
+
+	{
+		"acme": {"key_env": "ACME_STRIPE_KEY", "orgs": ["org:acme-"]},
+		"globex": {"key_env": "GLOBEX_STRIPE_KEY"}
+	}
+
+Each request is served for the account named in its Tier-Account header, or
+else for the account with the longest prefix of its org. Requests selecting
+no account are rejected. --store may not be used with --accounts.
+
+If --provider is paddle, the sidecar serves the Paddle Billing account with
+the key in PADDLE_API_KEY instead of a Stripe account; accounts in the
+--accounts file may set "provider": "paddle" likewise. Paddle supports
+features with flat prices, and metered features with a single tier, billed
+by one-time charges as usage is reported. Orgs without a subscription are
+subscribed through Paddle checkout. Test clocks and the audit log are only
+available with Stripe.
+
+If --notify-webhook is provided, lifecycle events of orgs are posted as JSON
+to the URL: "subscribe", "cancel", "payment_failed", and "limit_reached".
+If TIER_NOTIFY_SECRET is set, each event is signed with it in a
+Tier-Signature header, in the format of Stripe's Stripe-Signature header.
+
+If --notify-slack is provided, failed payments and limits reached are posted
+to the Slack incoming webhook URL. --notify-slack-templates names a JSON file
+mapping event types to Go text/template templates of the messages to post
+instead, executed with the event as posted to --notify-webhook, such as:
+
+	{
+		"payment_failed": "Payment of {{amount .Invoice.AmountDue .Invoice.Currency}} by {{.Org}} failed",
+		"cancel": "{{.Org}} canceled"
+	}
+
+Cancellations and failed payments are learned from Stripe, which must send
+its customer.subscription.deleted and invoice.payment_failed events to the
+sidecar's /v1/webhook endpoint, verified with the webhook signing secret in
+STRIPE_WEBHOOK_SECRET. Notifications are only sent with Stripe.
+
+If --events is provided, the sidecar keeps its 100 most recent lifecycle
+events, and the usage reported, as "report" events, and streams them from
+/v1/events to ("tier events"). Since limits are then looked up after each
+report, to learn of limits reached, reports take longer.
+
+If --metrics-addr is provided, the sidecar serves metrics at /metrics on that
+address, such as ":9090", in the Prometheus text format: the duration of the
+requests it serves, by path and status, and of the requests it makes to
+Stripe, and the durations of pushes, schedules, and usage reports.
+
+If --health-path is provided, such as "/healthz", the sidecar answers
+requests for the path with a 200 response, without calling Stripe, for load
+balancers and orchestrators to check it is up.
+
+The --log-requests flag sets which requests are logged to stderr, one line
+each with the method, path, org, status, size, and duration of the request:
+none (the default), errors, for those failing with a 4xx or 5xx status, or
+all. Health checks are not logged.
+
+If --config is provided, or TIER_CONFIG is set, the sidecar is configured by
+the JSON file it names, which may have comments and trailing commas. Flags
+given on the command line override the file, and so do the environment
+variables named for its keys, so keys and secrets may be kept out of it. Keys
+read from the file are not set in the environment. The store, configured
+under "store", is the sidecar's cache of entitlements; the sidecar has no
+authentication of its own to configure:
+
+	{
+		"addr": ":8080",
+		"provider": "stripe",
+		"stripe": {
+			"key": "sk_test_...",          // STRIPE_API_KEY
+			"secondary_key": "",           // STRIPE_API_KEY_SECONDARY
+			"base_url": "",                // STRIPE_BASE_API_URL
+			"webhook_secret": "whsec_...", // STRIPE_WEBHOOK_SECRET
+		},
+		"paddle": {"key": ""},             // PADDLE_API_KEY
+		"store": {"path": "tier.db", "refresh": "1m"},
+		"notify": {
+			"webhook": "https://example.com/tier",
+			"secret": "",                  // TIER_NOTIFY_SECRET
+			"slack": "",
+			"slack_templates": "",
+			"events": true,
+		},
+		"accounts": "", "audit": "", "anonymous_plan": "",
+		"tls_cert": "", "tls_key": "",
+		"metrics_addr": ":9090", "health_path": "/healthz", "log_requests": "errors",
+	}
 `,
 	"switch": `Usage:
 
-	tier switch [flags] [accountID]
+	tier switch [-c] [accountID]
+	tier switch <profile>
 
 Tier switch tells tier to use the provided accountID, or, if run with "-c", to
 create and use a new isolation account, when run from the current working
 directory.
 
+If a profile name is provided instead, tier uses that profile from then on,
+in every directory; see ("tier profile").
+
 To switch back to the default account:
 
     a) rename the tier.state file
@@ -218,10 +630,69 @@ Flags:
 
     -c
 	Create a new account and switch to it.
+`,
+	"profile": `Usage:
+
+	tier profile [ls]
+	tier profile set [--test-key=<key>] [--live-key=<key>] [--account=<id>]
+	                 [--sidecar=<url>] <name>
+	tier profile rm <name>
+
+Tier profile manages named profiles, each with its own Stripe keys, account,
+and sidecar URL, so that operators working across environments, such as
+staging and production, need not juggle environment variables. Use ("tier
+switch <name>") to select a profile, or set TIER_PROFILE to use one for a
+single command. The profile "tier" is used if none is selected, and is the
+one ("tier connect") saves to unless another is selected.
+
+Tier profile ls lists the profiles, marking the one in use with "*".
+
+Tier profile set creates or updates the profile name. Only the flags provided
+are changed, so a field is cleared by providing its flag empty. If --sidecar is
+set, commands using the sidecar API, such as ("tier limits"), are sent to the
+sidecar at that URL instead of to Stripe with the profile's keys.
+
+Tier profile rm deletes the profile name.
+
+Profiles are stored in tier/config.json in XDG_CONFIG_HOME, or in ~/.config if
+it is not set. STRIPE_API_KEY, if set, is used instead of a profile's keys.
+`,
+	"completion": `Usage:
+
+	tier completion bash|zsh|fish
+
+Tier completion prints a script completing the commands, subcommands, and
+flags of tier in the provided shell. To load it in the current shell:
+
+	source <(tier completion bash)
+	source <(tier completion zsh)
+	tier completion fish | source
+
+To load it in every new shell, add the line to your shell's startup file,
+such as ~/.bashrc, ~/.zshrc, or ~/.config/fish/config.fish.
+`,
+	"json": `Usage:
+
+	tier --json <command> [arguments]
+
+If the --json flag is provided before a command, the command prints its
+output as JSON instead of tables or text, for use in scripts and automation
+pipelines. Messages meant for people, such as which files were written, are
+printed to standard error instead, so that standard output holds only JSON.
+Commands that print nothing, such as subscribe and report, print nothing
+with --json either, and their success is told by their exit status.
+
+For example, to print the limits of an org as JSON:
+
+	tier --json limits org:acme
+
+Tier events prints one JSON object a line, as it does with its own --json
+flag, and tier pull prints the pricing model as JSON regardless of its
+--format flag.
 `,
 	"clean": `Usage:
 
-	tier clean <flags>
+	tier clean -switchaccounts <age>
 
 Clean removes objects from Stripe Test Mode accounts. It can be used with a
 cron job to keep your test accounts clean.
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"tier.run/api/materialize"
+	"tier.run/control"
+	"tier.run/refs"
+)
+
+// runImport runs tier import with args, which adopts the prices of a
+// Stripe account made before tier as features.
+func runImport(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	mappingFile := fs.String("mapping", "", "file of the features to import prices as, instead of those proposed")
+	writeMapping := fs.String("write-mapping", "", "file to write the mapping of prices to features to, for editing")
+	out := fs.String("o", "", "file to write the pricing JSON of the imported features to")
+	dryRun := fs.Bool("dry-run", false, "propose the import without importing anything")
+	yes := fs.Bool("yes", false, "import without asking for confirmation")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errUsage
+	}
+
+	ps, err := cc().ProposeImport(ctx)
+	if err != nil {
+		return err
+	}
+	if *mappingFile != "" {
+		data, err := os.ReadFile(*mappingFile)
+		if err != nil {
+			return err
+		}
+		var m control.ImportMapping
+		if err := json.Unmarshal(data, &m); err != nil {
+			return fmt.Errorf("%s: %w", *mappingFile, err)
+		}
+		ps, err = applyImportMapping(ps, m)
+		if err != nil {
+			return err
+		}
+	}
+	if *flagJSON {
+		err = printJSON(importProposals(ps))
+	} else {
+		err = printImportProposals(stdout, ps)
+	}
+	if err != nil {
+		return err
+	}
+
+	m := control.ProposedMapping(ps)
+	if len(m) == 0 {
+		return errors.New("no prices to import")
+	}
+	if *writeMapping != "" {
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(*writeMapping, append(data, '\n'), 0o644); err != nil {
+			return err
+		}
+	}
+
+	var features []control.Feature
+	for _, p := range ps {
+		if p.Problem == "" {
+			features = append(features, p.Feature)
+		}
+	}
+	ds := control.Validate(features)
+	if control.HasErrors(ds) {
+		for _, d := range ds {
+			fmt.Fprintln(stderr, d)
+		}
+		return errors.New("the features proposed are invalid; edit them with --write-mapping and --mapping")
+	}
+
+	if *dryRun {
+		return writeImportedJSON(*out, features)
+	}
+	if !*yes {
+		if !isTerminal(stdin) {
+			return errors.New("not importing without confirmation; use --yes")
+		}
+		ok, err := confirm(bufio.NewReader(stdin), stderr, fmt.Sprintf("Import %d prices as features?", len(m)))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("import canceled")
+		}
+	}
+	imported, err := cc().Import(ctx, m)
+	if err != nil {
+		return err
+	}
+	if err := writeImportedJSON(*out, imported); err != nil {
+		return err
+	}
+	fmt.Fprintf(messages(), "imported %d prices\n", len(imported))
+	return nil
+}
+
+// applyImportMapping returns ps with the features proposed replaced by
+// those mapped to in m. Prices not in m are skipped, with a Problem
+// saying so. It reports an error if m maps prices not in ps.
+func applyImportMapping(ps []control.ImportProposal, m control.ImportMapping) ([]control.ImportProposal, error) {
+	mapped := 0
+	for i, p := range ps {
+		fp, ok := m[p.Feature.ProviderID]
+		switch {
+		case !ok:
+			if p.Problem == "" {
+				ps[i].Problem = "not in mapping"
+			}
+			ps[i].Feature.FeaturePlan = fp
+		case p.Problem != "":
+			return nil, fmt.Errorf("price %s cannot be imported: %s", p.Feature.ProviderID, p.Problem)
+		default:
+			ps[i].Feature.FeaturePlan = fp
+			mapped++
+		}
+	}
+	if mapped != len(m) {
+		return nil, errors.New("mapping has prices that are not active prices made outside of tier")
+	}
+	return ps, nil
+}
+
+// printImportProposals prints a table of ps to w.
+func printImportProposals(w io.Writer, ps []control.ImportProposal) error {
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "PRICE\tPRODUCT\tNICKNAME\tPRICING\tINTERVAL\tFEATURE")
+	for _, p := range ps {
+		f := p.Feature
+		pricing := fmt.Sprintf("%d %s", f.Base, f.Currency)
+		if len(f.Tiers) > 0 {
+			pricing = f.Mode + " " + f.Currency
+		}
+		interval := f.Interval
+		if f.OneTime {
+			interval = "once"
+		}
+		feature := f.FeaturePlan.String()
+		if p.Problem != "" {
+			feature = "skipped: " + p.Problem
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			f.ProviderID,
+			valueOrDash(p.Product),
+			valueOrDash(p.Nickname),
+			pricing,
+			interval,
+			feature,
+		)
+	}
+	return tw.Flush()
+}
+
+// An importProposal is a control.ImportProposal as printed by import
+// --json.
+type importProposal struct {
+	Price    string           `json:"price"`
+	Product  string           `json:"product,omitempty"`
+	Nickname string           `json:"nickname,omitempty"`
+	Feature  refs.FeaturePlan `json:"feature"`
+	Problem  string           `json:"problem,omitempty"` // why the price is skipped
+}
+
+func importProposals(ps []control.ImportProposal) []importProposal {
+	out := []importProposal{}
+	for _, p := range ps {
+		out = append(out, importProposal{
+			Price:    p.Feature.ProviderID,
+			Product:  p.Product,
+			Nickname: p.Nickname,
+			Feature:  p.Feature.FeaturePlan,
+			Problem:  p.Problem,
+		})
+	}
+	return out
+}
+
+// writeImportedJSON writes the pricing JSON of fs to the file fname, if
+// not empty.
+func writeImportedJSON(fname string, fs []control.Feature) error {
+	if fname == "" {
+		return nil
+	}
+	data, err := materialize.ToPricingJSON(fs)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(fname, append(data, '\n'), 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(messages(), "wrote %s\n", fname)
+	return nil
+}
+
+// confirm asks question on w, reading the answer from r, and reports
+// whether it is yes.
+func confirm(r *bufio.Reader, w io.Writer, question string) (bool, error) {
+	fmt.Fprintf(w, "%s [y/N]: ", question)
+	line, err := r.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return false, err
+	}
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "y", "yes":
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/control"
+	"tier.run/refs"
+	"tier.run/values"
+)
+
+func TestImportProposals(t *testing.T) {
+	proposals := func() []control.ImportProposal {
+		return []control.ImportProposal{{
+			Product:  "Pro",
+			Nickname: "Seats",
+			Feature: control.Feature{
+				ProviderID:  "price_1",
+				FeaturePlan: refs.MustParseFeaturePlan("feature:seats@plan:pro@0"),
+				Interval:    "@monthly",
+				Currency:    "usd",
+				Base:        1000,
+			},
+		}, {
+			Product: "Pro",
+			Feature: control.Feature{
+				ProviderID:  "price_2",
+				FeaturePlan: refs.MustParseFeaturePlan("feature:pro@plan:pro@0"),
+				Currency:    "usd",
+				Mode:        "graduated",
+				Tiers:       []control.Tier{{Upto: control.Inf, Price: values.MustParseDecimal("2")}},
+				Interval:    "@monthly",
+			},
+		}, {
+			Product: "Biweekly",
+			Feature: control.Feature{ProviderID: "price_3", Currency: "usd", Base: 100},
+			Problem: "interval count 2 is not supported; must be 1",
+		}}
+	}
+
+	ps, err := applyImportMapping(proposals(), control.ImportMapping{
+		"price_1": refs.MustParseFeaturePlan("feature:seats@plan:legacy@1"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b strings.Builder
+	if err := printImportProposals(&b, ps); err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, strings.Split(b.String(), "\n"), []string{
+		"PRICE    PRODUCT   NICKNAME  PRICING        INTERVAL  FEATURE",
+		"price_1  Pro       Seats     1000 usd       @monthly  feature:seats@plan:legacy@1",
+		"price_2  Pro       -         graduated usd  @monthly  skipped: not in mapping",
+		"price_3  Biweekly  -         100 usd                  skipped: interval count 2 is not supported; must be 1",
+		"",
+	})
+	diff.Test(t, t.Errorf, control.ProposedMapping(ps), control.ImportMapping{
+		"price_1": refs.MustParseFeaturePlan("feature:seats@plan:legacy@1"),
+	})
+
+	for _, m := range []control.ImportMapping{
+		{"price_3": refs.MustParseFeaturePlan("feature:x@plan:legacy@1")},
+		{"price_4": refs.MustParseFeaturePlan("feature:x@plan:legacy@1")},
+	} {
+		if _, err := applyImportMapping(proposals(), m); err == nil {
+			t.Errorf("applying %v: err = nil; want error", m)
+		}
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	for answer, want := range map[string]bool{
+		"y\n":   true,
+		" YES ": true,
+		"\n":    false,
+		"no\n":  false,
+		"":      false,
+	} {
+		got, err := confirm(bufio.NewReader(strings.NewReader(answer)), io.Discard, "Import?")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("confirm(%q) = %v; want %v", answer, got, want)
+		}
+	}
+}
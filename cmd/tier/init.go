@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"tier.run/api/apitypes"
+	"tier.run/api/materialize"
+	"tier.run/control"
+	"tier.run/refs"
+	"tier.run/values"
+)
+
+// initOptions are the choices tier init scaffolds a model with.
+type initOptions struct {
+	Currency  string // e.g. "usd"
+	Interval  string // e.g. "@monthly"
+	Price     int    // the base price of the pro plan, in the smallest currency unit
+	TrialDays int    // the trial of the pro plan
+}
+
+// scaffoldModel returns a model with a free and a pro plan, with examples
+// of licensed, metered, and flag features.
+func scaffoldModel(o initOptions) apitypes.Model {
+	return apitypes.Model{
+		Plans: map[refs.Plan]apitypes.Plan{
+			refs.MustParsePlan("plan:free@0"): {
+				Title:    "Free",
+				Currency: o.Currency,
+				Interval: o.Interval,
+				Features: map[refs.Name]apitypes.Feature{
+					refs.MustParseName("feature:seats"): {
+						Title: "Seats",
+						Tiers: []apitypes.Tier{{Upto: 1}},
+					},
+					refs.MustParseName("feature:api"): {
+						Title: "API calls",
+						Unit:  "calls",
+						Tiers: []apitypes.Tier{{Upto: 1000}},
+					},
+				},
+			},
+			refs.MustParsePlan("plan:pro@0"): {
+				Title:     "Pro",
+				Currency:  o.Currency,
+				Interval:  o.Interval,
+				TrialDays: o.TrialDays,
+				Features: map[refs.Name]apitypes.Feature{
+					refs.MustParseName("feature:base"): {
+						Title: "Pro plan",
+						Base:  o.Price,
+					},
+					refs.MustParseName("feature:seats"): {
+						Title: "Seats",
+						Tiers: []apitypes.Tier{
+							{Upto: 5},
+							{Upto: apitypes.Inf, Price: values.DecimalOf(1000)},
+						},
+					},
+					refs.MustParseName("feature:api"): {
+						Title: "API calls",
+						Unit:  "calls",
+						Tiers: []apitypes.Tier{
+							{Upto: 10000},
+							{Upto: apitypes.Inf, Price: values.MustParseDecimal("0.5")},
+						},
+					},
+					refs.MustParseName("feature:sso"): {
+						Title: "Single sign-on",
+						Flag:  true,
+					},
+				},
+			},
+		},
+	}
+}
+
+// scaffold returns the JSON of the model scaffolded with o, after
+// checking that it validates, as user provided options may not.
+func scaffold(o initOptions) ([]byte, error) {
+	data, err := json.MarshalIndent(scaffoldModel(o), "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	features, err := materialize.FromPricingHuJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	ds := control.Validate(features)
+	if control.HasErrors(ds) {
+		for _, d := range ds {
+			fmt.Fprintln(stderr, d)
+		}
+		return nil, errors.New("invalid options")
+	}
+	return append(data, '\n'), nil
+}
+
+// runInit runs tier init with args.
+func runInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	o := initOptions{}
+	fs.StringVar(&o.Currency, "currency", "usd", "the currency of the plans")
+	fs.StringVar(&o.Interval, "interval", "@monthly", "the billing interval of the plans")
+	fs.IntVar(&o.Price, "price", 2000, "the base price of the pro plan, in the smallest currency unit")
+	fs.IntVar(&o.TrialDays, "trial-days", 14, "the length of the free trial of the pro plan")
+	yes := fs.Bool("yes", false, "use the flags and defaults without asking")
+	force := fs.Bool("force", false, "overwrite the file if it exists")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errUsage
+	}
+	fname := getArg(fs.Args(), 0)
+	if fname == "" {
+		fname = "pricing.json"
+	}
+	if fname != "-" && !*force {
+		if _, err := os.Stat(fname); err == nil {
+			return fmt.Errorf("%s already exists; use --force to overwrite it", fname)
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	if !*yes && isTerminal(stdin) {
+		if err := askInitOptions(bufio.NewReader(stdin), stderr, &o); err != nil {
+			return err
+		}
+	}
+	data, err := scaffold(o)
+	if err != nil {
+		return err
+	}
+	if fname == "-" {
+		_, err := stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(fname, data, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(messages(), "wrote %s; push it with \"tier push %s\"\n", fname, fname)
+	return nil
+}
+
+// askInitOptions asks for each of the options o on w, reading the answers
+// from r. Empty answers keep the values in o.
+func askInitOptions(r *bufio.Reader, w io.Writer, o *initOptions) error {
+	ask := func(question, def string) (string, error) {
+		fmt.Fprintf(w, "%s [%s]: ", question, def)
+		line, err := r.ReadString('\n')
+		if err != nil && !(errors.Is(err, io.EOF) && line != "") {
+			return "", err
+		}
+		return values.Coalesce(strings.TrimSpace(line), def), nil
+	}
+	askInt := func(question string, v *int) error {
+		s, err := ask(question, strconv.Itoa(*v))
+		if err != nil {
+			return err
+		}
+		*v, err = strconv.Atoi(s)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", question, s)
+		}
+		return nil
+	}
+
+	var err error
+	if o.Currency, err = ask("Currency", o.Currency); err != nil {
+		return err
+	}
+	if o.Interval, err = ask("Billing interval (@daily, @weekly, @monthly, or @yearly)", o.Interval); err != nil {
+		return err
+	}
+	if err := askInt("Pro plan price, in the smallest currency unit (e.g. cents)", &o.Price); err != nil {
+		return err
+	}
+	return askInt("Pro plan trial days", &o.TrialDays)
+}
+
+// isTerminal reports whether rw, a reader or writer, is a terminal.
+func isTerminal(rw any) bool {
+	f, ok := rw.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"strings"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/api/materialize"
+)
+
+func TestScaffold(t *testing.T) {
+	for _, o := range []initOptions{
+		{Currency: "usd", Interval: "@monthly", Price: 2000, TrialDays: 14},
+		{Currency: "eur", Interval: "@yearly", Price: 0},
+	} {
+		data, err := scaffold(o)
+		if err != nil {
+			t.Fatalf("%+v: %v", o, err)
+		}
+		fs, err := materialize.FromPricingHuJSON(data)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(fs) != 6 {
+			t.Errorf("%+v: got %d features; want 6", o, len(fs))
+		}
+	}
+	if _, err := scaffold(initOptions{Currency: "dollars", Interval: "@monthly"}); err == nil {
+		t.Error("scaffolding invalid currency: err = nil; want error")
+	}
+}
+
+func TestAskInitOptions(t *testing.T) {
+	o := initOptions{Currency: "usd", Interval: "@monthly", Price: 2000, TrialDays: 14}
+	r := bufio.NewReader(strings.NewReader("eur\n\n4900\n0"))
+	if err := askInitOptions(r, io.Discard, &o); err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, o, initOptions{Currency: "eur", Interval: "@monthly", Price: 4900, TrialDays: 0})
+
+	r = bufio.NewReader(strings.NewReader("\n\nfree\n"))
+	if err := askInitOptions(r, io.Discard, &o); err == nil {
+		t.Error("answering a price that is not a number: err = nil; want error")
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"tier.run/api/apitypes"
+	"tier.run/refs"
+)
+
+// runMigrate runs the migrate subcommand with args, moving the orgs on one
+// plan to another and printing the result for each org as it goes.
+func runMigrate(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "", "the plan to migrate orgs from")
+	to := fs.String("to", "", "the plan to migrate orgs to")
+	atRenewal := fs.Bool("at-renewal", false, "migrate each org at the end of its current billing period")
+	dryRun := fs.Bool("dry-run", false, "report the orgs that would be migrated, without migrating them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 || *from == "" || *to == "" {
+		return errUsage
+	}
+	fromPlan, err := refs.ParsePlan(*from)
+	if err != nil {
+		return err
+	}
+	toPlan, err := refs.ParsePlan(*to)
+	if err != nil {
+		return err
+	}
+
+	var results []apitypes.MigrateResult
+	err = tc().Migrate(ctx, apitypes.MigrateRequest{
+		From:      fromPlan,
+		To:        toPlan,
+		AtRenewal: *atRenewal,
+		DryRun:    *dryRun,
+	}, func(r apitypes.MigrateResult) error {
+		results = append(results, r)
+		if *flagJSON {
+			data, err := json.Marshal(r)
+			if err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(stdout, "%s\n", data)
+			return err
+		}
+		return printMigrateResult(stdout, r, *dryRun)
+	})
+	if err != nil {
+		return err
+	}
+	if !*flagJSON {
+		printMigrateSummary(stdout, results, *dryRun)
+	}
+	if n := failedMigrations(results); n > 0 {
+		return fmt.Errorf("%d of %d orgs failed to migrate", n, len(results))
+	}
+	return nil
+}
+
+// printMigrateResult prints r to w as a line of its org and what became of
+// it.
+func printMigrateResult(w io.Writer, r apitypes.MigrateResult, dryRun bool) error {
+	var status string
+	switch {
+	case r.Error != "":
+		status = "failed: " + r.Error
+	case dryRun && r.Effective != nil:
+		status = "would migrate at " + r.Effective.Format(time.RFC3339)
+	case dryRun:
+		status = "would migrate"
+	case r.Effective != nil:
+		status = "migrates at " + r.Effective.Format(time.RFC3339)
+	default:
+		status = "migrated"
+	}
+	_, err := fmt.Fprintf(w, "%s\t%s\n", r.Org, status)
+	return err
+}
+
+// printMigrateSummary prints to w the number of orgs migrated and failed,
+// followed by the orgs that failed, if any.
+func printMigrateSummary(w io.Writer, results []apitypes.MigrateResult, dryRun bool) {
+	n := failedMigrations(results)
+	verb := "migrated"
+	if dryRun {
+		verb = "would migrate"
+	}
+	fmt.Fprintf(w, "\n%d %s, %d failed\n", len(results)-n, verb, n)
+	if n == 0 {
+		return
+	}
+	fmt.Fprintln(w, "\nFailed:")
+	for _, r := range results {
+		if r.Error != "" {
+			fmt.Fprintf(w, "\t%s: %s\n", r.Org, r.Error)
+		}
+	}
+}
+
+func failedMigrations(results []apitypes.MigrateResult) int {
+	var n int
+	for _, r := range results {
+		if r.Error != "" {
+			n++
+		}
+	}
+	return n
+}
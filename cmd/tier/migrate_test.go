@@ -0,0 +1,41 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+	"tier.run/api/apitypes"
+)
+
+func TestPrintMigrate(t *testing.T) {
+	renewal := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	results := []apitypes.MigrateResult{
+		{Org: "org:a"},
+		{Org: "org:b", Effective: &renewal},
+		{Org: "org:c", Error: "org has phases scheduled after its current phase"},
+	}
+
+	var b strings.Builder
+	for _, r := range results {
+		if err := printMigrateResult(&b, r, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+	printMigrateSummary(&b, results, false)
+	diff.Test(t, t.Errorf, b.String(), `org:a	migrated
+org:b	migrates at 2024-02-01T00:00:00Z
+org:c	failed: org has phases scheduled after its current phase
+
+2 migrated, 1 failed
+
+Failed:
+	org:c: org has phases scheduled after its current phase
+`)
+
+	b.Reset()
+	printMigrateResult(&b, results[0], true)
+	printMigrateSummary(&b, results[:1], true)
+	diff.Test(t, t.Errorf, b.String(), "org:a\twould migrate\n\n1 would migrate, 0 failed\n")
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"tier.run/api/apitypes"
+	"tier.run/client/tier"
+)
+
+// runOrg runs the org subcommand with args.
+func runOrg(ctx context.Context, args []string) error {
+	switch getArg(args, 0) {
+	case "get":
+		if len(args) != 2 {
+			return errUsage
+		}
+		return showOrg(ctx, args[1])
+	case "set":
+		return setOrg(ctx, args[1:])
+	default:
+		return errUsage
+	}
+}
+
+// setOrg runs org set with args, setting and unsetting the metadata of an
+// org.
+func setOrg(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("org set", flag.ExitOnError)
+	var unset stringsFlag
+	fs.Var(&unset, "unset", "remove the metadata `key`; may be repeated")
+	pos, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) < 1 {
+		return errUsage
+	}
+	md, err := parseMetadata(pos[1:], unset)
+	if err != nil {
+		return err
+	}
+	if len(md) == 0 {
+		return errUsage
+	}
+	vlogf("setting metadata of %s: %v", pos[0], md)
+	return tc().Schedule(ctx, pos[0], &tier.ScheduleParams{
+		Info: &tier.OrgInfo{Metadata: md},
+	})
+}
+
+// parseMetadata returns the metadata to set for pairs of the form
+// "key=value", with each key in unset set to the empty value, which removes
+// it. A pair with an empty value, such as "key=", removes its key likewise.
+func parseMetadata(pairs, unset []string) (map[string]string, error) {
+	md := map[string]string{}
+	for _, p := range pairs {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid metadata %q; want key=value", p)
+		}
+		if _, dup := md[k]; dup {
+			return nil, fmt.Errorf("metadata key %q set more than once", k)
+		}
+		md[k] = v
+	}
+	for _, k := range unset {
+		if v, dup := md[k]; dup && v != "" {
+			return nil, fmt.Errorf("metadata key %q both set and unset", k)
+		}
+		md[k] = ""
+	}
+	for k := range md {
+		if strings.HasPrefix(k, "tier.") {
+			return nil, fmt.Errorf("metadata key %q uses the reserved prefix \"tier.\"", k)
+		}
+	}
+	return md, nil
+}
+
+// stringsFlag is a flag.Value collecting the value of each use of a flag.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+// showOrg prints the Stripe customer, billing state, and current phase of
+// org to stdout.
+func showOrg(ctx context.Context, org string) error {
+	who, err := tc().LookupOrg(ctx, org)
+	if err != nil {
+		return err
+	}
+	var p *apitypes.PhaseResponse
+	ph, err := tc().LookupPhase(ctx, org)
+	var e *apitypes.Error
+	switch {
+	case errors.As(err, &e) && e.Status == 404:
+		// no subscription
+	case err != nil:
+		return err
+	default:
+		p = &ph
+	}
+	if *flagJSON {
+		return printJSON(struct {
+			apitypes.WhoIsResponse
+			Phase *apitypes.PhaseResponse `json:"phase"`
+		}{who, p})
+	}
+	return printOrg(stdout, who, p)
+}
+
+// printOrg prints who and the current phase p of its org to w, one field a
+// line. If p is nil, the org has no current phase.
+func printOrg(w io.Writer, who apitypes.WhoIsResponse, p *apitypes.PhaseResponse) error {
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	field := func(name string, v any) {
+		fmt.Fprintf(tw, "%s:\t%v\n", name, v)
+	}
+	field("Org", who.Org)
+	field("StripeID", who.StripeID)
+	if info := who.OrgInfo; info != nil {
+		field("Email", valueOrDash(info.Email))
+		field("Name", valueOrDash(info.Name))
+		if info.Parent != "" {
+			field("Parent", info.Parent)
+		}
+		field("Status", valueOrDash(info.SubscriptionStatus))
+		if info.PeriodEnd != nil {
+			field("PeriodEnd", info.PeriodEnd.Format(time.RFC3339))
+		}
+		if info.CancelAtPeriodEnd {
+			field("CancelAtPeriodEnd", true)
+		}
+		if info.Paused {
+			field("Paused", true)
+		}
+		field("Delinquent", info.Delinquent)
+		field("PaymentMethod", info.HasPaymentMethod)
+		if info.CreditCurrency != "" {
+			field("Credit", fmt.Sprintf("%d %s", info.Credit, info.CreditCurrency))
+		}
+		keys := maps.Keys(info.Metadata)
+		sort.Strings(keys)
+		for _, k := range keys {
+			field("Metadata."+k, info.Metadata[k])
+		}
+	}
+	if p == nil {
+		field("Phase", "-")
+		return tw.Flush()
+	}
+	field("Effective", p.Effective.Format(time.RFC3339))
+	if p.TrialEnd != nil {
+		field("TrialEnd", p.TrialEnd.Format(time.RFC3339))
+	}
+	if p.Interval != "" {
+		field("Interval", p.Interval)
+	}
+	field("Plans", joinOrDash(p.Plans))
+	field("AddOns", joinOrDash(p.AddOns))
+	field("Fragments", joinOrDash(p.Fragments))
+	return tw.Flush()
+}
+
+func valueOrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func joinOrDash[T fmt.Stringer](vs []T) string {
+	ss := make([]string, len(vs))
+	for i, v := range vs {
+		ss[i] = v.String()
+	}
+	return valueOrDash(strings.Join(ss, " "))
+}
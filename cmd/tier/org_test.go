@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+	"tier.run/api/apitypes"
+	"tier.run/refs"
+)
+
+func TestPrintOrg(t *testing.T) {
+	periodEnd := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	who := apitypes.WhoIsResponse{
+		Org:      "org:acme",
+		StripeID: "cus_123",
+		OrgInfo: &apitypes.OrgInfo{
+			Email:              "billing@acme.com",
+			Metadata:           map[string]string{"region": "eu", "crm": "42"},
+			SubscriptionStatus: "active",
+			PeriodEnd:          &periodEnd,
+			HasPaymentMethod:   true,
+		},
+	}
+
+	check := func(p *apitypes.PhaseResponse, want []string) {
+		t.Helper()
+		var b strings.Builder
+		if err := printOrg(&b, who, p); err != nil {
+			t.Fatal(err)
+		}
+		diff.Test(t, t.Errorf, strings.Split(b.String(), "\n"), want)
+	}
+	head := []string{
+		`Org:              org:acme`,
+		`StripeID:         cus_123`,
+		`Email:            billing@acme.com`,
+		`Name:             -`,
+		`Status:           active`,
+		`PeriodEnd:        2024-02-01T00:00:00Z`,
+		`Delinquent:       false`,
+		`PaymentMethod:    true`,
+		`Metadata.crm:     42`,
+		`Metadata.region:  eu`,
+	}
+	check(nil, append(head,
+		`Phase:            -`,
+		``,
+	))
+	check(&apitypes.PhaseResponse{
+		Effective: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Plans:     []refs.Plan{refs.MustParsePlan("plan:pro@1")},
+		AddOns:    []refs.FeaturePlan{refs.MustParseFeaturePlan("feature:support@1")},
+	}, append(head,
+		`Effective:        2024-01-01T00:00:00Z`,
+		`Plans:            plan:pro@1`,
+		`AddOns:           feature:support@1`,
+		`Fragments:        -`,
+		``,
+	))
+}
+
+func TestParseMetadata(t *testing.T) {
+	md, err := parseMetadata([]string{"crm=42", "region=eu=west", "old="}, []string{"legacy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, md, map[string]string{
+		"crm":    "42",
+		"region": "eu=west",
+		"old":    "",
+		"legacy": "",
+	})
+
+	for _, tt := range []struct {
+		pairs, unset []string
+	}{
+		{[]string{"crm"}, nil},
+		{[]string{"=42"}, nil},
+		{[]string{"crm=1", "crm=2"}, nil},
+		{[]string{"crm=42"}, []string{"crm"}},
+		{[]string{"tier.org=org:a"}, nil},
+		{nil, []string{"tier.org"}},
+	} {
+		if _, err := parseMetadata(tt.pairs, tt.unset); err == nil {
+			t.Errorf("parseMetadata(%q, %q) = nil error; want error", tt.pairs, tt.unset)
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"tier.run/api/materialize"
+	"tier.run/control"
+	"tier.run/refs"
+)
+
+// printJSON prints v to stdout as indented JSON, which commands print in
+// place of their usual output if tier is run with --json.
+func printJSON(v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(stdout, "%s\n", data)
+	return err
+}
+
+// messages returns where to print messages meant for people, such as what
+// a command did: stdout, or stderr if tier is run with --json, so that
+// stdout holds only JSON.
+func messages() io.Writer {
+	if *flagJSON {
+		return stderr
+	}
+	return stdout
+}
+
+// A planChange is a control.Change as printed by push --json.
+type planChange struct {
+	Op       string           `json:"op"` // "add", "change", or "remove"
+	Feature  refs.FeaturePlan `json:"feature"`
+	Interval string           `json:"interval,omitempty"`
+	Fields   []string         `json:"fields,omitempty"`
+}
+
+func planChanges(cs []control.Change) []planChange {
+	out := []planChange{}
+	for _, c := range cs {
+		out = append(out, planChange(c))
+	}
+	return out
+}
+
+// A pushResult is the outcome of pushing a feature, as printed by push
+// --json.
+type pushResult struct {
+	Status  string           `json:"status"` // "ok" or "failed"
+	Feature refs.FeaturePlan `json:"feature"`
+	Link    string           `json:"link"`
+	Reason  string           `json:"reason"`
+}
+
+// A problem is a materialize.Problem as printed by validate --json.
+type problem struct {
+	File     string `json:"file"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"`
+	Code     string `json:"code"`
+	Plan     string `json:"plan,omitempty"`
+	Feature  string `json:"feature,omitempty"`
+	Message  string `json:"message"`
+}
+
+func newProblem(p materialize.Problem) problem {
+	pr := problem{
+		File:     p.File,
+		Line:     p.Line,
+		Column:   p.Column,
+		Severity: p.Severity,
+		Code:     p.Code,
+		Message:  p.Message,
+	}
+	if !p.Plan.IsZero() {
+		pr.Plan = p.Plan.String()
+	}
+	if !p.Feature.IsZero() {
+		pr.Feature = p.Feature.String()
+	}
+	return pr
+}
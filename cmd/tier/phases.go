@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"tier.run/api/apitypes"
+)
+
+// runPhases runs the phases subcommand with args, printing the timeline of
+// the phases of an org.
+func runPhases(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("phases", flag.ExitOnError)
+	all := fs.Bool("all", false, "include the phases of canceled subscriptions")
+	pos, err := parseInterspersed(fs, args)
+	if err != nil {
+		return err
+	}
+	if len(pos) != 1 {
+		return errUsage
+	}
+	r, err := tc().LookupPhases(ctx, pos[0], *all)
+	if err != nil {
+		return err
+	}
+	if *flagJSON {
+		return printJSON(r)
+	}
+	return printPhases(stdout, r.Phases, time.Now())
+}
+
+// printPhases prints the timeline of phases to w, with the status of each
+// at now.
+func printPhases(w io.Writer, ps []apitypes.TimelinePhase, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	fmt.Fprintln(tw, "EFFECTIVE\tSTATUS\tPLANS\tADDONS\tFRAGMENTS\tTRIAL END")
+	for i, p := range ps {
+		trialEnd := "-"
+		if p.TrialEnd != nil {
+			trialEnd = p.TrialEnd.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			p.Effective.Format(time.RFC3339),
+			phaseStatus(ps, i, now),
+			joinOrDash(p.Plans),
+			joinOrDash(p.AddOns),
+			joinOrDash(p.Fragments),
+			trialEnd,
+		)
+	}
+	return tw.Flush()
+}
+
+// phaseStatus returns the status of ps[i] in the timeline ps: "canceled"
+// if it is of a canceled subscription, "current", "past", or "scheduled".
+// Phases are past or scheduled relative to the current phase, if any, or
+// else to now.
+func phaseStatus(ps []apitypes.TimelinePhase, i int, now time.Time) string {
+	p := ps[i]
+	switch {
+	case p.Canceled:
+		return "canceled"
+	case p.Current:
+		return "current"
+	}
+	if c := slices.IndexFunc(ps, func(p apitypes.TimelinePhase) bool { return p.Current }); c >= 0 {
+		now = ps[c].Effective
+	}
+	if p.Effective.After(now) {
+		return "scheduled"
+	}
+	return "past"
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+	"tier.run/api/apitypes"
+	"tier.run/refs"
+)
+
+func TestPrintPhases(t *testing.T) {
+	day := func(m, d int) time.Time { return time.Date(2024, time.Month(m), d, 0, 0, 0, 0, time.UTC) }
+	phase := func(effective time.Time, plan string) apitypes.TimelinePhase {
+		return apitypes.TimelinePhase{PhaseResponse: apitypes.PhaseResponse{
+			Effective: effective,
+			Plans:     []refs.Plan{refs.MustParsePlan(plan)},
+		}}
+	}
+
+	canceled := phase(day(1, 1), "plan:free@1")
+	canceled.Canceled = true
+	past := phase(day(1, 15), "plan:free@1")
+	current := phase(day(2, 1), "plan:pro@0")
+	current.Current = true
+	current.AddOns = []refs.FeaturePlan{refs.MustParseFeaturePlan("feature:support@1")}
+	trialEnd := day(2, 15)
+	current.TrialEnd = &trialEnd
+	scheduled := phase(day(3, 1), "plan:pro@1")
+	scheduled.Fragments = []refs.FeaturePlan{refs.MustParseFeaturePlan("feature:seats@plan:team@0")}
+
+	check := func(ps []apitypes.TimelinePhase, now time.Time, want []string) {
+		t.Helper()
+		var b strings.Builder
+		if err := printPhases(&b, ps, now); err != nil {
+			t.Fatal(err)
+		}
+		diff.Test(t, t.Errorf, strings.Split(b.String(), "\n"), want)
+	}
+	check([]apitypes.TimelinePhase{canceled, past, current, scheduled}, day(12, 1), []string{
+		"EFFECTIVE             STATUS     PLANS        ADDONS             FRAGMENTS                  TRIAL END",
+		"2024-01-01T00:00:00Z  canceled   plan:free@1  -                  -                          -",
+		"2024-01-15T00:00:00Z  past       plan:free@1  -                  -                          -",
+		"2024-02-01T00:00:00Z  current    plan:pro@0   feature:support@1  -                          2024-02-15T00:00:00Z",
+		"2024-03-01T00:00:00Z  scheduled  plan:pro@1   -                  feature:seats@plan:team@0  -",
+		"",
+	})
+
+	// Without a current phase, phases are past or scheduled relative to
+	// now.
+	check([]apitypes.TimelinePhase{past, scheduled}, day(2, 1), []string{
+		"EFFECTIVE             STATUS     PLANS        ADDONS  FRAGMENTS                  TRIAL END",
+		"2024-01-15T00:00:00Z  past       plan:free@1  -       -                          -",
+		"2024-03-01T00:00:00Z  scheduled  plan:pro@1   -       feature:seats@plan:team@0  -",
+		"",
+	})
+}
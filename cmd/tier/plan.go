@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/exp/slices"
+	"tier.run/control"
+	"tier.run/refs"
+)
+
+// errChanges is returned by push --dry-run if pushing would change Stripe.
+// The summary of the changes is already printed, so main exits with status
+// 2 without printing it.
+var errChanges = errors.New("changes would be pushed")
+
+// opNoop is the op of features that push would leave as they are.
+const opNoop = "noop"
+
+// planOps are the symbols, verbs, and ANSI colors printed by printPlan for
+// each op.
+var planOps = map[string]struct{ symbol, verb, color string }{
+	control.OpAdd:    {"+", "create", "\x1b[32m"},
+	control.OpChange: {"~", "update", "\x1b[33m"},
+	control.OpRemove: {"-", "remove", "\x1b[31m"},
+	opNoop:           {" ", "noop", "\x1b[2m"},
+}
+
+// printPlan prints to w a line for each feature in fs, and each feature in
+// changes, saying whether pushing fs would create, update, remove, or leave
+// it as it is, followed by a summary. Lines are colored if color is true.
+// It returns the number of features that would change.
+func printPlan(w io.Writer, fs []control.Feature, changes []control.Change, color bool) (changed int) {
+	type key struct {
+		fp       refs.FeaturePlan
+		interval string
+	}
+	seen := map[key]bool{}
+	for _, c := range changes {
+		seen[key{c.Feature, c.Interval}] = true
+	}
+	lines := slices.Clone(changes)
+	for _, f := range fs {
+		k := key{fp: f.FeaturePlan}
+		if f.Variant {
+			k.interval = f.Interval
+		}
+		if !seen[k] {
+			lines = append(lines, control.Change{Op: opNoop, Feature: k.fp, Interval: k.interval})
+		}
+	}
+	slices.SortStableFunc(lines, func(a, b control.Change) bool {
+		if refs.ByPlan(a.Feature, b.Feature) {
+			return true
+		}
+		if refs.ByPlan(b.Feature, a.Feature) {
+			return false
+		}
+		if a.Feature != b.Feature {
+			return a.Feature.Less(b.Feature)
+		}
+		return a.Interval < b.Interval
+	})
+
+	counts := map[string]int{}
+	for _, c := range lines {
+		op := planOps[c.Op]
+		counts[c.Op]++
+		s := fmt.Sprintf("%s %-6s %s", op.symbol, op.verb, c.Feature)
+		if c.Interval != "" {
+			s += " " + c.Interval
+		}
+		if len(c.Fields) > 0 {
+			s += " (" + strings.Join(c.Fields, ", ") + ")"
+		}
+		if color {
+			s = op.color + s + "\x1b[0m"
+		}
+		fmt.Fprintln(w, s)
+	}
+	fmt.Fprintf(w, "\nPlan: %d to create, %d to update, %d to remove, %d unchanged.\n",
+		counts[control.OpAdd], counts[control.OpChange], counts[control.OpRemove], counts[opNoop])
+	if counts[control.OpChange]+counts[control.OpRemove] > 0 {
+		fmt.Fprintln(w, "Features in Stripe are immutable, so push skips updates and removals; push a new plan version instead.")
+	}
+	return len(lines) - counts[opNoop]
+}
+
+// useColor reports whether to color output written to w: whether it is a
+// terminal, and color is not disabled by NO_COLOR or TERM=dumb.
+func useColor(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(w)
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/control"
+	"tier.run/refs"
+)
+
+func TestPrintPlan(t *testing.T) {
+	mpf := refs.MustParseFeaturePlan
+	fs := []control.Feature{
+		{FeaturePlan: mpf("feature:a@plan:pro@1")},
+		{FeaturePlan: mpf("feature:b@plan:pro@1")},
+		{FeaturePlan: mpf("feature:b@plan:pro@1"), Variant: true, Interval: "@yearly"},
+		{FeaturePlan: mpf("feature:c@plan:pro@1")},
+	}
+	changes := []control.Change{
+		{Op: control.OpAdd, Feature: mpf("feature:b@plan:pro@1"), Interval: "@yearly"},
+		{Op: control.OpChange, Feature: mpf("feature:c@plan:pro@1"), Fields: []string{"title", "tiers"}},
+		{Op: control.OpRemove, Feature: mpf("feature:d@plan:pro@1")},
+	}
+
+	var b strings.Builder
+	if n := printPlan(&b, fs, changes, false); n != 3 {
+		t.Errorf("changed = %d; want 3", n)
+	}
+	diff.Test(t, t.Errorf, strings.Split(b.String(), "\n"), []string{
+		"  noop   feature:a@plan:pro@1",
+		"  noop   feature:b@plan:pro@1",
+		"+ create feature:b@plan:pro@1 @yearly",
+		"~ update feature:c@plan:pro@1 (title, tiers)",
+		"- remove feature:d@plan:pro@1",
+		"",
+		"Plan: 1 to create, 1 to update, 1 to remove, 2 unchanged.",
+		"Features in Stripe are immutable, so push skips updates and removals; push a new plan version instead.",
+		"",
+	})
+
+	b.Reset()
+	printPlan(&b, fs[:1], nil, true)
+	if !strings.HasPrefix(b.String(), "\x1b[2m  noop") {
+		t.Errorf("colored plan = %q; want dimmed noop", b.String())
+	}
+}
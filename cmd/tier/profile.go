@@ -0,0 +1,129 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"tier.run/profile"
+	"tier.run/values"
+)
+
+// runProfile runs the profile subcommand in args, which manages the named
+// profiles selected with tier switch.
+func runProfile(args []string) error {
+	switch getArg(args, 0) {
+	case "", "ls":
+		if len(args) > 1 {
+			return errUsage
+		}
+		c, err := profile.LoadConfig()
+		if err != nil {
+			return err
+		}
+		current := profile.CurrentName()
+		names := maps.Keys(c.Profiles)
+		sort.Strings(names)
+		if *flagJSON {
+			type entry struct {
+				Name    string `json:"name"`
+				Current bool   `json:"current"`
+				Account string `json:"account,omitempty"`
+				Sidecar string `json:"sidecar,omitempty"`
+			}
+			out := []entry{}
+			for _, name := range names {
+				p := c.Profiles[name]
+				out = append(out, entry{name, name == current, p.AccountID, p.SidecarURL})
+			}
+			return printJSON(out)
+		}
+		tw := newTabWriter()
+		defer tw.Flush()
+		fmt.Fprintln(tw, "\tNAME\tACCOUNT\tSIDECAR")
+		for _, name := range names {
+			p := c.Profiles[name]
+			mark := ""
+			if name == current {
+				mark = "*"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", mark, name, values.Coalesce(p.AccountID, "-"), values.Coalesce(p.SidecarURL, "-"))
+		}
+		return nil
+	case "set":
+		fs := flag.NewFlagSet("profile set", flag.ExitOnError)
+		testKey := fs.String("test-key", "", "the Stripe test mode secret key")
+		liveKey := fs.String("live-key", "", "the Stripe live mode secret key")
+		account := fs.String("account", "", "the ID of the Stripe account the keys belong to")
+		sidecar := fs.String("sidecar", "", "the base URL of a sidecar to send sidecar API requests to")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
+			return errUsage
+		}
+		name := fs.Arg(0)
+		p, err := profile.Load(name)
+		if errors.Is(err, profile.ErrProfileNotFound) {
+			p, err = &profile.Profile{}, nil
+		}
+		if err != nil {
+			return err
+		}
+		// Only flags provided change the profile, so that they may be
+		// cleared by providing them empty.
+		fs.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "test-key":
+				p.TestAPIKey = *testKey
+			case "live-key":
+				p.LiveAPIKey = *liveKey
+			case "account":
+				p.AccountID = *account
+			case "sidecar":
+				p.SidecarURL = *sidecar
+			}
+		})
+		if err := checkProfile(p); err != nil {
+			return err
+		}
+		return profile.Save(name, p)
+	case "rm":
+		if len(args) != 2 {
+			return errUsage
+		}
+		return profile.Delete(args[1])
+	default:
+		return errUsage
+	}
+}
+
+// checkProfile reports an error if the keys or account of p are for the
+// wrong mode or malformed, which would otherwise only be found when they
+// are first used.
+func checkProfile(p *profile.Profile) error {
+	hasPrefix := func(key string, prefixes ...string) bool {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		}
+		return key == ""
+	}
+	if !hasPrefix(p.TestAPIKey, "sk_test_", "rk_test_") {
+		return errors.New("--test-key must be a Stripe test mode secret key")
+	}
+	if !hasPrefix(p.LiveAPIKey, "sk_live_", "rk_live_") {
+		return errors.New("--live-key must be a Stripe live mode secret key")
+	}
+	if !hasPrefix(p.AccountID, "acct_") {
+		return errors.New("--account must be a Stripe account ID")
+	}
+	if p.SidecarURL != "" && !strings.HasPrefix(p.SidecarURL, "http://") && !strings.HasPrefix(p.SidecarURL, "https://") {
+		return errors.New("--sidecar must be an http or https URL")
+	}
+	return nil
+}
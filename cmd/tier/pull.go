@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"tier.run/api/apitypes"
+	"tier.run/refs"
+	"tier.run/values"
+)
+
+// printModel prints m to w in format, one of "json", "table", or
+// "pretty".
+func printModel(w io.Writer, m apitypes.Model, format string) error {
+	switch format {
+	case "", "json":
+		data, err := json.MarshalIndent(m, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "%s\n", data)
+	case "table":
+		printModelTable(w, m)
+	case "pretty":
+		printModelPretty(w, m)
+	default:
+		return fmt.Errorf("unknown format %q; want json, table, or pretty", format)
+	}
+	return nil
+}
+
+// sortedPlans returns the plans of m ordered by name, then version.
+func sortedPlans(m apitypes.Model) []refs.Plan {
+	plans := maps.Keys(m.Plans)
+	slices.SortFunc(plans, func(a, b refs.Plan) bool {
+		if a.Name() != b.Name() {
+			return a.Name() < b.Name()
+		}
+		return refs.CompareVersions(a.Version(), b.Version()) < 0
+	})
+	return plans
+}
+
+// sortedFeatures returns the names of the features in fs, in order.
+func sortedFeatures(fs map[refs.Name]apitypes.Feature) []refs.Name {
+	names := maps.Keys(fs)
+	slices.SortFunc(names, refs.Name.Less)
+	return names
+}
+
+// sortedAddOns returns the add-ons of m, in order.
+func sortedAddOns(m apitypes.Model) []refs.FeaturePlan {
+	fps := maps.Keys(m.AddOns)
+	slices.SortFunc(fps, refs.FeaturePlan.Less)
+	return fps
+}
+
+// printModelTable prints a row for each feature in m, like ls, with its
+// billing interval and currency.
+func printModelTable(w io.Writer, m apitypes.Model) {
+	tw := tabwriter.NewWriter(w, 0, 2, 2, ' ', 0)
+	defer tw.Flush()
+	fmt.Fprintln(tw, "PLAN\tFEATURE\tINTERVAL\tCURRENCY\tMODE\tAGG\tBASE\tTIERS")
+	row := func(plan, feature, interval, currency string, f apitypes.Feature) {
+		mode, agg := values.Coalesce(f.Mode, "graduated"), values.Coalesce(f.Aggregate, "sum")
+		if len(f.Tiers) == 0 {
+			mode, agg = "-", "-"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%d\n",
+			plan, feature,
+			values.Coalesce(interval, "-"), values.Coalesce(currency, "-"),
+			mode, agg, f.Base, len(f.Tiers))
+	}
+	for _, plan := range sortedPlans(m) {
+		p := m.Plans[plan]
+		for _, n := range sortedFeatures(p.Features) {
+			row(plan.String(), n.String(), p.Interval, p.Currency, p.Features[n])
+		}
+	}
+	for _, fp := range sortedAddOns(m) {
+		a := m.AddOns[fp]
+		row("-", fp.String(), a.Interval, a.Currency, a.Feature)
+	}
+}
+
+// printModelPretty prints m as an indented outline of its plans and their
+// features, with their prices.
+func printModelPretty(w io.Writer, m apitypes.Model) {
+	for i, plan := range sortedPlans(m) {
+		if i > 0 {
+			fmt.Fprintln(w)
+		}
+		p := m.Plans[plan]
+		fmt.Fprintf(w, "%s", plan)
+		if p.Title != "" && p.Title != plan.String() {
+			fmt.Fprintf(w, " %q", p.Title)
+		}
+		fmt.Fprintf(w, " (%s, %s)", values.Coalesce(p.Interval, "@monthly"), values.Coalesce(p.Currency, "usd"))
+		if p.TrialDays > 0 {
+			fmt.Fprintf(w, ", %d day trial", p.TrialDays)
+		}
+		if len(p.Tags) > 0 {
+			fmt.Fprintf(w, " [%s]", strings.Join(p.Tags, ", "))
+		}
+		if p.Archived {
+			fmt.Fprint(w, " archived")
+		}
+		fmt.Fprintln(w)
+		for _, n := range sortedFeatures(p.Features) {
+			fmt.Fprintf(w, "  %s: %s\n", n, describePrice(p.Features[n]))
+		}
+	}
+	if len(m.AddOns) > 0 {
+		if len(m.Plans) > 0 {
+			fmt.Fprintln(w)
+		}
+		fmt.Fprintln(w, "add-ons")
+		for _, fp := range sortedAddOns(m) {
+			a := m.AddOns[fp]
+			fmt.Fprintf(w, "  %s (%s, %s): %s\n", fp,
+				values.Coalesce(a.Interval, "@monthly"), values.Coalesce(a.Currency, "usd"),
+				describePrice(a.Feature))
+		}
+	}
+}
+
+// describePrice describes the price of f, such as "100 each" or
+// "graduated: up to 10 at 0, then 5".
+func describePrice(f apitypes.Feature) string {
+	var s string
+	switch {
+	case f.Flag:
+		s = "flag"
+	case f.OneTime:
+		s = fmt.Sprintf("%d once", f.Base)
+	case len(f.Tiers) == 0:
+		s = fmt.Sprintf("%d each", f.Base)
+	default:
+		var ts []string
+		for i, t := range f.Tiers {
+			var at string
+			switch {
+			case i == len(f.Tiers)-1 && t.Upto == apitypes.Inf && i > 0:
+				at = "then "
+			case t.Upto == apitypes.Inf:
+				at = "any "
+			default:
+				at = fmt.Sprintf("up to %d ", t.Upto)
+			}
+			at += "at " + t.Price.String()
+			if t.Base > 0 {
+				at += fmt.Sprintf(" + %d", t.Base)
+			}
+			ts = append(ts, at)
+		}
+		s = fmt.Sprintf("%s/%s: %s",
+			values.Coalesce(f.Mode, "graduated"), values.Coalesce(f.Aggregate, "sum"),
+			strings.Join(ts, ", "))
+		if f.Mode == "package" {
+			s += fmt.Sprintf(" per %d", f.PackageSize)
+		}
+	}
+	if f.Rollout != 0 {
+		s += fmt.Sprintf(" (rolled out to %d%%)", f.Rollout)
+	}
+	return s
+}
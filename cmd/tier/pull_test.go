@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/api/apitypes"
+	"tier.run/refs"
+	"tier.run/values"
+)
+
+func TestPrintModel(t *testing.T) {
+	m := apitypes.Model{
+		Plans: map[refs.Plan]apitypes.Plan{
+			refs.MustParsePlan("plan:pro@1"): {
+				Title:     "Pro",
+				TrialDays: 14,
+				Features: map[refs.Name]apitypes.Feature{
+					refs.MustParseName("feature:seats"): {Base: 1000},
+					refs.MustParseName("feature:sso"):   {Flag: true, Rollout: 20},
+					refs.MustParseName("feature:api"): {Tiers: []apitypes.Tier{
+						{Upto: 100},
+						{Upto: apitypes.Inf, Price: values.MustParseDecimal("0.5")},
+					}},
+				},
+			},
+		},
+		AddOns: map[refs.FeaturePlan]apitypes.AddOn{
+			refs.MustParseFeaturePlan("feature:support@1"): {
+				Feature:  apitypes.Feature{Base: 5000},
+				Interval: "@yearly",
+			},
+		},
+	}
+
+	check := func(format string, want []string) {
+		t.Helper()
+		var b strings.Builder
+		if err := printModel(&b, m, format); err != nil {
+			t.Fatal(err)
+		}
+		diff.Test(t, t.Errorf, strings.Split(b.String(), "\n"), want)
+	}
+	check("pretty", []string{
+		`plan:pro@1 "Pro" (@monthly, usd), 14 day trial`,
+		`  feature:api: graduated/sum: up to 100 at 0, then at 0.5`,
+		`  feature:seats: 1000 each`,
+		`  feature:sso: flag (rolled out to 20%)`,
+		``,
+		`add-ons`,
+		`  feature:support@1 (@yearly, usd): 5000 each`,
+		``,
+	})
+	check("table", []string{
+		`PLAN        FEATURE            INTERVAL  CURRENCY  MODE       AGG  BASE  TIERS`,
+		`plan:pro@1  feature:api        -         -         graduated  sum  0     2`,
+		`plan:pro@1  feature:seats      -         -         -          -    1000  0`,
+		`plan:pro@1  feature:sso        -         -         -          -    0     0`,
+		`-           feature:support@1  @yearly   -         -          -    5000  0`,
+		``,
+	})
+	if err := printModel(&strings.Builder{}, m, "yaml"); err == nil {
+		t.Error("printing yaml: err = nil; want error")
+	}
+}
@@ -1,28 +1,412 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
 
 	"tier.run/api"
 	"tier.run/control"
+	"tier.run/metrics"
+	"tier.run/notify"
+	"tier.run/paddle"
 	"tier.run/profile"
+	"tier.run/refs"
 	"tier.run/stripe"
+	"tier.run/values"
 )
 
-func serve(addr string) error {
-	ln, err := net.Listen("tcp", addr)
+type serveOptions struct {
+	addr     string
+	tlsCert  string        // TLS certificate file, if serving HTTPS
+	tlsKey   string        // TLS key file, if serving HTTPS
+	store    string        // file to save entitlements in, if any
+	refresh  time.Duration // how often to refresh store
+	anonPlan string        // plan of anonymous subjects, if any; requires store
+	audit    string        // file to append the audit log to, if any
+	accounts string        // accounts file, if serving many accounts
+	provider string        // "stripe" or "paddle"; if empty, "stripe"
+
+	notifyWebhook  string // URL to post lifecycle events to, if any
+	notifySlack    string // Slack incoming webhook URL, if any
+	slackTemplates string // file of Slack templates by event, if any
+	events         bool   // whether to serve /v1/events
+
+	metricsAddr string // address to serve Prometheus metrics on, if any
+	healthPath  string // path to serve health checks at, if any
+	logRequests string // requests to log: "none", "errors", or "all"
+
+	keys serveKeys
+}
+
+// serveKeys are the secrets and Stripe settings of the sidecar, from the
+// environment or else the file given to serve --config. They are passed to
+// the clients that need them, rather than set in the environment, so that
+// processes the sidecar starts do not inherit them.
+type serveKeys struct {
+	stripeKey          string // STRIPE_API_KEY; if empty, the profile's key is used
+	stripeKeySource    string // where stripeKey came from
+	stripeSecondaryKey string // STRIPE_API_KEY_SECONDARY
+	stripeBaseURL      string // STRIPE_BASE_API_URL
+	webhookSecret      string // STRIPE_WEBHOOK_SECRET
+	paddleKey          string // PADDLE_API_KEY
+	notifySecret       string // TIER_NOTIFY_SECRET
+}
+
+// envServeKeys returns the serveKeys set in the environment.
+func envServeKeys() serveKeys {
+	k := serveKeys{
+		stripeKey:          envAPIKey,
+		stripeSecondaryKey: os.Getenv("STRIPE_API_KEY_SECONDARY"),
+		stripeBaseURL:      os.Getenv("STRIPE_BASE_API_URL"),
+		webhookSecret:      os.Getenv("STRIPE_WEBHOOK_SECRET"),
+		paddleKey:          os.Getenv("PADDLE_API_KEY"),
+		notifySecret:       os.Getenv("TIER_NOTIFY_SECRET"),
+	}
+	if k.stripeKey != "" {
+		k.stripeKeySource = "STRIPE_API_KEY"
+	}
+	return k
+}
+
+func serve(ctx context.Context, opts serveOptions) error {
+	logLevel, err := api.ParseLogLevel(values.Coalesce(opts.logRequests, "none"))
+	if err != nil {
+		return err
+	}
+	if opts.healthPath != "" && !strings.HasPrefix(opts.healthPath, "/") {
+		return fmt.Errorf("--health-path %q must begin with /", opts.healthPath)
+	}
+	var audit control.AuditLog
+	if opts.audit != "" {
+		audit = &control.FileAuditLog{Path: opts.audit}
+	}
+	var reg *metrics.Registry
+	var sink metrics.Sink // nil unless serving metrics
+	if opts.metricsAddr != "" {
+		reg = &metrics.Registry{}
+		sink = reg
+	}
+	notifier, stream, err := newNotifier(opts)
+	if err != nil {
+		return err
+	}
+
+	var h *api.Handler
+	if opts.accounts != "" {
+		if opts.store != "" {
+			return errors.New("--store is not supported with --accounts")
+		}
+		accounts, prefixes, err := loadAccounts(opts.accounts, opts.keys)
+		if err != nil {
+			return err
+		}
+		for _, p := range accounts {
+			if c, ok := p.(*control.Client); ok {
+				c.Audit = audit
+				c.Notifier = notifier
+				c.Metrics = sink
+				c.Stripe.Metrics = sink
+			}
+		}
+		h = api.NewHandler(nil, vlogf)
+		h.Accounts = accounts
+		h.OrgPrefixes = prefixes
+	} else {
+		switch opts.provider {
+		case "", "stripe":
+			var c *control.Client
+			if opts.keys.stripeKey != "" {
+				c, err = connectedClient(opts.keys.stripeKey, opts.keys.stripeKeySource, opts.keys)
+				if err != nil {
+					return err
+				}
+			} else {
+				c = cc() // the profile's key
+			}
+			c.Audit = audit
+			c.Notifier = notifier
+			c.Metrics = sink
+			c.Stripe.Metrics = sink
+			h = api.NewHandler(c, vlogf)
+		case "paddle":
+			key := opts.keys.paddleKey
+			if key == "" {
+				return errors.New("--provider=paddle requires PADDLE_API_KEY")
+			}
+			p, err := newPaddleProvider(key)
+			if err != nil {
+				return err
+			}
+			h = api.NewHandler(p, vlogf)
+		default:
+			return fmt.Errorf("unknown provider %q", opts.provider)
+		}
+	}
+	if opts.store != "" {
+		s, err := api.OpenStore(opts.store)
+		if err != nil {
+			return err
+		}
+		s.Logf = func(format string, args ...any) {
+			fmt.Fprintf(stderr, "tier: "+format+"\n", args...)
+		}
+		defer func() {
+			if err := s.Close(); err != nil {
+				fmt.Fprintf(stderr, "tier: saving store: %v\n", err)
+			}
+		}()
+		h.Store = s
+		go h.RefreshStore(ctx, opts.refresh)
+	}
+	if opts.anonPlan != "" {
+		if opts.store == "" {
+			return errors.New("--anonymous-plan requires --store")
+		}
+		p, err := refs.ParsePlan(opts.anonPlan)
+		if err != nil {
+			return err
+		}
+		h.AnonymousPlan = p
+	}
+	h.WebhookSecret = opts.keys.webhookSecret
+	h.Events = stream
+	h.Metrics = sink
+	h.HealthPath = opts.healthPath
+	h.RequestLog = logLevel
+	h.RequestLogf = logRequest
+
+	ln, err := api.Listen(opts.addr)
 	if err != nil {
 		return err
 	}
 	fmt.Fprintf(stdout, "listening on %s\n", ln.Addr())
 
-	h := api.NewHandler(cc(), vlogf)
-	return http.Serve(ln, h)
+	// Shut down gracefully on SIGINT and SIGTERM, and restart on SIGHUP,
+	// handing the listener to the new process before shutting down.
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if reg != nil {
+		mln, err := listenMetrics(opts.metricsAddr)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "serving metrics on %s/metrics\n", mln.Addr())
+		go serveMetrics(ctx, mln, reg)
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	go func() {
+		for {
+			select {
+			case <-hup:
+				p, err := api.Restart(ln)
+				if err != nil {
+					fmt.Fprintf(stderr, "tier: restarting: %v\n", err)
+					continue
+				}
+				fmt.Fprintf(stdout, "restarted as pid %d; shutting down\n", p.Pid)
+				cancel()
+				return
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return h.Serve(ctx, ln, api.ServeOptions{
+		CertFile: opts.tlsCert,
+		KeyFile:  opts.tlsKey,
+	})
+}
+
+// logRequest logs a request served, as selected by serve --log-requests, to
+// stderr.
+func logRequest(format string, args ...any) {
+	fmt.Fprintf(stderr, "%s %s\n", time.Now().UTC().Format(time.RFC3339), fmt.Sprintf(format, args...))
+}
+
+// listenMetrics listens on addr to serve metrics. Since a sidecar restarted
+// by SIGHUP starts before the old one stops serving metrics, it retries for
+// a few seconds while addr is in use.
+func listenMetrics(addr string) (net.Listener, error) {
+	for i := 0; ; i++ {
+		ln, err := net.Listen("tcp", addr)
+		if err == nil || i == 10 || !errors.Is(err, syscall.EADDRINUSE) {
+			return ln, err
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// serveMetrics serves the metrics in reg at /metrics on ln, in the
+// Prometheus text format, until ctx is done.
+func serveMetrics(ctx context.Context, ln net.Listener, reg *metrics.Registry) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", reg)
+	s := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		s.Close()
+	}()
+	if err := s.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fmt.Fprintf(stderr, "tier: serving metrics: %v\n", err)
+	}
+}
+
+// newNotifier returns the notifier of the lifecycle events of orgs
+// configured by opts, or nil if none is, and the stream of events to serve
+// from /v1/events, if opts.events is set.
+func newNotifier(opts serveOptions) (control.Notifier, *notify.Stream, error) {
+	var m notify.Multi
+	var stream *notify.Stream
+	if opts.events {
+		stream = &notify.Stream{}
+		m = append(m, stream)
+	}
+	if opts.notifyWebhook != "" {
+		w := &notify.Webhook{
+			URL:    opts.notifyWebhook,
+			Secret: opts.keys.notifySecret,
+		}
+		w.Logf = vlogf
+		m = append(m, w)
+	}
+	if opts.notifySlack != "" {
+		s := &notify.Slack{URL: opts.notifySlack}
+		s.Logf = vlogf
+		if opts.slackTemplates != "" {
+			data, err := os.ReadFile(opts.slackTemplates)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err := json.Unmarshal(data, &s.Templates); err != nil {
+				return nil, nil, fmt.Errorf("slack templates %s: %w", opts.slackTemplates, err)
+			}
+		}
+		m = append(m, s)
+	} else if opts.slackTemplates != "" {
+		return nil, nil, errors.New("--notify-slack-templates requires --notify-slack")
+	}
+	if len(m) == 0 {
+		return nil, nil, nil
+	}
+	return m, stream, nil
+}
+
+// An accountConfig configures an account served by a sidecar serving many
+// accounts, as read from the file given to serve --accounts.
+type accountConfig struct {
+	// Provider is the billing provider of the account, "stripe" or
+	// "paddle". If empty, "stripe" is used.
+	Provider string `json:"provider"`
+
+	// KeyEnv names the environment variable holding the account's API
+	// key, so keys are not kept in the file.
+	KeyEnv string `json:"key_env"`
+
+	// Orgs lists the prefixes of the orgs of the account, such as
+	// "org:acme-", for requests not naming the account in a Tier-Account
+	// header.
+	Orgs []string `json:"orgs"`
+}
+
+// loadAccounts reads the accounts file at path, a JSON object mapping
+// account names to accountConfigs, and returns a provider for each account
+// by name, and the account names by org prefix. The Stripe clients of the
+// accounts use the settings in keys, but not its keys.
+func loadAccounts(path string, keys serveKeys) (accounts map[string]control.Provider, prefixes map[string]string, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var configs map[string]accountConfig
+	if err := json.Unmarshal(data, &configs); err != nil {
+		return nil, nil, fmt.Errorf("accounts %s: %w", path, err)
+	}
+	accounts = map[string]control.Provider{}
+	prefixes = map[string]string{}
+	for name, ac := range configs {
+		key := os.Getenv(ac.KeyEnv)
+		if key == "" {
+			return nil, nil, fmt.Errorf("accounts %s: %s: no key in $%s", path, name, ac.KeyEnv)
+		}
+		var p control.Provider
+		switch ac.Provider {
+		case "", "stripe":
+			p, err = newControlClient(key, "$"+ac.KeyEnv, "", "", keys)
+		case "paddle":
+			p, err = newPaddleProvider(key)
+		default:
+			err = fmt.Errorf("unknown provider %q", ac.Provider)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("accounts %s: %s: %w", path, name, err)
+		}
+		accounts[name] = p
+		for _, p := range ac.Orgs {
+			if other, ok := prefixes[p]; ok {
+				return nil, nil, fmt.Errorf("accounts %s: org prefix %q in both %s and %s", path, p, other, name)
+			}
+			prefixes[p] = name
+		}
+	}
+	return accounts, prefixes, nil
+}
+
+// newPaddleProvider returns a provider for the Paddle account with key,
+// using the sandbox unless key is a live key.
+func newPaddleProvider(key string) (*paddle.Provider, error) {
+	if paddle.IsLiveKey(key) != *flagLive {
+		return nil, errors.New("--live is required with, and only with, live paddle keys")
+	}
+	return &paddle.Provider{
+		Paddle: &paddle.Client{APIKey: key},
+		Logf:   vlogf,
+	}, nil
+}
+
+// newControlClient returns a client for the Stripe account with key, read
+// from source, and the provided account ID and key prefix, if any, using
+// the secondary key and base URL in keys.
+func newControlClient(key, source, accountID, keyPrefix string, keys serveKeys) (*control.Client, error) {
+	if stripe.IsLiveKey(key) {
+		if !*flagLive {
+			return nil, errors.New("--live is required if stripe key is a live key")
+		}
+	} else {
+		if *flagLive {
+			return nil, errors.New("--live provided with test key")
+		}
+	}
+	sc := &stripe.Client{
+		APIKey:          key,
+		SecondaryAPIKey: keys.stripeSecondaryKey, // for key rotation
+		KeyPrefix:       keyPrefix,
+		AccountID:       accountID,
+		Logf:            vlogf,
+		BaseURL:         values.Coalesce(keys.stripeBaseURL, stripe.BaseURL()),
+		AllowLive:       *flagLive, // required above for live keys
+	}
+	return &control.Client{
+		Stripe:    sc,
+		Logf:      vlogf,
+		KeySource: source,
+	}, nil
 }
 
 var controlClient *control.Client
@@ -37,45 +421,29 @@ func cc() *control.Client {
 			}
 			os.Exit(1)
 		}
-
-		if stripe.IsLiveKey(key) {
-			if !*flagLive {
-				fmt.Fprintf(stderr, "tier: --live is required if stripe key is a live key\n")
-				os.Exit(1)
-			}
-		} else {
-			if *flagLive {
-				fmt.Fprintf(stderr, "tier: --live provided with test key\n")
-				os.Exit(1)
-			}
-		}
-
-		a, err := getState()
+		controlClient, err = connectedClient(key, source, envServeKeys())
 		if err != nil {
-			fmt.Fprintf(stderr, "tier: %v", err)
+			fmt.Fprintf(stderr, "tier: %v\n", err)
 			os.Exit(1)
 		}
-
-		keyPrefix := a.ID
-		if keyPrefix == "" {
-			keyPrefix = os.Getenv("TIER_KEY_PREFIX")
-		}
-		sc := &stripe.Client{
-			APIKey:    key,
-			KeyPrefix: keyPrefix,
-			AccountID: a.ID,
-			Logf:      vlogf,
-			BaseURL:   stripe.BaseURL(),
-		}
-		controlClient = &control.Client{
-			Stripe:    sc,
-			Logf:      vlogf,
-			KeySource: source,
-		}
 	}
 	return controlClient
 }
 
+// connectedClient returns a client for the Stripe account with key, read
+// from source, in the isolated environment switched to, if any.
+func connectedClient(key, source string, keys serveKeys) (*control.Client, error) {
+	a, err := getState()
+	if err != nil {
+		return nil, err
+	}
+	keyPrefix := a.ID
+	if keyPrefix == "" {
+		keyPrefix = os.Getenv("TIER_KEY_PREFIX")
+	}
+	return newControlClient(key, source, a.ID, keyPrefix, keys)
+}
+
 const stateFile = "tier.state"
 
 func saveState(a stripe.Account) error {
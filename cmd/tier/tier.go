@@ -13,6 +13,7 @@ import (
 	"net/http/httptest"
 	"net/url"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strconv"
 	"strings"
@@ -27,7 +28,9 @@ import (
 	"tier.run/client/tier"
 	"tier.run/control"
 	"tier.run/profile"
+	"tier.run/refs"
 	"tier.run/stripe"
+	"tier.run/values"
 	"tier.run/version"
 )
 
@@ -36,6 +39,7 @@ var (
 	flagLive     = flag.Bool("live", false, "use live Stripe key (default is false)")
 	flagVerbose  = flag.Bool("v", false, "verbose output")
 	flagMainHelp = flag.Bool("h", false, "show this message")
+	flagJSON     = flag.Bool("json", false, "print output as JSON, for scripts")
 )
 
 // Env
@@ -77,6 +81,9 @@ func main() {
 			}
 			os.Exit(1)
 		}
+		if errors.Is(err, errChanges) {
+			os.Exit(2)
+		}
 		log.Fatalf("tier: %v", err)
 	}
 }
@@ -161,23 +168,74 @@ func runTier(cmd string, args []string) (err error) {
 		}
 		return help(stdout, args[0])
 	case "version":
+		if *flagJSON {
+			return printJSON(struct {
+				Version string `json:"version"`
+				Commit  string `json:"commit,omitempty"`
+				Dirty   bool   `json:"dirty,omitempty"`
+				Go      string `json:"go"`
+			}{version.Short, version.GitCommit, version.GitDirty, runtime.Version()})
+		}
 		fmt.Println(version.String())
 		return nil
+	case "completion":
+		if len(args) != 1 {
+			return errUsage
+		}
+		return printCompletion(stdout, args[0])
 	case "init":
-		panic("TODO")
+		return runInit(args)
 	case "push":
-		pj := ""
-		if len(args) > 0 {
-			pj = args[0]
+		dryRun := fs.Bool("dry-run", false, "print what would be pushed, without pushing; exit 2 if anything would change")
+		showDiff := fs.Bool("diff", false, "print what will be pushed before pushing")
+		if err := fs.Parse(args); err != nil {
+			return err
 		}
+		pj := fs.Arg(0)
 
-		f, err := fileOrStdin(pj)
-		if err != nil {
-			return err
+		var out struct {
+			Changes []planChange `json:"changes,omitempty"`
+			Pushed  []pushResult `json:"pushed,omitempty"`
+		}
+		if *dryRun || *showDiff {
+			features, err := readModel(pj)
+			if err != nil {
+				return err
+			}
+			ds := control.Validate(features)
+			for _, d := range ds {
+				fmt.Fprintln(stderr, d)
+			}
+			if control.HasErrors(ds) {
+				return errors.New("invalid pricing model")
+			}
+			changes, err := cc().Diff(ctx, features)
+			if err != nil {
+				return err
+			}
+			var n int
+			if *flagJSON {
+				out.Changes, n = planChanges(changes), len(changes)
+			} else {
+				n = printPlan(stdout, features, changes, useColor(stdout))
+			}
+			if *dryRun {
+				if *flagJSON {
+					if err := printJSON(out); err != nil {
+						return err
+					}
+				}
+				if n > 0 {
+					return errChanges
+				}
+				return nil
+			}
+			if !*flagJSON {
+				fmt.Fprintln(stdout)
+			}
 		}
-		defer f.Close()
 
-		err = pushJSON(ctx, f, func(f control.Feature, err error) {
+		err := pushJSON(ctx, pj, func(f control.Feature, err error) {
 			aid := cc().Stripe.AccountID
 			if aid == "" && envAPIKey == "" {
 				aid = p.AccountID
@@ -200,6 +258,10 @@ func runTier(cmd string, args []string) (err error) {
 				link = "-"
 			}
 
+			if *flagJSON {
+				out.Pushed = append(out.Pushed, pushResult{status, f.FeaturePlan, link, reason})
+				return
+			}
 			fmt.Fprintf(stdout, "%s\t%s\t%s\t%s\t[%s]\n",
 				status,
 				f.Plan(),
@@ -208,23 +270,74 @@ func runTier(cmd string, args []string) (err error) {
 				reason,
 			)
 		})
+		if *flagJSON {
+			if err := printJSON(out); err != nil {
+				return err
+			}
+		}
 		if errors.Is(err, control.ErrPlanExists) {
 			//lint:ignore ST1005 this error is not used like normal errors
 			return fmt.Errorf("illegal attempt to push features to existing plan(s); aborting.")
 		}
 		return err
-	case "pull":
-		data, err := tc().PullJSON(ctx)
+	case "validate":
+		if len(args) > 1 {
+			return errUsage
+		}
+		fname := values.Coalesce(getArg(args, 0), "pricing.json")
+		ps, err := materialize.Check(os.DirFS(filepath.Dir(fname)), filepath.Base(fname))
 		if err != nil {
 			return err
 		}
-		fmt.Fprintf(stdout, "%s\n", data)
+		var invalid bool
+		problems := []problem{}
+		for _, p := range ps {
+			p.File = filepath.Join(filepath.Dir(fname), p.File)
+			if *flagJSON {
+				problems = append(problems, newProblem(p))
+			} else {
+				fmt.Fprintln(stdout, p)
+			}
+			invalid = invalid || p.Severity == control.SeverityError
+		}
+		if *flagJSON {
+			if err := printJSON(problems); err != nil {
+				return err
+			}
+		}
+		if invalid {
+			return errors.New("invalid pricing model")
+		}
 		return nil
+	case "pull":
+		format := fs.String("format", "json", "output format: json, table, or pretty")
+		plan := fs.String("plan", "", "only pull the features of this plan, such as plan:pro@1")
+		archived := fs.Bool("include-archived", false, "include archived plans")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() > 0 {
+			return errUsage
+		}
+		if *flagJSON {
+			*format = "json"
+		}
+		m, err := tc().PullWithOptions(ctx, tier.PullOptions{
+			Plan:     *plan,
+			Archived: *archived,
+		})
+		if err != nil {
+			return err
+		}
+		return printModel(stdout, m, *format)
 	case "ls":
 		m, err := tc().Pull(ctx)
 		if err != nil {
 			return err
 		}
+		if *flagJSON {
+			return printModel(stdout, m, "json")
+		}
 
 		tw := newTabWriter()
 		defer tw.Flush()
@@ -255,6 +368,9 @@ func runTier(cmd string, args []string) (err error) {
 	case "subscribe":
 		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
 		email := fs.String("email", "", "sets the customer email address")
+		trialDays := fs.Int("trial-days", 0, "begin with a free trial of this many days")
+		effective := fs.String("effective", "", "when the plans take effect, in RFC 3339 format (default now)")
+		atPeriodEnd := fs.Bool("at-period-end", false, "take effect at the end of the current billing period")
 		if err := fs.Parse(args); err != nil {
 			return err
 		}
@@ -266,78 +382,123 @@ func runTier(cmd string, args []string) (err error) {
 			Info: &tier.OrgInfo{
 				Email: *email,
 			},
+			AtPeriodEnd: *atPeriodEnd,
 		}
 		var refs []string
 		if fs.NArg() > 1 {
 			refs = fs.Args()[1:]
-			p.Phases = []tier.Phase{{Features: refs}}
+			p.Phases = []tier.Phase{{Features: refs, TrialDays: *trialDays}}
+		} else if *trialDays != 0 || *effective != "" || *atPeriodEnd {
+			return errors.New("--trial-days, --effective, and --at-period-end require plans or features")
+		}
+		if *effective != "" {
+			if *atPeriodEnd {
+				return errors.New("--effective and --at-period-end are mutually exclusive")
+			}
+			t, err := time.Parse(time.RFC3339, *effective)
+			if err != nil {
+				return fmt.Errorf("--effective: %w", err)
+			}
+			p.Phases[0].Effective = t
 		}
 		vlogf("subscribing %s to %v", org, refs)
 		return tc().Schedule(ctx, org, p)
-	case "phases":
-		if len(args) < 1 {
+	case "checkout":
+		return runCheckout(ctx, args)
+	case "cancel":
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		atPeriodEnd := fs.Bool("at-period-end", false, "cancel at the end of the current billing period")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
 			return errUsage
 		}
-		org := args[0]
-		p, err := tc().LookupPhase(ctx, org)
-		if err != nil {
-			return err
+		vlogf("canceling subscription of %s", fs.Arg(0))
+		return tc().Cancel(ctx, fs.Arg(0), *atPeriodEnd)
+	case "pause", "resume":
+		if len(args) != 1 {
+			return errUsage
 		}
-		tw := newTabWriter()
-		defer tw.Flush()
-		fmt.Fprintln(tw, strings.Join([]string{
-			"EFFECTIVE",
-			"FEATURE",
-			"PLAN",
-		}, "\t"))
-		for _, f := range p.Features {
-			line := fmt.Sprintf("%s\t%s\t%s",
-				p.Effective.Format(time.RFC3339),
-				f.Name(),
-				f.Plan(),
-			)
-			fmt.Fprintln(tw, line)
+		if cmd == "resume" {
+			return tc().Resume(ctx, args[0])
 		}
-		return nil
+		return tc().Pause(ctx, args[0])
+	case "phases":
+		return runPhases(ctx, args)
+	case "migrate":
+		return runMigrate(ctx, args)
 	case "limits":
-		if len(args) < 1 {
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		asJSON := fs.Bool("json", false, "print the limits as JSON")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
 			return errUsage
 		}
-		org := args[0]
+		org := fs.Arg(0)
 		ur, err := tc().LookupLimits(ctx, org)
 		if err != nil {
 			return err
 		}
+		slices.SortFunc(ur.Usage, apitypes.UsageByFeature)
+		if *asJSON || *flagJSON {
+			return printJSON(ur)
+		}
 		tw := newTabWriter()
 		defer tw.Flush()
-		fmt.Fprintln(tw, "FEATURE\tLIMIT\tUSED")
+		fmt.Fprintln(tw, "FEATURE\tUSED\tLIMIT\tPERIOD START\tPERIOD END")
 		for _, u := range ur.Usage {
 			limit := strconv.Itoa(u.Limit)
 			if u.Limit == tier.Inf {
 				limit = "∞"
 			}
-			fmt.Fprintf(tw, "%s\t%s\t%d\n",
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n",
 				u.Feature,
-				limit,
 				u.Used,
+				limit,
+				formatPeriodTime(u.PeriodStart),
+				formatPeriodTime(u.PeriodEnd),
 			)
 		}
 		return nil
 	case "report":
-		org, feature, sn := getArg(args, 0), getArg(args, 1), getArg(args, 2)
-		if org == "" || feature == "" || sn == "" {
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		at := fs.String("at", "", "when the usage occurred, in RFC 3339 format (default now)")
+		set := fs.Bool("set", false, "set the usage to n, instead of adding n to it")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		org, feature, sn := fs.Arg(0), fs.Arg(1), fs.Arg(2)
+		if org == "" || feature == "" || sn == "" || fs.NArg() > 3 {
 			return errUsage
 		}
+		fn, err := refs.ParseName(feature)
+		if err != nil {
+			return err
+		}
 		n, err := strconv.Atoi(sn)
 		if err != nil {
 			return err
 		}
-		return tc().Report(ctx, org, feature, n)
+		use := control.Report{N: n, At: time.Now(), Clobber: *set}
+		if *at != "" {
+			use.At, err = time.Parse(time.RFC3339, *at)
+			if err != nil {
+				return fmt.Errorf("--at: %w", err)
+			}
+		}
+		vlogf("reporting %d of %s for %s at %v", n, fn, org, use.At)
+		return cc().ReportUsage(ctx, org, fn, use)
 	case "whoami":
 		who, err := tc().WhoAmI(ctx)
 		if err != nil {
 			return err
 		}
+		if *flagJSON {
+			return printJSON(who)
+		}
 		tw := newTabWriter()
 		defer tw.Flush()
 		fmt.Fprintf(tw, "ID:\t%v\n", who.ProviderID)
@@ -348,10 +509,18 @@ func runTier(cmd string, args []string) (err error) {
 		fmt.Fprintf(tw, "URL:\t%v\n", who.URL)
 		return nil
 	case "whois":
-		if len(args) < 1 {
+		fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+		info := fs.Bool("info", false, "show the org's billing state and current phase too")
+		if err := fs.Parse(args); err != nil {
+			return err
+		}
+		if fs.NArg() != 1 {
 			return errUsage
 		}
-		org := args[0]
+		org := fs.Arg(0)
+		if *info {
+			return showOrg(ctx, org)
+		}
 		cid, err := tc().WhoIs(ctx, org)
 		if errors.Is(err, control.ErrOrgNotFound) {
 			return fmt.Errorf("no customer found for %q", org)
@@ -359,15 +528,75 @@ func runTier(cmd string, args []string) (err error) {
 		if err != nil {
 			return err
 		}
+		if *flagJSON {
+			return printJSON(cid)
+		}
 		fmt.Fprintln(stdout, cid)
 		return nil
+	case "org":
+		return runOrg(ctx, args)
+	case "clock":
+		return runClock(ctx, args)
+	case "events":
+		return runEvents(ctx, args)
+	case "import":
+		return runImport(ctx, args)
 	case "serve":
 		fs := flag.NewFlagSet("serve", flag.ExitOnError)
 		addr := fs.String("addr", ":8080", "address to listen on (default ':8080')")
+		tlsCert := fs.String("tls-cert", "", "TLS certificate file, to serve HTTPS")
+		tlsKey := fs.String("tls-key", "", "TLS key file, to serve HTTPS")
+		store := fs.String("store", "", "file to save entitlements in, for use when Stripe is unavailable")
+		refresh := fs.Duration("refresh", time.Minute, "how often to refresh saved entitlements")
+		anonPlan := fs.String("anonymous-plan", "", "plan to entitle anonymous (\"anon:\") subjects to, tracking their usage in the store")
+		audit := fs.String("audit", "", "file to append the audit log of schedule and customer changes to")
+		accounts := fs.String("accounts", "", "file of accounts to serve, instead of the connected account")
+		provider := fs.String("provider", "stripe", "billing provider to serve: stripe or paddle")
+		notifyWebhook := fs.String("notify-webhook", "", "URL to post org lifecycle events to, signed with $TIER_NOTIFY_SECRET")
+		notifySlack := fs.String("notify-slack", "", "Slack incoming webhook URL to post org lifecycle events to")
+		slackTemplates := fs.String("notify-slack-templates", "", "file of Slack message templates by event type")
+		events := fs.Bool("events", false, "keep org lifecycle events and usage reports to stream from /v1/events")
+		metricsAddr := fs.String("metrics-addr", "", "address to serve Prometheus metrics on at /metrics, such as ':9090'")
+		healthPath := fs.String("health-path", "", "path to answer health checks at, such as '/healthz'")
+		logRequests := fs.String("log-requests", "none", "requests to log to stderr: none, errors, or all")
+		config := fs.String("config", os.Getenv("TIER_CONFIG"), "file configuring the sidecar, overridden by flags and the environment")
 		if err := fs.Parse(args); err != nil {
 			return err
 		}
-		return serve(*addr)
+		opts := serveOptions{
+			addr:     *addr,
+			tlsCert:  *tlsCert,
+			tlsKey:   *tlsKey,
+			store:    *store,
+			refresh:  *refresh,
+			anonPlan: *anonPlan,
+			audit:    *audit,
+			accounts: *accounts,
+			provider: *provider,
+
+			notifyWebhook:  *notifyWebhook,
+			notifySlack:    *notifySlack,
+			slackTemplates: *slackTemplates,
+			events:         *events,
+
+			metricsAddr: *metricsAddr,
+			healthPath:  *healthPath,
+			logRequests: *logRequests,
+
+			keys: envServeKeys(),
+		}
+		if *config != "" {
+			c, err := loadServeConfig(*config)
+			if err != nil {
+				return err
+			}
+			set := map[string]bool{}
+			fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+			if err := c.apply(&opts, set); err != nil {
+				return err
+			}
+		}
+		return serve(ctx, opts)
 	case "switch":
 		fs := flag.NewFlagSet("switch", flag.ExitOnError)
 		create := fs.Bool("c", false, "create a new isolated environment")
@@ -405,6 +634,17 @@ the tier.state file.`)
 				return errUsage
 			}
 			aid := fs.Arg(0)
+			if !strings.HasPrefix(aid, "acct_") && !strings.Contains(aid, "/") {
+				err := profile.SetCurrent(aid)
+				if errors.Is(err, profile.ErrProfileNotFound) {
+					return fmt.Errorf("no profile or account %q; see \"tier profile\"", aid)
+				}
+				if err != nil {
+					return err
+				}
+				fmt.Fprintf(messages(), "Switched to profile %q.\n", aid)
+				return nil
+			}
 			u, _ := url.Parse(aid)
 			if u != nil {
 				parts := strings.Split(u.Path, "/")
@@ -423,7 +663,7 @@ the tier.state file.`)
 		if err := saveState(a); err != nil {
 			return err
 		}
-		fmt.Fprintf(stdout, strings.TrimSpace(`
+		fmt.Fprintf(messages(), strings.TrimSpace(`
 Running in isolation mode.
 
 To switch back to normal mode, you can either:
@@ -435,8 +675,10 @@ The account dashboard is located at:
 
     https://dashboard.stripe.com/%s/test
 `), a.ID)
-		fmt.Fprintln(stdout)
+		fmt.Fprintln(messages())
 		return nil
+	case "profile":
+		return runProfile(args)
 	case "clean":
 		fs := flag.NewFlagSet("clean", flag.ExitOnError)
 		accountAge := fs.Duration("switchaccounts", -1, "garbage collect switch accounts older than a duration; default is -1")
@@ -484,22 +726,46 @@ func newID() string {
 	return hex.EncodeToString(buf[:])
 }
 
-func pushJSON(ctx context.Context, r io.Reader, cb func(control.Feature, error)) error {
-	data, err := io.ReadAll(r)
+func pushJSON(ctx context.Context, fname string, cb func(control.Feature, error)) error {
+	fs, err := readModel(fname)
 	if err != nil {
 		return err
 	}
-	fs, err := materialize.FromPricingHuJSON(data)
+	return cc().Push(ctx, fs, cb)
+}
+
+// readModel reads the pricing model in fname, merged with the files it
+// includes, or from stdin if fname is "-", in which case includes are not
+// supported.
+func readModel(fname string) ([]control.Feature, error) {
+	if fname != "" && fname != "-" {
+		return materialize.FromPricingHuJSONFile(os.DirFS(filepath.Dir(fname)), filepath.Base(fname))
+	}
+	f, err := fileOrStdin(fname)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return cc().Push(ctx, fs, cb)
+	defer f.Close()
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	return materialize.FromPricingHuJSON(data)
 }
 
 func newTabWriter() *tabwriter.Writer {
 	return tabwriter.NewWriter(stdout, 0, 2, 2, ' ', 0)
 }
 
+// formatPeriodTime formats the start or end of a billing period for the
+// limits table, or returns "-" if there is none.
+func formatPeriodTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
+
 func getArg(args []string, i int) string {
 	if i < len(args) {
 		return args[i]
@@ -510,8 +776,13 @@ func getArg(args []string, i int) string {
 var tierClient *tier.Client
 
 func tc() *tier.Client {
-	h := api.NewHandler(cc(), vlogf)
-	if tierClient == nil {
+	if tierClient != nil {
+		return tierClient
+	}
+	if u := loadProfile().SidecarURL; u != "" {
+		tierClient = tier.NewTierSidecarClient(strings.TrimSuffix(u, "/"))
+	} else {
+		h := api.NewHandler(cc(), vlogf)
 		// TODO(bmizerany): hookup logging, timeouts, etc
 		tierClient = &tier.Client{
 			HTTPClient: &http.Client{
@@ -539,7 +810,7 @@ func (t *clientTransport) RoundTrip(req *http.Request) (*http.Response, error) {
 }
 
 func loadProfile() *profile.Profile {
-	p, err := profile.Load("tier")
+	p, err := profile.Load(profile.CurrentName())
 	if err != nil {
 		vlogf("tier: %v", err)
 		p = &profile.Profile{
@@ -41,11 +41,15 @@ func testtier(t *testing.T, h http.HandlerFunc) *cline.Data {
 	// config and push to a real account.
 	home := t.TempDir()
 	t.Setenv("HOME", home) // be paranoid and just set for all tests
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("TIER_PROFILE", "")
 	chdir(t, home)
 
 	ct := cline.Test(t)
 	ct.Unsetenv("STRIPE_API_KEY") // force use of config file
 	ct.Setenv("HOME", home)
+	ct.Unsetenv("XDG_CONFIG_HOME")
+	ct.Unsetenv("TIER_PROFILE")
 	ct.Setenv("TIER_DEBUG", "1")
 	ct.Setenv("DO_NOT_TRACK", "1") // prevent tests from sending events
 
@@ -488,6 +492,57 @@ func TestSubscribe(t *testing.T) {
 	tt.GrepBothNot(".+", "unexpected output")
 }
 
+func TestReportFlags(t *testing.T) {
+	tt := testtier(t, fatalHandler(t))
+	tt.Unsetenv("TIER_DEBUG")
+	tt.RunFail("report", "org:test", "feature:x")
+	tt.GrepStderr("Usage:", "expected usage")
+
+	tt.RunFail("report", "org:test", "Feature:X", "1")
+	tt.GrepStderr("feature name must start", "expected feature name error")
+
+	tt.RunFail("report", "--at", "yesterday", "org:test", "feature:x", "1")
+	tt.GrepStderr("--at: ", "expected time error")
+}
+
+func TestLimitsFlags(t *testing.T) {
+	tt := testtier(t, fatalHandler(t))
+	tt.Unsetenv("TIER_DEBUG")
+	tt.RunFail("limits")
+	tt.GrepStderr("Usage:", "expected usage")
+
+	tt.RunFail("limits", "--json")
+	tt.GrepStderr("Usage:", "expected usage")
+
+	tt.RunFail("limits", "org:a", "org:b")
+	tt.GrepStderr("Usage:", "expected usage")
+}
+
+func TestProfiles(t *testing.T) {
+	tt := testtier(t, fatalHandler(t))
+	tt.Unsetenv("TIER_DEBUG")
+	tt.Run("profile", "set", "--test-key", "sk_test_staging", "--sidecar", "https://staging.example.com", "staging")
+	tt.RunFail("profile", "set", "--test-key", "sk_live_oops", "production")
+	tt.GrepStderr("must be a Stripe test mode secret key", "expected key error")
+
+	tt.Run("profile", "ls")
+	tt.GrepStdout(`(?m)^\*\s+tier\s`, "expected default profile in use")
+	tt.GrepStdout(`(?m)^\s+staging\s+-\s+https://staging.example.com$`, "expected staging profile")
+	tt.GrepStdoutNot("production", "unexpected invalid profile")
+
+	tt.Run("switch", "staging")
+	tt.GrepStdout(`Switched to profile "staging"`, "expected switch message")
+	tt.Run("profile")
+	tt.GrepStdout(`(?m)^\*\s+staging\s`, "expected staging profile in use")
+
+	tt.RunFail("switch", "production")
+	tt.GrepStderr(`no profile or account "production"`, "expected missing profile error")
+
+	tt.Run("profile", "rm", "staging")
+	tt.Run("profile")
+	tt.GrepStdout(`(?m)^\*\s+tier\s`, "expected default profile in use")
+}
+
 func chdir(t *testing.T, dir string) {
 	dir0, err := os.Getwd()
 	if err != nil {
@@ -0,0 +1,227 @@
+package control
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sync"
+	"time"
+
+	"tier.run/refs"
+)
+
+// Audited operations.
+const (
+	AuditSchedule          = "schedule"           // Schedule, and so SubscribeTo and ScheduleNow
+	AuditPutCustomer       = "put_customer"       // PutCustomer
+	AuditFinalizeInvoice   = "finalize_invoice"   // FinalizeInvoice
+	AuditVoidInvoice       = "void_invoice"       // VoidInvoice
+	AuditMarkUncollectible = "mark_uncollectible" // MarkInvoiceUncollectible
+	AuditRefund            = "refund"             // Refund
+	AuditCreditNote        = "credit_note"        // CreateCreditNote
+	AuditCredit            = "credit"             // AdjustCredit
+	AuditSetParent         = "set_parent"         // SetParent
+	AuditOverrides         = "overrides"          // PutOverrides
+	AuditCancel            = "cancel"             // Cancel
+	AuditPause             = "pause"              // Pause
+	AuditResume            = "resume"             // Resume
+)
+
+// An AuditEvent records an operation made by a Client that affects billing.
+type AuditEvent struct {
+	At    time.Time `json:"at"`
+	Actor string    `json:"actor,omitempty"` // as set by WithActor
+	Op    string    `json:"op"`              // one of the Audit constants
+	Org   string    `json:"org"`
+
+	// Before and After hold the state of the org before the operation,
+	// and the state requested by it, as JSON. For schedules, they hold
+	// the phases of the org; for customers, the org's info; for
+	// invoices, the ID and status of the invoice; for refunds, the
+	// amount of the charge refunded; for credit notes, the amount
+	// credited to the invoice; for credit, the org's credit balance; for
+	// parents, the org's parent; for overrides, the org's overrides; and
+	// for cancel, pause, and resume, the status of the org's subscription.
+	Before json.RawMessage `json:"before,omitempty"`
+	After  json.RawMessage `json:"after,omitempty"`
+
+	// Error is the error the operation failed with, if any. Failed
+	// operations are recorded since they may have made some changes.
+	Error string `json:"error,omitempty"`
+}
+
+// An AuditLog records audit events, and lists those recorded.
+// Implementations must be safe for concurrent use.
+type AuditLog interface {
+	Record(ctx context.Context, e AuditEvent) error
+
+	// List returns the events recorded for org, or for all orgs if org
+	// is empty, newest first.
+	List(ctx context.Context, org string) ([]AuditEvent, error)
+}
+
+type actorKey struct{}
+
+// WithActor returns a copy of ctx that causes operations made with it to be
+// audited as made by actor, such as the email of an admin.
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor set in ctx using WithActor, if any.
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
+
+// auditPhase is the state of a phase as recorded in the audit log.
+type auditPhase struct {
+	Effective *time.Time         `json:"effective,omitempty"`
+	Features  []refs.FeaturePlan `json:"features"`
+	Interval  string             `json:"interval,omitempty"`
+	TrialEnd  *time.Time         `json:"trialEnd,omitempty"`
+}
+
+// auditSchedule is the state of a schedule as recorded in the audit log.
+type auditSchedule struct {
+	Phases []auditPhase `json:"phases"`
+	Info   *OrgInfo     `json:"info,omitempty"`
+}
+
+func auditPhases(ps []Phase) []auditPhase {
+	nonZero := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+	aps := make([]auditPhase, len(ps))
+	for i, p := range ps {
+		aps[i] = auditPhase{
+			Effective: nonZero(p.Effective),
+			Features:  p.Features,
+			Interval:  p.Interval,
+			TrialEnd:  nonZero(p.TrialEnd),
+		}
+	}
+	return aps
+}
+
+// audit returns a func recording in c.Audit the operation op on org, with
+// the state before it, and the state after it and error it returns when
+// called, for use with defer. If c.Audit is nil, it does nothing.
+func (c *Client) audit(ctx context.Context, op, org string, before func() (any, error), after any, err *error) func() {
+	if c.Audit == nil {
+		return func() {}
+	}
+	e := AuditEvent{
+		Actor: ActorFromContext(ctx),
+		Op:    op,
+		Org:   org,
+	}
+	if v, berr := before(); berr != nil {
+		c.Logf("tier: audit: looking up %s before %s: %v", org, op, berr)
+	} else {
+		e.Before, _ = json.Marshal(v)
+	}
+	return func() {
+		e.At = time.Now()
+		e.After, _ = json.Marshal(after)
+		if *err != nil {
+			e.Error = (*err).Error()
+		}
+		if rerr := c.Audit.Record(ctx, e); rerr != nil {
+			c.Logf("tier: audit: recording %s of %s: %v", op, org, rerr)
+		}
+	}
+}
+
+// A MemoryAuditLog is an AuditLog holding events in memory. The zero value
+// is ready for use.
+type MemoryAuditLog struct {
+	mu     sync.Mutex
+	events []AuditEvent
+}
+
+// Record implements AuditLog.
+func (l *MemoryAuditLog) Record(_ context.Context, e AuditEvent) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.events = append(l.events, e)
+	return nil
+}
+
+// List implements AuditLog.
+func (l *MemoryAuditLog) List(_ context.Context, org string) ([]AuditEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return filterAudit(l.events, org), nil
+}
+
+// A FileAuditLog is an AuditLog appending events to a file, one JSON object
+// per line, for durability across restarts. The file may be shipped to
+// other systems, or rotated by moving it aside, as new files are created as
+// needed.
+type FileAuditLog struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+// Record implements AuditLog.
+func (l *FileAuditLog) Record(_ context.Context, e AuditEvent) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// List implements AuditLog.
+func (l *FileAuditLog) List(_ context.Context, org string) ([]AuditEvent, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	data, err := os.ReadFile(l.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var es []AuditEvent
+	s := bufio.NewScanner(bytes.NewReader(data))
+	s.Buffer(nil, len(data)+1)
+	for s.Scan() {
+		var e AuditEvent
+		if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+			return nil, err
+		}
+		es = append(es, e)
+	}
+	return filterAudit(es, org), s.Err()
+}
+
+// filterAudit returns the events in es for org, or all if org is empty,
+// newest first, given es in the order recorded.
+func filterAudit(es []AuditEvent, org string) []AuditEvent {
+	var out []AuditEvent
+	for i := len(es) - 1; i >= 0; i-- {
+		if org == "" || es[i].Org == org {
+			out = append(out, es[i])
+		}
+	}
+	return out
+}
@@ -0,0 +1,98 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"kr.dev/diff"
+)
+
+func TestAudit(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := WithActor(context.Background(), "ops@example.com")
+
+	var log MemoryAuditLog
+	tc.Audit = &log
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:free@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}}
+	if err := tc.Push(ctx, fs, pushLogger(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.PutCustomer(ctx, "org:example", &OrgInfo{Email: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SubscribeTo(ctx, "org:example", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.PutCustomer(context.Background(), "org:other", &OrgInfo{Email: "b@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := log.List(ctx, "org:example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type T struct {
+		Actor, Op, Org string
+		Before, After  string
+	}
+	var gotT []T
+	for _, e := range got {
+		if e.At.IsZero() || e.Error != "" {
+			t.Errorf("unexpected event: %+v", e)
+		}
+		gotT = append(gotT, T{e.Actor, e.Op, e.Org, string(e.Before), string(e.After)})
+	}
+	const phases = `{"phases":[{"features":["feature:x@plan:free@0"]}]}`
+	diff.Test(t, t.Errorf, gotT, []T{
+		{"ops@example.com", AuditSchedule, "org:example", `{"phases":[]}`, phases},
+		{"ops@example.com", AuditPutCustomer, "org:example", "null", `{"Email":"a@example.com","Name":"","Description":"","Phone":"","Metadata":null}`},
+	})
+
+	all, err := log.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 3 || all[0].Org != "org:other" || all[0].Actor != "" {
+		t.Errorf("List(\"\") = %+v; want 3 events, newest for org:other without actor", all)
+	}
+}
+
+func TestFileAuditLog(t *testing.T) {
+	ctx := context.Background()
+	l := &FileAuditLog{Path: filepath.Join(t.TempDir(), "audit.log")}
+
+	got, err := l.List(ctx, "")
+	if err != nil || got != nil {
+		t.Fatalf("List on missing file = %v, %v; want nil, nil", got, err)
+	}
+
+	want := []AuditEvent{
+		{Op: AuditPutCustomer, Org: "org:b", After: json.RawMessage(`{"Email":"b@example.com"}`)},
+		{Op: AuditSchedule, Org: "org:a", Actor: "ops", Error: "boom"},
+		{Op: AuditPutCustomer, Org: "org:a", Before: json.RawMessage(`null`)},
+	}
+	for i := len(want) - 1; i >= 0; i-- {
+		if err := l.Record(ctx, want[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, err = l.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, want)
+
+	got, err = l.List(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, want[1:])
+}
@@ -1,10 +1,12 @@
 package control
 
 import (
+	"context"
 	"sync"
 
 	"github.com/golang/groupcache/lru"
 	"github.com/golang/groupcache/singleflight"
+	"tier.run/refs"
 )
 
 type memo struct {
@@ -51,6 +53,16 @@ func (m *memo) load(key string, fn func() (string, error)) (string, error) {
 	return v.(string), nil
 }
 
+// remove removes key from the cache, if present, so that the next load
+// of key calls its fn.
+func (m *memo) remove(key string) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.lru != nil {
+		m.lru.Remove(key)
+	}
+}
+
 func (m *memo) add(key, val string) {
 	m.m.Lock()
 	defer m.m.Unlock()
@@ -59,3 +71,57 @@ func (m *memo) add(key, val string) {
 	}
 	m.lru.Add(key, val)
 }
+
+// planCache holds the features of each plan pushed, which cannot change
+// once the plan is pushed, so that the plans in a phase can be told
+// without pulling every price.
+type planCache struct {
+	m     sync.Mutex
+	plans map[refs.Plan][]refs.FeaturePlan
+	group singleflight.Group
+}
+
+// planFeatures returns the features in each plan in want, not including
+// variants, as pulled by PullAll. Features are pulled again only if a plan
+// in want is not cached, or has fewer features cached than want holds, as
+// it may if it was cached while it was being pushed.
+func (c *Client) planFeatures(ctx context.Context, want map[refs.Plan][]refs.FeaturePlan) (map[refs.Plan][]refs.FeaturePlan, error) {
+	pc := &c.plans
+	cached := func() map[refs.Plan][]refs.FeaturePlan {
+		pc.m.Lock()
+		defer pc.m.Unlock()
+		if pc.plans == nil {
+			return nil
+		}
+		for p, fs := range want {
+			if !p.IsZero() && len(pc.plans[p]) < len(fs) {
+				return nil
+			}
+		}
+		return pc.plans
+	}
+	if m := cached(); m != nil {
+		return m, nil
+	}
+	v, err := pc.group.Do("", func() (any, error) {
+		fs, err := c.PullAll(ctx, 0) // orgs may be subscribed to archived plans
+		if err != nil {
+			return nil, err
+		}
+		var m []refs.FeaturePlan
+		for _, f := range fs {
+			if !f.Variant {
+				m = append(m, f.FeaturePlan)
+			}
+		}
+		plans := refs.GroupByPlan(m)
+		pc.m.Lock()
+		defer pc.m.Unlock()
+		pc.plans = plans
+		return plans, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[refs.Plan][]refs.FeaturePlan), nil
+}
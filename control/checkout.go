@@ -0,0 +1,137 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"tier.run/refs"
+	"tier.run/stripe"
+)
+
+// CheckoutParams are the parameters of a Stripe Checkout session created by
+// Checkout.
+type CheckoutParams struct {
+	// Features are the features the org subscribes to by completing
+	// checkout. If empty, checkout only collects the payment details of
+	// the org, for it to be subscribed later.
+	Features []refs.FeaturePlan
+
+	// TrialDays, if set, begins the subscription with a free trial of
+	// that many days, instead of any trial given by the plans of
+	// Features.
+	TrialDays int
+
+	// CancelURL is where the org is sent if it leaves checkout without
+	// completing it. If empty, it is the success URL.
+	CancelURL string
+
+	// RequireBillingAddress, if true, makes checkout collect the billing
+	// address of the org.
+	RequireBillingAddress bool
+}
+
+// A CheckoutRequiredError is returned by providers that subscribe orgs only
+// by checkout, such as Paddle, when an org must complete checkout before it
+// is subscribed as scheduled.
+type CheckoutRequiredError struct {
+	Org string
+	URL string // the page where the org completes checkout
+}
+
+func (e *CheckoutRequiredError) Error() string {
+	return fmt.Sprintf("%s must complete checkout to subscribe: %s", e.Org, e.URL)
+}
+
+// Checkout creates a Stripe Checkout session for org, creating its customer
+// if needed, and returns the URL of the page where the org completes it.
+// Once completed, the org is sent to successURL.
+//
+// Trials given by the plans of p.Features are offered as SubscribeTo
+// offers them, and are recorded as given once offered.
+//
+// It reports a *ValidationError if successURL is empty, or if org already
+// has a subscription, which should be changed with SubscribeTo instead.
+func (c *Client) Checkout(ctx context.Context, org, successURL string, p *CheckoutParams) (string, error) {
+	if p == nil {
+		p = &CheckoutParams{}
+	}
+	if successURL == "" {
+		return "", &ValidationError{Message: "checkout requires a success URL"}
+	}
+	if p.TrialDays < 0 {
+		return "", &ValidationError{Message: "trial days must not be negative"}
+	}
+	_, err := c.lookupSubscription(ctx, org, scheduleNameTODO)
+	if err == nil {
+		return "", &ValidationError{Message: "org already has a subscription"}
+	}
+	if !errors.Is(err, ErrOrgNotFound) && !errors.Is(err, stripe.ErrNotFound) {
+		return "", err
+	}
+	cid, err := c.putCustomer(ctx, org, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var f stripe.Form
+	f.Set("customer", cid)
+	f.Set("success_url", successURL)
+	if p.CancelURL != "" {
+		f.Set("cancel_url", p.CancelURL)
+	} else {
+		f.Set("cancel_url", successURL)
+	}
+	if p.RequireBillingAddress {
+		f.Set("billing_address_collection", "required")
+	}
+
+	var trials []string
+	if len(p.Features) == 0 {
+		f.Set("mode", "setup")
+		f.Add("payment_method_types[]", "card")
+	} else {
+		fs, err := c.lookupFeatures(ctx, p.Features, "")
+		if err != nil {
+			orgError(err, org, -1)
+			return "", err
+		}
+		f.Set("mode", "subscription")
+		for i, fe := range fs {
+			f.Set("line_items", i, "price", fe.ProviderID)
+			if !fe.IsMetered() {
+				f.Set("line_items", i, "quantity", 1)
+			}
+		}
+		// Name the subscription so lookupSubscription finds it, though
+		// it has no schedule.
+		f.Set("subscription_data[metadata][tier.subscription]", scheduleNameTODO)
+
+		ph := Phase{Features: p.Features}
+		if p.TrialDays > 0 {
+			now, err := c.now(ctx, org)
+			if err != nil {
+				return "", err
+			}
+			ph.TrialEnd = now.AddDate(0, 0, p.TrialDays)
+		}
+		trials, err = c.startTrial(ctx, org, &ph)
+		if err != nil {
+			return "", err
+		}
+		if !ph.TrialEnd.IsZero() {
+			f.Set("subscription_data[trial_end]", ph.TrialEnd.Unix())
+		}
+	}
+
+	var s struct {
+		URL string
+	}
+	if err := c.Stripe.Do(ctx, "POST", "/v1/checkout/sessions", f, &s); err != nil {
+		return "", err
+	}
+	if err := c.recordTrials(ctx, org, trials); err != nil {
+		return "", err
+	}
+	return s.URL, nil
+}
@@ -0,0 +1,72 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/refs"
+)
+
+func TestCheckout(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:base@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Base:        1000,
+		TrialDays:   14,
+	}, {
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Mode:        "graduated",
+		Aggregate:   "sum",
+		Tiers:       []Tier{{Upto: 100}},
+	}}
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+
+	var ve *ValidationError
+	if _, err := tc.Checkout(ctx, "org:a", "", nil); !errors.As(err, &ve) {
+		t.Errorf("checkout without success URL: err = %v; want *ValidationError", err)
+	}
+	p := &CheckoutParams{Features: []refs.FeaturePlan{mpf("feature:nope@plan:pro@0")}}
+	if _, err := tc.Checkout(ctx, "org:a", "https://example.com/ok", p); !errors.Is(err, ErrFeatureNotFound) {
+		t.Errorf("checkout of unknown feature: err = %v; want %v", err, ErrFeatureNotFound)
+	}
+
+	// Checkout without features only collects payment details.
+	link, err := tc.Checkout(ctx, "org:a", "https://example.com/ok", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(link, "https://") {
+		t.Errorf("link = %q; want a URL", link)
+	}
+	if _, err := tc.WhoIs(ctx, "org:a"); err != nil {
+		t.Errorf("org not created by checkout: %v", err)
+	}
+
+	p = &CheckoutParams{Features: FeaturePlans(fs)}
+	if _, err := tc.Checkout(ctx, "org:a", "https://example.com/ok", p); err != nil {
+		t.Fatal(err)
+	}
+	trials, err := tc.lookupTrials(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, trials, []string{"pro"})
+
+	if err := tc.SubscribeTo(ctx, "org:b", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.Checkout(ctx, "org:b", "https://example.com/ok", p); !errors.As(err, &ve) {
+		t.Errorf("checkout of subscribed org: err = %v; want *ValidationError", err)
+	}
+}
@@ -7,11 +7,16 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/golang/groupcache/singleflight"
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
 	"golang.org/x/sync/errgroup"
+	"tier.run/metrics"
 	"tier.run/refs"
 	"tier.run/stripe"
+	"tier.run/trace"
 	"tier.run/values"
 )
 
@@ -24,6 +29,8 @@ var (
 	ErrInvalidEmail      = errors.New("invalid email")
 	ErrTooManyItems      = errors.New("too many subscription items")
 	ErrInvalidPrice      = errors.New("invalid price")
+	ErrStripeLimit       = errors.New("exceeds Stripe limit")
+	ErrPlanNotFound      = errors.New("plan not found")
 )
 
 const Inf = 1<<63 - 1
@@ -61,9 +68,23 @@ func FeaturePlans(fs []Feature) []refs.FeaturePlan {
 type Feature struct {
 	refs.FeaturePlan // the feature name prefixed with ("feature:")
 
-	ProviderID string // identifier set by the billing engine provider
-	PlanTitle  string // a human readable title for the plan
-	Title      string // a human readable title for the feature
+	ProviderID string   // identifier set by the billing engine provider
+	PlanTitle  string   // a human readable title for the plan
+	PlanTags   []string // tags labeling the plan; see refs.IsValidTag
+	Title      string   // a human readable title for the feature
+
+	// Unit, Description, and DisplayOrder describe the feature for
+	// display, such as on a pricing page, and are otherwise unused. Unit
+	// labels what is counted, such as "requests" or "seats", and features
+	// are meant to be shown in ascending DisplayOrder.
+	Unit         string
+	Description  string
+	DisplayOrder int
+
+	// TrialDays is the length in days of the free trial given to orgs the
+	// first time they subscribe to the feature's plan. Like PlanTitle, it
+	// is set for the plan as a whole. See ScheduleNow.
+	TrialDays int
 
 	// Interval specifies the billing interval for the feature.
 	//
@@ -82,9 +103,15 @@ type Feature struct {
 
 	// Mode specifies the billing mode for use with Tiers.
 	//
-	// Known modes are "graduated" and "volume".
+	// Known modes are "graduated", "volume", and "package". Features in
+	// "package" mode have a single tier, whose Price is charged for each
+	// PackageSize units used, rounded up to whole packages.
 	Mode string
 
+	// PackageSize is the number of units in each package of a feature in
+	// "package" mode, such as 1000 for a price per 1,000 API calls.
+	PackageSize int
+
 	// Aggregate specifies the usage aggregation method for use with Tiers.
 	//
 	// Known aggregates are "sum", "max", "last", and "perpetual".
@@ -99,6 +126,42 @@ type Feature struct {
 
 	// ReportID is the ID for reporting usage to the billing provider.
 	ReportID string
+
+	// Aliases are alternate names for the feature, accepted in its place
+	// when reporting and looking up usage.
+	Aliases []refs.Name
+
+	// Archived reports whether the feature, or its plan, was archived
+	// with Client.ArchiveFeature or Client.Archive. It is only set by
+	// PullAll.
+	Archived bool
+
+	// Variant reports whether the feature is a sibling price of the
+	// feature with the same FeaturePlan, billed at Interval instead of
+	// its plan's interval. Variants let a plan be offered, for example,
+	// both monthly and yearly; see Phase.Interval.
+	Variant bool
+
+	// OneTime reports whether the feature is billed once, for Base, when
+	// the phase it is subscribed to in begins, rather than each interval.
+	// One-time features, such as setup fees, have no Interval or Tiers.
+	OneTime bool
+
+	// Flag reports whether the feature is an entitlement that comes with
+	// its plan, such as SSO, rather than a priced feature. Flags have no
+	// price in Stripe and are not subscribed to; instead, LookupLimits
+	// reports them, with no limit, to orgs subscribed to their plan. They
+	// have no Interval, Currency, Base, or Tiers.
+	Flag bool
+
+	// Rollout, if not zero, is the percentage of orgs, from 1 to 100, the
+	// feature is enabled for, such as to roll it out gradually or to run
+	// an experiment. Orgs are chosen by hashing their names with the
+	// feature's, so each org is consistently in or out, and stays in as
+	// Rollout grows; see InRollout. LookupLimits reports a limit of zero
+	// to orgs the feature is not enabled for. Unlike the rest of a
+	// feature, Rollout may be changed after push, with SetRollout.
+	Rollout int
 }
 
 // TODO(bmizerany): remove FQN and replace with simply adding the version to
@@ -113,22 +176,46 @@ func (f *Feature) IsMetered() bool {
 	return f.Mode != ""
 }
 
+// ID returns the Stripe product ID and price lookup key for f. Variants
+// are suffixed with their interval, such as
+// "tier__feature-x-plan-pro-1__yearly".
 func (f *Feature) ID() string {
+	if f.Variant {
+		return stripe.MakeID(f.String(), strings.TrimPrefix(f.Interval, "@"))
+	}
 	return stripe.MakeID(f.String())
 }
 
+// FeatureByID returns the feature in fs with the provided Stripe product or
+// price ID, as made by Feature.ID, or the price ID in Feature.ProviderID.
+func FeatureByID(fs []Feature, id string) (Feature, bool) {
+	for _, f := range fs {
+		if f.ID() == id || f.ProviderID == id {
+			return f, true
+		}
+	}
+	return Feature{}, false
+}
+
+// Limit returns the most units of f that may be used in a billing period.
+// The limit of features in "package" mode is rounded up to whole packages,
+// since whole packages are billed for.
 func (f *Feature) Limit() int {
 	if len(f.Tiers) == 0 {
 		return Inf
 	}
-	return f.Tiers[len(f.Tiers)-1].Upto
+	limit := f.Tiers[len(f.Tiers)-1].Upto
+	if f.Mode == "package" && f.PackageSize > 0 && limit != Inf && limit%f.PackageSize != 0 {
+		limit += f.PackageSize - limit%f.PackageSize
+	}
+	return limit
 }
 
 // Tier holds the pricing information for a single tier.
 type Tier struct {
-	Upto  int     // the upper limit of the tier
-	Price float64 // the price of the tier
-	Base  int     // the base price of the tier
+	Upto  int            // the upper limit of the tier
+	Price values.Decimal // the price of each unit in the tier
+	Base  int            // the base price of the tier
 }
 
 type Client struct {
@@ -137,16 +224,154 @@ type Client struct {
 	Clock     string // a test clock name if any should be used
 	KeySource string // the source of the API key
 
-	cache memo
+	// Tracer, if non-nil, traces pushes, pulls, and the scheduling,
+	// lookups, and reports made for orgs. Set Stripe.Tracer to the same
+	// tracer to trace the requests made to Stripe within them.
+	Tracer trace.Tracer
+
+	// Metrics, if non-nil, records the durations of pushes, schedules,
+	// and usage reports, labeled by result, in the histograms
+	// tier_push_duration_seconds, tier_schedule_duration_seconds, and
+	// tier_report_duration_seconds, and counts the features pushed by
+	// status in tier_push_features_total. Set Stripe.Metrics to the same
+	// sink to record the latency of requests made to Stripe.
+	Metrics metrics.Sink
+
+	// Audit, if non-nil, records each schedule and customer change made
+	// by the Client, with the state of the org before and after it.
+	Audit AuditLog
+
+	// Notifier, if non-nil, is notified of lifecycle events of orgs, such
+	// as subscribing, and of those received from Stripe by HandleEvent.
+	Notifier Notifier
+
+	cache  memo
+	plans  planCache
+	limits limitWatch
 }
 
 // Live reports if APIKey is set to a "live" key.
 func (c *Client) Live() bool { return c.Stripe.Live() }
 
+// observe returns a func recording the time since observe was called in
+// the histogram name, labeled by the result in err, for use with defer.
+func (c *Client) observe(name string, err *error) func() {
+	start := time.Now()
+	return func() {
+		metrics.Since(c.Metrics, name, start, metrics.Result(*err))
+	}
+}
+
 // PushReportFunc is called for each feature pushed to Stripe. Implementations
 // must be safe to use accross goroutines.
 type PushReportFunc func(Feature, error)
 
+// Push stages, in the order each feature passes through them.
+const (
+	PushStageProduct = "product" // the product for the feature's plan
+	PushStagePrice   = "price"   // the feature's own product and price
+)
+
+// Push statuses.
+const (
+	PushCreated = "created" // the feature was created in Stripe
+	PushSkipped = "skipped" // the feature or its plan already exists
+	PushFailed  = "failed"  // the feature could not be pushed
+)
+
+// PushProgress reports that Push has finished with a feature.
+type PushProgress struct {
+	// Feature is the feature finished with. Its ProviderID is set if
+	// Status is PushCreated.
+	Feature Feature
+
+	// Stage is the stage the feature finished in, or the empty string if
+	// it failed the checks made before anything is pushed.
+	Stage  string
+	Status string // PushCreated, PushSkipped, or PushFailed
+	Err    error  // nil if Status is PushCreated
+
+	// Done is the number of features finished with so far, including
+	// Feature, out of Total.
+	Done, Total int
+}
+
+// A PushResult is the outcome of pushing a feature.
+type PushResult struct {
+	Feature Feature
+	Stage   string // as in PushProgress
+	Status  string // PushCreated, PushSkipped, or PushFailed
+	Err     error  // nil if Status is PushCreated
+}
+
+// A PushError is returned by Push if any feature was not created. It holds
+// the outcome of every feature pushed, in the order given to Push, so that
+// features skipped because they or their plans already exist can be told
+// apart from those that failed.
+//
+// errors.Is reports if the error of any feature matches the target, such
+// as ErrPlanExists.
+type PushError struct {
+	Results []PushResult
+}
+
+// Failed returns the results of the features that failed to push.
+func (e *PushError) Failed() []PushResult { return e.withStatus(PushFailed) }
+
+// Skipped returns the results of the features skipped because they or
+// their plans already exist.
+func (e *PushError) Skipped() []PushResult { return e.withStatus(PushSkipped) }
+
+func (e *PushError) withStatus(status string) []PushResult {
+	var rs []PushResult
+	for _, r := range e.Results {
+		if r.Status == status {
+			rs = append(rs, r)
+		}
+	}
+	return rs
+}
+
+func (e *PushError) Error() string {
+	rs, what := e.Failed(), "failed"
+	if len(rs) == 0 {
+		rs, what = e.Skipped(), "skipped"
+	}
+	if len(rs) == 0 {
+		return "push: no features failed"
+	}
+	return fmt.Sprintf("push: %d of %d features %s; %s: %v", len(rs), len(e.Results), what, rs[0].Feature.FeaturePlan, rs[0].Err)
+}
+
+func (e *PushError) Is(target error) bool {
+	for _, r := range e.Results {
+		if r.Err != nil && errors.Is(r.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// PushOptions holds options for PushWithOptions.
+type PushOptions struct {
+	// Concurrency is the most features to push at once. If zero, a
+	// limit suited to the mode of the Stripe key is used.
+	Concurrency int
+
+	// Progress, if not nil, is called once for each feature as Push
+	// finishes with it. Calls are made one at a time, in order of Done.
+	Progress func(PushProgress)
+
+	// Author is recorded as who made the push. See PushHistory.
+	Author string
+
+	// Rollback, if true, archives the features created by the push if
+	// any feature fails with PushFailed, so that no plan is left half
+	// pushed for orgs to subscribe to. Plans rolled back are archived as
+	// with Archive, and must be pushed again as new versions.
+	Rollback bool
+}
+
 // Push pushes each feature in fs to Stripe as a product and price combination.
 // A new price and product are created in Stripe if one does not already exist.
 //
@@ -159,73 +384,246 @@ type PushReportFunc func(Feature, error)
 // Each call to push is subject to rate limiting via the clients shared rate
 // limit.
 //
-// It returns the first error encountered if any.
+// If any feature is not created, it returns a *PushError with the outcome
+// of each feature. If fs fails the checks made before anything is pushed,
+// the first problem found is returned instead.
 func (c *Client) Push(ctx context.Context, fs []Feature, cb PushReportFunc) error {
-	plans := map[refs.Plan][]Feature{}
-	for _, f := range fs {
-		for _, t := range f.Tiers {
-			// Check the price has less than or equal to 12 decimal
-			// places as required by stripe.
-			//
-			// We do the pre-flight check here because we don't
-			// want to push a sentinel product if we can't push the
-			// prices; otherwise we'll have to delete the product
-			// manaully, which leads to crummy UX.
-			if countDecimals(t.Price) > 12 {
-				err := fmt.Errorf("%w: %.13f; tier prices must not exceed 12 decimal places", ErrInvalidPrice, t.Price)
-				cb(f, err)
-				return err
+	return c.PushWithOptions(ctx, fs, PushOptions{
+		Progress: func(p PushProgress) { cb(p.Feature, p.Err) },
+	})
+}
+
+// PushDryRun is like Push, but makes no changes in Stripe. Instead, cb is
+// called for each feature with the error Push would report for it, or nil
+// if Push would create it. Features with errors reported by Validate are
+// reported as failing with a *ValidationError.
+func (c *Client) PushDryRun(ctx context.Context, fs []Feature, cb PushReportFunc) error {
+	if err := preflight(fs, cb); err != nil {
+		return err
+	}
+	return c.pushDryRun(ctx, fs, cb)
+}
+
+// PushWithOptions is like Push, but reports structured progress, limits
+// how many features are pushed at once, and rolls back on failure, as set
+// in opts.
+//
+// If ctx is canceled, the features not yet pushed fail with its error, and
+// the push is neither rolled back nor recorded, so that the *PushError
+// returned reports exactly the features created before then.
+func (c *Client) PushWithOptions(ctx context.Context, fs []Feature, opts PushOptions) (err error) {
+	ctx, span := trace.Start(ctx, c.Tracer, "control.Push", trace.Int("tier.features", len(fs)))
+	defer trace.End(span, &err)
+	defer c.observe("tier_push_duration_seconds", &err)()
+
+	var doneMu sync.Mutex
+	done := 0
+	var created []Feature // for rollback
+	rec := PushRecord{Author: opts.Author}
+	results := make([]PushResult, len(fs))
+	// report records the result of fs[i], by position, since variants
+	// share the FeaturePlan of their base price.
+	report := func(i int, f Feature, stage string, err error) {
+		doneMu.Lock()
+		defer doneMu.Unlock()
+		done++
+		results[i] = PushResult{
+			Feature: f,
+			Stage:   stage,
+			Status:  pushStatus(err),
+			Err:     err,
+		}
+		metrics.Add(c.Metrics, "tier_push_features_total", 1, metrics.L("status", pushStatus(err)))
+		switch pushStatus(err) {
+		case PushCreated:
+			created = append(created, f)
+			rec.Created++
+			if p := f.Plan(); !p.IsZero() && !slices.Contains(rec.Plans, p) {
+				rec.Plans = append(rec.Plans, p)
 			}
+		case PushSkipped:
+			rec.Skipped++
+		case PushFailed:
+			rec.Failed++
+		}
+		if opts.Progress != nil {
+			opts.Progress(PushProgress{
+				Feature: f,
+				Stage:   stage,
+				Status:  pushStatus(err),
+				Err:     err,
+				Done:    done,
+				Total:   len(fs),
+			})
+		}
+	}
+
+	for i, f := range fs {
+		if err := preflightFeature(f); err != nil {
+			report(i, f, "", err)
+			return err
+		}
+	}
+
+	plans := map[refs.Plan][]int{} // indexes into fs
+	flags := map[refs.Plan][]Feature{}
+	for i, f := range fs {
+		plans[f.Plan()] = append(plans[f.Plan()], i)
+		if f.Flag {
+			flags[f.Plan()] = append(flags[f.Plan()], f)
 		}
-		plans[f.Plan()] = append(plans[f.Plan()], f)
 	}
 
 	var fg singleflight.Group
 	var mu sync.Mutex
 	pushed := map[refs.Plan]error{}
 	var g errgroup.Group
-	g.SetLimit(c.maxWorkers())
-	for p, fs := range plans {
-		p, fs := p, fs
-		for _, f := range fs {
-			f := f
+	g.SetLimit(values.Coalesce(opts.Concurrency, c.maxWorkers()))
+	for p, is := range plans {
+		p := p
+		for _, i := range is {
+			i, f := i, fs[i]
 			g.Go(func() error {
+				if err := ctx.Err(); err != nil {
+					report(i, f, "", err) // not started
+					return err
+				}
 				_, err := fg.Do(f.String(), func() (any, error) {
 					mu.Lock()
 					defer mu.Unlock()
 					if err, ok := pushed[p]; ok {
 						return nil, err
 					}
-					err := c.pushSentinelPlan(ctx, p)
+					err := c.pushSentinelPlan(ctx, p, flags[p])
 					pushed[p] = err
 					return nil, err
 				})
 				if err != nil {
-					cb(f, err) // error out all features in the plan
+					report(i, f, PushStageProduct, err) // error out all features in the plan
 					return err
 				}
+				if f.Flag {
+					// Flags are held by the plan's product.
+					f.ProviderID = stripe.MakeID(p.String())
+					report(i, f, PushStageProduct, nil)
+					return nil
+				}
 
 				pid, err := c.pushFeature(ctx, f)
 				if err != nil {
-					cb(f, err)
+					report(i, f, PushStagePrice, err)
 					return err
 				}
 				f.ProviderID = pid
-				cb(f, nil)
+				report(i, f, PushStagePrice, nil)
 				return nil
 			})
 		}
 	}
-	return g.Wait()
+	g.Wait() // errors are kept in results
+	if rec.Failed > 0 || rec.Skipped > 0 {
+		err = &PushError{Results: results}
+	}
+	if ctx.Err() != nil {
+		// Neither rolling back nor recording the push can be done
+		// once ctx is canceled. The results report the features
+		// created before then.
+		c.Logf("tier: push canceled after creating %d features", rec.Created)
+		return err
+	}
+	if opts.Rollback && rec.Failed > 0 {
+		c.Logf("tier: push failed; rolling back %d features", len(created))
+		if rerr := c.archiveFeatures(ctx, created); rerr != nil {
+			return fmt.Errorf("%w; rolling back: %v", err, rerr)
+		}
+		rec.RolledBack = true
+	}
+	if rec.Created > 0 || rec.Failed > 0 {
+		if rerr := c.recordPush(ctx, &rec); rerr != nil {
+			if err != nil {
+				return fmt.Errorf("%w; recording push: %v", err, rerr)
+			}
+			return fmt.Errorf("recording push: %w", rerr)
+		}
+	}
+	return err
+}
+
+// pushStatus returns the push status for a feature finished with err.
+func pushStatus(err error) string {
+	switch {
+	case err == nil:
+		return PushCreated
+	case errors.Is(err, ErrFeatureExists), errors.Is(err, ErrPlanExists):
+		return PushSkipped
+	default:
+		return PushFailed
+	}
+}
+
+// preflight checks fs for problems that Stripe would reject partway
+// through a push. It reports the first problem found to cb, and returns
+// it.
+func preflight(fs []Feature, cb PushReportFunc) error {
+	for _, f := range fs {
+		if err := preflightFeature(f); err != nil {
+			cb(f, err)
+			return err
+		}
+	}
+	return nil
 }
 
-func (c *Client) pushSentinelPlan(ctx context.Context, p refs.Plan) error {
+// preflightFeature returns the problem with f that Stripe would reject
+// partway through a push, if any.
+func preflightFeature(f Feature) error {
+	for _, t := range f.Tiers {
+		// Check the price has less than or equal to 12 decimal
+		// places as required by stripe.
+		//
+		// We do the pre-flight check here because we don't
+		// want to push a sentinel product if we can't push the
+		// prices; otherwise we'll have to delete the product
+		// manaully, which leads to crummy UX.
+		if t.Price.Places() > 12 {
+			return fmt.Errorf("%w: %s; tier prices must not exceed 12 decimal places", ErrInvalidPrice, t.Price)
+		}
+	}
+	if f.OneTime && len(f.Tiers) > 0 {
+		return fmt.Errorf("%w: one-time features must not have tiers", ErrInvalidPrice)
+	}
+	if f.Flag && (f.Plan().IsZero() || f.Base != 0 || len(f.Tiers) > 0 || f.OneTime || f.Variant) {
+		return fmt.Errorf("%w: flags must be in a plan and have no price", ErrInvalidPrice)
+	}
+	if f.Mode == "package" && (f.PackageSize < 1 || len(f.Tiers) != 1 || f.Tiers[0].Base != 0) {
+		return fmt.Errorf("%w: package features must have a package size and one tier without a base", ErrInvalidPrice)
+	}
+	// Names are not limited in length by refs, so check here that
+	// the values made from them fit within Stripe's limits before
+	// pushing anything, for the same reason as above.
+	return checkStripeLimits(f)
+}
+
+// pushSentinelPlan creates the product marking plan p as pushed, holding
+// the flags in p, if any.
+func (c *Client) pushSentinelPlan(ctx context.Context, p refs.Plan, flags []Feature) error {
 	if p.IsZero() {
 		return nil
 	}
 	var data stripe.Form
 	data.Set("id", stripe.MakeID(p.String()))
 	data.Set("name", p)
+	if len(flags) > 0 {
+		names := make([]refs.Name, len(flags))
+		for i, f := range flags {
+			names[i] = f.Name()
+		}
+		data.Set("metadata", "tier.flags", formatAliases(names))
+		data.Set("metadata", "tier.plan_title", flags[0].PlanTitle)
+		if rollouts := formatFlagRollouts(flags); rollouts != "" {
+			data.Set("metadata", "tier.rollouts", rollouts)
+		}
+	}
 
 	// prevent sentinel products from being visible or
 	// usable in the dashboard
@@ -245,67 +643,183 @@ func (c *Client) maxWorkers() int {
 	return 20 // a little under the max concurrent requests in test mode
 }
 
+// stripePriceParams are the parameters for creating a price in Stripe. See
+// https://stripe.com/docs/api/prices/create.
+type stripePriceParams struct {
+	LookupKey string         `json:"lookup_key"`
+	Currency  string         `json:"currency"` // secondary composite key in schedules
+	Metadata  map[string]any `json:"metadata"`
+
+	ProductData struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"product_data"`
+
+	Recurring struct {
+		Interval       string `json:"interval"`
+		IntervalCount  int    `json:"interval_count"`
+		UsageType      string `json:"usage_type"`
+		AggregateUsage string `json:"aggregate_usage,omitempty"`
+	} `json:"recurring,omitempty"` // empty for one-time prices
+
+	BillingScheme     string             `json:"billing_scheme"`
+	UnitAmount        *int               `json:"unit_amount,omitempty"`
+	UnitAmountDecimal string             `json:"unit_amount_decimal,omitempty"` // see formatDecimal
+	TiersMode         string             `json:"tiers_mode,omitempty"`
+	Tiers             []stripeTierParams `json:"tiers,omitempty"`
+
+	TransformQuantity struct {
+		DivideBy int    `json:"divide_by"`
+		Round    string `json:"round"`
+	} `json:"transform_quantity,omitempty"`
+
+	// TODO(bmizerany): Active
+	// TODO(bmizerany): TaxBehavior
+	// TODO(bmizerany): TransformQuantity
+	// TODO(bmizerany): CurrencyOptions
+}
+
+type stripeTierParams struct {
+	UpTo              any    `json:"up_to"`               // an int, or "inf"
+	UnitAmountDecimal string `json:"unit_amount_decimal"` // see formatDecimal
+	FlatAmount        int    `json:"flat_amount"`
+}
+
+// priceMetadata returns the metadata stored with the Stripe price for f.
+func priceMetadata(f Feature) map[string]any {
+	md := map[string]any{
+		"tier.plan_title": f.PlanTitle,
+		"tier.title":      f.Title,
+		"tier.feature":    f.FeaturePlan,
+	}
+	if len(f.Aliases) > 0 {
+		md["tier.aliases"] = formatAliases(f.Aliases)
+	}
+	if len(f.PlanTags) > 0 {
+		md["tier.plan_tags"] = strings.Join(f.PlanTags, ",")
+	}
+	if f.TrialDays > 0 {
+		md["tier.trial_days"] = f.TrialDays
+	}
+	if f.Unit != "" {
+		md["tier.unit"] = f.Unit
+	}
+	if f.Description != "" {
+		md["tier.description"] = f.Description
+	}
+	if f.DisplayOrder != 0 {
+		md["tier.display_order"] = f.DisplayOrder
+	}
+	if f.Rollout != 0 {
+		md["tier.rollout"] = f.Rollout
+	}
+	return md
+}
+
+// checkStripeLimits reports an error wrapping ErrStripeLimit if the ID or
+// metadata values made for f are too long for Stripe.
+func checkStripeLimits(f Feature) error {
+	if id := f.ID(); len(id) > stripe.MaxLookupKeyLen {
+		return fmt.Errorf("%w: feature ID %q is %d characters; must not exceed %d", ErrStripeLimit, id, len(id), stripe.MaxLookupKeyLen)
+	}
+	md := priceMetadata(f)
+	keys := maps.Keys(md)
+	slices.Sort(keys)
+	for _, k := range keys {
+		if s := fmt.Sprint(md[k]); len(s) > stripe.MaxMetadataValueLen {
+			return fmt.Errorf("%w: %s is %d characters; must not exceed %d", ErrStripeLimit, k, len(s), stripe.MaxMetadataValueLen)
+		}
+	}
+	return nil
+}
+
 func (c *Client) pushFeature(ctx context.Context, f Feature) (providerID string, err error) {
-	// https://stripe.com/docs/api/prices/create
-	var data stripe.Form
-	data.Set("metadata", "tier.plan_title", f.PlanTitle)
-	data.Set("metadata", "tier.title", f.Title)
-	data.Set("metadata", "tier.feature", f.FeaturePlan)
+	ctx, span := trace.Start(ctx, c.Tracer, "control.pushFeature", trace.String("tier.feature", f.FeaturePlan.String()))
+	defer trace.End(span, &err)
+
+	var p stripePriceParams
+	p.Metadata = priceMetadata(f)
 
 	c.Logf("tier: pushing feature %q", f.ID())
-	data.Set("lookup_key", f.ID())
-	data.Set("product_data", "id", f.ID())
+	p.LookupKey = f.ID()
+	p.ProductData.ID = f.ID()
 
 	// This will appear as the line item description in the Stripe dashboard
 	// and customer invoices.
-	data.Set("product_data", "name", fmt.Sprintf("%s - %s",
+	p.ProductData.Name = fmt.Sprintf("%s - %s",
 		values.Coalesce(f.PlanTitle, f.String()),
 		values.Coalesce(f.Title, f.String()),
-	))
+	)
 
-	// secondary composite key in schedules:
-	data.Set("currency", f.Currency)
+	p.Currency = f.Currency
+
+	if f.OneTime {
+		// One-time prices are per unit, without recurring fields.
+		p.BillingScheme = "per_unit"
+		p.UnitAmount = &f.Base
+		return c.createPrice(ctx, p)
+	}
 
 	interval := intervalToStripe[f.Interval]
 	if interval == "" {
 		return "", fmt.Errorf("unknown interval: %q", f.Interval)
 	}
-	data.Set("recurring", "interval", interval)
-	data.Set("recurring", "interval_count", 1) // TODO: support user-defined interval count
+	p.Recurring.Interval = interval
+	p.Recurring.IntervalCount = 1 // TODO: support user-defined interval count
 
-	if len(f.Tiers) == 0 {
-		data.Set("recurring", "usage_type", "licensed")
-		data.Set("billing_scheme", "per_unit")
-		data.Set("unit_amount", f.Base)
-	} else {
-		data.Set("recurring", "usage_type", "metered")
-		data.Set("billing_scheme", "tiered")
-		data.Set("tiers_mode", f.Mode)
+	switch {
+	case len(f.Tiers) == 0:
+		p.Recurring.UsageType = "licensed"
+		p.BillingScheme = "per_unit"
+		p.UnitAmount = &f.Base
+	case f.Mode == "package":
+		// Stripe prices packages per unit, dividing usage by the
+		// package size and rounding up before multiplying.
+		aggregate := aggregateToStripe[f.Aggregate]
+		if aggregate == "" {
+			return "", fmt.Errorf("unknown aggregate: %q", f.Aggregate)
+		}
+		p.Recurring.UsageType = "metered"
+		p.Recurring.AggregateUsage = aggregate
+		p.BillingScheme = "per_unit"
+		p.UnitAmountDecimal = f.Tiers[0].Price.String()
+		p.TransformQuantity.DivideBy = f.PackageSize
+		p.TransformQuantity.Round = "up"
+		p.Metadata["tier.limit"] = f.Tiers[0].Upto
+	default:
+		p.Recurring.UsageType = "metered"
+		p.BillingScheme = "tiered"
+		p.TiersMode = f.Mode
 		aggregate := aggregateToStripe[f.Aggregate]
 		if aggregate == "" {
 			return "", fmt.Errorf("unknown aggregate: %q", f.Aggregate)
 		}
-		data.Set("recurring", "aggregate_usage", aggregate)
+		p.Recurring.AggregateUsage = aggregate
 		var limit int
 		for i, t := range f.Tiers {
+			tp := stripeTierParams{
+				UpTo:              t.Upto,
+				UnitAmountDecimal: t.Price.String(),
+				FlatAmount:        t.Base,
+			}
 			if i == len(f.Tiers)-1 {
-				data.Set("tiers", i, "up_to", "inf")
-			} else {
-				data.Set("tiers", i, "up_to", t.Upto)
+				tp.UpTo = "inf"
 			}
 			if limit < t.Upto {
 				limit = t.Upto
 			}
-			data.Set("tiers", i, "unit_amount_decimal", t.Price)
-			data.Set("tiers", i, "flat_amount", t.Base)
+			p.Tiers = append(p.Tiers, tp)
 		}
-		data.Set("metadata", "tier.limit", limit)
+		p.Metadata["tier.limit"] = limit
 	}
+	return c.createPrice(ctx, p)
+}
 
-	// TODO(bmizerany): data.Set("active", ?)
-	// TODO(bmizerany): data.Set("tax_behavior", "?")
-	// TODO(bmizerany): data.Set("transform_quantity", "?")
-	// TODO(bmizerany): data.Set("currency_options", "?")
+func (c *Client) createPrice(ctx context.Context, p stripePriceParams) (providerID string, err error) {
+	var data stripe.Form
+	if err := data.EncodeStruct(p); err != nil {
+		return "", err
+	}
 
 	var v struct {
 		ID string
@@ -319,6 +833,8 @@ func (c *Client) pushFeature(ctx context.Context, f Feature) (providerID string,
 
 type stripePrice struct {
 	stripe.ID
+	Active    bool
+	Type      string // "recurring" or "one_time"
 	LookupKey string `json:"lookup_key"`
 	Metadata  struct {
 		Plan      string           `json:"tier.plan"`
@@ -326,6 +842,14 @@ type stripePrice struct {
 		Feature   refs.FeaturePlan `json:"tier.feature"`
 		Limit     string           `json:"tier.limit"`
 		Title     string           `json:"tier.title"`
+		Aliases   string           `json:"tier.aliases"`
+		PlanTags  string           `json:"tier.plan_tags"`
+		TrialDays int              `json:"tier.trial_days,string"`
+
+		Unit         string `json:"tier.unit"`
+		Description  string `json:"tier.description"`
+		DisplayOrder int    `json:"tier.display_order,string"`
+		Rollout      int    `json:"tier.rollout,string"`
 	}
 	Recurring struct {
 		Interval       string
@@ -333,14 +857,18 @@ type stripePrice struct {
 		UsageType      string `json:"usage_type"`
 		AggregateUsage string `json:"aggregate_usage"`
 	}
-	BillingScheme string `json:"billing_scheme"`
-	TiersMode     string `json:"tiers_mode"`
-	UnitAmount    int    `json:"unit_amount"`
-	Tiers         []struct {
-		Upto         int     `json:"up_to"`
-		Price        float64 `json:"unit_amount"`
-		PriceDecimal float64 `json:"unit_amount_decimal,string"`
-		Base         int     `json:"flat_amount"`
+	BillingScheme     string         `json:"billing_scheme"`
+	TiersMode         string         `json:"tiers_mode"`
+	UnitAmount        int            `json:"unit_amount"`
+	UnitAmountDecimal values.Decimal `json:"unit_amount_decimal"`
+	TransformQuantity struct {
+		DivideBy int `json:"divide_by"`
+	} `json:"transform_quantity"`
+	Tiers []struct {
+		Upto         int            `json:"up_to"`
+		Price        int            `json:"unit_amount"`
+		PriceDecimal values.Decimal `json:"unit_amount_decimal"`
+		Base         int            `json:"flat_amount"`
 	}
 	Currency string
 }
@@ -351,16 +879,36 @@ func stripePriceToFeature(p stripePrice) Feature {
 		PlanTitle:   p.Metadata.PlanTitle,
 		FeaturePlan: p.Metadata.Feature,
 		Title:       p.Metadata.Title,
+		Unit:        p.Metadata.Unit,
+		Description: p.Metadata.Description,
 		Currency:    p.Currency,
 		Interval:    intervalFromStripe[p.Recurring.Interval],
 		Mode:        p.TiersMode,
 		Aggregate:   aggregateFromStripe[p.Recurring.AggregateUsage],
 		Base:        p.UnitAmount,
+		Aliases:     parseAliases(p.Metadata.Aliases),
+		PlanTags:    parseTags(p.Metadata.PlanTags),
+		TrialDays:   p.Metadata.TrialDays,
+		Archived:    !p.Active,
+		OneTime:     p.Type == "one_time",
+
+		DisplayOrder: p.Metadata.DisplayOrder,
+		Rollout:      p.Metadata.Rollout,
+	}
+	f.Variant = p.LookupKey != "" && p.LookupKey != stripe.MakeID(f.String())
+	if n := p.TransformQuantity.DivideBy; n > 0 {
+		f.Mode = "package"
+		f.PackageSize = n
+		f.Base = 0
+		f.Tiers = []Tier{{
+			Upto:  parseLimit(p.Metadata.Limit),
+			Price: values.Coalesce(p.UnitAmountDecimal, values.DecimalOf(p.UnitAmount)),
+		}}
 	}
 	for i, t := range p.Tiers {
 		f.Tiers = append(f.Tiers, Tier{
 			Upto:  t.Upto,
-			Price: values.Coalesce(t.PriceDecimal, t.Price),
+			Price: values.Coalesce(t.PriceDecimal, values.DecimalOf(t.Price)),
 			Base:  t.Base,
 		})
 		if i == len(p.Tiers)-1 {
@@ -370,28 +918,297 @@ func stripePriceToFeature(p stripePrice) Feature {
 	return f
 }
 
-// Pull retrieves the feature from Stripe.
+// formatAliases formats aliases for storing in price metadata as a comma
+// separated list.
+func formatAliases(aliases []refs.Name) string {
+	ss := make([]string, len(aliases))
+	for i, n := range aliases {
+		ss[i] = n.String()
+	}
+	return strings.Join(ss, ",")
+}
+
+// parseAliases parses aliases formatted by formatAliases, skipping any that
+// are invalid.
+func parseAliases(s string) []refs.Name {
+	var ns []refs.Name
+	for _, a := range strings.Split(s, ",") {
+		if n, err := refs.ParseName(a); err == nil {
+			ns = append(ns, n)
+		}
+	}
+	return ns
+}
+
+// parseTags parses a comma separated list of tags, skipping any that are
+// invalid.
+func parseTags(s string) []string {
+	var tags []string
+	for _, t := range strings.Split(s, ",") {
+		if refs.IsValidTag(t) {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+// HasPlanTag reports whether f is in a plan tagged with tag.
+func (f *Feature) HasPlanTag(tag string) bool {
+	return slices.Contains(f.PlanTags, tag)
+}
+
+// Aliases returns the aliases declared by fs.
+func Aliases(fs []Feature) refs.Aliases {
+	m := refs.Aliases{}
+	for _, f := range fs {
+		for _, a := range f.Aliases {
+			m[a] = f.Name()
+		}
+	}
+	return m
+}
+
+// Pull retrieves the feature from Stripe. Features in archived plans are
+// omitted; use PullAll to include them.
 func (c *Client) Pull(ctx context.Context, limit int) ([]Feature, error) {
+	return c.PullWithOptions(ctx, PullOptions{})
+}
+
+// PullAll is like Pull, but includes features in archived plans, with
+// Archived set.
+func (c *Client) PullAll(ctx context.Context, limit int) ([]Feature, error) {
+	return c.PullWithOptions(ctx, PullOptions{Archived: true})
+}
+
+// PullOptions holds options for PullWithOptions. The zero value pulls the
+// same features as Pull.
+type PullOptions struct {
+	Plan          refs.Plan // if not zero, only features in Plan are pulled
+	FeaturePrefix string    // if set, only features with names starting with it are pulled
+	Tag           string    // if set, only features in plans tagged with it are pulled
+
+	// Archived reports whether to include features in archived plans, as
+	// PullAll does.
+	Archived bool
+}
+
+// PullWithOptions is like Pull, but only returns the features matching
+// opts. Stripe cannot filter prices by metadata, so every price is still
+// listed, but prices not matching opts are skipped as soon as their
+// metadata is read.
+//
+// FeaturePrefix is matched against feature names, such as "feature:api:"
+// for "feature:api:calls@plan:pro@1".
+func (c *Client) PullWithOptions(ctx context.Context, opts PullOptions) (_ []Feature, err error) {
+	ctx, span := trace.Start(ctx, c.Tracer, "control.Pull")
+	defer trace.End(span, &err)
+
 	// https://stripe.com/docs/api/prices/list
 	var f stripe.Form
-	f.Add("expand[]", "data.product")
-	f.Add("expand[]", "data.tiers")
-	prices, err := stripe.Slurp[stripePrice](ctx, c.Stripe, "GET", "/v1/prices", f)
+	f.Expand("data.tiers")
+	if !opts.Archived {
+		f.Set("active", true)
+	}
+	var fs []Feature
+	err = stripe.Iter(ctx, c.Stripe, "GET", "/v1/prices", f, func(p stripePrice) bool {
+		fp := p.Metadata.Feature
+		switch {
+		case fp.IsZero():
+		case !opts.Plan.IsZero() && !fp.InPlan(opts.Plan):
+		case !strings.HasPrefix(fp.Name().String(), opts.FeaturePrefix):
+		default:
+			f := stripePriceToFeature(p)
+			if opts.Tag == "" || f.HasPlanTag(opts.Tag) {
+				fs = append(fs, f)
+			}
+		}
+		return true
+	})
 	if err != nil {
 		return nil, err
 	}
+	flags, err := c.pullFlags(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Tag != "" {
+		// Tags are kept with the prices of a plan, so flags, which
+		// have none, are pulled if their plan's prices were.
+		tagged := map[refs.Plan]bool{}
+		for _, f := range fs {
+			tagged[f.Plan()] = true
+		}
+		n := 0
+		for _, f := range flags {
+			if tagged[f.Plan()] {
+				flags[n] = f
+				n++
+			}
+		}
+		flags = flags[:n]
+	}
+	return append(fs, flags...), nil
+}
+
+// pullFlags returns the flags matching opts, as held by the products of
+// their plans.
+func (c *Client) pullFlags(ctx context.Context, opts PullOptions) ([]Feature, error) {
+	type T struct {
+		stripe.ID
+		Name     string
+		Metadata struct {
+			Flags     string `json:"tier.flags"`
+			Rollouts  string `json:"tier.rollouts"`
+			PlanTitle string `json:"tier.plan_title"`
+			Archived  bool   `json:"tier.archived,string"`
+		}
+	}
+
 	var fs []Feature
-	for _, p := range prices {
-		if p.Metadata.Feature.IsZero() {
-			continue
+	add := func(p T) {
+		if p.Metadata.Flags == "" || (p.Metadata.Archived && !opts.Archived) {
+			return
+		}
+		plan, err := refs.ParsePlan(p.Name)
+		if err != nil {
+			return
+		}
+		rollouts := parseFlagRollouts(p.Metadata.Rollouts)
+		for _, n := range parseAliases(p.Metadata.Flags) {
+			if !strings.HasPrefix(n.String(), opts.FeaturePrefix) {
+				continue
+			}
+			fs = append(fs, Feature{
+				FeaturePlan: n.WithPlan(plan),
+				ProviderID:  p.ProviderID(),
+				PlanTitle:   p.Metadata.PlanTitle,
+				Archived:    p.Metadata.Archived,
+				Flag:        true,
+				Rollout:     rollouts[n],
+			})
+		}
+	}
+
+	if !opts.Plan.IsZero() {
+		var p T
+		err := c.Stripe.Do(ctx, "GET", "/v1/products/"+stripe.MakeID(opts.Plan.String()), stripe.Form{}, &p)
+		if isMissing(err) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
 		}
-		fs = append(fs, stripePriceToFeature(p))
+		add(p)
+		return fs, nil
+	}
+
+	// Plan products are inactive; see pushSentinelPlan.
+	var f stripe.Form
+	f.Set("active", false)
+	err := stripe.Iter(ctx, c.Stripe, "GET", "/v1/products", f, func(p T) bool {
+		add(p)
+		return true
+	})
+	if err != nil {
+		return nil, err
 	}
 	return fs, nil
 }
 
+// Archive retires plan p by deactivating the Stripe prices and products of
+// its features, which hides them from Pull and from the Stripe dashboard's
+// default views. Orgs already subscribed to the plan remain subscribed,
+// and features in the plan may still be looked up and reported.
+//
+// Archived plans cannot be pushed again, since features in Stripe are
+// immutable; push a new version of the plan instead.
+//
+// It returns ErrPlanNotFound if no features in p have been pushed, and
+// stripe.ErrLiveModeGuard if the client may not archive in live mode.
+func (c *Client) Archive(ctx context.Context, p refs.Plan) error {
+	if err := c.Stripe.GuardLive(); err != nil {
+		return err
+	}
+	fs, err := c.PullWithOptions(ctx, PullOptions{Plan: p, Archived: true})
+	if err != nil {
+		return err
+	}
+	if len(fs) == 0 {
+		return ErrPlanNotFound
+	}
+	if err := c.archiveFeatures(ctx, fs); err != nil {
+		return err
+	}
+	if slices.IndexFunc(fs, func(f Feature) bool { return f.Flag }) >= 0 {
+		var data stripe.Form
+		data.Set("metadata", "tier.archived", true)
+		return c.Stripe.Do(ctx, "POST", "/v1/products/"+stripe.MakeID(p.String()), data, nil)
+	}
+	return nil
+}
+
+// ArchiveFeature is like Archive, but retires only the feature fp, and its
+// variants, leaving the rest of its plan as it is. Flags are held by the
+// product of their plan, so they may only be archived with it.
+//
+// It returns ErrFeatureNotFound if fp has not been pushed, and
+// stripe.ErrLiveModeGuard if the client may not archive in live mode.
+func (c *Client) ArchiveFeature(ctx context.Context, fp refs.FeaturePlan) error {
+	if err := c.Stripe.GuardLive(); err != nil {
+		return err
+	}
+	pulled, err := c.PullWithOptions(ctx, PullOptions{Plan: fp.Plan(), Archived: true})
+	if err != nil {
+		return err
+	}
+	var fs []Feature // fp and its variants
+	for _, f := range pulled {
+		if f.FeaturePlan == fp {
+			fs = append(fs, f)
+		}
+	}
+	if len(fs) == 0 {
+		return ErrFeatureNotFound
+	}
+	if fs[0].Flag {
+		return &ValidationError{Message: fmt.Sprintf("flag %s may only be archived with its plan", fp)}
+	}
+	return c.archiveFeatures(ctx, fs)
+}
+
+// archiveFeatures deactivates the Stripe prices and products of the
+// features in fs not already archived.
+func (c *Client) archiveFeatures(ctx context.Context, fs []Feature) error {
+	var g errgroup.Group
+	g.SetLimit(c.maxWorkers())
+	for _, f := range fs {
+		if f.Archived || f.Flag {
+			continue
+		}
+		f := f
+		g.Go(func() error {
+			c.Logf("tier: archiving feature %q", f.ID())
+			var data stripe.Form
+			data.Set("active", false)
+			if err := c.Stripe.Do(ctx, "POST", "/v1/prices/"+f.ProviderID, data, nil); err != nil {
+				return err
+			}
+			err := c.Stripe.Do(ctx, "POST", "/v1/products/"+f.ID(), data, nil)
+			if isMissing(err) {
+				// Features adopted by Import keep their own
+				// products, which are left as they are.
+				return nil
+			}
+			return err
+		})
+	}
+	return g.Wait()
+}
+
 // Expand parses each ref in refs and adds it to the result. If the ref is a
 // plan ref, Expand will append all features in fs for that plan to the result.
+// Refs to the latest version of a plan, such as "plan:pro@latest", are
+// resolved to the highest version of the plan in fs.
 // returns an error if any ref is invalid or not availabe in the
 //
 // The parameter fs is assumed to have no two features with the same FeaturePlan.
@@ -407,9 +1224,13 @@ func Expand(fs []Feature, names ...string) ([]refs.FeaturePlan, error) {
 			if err != nil {
 				return nil, err
 			}
+			p, err = resolvePlan(fs, p)
+			if err != nil {
+				return nil, err
+			}
 			n := len(out)
 			for _, f := range fs {
-				if f.InPlan(p) {
+				if f.InPlan(p) && !f.Variant && !f.Flag {
 					out = append(out, f.FeaturePlan)
 				}
 			}
@@ -417,6 +1238,10 @@ func Expand(fs []Feature, names ...string) ([]refs.FeaturePlan, error) {
 				return nil, fmt.Errorf("no features found for plan %q", p)
 			}
 		} else {
+			fp, err = resolveFeaturePlan(fs, fp)
+			if err != nil {
+				return nil, err
+			}
 			out = append(out, fp)
 		}
 	}
@@ -424,37 +1249,171 @@ func Expand(fs []Feature, names ...string) ([]refs.FeaturePlan, error) {
 	return out, nil
 }
 
+// resolvePlan returns p, or if p refers to the latest version of a plan, the
+// highest version of the plan with features in fs, as ordered by
+// refs.CompareVersions.
+func resolvePlan(fs []Feature, p refs.Plan) (refs.Plan, error) {
+	if !p.IsLatest() {
+		return p, nil
+	}
+	var latest refs.Plan
+	for _, f := range fs {
+		fp := f.Plan()
+		if fp.Name() != p.Name() || fp.IsLatest() {
+			continue
+		}
+		if latest.IsZero() || refs.CompareVersions(fp.Version(), latest.Version()) > 0 {
+			latest = fp
+		}
+	}
+	if latest.IsZero() {
+		return refs.Plan{}, fmt.Errorf("no features found for plan %q", p)
+	}
+	return latest, nil
+}
+
+// resolveFeaturePlan returns fp with its plan resolved using resolvePlan.
+func resolveFeaturePlan(fs []Feature, fp refs.FeaturePlan) (refs.FeaturePlan, error) {
+	if !fp.Plan().IsLatest() {
+		return fp, nil
+	}
+	p, err := resolvePlan(fs, fp.Plan())
+	if err != nil {
+		return refs.FeaturePlan{}, err
+	}
+	return fp.Name().WithPlan(p), nil
+}
+
 type Org struct {
 	ProviderID string
 	ID         string
 	Email      string
+	Created    time.Time
+	Metadata   map[string]string // without keys reserved by tier
+}
+
+// ListOrgsOptions holds options for ListOrgs.
+type ListOrgsOptions struct {
+	// Limit is the most orgs to list, from 1 to 100. If zero, 100 orgs
+	// are listed.
+	Limit int
+
+	// StartingAfter, if set, is the Next cursor of the page listed
+	// before, to list the orgs after it.
+	StartingAfter string
+
+	// CreatedAfter and CreatedBefore, if not zero, list only the orgs
+	// created at or after CreatedAfter, and before CreatedBefore.
+	CreatedAfter  time.Time
+	CreatedBefore time.Time
+
+	// Metadata, if not empty, lists only the orgs with each key in
+	// Metadata set to its value.
+	Metadata map[string]string
+}
+
+// An OrgsPage is a page of orgs listed by ListOrgs.
+type OrgsPage struct {
+	Orgs []Org // newest first
+
+	// Next is the cursor to list the next page with, as
+	// ListOrgsOptions.StartingAfter, or empty if all orgs have been
+	// listed. A full page may be followed by an empty one.
+	Next string
 }
 
-// ListOrgs returns a list of all known customers in Stripe.
-func (c *Client) ListOrgs(ctx context.Context) ([]Org, error) {
+// ListOrgs returns a page of the known customers in Stripe matching opts,
+// newest first. Only as many customers as needed to fill the page are
+// read, so that accounts with many customers can be listed a page at a
+// time. Stripe cannot filter customers by metadata, so customers not
+// matching opts.Metadata are read and skipped.
+func (c *Client) ListOrgs(ctx context.Context, opts ListOrgsOptions) (OrgsPage, error) {
+	limit := values.Coalesce(opts.Limit, 100)
+	if limit < 1 || limit > 100 {
+		return OrgsPage{}, &ValidationError{Message: "limit must be from 1 to 100"}
+	}
+
 	// https://stripe.com/docs/api/customers/list
 	var f stripe.Form
-	f.Add("limit", 100)
-	type T struct {
-		stripe.ID
-		Email    string
-		Metadata struct {
-			Org string `json:"tier.org"`
+	if opts.StartingAfter != "" {
+		f.Set("starting_after", opts.StartingAfter)
+	}
+	if !opts.CreatedAfter.IsZero() {
+		f.Set("created[gte]", opts.CreatedAfter.Unix())
+	}
+	if !opts.CreatedBefore.IsZero() {
+		f.Set("created[lt]", opts.CreatedBefore.Unix())
+	}
+	var p OrgsPage
+	l := stripe.List[stripeCustomer](ctx, c.Stripe, "GET", "/v1/customers", f)
+	for len(p.Orgs) < limit && l.Next() {
+		cus := l.Value()
+		if !hasMetadata(cus.Metadata, opts.Metadata) {
+			continue
 		}
+		p.Orgs = append(p.Orgs, cus.org())
 	}
-	customers, err := stripe.Slurp[T](ctx, c.Stripe, "GET", "/v1/customers", f)
-	if err != nil {
-		return nil, err
+	if err := l.Err(); err != nil {
+		return OrgsPage{}, err
 	}
-	var cs []Org
-	for _, c := range customers {
-		cs = append(cs, Org{
-			ProviderID: c.ProviderID(),
-			ID:         c.Metadata.Org,
-			Email:      c.Email,
-		})
+	if len(p.Orgs) == limit {
+		p.Next = p.Orgs[limit-1].ProviderID
 	}
-	return cs, nil
+	return p, nil
+}
+
+// stripeCustomer is a Stripe customer, as listed for orgs.
+type stripeCustomer struct {
+	stripe.ID
+	Email    string
+	Created  int64
+	Metadata map[string]string
+}
+
+func (cus stripeCustomer) org() Org {
+	o := Org{
+		ProviderID: cus.ProviderID(),
+		ID:         cus.Metadata["tier.org"],
+		Email:      cus.Email,
+		Created:    time.Unix(cus.Created, 0),
+	}
+	for k, v := range cus.Metadata {
+		if strings.HasPrefix(k, "tier.") {
+			continue
+		}
+		if o.Metadata == nil {
+			o.Metadata = map[string]string{}
+		}
+		o.Metadata[k] = v
+	}
+	return o
+}
+
+// hasMetadata reports whether md has each key in want set to its value.
+func hasMetadata(md, want map[string]string) bool {
+	for k, v := range want {
+		if got, ok := md[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// EachOrg calls fn for each known customer in Stripe, one page of
+// customers at a time, without holding more than a page in memory. If fn
+// returns an error, EachOrg stops and returns it.
+func (c *Client) EachOrg(ctx context.Context, fn func(Org) error) error {
+	// https://stripe.com/docs/api/customers/list
+	var f stripe.Form
+	f.Add("limit", 100)
+	return stripe.Pages(ctx, c.Stripe, "GET", "/v1/customers", f, func(page []stripeCustomer) error {
+		for _, cus := range page {
+			if err := fn(cus.org()); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 }
 
 func parseLimit(s string) int {
@@ -470,8 +1429,7 @@ func isExists(err error) bool {
 	return errors.As(err, &e) && e.Code == "resource_already_exists"
 }
 
-func countDecimals(f float64) int {
-	s := strconv.FormatFloat(f, 'f', -1, 64)
-	_, dec, _ := strings.Cut(s, ".")
-	return len(dec)
+func isMissing(err error) bool {
+	var e *stripe.Error
+	return errors.As(err, &e) && e.Code == "resource_missing"
 }
@@ -3,21 +3,39 @@ package control
 import (
 	"context"
 	"errors"
+	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
 	"golang.org/x/exp/slices"
 	"kr.dev/diff"
+	"tier.run/metrics"
 	"tier.run/refs"
 	"tier.run/stripe"
+	"tier.run/stripe/stripefake"
 	"tier.run/stripe/stroke"
+	"tier.run/values"
 )
 
+func haveStripe() bool {
+	return os.Getenv("STRIPE_API_KEY") != ""
+}
+
+// newTestClient returns a Client using an isolated account in Stripe, or if
+// STRIPE_API_KEY is not set, an in-memory fake of Stripe.
 func newTestClient(t *testing.T) *Client {
 	t.Helper()
 	t.Parallel()
 
+	if !haveStripe() {
+		return &Client{
+			Stripe: stripefake.Client(t),
+			Logf:   t.Logf,
+		}
+	}
+
 	sc := stroke.Client(t)
 	if sc.Live() {
 		t.Fatal("expected test key")
@@ -42,6 +60,17 @@ func TestRoundTrip(t *testing.T) {
 	ctx := context.Background()
 
 	want := []Feature{
+		{
+			FeaturePlan: refs.MustParseFeaturePlan("feature:events@plan:free@4"),
+			Interval:    "@monthly",
+			Currency:    "usd",
+			Mode:        "graduated",
+			Aggregate:   "sum",
+			Tiers: []Tier{
+				{Upto: 1000000, Price: values.MustParseDecimal("0.07")}, // $0.0007
+				{Upto: 2000000, Price: values.MustParseDecimal("0.000000000001")},
+			},
+		},
 		{
 			FeaturePlan: refs.MustParseFeaturePlan("feature:test@plan:free@3"),
 			Interval:    "@daily",
@@ -52,16 +81,20 @@ func TestRoundTrip(t *testing.T) {
 		{
 			FeaturePlan: refs.MustParseFeaturePlan("feature:test@plan:free@theVersion"),
 			PlanTitle:   "PlanTitle",
+			PlanTags:    []string{"beta", "eu"},
 			Interval:    "@yearly",
 			Currency:    "usd",
 			Title:       "FeatureTitle",
+			Unit:        "requests",
+			Description: "Requests made to the API.",
 			Mode:        "volume",
 			Aggregate:   "perpetual",
 			Tiers: []Tier{
-				{Upto: 1, Price: 100, Base: 1},
-				{Upto: 2, Price: 200, Base: 2},
-				{Upto: 3, Price: 300, Base: 3},
+				{Upto: 1, Price: values.MustParseDecimal("100"), Base: 1},
+				{Upto: 2, Price: values.MustParseDecimal("200"), Base: 2},
+				{Upto: 3, Price: values.MustParseDecimal("300"), Base: 3},
 			},
+			DisplayOrder: 2,
 		},
 	}
 
@@ -96,6 +129,56 @@ func TestRoundTrip(t *testing.T) {
 	})
 }
 
+func TestPullWithOptions(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	f := func(s string) Feature {
+		return Feature{FeaturePlan: mpf(s), Interval: "@monthly", Currency: "usd"}
+	}
+	pro := f("feature:api:calls@plan:pro@0")
+	pro.PlanTags = []string{"beta"}
+	fs := []Feature{
+		f("feature:api:calls@plan:free@0"),
+		f("feature:seats@plan:free@0"),
+		pro,
+		{FeaturePlan: mpf("feature:sso@plan:pro@0"), Flag: true},
+		{FeaturePlan: mpf("feature:sso@plan:free@0"), Flag: true},
+		f("feature:api:calls@plan:old@0"),
+	}
+	if err := tc.Push(ctx, fs, pushLogger(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Archive(ctx, refs.MustParsePlan("plan:old@0")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		opts PullOptions
+		want string
+	}{
+		{PullOptions{}, "feature:api:calls@plan:free@0 feature:api:calls@plan:pro@0 feature:seats@plan:free@0 feature:sso@plan:free@0 feature:sso@plan:pro@0"},
+		{PullOptions{Plan: refs.MustParsePlan("plan:free@0")}, "feature:api:calls@plan:free@0 feature:seats@plan:free@0 feature:sso@plan:free@0"},
+		{PullOptions{FeaturePrefix: "feature:api:"}, "feature:api:calls@plan:free@0 feature:api:calls@plan:pro@0"},
+		{PullOptions{FeaturePrefix: "feature:api:", Archived: true}, "feature:api:calls@plan:free@0 feature:api:calls@plan:old@0 feature:api:calls@plan:pro@0"},
+		{PullOptions{Plan: refs.MustParsePlan("plan:pro@0"), FeaturePrefix: "feature:seats"}, ""},
+		{PullOptions{Tag: "beta"}, "feature:api:calls@plan:pro@0 feature:sso@plan:pro@0"},
+		{PullOptions{Tag: "beta", FeaturePrefix: "feature:api:"}, "feature:api:calls@plan:pro@0"},
+		{PullOptions{Tag: "eu"}, ""},
+	}
+	for _, tt := range tests {
+		got, err := tc.PullWithOptions(ctx, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		fps := FeaturePlans(got)
+		slices.SortFunc(fps, refs.FeaturePlan.Less)
+		if s := strings.Join(values.Strings(fps), " "); s != tt.want {
+			t.Errorf("PullWithOptions(%+v) = %q; want %q", tt.opts, s, tt.want)
+		}
+	}
+}
+
 func TestPushPlanInvalidDecimal(t *testing.T) {
 	tc := newTestClient(t) // TODO(bmizerany): use a client without creating an account
 	ctx := context.Background()
@@ -109,7 +192,7 @@ func TestPushPlanInvalidDecimal(t *testing.T) {
 			Aggregate:   "perpetual",
 			Tiers: []Tier{
 				// 13 decimals is greater than the max allowed by Stripe: 12 decimals
-				{Upto: 1, Price: 0.1111111111111, Base: 1},
+				{Upto: 1, Price: values.MustParseDecimal("0.1111111111111"), Base: 1},
 			},
 		},
 	}
@@ -119,6 +202,155 @@ func TestPushPlanInvalidDecimal(t *testing.T) {
 	}
 }
 
+func TestPushStripeLimits(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	long := strings.Repeat("x", 180)
+	fs := []Feature{
+		{
+			// 180 characters is fine in refs, but the ID made from it
+			// is too long for a Stripe lookup key.
+			FeaturePlan: mpf("feature:" + long + "@plan:free@1"),
+			Interval:    "@monthly",
+			Currency:    "usd",
+		},
+	}
+	got := tc.Push(ctx, fs, pushLogWith(t, t.Logf))
+	if !errors.Is(got, ErrStripeLimit) {
+		t.Fatalf("got %v, want ErrStripeLimit", got)
+	}
+
+	fs[0].FeaturePlan = mpf("feature:x@plan:free@1")
+	fs[0].Title = strings.Repeat("t", stripe.MaxMetadataValueLen+1)
+	got = tc.Push(ctx, fs, pushLogWith(t, t.Logf))
+	if !errors.Is(got, ErrStripeLimit) {
+		t.Fatalf("got %v, want ErrStripeLimit", got)
+	}
+
+	fs[0].FeaturePlan = mpf("feature:" + strings.Repeat("x", 150) + "@plan:free@1")
+	fs[0].Title = ""
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Logf)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestArchive(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	var fs []Feature
+	for _, s := range []string{
+		"feature:x@plan:pro@1",
+		"feature:y@plan:pro@1",
+		"feature:x@plan:pro@2",
+	} {
+		fs = append(fs, Feature{FeaturePlan: mpf(s), Interval: "@monthly", Currency: "usd"})
+	}
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SubscribeTo(ctx, "org:example", FeaturePlans(fs[:2])); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.Archive(ctx, refs.MustParsePlan("plan:pro@1")); err != nil {
+		t.Fatal(err)
+	}
+	// Archiving again is a no-op.
+	if err := tc.Archive(ctx, refs.MustParsePlan("plan:pro@1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Archive(ctx, refs.MustParsePlan("plan:free@1")); !errors.Is(err, ErrPlanNotFound) {
+		t.Errorf("err = %v; want ErrPlanNotFound", err)
+	}
+
+	pulled, err := tc.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, FeaturePlans(pulled), FeaturePlans(fs[2:]))
+
+	all, err := tc.PullAll(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archived := map[string]bool{}
+	for _, f := range all {
+		archived[f.String()] = f.Archived
+	}
+	diff.Test(t, t.Errorf, archived, map[string]bool{
+		"feature:x@plan:pro@1": true,
+		"feature:y@plan:pro@1": true,
+		"feature:x@plan:pro@2": false,
+	})
+
+	// Orgs subscribed to the archived plan are unaffected.
+	ps, err := tc.LookupPhases(ctx, "org:example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 1 || !slices.Equal(ps[0].Plans, refs.MustParsePlans("plan:pro@1")) {
+		t.Errorf("phases = %v; want plan:pro@1", ps)
+	}
+
+	// Archived plans are not removals.
+	cs, err := tc.Diff(ctx, fs[2:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 0 {
+		t.Errorf("Diff = %v; want no changes", cs)
+	}
+}
+
+func TestArchiveFeature(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	f := func(s string) Feature {
+		return Feature{FeaturePlan: mpf(s), Interval: "@monthly", Currency: "usd"}
+	}
+	x, y := f("feature:x@plan:pro@1"), f("feature:y@plan:pro@1")
+	yearly := x
+	yearly.Variant = true
+	yearly.Interval = "@yearly"
+	sso := Feature{FeaturePlan: mpf("feature:sso@plan:pro@1"), Flag: true}
+	if err := tc.Push(ctx, []Feature{x, yearly, y, sso}, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.ArchiveFeature(ctx, x.FeaturePlan); err != nil {
+		t.Fatal(err)
+	}
+	// Archiving again is a no-op.
+	if err := tc.ArchiveFeature(ctx, x.FeaturePlan); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.ArchiveFeature(ctx, mpf("feature:z@plan:pro@1")); !errors.Is(err, ErrFeatureNotFound) {
+		t.Errorf("err = %v; want ErrFeatureNotFound", err)
+	}
+	var ve *ValidationError
+	if err := tc.ArchiveFeature(ctx, sso.FeaturePlan); !errors.As(err, &ve) {
+		t.Errorf("archiving flag: err = %v; want *ValidationError", err)
+	}
+
+	all, err := tc.PullAll(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archived := map[string]bool{}
+	for _, f := range all {
+		archived[f.ID()] = f.Archived
+	}
+	diff.Test(t, t.Errorf, archived, map[string]bool{
+		x.ID():      true,
+		yearly.ID(): true,
+		y.ID():      false,
+		sso.ID():    false,
+	})
+}
+
 func TestPushPlanImmutability(t *testing.T) {
 	tc := newTestClient(t)
 	ctx := context.Background()
@@ -199,6 +431,326 @@ func TestPushAllFeaturesLoggedOnFailure(t *testing.T) {
 	check([]error{ErrPlanExists, ErrPlanExists})
 }
 
+func TestPushWithOptions(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	f := func(s string) Feature {
+		return Feature{FeaturePlan: mpf(s), Interval: "@monthly", Currency: "usd"}
+	}
+	if err := tc.Push(ctx, []Feature{f("feature:x@plan:free@0")}, pushLogger(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		Feature       string
+		Stage, Status string
+	}
+	push := func(fs []Feature) (got []result, err error) {
+		t.Helper()
+		err = tc.PushWithOptions(ctx, fs, PushOptions{
+			Concurrency: 1,
+			Progress: func(p PushProgress) {
+				if p.Done != len(got)+1 || p.Total != len(fs) {
+					t.Errorf("%s: progress = %d/%d; want %d/%d", p.Feature, p.Done, p.Total, len(got)+1, len(fs))
+				}
+				if (p.Status == PushCreated) == (p.Feature.ProviderID == "") {
+					t.Errorf("%s: status %q with ProviderID %q", p.Feature, p.Status, p.Feature.ProviderID)
+				}
+				got = append(got, result{p.Feature.String(), p.Stage, p.Status})
+			},
+		})
+		slices.SortFunc(got, func(a, b result) bool {
+			return a.Feature < b.Feature
+		})
+		return got, err
+	}
+
+	got, err := push([]Feature{
+		f("feature:x@plan:free@0"),
+		f("feature:x@plan:pro@0"),
+		f("feature:y@plan:pro@0"),
+	})
+	if !errors.Is(err, ErrPlanExists) {
+		t.Errorf("err = %v; want ErrPlanExists", err)
+	}
+	diff.Test(t, t.Errorf, got, []result{
+		{"feature:x@plan:free@0", PushStageProduct, PushSkipped},
+		{"feature:x@plan:pro@0", PushStagePrice, PushCreated},
+		{"feature:y@plan:pro@0", PushStagePrice, PushCreated},
+	})
+
+	// Features failing checks are reported before anything is pushed.
+	bad := f("feature:x@plan:bad@0")
+	bad.OneTime = true
+	bad.Tiers = []Tier{{Upto: Inf}}
+	got, err = push([]Feature{bad})
+	if !errors.Is(err, ErrInvalidPrice) {
+		t.Errorf("err = %v; want ErrInvalidPrice", err)
+	}
+	diff.Test(t, t.Errorf, got, []result{
+		{"feature:x@plan:bad@0", "", PushFailed},
+	})
+}
+
+func TestPushError(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	f := func(s, interval string) Feature {
+		return Feature{FeaturePlan: mpf(s), Interval: interval, Currency: "usd"}
+	}
+	if err := tc.Push(ctx, []Feature{f("feature:x@plan:free@0", "@monthly")}, pushLogger(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	err := tc.Push(ctx, []Feature{
+		f("feature:x@plan:pro@0", "@hourly"), // fails in Stripe
+		f("feature:x@plan:free@0", "@monthly"),
+		f("feature:y@plan:pro@0", "@monthly"),
+	}, func(Feature, error) {})
+	var pe *PushError
+	if !errors.As(err, &pe) {
+		t.Fatalf("err = %v; want *PushError", err)
+	}
+	if !errors.Is(err, ErrPlanExists) {
+		t.Errorf("err = %v; want ErrPlanExists", err)
+	}
+
+	type result struct {
+		Feature, Status string
+	}
+	results := func(rs []PushResult) []result {
+		var got []result
+		for _, r := range rs {
+			got = append(got, result{r.Feature.String(), r.Status})
+		}
+		return got
+	}
+	diff.Test(t, t.Errorf, results(pe.Results), []result{
+		{"feature:x@plan:pro@0", PushFailed},
+		{"feature:x@plan:free@0", PushSkipped},
+		{"feature:y@plan:pro@0", PushCreated},
+	})
+	diff.Test(t, t.Errorf, results(pe.Failed()), []result{{"feature:x@plan:pro@0", PushFailed}})
+	diff.Test(t, t.Errorf, results(pe.Skipped()), []result{{"feature:x@plan:free@0", PushSkipped}})
+
+	// Features that all exist are only skipped.
+	err = tc.Push(ctx, []Feature{f("feature:x@plan:free@0", "@monthly")}, func(Feature, error) {})
+	if !errors.As(err, &pe) || len(pe.Failed()) != 0 || len(pe.Skipped()) != 1 {
+		t.Errorf("err = %v; want *PushError with one feature skipped", err)
+	}
+}
+
+func TestPushVariantError(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	base := Feature{FeaturePlan: mpf("feature:x@plan:pro@0"), Interval: "@monthly", Currency: "usd"}
+	variant := base
+	variant.Variant = true
+	variant.Interval = "@hourly" // fails in Stripe
+
+	err := tc.PushWithOptions(ctx, []Feature{base, variant}, PushOptions{Concurrency: 1})
+	var pe *PushError
+	if !errors.As(err, &pe) {
+		t.Fatalf("err = %v; want *PushError", err)
+	}
+
+	type result struct {
+		Interval, Status string
+		Variant          bool
+	}
+	var got []result
+	for _, r := range pe.Results {
+		got = append(got, result{r.Feature.Interval, r.Status, r.Feature.Variant})
+	}
+	diff.Test(t, t.Errorf, got, []result{
+		{"@monthly", PushCreated, false},
+		{"@hourly", PushFailed, true},
+	})
+}
+
+func TestPushCanceled(t *testing.T) {
+	tc := newTestClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	fs := []Feature{
+		{FeaturePlan: mpf("feature:x@plan:pro@0"), Interval: "@monthly", Currency: "usd"},
+		{FeaturePlan: mpf("feature:y@plan:pro@0"), Interval: "@monthly", Currency: "usd"},
+	}
+	err := tc.PushWithOptions(ctx, fs, PushOptions{Rollback: true})
+	var pe *PushError
+	if !errors.As(err, &pe) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v; want *PushError for context.Canceled", err)
+	}
+	if len(pe.Failed()) != len(fs) {
+		t.Errorf("failed = %v; want all features", pe.Failed())
+	}
+
+	got, err := tc.Pull(context.Background(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("pulled %v; want nothing pushed", got)
+	}
+}
+
+func TestPushRollback(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	f := func(s, interval string) Feature {
+		return Feature{FeaturePlan: mpf(s), Interval: interval, Currency: "usd"}
+	}
+	fs := []Feature{
+		f("feature:x@plan:pro@0", "@monthly"),
+		f("feature:y@plan:pro@0", "@hourly"), // fails in Stripe
+		f("feature:z@plan:free@0", "@monthly"),
+	}
+	var created []refs.FeaturePlan
+	err := tc.PushWithOptions(ctx, fs, PushOptions{
+		Concurrency: 1,
+		Rollback:    true,
+		Progress: func(p PushProgress) {
+			if p.Status == PushCreated {
+				created = append(created, p.Feature.FeaturePlan)
+			}
+		},
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	if len(created) == 0 {
+		t.Fatal("expected features to be created before the failure")
+	}
+
+	pulled, err := tc.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pulled) > 0 {
+		t.Errorf("pulled %v; want none after rollback", FeaturePlans(pulled))
+	}
+	pulled, err = tc.PullAll(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archived := FeaturePlans(pulled)
+	slices.SortFunc(archived, refs.FeaturePlan.Less)
+	slices.SortFunc(created, refs.FeaturePlan.Less)
+	diff.Test(t, t.Errorf, archived, created)
+}
+
+func TestPushFlags(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:seats@plan:pro@0"),
+		PlanTitle:   "Pro",
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Base:        1000,
+	}, {
+		FeaturePlan: mpf("feature:sso@plan:pro@0"),
+		PlanTitle:   "Pro",
+		Flag:        true,
+	}}
+	if err := tc.Push(ctx, fs, pushLogger(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	pulled, err := tc.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(pulled, func(a, b Feature) bool {
+		return a.Less(b.FeaturePlan)
+	})
+	diff.Test(t, t.Errorf, pulled, fs, ignoreProviderIDs)
+
+	// Subscribing to the plan subscribes to its priced features, and
+	// entitles the org to its flags.
+	efs, err := Expand(pulled, "plan:pro@0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SubscribeTo(ctx, "org:example", efs); err != nil {
+		t.Fatal(err)
+	}
+	limits, err := tc.LookupLimits(ctx, "org:example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []refs.FeaturePlan
+	for _, u := range limits {
+		if u.Feature == mpf("feature:sso@plan:pro@0") {
+			if u.Limit != Inf {
+				t.Errorf("sso limit = %d; want Inf", u.Limit)
+			}
+		}
+		got = append(got, u.Feature)
+	}
+	slices.SortFunc(got, refs.FeaturePlan.Less)
+	diff.Test(t, t.Errorf, got, FeaturePlans(fs))
+
+	// Flags must be in a plan and have no price.
+	for _, f := range []Feature{
+		{FeaturePlan: mpf("feature:sso@0"), Flag: true},
+		{FeaturePlan: mpf("feature:sso@plan:team@0"), Flag: true, Base: 1},
+	} {
+		err := tc.Push(ctx, []Feature{f}, func(Feature, error) {})
+		if !errors.Is(err, ErrInvalidPrice) {
+			t.Errorf("Push(%s) = %v; want ErrInvalidPrice", f.FeaturePlan, err)
+		}
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	var r metrics.Registry
+	tc.Metrics = &r
+	tc.Stripe.Metrics = &r
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:free@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}}
+	if err := tc.Push(ctx, fs, pushLogger(t)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Push(ctx, fs, func(Feature, error) {}); err == nil {
+		t.Fatal("expected error pushing existing plan")
+	}
+	if err := tc.SubscribeTo(ctx, "org:example", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+
+	var b strings.Builder
+	if _, err := r.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	got := b.String()
+	for _, want := range []string{
+		`tier_push_features_total{status="created"} 1`,
+		`tier_push_features_total{status="skipped"} 1`,
+		`tier_push_duration_seconds_count{result="ok"} 1`,
+		`tier_push_duration_seconds_count{result="error"} 1`,
+		`tier_schedule_duration_seconds_count{result="ok"} 1`,
+		`tier_stripe_request_duration_seconds_count{method="POST",status="200"}`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("metrics missing %q; got:\n%s", want, got)
+		}
+	}
+}
+
 func pushLogger(t *testing.T) func(f Feature, err error) {
 	t.Helper()
 	return pushLogWith(t, t.Fatalf)
@@ -215,3 +767,273 @@ func pushLogWith(t *testing.T, fatalf func(string, ...any)) func(f Feature, err
 		}
 	}
 }
+
+func TestClockLiveMode(t *testing.T) {
+	c := &Client{
+		Stripe: &stripe.Client{APIKey: "sk_live_123"},
+		Logf:   t.Logf,
+	}
+	ctx := context.Background()
+	if _, err := c.CreateClock(ctx, "test", time.Now()); !errors.Is(err, ErrLiveClock) {
+		t.Errorf("CreateClock: got %v, want %v", err, ErrLiveClock)
+	}
+	if _, err := c.AdvanceClock(ctx, "clock_123", time.Now()); !errors.Is(err, ErrLiveClock) {
+		t.Errorf("AdvanceClock: got %v, want %v", err, ErrLiveClock)
+	}
+	if _, err := c.LookupClock(ctx, "staging"); !errors.Is(err, ErrLiveClock) {
+		t.Errorf("LookupClock: got %v, want %v", err, ErrLiveClock)
+	}
+	if _, err := c.WaitClock(ctx, "clock_123"); !errors.Is(err, ErrLiveClock) {
+		t.Errorf("WaitClock: got %v, want %v", err, ErrLiveClock)
+	}
+	if err := c.DeleteClock(ctx, "clock_123"); !errors.Is(err, ErrLiveClock) {
+		t.Errorf("DeleteClock: got %v, want %v", err, ErrLiveClock)
+	}
+	if err := c.AttachClock(ctx, "org:test", "clock_123", nil); !errors.Is(err, ErrLiveClock) {
+		t.Errorf("AttachClock: got %v, want %v", err, ErrLiveClock)
+	}
+}
+
+func TestAttachClock(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock, err := tc.CreateClock(ctx, t.Name(), t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := tc.CreateClock(ctx, t.Name()+"-other", t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := tc.LookupClock(ctx, t.Name()); err != nil || got.ID != clock.ID {
+		t.Errorf("LookupClock = %+v, %v; want %s", got, err, clock.ID)
+	}
+	if _, err := tc.LookupClock(ctx, t.Name()+"-none"); !errors.Is(err, ErrClockNotFound) {
+		t.Errorf("LookupClock(unknown): got %v, want %v", err, ErrClockNotFound)
+	}
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:trial@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		TrialDays:   14,
+	}}
+	if err := tc.Push(ctx, fs, pushLogger(t)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tc.AttachClock(ctx, "org:a", clock.ID, &OrgInfo{Email: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.AttachClock(ctx, "org:a", clock.ID, nil); err != nil {
+		t.Errorf("attaching again: %v", err)
+	}
+	if err := tc.AttachClock(ctx, "org:a", other.ID, nil); !errors.Is(err, ErrOrgExists) {
+		t.Errorf("attaching to other clock: got %v, want %v", err, ErrOrgExists)
+	}
+	if err := tc.PutCustomer(ctx, "org:b", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.AttachClock(ctx, "org:b", clock.ID, nil); !errors.Is(err, ErrOrgExists) {
+		t.Errorf("attaching existing org: got %v, want %v", err, ErrOrgExists)
+	}
+
+	// Trials of attached orgs start at the present of their clock.
+	if err := tc.SubscribeTo(ctx, "org:a", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+	ps, err := tc.LookupPhases(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) == 0 || !ps[0].TrialEnd.Equal(t0.AddDate(0, 0, 14)) {
+		t.Errorf("phases = %+v; want trial ending %v", ps, t0.AddDate(0, 0, 14))
+	}
+
+	t1 := t0.AddDate(0, 1, 0)
+	if _, err := tc.AdvanceClock(ctx, clock.ID, t1); err != nil {
+		t.Fatal(err)
+	}
+	got, err := tc.WaitClock(ctx, clock.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != "ready" || !got.Present.Equal(t1) {
+		t.Errorf("clock = %+v; want ready at %v", got, t1)
+	}
+	if err := tc.DeleteClock(ctx, other.ID); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLiveModeGuard(t *testing.T) {
+	c := &Client{
+		Stripe: &stripe.Client{APIKey: "sk_live_123"},
+		Logf:   t.Logf,
+	}
+	ctx := context.Background()
+	check := func(name string, err error) {
+		t.Helper()
+		if !errors.Is(err, stripe.ErrLiveModeGuard) {
+			t.Errorf("%s: got %v, want %v", name, err, stripe.ErrLiveModeGuard)
+		}
+	}
+	check("Cancel", c.Cancel(ctx, "org:a", false))
+	_, err := c.VoidInvoice(ctx, "org:a", "in_123")
+	check("VoidInvoice", err)
+	_, err = c.MarkInvoiceUncollectible(ctx, "org:a", "in_123")
+	check("MarkInvoiceUncollectible", err)
+	_, err = c.Refund(ctx, "org:a", "ch_123", 0, "")
+	check("Refund", err)
+	_, err = c.CreateCreditNote(ctx, "org:a", "in_123", 100, "", "")
+	check("CreateCreditNote", err)
+	check("Archive", c.Archive(ctx, mpp("plan:pro@0")))
+	check("ArchiveFeature", c.ArchiveFeature(ctx, mpf("feature:x@plan:pro@0")))
+}
+
+func TestEachOrg(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	for _, org := range []string{"org:a", "org:b", "org:c"} {
+		if err := tc.PutCustomer(ctx, org, &OrgInfo{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	errStop := errors.New("stop")
+	var got []string
+	err := tc.EachOrg(ctx, func(o Org) error {
+		got = append(got, o.ID)
+		if len(got) == 2 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("got %v; want %v", err, errStop)
+	}
+	if len(got) != 2 {
+		t.Errorf("got %d orgs; want 2", len(got))
+	}
+
+	p, err := tc.ListOrgs(ctx, ListOrgsOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Orgs) != 3 {
+		t.Errorf("ListOrgs returned %d orgs; want 3", len(p.Orgs))
+	}
+}
+
+func TestListOrgs(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	for _, org := range []string{"org:a", "org:b", "org:c", "org:d", "org:e"} {
+		info := &OrgInfo{Metadata: map[string]string{"tier": "pro"}}
+		if org == "org:c" {
+			info.Metadata["tier"] = "free"
+		}
+		if err := tc.PutCustomer(ctx, org, info); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := func(opts ListOrgsOptions) []string {
+		t.Helper()
+		var got []string
+		for i := 0; ; i++ {
+			if i > 10 {
+				t.Fatal("too many pages")
+			}
+			p, err := tc.ListOrgs(ctx, opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, o := range p.Orgs {
+				got = append(got, o.ID)
+			}
+			if p.Next == "" {
+				return got
+			}
+			opts.StartingAfter = p.Next
+		}
+	}
+
+	diff.Test(t, t.Errorf, list(ListOrgsOptions{Limit: 2}), []string{"org:e", "org:d", "org:c", "org:b", "org:a"})
+	diff.Test(t, t.Errorf, list(ListOrgsOptions{
+		Limit:    2,
+		Metadata: map[string]string{"tier": "pro"},
+	}), []string{"org:e", "org:d", "org:b", "org:a"})
+
+	now := time.Now()
+	if got := list(ListOrgsOptions{CreatedAfter: now.Add(time.Hour)}); len(got) != 0 {
+		t.Errorf("orgs created in the future = %q; want none", got)
+	}
+	if got := list(ListOrgsOptions{CreatedBefore: now.Add(time.Hour)}); len(got) != 5 {
+		t.Errorf("orgs created before now = %q; want all 5", got)
+	}
+
+	p, err := tc.ListOrgs(ctx, ListOrgsOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, p.Orgs[0].Metadata, map[string]string{"tier": "pro"})
+
+	var ve *ValidationError
+	if _, err := tc.ListOrgs(ctx, ListOrgsOptions{Limit: 101}); !errors.As(err, &ve) {
+		t.Errorf("listing 101 orgs: err = %v; want *ValidationError", err)
+	}
+}
+
+func TestExpandLatest(t *testing.T) {
+	var fs []Feature
+	for _, s := range []string{
+		"feature:x@plan:pro@2",
+		"feature:x@plan:pro@10",
+		"feature:y@plan:pro@10",
+		"feature:x@plan:free@11",
+		"feature:x@plan:team@1.9.0",
+		"feature:x@plan:team@1.10.0",
+	} {
+		fs = append(fs, Feature{FeaturePlan: mpf(s)})
+	}
+
+	got, err := Expand(fs, "plan:pro@latest", "feature:x@plan:free@latest", "plan:team@latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := refs.MustParseFeaturePlans(
+		"feature:x@plan:pro@10",
+		"feature:y@plan:pro@10",
+		"feature:x@plan:free@11",
+		"feature:x@plan:team@1.10.0",
+	)
+	diff.Test(t, t.Errorf, got, want)
+
+	if _, err := Expand(fs, "plan:basic@latest"); err == nil {
+		t.Error("expected error for unknown plan")
+	}
+}
+
+func TestFeatureByID(t *testing.T) {
+	fs := []Feature{
+		{FeaturePlan: mpf("feature:x@plan:pro@1"), ProviderID: "price_1"},
+		{FeaturePlan: mpf("feature:x@plan:pro@1"), Variant: true, Interval: "@yearly", ProviderID: "price_2"},
+		{FeaturePlan: mpf("feature:api:plan:reads@plan:pro@1"), ProviderID: "price_3"},
+	}
+	for _, f := range fs {
+		for _, id := range []string{f.ID(), f.ProviderID} {
+			got, ok := FeatureByID(fs, id)
+			if !ok || got.ProviderID != f.ProviderID {
+				t.Errorf("FeatureByID(%q) = %v, %v; want %v", id, got.ProviderID, ok, f.ProviderID)
+			}
+		}
+	}
+	if _, ok := FeatureByID(fs, "tier__feature-y-plan-pro-1"); ok {
+		t.Error("FeatureByID found unknown ID")
+	}
+}
@@ -0,0 +1,141 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"tier.run/stripe"
+)
+
+// ErrLiveClock is returned when test clocks are requested with a live key.
+var ErrLiveClock = errors.New("test clocks are not available in live mode")
+
+// ErrClockNotFound is returned by LookupClock when there is no test clock
+// with the requested name.
+var ErrClockNotFound = errors.New("test clock not found")
+
+// ErrOrgExists is returned by AttachClock for orgs that already exist on
+// another clock, or on none, since Stripe cannot move customers between
+// clocks.
+var ErrOrgExists = errors.New("org already exists")
+
+// Clock holds the state of a Stripe test clock.
+type Clock struct {
+	ID      string
+	Name    string
+	Present time.Time // the frozen time of the clock
+	Status  string    // "ready", "advancing", or "internal_failure"
+}
+
+// CreateClock creates a new test clock frozen at start. It returns
+// ErrLiveClock if the client is using a live key.
+func (c *Client) CreateClock(ctx context.Context, name string, start time.Time) (Clock, error) {
+	if c.Live() {
+		return Clock{}, ErrLiveClock
+	}
+	return fromStripeClock(c.Stripe.CreateClock(ctx, name, start))
+}
+
+// AdvanceClock moves the test clock with the provided id forward to t.
+// Advancing is asynchronous in Stripe; callers should use SyncClock to wait
+// for the returned Clock status to become "ready".
+func (c *Client) AdvanceClock(ctx context.Context, id string, t time.Time) (Clock, error) {
+	if c.Live() {
+		return Clock{}, ErrLiveClock
+	}
+	return fromStripeClock(c.Stripe.AdvanceClock(ctx, id, t))
+}
+
+// SyncClock retrieves the current state of the test clock with the provided
+// id.
+func (c *Client) SyncClock(ctx context.Context, id string) (Clock, error) {
+	if c.Live() {
+		return Clock{}, ErrLiveClock
+	}
+	return fromStripeClock(c.Stripe.RetrieveClock(ctx, id))
+}
+
+// LookupClock returns the newest test clock named name, such as the name
+// of a test environment. It returns ErrClockNotFound if there is none.
+func (c *Client) LookupClock(ctx context.Context, name string) (Clock, error) {
+	if c.Live() {
+		return Clock{}, ErrLiveClock
+	}
+	cs, err := c.Stripe.ListClocks(ctx)
+	if err != nil {
+		return Clock{}, err
+	}
+	for _, cl := range cs {
+		if cl.Name == name {
+			return Clock(cl), nil
+		}
+	}
+	return Clock{}, ErrClockNotFound
+}
+
+// WaitClock waits until the test clock with the provided id has finished
+// advancing, and returns its state. It returns stripe.ErrClockFailed if
+// Stripe failed to advance the clock.
+func (c *Client) WaitClock(ctx context.Context, id string) (Clock, error) {
+	if c.Live() {
+		return Clock{}, ErrLiveClock
+	}
+	return fromStripeClock(c.Stripe.WaitClock(ctx, id))
+}
+
+// DeleteClock deletes the test clock with the provided id, along with the
+// orgs attached to it.
+func (c *Client) DeleteClock(ctx context.Context, id string) error {
+	if c.Live() {
+		return ErrLiveClock
+	}
+	return c.Stripe.DeleteClock(ctx, id)
+}
+
+// AttachClock creates org, with info if not nil, attached to the test
+// clock with the provided id, so that its subscriptions, trials, and
+// invoices follow the clock as it is advanced, while other orgs follow
+// Client.Clock, if set, or the current time.
+//
+// Orgs can only be attached to clocks when they are created. AttachClock
+// returns nil if org is already attached to the clock, and an error
+// wrapping ErrOrgExists if org exists otherwise.
+func (c *Client) AttachClock(ctx context.Context, org, id string, info *OrgInfo) error {
+	if c.Live() {
+		return ErrLiveClock
+	}
+	clock, err := c.orgClock(ctx, org)
+	switch {
+	case errors.Is(err, ErrOrgNotFound):
+		_, err = c.createCustomer(ctx, org, id, info)
+		return err
+	case err != nil:
+		return err
+	case clock != id:
+		return fmt.Errorf("%w: %s is not attached to test clock %q", ErrOrgExists, org, id)
+	}
+	return nil
+}
+
+// orgClock returns the ID of the test clock org is attached to, if any.
+func (c *Client) orgClock(ctx context.Context, org string) (string, error) {
+	cid, err := c.WhoIs(ctx, org)
+	if err != nil {
+		return "", err
+	}
+	var cus struct {
+		TestClock string `json:"test_clock"`
+	}
+	err = c.Stripe.Do(ctx, "GET", "/v1/customers/"+cid, stripe.Form{}, &cus)
+	return cus.TestClock, err
+}
+
+// fromStripeClock converts the result of a stripe.Client clock method.
+func fromStripeClock(c stripe.Clock, err error) (Clock, error) {
+	if err != nil {
+		return Clock{}, err
+	}
+	return Clock(c), nil
+}
@@ -0,0 +1,89 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"tier.run/stripe"
+	"tier.run/values"
+)
+
+// A CreditTransaction is a change to the credit balance of an org.
+type CreditTransaction struct {
+	ID          string
+	Amount      int // credit granted, or deducted if negative
+	Currency    string
+	Description string
+	Credit      int // the credit balance of the org after the change
+	Created     time.Time
+}
+
+// AdjustCredit grants amount of credit to org, such as promotional or
+// goodwill credit, or deducts it if amount is negative, in the smallest unit
+// of currency. Credit is applied to the org's next invoices. Description,
+// if not empty, is shown to the org on its invoices.
+//
+// If currency is empty, the currency of the org's balance is used, or "usd"
+// if it has none. Otherwise it must match the currency of the balance.
+//
+// It reports a *ValidationError if amount is zero, or if it deducts more
+// credit than the org has, which would otherwise charge the difference on
+// the org's next invoice.
+func (c *Client) AdjustCredit(ctx context.Context, org string, amount int, currency, description string) (_ CreditTransaction, err error) {
+	if amount == 0 {
+		return CreditTransaction{}, &ValidationError{Message: "credit amount must not be zero"}
+	}
+	info, err := c.LookupOrg(ctx, org)
+	if err != nil {
+		return CreditTransaction{}, err
+	}
+	cid, err := c.WhoIs(ctx, org)
+	if err != nil {
+		return CreditTransaction{}, err
+	}
+
+	type state struct {
+		Credit      int    `json:"credit"`
+		Currency    string `json:"currency,omitempty"`
+		Description string `json:"description,omitempty"`
+	}
+	currency = values.Coalesce(currency, info.CreditCurrency, "usd")
+	defer c.audit(ctx, AuditCredit, org, func() (any, error) {
+		return state{info.Credit, info.CreditCurrency, ""}, nil
+	}, state{info.Credit + amount, currency, description}, &err)()
+
+	if info.CreditCurrency != "" && currency != info.CreditCurrency {
+		return CreditTransaction{}, &ValidationError{Message: fmt.Sprintf("credit currency must be %s, the currency of the org's balance", info.CreditCurrency)}
+	}
+	if amount < 0 && -amount > info.Credit {
+		return CreditTransaction{}, &ValidationError{Message: fmt.Sprintf("deducting %d exceeds the org's credit of %d", -amount, info.Credit)}
+	}
+
+	// Stripe balances are amounts owed, so credit is negative.
+	var f stripe.Form
+	f.Set("amount", -amount)
+	f.Set("currency", currency)
+	if description != "" {
+		f.Set("description", description)
+	}
+	var t struct {
+		stripe.ID
+		Amount        int
+		Currency      string
+		Description   string
+		EndingBalance int `json:"ending_balance"`
+		Created       int64
+	}
+	if err = c.Stripe.Do(ctx, "POST", "/v1/customers/"+cid+"/balance_transactions", f, &t); err != nil {
+		return CreditTransaction{}, err
+	}
+	return CreditTransaction{
+		ID:          t.ProviderID(),
+		Amount:      -t.Amount,
+		Currency:    t.Currency,
+		Description: t.Description,
+		Credit:      -t.EndingBalance,
+		Created:     time.Unix(t.Created, 0),
+	}, nil
+}
@@ -0,0 +1,75 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"kr.dev/diff"
+)
+
+func TestAdjustCredit(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	var log MemoryAuditLog
+	tc.Audit = &log
+
+	if _, err := tc.AdjustCredit(ctx, "org:a", 5000, "", ""); !errors.Is(err, ErrOrgNotFound) {
+		t.Errorf("crediting unknown org: err = %v; want %v", err, ErrOrgNotFound)
+	}
+	if err := tc.PutCustomer(ctx, "org:a", &OrgInfo{Email: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var ve *ValidationError
+	for _, amount := range []int{0, -1} {
+		if _, err := tc.AdjustCredit(ctx, "org:a", amount, "", ""); !errors.As(err, &ve) {
+			t.Errorf("adjusting by %d: err = %v; want *ValidationError", amount, err)
+		}
+	}
+
+	ct, err := tc.AdjustCredit(ctx, "org:a", 5000, "", "Goodwill credit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct.Amount != 5000 || ct.Currency != "usd" || ct.Credit != 5000 || ct.Description != "Goodwill credit" {
+		t.Errorf("transaction = %+v; want 5000 usd of goodwill credit", ct)
+	}
+	if _, err := tc.AdjustCredit(ctx, "org:a", 100, "eur", ""); !errors.As(err, &ve) {
+		t.Errorf("crediting other currency: err = %v; want *ValidationError", err)
+	}
+	if _, err := tc.AdjustCredit(ctx, "org:a", -6000, "", ""); !errors.As(err, &ve) {
+		t.Errorf("deducting too much: err = %v; want *ValidationError", err)
+	}
+	ct, err = tc.AdjustCredit(ctx, "org:a", -2000, "usd", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct.Amount != -2000 || ct.Credit != 3000 {
+		t.Errorf("transaction = %+v; want 2000 deducted leaving 3000", ct)
+	}
+
+	info, err := tc.LookupOrg(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Credit != 3000 || info.CreditCurrency != "usd" {
+		t.Errorf("credit = %d %s; want 3000 usd", info.Credit, info.CreditCurrency)
+	}
+
+	es, err := log.List(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ok []string
+	for _, e := range es {
+		if e.Op == AuditCredit && e.Error == "" {
+			ok = append(ok, string(e.Before)+" "+string(e.After))
+		}
+	}
+	diff.Test(t, t.Errorf, ok, []string{
+		`{"credit":5000,"currency":"usd"} {"credit":3000,"currency":"usd"}`,
+		`{"credit":0} {"credit":5000,"currency":"usd","description":"Goodwill credit"}`,
+	})
+}
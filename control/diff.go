@@ -0,0 +1,174 @@
+package control
+
+import (
+	"context"
+
+	"golang.org/x/exp/slices"
+	"tier.run/refs"
+)
+
+// Change operations.
+const (
+	OpAdd    = "add"    // the feature is in the model but not in Stripe
+	OpChange = "change" // the feature is in both, but defined differently
+	OpRemove = "remove" // the feature is in Stripe but not in the model
+)
+
+// A Change describes how a feature in a pricing model differs from the
+// feature pushed to Stripe, if any.
+type Change struct {
+	Op       string // OpAdd, OpChange, or OpRemove
+	Feature  refs.FeaturePlan
+	Interval string // the interval of the variant changed, if a variant
+
+	// Fields names the fields that differ for OpChange, as named in the
+	// pricing model JSON, such as "title" or "plan.currency".
+	Fields []string
+}
+
+// Diff reports how the pricing model made up of fs differs from the
+// features currently in Stripe, ordered by feature as ordered by
+// refs.ByPlan. Features in archived plans are not reported as removed.
+//
+// Push only ever adds features, since features in Stripe are immutable.
+// Changed features are skipped by Push with ErrFeatureExists, and removed
+// features are left in place, so changes and removals are best made by
+// pushing a new plan version.
+func (c *Client) Diff(ctx context.Context, fs []Feature) ([]Change, error) {
+	pulled, err := c.PullAll(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	return DiffFeatures(pulled, fs), nil
+}
+
+// DiffFeatures returns the changes needed to make the features have, as
+// pulled from a Provider, match the features want, as Diff does.
+func DiffFeatures(have, want []Feature) []Change {
+	// Features are keyed by ID so that variants are distinct.
+	inStripe := make(map[string]Feature, len(have))
+	for _, f := range have {
+		inStripe[f.ID()] = f
+	}
+	inModel := make(map[string]bool, len(want))
+
+	var cs []Change
+	change := func(op string, f Feature, fields []string) {
+		ch := Change{Op: op, Feature: f.FeaturePlan, Fields: fields}
+		if f.Variant {
+			ch.Interval = f.Interval
+		}
+		cs = append(cs, ch)
+	}
+	for _, f := range want {
+		inModel[f.ID()] = true
+		hf, ok := inStripe[f.ID()]
+		if !ok {
+			change(OpAdd, f, nil)
+			continue
+		}
+		if fields := diffFields(hf, f); len(fields) > 0 {
+			change(OpChange, f, fields)
+		}
+	}
+	for _, f := range have {
+		if !inModel[f.ID()] && !f.Archived {
+			change(OpRemove, f, nil)
+		}
+	}
+
+	slices.SortFunc(cs, func(a, b Change) bool {
+		if refs.ByPlan(a.Feature, b.Feature) {
+			return true
+		}
+		if refs.ByPlan(b.Feature, a.Feature) {
+			return false
+		}
+		if a.Feature != b.Feature {
+			return a.Feature.Less(b.Feature)
+		}
+		return a.Interval < b.Interval
+	})
+	return cs
+}
+
+// diffFields returns the names of the fields that differ between a and b.
+// Mode and aggregate are only compared for features with tiers, and base
+// only for features without, since the others are ignored by Stripe.
+// Rollout is not compared, since it may be changed after push.
+func diffFields(a, b Feature) []string {
+	var fields []string
+	check := func(name string, equal bool) {
+		if !equal {
+			fields = append(fields, name)
+		}
+	}
+	check("plan.title", a.PlanTitle == b.PlanTitle)
+	if a.Flag || b.Flag {
+		// Flags have nothing else stored in Stripe.
+		check("flag", a.Flag == b.Flag)
+		return fields
+	}
+	check("plan.interval", a.Interval == b.Interval)
+	check("plan.currency", a.Currency == b.Currency)
+	check("plan.tags", slices.Equal(a.PlanTags, b.PlanTags))
+	check("plan.trialDays", a.TrialDays == b.TrialDays)
+	check("title", a.Title == b.Title)
+	check("unit", a.Unit == b.Unit)
+	check("description", a.Description == b.Description)
+	check("displayOrder", a.DisplayOrder == b.DisplayOrder)
+	check("aliases", slices.Equal(a.Aliases, b.Aliases))
+	check("oneTime", a.OneTime == b.OneTime)
+	check("flag", a.Flag == b.Flag)
+	if len(a.Tiers) == 0 && len(b.Tiers) == 0 {
+		check("base", a.Base == b.Base)
+	} else {
+		check("mode", a.Mode == b.Mode)
+		check("aggregate", a.Aggregate == b.Aggregate)
+		check("packageSize", a.PackageSize == b.PackageSize)
+		check("tiers", slices.Equal(a.Tiers, b.Tiers))
+	}
+	return fields
+}
+
+// pushDryRun reports to cb what Push would do for each feature in fs,
+// without making any changes in Stripe. It returns the first error
+// reported, if any.
+func (c *Client) pushDryRun(ctx context.Context, fs []Feature, cb PushReportFunc) error {
+	pulled, err := c.PullAll(ctx, 0)
+	if err != nil {
+		return err
+	}
+
+	// Push fails for every feature in a plan that has already been
+	// pushed, so plans with any features in Stripe will fail.
+	inStripe := refs.GroupByPlan(FeaturePlans(pulled))
+	pushed := make(map[string]bool, len(pulled))
+	for _, f := range pulled {
+		pushed[f.ID()] = true
+	}
+	invalid := map[refs.FeaturePlan]error{}
+	for _, d := range Validate(fs) {
+		if d.Severity == SeverityError && invalid[d.Feature] == nil {
+			invalid[d.Feature] = &ValidationError{Message: d.Message}
+		}
+	}
+
+	var first error
+	for _, f := range fs {
+		var err error
+		switch p := f.Plan(); {
+		case invalid[f.FeaturePlan] != nil:
+			err = invalid[f.FeaturePlan]
+		case !p.IsZero() && len(inStripe[p]) > 0:
+			err = ErrPlanExists
+		case pushed[f.ID()]:
+			err = ErrFeatureExists
+		}
+		if first == nil && err != nil {
+			first = err
+		}
+		cb(f, err)
+	}
+	return first
+}
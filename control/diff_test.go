@@ -0,0 +1,118 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/refs"
+	"tier.run/values"
+)
+
+func TestDiff(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	pushed := []Feature{
+		{
+			FeaturePlan: mpf("feature:seats@plan:pro@1"),
+			PlanTitle:   "Pro",
+			Interval:    "@monthly",
+			Currency:    "usd",
+			Base:        1000,
+		},
+		{
+			FeaturePlan: mpf("feature:calls@plan:pro@1"),
+			PlanTitle:   "Pro",
+			Interval:    "@monthly",
+			Currency:    "usd",
+			Mode:        "graduated",
+			Aggregate:   "sum",
+			Tiers:       []Tier{{Upto: 10, Price: values.MustParseDecimal("1")}, {Upto: Inf, Price: values.MustParseDecimal("2")}},
+		},
+		{
+			FeaturePlan: mpf("feature:old@plan:pro@1"),
+			PlanTitle:   "Pro",
+			Interval:    "@monthly",
+			Currency:    "usd",
+		},
+	}
+	if err := tc.Push(ctx, pushed, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+
+	model := []Feature{
+		pushed[0],
+		pushed[1],
+		{
+			FeaturePlan: mpf("feature:new@plan:pro@1"),
+			PlanTitle:   "Pro",
+			Interval:    "@monthly",
+			Currency:    "usd",
+		},
+	}
+
+	got, err := tc.Diff(ctx, model)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, []Change{
+		{Op: OpAdd, Feature: mpf("feature:new@plan:pro@1")},
+		{Op: OpRemove, Feature: mpf("feature:old@plan:pro@1")},
+	})
+
+	model[0].Base = 2000
+	model[0].Title = "Seats"
+	model[1].Tiers = []Tier{{Upto: 10, Price: values.MustParseDecimal("1")}, {Upto: Inf, Price: values.MustParseDecimal("3")}}
+	model[1].Mode = "volume"
+	got, err = tc.Diff(ctx, model[:2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, []Change{
+		{Op: OpChange, Feature: mpf("feature:calls@plan:pro@1"), Fields: []string{"mode", "tiers"}},
+		{Op: OpRemove, Feature: mpf("feature:old@plan:pro@1")},
+		{Op: OpChange, Feature: mpf("feature:seats@plan:pro@1"), Fields: []string{"title", "base"}},
+	})
+}
+
+func TestPushDryRun(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	f := func(s string) Feature {
+		return Feature{FeaturePlan: mpf(s), Interval: "@monthly", Currency: "usd"}
+	}
+	if err := tc.Push(ctx, []Feature{f("feature:x@plan:free@1")}, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+
+	bad := f("feature:y@plan:pro@1")
+	bad.Interval = "@hourly"
+	fs := []Feature{
+		f("feature:x@plan:free@1"),
+		f("feature:x@plan:pro@1"),
+		bad,
+	}
+	got := map[string]string{}
+	err := tc.PushDryRun(ctx, fs, func(f Feature, err error) {
+		got[f.String()] = fmt.Sprint(err)
+	})
+	if !errors.Is(err, ErrPlanExists) {
+		t.Errorf("err = %v; want ErrPlanExists", err)
+	}
+	diff.Test(t, t.Errorf, got, map[string]string{
+		"feature:x@plan:free@1": ErrPlanExists.Error(),
+		"feature:x@plan:pro@1":  "<nil>",
+		"feature:y@plan:pro@1":  `unknown interval "@hourly"`,
+	})
+
+	// Nothing was pushed.
+	pulled, err := tc.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, FeaturePlans(pulled), refs.MustParseFeaturePlans("feature:x@plan:free@1"))
+}
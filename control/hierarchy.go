@@ -0,0 +1,254 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"tier.run/refs"
+	"tier.run/stripe"
+)
+
+// maxOrgDepth is the most ancestors an org may have. It bounds the lookups
+// made to find the org billed for a report.
+const maxOrgDepth = 5
+
+// orgLinks holds the metadata linking an org to its parent and children.
+type orgLinks struct {
+	Org      string `json:"tier.org"`
+	Parent   string `json:"tier.parent"`
+	Children string `json:"tier.children"` // "true" once the org has had a child
+	Usage    string `json:"tier.usage"`    // a childUsage, as JSON
+}
+
+// childUsage is the usage reported by a child org in the current period of
+// the subscription it is billed to.
+type childUsage struct {
+	Start int64          `json:"start"` // the start of the period, in Unix seconds
+	Used  map[string]int `json:"used"`  // by feature name
+
+	// Version counts the updates of the usage, which are made with
+	// idempotency keys of their versions, so that concurrent updates of
+	// the same version conflict instead of losing counts. Versions are
+	// kept across periods, so that keys are never reused.
+	Version int `json:"version"`
+
+	// Write identifies the update, so that concurrent updates of the same
+	// version differ, and conflict, even if they record the same use.
+	Write string `json:"write,omitempty"`
+}
+
+// childUsageAttempts is the most times recordChildUsage reads and updates
+// the usage of an org updated concurrently.
+const childUsageAttempts = 3
+
+// cachedLinks is like lookupLinks, but returns only the Parent and Children
+// of the links, which are cached with the customer IDs looked up by WhoIs,
+// so that reports and lookups of limits do not each fetch the customer of
+// org. Links changed by another Client are seen once evicted from the
+// cache, or once an operation on the customer fails.
+func (c *Client) cachedLinks(ctx context.Context, org string) (cid string, l orgLinks, err error) {
+	cid, err = c.WhoIs(ctx, org)
+	if err != nil {
+		return "", orgLinks{}, err
+	}
+	s, err := c.cache.load(linksKey(org), func() (string, error) {
+		_, l, err := c.lookupLinks(ctx, org)
+		if err != nil {
+			return "", err
+		}
+		data, err := json.Marshal(orgLinks{Parent: l.Parent, Children: l.Children})
+		return string(data), err
+	})
+	if err != nil {
+		return "", orgLinks{}, err
+	}
+	if err := json.Unmarshal([]byte(s), &l); err != nil {
+		return "", orgLinks{}, err
+	}
+	return cid, l, nil
+}
+
+// linksKey returns the key of the links of org in the cache.
+func linksKey(org string) string { return "links:" + org }
+
+func (c *Client) lookupLinks(ctx context.Context, org string) (cid string, l orgLinks, err error) {
+	cid, err = c.WhoIs(ctx, org)
+	if err != nil {
+		return "", orgLinks{}, err
+	}
+	var cus struct {
+		Metadata orgLinks
+	}
+	if err := c.Stripe.Do(ctx, "GET", "/v1/customers/"+cid, stripe.Form{}, &cus); err != nil {
+		return "", orgLinks{}, err
+	}
+	return cid, cus.Metadata, nil
+}
+
+// SetParent makes parent the parent of org, so that the usage reported by
+// org is billed to the subscription of parent, or of its topmost ancestor
+// if parent has a parent of its own. The limits of org are then those of
+// that ancestor, and its usage is broken down by the orgs reporting it; see
+// Usage.ByOrg. If parent is empty, org is detached from its parent and is
+// billed to its own subscription again.
+//
+// Both orgs must exist. It reports a *ValidationError if parent is org or
+// one of its descendants, or if org would have more than five ancestors.
+func (c *Client) SetParent(ctx context.Context, org, parent string) (err error) {
+	cid, l, err := c.lookupLinks(ctx, org)
+	if err != nil {
+		return err
+	}
+
+	type state struct {
+		Parent string `json:"parent"`
+	}
+	defer c.audit(ctx, AuditSetParent, org, func() (any, error) {
+		return state{l.Parent}, nil
+	}, state{parent}, &err)()
+
+	if parent != "" {
+		p := parent
+		for depth := 0; p != ""; depth++ {
+			if p == org {
+				return &ValidationError{Message: "org must not be an ancestor of its parent"}
+			}
+			if depth >= maxOrgDepth {
+				return &ValidationError{Message: "orgs must not have more than five ancestors"}
+			}
+			pid, pl, err := c.lookupLinks(ctx, p)
+			if err != nil {
+				return err
+			}
+			if pl.Children == "" {
+				var f stripe.Form
+				f.Set("metadata", "tier.children", "true")
+				if err := c.Stripe.Do(ctx, "POST", "/v1/customers/"+pid, f, nil); err != nil {
+					return err
+				}
+				c.cache.remove(linksKey(p))
+			}
+			p = pl.Parent
+		}
+	}
+
+	// Clear the usage recorded for the old parent, keeping its version,
+	// so that the idempotency keys of later updates are not reused.
+	var u childUsage
+	json.Unmarshal([]byte(l.Usage), &u)
+	data, err := json.Marshal(childUsage{Version: u.Version})
+	if err != nil {
+		return err
+	}
+	var f stripe.Form
+	f.Set("metadata", "tier.parent", parent) // empty deletes
+	f.Set("metadata", "tier.usage", string(data))
+	defer c.cache.remove(linksKey(org))
+	return c.Stripe.Do(ctx, "POST", "/v1/customers/"+cid, f, nil)
+}
+
+// billingOrg returns the org the usage of org is billed to: its topmost
+// ancestor, or org itself if it has no parent.
+func (c *Client) billingOrg(ctx context.Context, org string) (string, error) {
+	for depth := 0; ; depth++ {
+		_, l, err := c.cachedLinks(ctx, org)
+		if err != nil {
+			return "", err
+		}
+		if l.Parent == "" || depth >= maxOrgDepth {
+			return org, nil
+		}
+		org = l.Parent
+	}
+}
+
+// recordChildUsage adds n to the use of feature by the child org in the
+// period starting at start, for the breakdown reported by LookupLimits.
+// The usage is already billed, so the breakdown is dropped, and logged,
+// rather than failing if it no longer fits in the org's metadata.
+func (c *Client) recordChildUsage(ctx context.Context, org string, start time.Time, feature refs.Name, n int) error {
+	for i := 0; ; i++ {
+		err := c.updateChildUsage(ctx, org, start, feature, n)
+		if !errors.Is(err, stripe.ErrIdempotency) || i+1 >= childUsageAttempts {
+			return err
+		}
+		c.Logf("recordChildUsage: usage of %q updated concurrently; retrying", org)
+	}
+}
+
+// updateChildUsage makes one attempt of recordChildUsage. It returns
+// stripe.ErrIdempotency if the usage of org was updated since it was read.
+func (c *Client) updateChildUsage(ctx context.Context, org string, start time.Time, feature refs.Name, n int) error {
+	cid, l, err := c.lookupLinks(ctx, org)
+	if err != nil {
+		return err
+	}
+	var u childUsage
+	if l.Usage != "" {
+		if err := json.Unmarshal([]byte(l.Usage), &u); err != nil {
+			c.Logf("recordChildUsage: resetting usage of %q: %v", org, err)
+		}
+	}
+	version := u.Version
+	if u.Start != start.Unix() || u.Used == nil {
+		u = childUsage{Start: start.Unix(), Used: map[string]int{}}
+	}
+	u.Used[feature.String()] += n
+	u.Version = version + 1
+	u.Write = randomString()
+	data, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	if len(data) > stripe.MaxMetadataValueLen {
+		c.Logf("recordChildUsage: usage of %q too large to record: %s", org, data)
+		return nil
+	}
+	var f stripe.Form
+	f.SetIdempotencyKey(fmt.Sprintf("usage:%s:%d", org, u.Version))
+	f.Set("metadata", "tier.usage", string(data))
+	return c.Stripe.Do(ctx, "POST", "/v1/customers/"+cid, f, nil)
+}
+
+// lookupChildUsage returns the usage last reported by each descendant of
+// org, by org. Descendants are found by searching for the children of each
+// org with children, level by level, so orgs linked within the last minute
+// may be missing, as Stripe's search results lag behind.
+func (c *Client) lookupChildUsage(ctx context.Context, org string) (map[string]childUsage, error) {
+	type T struct {
+		stripe.ID
+		Metadata orgLinks
+	}
+	usage := map[string]childUsage{}
+	parents := []string{org}
+	for depth := 0; depth < maxOrgDepth && len(parents) > 0; depth++ {
+		var next []string
+		for _, p := range parents {
+			q := "metadata['tier.parent']:" + stripe.QuoteSearch(p)
+			err := stripe.Search(ctx, c.Stripe, "/v1/customers/search", q, stripe.Form{}, func(cus T) bool {
+				l := cus.Metadata
+				if l.Org == "" || l.Parent != p {
+					return true
+				}
+				if _, ok := usage[l.Org]; ok {
+					return true // seen
+				}
+				var u childUsage
+				json.Unmarshal([]byte(l.Usage), &u)
+				usage[l.Org] = u
+				if l.Children != "" {
+					next = append(next, l.Org)
+				}
+				return true
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+		parents = next
+	}
+	return usage, nil
+}
@@ -0,0 +1,162 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/sync/errgroup"
+	"kr.dev/diff"
+	"tier.run/refs"
+)
+
+func TestOrgHierarchy(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Mode:        "graduated",
+		Aggregate:   "sum",
+		Tiers:       []Tier{{Upto: 100}},
+	}}
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	for _, org := range []string{"org:parent", "org:child", "org:grandchild"} {
+		if err := tc.PutCustomer(ctx, org, &OrgInfo{Email: "a@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tc.SubscribeTo(ctx, "org:parent", []refs.FeaturePlan{mpf("feature:x@plan:pro@0")}); err != nil {
+		t.Fatal(err)
+	}
+
+	var log MemoryAuditLog
+	tc.Audit = &log
+
+	if err := tc.SetParent(ctx, "org:child", "org:parent"); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SetParent(ctx, "org:grandchild", "org:child"); err != nil {
+		t.Fatal(err)
+	}
+	var ve *ValidationError
+	if err := tc.SetParent(ctx, "org:parent", "org:grandchild"); !errors.As(err, &ve) {
+		t.Errorf("making a cycle: err = %v; want *ValidationError", err)
+	}
+	if err := tc.SetParent(ctx, "org:child", "org:child"); !errors.As(err, &ve) {
+		t.Errorf("parenting self: err = %v; want *ValidationError", err)
+	}
+	if err := tc.SetParent(ctx, "org:child", "org:missing"); !errors.Is(err, ErrOrgNotFound) {
+		t.Errorf("parenting to unknown org: err = %v; want %v", err, ErrOrgNotFound)
+	}
+	info, err := tc.LookupOrg(ctx, "org:grandchild")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Parent != "org:child" {
+		t.Errorf("Parent = %q; want %q", info.Parent, "org:child")
+	}
+
+	report := func(org string, n int) {
+		t.Helper()
+		if err := tc.ReportUsage(ctx, org, mpn("feature:x"), Report{N: n}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	report("org:parent", 1)
+	report("org:child", 2)
+	report("org:grandchild", 3)
+	report("org:child", 4)
+	if err := tc.ReportUsage(ctx, "org:child", mpn("feature:x"), Report{N: 1, Clobber: true}); !errors.As(err, &ve) {
+		t.Errorf("clobbering child usage: err = %v; want *ValidationError", err)
+	}
+
+	check := func(org string, used int, byOrg map[string]int) {
+		t.Helper()
+		got, err := tc.LookupLimits(ctx, org)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 {
+			t.Fatalf("got %d limits; want 1", len(got))
+		}
+		if got[0].Used != used {
+			t.Errorf("%s: Used = %d; want %d", org, got[0].Used, used)
+		}
+		diff.Test(t, t.Errorf, got[0].ByOrg, byOrg)
+	}
+	want := map[string]int{"org:child": 6, "org:grandchild": 3}
+	check("org:parent", 10, want)
+	check("org:grandchild", 10, want) // children share the limits of the billed org
+
+	if err := tc.SetParent(ctx, "org:grandchild", ""); err != nil {
+		t.Fatal(err)
+	}
+	check("org:parent", 10, map[string]int{"org:child": 6})
+
+	es, err := log.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range es {
+		if e.Error == "" {
+			got = append(got, e.Org+" "+string(e.Before)+" "+string(e.After))
+		}
+	}
+	diff.Test(t, t.Errorf, got, []string{
+		`org:grandchild {"parent":"org:child"} {"parent":""}`,
+		`org:grandchild {"parent":""} {"parent":"org:child"}`,
+		`org:child {"parent":""} {"parent":"org:parent"}`,
+	})
+}
+
+func TestChildUsageConcurrent(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Mode:        "graduated",
+		Aggregate:   "sum",
+		Tiers:       []Tier{{Upto: 100}},
+	}}
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	for _, org := range []string{"org:parent", "org:child"} {
+		if err := tc.PutCustomer(ctx, org, &OrgInfo{Email: "a@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tc.SubscribeTo(ctx, "org:parent", []refs.FeaturePlan{mpf("feature:x@plan:pro@0")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SetParent(ctx, "org:child", "org:parent"); err != nil {
+		t.Fatal(err)
+	}
+
+	var g errgroup.Group
+	for i := 0; i < childUsageAttempts; i++ {
+		g.Go(func() error {
+			return tc.ReportUsage(ctx, "org:child", mpn("feature:x"), Report{N: 1})
+		})
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := tc.LookupLimits(ctx, "org:parent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Fatalf("got %d limits; want 1", len(got))
+	}
+	diff.Test(t, t.Errorf, got[0].ByOrg, map[string]int{"org:child": childUsageAttempts})
+}
@@ -0,0 +1,118 @@
+package control
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"tier.run/refs"
+	"tier.run/stripe"
+)
+
+// A PushRecord describes a push made by Push or PushWithOptions, as
+// recorded in Stripe. Pushes that neither created nor failed to create any
+// features are not recorded.
+type PushRecord struct {
+	ID     string    // the ID of the Stripe product holding the record
+	At     time.Time // when the push finished
+	Author string    // who pushed, as set in PushOptions.Author
+
+	// Plans lists the plans with features created by the push. Plans
+	// are omitted if too many to fit in Stripe metadata.
+	Plans []refs.Plan
+
+	// Created, Skipped, and Failed count the features reported by the
+	// push with each PushProgress status.
+	Created, Skipped, Failed int
+
+	// RolledBack reports whether the features created were archived
+	// because of a failure. See PushOptions.Rollback.
+	RolledBack bool
+}
+
+// PushHistory returns the record of each push made to the Stripe account,
+// newest first.
+func (c *Client) PushHistory(ctx context.Context) ([]PushRecord, error) {
+	type T struct {
+		stripe.ID
+		Created  int64
+		Metadata struct {
+			Push       string `json:"tier.push"`
+			Author     string `json:"tier.push_author"`
+			Plans      string `json:"tier.push_plans"`
+			Created    int    `json:"tier.push_created,string"`
+			Skipped    int    `json:"tier.push_skipped,string"`
+			Failed     int    `json:"tier.push_failed,string"`
+			RolledBack bool   `json:"tier.push_rolled_back,string"`
+		}
+	}
+
+	// Records are held by inactive products, like plans, to keep them
+	// out of the way in the Stripe dashboard.
+	var f stripe.Form
+	f.Set("active", false)
+	var rs []PushRecord
+	err := stripe.Iter(ctx, c.Stripe, "GET", "/v1/products", f, func(p T) bool {
+		if p.Metadata.Push == "" {
+			return true
+		}
+		r := PushRecord{
+			ID:         p.ProviderID(),
+			At:         time.Unix(p.Created, 0),
+			Author:     p.Metadata.Author,
+			Created:    p.Metadata.Created,
+			Skipped:    p.Metadata.Skipped,
+			Failed:     p.Metadata.Failed,
+			RolledBack: p.Metadata.RolledBack,
+		}
+		if p.Metadata.Plans != "" {
+			for _, s := range strings.Split(p.Metadata.Plans, ",") {
+				if plan, err := refs.ParsePlan(s); err == nil {
+					r.Plans = append(r.Plans, plan)
+				}
+			}
+		}
+		rs = append(rs, r)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return rs, nil
+}
+
+// recordPush records r in Stripe for PushHistory, and sets its ID.
+func (c *Client) recordPush(ctx context.Context, r *PushRecord) error {
+	slices.SortFunc(r.Plans, func(a, b refs.Plan) bool {
+		return a.String() < b.String()
+	})
+	var plans string
+	for _, p := range r.Plans {
+		s := p.String()
+		if plans != "" {
+			s = "," + s
+		}
+		if len(plans)+len(s) > stripe.MaxMetadataValueLen {
+			break
+		}
+		plans += s
+	}
+
+	var f stripe.Form
+	f.Set("name", "tier push")
+	f.Set("active", false)
+	f.Set("metadata", "tier.push", 1)
+	f.Set("metadata", "tier.push_author", r.Author)
+	f.Set("metadata", "tier.push_plans", plans)
+	f.Set("metadata", "tier.push_created", r.Created)
+	f.Set("metadata", "tier.push_skipped", r.Skipped)
+	f.Set("metadata", "tier.push_failed", r.Failed)
+	f.Set("metadata", "tier.push_rolled_back", r.RolledBack)
+	var p stripe.JustID
+	if err := c.Stripe.Do(ctx, "POST", "/v1/products", f, &p); err != nil {
+		return err
+	}
+	r.ID = p.ProviderID()
+	return nil
+}
@@ -0,0 +1,57 @@
+package control
+
+import (
+	"context"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/refs"
+)
+
+func TestPushHistory(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	f := func(s, interval string) Feature {
+		return Feature{FeaturePlan: mpf(s), Interval: interval, Currency: "usd"}
+	}
+	push := func(opts PushOptions, fs ...Feature) {
+		t.Helper()
+		opts.Concurrency = 1
+		_ = tc.PushWithOptions(ctx, fs, opts)
+	}
+
+	push(PushOptions{Author: "ops@example.com"},
+		f("feature:x@plan:pro@1", "@monthly"),
+		f("feature:y@plan:pro@1", "@monthly"),
+		f("feature:x@plan:free@1", "@monthly"),
+	)
+	push(PushOptions{}, f("feature:x@plan:pro@1", "@monthly")) // not recorded
+	push(PushOptions{Rollback: true},
+		f("feature:x@plan:pro@2", "@monthly"),
+		f("feature:y@plan:pro@2", "@hourly"), // fails in Stripe
+	)
+
+	got, err := tc.PushHistory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range got {
+		if r.ID == "" || r.At.IsZero() {
+			t.Errorf("record missing ID or time: %+v", r)
+		}
+	}
+	diff.Test(t, t.Errorf, got, []PushRecord{
+		{
+			Plans:      []refs.Plan{refs.MustParsePlan("plan:pro@2")},
+			Created:    1,
+			Failed:     1,
+			RolledBack: true,
+		},
+		{
+			Author:  "ops@example.com",
+			Plans:   []refs.Plan{refs.MustParsePlan("plan:free@1"), refs.MustParsePlan("plan:pro@1")},
+			Created: 3,
+		},
+	}, diff.ZeroFields[PushRecord]("ID", "At"))
+}
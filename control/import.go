@@ -0,0 +1,268 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"tier.run/refs"
+	"tier.run/stripe"
+	"tier.run/values"
+)
+
+// An ImportMapping maps the IDs of Stripe prices made outside of tier to
+// the features Import adopts them as, such as "price_1MhX..." to
+// "feature:seats@plan:pro@1".
+type ImportMapping map[string]refs.FeaturePlan
+
+// Import adopts the existing Stripe prices in m as the features they are
+// mapped to, by setting the lookup keys and metadata Push would have set.
+// Prices keep their products, amounts, and subscriptions, so orgs already
+// subscribed to them may be looked up, reported, and rescheduled as if the
+// features had been pushed. It returns the imported features.
+//
+// As with Push, all features in a plan must be imported in one call, and
+// Import returns ErrPlanExists if a plan in m has already been pushed or
+// imported. Nothing is imported if any price is already a feature, in
+// which case an error wrapping ErrFeatureExists is returned, or if the
+// prices do not make valid features, such as prices in the same plan with
+// differing currencies, in which case a *ValidationError is returned.
+//
+// Only prices billed once, or every day, week, month, or year are
+// supported, and usage based prices must be tiered or in packages.
+func (c *Client) Import(ctx context.Context, m ImportMapping) ([]Feature, error) {
+	ids := maps.Keys(m)
+	slices.Sort(ids)
+
+	var fs []Feature
+	for _, id := range ids {
+		var f stripe.Form
+		f.Expand("tiers")
+		var p stripePrice
+		if err := c.Stripe.Do(ctx, "GET", "/v1/prices/"+id, f, &p); err != nil {
+			return nil, err
+		}
+		if fp := p.Metadata.Feature; !fp.IsZero() {
+			return nil, fmt.Errorf("%w: price %s is %s", ErrFeatureExists, id, fp)
+		}
+		if err := checkImportable(p); err != nil {
+			return nil, err
+		}
+		feature := stripePriceToFeature(p)
+		feature.FeaturePlan = m[id]
+		feature.Variant = false
+		fs = append(fs, feature)
+	}
+
+	for _, d := range Validate(fs) {
+		if d.Severity == SeverityError {
+			return nil, &ValidationError{Message: fmt.Sprintf("%s: %s", d.Feature, d.Message)}
+		}
+	}
+
+	// Stripe rejects lookup keys already in use, but check them all
+	// before changing any price.
+	var f stripe.Form
+	for _, feature := range fs {
+		f.Add("lookup_keys[]", feature.ID())
+	}
+	pushed, err := stripe.Slurp[stripePrice](ctx, c.Stripe, "GET", "/v1/prices", f)
+	if err != nil {
+		return nil, err
+	}
+	if len(pushed) > 0 {
+		return nil, fmt.Errorf("%w: %s", ErrFeatureExists, pushed[0].Metadata.Feature)
+	}
+
+	plans := refs.GroupByPlan(FeaturePlans(fs))
+	for _, p := range maps.Keys(plans) {
+		if err := c.pushSentinelPlan(ctx, p, nil); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, feature := range fs {
+		c.Logf("tier: importing price %q as feature %q", feature.ProviderID, feature.ID())
+		var f stripe.Form
+		f.Set("lookup_key", feature.ID())
+		for k, v := range priceMetadata(feature) {
+			f.Set("metadata", k, v)
+		}
+		if err := c.Stripe.Do(ctx, "POST", "/v1/prices/"+feature.ProviderID, f, nil); err != nil {
+			return nil, err
+		}
+	}
+	return fs, nil
+}
+
+// checkImportable reports a *ValidationError if p is billed in a way no
+// feature pushed by Push is.
+func checkImportable(p stripePrice) error {
+	invalid := func(format string, args ...any) error {
+		return &ValidationError{Message: fmt.Sprintf("price %s: ", p.ProviderID()) + fmt.Sprintf(format, args...)}
+	}
+	if p.Type == "one_time" {
+		return nil
+	}
+	if n := p.Recurring.IntervalCount; n != 1 {
+		return invalid("interval count %d is not supported; must be 1", n)
+	}
+	metered := p.Recurring.UsageType == "metered"
+	if metered != (len(p.Tiers) > 0 || p.TransformQuantity.DivideBy > 0) {
+		return invalid("usage based prices must be tiered or in packages, and only they may be")
+	}
+	return nil
+}
+
+// An ImportProposal proposes importing an active Stripe price made outside
+// of tier as a feature, as returned by ProposeImport.
+type ImportProposal struct {
+	Product  string // the name of the price's product
+	Nickname string // the nickname of the price, if any
+
+	// Feature is the feature the price would be imported as, with the ID
+	// of the price as its ProviderID. Its FeaturePlan is zero if Problem
+	// is set.
+	Feature Feature
+
+	// Problem, if not empty, is why the price cannot be imported.
+	Problem string
+}
+
+// ProposeImport proposes importing each active Stripe price made outside
+// of tier as a feature of a plan named for its product, and named for its
+// nickname, or else its product, such as "feature:seats@plan:pro@0" for a
+// price nicknamed "Seats" of the product "Pro". Names keep only the letters
+// and digits of the names they are made from, lowercased.
+//
+// Since the features of a plan must share a currency and interval, the
+// prices of a product billed in more than one are proposed in a plan for
+// each, suffixed with the interval or currency, such as
+// "plan:pro:yearly@0". Features that would share a name are suffixed with
+// a number.
+//
+// Proposals are ordered by product name, then by when their prices were
+// created. Prices that cannot be imported are proposed with a Problem.
+func (c *Client) ProposeImport(ctx context.Context) ([]ImportProposal, error) {
+	type T struct {
+		stripePrice
+		Nickname string
+		Product  struct {
+			Name string
+		}
+	}
+	var f stripe.Form
+	f.Set("active", true)
+	f.Expand("data.tiers")
+	f.Expand("data.product")
+	var prices []T
+	err := stripe.Iter(ctx, c.Stripe, "GET", "/v1/prices", f, func(p T) bool {
+		if p.Metadata.Feature.IsZero() {
+			prices = append(prices, p)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	// Stripe lists prices newest first.
+	for i, j := 0, len(prices)-1; i < j; i, j = i+1, j-1 {
+		prices[i], prices[j] = prices[j], prices[i]
+	}
+	slices.SortStableFunc(prices, func(a, b T) bool {
+		return a.Product.Name < b.Product.Name
+	})
+
+	type group struct{ currency, interval string }
+	groups := map[string]map[group]bool{} // by product
+	for _, p := range prices {
+		if groups[p.Product.Name] == nil {
+			groups[p.Product.Name] = map[group]bool{}
+		}
+		groups[p.Product.Name][group{p.Currency, importInterval(p.stripePrice)}] = true
+	}
+
+	var ps []ImportProposal
+	taken := map[string]bool{} // feature plans proposed
+	for _, p := range prices {
+		ip := ImportProposal{
+			Product:  p.Product.Name,
+			Nickname: p.Nickname,
+			Feature:  stripePriceToFeature(p.stripePrice),
+		}
+		ip.Feature.Variant = false
+		if err := checkImportable(p.stripePrice); err != nil {
+			ip.Problem = err.(*ValidationError).Message
+			ps = append(ps, ip)
+			continue
+		}
+
+		plan := values.Coalesce(importName(p.Product.Name), "imported")
+		var currencies, intervals []string
+		for g := range groups[p.Product.Name] {
+			if !slices.Contains(currencies, g.currency) {
+				currencies = append(currencies, g.currency)
+			}
+			if !slices.Contains(intervals, g.interval) {
+				intervals = append(intervals, g.interval)
+			}
+		}
+		if len(intervals) > 1 {
+			plan += ":" + importInterval(p.stripePrice)
+		}
+		if len(currencies) > 1 {
+			plan += ":" + p.Currency
+		}
+		name := values.Coalesce(importName(p.Nickname), importName(p.Product.Name), "price")
+		id := "feature:" + name + "@plan:" + plan + "@0"
+		for i := 2; taken[id]; i++ {
+			id = "feature:" + name + strconv.Itoa(i) + "@plan:" + plan + "@0"
+		}
+		fp, err := refs.ParseFeaturePlan(id)
+		if err != nil {
+			ip.Problem = err.Error()
+			ps = append(ps, ip)
+			continue
+		}
+		taken[id] = true
+		ip.Feature.FeaturePlan = fp
+		ps = append(ps, ip)
+	}
+	return ps, nil
+}
+
+// ProposedMapping returns the mapping of the prices in ps to the features
+// they are proposed as, skipping those with problems.
+func ProposedMapping(ps []ImportProposal) ImportMapping {
+	m := ImportMapping{}
+	for _, p := range ps {
+		if p.Problem == "" {
+			m[p.Feature.ProviderID] = p.Feature.FeaturePlan
+		}
+	}
+	return m
+}
+
+// importInterval returns the name of the interval p is billed at, for
+// suffixing plan names: "daily", "weekly", "monthly", "yearly", or "once".
+func importInterval(p stripePrice) string {
+	if p.Type == "one_time" {
+		return "once"
+	}
+	return strings.TrimPrefix(intervalFromStripe[p.Recurring.Interval], "@")
+}
+
+// importName returns the letters and digits of s, lowercased, for use in
+// feature and plan names.
+func importName(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,208 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/exp/slices"
+	"kr.dev/diff"
+	"tier.run/refs"
+	"tier.run/stripe"
+	"tier.run/values"
+)
+
+func TestImport(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	// createPrice creates a price the way it might have been made before
+	// migrating to tier.
+	createPrice := func(name string, f stripe.Form) string {
+		t.Helper()
+		f.Set("product_data", "name", name)
+		f.Set("currency", "usd")
+		var p stripe.JustID
+		if err := tc.Stripe.Do(ctx, "POST", "/v1/prices", f, &p); err != nil {
+			t.Fatal(err)
+		}
+		return p.ProviderID()
+	}
+
+	var seats stripe.Form
+	seats.Set("unit_amount", 1000)
+	seats.Set("recurring", "interval", "month")
+	seatsID := createPrice("Seats", seats)
+
+	var calls stripe.Form
+	calls.Set("billing_scheme", "tiered")
+	calls.Set("tiers_mode", "graduated")
+	calls.Set("tiers", 0, "up_to", 10)
+	calls.Set("tiers", 0, "unit_amount", 0)
+	calls.Set("tiers", 1, "up_to", "inf")
+	calls.Set("tiers", 1, "unit_amount", 2)
+	calls.Set("recurring", "interval", "month")
+	calls.Set("recurring", "usage_type", "metered")
+	callsID := createPrice("Calls", calls)
+
+	var weekly stripe.Form
+	weekly.Set("unit_amount", 100)
+	weekly.Set("recurring", "interval", "week")
+	weekly.Set("recurring", "interval_count", 2)
+	weeklyID := createPrice("Fortnightly", weekly)
+
+	_, err := tc.Import(ctx, ImportMapping{weeklyID: mpf("feature:x@plan:legacy@0")})
+	var ve *ValidationError
+	if !errors.As(err, &ve) {
+		t.Errorf("err = %v; want *ValidationError", err)
+	}
+
+	m := ImportMapping{
+		seatsID: mpf("feature:seats@plan:legacy@0"),
+		callsID: mpf("feature:calls@plan:legacy@0"),
+	}
+	imported, err := tc.Import(ctx, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Feature{{
+		FeaturePlan: mpf("feature:calls@plan:legacy@0"),
+		ProviderID:  callsID,
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Mode:        "graduated",
+		Aggregate:   "sum",
+		Tiers:       []Tier{{Upto: 10}, {Upto: Inf, Price: values.MustParseDecimal("2")}},
+	}, {
+		FeaturePlan: mpf("feature:seats@plan:legacy@0"),
+		ProviderID:  seatsID,
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Base:        1000,
+	}}
+	sortFeatures := func(fs []Feature) {
+		slices.SortFunc(fs, func(a, b Feature) bool {
+			return a.Less(b.FeaturePlan)
+		})
+	}
+	sortFeatures(imported)
+	diff.Test(t, t.Errorf, imported, want)
+
+	pulled, err := tc.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sortFeatures(pulled)
+	diff.Test(t, t.Errorf, pulled, want)
+
+	// Imported features may be subscribed to like pushed ones.
+	if err := tc.SubscribeTo(ctx, "org:example", FeaturePlans(want)); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = tc.Import(ctx, m)
+	if !errors.Is(err, ErrFeatureExists) {
+		t.Errorf("err = %v; want ErrFeatureExists", err)
+	}
+	_, err = tc.Import(ctx, ImportMapping{
+		createPrice("Other", seats): mpf("feature:other@plan:legacy@0"),
+	})
+	if !errors.Is(err, ErrPlanExists) {
+		t.Errorf("err = %v; want ErrPlanExists", err)
+	}
+
+	if err := tc.Archive(ctx, refs.MustParsePlan("plan:legacy@0")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestProposeImport(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	createPrice := func(product, nickname, interval, currency string, amount int) string {
+		t.Helper()
+		var f stripe.Form
+		id := "prod_" + importName(product)
+		if err := tc.Stripe.Do(ctx, "GET", "/v1/products/"+id, f, nil); err != nil {
+			f.Set("product_data", "id", id)
+			f.Set("product_data", "name", product)
+		} else {
+			f.Set("product", id)
+		}
+		if nickname != "" {
+			f.Set("nickname", nickname)
+		}
+		f.Set("currency", currency)
+		f.Set("unit_amount", amount)
+		if interval != "" {
+			f.Set("recurring", "interval", interval)
+		}
+		var p stripe.JustID
+		if err := tc.Stripe.Do(ctx, "POST", "/v1/prices", f, &p); err != nil {
+			t.Fatal(err)
+		}
+		return p.ProviderID()
+	}
+	createPrice("Pro", "Seats", "month", "usd", 1000)
+	createPrice("Pro", "Seats", "year", "usd", 10000)
+	createPrice("Pro", "Setup Fee", "", "usd", 5000)
+	createPrice("Pro", "Seats", "month", "usd", 1200)
+	createPrice("Starter Plan", "", "month", "usd", 500)
+	createPrice("Starter Plan", "", "month", "eur", 500)
+
+	var biweekly stripe.Form
+	biweekly.Set("product_data", "name", "Biweekly")
+	biweekly.Set("currency", "usd")
+	biweekly.Set("unit_amount", 100)
+	biweekly.Set("recurring", "interval", "week")
+	biweekly.Set("recurring", "interval_count", 2)
+	if err := tc.Stripe.Do(ctx, "POST", "/v1/prices", biweekly, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	pushed := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:pushed@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Base:        100,
+	}}
+	if err := tc.Push(ctx, pushed, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+
+	ps, err := tc.ProposeImport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range ps {
+		got = append(got, fmt.Sprintf("%s %q: %v %d%s %s", p.Product, p.Nickname, p.Feature.FeaturePlan, p.Feature.Base, p.Feature.Currency, p.Problem))
+	}
+	diff.Test(t, t.Errorf, got, []string{
+		`Biweekly "": feature:@plan:@ 100usd price ` + ps[0].Feature.ProviderID + `: interval count 2 is not supported; must be 1`,
+		`Pro "Seats": feature:seats@plan:pro:monthly@0 1000usd `,
+		`Pro "Seats": feature:seats@plan:pro:yearly@0 10000usd `,
+		`Pro "Setup Fee": feature:setupfee@plan:pro:once@0 5000usd `,
+		`Pro "Seats": feature:seats2@plan:pro:monthly@0 1200usd `,
+		`Starter Plan "": feature:starterplan@plan:starterplan:usd@0 500usd `,
+		`Starter Plan "": feature:starterplan@plan:starterplan:eur@0 500eur `,
+	})
+
+	m := ProposedMapping(ps)
+	if len(m) != 6 {
+		t.Fatalf("mapping = %v; want 6 prices", m)
+	}
+	if _, err := tc.Import(ctx, m); err != nil {
+		t.Fatal(err)
+	}
+	ps, err = tc.ProposeImport(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 1 || ps[0].Problem == "" {
+		t.Errorf("after import, proposals = %+v; want only the biweekly price", ps)
+	}
+}
@@ -0,0 +1,152 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"tier.run/stripe"
+)
+
+// Invoice statuses, as reported by Stripe.
+const (
+	InvoiceDraft         = "draft"
+	InvoiceOpen          = "open"
+	InvoicePaid          = "paid"
+	InvoiceUncollectible = "uncollectible"
+	InvoiceVoid          = "void"
+)
+
+// Errors
+var (
+	ErrInvoiceNotFound = errors.New("invoice not found")
+	ErrInvoiceStatus   = errors.New("invoice status does not allow operation")
+)
+
+// An Invoice is an invoice billed to an org.
+type Invoice struct {
+	ID        string
+	Status    string // one of the Invoice constants
+	Currency  string
+	Total     int // in the smallest currency unit
+	AmountDue int // the part of Total not yet paid or credited
+	Created   time.Time
+
+	// URL is the page on which the org may view and pay the invoice. It
+	// is empty for drafts.
+	URL string
+}
+
+type stripeInvoice struct {
+	stripe.ID
+	Customer  string
+	Status    string
+	Currency  string
+	Total     int
+	AmountDue int `json:"amount_due"`
+	Created   int64
+	URL       string `json:"hosted_invoice_url"`
+	Charge    string // the charge paying the invoice, if paid
+}
+
+func (in stripeInvoice) invoice() Invoice {
+	return Invoice{
+		ID:        in.ProviderID(),
+		Status:    in.Status,
+		Currency:  in.Currency,
+		Total:     in.Total,
+		AmountDue: in.AmountDue,
+		Created:   time.Unix(in.Created, 0),
+		URL:       in.URL,
+	}
+}
+
+// LookupInvoices returns the invoices of org, newest first.
+func (c *Client) LookupInvoices(ctx context.Context, org string) ([]Invoice, error) {
+	cid, err := c.WhoIs(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+	var f stripe.Form
+	f.Set("customer", cid)
+	var ins []Invoice
+	err = stripe.Iter(ctx, c.Stripe, "GET", "/v1/invoices", f, func(in stripeInvoice) bool {
+		ins = append(ins, in.invoice())
+		return true
+	})
+	return ins, err
+}
+
+// FinalizeInvoice finalizes the draft invoice id of org, so that it may be
+// paid, and stops Stripe from finalizing it automatically later.
+func (c *Client) FinalizeInvoice(ctx context.Context, org, id string) (Invoice, error) {
+	return c.moveInvoice(ctx, AuditFinalizeInvoice, org, id, "finalize", InvoiceOpen, InvoiceDraft)
+}
+
+// VoidInvoice voids the open or uncollectible invoice id of org, such as
+// one billed in error. Voided invoices are kept for records, but can no
+// longer be paid.
+func (c *Client) VoidInvoice(ctx context.Context, org, id string) (Invoice, error) {
+	return c.moveInvoice(ctx, AuditVoidInvoice, org, id, "void", InvoiceVoid, InvoiceOpen, InvoiceUncollectible)
+}
+
+// MarkInvoiceUncollectible marks the open invoice id of org as not expected
+// to be paid, such as for bad debt. Unlike voided invoices, uncollectible
+// invoices may still be paid.
+func (c *Client) MarkInvoiceUncollectible(ctx context.Context, org, id string) (Invoice, error) {
+	return c.moveInvoice(ctx, AuditMarkUncollectible, org, id, "mark_uncollectible", InvoiceUncollectible, InvoiceOpen)
+}
+
+// moveInvoice applies the Stripe invoice action to the invoice id of org,
+// which must have one of the statuses from, so that it has the status to.
+//
+// It reports ErrInvoiceNotFound if the invoice does not belong to org, so
+// that orgs cannot be used to reach the invoices of others, and
+// ErrInvoiceStatus if the invoice has none of the statuses from. Since
+// moving an invoice cannot be undone, it reports stripe.ErrLiveModeGuard
+// if the client may not make such changes in live mode.
+func (c *Client) moveInvoice(ctx context.Context, op, org, id, action, to string, from ...string) (_ Invoice, err error) {
+	if err := c.Stripe.GuardLive(); err != nil {
+		return Invoice{}, err
+	}
+	cid, err := c.WhoIs(ctx, org)
+	if err != nil {
+		return Invoice{}, err
+	}
+	in, err := c.lookupInvoice(ctx, cid, id)
+	if err != nil {
+		return Invoice{}, err
+	}
+
+	type state struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	before := state{id, in.Status}
+	defer c.audit(ctx, op, org, func() (any, error) { return before, nil }, state{id, to}, &err)()
+
+	if !slices.Contains(from, in.Status) {
+		return Invoice{}, fmt.Errorf("%w: cannot %s %s invoice %s", ErrInvoiceStatus, action, in.Status, id)
+	}
+	var f stripe.Form
+	err = c.Stripe.Do(ctx, "POST", "/v1/invoices/"+url.PathEscape(id)+"/"+action, f, &in)
+	if err != nil {
+		return Invoice{}, err
+	}
+	return in.invoice(), nil
+}
+
+// lookupInvoice returns the invoice id of the customer cid. It reports
+// ErrInvoiceNotFound if the invoice is billed to another customer.
+func (c *Client) lookupInvoice(ctx context.Context, cid, id string) (stripeInvoice, error) {
+	var in stripeInvoice
+	var f stripe.Form
+	err := c.Stripe.Do(ctx, "GET", "/v1/invoices/"+url.PathEscape(id), f, &in)
+	if isMissing(err) || err == nil && in.Customer != cid {
+		return stripeInvoice{}, fmt.Errorf("%w: %s", ErrInvoiceNotFound, id)
+	}
+	return in, err
+}
@@ -0,0 +1,179 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/refs"
+	"tier.run/stripe"
+)
+
+func TestInvoices(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	for _, org := range []string{"org:a", "org:b"} {
+		if err := tc.PutCustomer(ctx, org, &OrgInfo{Email: "a@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cid, err := tc.WhoIs(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, second := newDraft(t, tc, cid, 1000), newDraft(t, tc, cid, 1000)
+
+	var log MemoryAuditLog
+	tc.Audit = &log
+
+	if _, err := tc.FinalizeInvoice(ctx, "org:b", first); !errors.Is(err, ErrInvoiceNotFound) {
+		t.Errorf("finalizing invoice of other org: err = %v; want %v", err, ErrInvoiceNotFound)
+	}
+	if _, err := tc.FinalizeInvoice(ctx, "org:a", "in_missing"); !errors.Is(err, ErrInvoiceNotFound) {
+		t.Errorf("finalizing missing invoice: err = %v; want %v", err, ErrInvoiceNotFound)
+	}
+	if _, err := tc.VoidInvoice(ctx, "org:a", first); !errors.Is(err, ErrInvoiceStatus) {
+		t.Errorf("voiding draft: err = %v; want %v", err, ErrInvoiceStatus)
+	}
+
+	in, err := tc.FinalizeInvoice(ctx, "org:a", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if in.Status != InvoiceOpen || in.Total != 1000 || in.URL == "" {
+		t.Errorf("finalized invoice = %+v; want open for 1000 with URL", in)
+	}
+	if _, err := tc.FinalizeInvoice(ctx, "org:a", first); !errors.Is(err, ErrInvoiceStatus) {
+		t.Errorf("finalizing again: err = %v; want %v", err, ErrInvoiceStatus)
+	}
+	if _, err := tc.MarkInvoiceUncollectible(ctx, "org:a", first); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.VoidInvoice(ctx, "org:a", first); err != nil {
+		t.Fatal(err)
+	}
+
+	ins, err := tc.LookupInvoices(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	type T struct{ ID, Status string }
+	var got []T
+	for _, in := range ins {
+		got = append(got, T{in.ID, in.Status})
+	}
+	diff.Test(t, t.Errorf, got, []T{
+		{second, InvoiceDraft},
+		{first, InvoiceVoid},
+	})
+	if ins, err := tc.LookupInvoices(ctx, "org:b"); err != nil || len(ins) != 0 {
+		t.Errorf("invoices of org:b = %v, %v; want none", ins, err)
+	}
+
+	es, err := log.List(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ops []string
+	for _, e := range es {
+		if e.Error == "" {
+			ops = append(ops, e.Op+" "+string(e.Before)+" "+string(e.After))
+		}
+	}
+	diff.Test(t, t.Errorf, ops, []string{
+		AuditVoidInvoice + ` {"id":"` + first + `","status":"uncollectible"} {"id":"` + first + `","status":"void"}`,
+		AuditMarkUncollectible + ` {"id":"` + first + `","status":"open"} {"id":"` + first + `","status":"uncollectible"}`,
+		AuditFinalizeInvoice + ` {"id":"` + first + `","status":"draft"} {"id":"` + first + `","status":"open"}`,
+	})
+}
+
+// newDraft creates a draft invoice of amount for the customer cid, and
+// returns its ID.
+func newDraft(t *testing.T, tc *Client, cid string, amount int) string {
+	t.Helper()
+	ctx := context.Background()
+	var f stripe.Form
+	f.Set("customer", cid)
+	var in struct{ stripe.ID }
+	if err := tc.Stripe.Do(ctx, "POST", "/v1/invoices", f, &in); err != nil {
+		t.Fatal(err)
+	}
+	// Invoices with nothing due are paid when finalized, so add an item
+	// to keep them open.
+	f = stripe.Form{}
+	f.Set("customer", cid)
+	f.Set("invoice", in.ProviderID())
+	f.Set("amount", amount)
+	f.Set("currency", "usd")
+	if err := tc.Stripe.Do(ctx, "POST", "/v1/invoiceitems", f, nil); err != nil {
+		t.Fatal(err)
+	}
+	return in.ProviderID()
+}
+
+func TestLookupOrgBillingState(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Base:        1000,
+	}}
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.PutCustomer(ctx, "org:a", &OrgInfo{Email: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	check := func(status string, delinquent, hasPM bool) {
+		t.Helper()
+		info, err := tc.LookupOrg(ctx, "org:a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.SubscriptionStatus != status || info.Delinquent != delinquent || info.HasPaymentMethod != hasPM {
+			t.Errorf("status, delinquent, has payment method = %q, %v, %v; want %q, %v, %v",
+				info.SubscriptionStatus, info.Delinquent, info.HasPaymentMethod,
+				status, delinquent, hasPM)
+		}
+		if (info.PeriodEnd != nil) != (status != "") {
+			t.Errorf("PeriodEnd = %v; want set only with a subscription", info.PeriodEnd)
+		}
+	}
+
+	check("", false, false)
+	if err := tc.SubscribeTo(ctx, "org:a", []refs.FeaturePlan{mpf("feature:x@plan:pro@0")}); err != nil {
+		t.Fatal(err)
+	}
+	check("active", false, false)
+
+	cid, err := tc.WhoIs(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := newDraft(t, tc, cid, 1000)
+	if _, err := tc.FinalizeInvoice(ctx, "org:a", id); err != nil {
+		t.Fatal(err)
+	}
+	var f stripe.Form
+	f.Set("payment_method", "pm_card_chargeDeclined")
+	if err := tc.Stripe.Do(ctx, "POST", "/v1/invoices/"+id+"/pay", f, nil); err == nil {
+		t.Fatal("paying with declined card: err = nil; want error")
+	}
+	check("active", true, false)
+
+	f = stripe.Form{}
+	f.Set("invoice_settings[default_payment_method]", "pm_card_visa")
+	if err := tc.Stripe.Do(ctx, "POST", "/v1/customers/"+cid, f, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Stripe.Do(ctx, "POST", "/v1/invoices/"+id+"/pay", stripe.Form{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	check("active", false, true)
+}
@@ -0,0 +1,127 @@
+package control
+
+import (
+	"context"
+	"errors"
+
+	"tier.run/stripe"
+)
+
+// Errors
+var (
+	ErrNoSubscription = errors.New("org has no subscription")
+)
+
+// subscriptionState is the state of the subscription of an org recorded in
+// the audit log by Cancel, Pause, and Resume.
+type subscriptionState struct {
+	Status            string `json:"status"`
+	CancelAtPeriodEnd bool   `json:"cancel_at_period_end,omitempty"`
+	Paused            bool   `json:"paused,omitempty"`
+}
+
+// lookupSubscriptionState returns the subscription of org and its state.
+// It reports ErrNoSubscription if org has none, or it is canceled.
+func (c *Client) lookupSubscriptionState(ctx context.Context, org string) (subscription, subscriptionState, error) {
+	s, err := c.lookupSubscription(ctx, org, scheduleNameTODO)
+	if errors.Is(err, stripe.ErrNotFound) {
+		return subscription{}, subscriptionState{}, ErrNoSubscription
+	}
+	if err != nil {
+		return subscription{}, subscriptionState{}, err
+	}
+	return s, subscriptionState{s.Status, s.CancelAtPeriodEnd, s.Paused}, nil
+}
+
+// Cancel cancels the subscription of org. If atPeriodEnd is false, the
+// subscription is canceled immediately, along with its scheduled phases;
+// otherwise the org keeps its current phase until the end of its current
+// billing period, and no later phases take effect. Scheduling the org again
+// before then, such as with SubscribeTo, resumes the subscription.
+//
+// It reports ErrNoSubscription if org has no subscription to cancel, and
+// stripe.ErrLiveModeGuard if atPeriodEnd is false and the client may not
+// make such changes in live mode.
+func (c *Client) Cancel(ctx context.Context, org string, atPeriodEnd bool) (err error) {
+	if !atPeriodEnd {
+		if err := c.Stripe.GuardLive(); err != nil {
+			return err
+		}
+	}
+	s, before, err := c.lookupSubscriptionState(ctx, org)
+	if err != nil {
+		return err
+	}
+	after := before
+	if atPeriodEnd {
+		after.CancelAtPeriodEnd = true
+	} else {
+		after.Status = "canceled"
+	}
+	defer c.audit(ctx, AuditCancel, org, func() (any, error) {
+		return before, nil
+	}, after, &err)()
+
+	if !atPeriodEnd {
+		if s.ScheduleID != "" {
+			return c.Stripe.Do(ctx, "POST", "/v1/subscription_schedules/"+s.ScheduleID+"/cancel", stripe.Form{}, nil)
+		}
+		return c.Stripe.Do(ctx, "POST", "/v1/subscriptions/"+s.ID+"/cancel", stripe.Form{}, nil)
+	}
+	if s.ScheduleID != "" {
+		// Stripe does not allow canceling subscriptions managed by a
+		// schedule at period end, so release it from the schedule,
+		// dropping its later phases.
+		if err := c.Stripe.Do(ctx, "POST", "/v1/subscription_schedules/"+s.ScheduleID+"/release", stripe.Form{}, nil); err != nil {
+			return err
+		}
+	}
+	return c.setCancelAtPeriodEnd(ctx, s.ID, scheduleNameTODO, true)
+}
+
+// setCancelAtPeriodEnd sets whether the subscription id, released from its
+// schedule named name, is canceled at period end. The name is kept in the
+// metadata of the subscription so lookupSubscription still finds it.
+func (c *Client) setCancelAtPeriodEnd(ctx context.Context, id, name string, cancel bool) error {
+	var f stripe.Form
+	f.Set("cancel_at_period_end", cancel)
+	f.Set("metadata[tier.subscription]", name)
+	return c.Stripe.Do(ctx, "POST", "/v1/subscriptions/"+id, f, nil)
+}
+
+// Pause pauses collecting payments for the subscription of org. The org
+// keeps its features and usage is still reported, but its invoices are
+// voided until Resume is called.
+//
+// It reports ErrNoSubscription if org has no subscription to pause.
+func (c *Client) Pause(ctx context.Context, org string) error {
+	return c.setPaused(ctx, AuditPause, org, true)
+}
+
+// Resume resumes collecting payments for the subscription of org, paused
+// by Pause. Invoices voided while it was paused are not collected.
+//
+// It reports ErrNoSubscription if org has no subscription to resume.
+func (c *Client) Resume(ctx context.Context, org string) error {
+	return c.setPaused(ctx, AuditResume, org, false)
+}
+
+func (c *Client) setPaused(ctx context.Context, op, org string, paused bool) (err error) {
+	s, before, err := c.lookupSubscriptionState(ctx, org)
+	if err != nil {
+		return err
+	}
+	after := before
+	after.Paused = paused
+	defer c.audit(ctx, op, org, func() (any, error) {
+		return before, nil
+	}, after, &err)()
+
+	var f stripe.Form
+	if paused {
+		f.Set("pause_collection", "behavior", "void")
+	} else {
+		f.Set("pause_collection", "")
+	}
+	return c.Stripe.Do(ctx, "POST", "/v1/subscriptions/"+s.ID, f, nil)
+}
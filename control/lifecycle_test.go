@@ -0,0 +1,201 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+	"tier.run/refs"
+)
+
+func TestCancel(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	t0 := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock, err := tc.CreateClock(ctx, t.Name(), t0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Mode:        "graduated",
+		Aggregate:   "sum",
+		Tiers:       []Tier{{Upto: 100}},
+	}}
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	for _, org := range []string{"org:a", "org:b"} {
+		if err := tc.AttachClock(ctx, org, clock.ID, &OrgInfo{Email: "a@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var log MemoryAuditLog
+	tc.Audit = &log
+
+	if err := tc.Cancel(ctx, "org:missing", false); !errors.Is(err, ErrOrgNotFound) {
+		t.Errorf("canceling unknown org: err = %v; want %v", err, ErrOrgNotFound)
+	}
+	if err := tc.Cancel(ctx, "org:a", false); !errors.Is(err, ErrNoSubscription) {
+		t.Errorf("canceling unsubscribed org: err = %v; want %v", err, ErrNoSubscription)
+	}
+	for _, org := range []string{"org:a", "org:b"} {
+		if err := tc.SubscribeTo(ctx, org, FeaturePlans(fs)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := tc.Cancel(ctx, "org:a", false); err != nil {
+		t.Fatal(err)
+	}
+	info, err := tc.LookupOrg(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.SubscriptionStatus != "" {
+		t.Errorf("SubscriptionStatus = %q; want none", info.SubscriptionStatus)
+	}
+	if ps, err := tc.LookupPhases(ctx, "org:a"); err != nil || len(ps) != 0 {
+		t.Errorf("phases = %+v, %v; want none", ps, err)
+	}
+	if err := tc.Cancel(ctx, "org:a", false); !errors.Is(err, ErrNoSubscription) {
+		t.Errorf("canceling again: err = %v; want %v", err, ErrNoSubscription)
+	}
+
+	if err := tc.Cancel(ctx, "org:b", true); err != nil {
+		t.Fatal(err)
+	}
+	info, err = tc.LookupOrg(ctx, "org:b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.SubscriptionStatus != "active" || !info.CancelAtPeriodEnd {
+		t.Errorf("org = %+v; want active until period end", info)
+	}
+	if err := tc.ReportUsage(ctx, "org:b", mpn("feature:x"), Report{N: 1}); err != nil {
+		t.Errorf("reporting before period end: %v", err)
+	}
+
+	// Updating only the info of the org leaves it canceled.
+	if err := tc.Schedule(ctx, "org:b", &OrgInfo{Metadata: map[string]string{"crm": "42"}}, nil); err != nil {
+		t.Fatal(err)
+	}
+	info, err = tc.LookupOrg(ctx, "org:b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.CancelAtPeriodEnd || info.Metadata["crm"] != "42" {
+		t.Errorf("org = %+v; want crm metadata, still canceled at period end", info)
+	}
+
+	// Scheduling again resumes the subscription.
+	if err := tc.SubscribeTo(ctx, "org:b", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+	info, err = tc.LookupOrg(ctx, "org:b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.CancelAtPeriodEnd {
+		t.Errorf("CancelAtPeriodEnd = true after resubscribing; want false")
+	}
+
+	if err := tc.Cancel(ctx, "org:b", true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.AdvanceClock(ctx, clock.ID, t0.AddDate(0, 1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.WaitClock(ctx, clock.ID); err != nil {
+		t.Fatal(err)
+	}
+	info, err = tc.LookupOrg(ctx, "org:b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.SubscriptionStatus != "" {
+		t.Errorf("SubscriptionStatus = %q after period end; want none", info.SubscriptionStatus)
+	}
+
+	es, err := log.List(ctx, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range es {
+		if e.Op == AuditCancel {
+			got = append(got, e.Org+" "+string(e.Before)+" "+string(e.After))
+		}
+	}
+	diff.Test(t, t.Errorf, got, []string{
+		`org:b {"status":"active"} {"status":"active","cancel_at_period_end":true}`,
+		`org:b {"status":"active"} {"status":"active","cancel_at_period_end":true}`,
+		`org:a {"status":"active"} {"status":"canceled"}`,
+	})
+}
+
+func TestPause(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}}
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.PutCustomer(ctx, "org:a", &OrgInfo{Email: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.Pause(ctx, "org:a"); !errors.Is(err, ErrNoSubscription) {
+		t.Errorf("pausing unsubscribed org: err = %v; want %v", err, ErrNoSubscription)
+	}
+	if err := tc.SubscribeTo(ctx, "org:a", []refs.FeaturePlan{mpf("feature:x@plan:pro@0")}); err != nil {
+		t.Fatal(err)
+	}
+
+	var log MemoryAuditLog
+	tc.Audit = &log
+
+	paused := func() bool {
+		t.Helper()
+		info, err := tc.LookupOrg(ctx, "org:a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Paused
+	}
+	if err := tc.Pause(ctx, "org:a"); err != nil {
+		t.Fatal(err)
+	}
+	if !paused() {
+		t.Errorf("Paused = false after Pause; want true")
+	}
+	if err := tc.Resume(ctx, "org:a"); err != nil {
+		t.Fatal(err)
+	}
+	if paused() {
+		t.Errorf("Paused = true after Resume; want false")
+	}
+
+	es, err := log.List(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range es {
+		got = append(got, e.Op+" "+string(e.Before)+" "+string(e.After))
+	}
+	diff.Test(t, t.Errorf, got, []string{
+		AuditResume + ` {"status":"active","paused":true} {"status":"active"}`,
+		AuditPause + ` {"status":"active"} {"status":"active","paused":true}`,
+	})
+}
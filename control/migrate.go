@@ -0,0 +1,155 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"tier.run/refs"
+)
+
+// Errors
+var (
+	ErrScheduledPhases = errors.New("org has phases scheduled after its current phase")
+)
+
+// MigrateOptions are the options for Migrate.
+type MigrateOptions struct {
+	// AtRenewal, if true, moves each org at the end of its current
+	// billing period, instead of now.
+	AtRenewal bool
+
+	// DryRun, if true, reports the orgs that would be migrated without
+	// migrating them.
+	DryRun bool
+}
+
+// A MigrateResult reports the migration of an org by Migrate.
+type MigrateResult struct {
+	Org string
+
+	// Effective is when the org moves to the new plan, or zero if it
+	// moved now.
+	Effective time.Time
+
+	// Err is the error migrating the org, if any.
+	Err error
+}
+
+// Migrate moves the orgs subscribed to plan from to plan to, calling fn with
+// the result for each, as it goes. In the current phase of each org, the
+// features of from are replaced by those of to, and its other plans,
+// add-ons, interval, and any trial in progress are kept.
+//
+// Orgs with phases scheduled after their current phase are not migrated,
+// so that those phases are not lost, and are reported with
+// ErrScheduledPhases. Orgs that fail to migrate are reported to fn, and
+// Migrate continues with the next. It stops and returns an error only if
+// the orgs cannot be listed, or with ErrPlanNotFound if to has not been
+// pushed, or is archived.
+func (c *Client) Migrate(ctx context.Context, from, to refs.Plan, opts MigrateOptions, fn func(MigrateResult)) error {
+	if from.IsZero() || to.IsZero() {
+		return &ValidationError{Message: "plans to migrate from and to are required"}
+	}
+	if from == to {
+		return &ValidationError{Message: "plans to migrate from and to must differ"}
+	}
+	fs, err := c.PullWithOptions(ctx, PullOptions{Plan: to})
+	if err != nil {
+		return err
+	}
+	var toFeatures []refs.FeaturePlan
+	for _, f := range fs {
+		if !f.Variant {
+			toFeatures = append(toFeatures, f.FeaturePlan)
+		}
+	}
+	if len(toFeatures) == 0 {
+		return ErrPlanNotFound
+	}
+
+	// List the orgs before migrating any, so that changes made to them
+	// do not disturb the listing.
+	var orgs []string
+	err = c.EachOrg(ctx, func(o Org) error {
+		if o.ID != "" {
+			orgs = append(orgs, o.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, org := range orgs {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		r, ok := c.migrate(ctx, org, from, toFeatures, opts)
+		if ok {
+			fn(r)
+		}
+	}
+	return nil
+}
+
+// migrate migrates org from plan from to the features toFeatures, as
+// described by Migrate. It reports false if org is not subscribed to from.
+func (c *Client) migrate(ctx context.Context, org string, from refs.Plan, toFeatures []refs.FeaturePlan, opts MigrateOptions) (MigrateResult, bool) {
+	r := MigrateResult{Org: org}
+	ps, err := c.LookupPhases(ctx, org)
+	if err != nil {
+		r.Err = err
+		return r, true
+	}
+	i := slices.IndexFunc(ps, func(p Phase) bool { return p.Current })
+	if i < 0 || !slices.Contains(ps[i].Plans, from) {
+		return r, false
+	}
+	cur := ps[i]
+	if i < len(ps)-1 {
+		r.Err = ErrScheduledPhases
+		return r, true
+	}
+
+	var features []refs.FeaturePlan
+	for _, f := range cur.Features {
+		if f.Plan() != from {
+			features = append(features, f)
+		}
+	}
+	for _, f := range toFeatures {
+		if !slices.Contains(features, f) {
+			features = append(features, f)
+		}
+	}
+
+	if opts.AtRenewal {
+		info, err := c.LookupOrg(ctx, org)
+		if err != nil {
+			r.Err = err
+			return r, true
+		}
+		if info.PeriodEnd == nil {
+			r.Err = &ValidationError{Message: "org has no current billing period to end"}
+			return r, true
+		}
+		r.Effective = *info.PeriodEnd
+	}
+	if opts.DryRun {
+		return r, true
+	}
+
+	next := Phase{
+		Effective: r.Effective,
+		Features:  features,
+		Interval:  cur.Interval,
+	}
+	phases := []Phase{next}
+	if opts.AtRenewal {
+		phases = []Phase{{Features: cur.Features, Interval: cur.Interval}, next}
+	}
+	r.Err = c.ScheduleNow(ctx, org, nil, phases)
+	return r, true
+}
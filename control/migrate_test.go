@@ -0,0 +1,130 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"golang.org/x/exp/slices"
+	"kr.dev/diff"
+	"tier.run/refs"
+)
+
+func TestMigrate(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	var fs []Feature
+	for _, fp := range []string{
+		"feature:x@plan:pro@0",
+		"feature:y@plan:pro@0",
+		"feature:x@plan:pro@1",
+		"feature:z@plan:pro@1",
+		"feature:x@plan:free@0",
+		"feature:addon@0",
+	} {
+		fs = append(fs, Feature{
+			FeaturePlan: mpf(fp),
+			Interval:    "@monthly",
+			Currency:    "usd",
+		})
+	}
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	clock := tc.setClock(t, t0)
+
+	pro0 := []refs.FeaturePlan{mpf("feature:x@plan:pro@0"), mpf("feature:y@plan:pro@0")}
+	pro1 := []refs.FeaturePlan{mpf("feature:x@plan:pro@1"), mpf("feature:z@plan:pro@1")}
+	for org, fps := range map[string][]refs.FeaturePlan{
+		"org:a": pro0,
+		"org:b": append(slices.Clone(pro0), mpf("feature:addon@0")),
+		"org:c": {mpf("feature:x@plan:free@0")},
+		"org:d": pro0,
+	} {
+		if err := tc.SubscribeTo(ctx, org, fps); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tc.Schedule(ctx, "org:d", nil, []Phase{
+		{Features: pro0},
+		{Effective: t2, Features: pro1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	migrate := func(opts MigrateOptions) []string {
+		t.Helper()
+		var got []string
+		err := tc.Migrate(ctx, mpp("plan:pro@0"), mpp("plan:pro@1"), opts, func(r MigrateResult) {
+			s := r.Org
+			if !r.Effective.IsZero() {
+				s += " at " + r.Effective.Format("2006-01-02")
+			}
+			if r.Err != nil {
+				s += ": " + r.Err.Error()
+			}
+			got = append(got, s)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		slices.Sort(got)
+		return got
+	}
+	current := func(org string) []refs.FeaturePlan {
+		t.Helper()
+		ps, err := tc.LookupPhases(ctx, org)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range ps {
+			if p.Current {
+				fs := slices.Clone(p.Features)
+				slices.SortFunc(fs, func(a, b refs.FeaturePlan) bool {
+					return a.String() < b.String()
+				})
+				return fs
+			}
+		}
+		return nil
+	}
+
+	if err := tc.Migrate(ctx, mpp("plan:pro@0"), mpp("plan:pro@0"), MigrateOptions{}, nil); err == nil {
+		t.Error("migrating to the same plan: err = nil; want error")
+	}
+	if err := tc.Migrate(ctx, mpp("plan:pro@0"), mpp("plan:pro@9"), MigrateOptions{}, nil); !errors.Is(err, ErrPlanNotFound) {
+		t.Errorf("migrating to unknown plan: err = %v; want %v", err, ErrPlanNotFound)
+	}
+
+	want := []string{
+		"org:a",
+		"org:b",
+		"org:d: " + ErrScheduledPhases.Error(),
+	}
+	diff.Test(t, t.Errorf, migrate(MigrateOptions{DryRun: true}), want)
+	diff.Test(t, t.Errorf, current("org:a"), pro0)
+
+	diff.Test(t, t.Errorf, migrate(MigrateOptions{}), want)
+	diff.Test(t, t.Errorf, current("org:a"), pro1)
+	diff.Test(t, t.Errorf, current("org:b"), []refs.FeaturePlan{mpf("feature:addon@0"), pro1[0], pro1[1]})
+	diff.Test(t, t.Errorf, current("org:c"), []refs.FeaturePlan{mpf("feature:x@plan:free@0")})
+	diff.Test(t, t.Errorf, current("org:d"), pro0)
+
+	// Migrating back at renewal keeps the orgs on pro@1 until then.
+	var got []string
+	err := tc.Migrate(ctx, mpp("plan:pro@1"), mpp("plan:pro@0"), MigrateOptions{AtRenewal: true}, func(r MigrateResult) {
+		got = append(got, r.Org)
+		if r.Err != nil || r.Effective.IsZero() {
+			t.Errorf("result = %+v; want migrated at renewal", r)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(got)
+	diff.Test(t, t.Errorf, got, []string{"org:a", "org:b"})
+	diff.Test(t, t.Errorf, current("org:a"), pro1)
+	clock.Advance(t1.AddDate(0, 0, 1))
+	diff.Test(t, t.Errorf, current("org:a"), pro0)
+}
@@ -0,0 +1,198 @@
+package control
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"tier.run/refs"
+	"tier.run/stripe"
+)
+
+// A Notifier is notified of events in the lifecycle of orgs, so that side
+// effects, such as sending email or updating a CRM, can be wired to them.
+// Methods are called synchronously once the event has happened, and so
+// should return quickly, handing slow work to another goroutine. Events
+// received from Stripe may be delivered more than once.
+//
+// Embed NopNotifier to implement only some of the methods.
+type Notifier interface {
+	// OnSubscribe is called after Schedule, and so SubscribeTo and
+	// ScheduleNow, schedules org in phases.
+	OnSubscribe(ctx context.Context, org string, phases []Phase)
+
+	// OnCancel is called when the subscription of org ends, as received
+	// by HandleEvent.
+	OnCancel(ctx context.Context, org string)
+
+	// OnPaymentFailed is called when an attempt to pay the invoice in of
+	// org fails, as received by HandleEvent.
+	OnPaymentFailed(ctx context.Context, org string, in Invoice)
+
+	// OnLimitReached is called, shortly after ReportUsage reports use of
+	// a feature by org reaching the feature's limit, with the usage
+	// then. It is called once a period, unless use falls below the
+	// limit again, and is not called within ReportUsage, but from
+	// another goroutine.
+	OnLimitReached(ctx context.Context, org string, u Usage)
+
+	// OnReport is called after ReportUsage reports use of feature by
+	// org.
+	OnReport(ctx context.Context, org string, feature refs.Name, use Report)
+}
+
+// NopNotifier is a Notifier doing nothing.
+type NopNotifier struct{}
+
+func (NopNotifier) OnSubscribe(context.Context, string, []Phase)        {}
+func (NopNotifier) OnCancel(context.Context, string)                    {}
+func (NopNotifier) OnPaymentFailed(context.Context, string, Invoice)    {}
+func (NopNotifier) OnLimitReached(context.Context, string, Usage)       {}
+func (NopNotifier) OnReport(context.Context, string, refs.Name, Report) {}
+
+// HandleEvent notifies c.Notifier of e, an event received from Stripe by a
+// webhook endpoint, if it is a lifecycle event of an org. Other events, and
+// events for customers not made by tier, are ignored.
+func (c *Client) HandleEvent(ctx context.Context, e *stripe.Event) error {
+	if c.Notifier == nil {
+		return nil
+	}
+	switch e.Type {
+	case "customer.subscription.deleted":
+		var s struct{ Customer string }
+		if err := e.Decode(&s); err != nil {
+			return err
+		}
+		org, err := c.orgOf(ctx, s.Customer)
+		if err != nil || org == "" {
+			return err
+		}
+		c.Notifier.OnCancel(ctx, org)
+	case "invoice.payment_failed":
+		var in stripeInvoice
+		if err := e.Decode(&in); err != nil {
+			return err
+		}
+		org, err := c.orgOf(ctx, in.Customer)
+		if err != nil || org == "" {
+			return err
+		}
+		c.Notifier.OnPaymentFailed(ctx, org, in.invoice())
+	}
+	return nil
+}
+
+// orgOf returns the org of the customer cid, or the empty string if the
+// customer was not made by tier.
+func (c *Client) orgOf(ctx context.Context, cid string) (string, error) {
+	var cus struct {
+		Metadata struct {
+			Org string `json:"tier.org"`
+		}
+	}
+	if err := c.Stripe.Do(ctx, "GET", "/v1/customers/"+cid, stripe.Form{}, &cus); err != nil {
+		return "", err
+	}
+	return cus.Metadata.Org, nil
+}
+
+// limitCheckDelay is how long after a report of use the limit of the
+// feature is checked, so that a burst of reports is checked once.
+const limitCheckDelay = time.Second
+
+type limitKey struct {
+	org     string
+	feature refs.Name
+}
+
+// limitWatch holds the limit checks pending after reports of use, and the
+// period start of each org's features whose limit was reached and
+// notified, so that OnLimitReached is called once a period.
+type limitWatch struct {
+	m       sync.Mutex
+	pending map[limitKey]*time.Timer
+	reached map[limitKey]time.Time
+}
+
+// watchLimit checks, in the background and shortly after, if use of
+// feature by org reached the feature's limit. Checks of the same org and
+// feature made before then are done once.
+func (c *Client) watchLimit(org string, feature refs.Name) {
+	lw := &c.limits
+	k := limitKey{org, feature}
+	lw.m.Lock()
+	defer lw.m.Unlock()
+	if lw.pending[k] != nil {
+		return
+	}
+	if lw.pending == nil {
+		lw.pending = map[limitKey]*time.Timer{}
+	}
+	lw.pending[k] = time.AfterFunc(limitCheckDelay, func() {
+		if lw.take(k) {
+			c.checkLimit(k)
+		}
+	})
+}
+
+// take removes the pending check of k, reporting if it was pending.
+func (lw *limitWatch) take(k limitKey) bool {
+	lw.m.Lock()
+	defer lw.m.Unlock()
+	_, ok := lw.pending[k]
+	delete(lw.pending, k)
+	return ok
+}
+
+// flushLimits runs the pending limit checks now, rather than when due.
+func (c *Client) flushLimits() {
+	lw := &c.limits
+	lw.m.Lock()
+	var ks []limitKey
+	for k, t := range lw.pending {
+		t.Stop()
+		ks = append(ks, k)
+	}
+	lw.pending = nil
+	lw.m.Unlock()
+	for _, k := range ks {
+		c.checkLimit(k)
+	}
+}
+
+// checkLimit calls c.Notifier.OnLimitReached if use of k.feature by k.org
+// reached the feature's limit and has not been notified yet this period.
+// Errors looking up the limit are logged, since the use is already
+// reported.
+func (c *Client) checkLimit(k limitKey) {
+	ctx := context.Background()
+	us, err := c.LookupLimits(ctx, k.org)
+	if err != nil {
+		c.Logf("checkLimit: %q: %v", k.org, err)
+		return
+	}
+	for _, u := range us {
+		if u.Feature.Name() != k.feature || u.Limit == Inf {
+			continue
+		}
+		lw := &c.limits
+		lw.m.Lock()
+		notify := false
+		if u.Used < u.Limit {
+			// Below the limit again, as when clobbered, so notify
+			// when it is next reached.
+			delete(lw.reached, k)
+		} else if !lw.reached[k].Equal(u.Start) {
+			if lw.reached == nil {
+				lw.reached = map[limitKey]time.Time{}
+			}
+			lw.reached[k] = u.Start
+			notify = true
+		}
+		lw.m.Unlock()
+		if notify {
+			c.Notifier.OnLimitReached(ctx, k.org, u)
+		}
+		return
+	}
+}
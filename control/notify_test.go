@@ -0,0 +1,182 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+	"tier.run/refs"
+	"tier.run/stripe"
+)
+
+type recordingNotifier struct {
+	NopNotifier
+
+	mu     sync.Mutex
+	events []string
+}
+
+func (n *recordingNotifier) record(format string, args ...any) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.events = append(n.events, fmt.Sprintf(format, args...))
+}
+
+func (n *recordingNotifier) OnSubscribe(_ context.Context, org string, phases []Phase) {
+	n.record("subscribe %s %v", org, phases[0].Features)
+}
+
+func (n *recordingNotifier) OnCancel(_ context.Context, org string) {
+	n.record("cancel %s", org)
+}
+
+func (n *recordingNotifier) OnPaymentFailed(_ context.Context, org string, in Invoice) {
+	n.record("payment_failed %s %s %d", org, in.ID, in.AmountDue)
+}
+
+func (n *recordingNotifier) OnLimitReached(_ context.Context, org string, u Usage) {
+	n.record("limit_reached %s %s %d/%d", org, u.Feature, u.Used, u.Limit)
+}
+
+func (n *recordingNotifier) OnReport(_ context.Context, org string, feature refs.Name, use Report) {
+	n.record("report %s %s %d", org, feature, use.N)
+}
+
+func TestNotifier(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Mode:        "graduated",
+		Aggregate:   "sum",
+		Tiers:       []Tier{{Upto: 10}},
+	}}
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	n := &recordingNotifier{}
+	tc.Notifier = n
+
+	if err := tc.SubscribeTo(ctx, "org:a", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+	report := func(qs ...int) {
+		t.Helper()
+		for _, q := range qs {
+			if err := tc.ReportUsage(ctx, "org:a", mpn("feature:x"), Report{N: q}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tc.flushLimits()
+	}
+	report(5, 4)
+	report(1, 1) // reached, and notified once
+	report(1)
+
+	cid, err := tc.WhoIs(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := func(typ string, object any) *stripe.Event {
+		t.Helper()
+		data, err := json.Marshal(object)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &stripe.Event{ID: "evt_test", Type: typ, Object: data}
+	}
+	var other struct{ stripe.ID }
+	if err := tc.Stripe.Do(ctx, "POST", "/v1/customers", stripe.Form{}, &other); err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range []*stripe.Event{
+		event("customer.subscription.deleted", map[string]any{"customer": cid}),
+		event("customer.subscription.deleted", map[string]any{"customer": other.ProviderID()}), // not made by tier
+		event("invoice.payment_failed", map[string]any{"id": "in_test", "customer": cid, "amount_due": 1000}),
+		event("invoice.paid", map[string]any{"id": "in_test", "customer": cid}),
+	} {
+		if err := tc.HandleEvent(ctx, e); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	diff.Test(t, t.Errorf, n.events, []string{
+		"subscribe org:a [feature:x@plan:pro@0]",
+		"report org:a feature:x 5",
+		"report org:a feature:x 4",
+		"report org:a feature:x 1",
+		"report org:a feature:x 1",
+		"limit_reached org:a feature:x@plan:pro@0 11/10",
+		"report org:a feature:x 1",
+		"cancel org:a",
+		"payment_failed org:a in_test 1000",
+	})
+}
+
+func TestNotifyLimitInBackground(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Mode:        "graduated",
+		Aggregate:   "sum",
+		Tiers:       []Tier{{Upto: 10}},
+	}}
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SubscribeTo(ctx, "org:a", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+	n := &recordingNotifier{}
+	tc.Notifier = n
+
+	report := func(use Report) {
+		t.Helper()
+		if err := tc.ReportUsage(ctx, "org:a", mpn("feature:x"), use); err != nil {
+			t.Fatal(err)
+		}
+	}
+	limits := func() []string {
+		n.mu.Lock()
+		defer n.mu.Unlock()
+		var got []string
+		for _, e := range n.events {
+			if strings.HasPrefix(e, "limit_reached") {
+				got = append(got, e)
+			}
+		}
+		return got
+	}
+
+	report(Report{N: 6})
+	report(Report{N: 6})
+	deadline := time.Now().Add(5 * time.Second)
+	for len(limits()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	diff.Test(t, t.Errorf, limits(), []string{
+		"limit_reached org:a feature:x@plan:pro@0 12/10",
+	})
+
+	// Clobbered below the limit, it is notified when reached again.
+	report(Report{N: 0, Clobber: true})
+	tc.flushLimits()
+	report(Report{N: 10})
+	tc.flushLimits()
+	diff.Test(t, t.Errorf, limits(), []string{
+		"limit_reached org:a feature:x@plan:pro@0 12/10",
+		"limit_reached org:a feature:x@plan:pro@0 10/10",
+	})
+}
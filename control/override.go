@@ -0,0 +1,189 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"tier.run/refs"
+	"tier.run/stripe"
+)
+
+// ErrOverrideConflict is returned by PutOverrides if the overrides of the
+// org changed since the version they were based on.
+var ErrOverrideConflict = errors.New("overrides changed since read")
+
+// Overrides are the limits of features set for an org in place of those of
+// its plans. They are kept in the metadata of the org's customer in Stripe,
+// so that they survive changes to the org's schedule.
+type Overrides struct {
+	// Version is incremented by each change to the overrides, starting
+	// from zero for an org without overrides.
+	Version int
+
+	Limits map[refs.Name]int // by feature
+}
+
+// overridesJSON is the encoding of Overrides in customer metadata.
+type overridesJSON struct {
+	Version int            `json:"version"`
+	Limits  map[string]int `json:"limits,omitempty"`
+}
+
+func (o Overrides) encode() overridesJSON {
+	v := overridesJSON{Version: o.Version}
+	for name, limit := range o.Limits {
+		if v.Limits == nil {
+			v.Limits = map[string]int{}
+		}
+		v.Limits[name.String()] = limit
+	}
+	return v
+}
+
+func decodeOverrides(s string) (Overrides, error) {
+	if s == "" {
+		return Overrides{}, nil
+	}
+	var v overridesJSON
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		return Overrides{}, err
+	}
+	o := Overrides{Version: v.Version}
+	for name, limit := range v.Limits {
+		fn, err := refs.ParseName(name)
+		if err != nil {
+			return Overrides{}, err
+		}
+		if o.Limits == nil {
+			o.Limits = map[refs.Name]int{}
+		}
+		o.Limits[fn] = limit
+	}
+	return o, nil
+}
+
+// LookupOverrides returns the overrides of org.
+func (c *Client) LookupOverrides(ctx context.Context, org string) (Overrides, error) {
+	_, o, err := c.lookupOverrides(ctx, org)
+	return o, err
+}
+
+func (c *Client) lookupOverrides(ctx context.Context, org string) (cid string, _ Overrides, err error) {
+	cid, err = c.WhoIs(ctx, org)
+	if err != nil {
+		return "", Overrides{}, err
+	}
+	var cus struct {
+		Metadata struct {
+			Overrides string `json:"tier.overrides"`
+		}
+	}
+	if err := c.Stripe.Do(ctx, "GET", "/v1/customers/"+cid, stripe.Form{}, &cus); err != nil {
+		return "", Overrides{}, err
+	}
+	o, err := decodeOverrides(cus.Metadata.Overrides)
+	if err != nil {
+		return "", Overrides{}, fmt.Errorf("overrides of %q: %w", org, err)
+	}
+	return cid, o, nil
+}
+
+// PutOverrides replaces the overrides of org with limits, and returns them
+// with their new version. If limits is empty, the overrides are cleared.
+//
+// Version must be the version of the overrides the change is based on, as
+// returned by LookupOverrides, or else ErrOverrideConflict is returned, so
+// that concurrent changes are not lost. Changes based on the same version
+// are also detected by Stripe while in flight, since they are sent with the
+// same idempotency key.
+//
+// It reports a *ValidationError if a limit is negative, or if the
+// overrides are too large to keep in Stripe metadata.
+func (c *Client) PutOverrides(ctx context.Context, org string, limits map[refs.Name]int, version int) (_ Overrides, err error) {
+	cid, old, err := c.lookupOverrides(ctx, org)
+	if err != nil {
+		return Overrides{}, err
+	}
+	o := Overrides{Version: version + 1, Limits: maps.Clone(limits)}
+	defer c.audit(ctx, AuditOverrides, org, func() (any, error) {
+		return old.encode(), nil
+	}, o.encode(), &err)()
+
+	if version != old.Version {
+		return Overrides{}, fmt.Errorf("%w: version is %d, not %d", ErrOverrideConflict, old.Version, version)
+	}
+	for name, limit := range limits {
+		if limit < 0 {
+			return Overrides{}, &ValidationError{Message: fmt.Sprintf("limit of %s must not be negative", name)}
+		}
+	}
+
+	// Cleared overrides are kept with their version, so that versions,
+	// and the idempotency keys made of them, are never reused.
+	data, err := json.Marshal(o.encode())
+	if err != nil {
+		return Overrides{}, err
+	}
+	if len(data) > stripe.MaxMetadataValueLen {
+		return Overrides{}, &ValidationError{Message: fmt.Sprintf("overrides must not exceed %d bytes as JSON", stripe.MaxMetadataValueLen)}
+	}
+
+	var f stripe.Form
+	f.SetIdempotencyKey(fmt.Sprintf("overrides:%s:%d", org, o.Version))
+	f.Set("metadata", "tier.overrides", string(data))
+	err = c.Stripe.Do(ctx, "POST", "/v1/customers/"+cid, f, nil)
+	if errors.Is(err, stripe.ErrIdempotency) {
+		return Overrides{}, fmt.Errorf("%w: version %d written concurrently", ErrOverrideConflict, o.Version)
+	}
+	if err != nil {
+		return Overrides{}, err
+	}
+	return o, nil
+}
+
+// reconcileOverrides reports the overrides of orgs for features never
+// pushed, as named by the features in fs, and overrides that cannot be
+// decoded.
+func (c *Client) reconcileOverrides(ctx context.Context, fs []Feature) ([]Drift, error) {
+	pushed := map[refs.Name]bool{}
+	for _, f := range fs {
+		pushed[f.Name()] = true
+	}
+	type T struct {
+		stripe.ID
+		Metadata struct {
+			Org       string `json:"tier.org"`
+			Overrides string `json:"tier.overrides"`
+		}
+	}
+	var ds []Drift
+	err := stripe.Iter(ctx, c.Stripe, "GET", "/v1/customers", stripe.Form{}, func(cus T) bool {
+		if cus.Metadata.Org == "" || cus.Metadata.Overrides == "" {
+			return true
+		}
+		o, err := decodeOverrides(cus.Metadata.Overrides)
+		if err != nil {
+			c.Logf("reconcile: overrides of %q: %v", cus.Metadata.Org, err)
+			ds = append(ds, Drift{Kind: DriftInvalidOverrides, ID: cus.ProviderID(), Org: cus.Metadata.Org})
+			return true
+		}
+		names := maps.Keys(o.Limits)
+		slices.SortFunc(names, refs.Name.Less)
+		for _, name := range names {
+			if !pushed[name] {
+				ds = append(ds, Drift{
+					Kind:     DriftUnknownOverride,
+					ID:       cus.ProviderID(),
+					Org:      cus.Metadata.Org,
+					Override: name,
+				})
+			}
+		}
+		return true
+	})
+	return ds, err
+}
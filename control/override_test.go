@@ -0,0 +1,106 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/refs"
+	"tier.run/stripe"
+)
+
+func TestOverrides(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:free@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}}
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SubscribeTo(ctx, "org:a", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+
+	var log MemoryAuditLog
+	tc.Audit = &log
+
+	o, err := tc.LookupOverrides(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, o, Overrides{})
+
+	limits := map[refs.Name]int{mpn("feature:x"): 100, mpn("feature:gone"): 5}
+	if _, err := tc.PutOverrides(ctx, "org:a", limits, 1); !errors.Is(err, ErrOverrideConflict) {
+		t.Errorf("putting future version: err = %v; want %v", err, ErrOverrideConflict)
+	}
+	var ve *ValidationError
+	if _, err := tc.PutOverrides(ctx, "org:a", map[refs.Name]int{mpn("feature:x"): -1}, 0); !errors.As(err, &ve) {
+		t.Errorf("putting negative limit: err = %v; want *ValidationError", err)
+	}
+	o, err = tc.PutOverrides(ctx, "org:a", limits, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Overrides{Version: 1, Limits: limits}
+	diff.Test(t, t.Errorf, o, want)
+	if _, err := tc.PutOverrides(ctx, "org:a", nil, 0); !errors.Is(err, ErrOverrideConflict) {
+		t.Errorf("putting stale version: err = %v; want %v", err, ErrOverrideConflict)
+	}
+
+	// Overrides survive schedule rewrites.
+	if err := tc.SubscribeTo(ctx, "org:a", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+	o, err = tc.LookupOverrides(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, o, want)
+
+	ds, err := tc.Reconcile(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range ds {
+		if d.Kind == DriftUnknownOverride {
+			got = append(got, d.Org+" "+d.Override.String())
+		}
+	}
+	diff.Test(t, t.Errorf, got, []string{"org:a feature:gone"})
+
+	// A change of the same version in flight conflicts too.
+	cid, err := tc.WhoIs(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f stripe.Form
+	f.SetIdempotencyKey("overrides:org:a:2")
+	f.Set("metadata", "other", "change")
+	if err := tc.Stripe.Do(ctx, "POST", "/v1/customers/"+cid, f, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.PutOverrides(ctx, "org:a", nil, 1); !errors.Is(err, ErrOverrideConflict) {
+		t.Errorf("putting concurrently: err = %v; want %v", err, ErrOverrideConflict)
+	}
+
+	es, err := log.List(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = nil
+	for _, e := range es {
+		if e.Op == AuditOverrides && e.Error == "" {
+			got = append(got, string(e.Before)+" "+string(e.After))
+		}
+	}
+	diff.Test(t, t.Errorf, got, []string{
+		`{"version":0} {"version":1,"limits":{"feature:gone":5,"feature:x":100}}`,
+	})
+}
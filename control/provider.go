@@ -0,0 +1,48 @@
+package control
+
+import (
+	"context"
+
+	"tier.run/refs"
+)
+
+// A Provider is a billing backend. It holds the features pushed to it, the
+// phases orgs are scheduled in, and the usage reported for them.
+//
+// Client is the Provider for Stripe. The api package serves any Provider,
+// so other backends may be added without changes to it or to the clients
+// of the sidecar.
+type Provider interface {
+	// Push creates the features in fs, calling cb with each feature and
+	// the error pushing it, if any. PushDryRun calls cb as Push would,
+	// without creating any features.
+	Push(ctx context.Context, fs []Feature, cb PushReportFunc) error
+	PushDryRun(ctx context.Context, fs []Feature, cb PushReportFunc) error
+
+	// PullWithOptions returns the features pushed.
+	PullWithOptions(ctx context.Context, opts PullOptions) ([]Feature, error)
+
+	// Diff returns the changes a Push of fs would make.
+	Diff(ctx context.Context, fs []Feature) ([]Change, error)
+
+	// ScheduleNow replaces the phases org is scheduled in with phases,
+	// starting the first now, and updates its info if not nil.
+	ScheduleNow(ctx context.Context, org string, info *OrgInfo, phases []Phase) error
+
+	// LookupPhases returns the phases org is scheduled in.
+	LookupPhases(ctx context.Context, org string) ([]Phase, error)
+
+	// ReportUsage reports the use of feature by org.
+	ReportUsage(ctx context.Context, org string, feature refs.Name, use Report) error
+
+	// LookupLimits returns the limits and usage of the features org is
+	// subscribed to in its current phase.
+	LookupLimits(ctx context.Context, org string) ([]Usage, error)
+
+	// WhoIs returns the ID of org in the backend, and LookupOrg its info.
+	// Both return an error wrapping ErrOrgNotFound for unknown orgs.
+	WhoIs(ctx context.Context, org string) (string, error)
+	LookupOrg(ctx context.Context, org string) (*OrgInfo, error)
+}
+
+var _ Provider = (*Client)(nil)
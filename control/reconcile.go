@@ -0,0 +1,310 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"tier.run/refs"
+	"tier.run/stripe"
+)
+
+// Kinds of Drift reported by Reconcile.
+const (
+	// DriftMissingMetadata is reported for an active price with the
+	// lookup key of a feature but no tier metadata, such as one edited in
+	// the Stripe dashboard. It is repaired by restoring the feature in the
+	// price's metadata, so that it is pulled again.
+	DriftMissingMetadata = "missing_metadata"
+
+	// DriftMissingPlan is reported for a plan with features but no
+	// product marking it as pushed, which keeps it from being pushed
+	// again. It is repaired by creating the product.
+	DriftMissingPlan = "missing_plan"
+
+	// DriftOrphanedPrice is reported for an active feature in a plan
+	// with archived features, as left by an Archive that failed part way.
+	// It is repaired by archiving the rest of the plan.
+	DriftOrphanedPrice = "orphaned_price"
+
+	// DriftUnknownPrice is reported for a price in the subscription
+	// schedule of an org that is not a feature, once per org. It is not
+	// repaired.
+	DriftUnknownPrice = "unknown_price"
+
+	// DriftUnknownOverride is reported for an override of an org for a
+	// feature never pushed, such as one since renamed. It is not
+	// repaired.
+	DriftUnknownOverride = "unknown_override"
+
+	// DriftInvalidOverrides is reported for an org whose overrides
+	// cannot be decoded, such as ones edited in the Stripe dashboard. It
+	// is not repaired.
+	DriftInvalidOverrides = "invalid_overrides"
+)
+
+// A Drift is a difference found by Reconcile between the state of Stripe
+// and the state tier expects of it.
+type Drift struct {
+	Kind    string           // one of the Drift constants
+	ID      string           // the Stripe ID of the price or product
+	Feature refs.FeaturePlan // the feature drifted, if known
+	Org     string           // the org whose schedule or overrides drifted, if any
+
+	// Override is the feature overridden, for DriftUnknownOverride.
+	Override refs.Name
+
+	// Repaired reports whether the drift was repaired. If not, and
+	// Reconcile was asked to repair, Err holds the reason.
+	Repaired bool
+	Err      error
+}
+
+func (d Drift) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s", d.Kind, d.ID)
+	if !d.Feature.IsZero() {
+		fmt.Fprintf(&b, " feature=%s", d.Feature)
+	}
+	if d.Org != "" {
+		fmt.Fprintf(&b, " org=%s", d.Org)
+	}
+	if d.Override != (refs.Name{}) {
+		fmt.Fprintf(&b, " override=%s", d.Override)
+	}
+	switch {
+	case d.Repaired:
+		b.WriteString(" (repaired)")
+	case d.Err != nil:
+		fmt.Fprintf(&b, " (repair failed: %v)", d.Err)
+	}
+	return b.String()
+}
+
+// Reconcile compares the features pushed and the subscription schedules of
+// known orgs against Stripe, and returns the drift found, if any. If repair
+// is true, drift that can be repaired is repaired; see the Drift constants.
+//
+// Repairs to missing metadata use refs.ParseFeatureID, and so cannot
+// restore features whose IDs it finds ambiguous, such as those with "plan"
+// as a segment of their name.
+func (c *Client) Reconcile(ctx context.Context, repair bool) ([]Drift, error) {
+	var ds []Drift
+	known := map[string]bool{} // IDs of prices that are features
+
+	// Restore missing metadata first, so the checks that follow see the
+	// features repaired.
+	var f stripe.Form
+	f.Set("active", true)
+	prices, err := stripe.Slurp[stripePrice](ctx, c.Stripe, "GET", "/v1/prices", f)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range prices {
+		if !p.Metadata.Feature.IsZero() || !strings.HasPrefix(p.LookupKey, "tier__") {
+			continue
+		}
+		fp, err := refs.ParseFeatureID(p.LookupKey)
+		if err != nil {
+			continue
+		}
+		d := Drift{Kind: DriftMissingMetadata, ID: p.ProviderID(), Feature: fp}
+		known[d.ID] = true
+		if repair {
+			var f stripe.Form
+			f.Set("metadata", "tier.feature", fp)
+			d.Err = c.Stripe.Do(ctx, "POST", "/v1/prices/"+d.ID, f, nil)
+			d.Repaired = d.Err == nil
+		}
+		ds = append(ds, d)
+	}
+
+	fs, err := c.PullAll(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	slices.SortFunc(fs, func(a, b Feature) bool {
+		return a.Less(b.FeaturePlan)
+	})
+	byPlan := map[refs.Plan][]Feature{}
+	for _, f := range fs {
+		known[f.ProviderID] = true
+		if p := f.Plan(); !p.IsZero() {
+			byPlan[p] = append(byPlan[p], f)
+		}
+	}
+	plans := maps.Keys(byPlan)
+	slices.SortFunc(plans, func(a, b refs.Plan) bool {
+		return a.String() < b.String()
+	})
+
+	pushed, err := c.pushedPlans(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range plans {
+		if pushed[p] {
+			continue
+		}
+		d := Drift{Kind: DriftMissingPlan, ID: stripe.MakeID(p.String())}
+		if repair {
+			d.Err = c.pushSentinelPlan(ctx, p, nil)
+			d.Repaired = d.Err == nil
+		}
+		ds = append(ds, d)
+	}
+
+	for _, p := range plans {
+		pfs := byPlan[p]
+		if slices.IndexFunc(pfs, func(f Feature) bool { return f.Archived }) < 0 {
+			continue
+		}
+		var orphans []Drift
+		for _, f := range pfs {
+			if !f.Archived {
+				orphans = append(orphans, Drift{Kind: DriftOrphanedPrice, ID: f.ProviderID, Feature: f.FeaturePlan})
+			}
+		}
+		if len(orphans) > 0 && repair {
+			err := c.Archive(ctx, p)
+			for i := range orphans {
+				orphans[i].Err = err
+				orphans[i].Repaired = err == nil
+			}
+		}
+		ds = append(ds, orphans...)
+	}
+
+	sds, err := c.reconcileSchedules(ctx, known)
+	if err != nil {
+		return nil, err
+	}
+	ods, err := c.reconcileOverrides(ctx, fs)
+	if err != nil {
+		return nil, err
+	}
+	ds = append(ds, sds...)
+	return append(ds, ods...), nil
+}
+
+// pushedPlans returns the set of plans marked as pushed by the inactive
+// products made by pushSentinelPlan.
+func (c *Client) pushedPlans(ctx context.Context) (map[refs.Plan]bool, error) {
+	type T struct {
+		stripe.ID
+		Name string
+	}
+	var f stripe.Form
+	f.Set("active", false)
+	pushed := map[refs.Plan]bool{}
+	err := stripe.Iter(ctx, c.Stripe, "GET", "/v1/products", f, func(p T) bool {
+		plan, err := refs.ParsePlan(p.Name)
+		if err == nil && p.ProviderID() == stripe.MakeID(plan.String()) {
+			pushed[plan] = true
+		}
+		return true
+	})
+	return pushed, err
+}
+
+// reconcileSchedules reports the prices in the schedules of known orgs
+// that are not known to be features.
+func (c *Client) reconcileSchedules(ctx context.Context, known map[string]bool) ([]Drift, error) {
+	orgs := map[string]string{} // customer ID -> org
+	err := c.EachOrg(ctx, func(o Org) error {
+		if o.ID != "" {
+			orgs[o.ProviderID] = o.ID
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	type T struct {
+		stripe.ID
+		Customer string
+		Status   string
+		Phases   []struct {
+			Items []struct {
+				Price string
+			}
+			InvoiceItems []struct {
+				Price string
+			} `json:"add_invoice_items"`
+		}
+	}
+	var ds []Drift
+	seen := map[string]bool{}
+	err = stripe.Iter(ctx, c.Stripe, "GET", "/v1/subscription_schedules", stripe.Form{}, func(s T) bool {
+		org := orgs[s.Customer]
+		if org == "" || (s.Status != "active" && s.Status != "not_started") {
+			return true
+		}
+		var prices []string
+		for _, p := range s.Phases {
+			for _, it := range p.Items {
+				prices = append(prices, it.Price)
+			}
+			for _, it := range p.InvoiceItems {
+				prices = append(prices, it.Price)
+			}
+		}
+		for _, price := range prices {
+			if known[price] || seen[org+" "+price] {
+				continue
+			}
+			seen[org+" "+price] = true
+			ds = append(ds, Drift{Kind: DriftUnknownPrice, ID: price, Org: org})
+		}
+		return true
+	})
+	return ds, err
+}
+
+// A Reconciler runs Reconcile periodically, reporting the drift found.
+type Reconciler struct {
+	Client *Client
+
+	// Interval is how often to reconcile. If zero, an hour is used.
+	Interval time.Duration
+
+	// Repair, if true, repairs the drift found where possible.
+	Repair bool
+
+	// Report, if non-nil, is called with each drift found, such as to
+	// count them in metrics. Drift is logged using Client.Logf whether or
+	// not Report is set.
+	Report func(Drift)
+}
+
+// Run reconciles once, and then every r.Interval until ctx is done. Errors
+// reconciling are logged and retried at the next interval. It returns
+// ctx.Err().
+func (r *Reconciler) Run(ctx context.Context) error {
+	interval := r.Interval
+	if interval == 0 {
+		interval = time.Hour
+	}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		ds, err := r.Client.Reconcile(ctx, r.Repair)
+		if err != nil {
+			r.Client.Logf("tier: reconcile: %v", err)
+		}
+		for _, d := range ds {
+			r.Client.Logf("tier: reconcile: %v", d)
+			if r.Report != nil {
+				r.Report(d)
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package control
+
+import (
+	"context"
+	"testing"
+
+	"golang.org/x/exp/slices"
+	"kr.dev/diff"
+	"tier.run/refs"
+	"tier.run/stripe"
+)
+
+func TestReconcile(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	f := func(s string) Feature {
+		return Feature{FeaturePlan: mpf(s), Interval: "@monthly", Currency: "usd"}
+	}
+	fs := []Feature{
+		f("feature:x@plan:free@0"),
+		f("feature:x@plan:pro@0"),
+		f("feature:y@plan:pro@0"),
+	}
+	if err := tc.Push(ctx, fs, pushLogger(t)); err != nil {
+		t.Fatal(err)
+	}
+	pulled, err := tc.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	find := func(fs []Feature, fp refs.FeaturePlan) (Feature, bool) {
+		i := slices.IndexFunc(fs, func(f Feature) bool { return f.FeaturePlan == fp })
+		if i < 0 {
+			return Feature{}, false
+		}
+		return fs[i], true
+	}
+	ids := map[refs.FeaturePlan]string{}
+	for _, f := range pulled {
+		ids[f.FeaturePlan] = f.ProviderID
+	}
+	providerID := func(fp refs.FeaturePlan) string {
+		t.Helper()
+		if ids[fp] == "" {
+			t.Fatalf("feature %q not found", fp)
+		}
+		return ids[fp]
+	}
+	if err := tc.SubscribeTo(ctx, "org:acme", FeaturePlans(fs[:1])); err != nil {
+		t.Fatal(err)
+	}
+
+	do := func(path string, f stripe.Form) string {
+		t.Helper()
+		var v stripe.JustID
+		if err := tc.Stripe.Do(ctx, "POST", path, f, &v); err != nil {
+			t.Fatal(err)
+		}
+		return v.ProviderID()
+	}
+
+	// A price edited in the dashboard, losing its metadata.
+	var unset stripe.Form
+	unset.Set("metadata", "tier.feature", "")
+	do("/v1/prices/"+providerID(mpf("feature:x@plan:free@0")), unset)
+
+	// A plan partly archived.
+	var archive stripe.Form
+	archive.Set("active", false)
+	do("/v1/prices/"+providerID(mpf("feature:x@plan:pro@0")), archive)
+
+	// A feature made without a plan sentinel.
+	var team stripe.Form
+	team.Set("product_data", "name", "Team")
+	team.Set("currency", "usd")
+	team.Set("unit_amount", 100)
+	team.Set("recurring", "interval", "month")
+	team.Set("metadata", "tier.feature", "feature:x@plan:team@0")
+	do("/v1/prices", team)
+
+	// A price scheduled outside of tier.
+	var other stripe.Form
+	other.Set("product_data", "name", "Other")
+	other.Set("currency", "usd")
+	other.Set("unit_amount", 100)
+	other.Set("recurring", "interval", "month")
+	otherID := do("/v1/prices", other)
+	cid, err := tc.WhoIs(ctx, "org:acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sched stripe.Form
+	sched.Set("customer", cid)
+	sched.Set("start_date", "now")
+	sched.Set("phases", 0, "items", 0, "price", otherID)
+	do("/v1/subscription_schedules", sched)
+
+	want := []Drift{
+		{Kind: DriftMissingMetadata, ID: providerID(mpf("feature:x@plan:free@0")), Feature: mpf("feature:x@plan:free@0")},
+		{Kind: DriftMissingPlan, ID: stripe.MakeID("plan:team@0")},
+		{Kind: DriftOrphanedPrice, ID: providerID(mpf("feature:y@plan:pro@0")), Feature: mpf("feature:y@plan:pro@0")},
+		{Kind: DriftUnknownPrice, ID: otherID, Org: "org:acme"},
+	}
+	got, err := tc.Reconcile(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, want)
+
+	got, err = tc.Reconcile(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range want[:3] {
+		want[i].Repaired = true
+	}
+	diff.Test(t, t.Errorf, got, want)
+
+	// Only the unknown price remains.
+	got, err = tc.Reconcile(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, want[3:])
+
+	pulled, err = tc.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := find(pulled, mpf("feature:y@plan:pro@0")); ok {
+		t.Error("feature:y@plan:pro@0 not archived")
+	}
+	if f, _ := find(pulled, mpf("feature:x@plan:free@0")); f.ProviderID == "" {
+		t.Error("feature:x@plan:free@0 not restored")
+	}
+}
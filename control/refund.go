@@ -0,0 +1,236 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"tier.run/stripe"
+)
+
+// Refund reasons, as accepted by Stripe.
+const (
+	RefundDuplicate           = "duplicate"
+	RefundFraudulent          = "fraudulent"
+	RefundRequestedByCustomer = "requested_by_customer"
+)
+
+// Credit note reasons, as accepted by Stripe.
+const (
+	CreditNoteDuplicate             = "duplicate"
+	CreditNoteFraudulent            = "fraudulent"
+	CreditNoteOrderChange           = "order_change"
+	CreditNoteProductUnsatisfactory = "product_unsatisfactory"
+)
+
+var (
+	refundReasons     = []string{RefundDuplicate, RefundFraudulent, RefundRequestedByCustomer}
+	creditNoteReasons = []string{CreditNoteDuplicate, CreditNoteFraudulent, CreditNoteOrderChange, CreditNoteProductUnsatisfactory}
+)
+
+// ErrChargeNotFound is returned by Refund for charges that do not belong to
+// the org.
+var ErrChargeNotFound = errors.New("charge not found")
+
+// A Refund is a refund of part or all of a charge.
+type Refund struct {
+	ID       string
+	Charge   string // the ID of the charge refunded
+	Amount   int    // in the smallest currency unit
+	Currency string
+	Reason   string // one of the Refund constants, or empty
+	Status   string // (e.g. "succeeded", "pending")
+	Created  time.Time
+}
+
+// A CreditNote is a credit of part or all of an invoice.
+type CreditNote struct {
+	ID       string
+	Invoice  string // the ID of the invoice credited
+	Amount   int    // in the smallest currency unit
+	Currency string
+	Reason   string // one of the CreditNote constants, or empty
+	Memo     string
+	Created  time.Time
+}
+
+type stripeCharge struct {
+	stripe.ID
+	Customer       string
+	Amount         int
+	AmountRefunded int `json:"amount_refunded"`
+}
+
+// Refund refunds amount of the charge id of org, or the rest of the charge
+// if amount is zero, for reason, which must be one of the Refund constants
+// or empty. If id is the ID of a paid invoice, its charge is refunded.
+//
+// It reports ErrInvoiceNotFound or ErrChargeNotFound if the invoice or
+// charge does not belong to org, ErrInvoiceStatus if the invoice is not
+// paid, a *ValidationError if amount is more than is left to refund, and
+// stripe.ErrLiveModeGuard if the client may not refund in live mode.
+func (c *Client) Refund(ctx context.Context, org, id string, amount int, reason string) (_ Refund, err error) {
+	if err := c.Stripe.GuardLive(); err != nil {
+		return Refund{}, err
+	}
+	if amount < 0 {
+		return Refund{}, &ValidationError{Message: "refund amount must not be negative"}
+	}
+	if reason != "" && !slices.Contains(refundReasons, reason) {
+		return Refund{}, &ValidationError{Message: fmt.Sprintf("refund reason must be one of %s", strings.Join(refundReasons, ", "))}
+	}
+	cid, err := c.WhoIs(ctx, org)
+	if err != nil {
+		return Refund{}, err
+	}
+
+	if strings.HasPrefix(id, "in_") {
+		in, err := c.lookupInvoice(ctx, cid, id)
+		if err != nil {
+			return Refund{}, err
+		}
+		if in.Charge == "" {
+			return Refund{}, fmt.Errorf("%w: cannot refund %s invoice %s", ErrInvoiceStatus, in.Status, id)
+		}
+		id = in.Charge
+	}
+	var ch stripeCharge
+	var f stripe.Form
+	err = c.Stripe.Do(ctx, "GET", "/v1/charges/"+url.PathEscape(id), f, &ch)
+	if isMissing(err) || err == nil && ch.Customer != cid {
+		return Refund{}, fmt.Errorf("%w: %s", ErrChargeNotFound, id)
+	}
+	if err != nil {
+		return Refund{}, err
+	}
+
+	left := ch.Amount - ch.AmountRefunded
+	if amount == 0 {
+		amount = left
+	}
+	type state struct {
+		Charge   string `json:"charge"`
+		Refunded int    `json:"refunded"`
+		Reason   string `json:"reason,omitempty"`
+	}
+	defer c.audit(ctx, AuditRefund, org, func() (any, error) {
+		return state{id, ch.AmountRefunded, ""}, nil
+	}, state{id, ch.AmountRefunded + amount, reason}, &err)()
+
+	if left == 0 {
+		return Refund{}, &ValidationError{Message: fmt.Sprintf("charge %s is already refunded", id)}
+	}
+	if amount > left {
+		return Refund{}, &ValidationError{Message: fmt.Sprintf("refund amount %d exceeds the %d left to refund", amount, left)}
+	}
+
+	f.Set("charge", id)
+	f.Set("amount", amount)
+	if reason != "" {
+		f.Set("reason", reason)
+	}
+	var r struct {
+		stripe.ID
+		Charge   string
+		Amount   int
+		Currency string
+		Reason   string
+		Status   string
+		Created  int64
+	}
+	if err = c.Stripe.Do(ctx, "POST", "/v1/refunds", f, &r); err != nil {
+		return Refund{}, err
+	}
+	return Refund{
+		ID:       r.ProviderID(),
+		Charge:   r.Charge,
+		Amount:   r.Amount,
+		Currency: r.Currency,
+		Reason:   r.Reason,
+		Status:   r.Status,
+		Created:  time.Unix(r.Created, 0),
+	}, nil
+}
+
+// CreateCreditNote credits amount of the open or paid invoice id of org for
+// reason, which must be one of the CreditNote constants or empty, with memo
+// shown to the org, if not empty. Credits for open invoices reduce the
+// amount due. Credits for paid invoices are added to the org's credit
+// balance, to be applied to its next invoices; use Refund to return money
+// instead.
+//
+// It reports ErrInvoiceNotFound if the invoice does not belong to org,
+// ErrInvoiceStatus if it is neither open nor paid, a *ValidationError if
+// amount is not positive, and stripe.ErrLiveModeGuard if the client may not
+// credit invoices in live mode.
+func (c *Client) CreateCreditNote(ctx context.Context, org, id string, amount int, reason, memo string) (_ CreditNote, err error) {
+	if err := c.Stripe.GuardLive(); err != nil {
+		return CreditNote{}, err
+	}
+	if amount <= 0 {
+		return CreditNote{}, &ValidationError{Message: "credit note amount must be positive"}
+	}
+	if reason != "" && !slices.Contains(creditNoteReasons, reason) {
+		return CreditNote{}, &ValidationError{Message: fmt.Sprintf("credit note reason must be one of %s", strings.Join(creditNoteReasons, ", "))}
+	}
+	cid, err := c.WhoIs(ctx, org)
+	if err != nil {
+		return CreditNote{}, err
+	}
+	in, err := c.lookupInvoice(ctx, cid, id)
+	if err != nil {
+		return CreditNote{}, err
+	}
+
+	type state struct {
+		Invoice string `json:"invoice"`
+		Status  string `json:"status"`
+		Credit  int    `json:"credit,omitempty"`
+		Reason  string `json:"reason,omitempty"`
+	}
+	defer c.audit(ctx, AuditCreditNote, org, func() (any, error) {
+		return state{Invoice: id, Status: in.Status}, nil
+	}, state{id, in.Status, amount, reason}, &err)()
+
+	if in.Status != InvoiceOpen && in.Status != InvoicePaid {
+		return CreditNote{}, fmt.Errorf("%w: cannot credit %s invoice %s", ErrInvoiceStatus, in.Status, id)
+	}
+
+	var f stripe.Form
+	f.Set("invoice", id)
+	f.Set("amount", amount)
+	if in.Status == InvoicePaid {
+		f.Set("credit_amount", amount)
+	}
+	if reason != "" {
+		f.Set("reason", reason)
+	}
+	if memo != "" {
+		f.Set("memo", memo)
+	}
+	var cn struct {
+		stripe.ID
+		Invoice  string
+		Amount   int
+		Currency string
+		Reason   string
+		Memo     string
+		Created  int64
+	}
+	if err = c.Stripe.Do(ctx, "POST", "/v1/credit_notes", f, &cn); err != nil {
+		return CreditNote{}, err
+	}
+	return CreditNote{
+		ID:       cn.ProviderID(),
+		Invoice:  cn.Invoice,
+		Amount:   cn.Amount,
+		Currency: cn.Currency,
+		Reason:   cn.Reason,
+		Memo:     cn.Memo,
+		Created:  time.Unix(cn.Created, 0),
+	}, nil
+}
@@ -0,0 +1,138 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/stripe"
+)
+
+func TestRefund(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	for _, org := range []string{"org:a", "org:b"} {
+		if err := tc.PutCustomer(ctx, org, &OrgInfo{Email: "a@example.com"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cid, err := tc.WhoIs(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	open := newDraft(t, tc, cid, 1000)
+	if _, err := tc.FinalizeInvoice(ctx, "org:a", open); err != nil {
+		t.Fatal(err)
+	}
+	paid := newDraft(t, tc, cid, 1000)
+	if _, err := tc.FinalizeInvoice(ctx, "org:a", paid); err != nil {
+		t.Fatal(err)
+	}
+	var in stripeInvoice
+	if err := tc.Stripe.Do(ctx, "POST", "/v1/invoices/"+paid+"/pay", stripe.Form{}, &in); err != nil {
+		t.Fatal(err)
+	}
+
+	var log MemoryAuditLog
+	tc.Audit = &log
+
+	if _, err := tc.Refund(ctx, "org:b", paid, 0, ""); !errors.Is(err, ErrInvoiceNotFound) {
+		t.Errorf("refunding invoice of other org: err = %v; want %v", err, ErrInvoiceNotFound)
+	}
+	if _, err := tc.Refund(ctx, "org:b", in.Charge, 0, ""); !errors.Is(err, ErrChargeNotFound) {
+		t.Errorf("refunding charge of other org: err = %v; want %v", err, ErrChargeNotFound)
+	}
+	if _, err := tc.Refund(ctx, "org:a", open, 0, ""); !errors.Is(err, ErrInvoiceStatus) {
+		t.Errorf("refunding open invoice: err = %v; want %v", err, ErrInvoiceStatus)
+	}
+	var ve *ValidationError
+	if _, err := tc.Refund(ctx, "org:a", paid, 100, "changed_mind"); !errors.As(err, &ve) {
+		t.Errorf("refunding with bad reason: err = %v; want *ValidationError", err)
+	}
+
+	r, err := tc.Refund(ctx, "org:a", paid, 300, RefundRequestedByCustomer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Charge != in.Charge || r.Amount != 300 || r.Reason != RefundRequestedByCustomer {
+		t.Errorf("refund = %+v; want 300 of %s for %s", r, in.Charge, RefundRequestedByCustomer)
+	}
+	if _, err := tc.Refund(ctx, "org:a", in.Charge, 800, ""); !errors.As(err, &ve) {
+		t.Errorf("refunding too much: err = %v; want *ValidationError", err)
+	}
+	r, err = tc.Refund(ctx, "org:a", in.Charge, 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Amount != 700 {
+		t.Errorf("refunding the rest: amount = %d; want 700", r.Amount)
+	}
+	if _, err := tc.Refund(ctx, "org:a", in.Charge, 0, ""); !errors.As(err, &ve) {
+		t.Errorf("refunding refunded charge: err = %v; want *ValidationError", err)
+	}
+
+	es, err := log.List(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range es {
+		if e.Error == "" {
+			got = append(got, e.Op+" "+string(e.Before)+" "+string(e.After))
+		}
+	}
+	diff.Test(t, t.Errorf, got, []string{
+		AuditRefund + ` {"charge":"` + in.Charge + `","refunded":300} {"charge":"` + in.Charge + `","refunded":1000}`,
+		AuditRefund + ` {"charge":"` + in.Charge + `","refunded":0} {"charge":"` + in.Charge + `","refunded":300,"reason":"requested_by_customer"}`,
+	})
+}
+
+func TestCreateCreditNote(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	if err := tc.PutCustomer(ctx, "org:a", &OrgInfo{Email: "a@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	cid, err := tc.WhoIs(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := newDraft(t, tc, cid, 1000)
+
+	if _, err := tc.CreateCreditNote(ctx, "org:a", id, 100, "", ""); !errors.Is(err, ErrInvoiceStatus) {
+		t.Errorf("crediting draft: err = %v; want %v", err, ErrInvoiceStatus)
+	}
+	if _, err := tc.FinalizeInvoice(ctx, "org:a", id); err != nil {
+		t.Fatal(err)
+	}
+	var ve *ValidationError
+	if _, err := tc.CreateCreditNote(ctx, "org:a", id, 0, "", ""); !errors.As(err, &ve) {
+		t.Errorf("crediting nothing: err = %v; want *ValidationError", err)
+	}
+
+	cn, err := tc.CreateCreditNote(ctx, "org:a", id, 250, CreditNoteOrderChange, "seat removed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cn.Invoice != id || cn.Amount != 250 || cn.Reason != CreditNoteOrderChange || cn.Memo != "seat removed" {
+		t.Errorf("credit note = %+v; want 250 of %s for order change", cn, id)
+	}
+	ins, err := tc.LookupInvoices(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ins) != 1 || ins[0].AmountDue != 750 {
+		t.Errorf("invoices = %+v; want one with 750 due", ins)
+	}
+
+	// Credits for paid invoices go to the org's balance.
+	if err := tc.Stripe.Do(ctx, "POST", "/v1/invoices/"+id+"/pay", stripe.Form{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tc.CreateCreditNote(ctx, "org:a", id, 750, "", ""); err != nil {
+		t.Fatal(err)
+	}
+}
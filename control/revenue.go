@@ -0,0 +1,147 @@
+package control
+
+import (
+	"context"
+	"math"
+	"sort"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"tier.run/refs"
+	"tier.run/stripe"
+	"tier.run/values"
+)
+
+// A RevenueReport summarizes the recurring revenue of the subscriptions to
+// features pushed by tier.
+//
+// Monthly recurring revenue (MRR) is the sum of the fixed prices of the
+// features in active and past due subscriptions, normalized to a month,
+// before discounts and taxes. Metered features, which vary with usage, and
+// subscriptions in trial are not included, but orgs in trial are counted as
+// subscribers. Amounts are in the smallest unit of their currency, and
+// since amounts in different currencies cannot be summed, they are reported
+// separately for each currency.
+type RevenueReport struct {
+	Currencies []CurrencyRevenue // ordered by currency
+	Plans      []PlanRevenue     // ordered by plan name, then version
+}
+
+// CurrencyRevenue is the recurring revenue in a currency.
+type CurrencyRevenue struct {
+	Currency    string
+	MRR         int
+	ARR         int // MRR times 12
+	Subscribers int // orgs subscribed to features priced in Currency
+}
+
+// PlanRevenue is the recurring revenue of a plan.
+type PlanRevenue struct {
+	Plan        refs.Plan
+	Currency    string
+	MRR         int
+	Subscribers int // orgs subscribed to any feature of Plan
+}
+
+// monthsPerInterval is the number of months in each Stripe interval.
+var monthsPerInterval = map[string]float64{
+	"day":   12.0 / 365,
+	"week":  12.0 / 52,
+	"month": 1,
+	"year":  12,
+}
+
+// revenueTotals accumulates the revenue and subscribers of a currency or
+// plan.
+type revenueTotals struct {
+	currency string
+	mrr      float64
+	orgs     map[string]bool
+}
+
+func addRevenue[K comparable](m map[K]*revenueTotals, key K, currency, org string, mrr float64) {
+	t := m[key]
+	if t == nil {
+		t = &revenueTotals{currency: currency, orgs: map[string]bool{}}
+		m[key] = t
+	}
+	t.mrr += mrr
+	t.orgs[org] = true
+}
+
+// Revenue reports the recurring revenue of all subscriptions in Stripe.
+func (c *Client) Revenue(ctx context.Context) (*RevenueReport, error) {
+	type T struct {
+		stripe.ID
+		Customer string
+		Status   string
+		Items    struct {
+			Data []struct {
+				Quantity int
+				Price    stripePrice
+			}
+		}
+	}
+
+	byCurrency := map[string]*revenueTotals{}
+	byPlan := map[refs.Plan]*revenueTotals{}
+
+	var f stripe.Form
+	err := stripe.Iter(ctx, c.Stripe, "GET", "/v1/subscriptions", f, func(s T) bool {
+		switch s.Status {
+		case "active", "past_due", "trialing":
+		default:
+			return true
+		}
+		for _, it := range s.Items.Data {
+			p := it.Price
+			if p.Metadata.Feature.IsZero() {
+				continue // not pushed by tier
+			}
+			var mrr float64
+			if s.Status != "trialing" && p.Recurring.UsageType == "licensed" {
+				months := monthsPerInterval[p.Recurring.Interval] * float64(values.Coalesce(p.Recurring.IntervalCount, 1))
+				if months > 0 {
+					mrr = float64(p.UnitAmount*values.Coalesce(it.Quantity, 1)) / months
+				}
+			}
+			addRevenue(byCurrency, p.Currency, p.Currency, s.Customer, mrr)
+			if plan := p.Metadata.Feature.Plan(); !plan.IsZero() {
+				addRevenue(byPlan, plan, p.Currency, s.Customer, mrr)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &RevenueReport{}
+	currencies := maps.Keys(byCurrency)
+	sort.Strings(currencies)
+	for _, cur := range currencies {
+		t := byCurrency[cur]
+		mrr := int(math.Round(t.mrr))
+		r.Currencies = append(r.Currencies, CurrencyRevenue{
+			Currency:    cur,
+			MRR:         mrr,
+			ARR:         mrr * 12,
+			Subscribers: len(t.orgs),
+		})
+	}
+	for plan, t := range byPlan {
+		r.Plans = append(r.Plans, PlanRevenue{
+			Plan:        plan,
+			Currency:    t.currency,
+			MRR:         int(math.Round(t.mrr)),
+			Subscribers: len(t.orgs),
+		})
+	}
+	slices.SortFunc(r.Plans, func(a, b PlanRevenue) bool {
+		if a.Plan.Name() != b.Plan.Name() {
+			return a.Plan.Name() < b.Plan.Name()
+		}
+		return refs.CompareVersions(a.Plan.Version(), b.Plan.Version()) < 0
+	})
+	return r, nil
+}
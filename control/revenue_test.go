@@ -0,0 +1,89 @@
+package control
+
+import (
+	"context"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/refs"
+	"tier.run/values"
+)
+
+func TestRevenue(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:seat@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Base:        1000,
+	}, {
+		FeaturePlan: mpf("feature:api@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Tiers:       []Tier{{Upto: Inf, Price: values.MustParseDecimal("1")}},
+		Mode:        "graduated",
+		Aggregate:   "sum",
+	}, {
+		FeaturePlan: mpf("feature:seat@plan:pro@1"),
+		Interval:    "@yearly",
+		Currency:    "usd",
+		Base:        12000,
+	}, {
+		FeaturePlan: mpf("feature:seat@plan:trial@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Base:        500,
+		TrialDays:   14,
+	}, {
+		FeaturePlan: mpf("feature:seat@plan:eu@0"),
+		Interval:    "@monthly",
+		Currency:    "eur",
+		Base:        900,
+	}}
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := tc.Revenue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, &RevenueReport{})
+
+	for org, plan := range map[string]string{
+		"org:a": "plan:pro@0",
+		"org:b": "plan:pro@0",
+		"org:c": "plan:pro@1",
+		"org:d": "plan:trial@0",
+		"org:e": "plan:eu@0",
+	} {
+		if err := tc.SubscribeTo(ctx, org, []refs.FeaturePlan{mpf("feature:seat@" + plan)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tc.SubscribeTo(ctx, "org:a", []refs.FeaturePlan{
+		mpf("feature:seat@plan:pro@0"),
+		mpf("feature:api@plan:pro@0"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err = tc.Revenue(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, &RevenueReport{
+		Currencies: []CurrencyRevenue{
+			{Currency: "eur", MRR: 900, ARR: 10800, Subscribers: 1},
+			{Currency: "usd", MRR: 3000, ARR: 36000, Subscribers: 4},
+		},
+		Plans: []PlanRevenue{
+			{Plan: refs.MustParsePlan("plan:eu@0"), Currency: "eur", MRR: 900, Subscribers: 1},
+			{Plan: refs.MustParsePlan("plan:pro@0"), Currency: "usd", MRR: 2000, Subscribers: 2},
+			{Plan: refs.MustParsePlan("plan:pro@1"), Currency: "usd", MRR: 1000, Subscribers: 1},
+			{Plan: refs.MustParsePlan("plan:trial@0"), Currency: "usd", Subscribers: 1},
+		},
+	})
+}
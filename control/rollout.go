@@ -0,0 +1,137 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"tier.run/refs"
+	"tier.run/stripe"
+)
+
+// rolloutBucket returns the bucket, from 0 to 99, of org for the feature
+// named n. Buckets depend only on org and n, so that orgs in a rollout
+// stay in it as it grows, and when they change plans.
+func rolloutBucket(org string, n refs.Name) int {
+	h := fnv.New64a()
+	io.WriteString(h, n.String())
+	h.Write([]byte{0})
+	io.WriteString(h, org)
+	return int(h.Sum64() % 100)
+}
+
+// InRollout reports whether f is enabled for org: whether f has no
+// Rollout, or org is among the Rollout percent of orgs f is enabled for.
+func (f *Feature) InRollout(org string) bool {
+	if f.Rollout == 0 || f.Rollout >= 100 {
+		return true
+	}
+	return rolloutBucket(org, f.Name()) < f.Rollout
+}
+
+// rolloutLimit returns the limit of f for org: its Limit, or zero if f is
+// not enabled for org.
+func rolloutLimit(org string, f Feature) int {
+	if !f.InRollout(org) {
+		return 0
+	}
+	return f.Limit()
+}
+
+// SetRollout sets the Rollout of the feature fp, including its variants, to
+// percent, from 1 to 100, or removes it if percent is zero, enabling fp for
+// all orgs. Rollouts may be changed at any time, unlike the rest of a
+// pushed feature, to roll features out gradually.
+//
+// It reports a *ValidationError if percent is out of range, and
+// ErrFeatureNotFound if fp has not been pushed.
+func (c *Client) SetRollout(ctx context.Context, fp refs.FeaturePlan, percent int) error {
+	if percent < 0 || percent > 100 {
+		return &ValidationError{Message: "rollout must be from 0 to 100 percent"}
+	}
+	fs, err := c.PullWithOptions(ctx, PullOptions{Plan: fp.Plan(), Archived: true})
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, f := range fs {
+		if f.FeaturePlan != fp {
+			continue
+		}
+		found = true
+		if f.Flag {
+			continue
+		}
+		var data stripe.Form
+		data.Set("metadata", "tier.rollout", formatRollout(percent))
+		if err := c.Stripe.Do(ctx, "POST", "/v1/prices/"+f.ProviderID, data, nil); err != nil {
+			return err
+		}
+	}
+	if !found {
+		return featureNotFound("", fp.String())
+	}
+	var flags []Feature
+	for _, f := range fs {
+		if f.Flag {
+			if f.FeaturePlan == fp {
+				f.Rollout = percent
+			}
+			flags = append(flags, f)
+		}
+	}
+	if len(flags) == 0 {
+		return nil
+	}
+	rollouts := formatFlagRollouts(flags)
+	if len(rollouts) > stripe.MaxMetadataValueLen {
+		return fmt.Errorf("%w: tier.rollouts is %d characters; must not exceed %d", ErrStripeLimit, len(rollouts), stripe.MaxMetadataValueLen)
+	}
+	var data stripe.Form
+	data.Set("metadata", "tier.rollouts", rollouts)
+	return c.Stripe.Do(ctx, "POST", "/v1/products/"+stripe.MakeID(fp.Plan().String()), data, nil)
+}
+
+// formatRollout formats percent for storing in price metadata, as the empty
+// string, which removes it, if percent is zero.
+func formatRollout(percent int) string {
+	if percent == 0 {
+		return ""
+	}
+	return strconv.Itoa(percent)
+}
+
+// formatFlagRollouts formats the rollouts of flags for storing in the
+// metadata of their plan's product, as a comma separated list of
+// name=percent pairs.
+func formatFlagRollouts(flags []Feature) string {
+	var ss []string
+	for _, f := range flags {
+		if f.Rollout != 0 {
+			ss = append(ss, f.Name().String()+"="+strconv.Itoa(f.Rollout))
+		}
+	}
+	sort.Strings(ss)
+	return strings.Join(ss, ",")
+}
+
+// parseFlagRollouts parses rollouts formatted by formatFlagRollouts,
+// skipping any that are invalid.
+func parseFlagRollouts(s string) map[refs.Name]int {
+	m := map[refs.Name]int{}
+	for _, r := range strings.Split(s, ",") {
+		name, percent, _ := strings.Cut(r, "=")
+		n, err := refs.ParseName(name)
+		if err != nil {
+			continue
+		}
+		if p, err := strconv.Atoi(percent); err == nil && p > 0 && p <= 100 {
+			m[n] = p
+		}
+	}
+	return m
+}
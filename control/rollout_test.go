@@ -0,0 +1,107 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"tier.run/refs"
+)
+
+func TestRolloutBucket(t *testing.T) {
+	n := mpn("feature:x")
+	in := 0
+	for i := 0; i < 1000; i++ {
+		f := Feature{FeaturePlan: n.WithPlan(mpp("plan:a@0")), Rollout: 30}
+		g := Feature{FeaturePlan: n.WithPlan(mpp("plan:b@1")), Rollout: 60}
+		org := fmt.Sprintf("org:%d", i)
+		if f.InRollout(org) {
+			in++
+			if !g.InRollout(org) {
+				t.Errorf("%s in 30%% rollout of %s, but not 60%% of %s", org, f.FeaturePlan, g.FeaturePlan)
+			}
+		}
+	}
+	if in < 250 || in > 350 {
+		t.Errorf("%d of 1000 orgs in 30%% rollout; want about 300", in)
+	}
+}
+
+func TestSetRollout(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Base:        100,
+		Rollout:     50,
+	}, {
+		FeaturePlan: mpf("feature:sso@plan:pro@0"),
+		Flag:        true,
+		Rollout:     50,
+	}}
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	pulled, err := tc.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range pulled {
+		if f.Rollout != 50 {
+			t.Errorf("pulled %s with rollout %d; want 50", f.FeaturePlan, f.Rollout)
+		}
+	}
+
+	// Find an org in the rollout of both features, and one in neither.
+	var in, out string
+	for i := 0; in == "" || out == ""; i++ {
+		org := fmt.Sprintf("org:%d", i)
+		switch {
+		case in == "" && fs[0].InRollout(org) && fs[1].InRollout(org):
+			in = org
+		case out == "" && !fs[0].InRollout(org) && !fs[1].InRollout(org):
+			out = org
+		}
+	}
+	for _, org := range []string{in, out} {
+		if err := tc.SubscribeTo(ctx, org, []refs.FeaturePlan{fs[0].FeaturePlan}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func(org string, want int) {
+		t.Helper()
+		usage, err := tc.LookupLimits(ctx, org)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(usage) != 2 {
+			t.Fatalf("limits of %s = %v; want 2", org, usage)
+		}
+		for _, u := range usage {
+			if u.Limit != want {
+				t.Errorf("limit of %s for %s = %d; want %d", org, u.Feature, u.Limit, want)
+			}
+		}
+	}
+	check(in, Inf)
+	check(out, 0)
+
+	var ve *ValidationError
+	if err := tc.SetRollout(ctx, fs[0].FeaturePlan, 101); !errors.As(err, &ve) {
+		t.Errorf("setting rollout 101: err = %v; want *ValidationError", err)
+	}
+	if err := tc.SetRollout(ctx, mpf("feature:y@plan:pro@0"), 10); !errors.Is(err, ErrFeatureNotFound) {
+		t.Errorf("setting rollout of unknown feature: err = %v; want %v", err, ErrFeatureNotFound)
+	}
+	for _, f := range fs {
+		if err := tc.SetRollout(ctx, f.FeaturePlan, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	check(out, Inf)
+}
@@ -9,10 +9,10 @@ import (
 
 	"github.com/kr/pretty"
 	"golang.org/x/exp/slices"
-	"golang.org/x/sync/errgroup"
 	"kr.dev/errorfmt"
 	"tier.run/refs"
 	"tier.run/stripe"
+	"tier.run/trace"
 	"tier.run/values"
 )
 
@@ -26,20 +26,154 @@ var (
 	ErrOrgNotFound     = errors.New("org not found")
 	ErrInvalidMetadata = errors.New("invalid metadata")
 	ErrInvalidPhase    = errors.New("invalid phase")
+
+	// ErrScheduleConflict is returned by Schedule if the schedule of the
+	// org changed while Schedule was updating it, and by ScheduleNow if
+	// it kept changing.
+	ErrScheduleConflict = errors.New("schedule changed while being updated")
 )
 
+// scheduleAttempts is the most times ScheduleNow reads and updates the
+// schedule of an org that changes as it is updated.
+const scheduleAttempts = 3
+
+// anyVersion is passed to scheduleAt to update the schedule of an org
+// from whatever version it is at.
+const anyVersion = -1
+
 type ValidationError struct {
 	Message string
 }
 
 func (e *ValidationError) Error() string { return e.Message }
 
+// An OrgError is an error caused by a phase or feature requested for an
+// org, such as a feature not found or a phase Stripe cannot bill. It wraps
+// the error describing the kind of failure, one of ErrFeatureNotFound,
+// ErrInvalidPhase, or ErrTooManyItems, so it may be tested for with
+// errors.Is, and carries what is known of the request at fault, so callers
+// may say precisely what to fix.
+type OrgError struct {
+	Org     string // the org, if known
+	Phase   int    // the index of the phase at fault, or -1 if not known
+	Feature string // the feature or feature plan at fault, if any
+	Reason  string // why the phase or feature is at fault, if more is known
+	Err     error
+}
+
+func (e *OrgError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Err.Error())
+	if e.Phase >= 0 {
+		fmt.Fprintf(&b, ": phase %d", e.Phase)
+	}
+	if e.Feature != "" {
+		b.WriteString(": " + e.Feature)
+	}
+	if e.Reason != "" {
+		b.WriteString(": " + e.Reason)
+	}
+	return b.String()
+}
+
+func (e *OrgError) Unwrap() error { return e.Err }
+
+// A CanceledError reports that changes to an org were stopped partway by
+// the cancellation of their context. The changes made before then are not
+// undone.
+type CanceledError struct {
+	Org  string
+	Done []string // the changes made, in order, such as "updated org info"
+	Err  error    // the error of the context
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("changes to %s canceled after %s: %v", e.Org, strings.Join(e.Done, ", "), e.Err)
+}
+
+func (e *CanceledError) Unwrap() error { return e.Err }
+
+// canceled returns a *CanceledError reporting the changes done to org, in
+// place of err, if ctx is done and any changes were made, unless err is one
+// already; otherwise it returns err.
+func canceled(ctx context.Context, org string, done []string, err error) error {
+	if ctx.Err() == nil || len(done) == 0 {
+		return err
+	}
+	var e *CanceledError
+	if errors.As(err, &e) {
+		return err
+	}
+	return &CanceledError{Org: org, Done: done, Err: ctx.Err()}
+}
+
+// featureNotFound returns an *OrgError wrapping ErrFeatureNotFound for the
+// feature of org, which may be empty if not known.
+func featureNotFound(org, feature string) error {
+	return &OrgError{Org: org, Phase: -1, Feature: feature, Err: ErrFeatureNotFound}
+}
+
+// invalidPhase returns an *OrgError wrapping ErrInvalidPhase for phase i,
+// caused by feature, if not zero, for reason.
+func invalidPhase(i int, feature refs.FeaturePlan, reason string) error {
+	e := &OrgError{Phase: i, Reason: reason, Err: ErrInvalidPhase}
+	if !feature.IsZero() {
+		e.Feature = feature.String()
+	}
+	return e
+}
+
+// orgError sets the org, and the phase, if not known, of the *OrgError in
+// err, if any.
+func orgError(err error, org string, phase int) {
+	var e *OrgError
+	if !errors.As(err, &e) {
+		return
+	}
+	if e.Org == "" {
+		e.Org = org
+	}
+	if e.Phase < 0 {
+		e.Phase = phase
+	}
+}
+
 type OrgInfo struct {
 	Email       string
 	Name        string
 	Description string
 	Phone       string
 	Metadata    map[string]string
+
+	// Credit is the credit balance of the org, in the smallest unit of
+	// CreditCurrency, applied to its next invoices. It is negative if
+	// the org owes an amount to be added to its next invoice. Credit and
+	// CreditCurrency are set by LookupOrg, and ignored on write; use
+	// AdjustCredit to change them.
+	Credit         int    `json:",omitempty"`
+	CreditCurrency string `json:",omitempty"`
+
+	// Parent is the org the usage of the org is billed to, if any. It is
+	// set by LookupOrg, and ignored on write; use SetParent to change it.
+	Parent string `json:",omitempty"`
+
+	// The billing state of the org, set by LookupOrg and ignored on
+	// write, such as to ask orgs to update their payment details.
+	//
+	// SubscriptionStatus is the Stripe status of the org's subscription,
+	// such as "active", "trialing", or "past_due", or empty if it has
+	// none. PeriodEnd is when its current billing period ends. Delinquent
+	// reports whether the last payment of an invoice of the org failed.
+	// HasPaymentMethod reports whether the org has a default payment
+	// method to pay its invoices with. CancelAtPeriodEnd and Paused
+	// report whether the subscription is canceled at PeriodEnd, and
+	// whether collecting its payments is paused; see Cancel and Pause.
+	SubscriptionStatus string     `json:",omitempty"`
+	PeriodEnd          *time.Time `json:",omitempty"`
+	Delinquent         bool       `json:",omitempty"`
+	HasPaymentMethod   bool       `json:",omitempty"`
+	CancelAtPeriodEnd  bool       `json:",omitempty"`
+	Paused             bool       `json:",omitempty"`
 }
 
 type Phase struct {
@@ -48,18 +182,47 @@ type Phase struct {
 	Features  []refs.FeaturePlan
 	Current   bool
 
+	// Interval, if set, selects the billing interval of the phase. Each
+	// feature is billed using its variant for Interval, or its default
+	// price if that is billed at Interval. If empty, default prices are
+	// used. On read, it is set if any feature is billed using a variant.
+	Interval string
+
+	// TrialEnd, if set, is when the free trial that begins with the phase
+	// ends. On read, it is set for phases with trials.
+	TrialEnd time.Time
+
 	// Plans is the set of plans that are currently active for the phase. A
 	// plan is considered active in a phase if all of its features are
 	// listed in the phase. If any features from a plan is in the phase
 	// without the other features in the plan, this phase is considered
 	// "fragmented".
 	Plans []refs.Plan
+
+	// Canceled is set on read for phases of canceled subscriptions. See
+	// LookupPhaseHistory.
+	Canceled bool
 }
 
+// Fragments returns the features in p from plans that are not wholly in
+// p. Add-ons are not fragments; see AddOns.
 func (p *Phase) Fragments() []refs.FeaturePlan {
 	var fs []refs.FeaturePlan
 	for _, f := range p.Features {
-		if !slices.Contains(p.Plans, f.Plan()) {
+		if !f.Plan().IsZero() && !slices.Contains(p.Plans, f.Plan()) {
+			fs = append(fs, f)
+		}
+	}
+	return fs
+}
+
+// AddOns returns the features in p that are not in any plan, such as
+// "feature:support@1". Add-ons are subscribed to alongside plans, and
+// are billed like features in plans.
+func (p *Phase) AddOns() []refs.FeaturePlan {
+	var fs []refs.FeaturePlan
+	for _, f := range p.Features {
+		if f.Plan().IsZero() {
 			fs = append(fs, f)
 		}
 	}
@@ -70,7 +233,25 @@ type subscription struct {
 	ID         string
 	ScheduleID string
 	Name       string
-	Features   []Feature
+
+	// ScheduleVersion is the number of times the schedule has been
+	// updated by tier, to detect updates made since it was read.
+	ScheduleVersion int
+
+	Features    []Feature
+	Status      string
+	PeriodStart time.Time // the start of the current period
+	PeriodEnd   time.Time // the end of the current period
+
+	// CancelAtPeriodEnd reports whether the subscription is canceled at
+	// the end of its current period. Paused reports whether collecting
+	// payments for it is paused.
+	CancelAtPeriodEnd bool
+	Paused            bool
+
+	// PaymentMethod is the default payment method of the subscription,
+	// if it overrides the customer's.
+	PaymentMethod string
 }
 
 func (c *Client) lookupSubscription(ctx context.Context, org, name string) (subscription, error) {
@@ -81,10 +262,21 @@ func (c *Client) lookupSubscription(ctx context.Context, org, name string) (subs
 
 	var f stripe.Form
 	f.Set("customer", cid)
-	f.Add("expand[]", "data.schedule")
+	f.Expand("data.schedule")
 
 	type T struct {
 		stripe.ID
+		Status               string
+		CurrentPeriodStart   int64  `json:"current_period_start"`
+		CurrentPeriodEnd     int64  `json:"current_period_end"`
+		DefaultPaymentMethod string `json:"default_payment_method"`
+		CancelAtPeriodEnd    bool   `json:"cancel_at_period_end"`
+		PauseCollection      *struct {
+			Behavior string
+		} `json:"pause_collection"`
+		Metadata struct {
+			Name string `json:"tier.subscription"`
+		}
 		Items struct {
 			Data []struct {
 				ID    string
@@ -94,7 +286,8 @@ func (c *Client) lookupSubscription(ctx context.Context, org, name string) (subs
 		Schedule struct {
 			ID       string
 			Metadata struct {
-				Name string `json:"tier.subscription"`
+				Name    string `json:"tier.subscription"`
+				Version int    `json:"tier.version,string"`
 			}
 		}
 	}
@@ -110,8 +303,10 @@ func (c *Client) lookupSubscription(ctx context.Context, org, name string) (subs
 	// NOTE: we can't cache the schedule information because it changes
 	// over time.
 
+	// Subscriptions released from their schedule, such as to cancel them
+	// at period end, keep their name in their own metadata.
 	v, err := stripe.List[T](ctx, c.Stripe, "GET", "/v1/subscriptions", f).Find(func(s T) bool {
-		return s.Schedule.Metadata.Name == name
+		return s.Schedule.Metadata.Name == name || s.Schedule.ID == "" && s.Metadata.Name == name
 	})
 
 	var fs []Feature
@@ -126,9 +321,18 @@ func (c *Client) lookupSubscription(ctx context.Context, org, name string) (subs
 		return subscription{}, err
 	}
 	s := subscription{
-		ID:         v.ProviderID(),
-		ScheduleID: v.Schedule.ID,
-		Features:   fs,
+		ID:              v.ProviderID(),
+		ScheduleID:      v.Schedule.ID,
+		ScheduleVersion: v.Schedule.Metadata.Version,
+		Features:        fs,
+		Status:          v.Status,
+		PeriodStart:     time.Unix(v.CurrentPeriodStart, 0),
+		PeriodEnd:       time.Unix(v.CurrentPeriodEnd, 0),
+
+		CancelAtPeriodEnd: v.CancelAtPeriodEnd,
+		Paused:            v.PauseCollection != nil,
+
+		PaymentMethod: v.DefaultPaymentMethod,
 	}
 	return s, nil
 }
@@ -164,36 +368,48 @@ func (c *Client) createSchedule(ctx context.Context, org, name string, fromSub s
 		if err != nil {
 			return err
 		}
-		return c.updateSchedule(ctx, sid, name, phases)
+		return c.updateSchedule(ctx, sid, name, 0, phases)
 	} else {
 		var f stripe.Form
 		f.Set("customer", cid)
 		f.Set("metadata[tier.subscription]", name)
 		for i, p := range phases {
-			fs, err := c.lookupFeatures(ctx, p.Features)
+			fs, err := c.lookupFeatures(ctx, p.Features, p.Interval)
 			if err != nil {
+				orgError(err, "", i)
+				return err
+			}
+			if err := checkPhaseFeatures(i, fs); err != nil {
 				return err
 			}
 
 			if i == 0 {
 				f.Set("start_date", nowOrSpecific(p.Effective))
 			}
+			if !p.TrialEnd.IsZero() {
+				f.Set("phases", i, "trial_end", p.TrialEnd)
+			}
 
 			if i > 0 && i < len(phases)-1 {
 				f.Set("phases", i-1, "end_date", nowOrSpecific(p.Effective))
 			}
 
-			for j, fe := range fs {
-				c.Logf("phase %d, item %d: %v", i, j, fe)
-				f.Set("phases", i, "items", j, "price", fe.ProviderID)
-			}
+			c.setPhaseItems(&f, i, fs)
 		}
 		_, err := do(f)
 		return err
 	}
 }
 
-func (c *Client) updateSchedule(ctx context.Context, id, name string, phases []Phase) (err error) {
+// updateSchedule replaces the phases of the schedule id, read at version,
+// and names it name, if not empty. It returns ErrScheduleConflict if the
+// schedule was updated since it was read at version.
+//
+// Stripe cannot update a schedule only if it is unchanged, so each update
+// is made with an idempotency key naming the version it is made from, and
+// records the next version. Of two updates made from the same version, the
+// second fails, unless it is the same update.
+func (c *Client) updateSchedule(ctx context.Context, id, name string, version int, phases []Phase) (err error) {
 	defer errorfmt.Handlef("stripe: updateSchedule: %q: %w", id, &err)
 
 	if id == "" {
@@ -215,12 +431,16 @@ func (c *Client) updateSchedule(ctx context.Context, id, name string, phases []P
 			return fmt.Errorf("phase %d must contain at least one feature", i)
 		}
 
-		fs, err := c.lookupFeatures(ctx, p.Features)
+		fs, err := c.lookupFeatures(ctx, p.Features, p.Interval)
 		if err != nil {
+			orgError(err, "", i)
 			return err
 		}
 		if len(fs) != len(p.Features) {
-			return ErrFeatureNotFound
+			return &OrgError{Phase: i, Feature: missingFeature(p.Features, fs), Err: ErrFeatureNotFound}
+		}
+		if err := checkPhaseFeatures(i, fs); err != nil {
+			return err
 		}
 
 		if i == 0 {
@@ -229,33 +449,124 @@ func (c *Client) updateSchedule(ctx context.Context, id, name string, phases []P
 			f.Set("phases", i-1, "end_date", nowOrSpecific(p.Effective))
 			f.Set("phases", i, "start_date", nowOrSpecific(p.Effective))
 		}
-		for j, fe := range fs {
-			f.Set("phases", i, "items", j, "price", fe.ProviderID)
+		if !p.TrialEnd.IsZero() {
+			f.Set("phases", i, "trial_end", p.TrialEnd)
+		}
+		c.setPhaseItems(&f, i, fs)
+	}
+	f.Set("metadata[tier.version]", version+1)
+	f.SetIdempotencyKey(fmt.Sprintf("schedule:update:%s:%d", id, version))
+	err = c.Stripe.Do(ctx, "POST", "/v1/subscription_schedules/"+id, f, nil)
+	if errors.Is(err, stripe.ErrIdempotency) {
+		return ErrScheduleConflict
+	}
+	if err != nil {
+		// Stripe may keep the failed response for the key of version,
+		// so move past it, or no update from version could be made.
+		var bump stripe.Form
+		bump.Set("metadata[tier.version]", version+1)
+		bump.SetIdempotencyKey(fmt.Sprintf("schedule:bump:%s:%d", id, version))
+		if err := c.Stripe.Do(ctx, "POST", "/v1/subscription_schedules/"+id, bump, nil); err != nil {
+			c.Logf("tier: moving schedule %s past version %d: %v", id, version, err)
 		}
 	}
-	return c.Stripe.Do(ctx, "POST", "/v1/subscription_schedules/"+id, f, nil)
+	return err
 }
 
-func (c *Client) Schedule(ctx context.Context, org string, info *OrgInfo, phases []Phase) (err error) {
-	err = c.schedule(ctx, org, info, phases)
+// checkPhaseFeatures reports an error wrapping ErrInvalidPhase if the
+// features of phase i do not share a currency, or its recurring features
+// do not share an interval, as Stripe requires. This is most likely when
+// mixing a plan with add-ons.
+func checkPhaseFeatures(i int, fs []Feature) error {
+	var currency, interval string
+	for _, f := range fs {
+		if currency == "" {
+			currency = f.Currency
+		} else if f.Currency != currency {
+			return invalidPhase(i, f.FeaturePlan, fmt.Sprintf("billed in %q, not %q", f.Currency, currency))
+		}
+		if f.OneTime {
+			continue
+		}
+		if interval == "" {
+			interval = f.Interval
+		} else if f.Interval != interval {
+			return invalidPhase(i, f.FeaturePlan, fmt.Sprintf("billed %s, not %s", f.Interval, interval))
+		}
+	}
+	return nil
+}
+
+// setPhaseItems sets the prices of fs as the items of phase i in f.
+// One-time prices are set as invoice items instead, which Stripe bills once
+// when the phase begins, and not again if the phase is later updated.
+func (c *Client) setPhaseItems(f *stripe.Form, i int, fs []Feature) {
+	var items, invoiceItems int
+	for _, fe := range fs {
+		if fe.OneTime {
+			c.Logf("phase %d, invoice item %d: %v", i, invoiceItems, fe)
+			f.Set("phases", i, "add_invoice_items", invoiceItems, "price", fe.ProviderID)
+			invoiceItems++
+		} else {
+			c.Logf("phase %d, item %d: %v", i, items, fe)
+			f.Set("phases", i, "items", items, "price", fe.ProviderID)
+			items++
+		}
+	}
+}
+
+// Schedule replaces the phases of the subscription of org with phases, and
+// updates its info, if not nil.
+//
+// If the schedule of org is updated by another client while Schedule is
+// updating it, Schedule returns ErrScheduleConflict rather than silently
+// replacing the other update. Callers that made phases from the schedule,
+// as ScheduleNow does, should read it again before retrying.
+func (c *Client) Schedule(ctx context.Context, org string, info *OrgInfo, phases []Phase) error {
+	return c.scheduleAt(ctx, org, info, phases, anyVersion)
+}
+
+// scheduleAt is like Schedule, but returns ErrScheduleConflict if the
+// schedule of org is not at version, unless version is anyVersion.
+func (c *Client) scheduleAt(ctx context.Context, org string, info *OrgInfo, phases []Phase, version int) (err error) {
+	ctx, span := trace.Start(ctx, c.Tracer, "control.Schedule", trace.String("tier.org", org))
+	defer trace.End(span, &err)
+	defer c.observe("tier_schedule_duration_seconds", &err)()
+	defer c.forgetCustomerOnError(org, &err)
+	defer c.audit(ctx, AuditSchedule, org, func() (any, error) {
+		ps, err := c.LookupPhases(ctx, org)
+		return auditSchedule{Phases: auditPhases(ps)}, err
+	}, auditSchedule{Phases: auditPhases(phases), Info: info}, &err)()
+
+	err = c.schedule(ctx, org, info, phases, version)
 	var e *stripe.Error
 	if errors.As(err, &e) && strings.Contains(e.Message, "maximum number of items") {
-		return ErrTooManyItems
+		return &OrgError{Org: org, Phase: -1, Reason: e.Message, Err: ErrTooManyItems}
+	}
+	orgError(err, org, -1)
+	if err == nil && c.Notifier != nil && len(phases) > 0 {
+		c.Notifier.OnSubscribe(ctx, org, phases)
 	}
 	return err
 }
 
-func (c *Client) schedule(ctx context.Context, org string, info *OrgInfo, phases []Phase) (err error) {
+func (c *Client) schedule(ctx context.Context, org string, info *OrgInfo, phases []Phase, version int) (err error) {
 	defer errorfmt.Handlef("tier: schedule: %q: %w", org, &err)
+	var done []string
+	defer func() {
+		if err != nil {
+			err = canceled(ctx, org, done, err)
+		}
+	}()
 
 	c.Logf("Subscribe phases: %# v", pretty.Formatter(phases))
 
 	for i, p := range phases {
 		if len(p.Features) > 20 {
-			return ErrTooManyItems
+			return &OrgError{Phase: i, Reason: fmt.Sprintf("%d features exceed the limit of 20", len(p.Features)), Err: ErrTooManyItems}
 		}
 		if len(p.Features) == 0 {
-			return fmt.Errorf("%w: phase %d must contain a minimum of one item", ErrInvalidPhase, i)
+			return invalidPhase(i, refs.FeaturePlan{}, "must contain a minimum of one item")
 		}
 	}
 
@@ -263,6 +574,16 @@ func (c *Client) schedule(ctx context.Context, org string, info *OrgInfo, phases
 		if _, err := c.putCustomer(ctx, org, info); err != nil {
 			return err
 		}
+		if len(phases) == 0 {
+			// Only the info of org is updated. Its subscription,
+			// if any, is left as it is, even if canceled at period
+			// end.
+			return nil
+		}
+		done = append(done, "updated org info")
+		if err := ctx.Err(); err != nil {
+			return err
+		}
 	}
 
 	s, err := c.lookupSubscription(ctx, org, scheduleNameTODO)
@@ -280,7 +601,19 @@ func (c *Client) schedule(ctx context.Context, org string, info *OrgInfo, phases
 	if err != nil {
 		return err
 	}
-	err = c.updateSchedule(ctx, s.ScheduleID, scheduleNameTODO, phases)
+	if s.ScheduleID == "" {
+		// The subscription was released from its schedule to be
+		// canceled at period end, so scheduling it again resumes it.
+		if err := c.setCancelAtPeriodEnd(ctx, s.ID, scheduleNameTODO, false); err != nil {
+			return err
+		}
+		done = append(done, "resumed subscription")
+		return c.createSchedule(ctx, org, scheduleNameTODO, s.ID, info, phases)
+	}
+	if version != anyVersion && version != s.ScheduleVersion {
+		return ErrScheduleConflict
+	}
+	err = c.updateSchedule(ctx, s.ScheduleID, scheduleNameTODO, s.ScheduleVersion, phases)
 	if isReleased(err) {
 		return c.createSchedule(ctx, org, scheduleNameTODO, s.ScheduleID, info, phases)
 	}
@@ -305,37 +638,268 @@ func isReleased(err error) bool {
 //
 // The first phase must have a zero Effective time to indicate that it should
 // start now.
-func (c *Client) ScheduleNow(ctx context.Context, org string, info *OrgInfo, phases []Phase) error {
-	if len(phases) > 0 {
-		if !phases[0].Effective.IsZero() {
-			return errors.New("first phase must be effective now")
+//
+// If the first phase has no TrialEnd, and includes plans with TrialDays
+// that org has never been subscribed to by ScheduleNow, it begins with a
+// free trial as long as the longest of them. A trial still in progress in
+// the current phase is kept.
+//
+// If the schedule of org is updated by another client while ScheduleNow is
+// updating it, ScheduleNow reads the current phase again and retries,
+// rather than silently replacing the other update, and returns
+// ErrScheduleConflict if it keeps changing.
+//
+// ScheduleNow makes no further changes once ctx is canceled. If it is
+// canceled after some changes were made, it returns a *CanceledError
+// reporting them.
+func (c *Client) ScheduleNow(ctx context.Context, org string, info *OrgInfo, phases []Phase) (err error) {
+	ctx, span := trace.Start(ctx, c.Tracer, "control.ScheduleNow", trace.String("tier.org", org))
+	defer trace.End(span, &err)
+	defer c.forgetCustomerOnError(org, &err)
+
+	if len(phases) == 0 {
+		return c.Schedule(ctx, org, info, phases)
+	}
+	if !phases[0].Effective.IsZero() {
+		return errors.New("first phase must be effective now")
+	}
+	p0 := phases[0]
+	var trials []string
+	for i := 0; ; i++ {
+		trials, err = c.scheduleNow(ctx, org, info, p0, phases)
+		if !errors.Is(err, ErrScheduleConflict) || i+1 == scheduleAttempts {
+			break
 		}
-		cps, err := c.LookupPhases(ctx, org)
-		if err != nil && !errors.Is(err, ErrOrgNotFound) {
-			return err
+		c.Logf("tier: schedule of %q changed while updating it; retrying", org)
+	}
+	if err != nil {
+		return err
+	}
+	if err := c.recordTrials(ctx, org, trials); err != nil {
+		var done []string
+		if info != nil {
+			done = append(done, "updated org info")
+		}
+		done = append(done, "scheduled phases")
+		return canceled(ctx, org, done, err)
+	}
+	return nil
+}
+
+// scheduleNow makes phases[0] from p0 and the current phase of org, and
+// schedules phases, as ScheduleNow does, once. It returns the trials to
+// record, as startTrial does. It reports ErrScheduleConflict if the
+// schedule of org changed since the current phase was read.
+func (c *Client) scheduleNow(ctx context.Context, org string, info *OrgInfo, p0 Phase, phases []Phase) ([]string, error) {
+	// The version is read before the phases, so that an update made
+	// after the phases are read is seen as a conflict.
+	version := anyVersion
+	s, err := c.lookupSubscription(ctx, org, scheduleNameTODO)
+	switch {
+	case err == nil:
+		if s.ScheduleID != "" {
+			version = s.ScheduleVersion
 		}
-		for _, p := range cps {
-			if p.Current {
-				p0 := phases[0]
-				p.Features = p0.Features
-				phases[0] = p
-				break
+	case errors.Is(err, ErrOrgNotFound), errors.Is(err, stripe.ErrNotFound):
+	default:
+		return nil, err
+	}
+
+	cps, err := c.LookupPhases(ctx, org)
+	if err != nil && !errors.Is(err, ErrOrgNotFound) {
+		return nil, err
+	}
+	phases[0] = p0
+	for _, p := range cps {
+		if p.Current {
+			p.Features = p0.Features
+			p.Interval = p0.Interval
+			if !p0.TrialEnd.IsZero() {
+				p.TrialEnd = p0.TrialEnd
 			}
+			phases[0] = p
+			break
 		}
 	}
-	return c.Schedule(ctx, org, info, phases)
+	trials, err := c.startTrial(ctx, org, &phases[0])
+	if err != nil {
+		return nil, err
+	}
+	if err := c.scheduleAt(ctx, org, info, phases, version); err != nil {
+		return nil, err
+	}
+	return trials, nil
+}
+
+// startTrial sets the TrialEnd of p, unless a trial is already in
+// progress, for the longest trial offered by the plans in p that org has
+// not been given a trial of before. It returns the names of all plans org
+// has been given trials of, including those started, or nil if no trial
+// was started.
+func (c *Client) startTrial(ctx context.Context, org string, p *Phase) ([]string, error) {
+	if len(p.Features) == 0 {
+		return nil, nil
+	}
+	now, err := c.now(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+	if p.TrialEnd.After(now) {
+		return nil, nil
+	}
+	p.TrialEnd = time.Time{} // the trial has ended
+
+	fs, err := c.lookupFeatures(ctx, p.Features, p.Interval)
+	if err != nil {
+		orgError(err, org, 0)
+		return nil, err
+	}
+	trials, err := c.lookupTrials(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+	n, days := len(trials), 0
+	for _, f := range fs {
+		name := f.Plan().Name()
+		if f.TrialDays < 1 || f.Plan().IsZero() || slices.Contains(trials[:n], name) {
+			continue
+		}
+		if !slices.Contains(trials, name) {
+			trials = append(trials, name)
+		}
+		if f.TrialDays > days {
+			days = f.TrialDays
+		}
+	}
+	if days == 0 {
+		return nil, nil
+	}
+	p.TrialEnd = now.AddDate(0, 0, days)
+	return trials, nil
+}
+
+// lookupTrials returns the names of the plans org has been given trials
+// of, as recorded by recordTrials.
+func (c *Client) lookupTrials(ctx context.Context, org string) ([]string, error) {
+	cid, err := c.WhoIs(ctx, org)
+	if errors.Is(err, ErrOrgNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var v struct {
+		Metadata struct {
+			Trials string `json:"tier.trials"`
+		}
+	}
+	if err := c.Stripe.Do(ctx, "GET", "/v1/customers/"+cid, stripe.Form{}, &v); err != nil {
+		return nil, err
+	}
+	if v.Metadata.Trials == "" {
+		return nil, nil
+	}
+	return strings.Split(v.Metadata.Trials, ","), nil
+}
+
+// recordTrials records in the metadata of org's customer that org has been
+// given trials of the plans named in trials. It does nothing if trials is
+// empty.
+func (c *Client) recordTrials(ctx context.Context, org string, trials []string) error {
+	if len(trials) == 0 {
+		return nil
+	}
+	cid, err := c.WhoIs(ctx, org)
+	if err != nil {
+		return err
+	}
+	var f stripe.Form
+	f.Set("metadata[tier.trials]", strings.Join(trials, ","))
+	return c.Stripe.Do(ctx, "POST", "/v1/customers/"+cid, f, nil)
+}
+
+// now returns the present time of the test clock org is attached to, or
+// else of the client's test clock, if any, or else the current time.
+func (c *Client) now(ctx context.Context, org string) (time.Time, error) {
+	if c.Live() {
+		return time.Now(), nil
+	}
+	clock, err := c.orgClock(ctx, org)
+	if err != nil && !errors.Is(err, ErrOrgNotFound) {
+		return time.Time{}, err
+	}
+	clock = values.Coalesce(clock, c.Clock)
+	if clock == "" {
+		return time.Now(), nil
+	}
+	clk, err := c.SyncClock(ctx, clock)
+	return clk.Present, err
 }
 
 // SubscribeTo subscribes org to the provided features effective immediately,
 // taking over any in-progress schedule. The customer is billed immediately
 // with prorations if any.
+//
+// Features in the latest version of a plan, such as
+// "feature:x@plan:pro@latest", are resolved to the highest pushed version of
+// the plan.
 func (c *Client) SubscribeTo(ctx context.Context, org string, fs []refs.FeaturePlan) error {
+	if slices.IndexFunc(fs, func(fp refs.FeaturePlan) bool { return fp.Plan().IsLatest() }) >= 0 {
+		m, err := c.Pull(ctx, 0)
+		if err != nil {
+			return err
+		}
+		fs = slices.Clone(fs)
+		for i, fp := range fs {
+			if fs[i], err = resolveFeaturePlan(m, fp); err != nil {
+				return err
+			}
+		}
+	}
 	return c.ScheduleNow(ctx, org, nil, []Phase{{
 		Features: fs,
 	}})
 }
 
-func (c *Client) lookupFeatures(ctx context.Context, keys []refs.FeaturePlan) ([]Feature, error) {
+// SubscribeToInterval is like SubscribeTo, but bills org at interval,
+// using the variants of features billed at interval where their plans'
+// intervals differ. See Phase.Interval.
+func (c *Client) SubscribeToInterval(ctx context.Context, org, interval string, fs []refs.FeaturePlan) error {
+	if _, ok := intervalToStripe[interval]; !ok {
+		return &OrgError{Org: org, Phase: 0, Reason: fmt.Sprintf("unknown interval %q", interval), Err: ErrInvalidPhase}
+	}
+	if slices.IndexFunc(fs, func(fp refs.FeaturePlan) bool { return fp.Plan().IsLatest() }) >= 0 {
+		m, err := c.Pull(ctx, 0)
+		if err != nil {
+			return err
+		}
+		fs = slices.Clone(fs)
+		for i, fp := range fs {
+			if fs[i], err = resolveFeaturePlan(m, fp); err != nil {
+				return err
+			}
+		}
+	}
+	return c.ScheduleNow(ctx, org, nil, []Phase{{
+		Features: fs,
+		Interval: interval,
+	}})
+}
+
+// missingFeature returns the first of keys not in fs, or the empty string
+// if there is none.
+func missingFeature(keys []refs.FeaturePlan, fs []Feature) string {
+	for _, k := range keys {
+		if slices.IndexFunc(fs, func(f Feature) bool { return f.FeaturePlan == k }) < 0 {
+			return k.String()
+		}
+	}
+	return ""
+}
+
+// lookupFeatures looks up the features for keys, billed at interval as
+// described by Phase.Interval.
+func (c *Client) lookupFeatures(ctx context.Context, keys []refs.FeaturePlan, interval string) ([]Feature, error) {
 	if len(keys) == 0 {
 		return nil, errors.New("lookupFeatures: no features provided")
 	}
@@ -344,31 +908,62 @@ func (c *Client) lookupFeatures(ctx context.Context, keys []refs.FeaturePlan) ([
 		// TODO(bmizerany): return error if len(keys) == 0. No keys means
 		// stripe returns all known prices.
 		var f stripe.Form
-		f.Add("expand[]", "data.tiers")
+		f.Expand("data.tiers")
 		for _, k := range keys {
 			f.Add("lookup_keys[]", stripe.MakeID(k.String()))
+			if interval != "" {
+				v := Feature{FeaturePlan: k, Interval: interval, Variant: true}
+				f.Add("lookup_keys[]", v.ID())
+			}
 		}
 		pp, err := stripe.Slurp[stripePrice](ctx, c.Stripe, "GET", "/v1/prices", f)
 		if err != nil {
 			return nil, err
 		}
 
-		if len(pp) != len(keys) {
-			// TODO(bmizerany): return a more specific error with omitted features
-			return nil, ErrFeatureNotFound
+		if interval == "" {
+			fs := make([]Feature, len(pp))
+			for i, p := range pp {
+				fs[i] = stripePriceToFeature(p)
+			}
+			if len(fs) != len(keys) {
+				return nil, featureNotFound("", missingFeature(keys, fs))
+			}
+			return fs, nil
 		}
 
-		fs := make([]Feature, len(pp))
-		for i, p := range pp {
-			fs[i] = stripePriceToFeature(p)
+		// Prefer variants, falling back to default prices billed at
+		// interval. One-time prices are billed once at any interval.
+		byKey := map[refs.FeaturePlan]Feature{}
+		for _, p := range pp {
+			f := stripePriceToFeature(p)
+			if f.Interval != interval && !f.OneTime {
+				continue
+			}
+			if _, ok := byKey[f.FeaturePlan]; !ok || f.Variant {
+				byKey[f.FeaturePlan] = f
+			}
+		}
+		fs := make([]Feature, 0, len(keys))
+		for _, k := range keys {
+			f, ok := byKey[k]
+			if !ok {
+				return nil, &OrgError{Phase: -1, Feature: k.String(), Reason: "no price billed " + interval, Err: ErrFeatureNotFound}
+			}
+			fs = append(fs, f)
 		}
 		return fs, nil
 	}
 
+	// Stripe allows at most 10 lookup keys per request, and each key may
+	// be looked up along with its variant.
+	per := 10
+	if interval != "" {
+		per = 5
+	}
 	var fs []Feature
-	// lookup 10 keys at a time
 	for len(keys) > 0 {
-		n := 10
+		n := per
 		if len(keys) < n {
 			n = len(keys)
 		}
@@ -390,9 +985,26 @@ func notFoundAsNil(err error) error {
 	return err
 }
 
+// LookupPhases returns the past, current, and scheduled phases of org,
+// ordered by when they take effect. Phases of canceled subscriptions are
+// not included.
 func (c *Client) LookupPhases(ctx context.Context, org string) (ps []Phase, err error) {
+	ctx, span := trace.Start(ctx, c.Tracer, "control.LookupPhases", trace.String("tier.org", org))
+	defer trace.End(span, &err)
 	defer errorfmt.Handlef("LookupPhase: %w", &err)
+	return c.lookupPhases(ctx, org, false)
+}
+
+// LookupPhaseHistory is like LookupPhases, but also returns the phases of
+// subscriptions org canceled, with Canceled set.
+func (c *Client) LookupPhaseHistory(ctx context.Context, org string) (ps []Phase, err error) {
+	ctx, span := trace.Start(ctx, c.Tracer, "control.LookupPhaseHistory", trace.String("tier.org", org))
+	defer trace.End(span, &err)
+	defer errorfmt.Handlef("LookupPhaseHistory: %w", &err)
+	return c.lookupPhases(ctx, org, true)
+}
 
+func (c *Client) lookupPhases(ctx context.Context, org string, canceled bool) (ps []Phase, err error) {
 	cid, err := c.WhoIs(ctx, org)
 	if err != nil {
 		return nil, notFoundAsNil(err)
@@ -400,6 +1012,7 @@ func (c *Client) LookupPhases(ctx context.Context, org string) (ps []Phase, err
 
 	type T struct {
 		stripe.ID
+		Status   string
 		Metadata struct {
 			Name string `json:"tier.subscription"`
 		}
@@ -408,68 +1021,91 @@ func (c *Client) LookupPhases(ctx context.Context, org string) (ps []Phase, err
 			End   int64 `json:"end_date"`
 		} `json:"current_phase"`
 		Phases []struct {
-			Start int64 `json:"start_date"`
-			Items []struct {
-				Price stripePrice
+			Start    int64 `json:"start_date"`
+			TrialEnd int64 `json:"trial_end"`
+			Items    []struct {
+				Price stripePrice // expanded
 			}
+			InvoiceItems []struct {
+				Price stripePrice // expanded
+			} `json:"add_invoice_items"`
 		}
 	}
 
-	g, ctx := errgroup.WithContext(ctx)
-
+	// The prices of the phases are expanded, so that the schedules are
+	// all that is fetched, unless a plan in them has not been seen
+	// before.
 	var ss []T
-	g.Go(func() error {
-		var f stripe.Form
-		f.Add("expand[]", "data.phases.items.price")
-		f.Set("customer", cid)
-		got, err := stripe.Slurp[T](ctx, c.Stripe, "GET", "/v1/subscription_schedules", f)
-		if len(got) > 0 { // preserve nil
-			ss = got
-		}
-		return notFoundAsNil(err)
-	})
-
-	var m []refs.FeaturePlan
-	featureByProviderID := make(map[string]refs.FeaturePlan)
-	g.Go(func() (err error) {
-		fs, err := c.Pull(ctx, 0)
-		if err != nil {
-			return err
+	var f stripe.Form
+	f.Set("customer", cid)
+	f.Expand("data.phases.items.price", "data.phases.add_invoice_items.price")
+	err = stripe.Iter(ctx, c.Stripe, "GET", "/v1/subscription_schedules", f, func(s T) bool {
+		const name = "default" // TODO(bmizerany): support multiple subscriptions by name
+		c.Logf("subscription schedule: %s %s %d phases", s.ProviderID(), s.Status, len(s.Phases))
+		if s.Metadata.Name == name && (s.Status != "canceled" || canceled) {
+			ss = append(ss, s)
 		}
-		m = values.MapFunc(fs, func(f Feature) refs.FeaturePlan {
-			featureByProviderID[f.ProviderID] = f.FeaturePlan
-			return f.FeaturePlan
-		})
-		return err
+		return true
 	})
-
-	if err := g.Wait(); err != nil {
+	if err := notFoundAsNil(err); err != nil {
 		return nil, err
 	}
 
+	type phaseFeatures struct {
+		features []refs.FeaturePlan
+		interval string
+	}
+	var pfs [][]phaseFeatures
+	want := map[refs.Plan][]refs.FeaturePlan{}
 	for _, s := range ss {
-		const name = "default" // TODO(bmizerany): support multiple subscriptions by name
-		c.Logf("subscription schedule: %# v", pretty.Formatter(s))
-		if s.Metadata.Name != name {
-			continue
-		}
+		var sfs []phaseFeatures
 		for _, p := range s.Phases {
-			fs := make([]refs.FeaturePlan, 0, len(p.Items))
+			pf := phaseFeatures{features: make([]refs.FeaturePlan, 0, len(p.Items))}
 			for _, pi := range p.Items {
-				fs = append(fs, featureByProviderID[pi.Price.ProviderID()])
+				f := stripePriceToFeature(pi.Price)
+				pf.features = append(pf.features, f.FeaturePlan)
+				if f.Variant {
+					pf.interval = f.Interval
+				}
+			}
+			for _, pi := range p.InvoiceItems {
+				pf.features = append(pf.features, pi.Price.Metadata.Feature)
+			}
+			for p, fs := range refs.GroupByPlan(pf.features) {
+				if len(fs) > len(want[p]) {
+					want[p] = fs
+				}
 			}
+			sfs = append(sfs, pf)
+		}
+		pfs = append(pfs, sfs)
+	}
+	if len(ss) == 0 {
+		return nil, nil
+	}
+	inModel, err := c.planFeatures(ctx, want)
+	if err != nil {
+		return nil, err
+	}
 
+	for i, s := range ss {
+		for j, p := range s.Phases {
+			fs, interval := pfs[i][j].features, pfs[i][j].interval
+			inPhase := refs.GroupByPlan(fs)
 			var plans []refs.Plan
 			for _, f := range fs {
-				if slices.Contains(plans, f.Plan()) {
+				p := f.Plan()
+				if slices.Contains(plans, p) {
 					continue
 				}
-				inModel := numFeaturesInPlan(m, f.Plan())
-				inPhase := numFeaturesInPlan(fs, f.Plan())
-				if inModel == inPhase {
-					plans = append(plans, f.Plan())
+				if len(inModel[p]) == len(inPhase[p]) {
+					plans = append(plans, p)
 				}
+			}
 
+			var trialEnd time.Time
+			if p.TrialEnd != 0 {
+				trialEnd = time.Unix(p.TrialEnd, 0)
 			}
 
 			ps = append(ps, Phase{
@@ -477,8 +1113,11 @@ func (c *Client) LookupPhases(ctx context.Context, org string) (ps []Phase, err
 				Effective: time.Unix(p.Start, 0),
 				Features:  fs,
 				Current:   p.Start == s.Current.Start,
+				Interval:  interval,
+				TrialEnd:  trialEnd,
 
-				Plans: plans,
+				Plans:    plans,
+				Canceled: s.Status == "canceled",
 			})
 		}
 	}
@@ -493,8 +1132,17 @@ func (c *Client) LookupPhases(ctx context.Context, org string) (ps []Phase, err
 // PutCustomer safely creates or updates a customer in Stripe. It does this
 // being careful to not duplicate customer records. If the customer already exists, it
 // will be updated with the provided info.
-func (c *Client) PutCustomer(ctx context.Context, org string, info *OrgInfo) error {
-	_, err := c.putCustomer(ctx, org, info)
+func (c *Client) PutCustomer(ctx context.Context, org string, info *OrgInfo) (err error) {
+	defer c.audit(ctx, AuditPutCustomer, org, func() (any, error) {
+		info, err := c.LookupOrg(ctx, org)
+		if errors.Is(err, ErrOrgNotFound) {
+			return nil, nil
+		}
+		return info, err
+	}, info, &err)()
+
+	defer c.forgetCustomerOnError(org, &err)
+	_, err = c.putCustomer(ctx, org, info)
 	var e *stripe.Error
 	if errors.As(err, &e) && e.Code == "email_invalid" {
 		return ErrInvalidEmail
@@ -518,7 +1166,7 @@ func (c *Client) PutCustomer(ctx context.Context, org string, info *OrgInfo) err
 func (c *Client) putCustomer(ctx context.Context, org string, info *OrgInfo) (string, error) {
 	cid, err := c.WhoIs(ctx, org)
 	if errors.Is(err, ErrOrgNotFound) {
-		return c.createCustomer(ctx, org, info)
+		return c.createCustomer(ctx, org, c.Clock, info)
 	}
 	if err != nil {
 		return "", err
@@ -562,6 +1210,10 @@ func (c *Client) Isolated() bool {
 	return c.Stripe.AccountID != ""
 }
 
+// WhoIs returns the Stripe customer ID of org, or ErrOrgNotFound if it has
+// none. IDs are cached, so that operations starting with WhoIs do not each
+// search the customers, until an operation on the customer fails, in case
+// it was deleted in Stripe.
 func (c *Client) WhoIs(ctx context.Context, org string) (id string, err error) {
 	if !strings.HasPrefix(org, "org:") {
 		return "", &ValidationError{Message: "org must be prefixed with \"org:\""}
@@ -591,6 +1243,16 @@ func (c *Client) WhoIs(ctx context.Context, org string) (id string, err error) {
 	return cid, err
 }
 
+// forgetCustomerOnError removes the customer ID of org from the cache if
+// *err is not nil, so that the next WhoIs searches for it again, in case
+// the customer was deleted in Stripe. It is for use with defer.
+func (c *Client) forgetCustomerOnError(org string, err *error) {
+	if *err != nil {
+		c.cache.remove(org)
+		c.cache.remove(linksKey(org))
+	}
+}
+
 // LookupOrg returns the org information on file with Stripe, uncached.
 func (c *Client) LookupOrg(ctx context.Context, org string) (*OrgInfo, error) {
 	cid, err := c.WhoIs(ctx, org)
@@ -598,10 +1260,37 @@ func (c *Client) LookupOrg(ctx context.Context, org string) (*OrgInfo, error) {
 		return nil, err
 	}
 	var f stripe.Form
-	var info *OrgInfo
-	if err := c.Stripe.Do(ctx, "GET", "/v1/customers/"+cid, f, &info); err != nil {
+	var cus struct {
+		OrgInfo
+		Balance         int
+		Currency        string
+		Delinquent      bool
+		DefaultSource   string `json:"default_source"`
+		InvoiceSettings struct {
+			DefaultPaymentMethod string `json:"default_payment_method"`
+		} `json:"invoice_settings"`
+	}
+	if err := c.Stripe.Do(ctx, "GET", "/v1/customers/"+cid, f, &cus); err != nil {
 		return nil, err
 	}
+	info := &cus.OrgInfo
+	info.Credit = -cus.Balance
+	info.CreditCurrency = cus.Currency
+	info.Parent = info.Metadata["tier.parent"]
+	info.Delinquent = cus.Delinquent
+	info.HasPaymentMethod = cus.InvoiceSettings.DefaultPaymentMethod != "" || cus.DefaultSource != ""
+
+	s, err := c.lookupSubscription(ctx, org, scheduleNameTODO)
+	if err != nil && !errors.Is(err, stripe.ErrNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		info.SubscriptionStatus = s.Status
+		info.PeriodEnd = &s.PeriodEnd
+		info.HasPaymentMethod = info.HasPaymentMethod || s.PaymentMethod != ""
+		info.CancelAtPeriodEnd = s.CancelAtPeriodEnd
+		info.Paused = s.Paused
+	}
 
 	for k := range info.Metadata {
 		if strings.HasPrefix(k, "tier.") {
@@ -612,7 +1301,9 @@ func (c *Client) LookupOrg(ctx context.Context, org string) (*OrgInfo, error) {
 	return info, nil
 }
 
-func (c *Client) createCustomer(ctx context.Context, org string, info *OrgInfo) (id string, err error) {
+// createCustomer creates the customer for org, attached to the test clock
+// with ID clock, if not empty.
+func (c *Client) createCustomer(ctx context.Context, org, clock string, info *OrgInfo) (id string, err error) {
 	defer errorfmt.Handlef("createCustomer: %w", &err)
 	return c.cache.load(org, func() (string, error) {
 		var f stripe.Form
@@ -621,8 +1312,8 @@ func (c *Client) createCustomer(ctx context.Context, org string, info *OrgInfo)
 		if err := setOrgInfo(&f, info); err != nil {
 			return "", err
 		}
-		if c.Clock != "" {
-			f.Set("test_clock", c.Clock)
+		if clock != "" {
+			f.Set("test_clock", clock)
 		}
 		var created struct {
 			stripe.ID
@@ -669,12 +1360,3 @@ func nowOrSpecific(t time.Time) any {
 	}
 	return t
 }
-
-func numFeaturesInPlan(fs []refs.FeaturePlan, plan refs.Plan) (n int) {
-	for _, f := range fs {
-		if f.InPlan(plan) {
-			n++
-		}
-	}
-	return n
-}
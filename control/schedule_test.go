@@ -4,7 +4,10 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -14,6 +17,7 @@ import (
 	"kr.dev/diff"
 	"kr.dev/errorfmt"
 	"tier.run/refs"
+	"tier.run/values"
 )
 
 var (
@@ -179,6 +183,27 @@ func TestScheduleMinMaxItems(t *testing.T) {
 	if !errors.Is(err, ErrTooManyItems) {
 		t.Fatalf("got %v, want %v", err, ErrTooManyItems)
 	}
+	var oe *OrgError
+	if !errors.As(err, &oe) {
+		t.Fatalf("got %T, want *OrgError", err)
+	}
+	diff.Test(t, t.Errorf, oe, &OrgError{
+		Org:    "org:example",
+		Phase:  0,
+		Reason: "21 features exceed the limit of 20",
+		Err:    ErrTooManyItems,
+	})
+
+	err = c.SubscribeTo(ctx, "org:example", []refs.FeaturePlan{fps[0], mpf("feature:nope@plan:test@0")})
+	if !errors.As(err, &oe) {
+		t.Fatalf("got %v, want *OrgError", err)
+	}
+	diff.Test(t, t.Errorf, oe, &OrgError{
+		Org:     "org:example",
+		Phase:   0,
+		Feature: "feature:nope@plan:test@0",
+		Err:     ErrFeatureNotFound,
+	})
 
 	// check that we can still subscribe to the max number of items
 	wantFeatures := fps[:20]
@@ -298,6 +323,261 @@ func TestSubscribeToPlan(t *testing.T) {
 	diff.Test(t, t.Errorf, got, want, ignoreProviderIDs)
 }
 
+func TestSubscribeToInterval(t *testing.T) {
+	monthly := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Base:        100,
+		Currency:    "usd",
+	}, {
+		FeaturePlan: mpf("feature:y@plan:pro@0"),
+		Interval:    "@monthly",
+		Base:        1000,
+		Currency:    "usd",
+	}}
+	var fs []Feature
+	for _, f := range monthly {
+		v := f
+		v.Variant = true
+		v.Interval = "@yearly"
+		v.Base = f.Base * 10
+		fs = append(fs, f, v)
+	}
+
+	ctx := context.Background()
+	tc := newTestClient(t)
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	tc.setClock(t, t0)
+
+	pulled, err := tc.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(pulled, func(a, b Feature) bool {
+		return a.ID() < b.ID()
+	})
+	diff.Test(t, t.Errorf, pulled, fs, diff.ZeroFields[Feature]("ProviderID"))
+
+	efs, err := Expand(pulled, "plan:pro@0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, efs, FeaturePlans(monthly))
+
+	if err := tc.SubscribeToInterval(ctx, "org:example", "@yearly", efs); err != nil {
+		t.Fatal(err)
+	}
+	got, err := tc.LookupPhases(ctx, "org:example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Phase{{
+		Org:       "org:example",
+		Current:   true,
+		Effective: t0,
+		Features:  efs,
+		Interval:  "@yearly",
+
+		Plans: plans("plan:pro@0"),
+	}}
+	diff.Test(t, t.Errorf, got, want, ignoreProviderIDs)
+
+	// The default prices are billed monthly.
+	if err := tc.SubscribeToInterval(ctx, "org:example", "@monthly", efs); err != nil {
+		t.Fatal(err)
+	}
+	got, err = tc.LookupPhases(ctx, "org:example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want[0].Interval = ""
+	diff.Test(t, t.Errorf, got, want, ignoreProviderIDs)
+
+	err = tc.SubscribeToInterval(ctx, "org:example", "@weekly", efs)
+	if !errors.Is(err, ErrFeatureNotFound) {
+		t.Errorf("err = %v; want ErrFeatureNotFound", err)
+	}
+	err = tc.SubscribeToInterval(ctx, "org:example", "@hourly", efs)
+	if !errors.Is(err, ErrInvalidPhase) {
+		t.Errorf("err = %v; want ErrInvalidPhase", err)
+	}
+}
+
+func TestSubscribeToOneTime(t *testing.T) {
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Base:        100,
+		Currency:    "usd",
+	}, {
+		FeaturePlan: mpf("feature:setup@plan:pro@0"),
+		OneTime:     true,
+		Base:        5000,
+		Currency:    "usd",
+	}}
+
+	ctx := context.Background()
+	tc := newTestClient(t)
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	tc.setClock(t, t0)
+
+	pulled, err := tc.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(pulled, func(a, b Feature) bool {
+		return a.Less(b.FeaturePlan)
+	})
+	diff.Test(t, t.Errorf, pulled, []Feature{fs[1], fs[0]}, diff.ZeroFields[Feature]("ProviderID"))
+
+	efs, err := Expand(pulled, "plan:pro@0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SubscribeTo(ctx, "org:example", efs); err != nil {
+		t.Fatal(err)
+	}
+	got, err := tc.LookupPhases(ctx, "org:example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Phase{{
+		Org:       "org:example",
+		Current:   true,
+		Effective: t0,
+		Features:  FeaturePlans(fs), // one-time features last
+
+		Plans: plans("plan:pro@0"),
+	}}
+	diff.Test(t, t.Errorf, got, want, ignoreProviderIDs)
+
+	// Phases must have at least one recurring price.
+	err = tc.SubscribeTo(ctx, "org:other", []refs.FeaturePlan{mpf("feature:setup@plan:pro@0")})
+	if err == nil {
+		t.Error("expected error subscribing to only one-time features")
+	}
+}
+
+func TestSubscribeToTrial(t *testing.T) {
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:free@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}, {
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Base:        100,
+		Currency:    "usd",
+		TrialDays:   14,
+	}}
+
+	ctx := context.Background()
+	tc := newTestClient(t)
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	clock := tc.setClock(t, t0)
+
+	pulled, err := tc.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(pulled, func(a, b Feature) bool {
+		return a.Less(b.FeaturePlan)
+	})
+	diff.Test(t, t.Errorf, pulled, fs, diff.ZeroFields[Feature]("ProviderID"))
+
+	subscribe := func(plan string, wantTrialEnd time.Time) {
+		t.Helper()
+		if err := tc.SubscribeTo(ctx, "org:example", []refs.FeaturePlan{mpf("feature:x@" + plan)}); err != nil {
+			t.Fatal(err)
+		}
+		got, err := tc.LookupPhases(ctx, "org:example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 {
+			t.Fatalf("got %d phases; want 1", len(got))
+		}
+		if !got[0].TrialEnd.Equal(wantTrialEnd) {
+			t.Errorf("%s: TrialEnd = %v; want %v", plan, got[0].TrialEnd, wantTrialEnd)
+		}
+	}
+
+	trialEnd := t0.AddDate(0, 0, 14)
+	subscribe("plan:free@0", time.Time{})
+	subscribe("plan:pro@0", trialEnd)
+	subscribe("plan:pro@0", trialEnd) // trial in progress is kept
+
+	// Trials are only given the first time an org subscribes to a plan.
+	clock.Advance(trialEnd.AddDate(0, 0, 1))
+	subscribe("plan:free@0", time.Time{})
+	subscribe("plan:pro@0", time.Time{})
+}
+
+func TestSubscribeToAddOns(t *testing.T) {
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:pro@0"),
+		Interval:    "@monthly",
+		Base:        100,
+		Currency:    "usd",
+	}, {
+		FeaturePlan: mpf("feature:y@plan:pro@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}, {
+		FeaturePlan: mpf("feature:support@1"),
+		Interval:    "@monthly",
+		Base:        500,
+		Currency:    "usd",
+	}, {
+		FeaturePlan: mpf("feature:audit@1"),
+		Interval:    "@yearly",
+		Base:        500,
+		Currency:    "usd",
+	}}
+
+	ctx := context.Background()
+	tc := newTestClient(t)
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	tc.setClock(t, t0)
+
+	efs, err := Expand(fs, "plan:pro@0", "feature:support@1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SubscribeTo(ctx, "org:example", efs); err != nil {
+		t.Fatal(err)
+	}
+	got, err := tc.LookupPhases(ctx, "org:example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Phase{{
+		Org:       "org:example",
+		Current:   true,
+		Effective: t0,
+		Features:  efs,
+
+		Plans: plans("plan:pro@0"),
+	}}
+	diff.Test(t, t.Errorf, got, want, ignoreProviderIDs)
+	diff.Test(t, t.Errorf, got[0].AddOns(), refs.MustParseFeaturePlans("feature:support@1"))
+	diff.Test(t, t.Errorf, got[0].Fragments(), []refs.FeaturePlan(nil))
+
+	// Add-ons must be billed at the same interval as the plan.
+	err = tc.SubscribeTo(ctx, "org:example", append(efs, mpf("feature:audit@1")))
+	if !errors.Is(err, ErrInvalidPhase) {
+		t.Errorf("err = %v; want ErrInvalidPhase", err)
+	}
+}
+
 func TestDedupCustomer(t *testing.T) {
 	fs := []Feature{{
 		FeaturePlan: mpf("feature:x@plan:test@0"),
@@ -319,12 +599,18 @@ func TestDedupCustomer(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	got, err := tc.ListOrgs(ctx)
+	got, err := tc.ListOrgs(ctx, ListOrgsOptions{})
 	if err != nil {
 		t.Fatal(err)
 	}
 	want := []Org{{ID: "org:example"}}
-	diff.Test(t, t.Errorf, got, want, ignoreProviderIDs)
+	diff.Test(t, t.Errorf, got.Orgs, want, ignoreProviderIDs, diff.ZeroFields[Org]("Created"))
+	if len(got.Orgs) > 0 && got.Orgs[0].Created.IsZero() {
+		t.Error("Created is zero")
+	}
+	if got.Next != "" {
+		t.Errorf("Next = %q; want none", got.Next)
+	}
 }
 
 func TestLookupPhases(t *testing.T) {
@@ -409,7 +695,289 @@ func TestLookupPhases(t *testing.T) {
 	diff.Test(t, t.Errorf, got, want, ignoreProviderIDs)
 }
 
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+func TestLookupPhasesRequests(t *testing.T) {
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:test@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}}
+
+	tc := newTestClient(t)
+	ctx := context.Background()
+	tc.Push(ctx, fs, pushLogger(t))
+	if err := tc.SubscribeTo(ctx, "org:example", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var paths []string
+	hc := *tc.Stripe.HTTPClient
+	rt := hc.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	hc.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		mu.Lock()
+		paths = append(paths, r.URL.Path)
+		mu.Unlock()
+		return rt.RoundTrip(r)
+	})
+	tc.Stripe.HTTPClient = &hc
+
+	lookup := func() []string {
+		t.Helper()
+		mu.Lock()
+		paths = nil
+		mu.Unlock()
+		ps, err := tc.LookupPhases(ctx, "org:example")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ps) != 1 || !slices.Equal(ps[0].Plans, plans("plan:test@0")) {
+			t.Errorf("phases = %+v; want one phase with plan:test@0", ps)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return slices.Clone(paths)
+	}
+
+	// The prices are pulled once to learn the features of the plan, and
+	// then only the schedules are fetched.
+	if got := lookup(); !slices.Contains(got, "/v1/prices") {
+		t.Errorf("first lookup requested %q; want prices pulled", got)
+	}
+	diff.Test(t, t.Errorf, lookup(), []string{"/v1/subscription_schedules"})
+}
+
+func TestWhoIsCacheInvalidation(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:test@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}}
+	tc.Push(ctx, fs, pushLogger(t))
+	if err := tc.PutCustomer(ctx, "org:a", nil); err != nil {
+		t.Fatal(err)
+	}
+	cid, err := tc.WhoIs(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Stand in for a customer deleted in Stripe since it was cached.
+	stale := func() {
+		t.Helper()
+		tc.cache.add("org:a", "cus_deleted")
+		if got, _ := tc.WhoIs(ctx, "org:a"); got != "cus_deleted" {
+			t.Fatalf("WhoIs = %q; want cached cus_deleted", got)
+		}
+	}
+	check := func() {
+		t.Helper()
+		got, err := tc.WhoIs(ctx, "org:a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != cid {
+			t.Errorf("WhoIs = %q; want %q", got, cid)
+		}
+	}
+
+	stale()
+	if err := tc.SubscribeTo(ctx, "org:a", FeaturePlans(fs)); err == nil {
+		t.Fatal("subscribing stale customer: err = nil; want error")
+	}
+	check()
+	if err := tc.SubscribeTo(ctx, "org:a", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+
+	stale()
+	if err := tc.PutCustomer(ctx, "org:a", &OrgInfo{Email: "a@example.com"}); err == nil {
+		t.Fatal("updating stale customer: err = nil; want error")
+	}
+	check()
+}
+
+func TestScheduleConflict(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:a@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}, {
+		FeaturePlan: mpf("feature:x@plan:b@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}}
+	tc.Push(ctx, fs, pushLogWith(t, t.Fatalf))
+	a := []Phase{{Features: []refs.FeaturePlan{mpf("feature:x@plan:a@0")}}}
+	b := []Phase{{Features: []refs.FeaturePlan{mpf("feature:x@plan:b@0")}}}
+
+	if err := tc.Schedule(ctx, "org:a", nil, a); err != nil {
+		t.Fatal(err)
+	}
+	s, err := tc.lookupSubscription(ctx, "org:a", scheduleNameTODO)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.ScheduleVersion != 0 {
+		t.Errorf("version of new schedule = %d; want 0", s.ScheduleVersion)
+	}
+
+	// Another update lands after s was read.
+	if err := tc.Schedule(ctx, "org:a", nil, b); err != nil {
+		t.Fatal(err)
+	}
+	err = tc.updateSchedule(ctx, s.ScheduleID, scheduleNameTODO, s.ScheduleVersion, a)
+	if !errors.Is(err, ErrScheduleConflict) {
+		t.Fatalf("updating stale schedule: err = %v; want %v", err, ErrScheduleConflict)
+	}
+
+	current := func() []refs.FeaturePlan {
+		t.Helper()
+		ps, err := tc.LookupPhases(ctx, "org:a")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range ps {
+			if p.Current {
+				return p.Features
+			}
+		}
+		return nil
+	}
+	diff.Test(t, t.Errorf, current(), []refs.FeaturePlan{mpf("feature:x@plan:b@0")})
+
+	// Schedule reads the schedule again, so it is not in conflict.
+	if err := tc.Schedule(ctx, "org:a", nil, a); err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, current(), []refs.FeaturePlan{mpf("feature:x@plan:a@0")})
+}
+
+func TestScheduleNowConflict(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:a@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}, {
+		FeaturePlan: mpf("feature:x@plan:b@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}}
+	tc.Push(ctx, fs, pushLogWith(t, t.Fatalf))
+	tc.setClock(t, t0)
+	a := []refs.FeaturePlan{mpf("feature:x@plan:a@0")}
+	b := []refs.FeaturePlan{mpf("feature:x@plan:b@0")}
+	if err := tc.Schedule(ctx, "org:a", nil, []Phase{{Features: a}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another client gives org:a a trial just after ScheduleNow reads
+	// its current phase.
+	trialEnd := t0.AddDate(0, 0, 7)
+	var once sync.Once
+	hc := *tc.Stripe.HTTPClient
+	rt := hc.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	hc.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		res, err := rt.RoundTrip(r)
+		if r.Method == "GET" && r.URL.Path == "/v1/subscription_schedules" {
+			once.Do(func() {
+				err := tc.Schedule(ctx, "org:a", nil, []Phase{{Features: a, TrialEnd: trialEnd}})
+				if err != nil {
+					t.Errorf("concurrent Schedule: %v", err)
+				}
+			})
+		}
+		return res, err
+	})
+	tc.Stripe.HTTPClient = &hc
+
+	if err := tc.ScheduleNow(ctx, "org:a", nil, []Phase{{Features: b}}); err != nil {
+		t.Fatal(err)
+	}
+	ps, err := tc.LookupPhases(ctx, "org:a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 1 {
+		t.Fatalf("got %d phases; want 1", len(ps))
+	}
+	diff.Test(t, t.Errorf, ps[0].Features, b)
+	if !ps[0].TrialEnd.Equal(trialEnd) {
+		t.Errorf("TrialEnd = %v; want %v kept from the concurrent update", ps[0].TrialEnd, trialEnd)
+	}
+}
+
+func TestLookupPhaseHistory(t *testing.T) {
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:test@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}}
+
+	tc := newTestClient(t)
+	ctx := context.Background()
+	tc.Push(ctx, fs, pushLogger(t))
+
+	clock := tc.setClock(t, t0)
+	if err := tc.SubscribeTo(ctx, "org:example", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+	t7 := t0.AddDate(0, 0, 7) // within the first billing period
+	clock.Advance(t7)
+	if err := tc.Cancel(ctx, "org:example", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.SubscribeTo(ctx, "org:example", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+
+	current := Phase{
+		Org:       "org:example",
+		Current:   true,
+		Effective: t7,
+		Features:  FeaturePlans(fs),
+		Plans:     plans("plan:test@0"),
+	}
+	got, err := tc.LookupPhases(ctx, "org:example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, []Phase{current}, ignoreProviderIDs)
+
+	got, err = tc.LookupPhaseHistory(ctx, "org:example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, []Phase{{
+		Org:       "org:example",
+		Effective: t0,
+		Features:  FeaturePlans(fs),
+		Plans:     plans("plan:test@0"),
+		Canceled:  true,
+	}, current}, ignoreProviderIDs)
+}
+
 func TestReportUsage(t *testing.T) {
+	stripeOnly(t) // depends on the exact periods Stripe reports
+
 	fs := []Feature{
 		{
 			FeaturePlan: mpf("feature:10@plan:test@0"),
@@ -482,6 +1050,47 @@ func TestReportUsage(t *testing.T) {
 	diff.Test(t, t.Errorf, got, want)
 }
 
+func TestReportUsagePackage(t *testing.T) {
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:calls@plan:test@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Mode:        "package",
+		Aggregate:   "sum",
+		PackageSize: 1000,
+		Tiers:       []Tier{{Upto: 2500, Price: values.MustParseDecimal("10.5")}},
+	}}
+
+	tc := newTestClient(t)
+	ctx := context.Background()
+	if err := tc.Push(ctx, fs, pushLogWith(t, t.Fatalf)); err != nil {
+		t.Fatal(err)
+	}
+	tc.setClock(t, t0)
+
+	pulled, err := tc.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, pulled, fs, diff.ZeroFields[Feature]("ProviderID"))
+
+	if err := tc.SubscribeTo(ctx, "org:example", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+	err = tc.ReportUsage(ctx, "org:example", mpn("feature:calls"), Report{N: 1500, At: t0})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := tc.LookupLimits(ctx, "org:example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Usage is in units, and the limit is rounded up to whole packages.
+	want := []Usage{{Feature: mpf("feature:calls@plan:test@0"), Used: 1500, Limit: 3000}}
+	diff.Test(t, t.Errorf, got, want, diff.ZeroFields[Usage]("Start", "End"))
+}
+
 func TestReportUsageFeatureNotFound(t *testing.T) {
 	tc := newTestClient(t)
 	ctx := context.Background()
@@ -506,6 +1115,56 @@ func TestReportUsageFeatureNotFound(t *testing.T) {
 	}
 }
 
+func TestReportUsageAlias(t *testing.T) {
+	tc := newTestClient(t)
+	ctx := context.Background()
+
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:seats@plan:test@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Tiers:       []Tier{{Upto: 10}},
+		Mode:        "graduated",
+		Aggregate:   "sum",
+		Aliases:     []refs.Name{mpn("feature:users")},
+	}}
+
+	tc.Push(ctx, fs, pushLogger(t))
+	tc.setClock(t, t0)
+	if err := tc.SubscribeTo(ctx, "org:example", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.ReportUsage(ctx, "org:example", mpn("feature:users"), Report{N: 3, At: t0}); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := tc.LookupLimits(ctx, "org:example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []refs.FeaturePlan
+	for _, u := range got {
+		if u.Used != 3 || u.Limit != 10 {
+			t.Errorf("%v: used %d of %d; want 3 of 10", u.Feature, u.Used, u.Limit)
+		}
+		names = append(names, u.Feature)
+	}
+	slices.SortFunc(names, refs.ByName)
+	diff.Test(t, t.Errorf, names, refs.MustParseFeaturePlans(
+		"feature:seats@plan:test@0",
+		"feature:users@plan:test@0",
+	))
+
+	pulled, err := tc.Pull(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pulled) != 1 {
+		t.Fatalf("pulled %d features; want 1", len(pulled))
+	}
+	diff.Test(t, t.Errorf, pulled[0].Aliases, fs[0].Aliases)
+}
+
 func TestSubscribeToUnknownFeatures(t *testing.T) {
 	tc := newTestClient(t)
 	ctx := context.Background()
@@ -643,6 +1302,12 @@ func ciOnly(t *testing.T) {
 	}
 }
 
+func stripeOnly(t *testing.T) {
+	if !haveStripe() {
+		t.Skip("STRIPE_API_KEY not set; skipping test that requires Stripe")
+	}
+}
+
 func endOfStripeMonth(t time.Time) time.Time {
 	return t.AddDate(0, 1, 0).Truncate(time.Minute).Add(-5 * time.Minute)
 }
@@ -654,3 +1319,60 @@ func plans(ss ...string) []refs.Plan {
 	}
 	return ps
 }
+
+func TestScheduleNowCanceled(t *testing.T) {
+	fs := []Feature{{
+		FeaturePlan: mpf("feature:x@plan:test@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+	}}
+
+	tc := newTestClient(t)
+	tc.Push(context.Background(), fs, pushLogger(t))
+	if err := tc.SubscribeTo(context.Background(), "org:example", FeaturePlans(fs)); err != nil {
+		t.Fatal(err)
+	}
+
+	// Cancel once the info of the org is updated, before its phases are.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var posts []string
+	hc := *tc.Stripe.HTTPClient
+	rt := hc.Transport
+	if rt == nil {
+		rt = http.DefaultTransport
+	}
+	hc.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		res, err := rt.RoundTrip(r)
+		if r.Method == "POST" {
+			posts = append(posts, r.URL.Path)
+			if strings.HasPrefix(r.URL.Path, "/v1/customers/") {
+				cancel()
+			}
+		}
+		return res, err
+	})
+	tc.Stripe.HTTPClient = &hc
+
+	err := tc.ScheduleNow(ctx, "org:example", &OrgInfo{Email: "a@example.com"}, []Phase{{
+		Features: FeaturePlans(fs),
+	}})
+	var ce *CanceledError
+	if !errors.As(err, &ce) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("err = %v; want *CanceledError for context.Canceled", err)
+	}
+	diff.Test(t, t.Errorf, ce.Done, []string{"updated org info"})
+	for _, p := range posts {
+		if strings.HasPrefix(p, "/v1/subscription_schedules") {
+			t.Errorf("schedule updated after cancellation: POST %s", p)
+		}
+	}
+
+	info, err := tc.LookupOrg(context.Background(), "org:example")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Email != "a@example.com" {
+		t.Errorf("email = %q; want the update made before cancellation", info.Email)
+	}
+}
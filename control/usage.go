@@ -4,7 +4,7 @@ import (
 	"context"
 	"crypto/rand"
 	"encoding/hex"
-	"fmt"
+	"errors"
 	"time"
 
 	"golang.org/x/exp/maps"
@@ -12,6 +12,7 @@ import (
 	"tailscale.com/logtail/backoff"
 	"tier.run/refs"
 	"tier.run/stripe"
+	"tier.run/trace"
 )
 
 type Report struct {
@@ -26,16 +27,57 @@ type Usage struct {
 	End     time.Time
 	Used    int
 	Limit   int
+
+	// ByOrg, if the org has children, is the part of Used reported by
+	// each of its descendants, by org; see SetParent.
+	ByOrg map[string]int
 }
 
-func (c *Client) ReportUsage(ctx context.Context, org string, feature refs.Name, use Report) error {
-	itemID, isMetered, err := c.lookupSubscriptionItemID(ctx, org, scheduleNameTODO, feature)
+// ReportUsage reports the use of feature by org. The use of orgs with a
+// parent is billed to the subscription of their topmost ancestor, and may
+// not be clobbered, since it is only part of the use billed. If c.Notifier
+// is set, it is notified of each report, and the limit of feature is
+// checked in the background after reports, to notify it of limits reached.
+func (c *Client) ReportUsage(ctx context.Context, org string, feature refs.Name, use Report) (err error) {
+	ctx, span := trace.Start(ctx, c.Tracer, "control.ReportUsage", trace.String("tier.org", org), trace.String("tier.feature", feature.String()))
+	defer trace.End(span, &err)
+	defer c.observe("tier_report_duration_seconds", &err)()
+
+	billing, err := c.billingOrg(ctx, org)
+	if err != nil {
+		return err
+	}
+	if billing != org && use.Clobber {
+		return &ValidationError{Message: "usage of child orgs must not be clobbered"}
+	}
+	itemID, isMetered, start, err := c.lookupSubscriptionItemID(ctx, billing, scheduleNameTODO, feature)
 	if err != nil {
 		return err
 	}
 	if !isMetered {
 		return ErrFeatureNotMetered
 	}
+	if billing != org {
+		// Record the use by the child once billed, with ctx as it is
+		// before the timeout for reporting below. The use is billed
+		// whether or not it is recorded, so failing to record it is
+		// logged rather than reported, lest the caller report it again.
+		defer func(ctx context.Context) {
+			if err == nil {
+				if rerr := c.recordChildUsage(ctx, org, start, feature, use.N); rerr != nil {
+					c.Logf("tier: recording usage of %s by %q: %v", feature, org, rerr)
+				}
+			}
+		}(ctx)
+	}
+	if c.Notifier != nil {
+		defer func(ctx context.Context) {
+			if err == nil {
+				c.Notifier.OnReport(ctx, org, feature, use)
+				c.watchLimit(org, feature)
+			}
+		}(ctx)
+	}
 
 	var f stripe.Form
 	f.Set("quantity", use.N)
@@ -46,9 +88,12 @@ func (c *Client) ReportUsage(ctx context.Context, org string, feature refs.Name,
 		f.Set("action", "increment")
 	}
 
-	// TODO(bmizerany): take idempotency key from context or use random
-	// string. if in context then upstream client supplied their own.
-	f.SetIdempotencyKey(randomString())
+	// Use the same idempotency key for every attempt below. If the
+	// context has a key, the key Stripe.Do derives from it is already
+	// stable across attempts.
+	if stripe.IdempotencyKeyFromContext(ctx) == "" {
+		f.SetIdempotencyKey(randomString())
+	}
 
 	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
 	defer cancel()
@@ -63,22 +108,29 @@ func (c *Client) ReportUsage(ctx context.Context, org string, feature refs.Name,
 		}
 		err := c.Stripe.Do(ctx, "POST", "/v1/subscription_items/"+itemID+"/usage_records", f, nil)
 		c.Logf("ReportUsage: %v", err)
-		bo.BackOff(ctx, err)
-		if err == nil {
-			return nil
+		if err == nil || errors.Is(err, stripe.ErrStripeUnavailable) {
+			return err
 		}
+		bo.BackOff(ctx, err)
 	}
 }
 
-func (c *Client) LookupLimits(ctx context.Context, org string) ([]Usage, error) {
-	cid, err := c.WhoIs(ctx, org)
+func (c *Client) LookupLimits(ctx context.Context, org string) (_ []Usage, err error) {
+	ctx, span := trace.Start(ctx, c.Tracer, "control.LookupLimits", trace.String("tier.org", org))
+	defer trace.End(span, &err)
+
+	billing, err := c.billingOrg(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+	cid, l, err := c.cachedLinks(ctx, billing)
 	if err != nil {
 		return nil, err
 	}
 
 	var f stripe.Form
 	f.Set("customer", cid)
-	f.Add("expand[]", "data.price.tiers")
+	f.Expand("data.price.tiers")
 
 	type T struct {
 		stripe.ID
@@ -87,16 +139,13 @@ func (c *Client) LookupLimits(ctx context.Context, org string) ([]Usage, error)
 		Quantity int
 	}
 
-	lines, err := stripe.Slurp[T](ctx, c.Stripe, "GET", "/v1/invoices/upcoming/lines", f)
-	if err != nil {
-		return nil, err
-	}
-
 	seen := map[refs.FeaturePlan]Usage{}
-	for _, line := range lines {
+	aliases := map[refs.FeaturePlan][]refs.Name{}
+	periods := map[refs.Plan]T{} // a line of each plan, for its flags
+	err = stripe.Iter(ctx, c.Stripe, "GET", "/v1/invoices/upcoming/lines", f, func(line T) bool {
 		f := stripePriceToFeature(line.Price)
 		if f.IsZero() { // not a Tier price
-			continue
+			return true
 		}
 		if seen[f.FeaturePlan].Used <= line.Quantity {
 			seen[f.FeaturePlan] = Usage{
@@ -104,25 +153,91 @@ func (c *Client) LookupLimits(ctx context.Context, org string) ([]Usage, error)
 				Start:   time.Unix(line.Period.Start, 0),
 				End:     time.Unix(line.Period.End, 0),
 				Used:    line.Quantity,
-				Limit:   f.Limit(),
+				Limit:   rolloutLimit(org, f),
+			}
+			aliases[f.FeaturePlan] = f.Aliases
+		}
+		if p := f.Plan(); !p.IsZero() {
+			periods[p] = line
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Flags are allowed, without limit, to orgs subscribed to their plans,
+	// unless they are rolled out to others.
+	for p, line := range periods {
+		flags, err := c.pullFlags(ctx, PullOptions{Plan: p, Archived: true})
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range flags {
+			seen[f.FeaturePlan] = Usage{
+				Feature: f.FeaturePlan,
+				Start:   time.Unix(line.Period.Start, 0),
+				End:     time.Unix(line.Period.End, 0),
+				Limit:   rolloutLimit(org, f),
+			}
+		}
+	}
+
+	if l.Children != "" {
+		if err := c.breakDownUsage(ctx, billing, seen); err != nil {
+			return nil, err
+		}
+	}
+
+	// Report usage under each alias too, so that lookups by an old name
+	// keep working after a rename.
+	usage := maps.Values(seen)
+	for fp, u := range seen {
+		for _, a := range aliases[fp] {
+			u.Feature = a.WithPlan(fp.Plan())
+			usage = append(usage, u)
+		}
+	}
+	return usage, nil
+}
+
+// breakDownUsage sets the ByOrg of each usage in seen, of features of org,
+// to the usage reported by the descendants of org.
+func (c *Client) breakDownUsage(ctx context.Context, org string, seen map[refs.FeaturePlan]Usage) error {
+	children, err := c.lookupChildUsage(ctx, org)
+	if err != nil {
+		return err
+	}
+	for fp, u := range seen {
+		for child, cu := range children {
+			if n := cu.Used[fp.Name().String()]; n > 0 && cu.Start == u.Start.Unix() {
+				if u.ByOrg == nil {
+					u.ByOrg = map[string]int{}
+				}
+				u.ByOrg[child] = n
 			}
 		}
+		seen[fp] = u
 	}
-	return maps.Values(seen), nil
+	return nil
 }
 
-func (c *Client) lookupSubscriptionItemID(ctx context.Context, org, name string, feature refs.Name) (id string, isMetered bool, err error) {
+// lookupSubscriptionItemID returns the ID of the subscription item of org
+// for feature, whether it is metered, and the start of the current period
+// of the subscription.
+func (c *Client) lookupSubscriptionItemID(ctx context.Context, org, name string, feature refs.Name) (id string, isMetered bool, start time.Time, err error) {
 	defer errorfmt.Handlef("lookupSubscriptionItemID: %w", &err)
 	s, err := c.lookupSubscription(ctx, org, name)
 	if err != nil {
-		return "", false, err
+		return "", false, time.Time{}, err
 	}
+	feature = Aliases(s.Features).Resolve(feature)
 	for _, f := range s.Features {
 		if f.IsVersionOf(feature) {
-			return f.ReportID, f.IsMetered(), nil
+			return f.ReportID, f.IsMetered(), s.PeriodStart, nil
 		}
 	}
-	return "", false, fmt.Errorf("%w: %q", ErrFeatureNotFound, feature)
+	return "", false, time.Time{}, featureNotFound(org, feature.String())
 }
 
 func randomString() string {
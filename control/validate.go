@@ -0,0 +1,197 @@
+package control
+
+import (
+	"fmt"
+
+	"tier.run/refs"
+)
+
+// Diagnostic severities.
+const (
+	SeverityError   = "error"   // the model cannot be pushed as is
+	SeverityWarning = "warning" // the model can be pushed, but likely not as intended
+)
+
+// A Diagnostic describes a problem found in a pricing model by Validate.
+type Diagnostic struct {
+	Severity string           // SeverityError or SeverityWarning
+	Code     string           // machine readable code, e.g. "tiers_out_of_order"
+	Plan     refs.Plan        // the plan the problem was found in, if any
+	Feature  refs.FeaturePlan // the feature the problem was found in, if any
+	Message  string           // human readable description
+}
+
+func (d Diagnostic) String() string {
+	var at string
+	switch {
+	case !d.Feature.IsZero():
+		at = d.Feature.String() + ": "
+	case !d.Plan.IsZero():
+		at = d.Plan.String() + ": "
+	}
+	return fmt.Sprintf("%s: %s%s (%s)", d.Severity, at, d.Message, d.Code)
+}
+
+// HasErrors reports whether any of ds has SeverityError.
+func HasErrors(ds []Diagnostic) bool {
+	for _, d := range ds {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the pricing model made up of fs for problems that would
+// cause Push to fail, or cause features to be billed other than intended,
+// without making any requests to Stripe. Diagnostics are returned in the
+// order of the features they were found in.
+//
+// Features in the same plan must share a currency and interval, since
+// Stripe requires all prices in a subscription to agree on both. Variants
+// must instead have an interval other than their plan's, one-time
+// features have no interval, and flags have neither.
+func Validate(fs []Feature) []Diagnostic {
+	var ds []Diagnostic
+	seen := map[string]bool{} // by ID, so variants are distinct
+
+	// Features in a plan are checked against the plan's first feature
+	// that is not a variant, one-time, or a flag.
+	first := map[refs.Plan]Feature{}
+	for _, f := range fs {
+		if _, ok := first[f.Plan()]; !ok && !f.Variant && !f.OneTime && !f.Flag {
+			first[f.Plan()] = f
+		}
+	}
+
+	for _, f := range fs {
+		report := func(severity, code, format string, args ...any) {
+			ds = append(ds, Diagnostic{
+				Severity: severity,
+				Code:     code,
+				Plan:     f.Plan(),
+				Feature:  f.FeaturePlan,
+				Message:  fmt.Sprintf(format, args...),
+			})
+		}
+
+		if seen[f.ID()] {
+			report(SeverityError, "duplicate_feature", "feature is defined more than once")
+			continue
+		}
+		seen[f.ID()] = true
+
+		if f.Flag {
+			if f.Plan().IsZero() {
+				report(SeverityError, "flag_not_in_plan", "flags must be in a plan")
+			}
+			if f.Base != 0 || len(f.Tiers) > 0 || f.OneTime || f.Variant {
+				report(SeverityError, "flag_priced", "flags must not have a price")
+			}
+		}
+		if _, ok := intervalToStripe[f.Interval]; !ok && !f.OneTime && !f.Flag {
+			report(SeverityError, "unknown_interval", "unknown interval %q", f.Interval)
+		}
+		if !isCurrency(f.Currency) && !f.Flag {
+			report(SeverityError, "invalid_currency", "currency %q must be a three letter ISO 4217 code", f.Currency)
+		}
+		if p := f.Plan(); !p.IsZero() {
+			if pf, ok := first[p]; ok && pf.ID() != f.ID() {
+				if f.Currency != pf.Currency && !f.Flag {
+					report(SeverityError, "currency_mismatch", "currency %q does not match %q used by %s", f.Currency, pf.Currency, pf.FeaturePlan)
+				}
+				switch {
+				case f.Variant && f.Interval == pf.Interval:
+					report(SeverityError, "variant_interval", "variant interval %q must differ from the plan interval", f.Interval)
+				case !f.Variant && !f.OneTime && !f.Flag && f.Interval != pf.Interval:
+					report(SeverityError, "interval_mismatch", "interval %q does not match %q used by %s", f.Interval, pf.Interval, pf.FeaturePlan)
+				}
+				if f.PlanTitle != pf.PlanTitle {
+					report(SeverityWarning, "plan_title_mismatch", "plan title %q does not match %q used by %s", f.PlanTitle, pf.PlanTitle, pf.FeaturePlan)
+				}
+				if f.TrialDays != pf.TrialDays {
+					report(SeverityWarning, "trial_mismatch", "trial days %d does not match %d used by %s", f.TrialDays, pf.TrialDays, pf.FeaturePlan)
+				}
+			}
+		}
+
+		if f.TrialDays < 0 {
+			report(SeverityError, "invalid_trial", "trial days must not be negative")
+		}
+		if f.Base < 0 {
+			report(SeverityError, "invalid_price", "base must not be negative")
+		}
+		if f.OneTime {
+			if len(f.Tiers) > 0 {
+				report(SeverityError, "one_time_tiers", "one-time features must not have tiers")
+			}
+			if f.Variant {
+				report(SeverityError, "one_time_variant", "one-time features must not have interval variants")
+			}
+		}
+		if len(f.Tiers) > 0 {
+			if f.Base > 0 {
+				report(SeverityWarning, "base_ignored", "base is ignored for features with tiers")
+			}
+			switch f.Mode {
+			case "graduated", "volume":
+			case "package":
+				if f.PackageSize < 1 {
+					report(SeverityError, "invalid_package", "package size must be greater than zero")
+				}
+				if len(f.Tiers) != 1 || f.Tiers[0].Base != 0 {
+					report(SeverityError, "invalid_package", "package features must have one tier without a base")
+				}
+			default:
+				report(SeverityError, "unknown_mode", "unknown mode %q; must be \"graduated\", \"volume\", or \"package\"", f.Mode)
+			}
+			if _, ok := aggregateToStripe[f.Aggregate]; !ok {
+				report(SeverityError, "unknown_aggregate", "unknown aggregate %q", f.Aggregate)
+			}
+		} else {
+			// Mode, aggregate, and package size only apply to tiers,
+			// so any value other than the default was likely meant
+			// for tiers that are missing.
+			if f.Mode != "" && f.Mode != "graduated" {
+				report(SeverityWarning, "mode_ignored", "mode %q is ignored for features without tiers", f.Mode)
+			}
+			if f.Aggregate != "" && f.Aggregate != "sum" {
+				report(SeverityWarning, "aggregate_ignored", "aggregate %q is ignored for features without tiers", f.Aggregate)
+			}
+		}
+		if f.PackageSize != 0 && (f.Mode != "package" || len(f.Tiers) == 0) {
+			report(SeverityWarning, "package_size_ignored", "package size is ignored for features not in \"package\" mode")
+		}
+		for i, t := range f.Tiers {
+			if t.Upto < 1 {
+				report(SeverityError, "invalid_tier", "tiers[%d]: upto must be greater than zero", i)
+			}
+			if i > 0 && t.Upto <= f.Tiers[i-1].Upto {
+				report(SeverityError, "tiers_out_of_order", "tiers[%d]: upto %d must be greater than upto %d of the previous tier", i, t.Upto, f.Tiers[i-1].Upto)
+			}
+			if t.Price.Sign() < 0 || t.Base < 0 {
+				report(SeverityError, "invalid_price", "tiers[%d]: price and base must not be negative", i)
+			}
+			if t.Price.Places() > 12 {
+				report(SeverityError, "invalid_price", "tiers[%d]: price must not exceed 12 decimal places", i)
+			}
+		}
+
+		if err := checkStripeLimits(f); err != nil {
+			report(SeverityError, "stripe_limit", "%v", err)
+		}
+	}
+	return ds
+}
+
+func isCurrency(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for _, r := range s {
+		if r < 'a' || r > 'z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package control
+
+import (
+	"strings"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/refs"
+	"tier.run/values"
+)
+
+func TestValidate(t *testing.T) {
+	valid := func(s string) Feature {
+		return Feature{
+			FeaturePlan: mpf(s),
+			Currency:    "usd",
+			Interval:    "@monthly",
+			Mode:        "graduated",
+			Aggregate:   "sum",
+		}
+	}
+	with := func(f Feature, fn func(*Feature)) Feature {
+		fn(&f)
+		return f
+	}
+
+	fs := []Feature{
+		valid("feature:ok@plan:free@1"),
+		with(valid("feature:tiers@plan:free@1"), func(f *Feature) {
+			f.Tiers = []Tier{{Upto: 10}, {Upto: 5}}
+			f.Base = 1
+		}),
+		valid("feature:ok@plan:free@1"),
+		with(valid("feature:eur@plan:free@1"), func(f *Feature) {
+			f.Currency = "eur"
+			f.Interval = "@yearly"
+		}),
+		with(valid("feature:mode@plan:pro@1"), func(f *Feature) {
+			f.Tiers = []Tier{{Upto: Inf}}
+			f.Mode = "flat"
+		}),
+		with(valid("feature:licensed@plan:pro@1"), func(f *Feature) {
+			f.Currency = "US Dollars"
+			f.Aggregate = "max"
+		}),
+		valid("feature:" + strings.Repeat("x", 200) + "@1"),
+		with(valid("feature:setup@plan:pro@1"), func(f *Feature) {
+			f.OneTime = true
+			f.Interval = ""
+			f.Tiers = []Tier{{Upto: Inf}}
+		}),
+		with(valid("feature:calls@plan:pro@1"), func(f *Feature) {
+			f.Mode = "package"
+			f.Tiers = []Tier{{Upto: Inf, Price: values.MustParseDecimal("1")}}
+		}),
+		with(valid("feature:trial@plan:pro@1"), func(f *Feature) {
+			f.TrialDays = -1
+		}),
+		with(valid("feature:sso@plan:pro@1"), func(f *Feature) {
+			f.Flag = true
+			f.Currency = ""
+			f.Interval = ""
+		}),
+		with(valid("feature:audit@1"), func(f *Feature) {
+			f.Flag = true
+			f.Base = 100
+		}),
+	}
+
+	var got []string
+	for _, d := range Validate(fs) {
+		got = append(got, d.Severity+" "+d.Code+" "+d.Feature.String())
+	}
+	want := []string{
+		"warning base_ignored feature:tiers@plan:free@1",
+		"error tiers_out_of_order feature:tiers@plan:free@1",
+		"error duplicate_feature feature:ok@plan:free@1",
+		"error currency_mismatch feature:eur@plan:free@1",
+		"error interval_mismatch feature:eur@plan:free@1",
+		"error unknown_mode feature:mode@plan:pro@1",
+		"error invalid_currency feature:licensed@plan:pro@1",
+		"error currency_mismatch feature:licensed@plan:pro@1",
+		"warning aggregate_ignored feature:licensed@plan:pro@1",
+		"error stripe_limit feature:" + strings.Repeat("x", 200) + "@1",
+		"error one_time_tiers feature:setup@plan:pro@1",
+		"error invalid_package feature:calls@plan:pro@1",
+		"warning trial_mismatch feature:trial@plan:pro@1",
+		"error invalid_trial feature:trial@plan:pro@1",
+		"error flag_not_in_plan feature:audit@1",
+		"error flag_priced feature:audit@1",
+	}
+	diff.Test(t, t.Errorf, got, want)
+
+	if !HasErrors(Validate(fs)) {
+		t.Error("HasErrors = false; want true")
+	}
+	if ds := Validate(fs[:1]); ds != nil {
+		t.Errorf("Validate(valid) = %v; want nil", ds)
+	}
+
+	d := Diagnostic{
+		Severity: SeverityError,
+		Code:     "invalid_tier",
+		Plan:     refs.MustParsePlan("plan:free@1"),
+		Message:  "bad",
+	}
+	if got, want := d.String(), "error: plan:free@1: bad (invalid_tier)"; got != want {
+		t.Errorf("String() = %q; want %q", got, want)
+	}
+}
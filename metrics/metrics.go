@@ -0,0 +1,51 @@
+// Package metrics defines the sink the control and stripe packages report
+// measurements to, such as the latency of requests made to Stripe and the
+// durations of pushes, and provides a Registry that collects them for
+// Prometheus.
+package metrics
+
+import "time"
+
+// A Label names the dimension a measurement was made in, such as the
+// status of a request.
+type Label struct {
+	Name  string
+	Value string
+}
+
+// L returns a Label.
+func L(name, value string) Label { return Label{name, value} }
+
+// A Sink receives measurements. Implementations must be safe for
+// concurrent use.
+type Sink interface {
+	// Add adds v to the counter name with labels.
+	Add(name string, v float64, labels ...Label)
+
+	// Observe records v in the histogram name with labels.
+	Observe(name string, v float64, labels ...Label)
+}
+
+// Add adds v to the counter name in s, if s is non-nil.
+func Add(s Sink, name string, v float64, labels ...Label) {
+	if s != nil {
+		s.Add(name, v, labels...)
+	}
+}
+
+// Since records the seconds since start in the histogram name in s, if s
+// is non-nil.
+func Since(s Sink, name string, start time.Time, labels ...Label) {
+	if s != nil {
+		s.Observe(name, time.Since(start).Seconds(), labels...)
+	}
+}
+
+// Result returns the label "result" with the value "ok" if err is nil, and
+// "error" otherwise.
+func Result(err error) Label {
+	if err != nil {
+		return L("result", "error")
+	}
+	return L("result", "ok")
+}
@@ -0,0 +1,179 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+)
+
+// DefaultBuckets are the upper bounds, in seconds, of the histogram buckets
+// used by a Registry with no Buckets set. They are those used by default by
+// Prometheus client libraries.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// A Registry is a Sink holding the measurements it receives in memory, and
+// an http.Handler serving them in the Prometheus text exposition format,
+// for use as a scrape target. The zero value is ready for use.
+type Registry struct {
+	// Buckets are the upper bounds of the buckets of each histogram, in
+	// increasing order. If nil, DefaultBuckets is used. It must not be
+	// changed once measurements are made.
+	Buckets []float64
+
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+type family struct {
+	histogram bool
+	series    map[string]*series // by formatted labels
+}
+
+type series struct {
+	labels  []Label
+	value   float64  // counters
+	counts  []uint64 // histograms, per bucket
+	sum     float64
+	samples uint64
+}
+
+// Add implements Sink.
+func (r *Registry) Add(name string, v float64, labels ...Label) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.series(name, false, labels).value += v
+}
+
+// Observe implements Sink.
+func (r *Registry) Observe(name string, v float64, labels ...Label) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.series(name, true, labels)
+	if s.counts == nil {
+		s.counts = make([]uint64, len(r.buckets()))
+	}
+	for i, le := range r.buckets() {
+		if v <= le {
+			s.counts[i]++
+		}
+	}
+	s.sum += v
+	s.samples++
+}
+
+func (r *Registry) buckets() []float64 {
+	if r.Buckets == nil {
+		return DefaultBuckets
+	}
+	return r.Buckets
+}
+
+// series returns the series of name with labels, creating it if needed. A
+// name first used as a counter stays a counter, and likewise for
+// histograms; measurements of the other kind are recorded in a family
+// named with a suffix of "_counter" or "_histogram" instead.
+func (r *Registry) series(name string, histogram bool, labels []Label) *series {
+	if r.families == nil {
+		r.families = map[string]*family{}
+	}
+	f := r.families[name]
+	if f != nil && f.histogram != histogram {
+		if histogram {
+			name += "_histogram"
+		} else {
+			name += "_counter"
+		}
+		f = r.families[name]
+	}
+	if f == nil {
+		f = &family{histogram: histogram, series: map[string]*series{}}
+		r.families[name] = f
+	}
+	labels = slices.Clone(labels)
+	slices.SortFunc(labels, func(a, b Label) bool { return a.Name < b.Name })
+	key := formatLabels(labels)
+	s := f.series[key]
+	if s == nil {
+		s = &series{labels: labels}
+		f.series[key] = s
+	}
+	return s
+}
+
+// ServeHTTP serves the measurements in r in the Prometheus text exposition
+// format.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	r.WriteTo(w)
+}
+
+// WriteTo writes the measurements in r to w in the Prometheus text
+// exposition format, with families and series sorted by name and labels.
+func (r *Registry) WriteTo(w io.Writer) (n int64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := func(format string, args ...any) {
+		if err == nil {
+			var m int
+			m, err = fmt.Fprintf(w, format, args...)
+			n += int64(m)
+		}
+	}
+	names := maps.Keys(r.families)
+	slices.Sort(names)
+	for _, name := range names {
+		f := r.families[name]
+		keys := maps.Keys(f.series)
+		slices.Sort(keys)
+		if !f.histogram {
+			count("# TYPE %s counter\n", name)
+			for _, k := range keys {
+				count("%s%s %s\n", name, k, formatFloat(f.series[k].value))
+			}
+			continue
+		}
+		count("# TYPE %s histogram\n", name)
+		for _, k := range keys {
+			s := f.series[k]
+			for i, le := range r.buckets() {
+				count("%s_bucket%s %d\n", name, formatLabels(append(slices.Clone(s.labels), L("le", formatFloat(le)))), s.counts[i])
+			}
+			count("%s_bucket%s %d\n", name, formatLabels(append(slices.Clone(s.labels), L("le", "+Inf"))), s.samples)
+			count("%s_sum%s %s\n", name, k, formatFloat(s.sum))
+			count("%s_count%s %d\n", name, k, s.samples)
+		}
+	}
+	return n, err
+}
+
+func formatLabels(labels []Label) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, l := range labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(l.Name)
+		b.WriteString(`="`)
+		b.WriteString(labelEscaper.Replace(l.Value))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
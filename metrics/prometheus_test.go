@@ -0,0 +1,39 @@
+package metrics
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"kr.dev/diff"
+)
+
+func TestRegistry(t *testing.T) {
+	r := &Registry{Buckets: []float64{0.1, 1}}
+	r.Add("tier_things_total", 1, L("status", "ok"))
+	r.Add("tier_things_total", 2, L("status", "ok"))
+	r.Add("tier_things_total", 1, L("status", `say "hi"`))
+	r.Observe("tier_wait_seconds", 0.05, L("b", "2"), L("a", "1"))
+	r.Observe("tier_wait_seconds", 0.5, L("a", "1"), L("b", "2"))
+	r.Observe("tier_wait_seconds", 5)
+	r.Add("tier_wait_seconds", 1) // kind mismatch
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("GET", "/metrics", nil))
+	diff.Test(t, t.Errorf, w.Body.String(), `# TYPE tier_things_total counter
+tier_things_total{status="ok"} 3
+tier_things_total{status="say \"hi\""} 1
+# TYPE tier_wait_seconds histogram
+tier_wait_seconds_bucket{le="0.1"} 0
+tier_wait_seconds_bucket{le="1"} 0
+tier_wait_seconds_bucket{le="+Inf"} 1
+tier_wait_seconds_sum 5
+tier_wait_seconds_count 1
+tier_wait_seconds_bucket{a="1",b="2",le="0.1"} 1
+tier_wait_seconds_bucket{a="1",b="2",le="1"} 2
+tier_wait_seconds_bucket{a="1",b="2",le="+Inf"} 2
+tier_wait_seconds_sum{a="1",b="2"} 0.55
+tier_wait_seconds_count{a="1",b="2"} 2
+# TYPE tier_wait_seconds_counter counter
+tier_wait_seconds_counter 1
+`)
+}
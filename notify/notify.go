@@ -0,0 +1,331 @@
+// Package notify provides control.Notifiers posting the lifecycle events of
+// orgs to a Webhook, signed so receivers can verify them, or to a Slack
+// incoming webhook, as messages rendered from templates.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"tier.run/api/apitypes"
+	"tier.run/control"
+	"tier.run/refs"
+)
+
+// Event types.
+const (
+	EventSubscribe     = "subscribe"      // Notifier.OnSubscribe
+	EventCancel        = "cancel"         // Notifier.OnCancel
+	EventPaymentFailed = "payment_failed" // Notifier.OnPaymentFailed
+	EventLimitReached  = "limit_reached"  // Notifier.OnLimitReached
+	EventReport        = "report"         // Notifier.OnReport
+)
+
+// An Event is a lifecycle event of an org, as posted by Webhook, passed to
+// the templates of Slack, and sent by Stream. Its Type is one of the Event
+// constants.
+type Event = apitypes.Event
+
+// timeout bounds the time taken to post each event.
+const timeout = 10 * time.Second
+
+// poster posts events in the background, so that Notifier methods return
+// quickly.
+type poster struct {
+	// HTTPClient is the client to post with. If nil,
+	// http.DefaultClient is used.
+	HTTPClient *http.Client
+
+	// Logf, if non-nil, logs the events that could not be posted.
+	Logf func(format string, args ...any)
+
+	wg sync.WaitGroup
+}
+
+// Wait waits for the events being posted, such as before exiting.
+func (p *poster) Wait() { p.wg.Wait() }
+
+// post posts the JSON body made by body for e to url in the background,
+// with the headers set by header, if not nil.
+func (p *poster) post(e Event, url string, body func(Event) ([]byte, error), header func(http.Header, []byte)) {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		data, err := body(e)
+		if err == nil {
+			err = p.do(url, data, header)
+		}
+		if err != nil && p.Logf != nil {
+			p.Logf("notify: %s %s: %v", e.Type, e.Org, err)
+		}
+	}()
+}
+
+func (p *poster) do(url string, data []byte, header func(http.Header, []byte)) error {
+	// Requests are not tied to the context of the event, which may end
+	// before the event is posted.
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if header != nil {
+		header(req.Header, data)
+	}
+	c := p.HTTPClient
+	if c == nil {
+		c = http.DefaultClient
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	res.Body.Close()
+	if res.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", res.Status)
+	}
+	return nil
+}
+
+func subscribeEvent(org string, phases []control.Phase) Event {
+	e := Event{Type: EventSubscribe, Org: org, At: time.Now()}
+	if len(phases) > 0 {
+		e.Features = phases[0].Features
+	}
+	return e
+}
+
+func cancelEvent(org string) Event {
+	return Event{Type: EventCancel, Org: org, At: time.Now()}
+}
+
+func paymentFailedEvent(org string, in control.Invoice) Event {
+	ain := apitypes.Invoice(in)
+	return Event{Type: EventPaymentFailed, Org: org, At: time.Now(), Invoice: &ain}
+}
+
+func reportEvent(org string, feature refs.Name, use control.Report) Event {
+	return Event{Type: EventReport, Org: org, At: time.Now(), Report: &apitypes.EventReport{
+		Feature: feature,
+		N:       use.N,
+		Clobber: use.Clobber,
+	}}
+}
+
+func limitReachedEvent(org string, u control.Usage) Event {
+	return Event{Type: EventLimitReached, Org: org, At: time.Now(), Usage: &apitypes.Usage{
+		Feature: u.Feature.Name(),
+		Used:    u.Used,
+		Limit:   u.Limit,
+		ByOrg:   u.ByOrg,
+	}}
+}
+
+// A Webhook is a control.Notifier posting each event, as JSON, to URL.
+// Since usage is reported often, EventReport events are posted only if
+// listed in Events.
+//
+// If Secret is set, events are signed with it in a Tier-Signature header,
+// as Stripe signs webhooks in its Stripe-Signature header, so that
+// receivers can verify them using stripe.VerifyWebhook, or
+// stripe.VerifyWebhookTolerance if they may receive events late.
+type Webhook struct {
+	poster
+
+	URL    string
+	Secret string
+
+	// Events, if not empty, are the types of events to post. Other
+	// events are ignored.
+	Events []string
+}
+
+var _ control.Notifier = (*Webhook)(nil)
+
+func (w *Webhook) OnSubscribe(_ context.Context, org string, phases []control.Phase) {
+	w.send(subscribeEvent(org, phases))
+}
+
+func (w *Webhook) OnCancel(_ context.Context, org string) {
+	w.send(cancelEvent(org))
+}
+
+func (w *Webhook) OnPaymentFailed(_ context.Context, org string, in control.Invoice) {
+	w.send(paymentFailedEvent(org, in))
+}
+
+func (w *Webhook) OnLimitReached(_ context.Context, org string, u control.Usage) {
+	w.send(limitReachedEvent(org, u))
+}
+
+func (w *Webhook) OnReport(_ context.Context, org string, feature refs.Name, use control.Report) {
+	w.send(reportEvent(org, feature, use))
+}
+
+func (w *Webhook) send(e Event) {
+	if len(w.Events) == 0 && e.Type == EventReport {
+		return
+	}
+	if len(w.Events) > 0 && !slices.Contains(w.Events, e.Type) {
+		return
+	}
+	w.post(e, w.URL, func(e Event) ([]byte, error) {
+		return json.Marshal(e)
+	}, w.sign)
+}
+
+func (w *Webhook) sign(h http.Header, payload []byte) {
+	if w.Secret == "" {
+		return
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(w.Secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte{'.'})
+	mac.Write(payload)
+	h.Set("Tier-Signature", "t="+ts+",v1="+hex.EncodeToString(mac.Sum(nil)))
+}
+
+// DefaultSlackTemplates are the templates used by a Slack with no
+// Templates, posting failed payments and limits reached.
+var DefaultSlackTemplates = map[string]string{
+	EventPaymentFailed: `:warning: Payment of {{amount .Invoice.AmountDue .Invoice.Currency}} by {{.Org}} failed.{{with .Invoice.URL}} <{{.}}|View invoice>{{end}}`,
+	EventLimitReached:  `{{.Org}} reached its limit of {{.Usage.Limit}} for {{.Usage.Feature}}.`,
+}
+
+// Slack is a control.Notifier posting events to a Slack incoming webhook at
+// URL.
+type Slack struct {
+	poster
+
+	URL string
+
+	// Templates maps event types to text/template templates, executed
+	// with the Event to render the text of its message. Events without a
+	// template are not posted. If nil, DefaultSlackTemplates is used.
+	//
+	// Templates may use the function amount, which formats an amount in
+	// the smallest unit of a currency, such as {{amount 1050 "usd"}} for
+	// "10.50 USD".
+	Templates map[string]string
+}
+
+var _ control.Notifier = (*Slack)(nil)
+
+func (s *Slack) OnSubscribe(_ context.Context, org string, phases []control.Phase) {
+	s.send(subscribeEvent(org, phases))
+}
+
+func (s *Slack) OnCancel(_ context.Context, org string) {
+	s.send(cancelEvent(org))
+}
+
+func (s *Slack) OnPaymentFailed(_ context.Context, org string, in control.Invoice) {
+	s.send(paymentFailedEvent(org, in))
+}
+
+func (s *Slack) OnLimitReached(_ context.Context, org string, u control.Usage) {
+	s.send(limitReachedEvent(org, u))
+}
+
+func (s *Slack) OnReport(_ context.Context, org string, feature refs.Name, use control.Report) {
+	s.send(reportEvent(org, feature, use))
+}
+
+func (s *Slack) send(e Event) {
+	templates := s.Templates
+	if templates == nil {
+		templates = DefaultSlackTemplates
+	}
+	text, ok := templates[e.Type]
+	if !ok {
+		return
+	}
+	s.post(e, s.URL, func(e Event) ([]byte, error) {
+		msg, err := render(text, e)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(struct {
+			Text string `json:"text"`
+		}{msg})
+	}, nil)
+}
+
+// render executes the template text with e.
+func render(text string, e Event) (string, error) {
+	t, err := template.New(e.Type).Funcs(template.FuncMap{"amount": amount}).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if err := t.Execute(&b, e); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// zeroDecimal are the currencies Stripe has no smaller unit of.
+var zeroDecimal = []string{"bif", "clp", "djf", "gnf", "jpy", "kmf", "krw", "mga", "pyg", "rwf", "ugx", "vnd", "vuv", "xaf", "xof", "xpf"}
+
+// amount formats n in the smallest unit of currency.
+func amount(n int, currency string) string {
+	code := strings.ToUpper(currency)
+	if slices.Contains(zeroDecimal, strings.ToLower(currency)) {
+		return fmt.Sprintf("%d %s", n, code)
+	}
+	sign := ""
+	if n < 0 {
+		sign, n = "-", -n
+	}
+	return fmt.Sprintf("%s%d.%02d %s", sign, n/100, n%100, code)
+}
+
+// Multi is a control.Notifier notifying each of its Notifiers in turn.
+type Multi []control.Notifier
+
+var _ control.Notifier = Multi(nil)
+
+func (m Multi) OnSubscribe(ctx context.Context, org string, phases []control.Phase) {
+	for _, n := range m {
+		n.OnSubscribe(ctx, org, phases)
+	}
+}
+
+func (m Multi) OnCancel(ctx context.Context, org string) {
+	for _, n := range m {
+		n.OnCancel(ctx, org)
+	}
+}
+
+func (m Multi) OnPaymentFailed(ctx context.Context, org string, in control.Invoice) {
+	for _, n := range m {
+		n.OnPaymentFailed(ctx, org, in)
+	}
+}
+
+func (m Multi) OnLimitReached(ctx context.Context, org string, u control.Usage) {
+	for _, n := range m {
+		n.OnLimitReached(ctx, org, u)
+	}
+}
+
+func (m Multi) OnReport(ctx context.Context, org string, feature refs.Name, use control.Report) {
+	for _, n := range m {
+		n.OnReport(ctx, org, feature, use)
+	}
+}
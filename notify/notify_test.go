@@ -0,0 +1,120 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"kr.dev/diff"
+	"tier.run/control"
+	"tier.run/refs"
+	"tier.run/stripe"
+)
+
+type request struct {
+	header http.Header
+	body   []byte
+}
+
+func newServer(t *testing.T) (*httptest.Server, func() []request) {
+	var (
+		mu   sync.Mutex
+		reqs []request
+	)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		reqs = append(reqs, request{r.Header, body})
+	}))
+	t.Cleanup(s.Close)
+	return s, func() []request {
+		mu.Lock()
+		defer mu.Unlock()
+		return reqs
+	}
+}
+
+func TestWebhook(t *testing.T) {
+	s, reqs := newServer(t)
+	w := &Webhook{
+		URL:    s.URL,
+		Secret: "whsec_test",
+		Events: []string{EventSubscribe, EventPaymentFailed},
+	}
+	w.Logf = t.Logf
+
+	ctx := context.Background()
+	fp := refs.MustParseFeaturePlans("feature:x@plan:free@0")
+	w.OnSubscribe(ctx, "org:a", []control.Phase{{Features: fp}})
+	w.OnCancel(ctx, "org:a") // not in Events
+	w.OnPaymentFailed(ctx, "org:a", control.Invoice{ID: "in_1", Currency: "usd", AmountDue: 1050})
+	w.Wait()
+
+	got := map[string]Event{}
+	for _, r := range reqs() {
+		if err := stripe.VerifyWebhook(r.body, r.header.Get("Tier-Signature"), w.Secret); err != nil {
+			t.Errorf("verifying %s: %v", r.body, err)
+		}
+		var e Event
+		if err := json.Unmarshal(r.body, &e); err != nil {
+			t.Fatal(err)
+		}
+		got[e.Type] = e
+	}
+	if len(got) != 2 {
+		t.Fatalf("got events %v; want %s and %s", got, EventSubscribe, EventPaymentFailed)
+	}
+	diff.Test(t, t.Errorf, got[EventSubscribe].Features, fp)
+	if in := got[EventPaymentFailed].Invoice; in == nil || in.ID != "in_1" || in.AmountDue != 1050 {
+		t.Errorf("invoice = %+v; want in_1 with 1050 due", in)
+	}
+}
+
+func TestSlack(t *testing.T) {
+	s, reqs := newServer(t)
+	sl := &Slack{URL: s.URL}
+	sl.Logf = t.Logf
+
+	ctx := context.Background()
+	sl.OnSubscribe(ctx, "org:a", nil) // no default template
+	sl.OnPaymentFailed(ctx, "org:a", control.Invoice{Currency: "usd", AmountDue: 1050, URL: "https://example.com/in"})
+	sl.Wait()
+	sl.Templates = map[string]string{EventLimitReached: `{{.Org}}: {{.Usage.Used}}/{{.Usage.Limit}} {{amount 500 "jpy"}}`}
+	sl.OnLimitReached(ctx, "org:b", control.Usage{Feature: refs.MustParseFeaturePlan("feature:x@plan:free@0"), Used: 10, Limit: 10})
+	sl.OnPaymentFailed(ctx, "org:b", control.Invoice{}) // no template
+	sl.Wait()
+
+	var got []string
+	for _, r := range reqs() {
+		var msg struct{ Text string }
+		if err := json.Unmarshal(r.body, &msg); err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, msg.Text)
+	}
+	diff.Test(t, t.Errorf, got, []string{
+		":warning: Payment of 10.50 USD by org:a failed. <https://example.com/in|View invoice>",
+		"org:b: 10/10 500 JPY",
+	})
+}
+
+func TestMulti(t *testing.T) {
+	s, reqs := newServer(t)
+	a := &Webhook{URL: s.URL}
+	b := &Webhook{URL: s.URL}
+	Multi{a, b}.OnCancel(context.Background(), "org:a")
+	Multi{a, b}.OnReport(context.Background(), "org:a", refs.MustParseName("feature:x"), control.Report{N: 1}) // not in Events
+	a.Wait()
+	b.Wait()
+	if n := len(reqs()); n != 2 {
+		t.Errorf("got %d requests; want 2", n)
+	}
+}
@@ -0,0 +1,134 @@
+package notify
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/exp/slices"
+	"tier.run/control"
+	"tier.run/refs"
+	"tier.run/values"
+)
+
+// A Stream is a control.Notifier keeping the recent events of orgs, and
+// sending each event to its subscribers as it happens, such as for
+// sidecars streaming events from /v1/events.
+//
+// Subscribers that fall behind miss events, rather than holding up the
+// requests notifying the Stream.
+type Stream struct {
+	// Size is the number of recent events kept. If zero, 100 are kept.
+	Size int
+
+	mu     sync.Mutex
+	recent []Event
+	subs   map[*subscriber]bool
+	closed bool
+}
+
+type subscriber struct {
+	org string // if empty, all orgs
+	c   chan Event
+}
+
+// subscriberBuffer is the number of events a subscriber may fall behind by
+// before missing events.
+const subscriberBuffer = 64
+
+var _ control.Notifier = (*Stream)(nil)
+
+func (s *Stream) OnSubscribe(_ context.Context, org string, phases []control.Phase) {
+	s.send(subscribeEvent(org, phases))
+}
+
+func (s *Stream) OnCancel(_ context.Context, org string) {
+	s.send(cancelEvent(org))
+}
+
+func (s *Stream) OnPaymentFailed(_ context.Context, org string, in control.Invoice) {
+	s.send(paymentFailedEvent(org, in))
+}
+
+func (s *Stream) OnLimitReached(_ context.Context, org string, u control.Usage) {
+	s.send(limitReachedEvent(org, u))
+}
+
+func (s *Stream) OnReport(_ context.Context, org string, feature refs.Name, use control.Report) {
+	s.send(reportEvent(org, feature, use))
+}
+
+func (s *Stream) send(e Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.recent = append(s.recent, e)
+	if n := len(s.recent) - values.Coalesce(s.Size, 100); n > 0 {
+		s.recent = slices.Delete(s.recent, 0, n)
+	}
+	for sub := range s.subs {
+		if sub.org != "" && sub.org != e.Org {
+			continue
+		}
+		select {
+		case sub.c <- e:
+		default: // fallen behind
+		}
+	}
+}
+
+// Recent returns the recent events of org, oldest first, or of all orgs if
+// org is empty.
+func (s *Stream) Recent(org string) []Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recentLocked(org)
+}
+
+func (s *Stream) recentLocked(org string) []Event {
+	var es []Event
+	for _, e := range s.recent {
+		if org == "" || e.Org == org {
+			es = append(es, e)
+		}
+	}
+	return es
+}
+
+// Subscribe returns the recent events of org, as Recent does, and a channel
+// receiving the events of org that follow them. The channel is closed once
+// cancel is called, or the Stream is closed.
+func (s *Stream) Subscribe(org string) (recent []Event, events <-chan Event, cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sub := &subscriber{org: org, c: make(chan Event, subscriberBuffer)}
+	if s.closed {
+		close(sub.c)
+		return s.recentLocked(org), sub.c, func() {}
+	}
+	if s.subs == nil {
+		s.subs = map[*subscriber]bool{}
+	}
+	s.subs[sub] = true
+	return s.recentLocked(org), sub.c, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.subs[sub] {
+			delete(s.subs, sub)
+			close(sub.c)
+		}
+	}
+}
+
+// Close closes the channels of all subscribers, such as when shutting
+// down. Events sent after Close are dropped.
+func (s *Stream) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	for sub := range s.subs {
+		close(sub.c)
+	}
+	s.subs = nil
+}
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"context"
+	"testing"
+
+	"kr.dev/diff"
+)
+
+func describe(es []Event) []string {
+	var s []string
+	for _, e := range es {
+		s = append(s, e.Type+" "+e.Org)
+	}
+	return s
+}
+
+func TestStream(t *testing.T) {
+	ctx := context.Background()
+	s := &Stream{Size: 2}
+	s.OnCancel(ctx, "org:a")
+	s.OnCancel(ctx, "org:b")
+	s.OnCancel(ctx, "org:a")
+	diff.Test(t, t.Errorf, describe(s.Recent("")), []string{"cancel org:b", "cancel org:a"})
+
+	recent, events, cancel := s.Subscribe("org:a")
+	diff.Test(t, t.Errorf, describe(recent), []string{"cancel org:a"})
+	_, all, _ := s.Subscribe("")
+	s.OnCancel(ctx, "org:b")
+	s.OnCancel(ctx, "org:a")
+	if e := <-events; e.Org != "org:a" {
+		t.Errorf("got event of %s; want org:a", e.Org)
+	}
+	cancel()
+	cancel() // no-op
+	if _, ok := <-events; ok {
+		t.Error("got event after cancel")
+	}
+
+	s.Close()
+	var got []Event
+	for e := range all {
+		got = append(got, e)
+	}
+	diff.Test(t, t.Errorf, describe(got), []string{"cancel org:b", "cancel org:a"})
+	s.OnCancel(ctx, "org:c") // dropped
+	_, events, _ = s.Subscribe("")
+	if _, ok := <-events; ok {
+		t.Error("got event after Close")
+	}
+	diff.Test(t, t.Errorf, describe(s.Recent("")), []string{"cancel org:b", "cancel org:a"})
+}
@@ -0,0 +1,195 @@
+// Package paddle is a client for the Paddle Billing API, and a
+// control.Provider backed by it.
+//
+// See https://developer.paddle.com/api-reference/overview.
+package paddle
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Base URLs of the Paddle API.
+const (
+	LiveURL    = "https://api.paddle.com"
+	SandboxURL = "https://sandbox-api.paddle.com"
+)
+
+// Error families
+//
+// An *Error matches these errors using errors.Is if it belongs to the family.
+var (
+	ErrNotFound    = errors.New("paddle: not found")    // status 404
+	ErrRateLimited = errors.New("paddle: rate limited") // status 429
+)
+
+// IsLiveKey reports whether key is a key for live Paddle accounts, rather
+// than for the sandbox.
+func IsLiveKey(key string) bool {
+	return strings.HasPrefix(key, "pdl_live_")
+}
+
+// BaseURL returns the base URL of the API serving key.
+func BaseURL(key string) string {
+	if IsLiveKey(key) {
+		return LiveURL
+	}
+	return SandboxURL
+}
+
+// Error is an error response from Paddle. See
+// https://developer.paddle.com/errors/overview for more information.
+type Error struct {
+	Type      string // (e.g. "request_error", "api_error")
+	Code      string // (e.g. "not_found")
+	Detail    string
+	DocURL    string `json:"documentation_url"`
+	RequestID string `json:"-"`
+
+	// Status is the HTTP status code of the response the error was
+	// decoded from.
+	Status int `json:"-"`
+}
+
+// Is reports if e belongs to the error family target. Known families are
+// ErrNotFound and ErrRateLimited.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return e.Status == http.StatusNotFound
+	case ErrRateLimited:
+		return e.Status == http.StatusTooManyRequests
+	}
+	return false
+}
+
+func (e *Error) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "paddle: %d %s", e.Status, e.Code)
+	if e.Detail != "" {
+		fmt.Fprintf(&b, ": %s", e.Detail)
+	}
+	if e.RequestID != "" {
+		fmt.Fprintf(&b, " (request %s)", e.RequestID)
+	}
+	return b.String()
+}
+
+// A Client makes requests to the Paddle API.
+type Client struct {
+	APIKey     string
+	BaseURL    string       // if empty, BaseURL(APIKey) is used
+	HTTPClient *http.Client // if nil, http.DefaultClient is used
+}
+
+// Do makes a request to path with body, if not nil, encoded as JSON, and
+// decodes the "data" field of the response into out, if not nil. Error
+// responses are returned as an *Error.
+func (c *Client) Do(ctx context.Context, method, path string, body, out any) error {
+	_, err := c.do(ctx, method, c.url(path), body, out)
+	return err
+}
+
+// Iter calls f with each item listed by a GET request to path with query
+// q, following pages until all items are listed or f returns false.
+func Iter[T any](ctx context.Context, c *Client, path string, q url.Values, f func(T) bool) error {
+	if q == nil {
+		q = url.Values{}
+	}
+	q.Set("per_page", "200")
+	next := c.url(path) + "?" + q.Encode()
+	for next != "" {
+		var page []T
+		meta, err := c.do(ctx, "GET", next, nil, &page)
+		if err != nil {
+			return err
+		}
+		for _, v := range page {
+			if !f(v) {
+				return nil
+			}
+		}
+		next = ""
+		if meta.Pagination.HasMore {
+			next = meta.Pagination.Next
+		}
+	}
+	return nil
+}
+
+type meta struct {
+	RequestID  string `json:"request_id"`
+	Pagination struct {
+		Next    string
+		HasMore bool `json:"has_more"`
+	}
+}
+
+func (c *Client) url(path string) string {
+	base := c.BaseURL
+	if base == "" {
+		base = BaseURL(c.APIKey)
+	}
+	return strings.TrimSuffix(base, "/") + path
+}
+
+func (c *Client) do(ctx context.Context, method, urlStr string, body, out any) (meta, error) {
+	var r io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return meta{}, err
+		}
+		r = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, urlStr, r)
+	if err != nil {
+		return meta{}, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.APIKey)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	res, err := hc.Do(req)
+	if err != nil {
+		return meta{}, err
+	}
+	defer res.Body.Close()
+
+	var v struct {
+		Data  json.RawMessage
+		Error *Error
+		Meta  meta
+	}
+	err = json.NewDecoder(res.Body).Decode(&v)
+	if err != nil && err != io.EOF && res.StatusCode/100 == 2 {
+		return meta{}, fmt.Errorf("paddle: %s %s: %w", method, urlStr, err)
+	}
+	if res.StatusCode/100 != 2 {
+		e := v.Error
+		if e == nil {
+			e = &Error{Type: "api_error", Code: http.StatusText(res.StatusCode)}
+		}
+		e.Status = res.StatusCode
+		e.RequestID = v.Meta.RequestID
+		return v.Meta, e
+	}
+	if out != nil && len(v.Data) > 0 {
+		if err := json.Unmarshal(v.Data, out); err != nil {
+			return v.Meta, err
+		}
+	}
+	return v.Meta, nil
+}
@@ -0,0 +1,745 @@
+package paddle
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"tier.run/control"
+	"tier.run/refs"
+	"tier.run/values"
+)
+
+var intervalToPaddle = map[string]string{
+	"@daily":   "day",
+	"@weekly":  "week",
+	"@monthly": "month",
+	"@yearly":  "year",
+}
+
+// maxQuantity is the largest quantity Paddle accepts for a price.
+const maxQuantity = 999999999
+
+// A Provider is a control.Provider backed by Paddle Billing.
+//
+// Each feature is pushed as a product and price, with the feature held in
+// the price's custom data. Features billed each interval at a flat price
+// are subscribed to as subscription items. Metered features must have a
+// single tier without a base price, and are billed at its price per unit
+// by one-time charges added to the next invoice as usage is reported.
+// Usage is counted in the custom data of the org's subscription.
+//
+// Paddle subscriptions are created by checkout, so ScheduleNow creates a
+// transaction for orgs without a subscription, and returns its checkout URL
+// in a *control.CheckoutRequiredError; the org is subscribed once it
+// completes checkout. Only one phase,
+// effective immediately, may be scheduled.
+//
+// Flags, variants, one-time features, and features with more than one
+// tier, packages, or aggregates other than "sum" and "perpetual" are not
+// supported, and are rejected by Push with control.ErrInvalidPrice.
+type Provider struct {
+	Paddle *Client
+	Logf   func(format string, args ...any) // if nil, nothing is logged
+
+	// orgs serializes the reports and schedules of each org, which
+	// update the usage counted in its subscription by reading and then
+	// replacing it. Each org has one subscription.
+	orgs keyedMutex
+
+	cacheMu   sync.Mutex
+	customers map[string]string // org -> customer ID
+}
+
+var _ control.Provider = (*Provider)(nil)
+
+func (p *Provider) logf(format string, args ...any) {
+	if p.Logf != nil {
+		p.Logf(format, args...)
+	}
+}
+
+type price struct {
+	ID         string
+	Status     string
+	CustomData struct {
+		Feature *storedFeature `json:"tier"`
+	} `json:"custom_data"`
+}
+
+// storedFeature is a feature as held in the custom data of its price.
+type storedFeature struct {
+	Feature      refs.FeaturePlan `json:"feature"`
+	Title        string           `json:"title,omitempty"`
+	PlanTitle    string           `json:"plan_title,omitempty"`
+	PlanTags     []string         `json:"plan_tags,omitempty"`
+	Unit         string           `json:"unit,omitempty"`
+	Description  string           `json:"description,omitempty"`
+	DisplayOrder int              `json:"display_order,omitempty"`
+	TrialDays    int              `json:"trial_days,omitempty"`
+	Interval     string           `json:"interval"`
+	Currency     string           `json:"currency"`
+	Base         int              `json:"base,omitempty"`
+	Mode         string           `json:"mode,omitempty"`
+	Aggregate    string           `json:"aggregate,omitempty"`
+	Tiers        []storedTier     `json:"tiers,omitempty"`
+	Aliases      []refs.Name      `json:"aliases,omitempty"`
+}
+
+// storedTier is a tier of a storedFeature. Paddle may not keep integers
+// as large as control.Inf exactly, so unlimited tiers have Upto -1.
+type storedTier struct {
+	Upto  int            `json:"upto"`
+	Price values.Decimal `json:"price"`
+}
+
+func storeFeature(f control.Feature) storedFeature {
+	s := storedFeature{
+		Feature:      f.FeaturePlan,
+		Title:        f.Title,
+		PlanTitle:    f.PlanTitle,
+		PlanTags:     f.PlanTags,
+		Unit:         f.Unit,
+		Description:  f.Description,
+		DisplayOrder: f.DisplayOrder,
+		TrialDays:    f.TrialDays,
+		Interval:     f.Interval,
+		Currency:     f.Currency,
+		Base:         f.Base,
+		Mode:         f.Mode,
+		Aggregate:    f.Aggregate,
+		Aliases:      f.Aliases,
+	}
+	for _, t := range f.Tiers {
+		if t.Upto == control.Inf {
+			t.Upto = -1
+		}
+		s.Tiers = append(s.Tiers, storedTier{Upto: t.Upto, Price: t.Price})
+	}
+	return s
+}
+
+func (s storedFeature) feature() control.Feature {
+	f := control.Feature{
+		FeaturePlan:  s.Feature,
+		Title:        s.Title,
+		PlanTitle:    s.PlanTitle,
+		PlanTags:     s.PlanTags,
+		Unit:         s.Unit,
+		Description:  s.Description,
+		DisplayOrder: s.DisplayOrder,
+		TrialDays:    s.TrialDays,
+		Interval:     s.Interval,
+		Currency:     s.Currency,
+		Base:         s.Base,
+		Mode:         s.Mode,
+		Aggregate:    s.Aggregate,
+		Aliases:      s.Aliases,
+	}
+	for _, t := range s.Tiers {
+		if t.Upto == -1 {
+			t.Upto = control.Inf
+		}
+		f.Tiers = append(f.Tiers, control.Tier{Upto: t.Upto, Price: t.Price})
+	}
+	return f
+}
+
+type customer struct {
+	ID         string
+	Email      string
+	Name       string
+	CustomData customerData `json:"custom_data"`
+}
+
+type customerData struct {
+	Org         string            `json:"tier.org"`
+	Description string            `json:"tier.description,omitempty"`
+	Phone       string            `json:"tier.phone,omitempty"`
+	Metadata    map[string]string `json:"tier.metadata,omitempty"`
+}
+
+type subscription struct {
+	ID        string
+	StartedAt time.Time `json:"started_at"`
+	Period    struct {
+		Start time.Time `json:"starts_at"`
+		End   time.Time `json:"ends_at"`
+	} `json:"current_billing_period"`
+	CustomData subscriptionData `json:"custom_data"`
+}
+
+type subscriptionData struct {
+	Org      string             `json:"tier.org"`
+	Features []refs.FeaturePlan `json:"tier.features"`
+	Usage    map[string]usage   `json:"tier.usage,omitempty"` // by feature ID
+}
+
+// usage is the usage of a metered feature counted since Period began.
+type usage struct {
+	Period time.Time `json:"period"`
+	Used   int       `json:"used"`
+}
+
+type item struct {
+	PriceID  string `json:"price_id"`
+	Quantity int    `json:"quantity"`
+}
+
+// checkFeature reports an error wrapping control.ErrInvalidPrice if f
+// cannot be pushed to Paddle.
+func checkFeature(f control.Feature) error {
+	invalid := func(format string, args ...any) error {
+		return fmt.Errorf("%w: %s: paddle: "+format, append([]any{control.ErrInvalidPrice, f.FeaturePlan}, args...)...)
+	}
+	switch {
+	case f.Flag:
+		return invalid("flags are not supported")
+	case f.Variant:
+		return invalid("variants are not supported")
+	case f.OneTime:
+		return invalid("one-time features are not supported")
+	case intervalToPaddle[f.Interval] == "":
+		return invalid("interval %q is not supported", f.Interval)
+	case f.Currency == "":
+		return invalid("currency is required")
+	}
+	if !f.IsMetered() {
+		return nil
+	}
+	if len(f.Tiers) != 1 || f.Mode == "package" {
+		return invalid("metered features must have a single tier, and not be packages")
+	}
+	t := f.Tiers[0]
+	if t.Base != 0 || t.Price.Places() > 0 {
+		return invalid("tier prices must be whole amounts, without a base price")
+	}
+	if f.Aggregate != "" && f.Aggregate != "sum" && f.Aggregate != "perpetual" {
+		return invalid("aggregate %q is not supported", f.Aggregate)
+	}
+	return nil
+}
+
+// Push pushes each feature in fs to Paddle as a product and price, calling
+// cb with each feature and the error pushing it, if any. As with
+// control.Client.Push, features in plans already pushed are skipped with
+// control.ErrPlanExists, and add-ons in no plan already pushed are skipped
+// with control.ErrFeatureExists. If any feature cannot be pushed to Paddle,
+// nothing is pushed. It returns the first error encountered, if any.
+func (p *Provider) Push(ctx context.Context, fs []control.Feature, cb control.PushReportFunc) error {
+	return p.push(ctx, fs, cb, false)
+}
+
+// PushDryRun is like Push, but makes no changes in Paddle.
+func (p *Provider) PushDryRun(ctx context.Context, fs []control.Feature, cb control.PushReportFunc) error {
+	return p.push(ctx, fs, cb, true)
+}
+
+func (p *Provider) push(ctx context.Context, fs []control.Feature, cb control.PushReportFunc, dryRun bool) error {
+	for _, f := range fs {
+		if err := checkFeature(f); err != nil {
+			cb(f, err)
+			return err
+		}
+	}
+	pulled, err := p.PullWithOptions(ctx, control.PullOptions{Archived: true})
+	if err != nil {
+		return err
+	}
+	pushed := map[refs.Plan]bool{}
+	ids := map[string]bool{} // of add-ons in no plan
+	for _, f := range pulled {
+		pushed[f.Plan()] = true
+		if f.Plan().IsZero() {
+			ids[f.ID()] = true
+		}
+	}
+
+	var first error
+	for _, f := range fs {
+		var err error
+		switch {
+		case !f.Plan().IsZero() && pushed[f.Plan()]:
+			err = fmt.Errorf("%w: %s", control.ErrPlanExists, f.Plan())
+		case f.Plan().IsZero() && ids[f.ID()]:
+			err = fmt.Errorf("%w: %s", control.ErrFeatureExists, f.FeaturePlan)
+		case !dryRun:
+			f.ProviderID, err = p.pushFeature(ctx, f)
+		}
+		cb(f, err)
+		if first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (p *Provider) pushFeature(ctx context.Context, f control.Feature) (string, error) {
+	var product struct{ ID string }
+	err := p.Paddle.Do(ctx, "POST", "/products", map[string]any{
+		"name":         values.Coalesce(f.Title, f.Name().String()),
+		"description":  f.Description,
+		"tax_category": "standard",
+		"custom_data":  map[string]any{"tier.feature": f.FeaturePlan},
+	}, &product)
+	if err != nil {
+		return "", err
+	}
+
+	body := map[string]any{
+		"product_id":  product.ID,
+		"description": f.ID(),
+		"custom_data": map[string]any{"tier": storeFeature(f)},
+	}
+	if f.IsMetered() {
+		body["unit_price"] = amount(f.Tiers[0].Price.String(), f.Currency)
+		body["quantity"] = map[string]int{"minimum": 1, "maximum": maxQuantity}
+	} else {
+		body["unit_price"] = amount(strconv.Itoa(f.Base), f.Currency)
+		body["billing_cycle"] = map[string]any{"interval": intervalToPaddle[f.Interval], "frequency": 1}
+		if f.TrialDays > 0 {
+			body["trial_period"] = map[string]any{"interval": "day", "frequency": f.TrialDays}
+		}
+	}
+	var pr struct{ ID string }
+	if err := p.Paddle.Do(ctx, "POST", "/prices", body, &pr); err != nil {
+		return "", err
+	}
+	return pr.ID, nil
+}
+
+// amount returns the Paddle money object for cents, a whole number of the
+// smallest unit of currency.
+func amount(cents, currency string) map[string]string {
+	return map[string]string{
+		"amount":        cents,
+		"currency_code": strings.ToUpper(currency),
+	}
+}
+
+// PullWithOptions returns the features pushed to Paddle matching opts.
+func (p *Provider) PullWithOptions(ctx context.Context, opts control.PullOptions) ([]control.Feature, error) {
+	q := url.Values{}
+	if !opts.Archived {
+		q.Set("status", "active")
+	}
+	var fs []control.Feature
+	err := Iter(ctx, p.Paddle, "/prices", q, func(pr price) bool {
+		s := pr.CustomData.Feature
+		switch {
+		case s == nil:
+		case !opts.Plan.IsZero() && s.Feature.Plan() != opts.Plan:
+		case !strings.HasPrefix(s.Feature.Name().String(), opts.FeaturePrefix):
+		case opts.Tag != "" && !slices.Contains(s.PlanTags, opts.Tag):
+		default:
+			f := s.feature()
+			f.ProviderID = pr.ID
+			f.ReportID = pr.ID
+			f.Archived = pr.Status == "archived"
+			fs = append(fs, f)
+		}
+		return true
+	})
+	return fs, err
+}
+
+// Diff reports how the pricing model made up of fs differs from the
+// features pushed to Paddle, as control.Client.Diff does.
+func (p *Provider) Diff(ctx context.Context, fs []control.Feature) ([]control.Change, error) {
+	pulled, err := p.PullWithOptions(ctx, control.PullOptions{Archived: true})
+	if err != nil {
+		return nil, err
+	}
+	return control.DiffFeatures(pulled, fs), nil
+}
+
+// WhoIs returns the ID of the Paddle customer for org, or
+// control.ErrOrgNotFound if there is none.
+func (p *Provider) WhoIs(ctx context.Context, org string) (string, error) {
+	c, err := p.lookupCustomer(ctx, org)
+	if err != nil {
+		return "", err
+	}
+	return c.ID, nil
+}
+
+// LookupOrg returns the info of the Paddle customer for org.
+func (p *Provider) LookupOrg(ctx context.Context, org string) (*control.OrgInfo, error) {
+	c, err := p.lookupCustomer(ctx, org)
+	if err != nil {
+		return nil, err
+	}
+	return &control.OrgInfo{
+		Email:       c.Email,
+		Name:        c.Name,
+		Description: c.CustomData.Description,
+		Phone:       c.CustomData.Phone,
+		Metadata:    c.CustomData.Metadata,
+	}, nil
+}
+
+func (p *Provider) lookupCustomer(ctx context.Context, org string) (customer, error) {
+	if !strings.HasPrefix(org, "org:") {
+		return customer{}, &control.ValidationError{Message: "org must be prefixed with \"org:\""}
+	}
+
+	p.cacheMu.Lock()
+	id := p.customers[org]
+	p.cacheMu.Unlock()
+	if id != "" {
+		var c customer
+		err := p.Paddle.Do(ctx, "GET", "/customers/"+id, nil, &c)
+		return c, err
+	}
+
+	var c customer
+	err := Iter(ctx, p.Paddle, "/customers", nil, func(v customer) bool {
+		if v.CustomData.Org == org {
+			c = v
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return customer{}, err
+	}
+	if c.ID == "" {
+		return customer{}, control.ErrOrgNotFound
+	}
+	p.cacheCustomer(org, c.ID)
+	return c, nil
+}
+
+func (p *Provider) cacheCustomer(org, id string) {
+	p.cacheMu.Lock()
+	defer p.cacheMu.Unlock()
+	if p.customers == nil {
+		p.customers = map[string]string{}
+	}
+	p.customers[org] = id
+}
+
+// putCustomer creates or updates the customer for org with info, if not
+// nil, and returns its ID. Paddle requires an email for new customers.
+func (p *Provider) putCustomer(ctx context.Context, org string, info *control.OrgInfo) (string, error) {
+	c, err := p.lookupCustomer(ctx, org)
+	if err != nil && !errors.Is(err, control.ErrOrgNotFound) {
+		return "", err
+	}
+	if info == nil {
+		if c.ID == "" {
+			return "", fmt.Errorf("%w: paddle requires an email for new orgs", control.ErrInvalidEmail)
+		}
+		return c.ID, nil
+	}
+
+	body := map[string]any{
+		"email": info.Email,
+		"custom_data": customerData{
+			Org:         org,
+			Description: info.Description,
+			Phone:       info.Phone,
+			Metadata:    info.Metadata,
+		},
+	}
+	if info.Name != "" {
+		body["name"] = info.Name
+	}
+	if c.ID != "" {
+		return c.ID, p.Paddle.Do(ctx, "PATCH", "/customers/"+c.ID, body, nil)
+	}
+	if info.Email == "" {
+		return "", fmt.Errorf("%w: paddle requires an email for new orgs", control.ErrInvalidEmail)
+	}
+	if err := p.Paddle.Do(ctx, "POST", "/customers", body, &c); err != nil {
+		return "", err
+	}
+	p.cacheCustomer(org, c.ID)
+	return c.ID, nil
+}
+
+// lookupSubscription returns the subscription of the customer with ID cid
+// that is not canceled, or nil if there is none.
+func (p *Provider) lookupSubscription(ctx context.Context, cid string) (*subscription, error) {
+	q := url.Values{}
+	q.Set("customer_id", cid)
+	q.Set("status", "active,trialing,past_due,paused")
+	var sub *subscription
+	err := Iter(ctx, p.Paddle, "/subscriptions", q, func(s subscription) bool {
+		sub = &s
+		return false
+	})
+	return sub, err
+}
+
+// ScheduleNow subscribes org to the features in the one phase in phases,
+// or cancels its subscription immediately if phases is empty, and updates
+// the info of org if not nil. Orgs without a subscription are sent to
+// checkout; see Provider.
+func (p *Provider) ScheduleNow(ctx context.Context, org string, info *control.OrgInfo, phases []control.Phase) error {
+	if len(phases) > 1 || (len(phases) == 1 && (phases[0].Effective.After(time.Now()) || phases[0].Interval != "")) {
+		return &control.ValidationError{Message: "paddle: only one phase, effective now, at the interval of its features, may be scheduled"}
+	}
+	defer p.orgs.lock(org)()
+	cid, err := p.putCustomer(ctx, org, info)
+	if err != nil {
+		return err
+	}
+	sub, err := p.lookupSubscription(ctx, cid)
+	if err != nil {
+		return err
+	}
+
+	if len(phases) == 0 {
+		if sub == nil {
+			return nil
+		}
+		return p.Paddle.Do(ctx, "POST", "/subscriptions/"+sub.ID+"/cancel", map[string]string{
+			"effective_from": "immediately",
+		}, nil)
+	}
+
+	fs, err := p.features(ctx, phases[0].Features)
+	if err != nil {
+		return err
+	}
+	var items []item
+	for _, f := range fs {
+		if !f.IsMetered() {
+			items = append(items, item{PriceID: f.ProviderID, Quantity: 1})
+		}
+	}
+	if len(items) == 0 {
+		return &control.ValidationError{Message: "paddle: a phase must include a feature with a flat price"}
+	}
+	data := subscriptionData{Org: org, Features: phases[0].Features}
+
+	if sub == nil {
+		var tx struct {
+			ID       string
+			Checkout struct{ URL string }
+		}
+		err := p.Paddle.Do(ctx, "POST", "/transactions", map[string]any{
+			"customer_id":     cid,
+			"items":           items,
+			"collection_mode": "automatic",
+			"custom_data":     data,
+		}, &tx)
+		if err != nil {
+			return err
+		}
+		return &control.CheckoutRequiredError{Org: org, URL: tx.Checkout.URL}
+	}
+
+	data.Usage = sub.CustomData.Usage
+	return p.Paddle.Do(ctx, "PATCH", "/subscriptions/"+sub.ID, map[string]any{
+		"items":                  items,
+		"proration_billing_mode": "prorated_immediately",
+		"custom_data":            data,
+	}, nil)
+}
+
+// features returns the features pushed for fps, in order.
+func (p *Provider) features(ctx context.Context, fps []refs.FeaturePlan) ([]control.Feature, error) {
+	pulled, err := p.PullWithOptions(ctx, control.PullOptions{Archived: true})
+	if err != nil {
+		return nil, err
+	}
+	fs := make([]control.Feature, len(fps))
+	for i, fp := range fps {
+		j := slices.IndexFunc(pulled, func(f control.Feature) bool { return f.FeaturePlan == fp })
+		if j < 0 {
+			return nil, &control.OrgError{Phase: -1, Feature: fp.String(), Err: control.ErrFeatureNotFound}
+		}
+		fs[i] = pulled[j]
+	}
+	return fs, nil
+}
+
+// subscribed returns the subscription of org and the features in it, or
+// a nil subscription if org has none.
+func (p *Provider) subscribed(ctx context.Context, org string) (*subscription, []control.Feature, error) {
+	cid, err := p.WhoIs(ctx, org)
+	if err != nil {
+		return nil, nil, err
+	}
+	sub, err := p.lookupSubscription(ctx, cid)
+	if err != nil || sub == nil {
+		return nil, nil, err
+	}
+	fs, err := p.features(ctx, sub.CustomData.Features)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sub, fs, nil
+}
+
+// LookupPhases returns the current phase of org, if it is subscribed.
+func (p *Provider) LookupPhases(ctx context.Context, org string) ([]control.Phase, error) {
+	sub, _, err := p.subscribed(ctx, org)
+	if errors.Is(err, control.ErrOrgNotFound) {
+		return nil, nil
+	}
+	if err != nil || sub == nil {
+		return nil, err
+	}
+	return []control.Phase{{
+		Org:       org,
+		Effective: sub.StartedAt,
+		Features:  sub.CustomData.Features,
+		Current:   true,
+	}}, nil
+}
+
+// used returns the usage of f counted in sub for its current period.
+func used(sub *subscription, f control.Feature) usage {
+	u := sub.CustomData.Usage[f.ID()]
+	if f.Aggregate != "perpetual" && !u.Period.Equal(sub.Period.Start) {
+		u = usage{Period: sub.Period.Start}
+	}
+	return u
+}
+
+// ReportUsage counts the use of the metered feature by org, and charges
+// for it on the next invoice. Reports clobbering usage may only increase
+// it, and no more than maxQuantity may be charged at once. The time of use
+// is not reported to Paddle, and use is counted in the current billing
+// period.
+func (p *Provider) ReportUsage(ctx context.Context, org string, feature refs.Name, use control.Report) error {
+	defer p.orgs.lock(org)()
+
+	sub, fs, err := p.subscribed(ctx, org)
+	if err != nil {
+		return err
+	}
+	if sub == nil {
+		return &control.OrgError{Org: org, Phase: -1, Feature: feature.String(), Err: control.ErrFeatureNotFound}
+	}
+	feature = control.Aliases(fs).Resolve(feature)
+	i := slices.IndexFunc(fs, func(f control.Feature) bool { return f.Name() == feature })
+	if i < 0 {
+		return &control.OrgError{Org: org, Phase: -1, Feature: feature.String(), Err: control.ErrFeatureNotFound}
+	}
+	f := fs[i]
+	if !f.IsMetered() {
+		return control.ErrFeatureNotMetered
+	}
+
+	u := used(sub, f)
+	n := use.N
+	if use.Clobber {
+		n -= u.Used
+	}
+	if n < 0 {
+		return &control.ValidationError{Message: "paddle: usage may not be decreased"}
+	}
+	if n > maxQuantity {
+		return &control.ValidationError{Message: fmt.Sprintf("paddle: usage reported at once may not exceed %d", maxQuantity)}
+	}
+
+	// The usage is counted before it is charged, so that a failure to
+	// count it cannot leave a charge that a retry makes again. If the
+	// charge fails, the count is undone, so that a retry charges it.
+	u.Used += n
+	if err := p.putUsage(ctx, sub, f, u); err != nil {
+		return err
+	}
+	if n > 0 && f.Tiers[0].Price.Sign() > 0 {
+		err := p.Paddle.Do(ctx, "POST", "/subscriptions/"+sub.ID+"/charge", map[string]any{
+			"effective_from": "next_billing_period",
+			"items":          []item{{PriceID: f.ProviderID, Quantity: n}},
+		}, nil)
+		if err != nil {
+			u.Used -= n
+			if err := p.putUsage(ctx, sub, f, u); err != nil {
+				p.logf("paddle: undoing usage of %s by %s after failed charge: %v", f.FeaturePlan, org, err)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// putUsage records u as the usage of f in the custom data of sub.
+func (p *Provider) putUsage(ctx context.Context, sub *subscription, f control.Feature, u usage) error {
+	data := sub.CustomData
+	if data.Usage == nil {
+		data.Usage = map[string]usage{}
+	}
+	data.Usage[f.ID()] = u
+	return p.Paddle.Do(ctx, "PATCH", "/subscriptions/"+sub.ID, map[string]any{
+		"custom_data": data,
+	}, nil)
+}
+
+// LookupLimits returns the limits and usage of the features org is
+// subscribed to, with usage of features billed at a flat price as 1.
+func (p *Provider) LookupLimits(ctx context.Context, org string) ([]control.Usage, error) {
+	sub, fs, err := p.subscribed(ctx, org)
+	if err != nil || sub == nil {
+		return nil, err
+	}
+	var us []control.Usage
+	for _, f := range fs {
+		u := control.Usage{
+			Feature: f.FeaturePlan,
+			Start:   sub.Period.Start,
+			End:     sub.Period.End,
+			Used:    1,
+			Limit:   f.Limit(),
+		}
+		if f.IsMetered() {
+			u.Used = used(sub, f).Used
+		}
+		us = append(us, u)
+		// Report usage under each alias too, as control.Client does.
+		for _, a := range f.Aliases {
+			u.Feature = a.WithPlan(f.Plan())
+			us = append(us, u)
+		}
+	}
+	return us, nil
+}
+
+// A keyedMutex is a mutex for each of a set of keys, held only while
+// locked.
+type keyedMutex struct {
+	m     sync.Mutex
+	locks map[string]*keyedLock
+}
+
+type keyedLock struct {
+	sync.Mutex
+	n int // the holders and waiters
+}
+
+// lock locks the mutex of key, and returns the func unlocking it.
+func (km *keyedMutex) lock(key string) (unlock func()) {
+	km.m.Lock()
+	l := km.locks[key]
+	if l == nil {
+		if km.locks == nil {
+			km.locks = map[string]*keyedLock{}
+		}
+		l = &keyedLock{}
+		km.locks[key] = l
+	}
+	l.n++
+	km.m.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		km.m.Lock()
+		if l.n--; l.n == 0 {
+			delete(km.locks, key)
+		}
+		km.m.Unlock()
+	}
+}
@@ -0,0 +1,375 @@
+package paddle
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/exp/slices"
+	"golang.org/x/sync/errgroup"
+	"kr.dev/diff"
+	"tier.run/control"
+	"tier.run/refs"
+	"tier.run/values"
+)
+
+// fake is an in-memory Paddle API, serving the endpoints used by Provider.
+// Checkouts are completed as soon as transactions are created.
+type fake struct {
+	t   *testing.T
+	url string
+
+	mu          sync.Mutex
+	objects     map[string][]map[string]any // by collection
+	charges     []item
+	failCharges bool // whether charges fail
+	failPatches bool // whether updates fail
+}
+
+func newFake(t *testing.T) *fake {
+	f := &fake{t: t, objects: map[string][]map[string]any{}}
+	s := httptest.NewServer(f)
+	t.Cleanup(s.Close)
+	f.url = s.URL
+	return f
+}
+
+func (f *fake) find(coll, id string) map[string]any {
+	for _, o := range f.objects[coll] {
+		if o["id"] == id {
+			return o
+		}
+	}
+	return nil
+}
+
+func (f *fake) create(coll, prefix string, o map[string]any) map[string]any {
+	o["id"] = fmt.Sprintf("%s_%02d", prefix, len(f.objects[coll])+1)
+	f.objects[coll] = append(f.objects[coll], o)
+	return o
+}
+
+func (f *fake) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if r.Header.Get("Authorization") != "Bearer test_key" {
+		f.error(w, 403, "forbidden")
+		return
+	}
+	var body map[string]any
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&body)
+	}
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	coll := parts[0]
+	var o map[string]any
+	if len(parts) > 1 {
+		if o = f.find(coll, parts[1]); o == nil {
+			f.error(w, 404, "not_found")
+			return
+		}
+	}
+
+	switch {
+	case r.Method == "GET" && o == nil:
+		f.list(w, r, coll)
+		return
+	case r.Method == "GET":
+	case r.Method == "PATCH":
+		if f.failPatches {
+			f.error(w, 500, "internal_error")
+			return
+		}
+		for k, v := range body {
+			o[k] = v
+		}
+	case r.Method == "POST" && len(parts) == 3 && parts[2] == "charge":
+		if f.failCharges {
+			f.error(w, 500, "internal_error")
+			return
+		}
+		data, _ := json.Marshal(body["items"])
+		var items []item
+		json.Unmarshal(data, &items)
+		f.charges = append(f.charges, items...)
+	case r.Method == "POST" && len(parts) == 3 && parts[2] == "cancel":
+		o["status"] = "canceled"
+	case r.Method == "POST" && coll == "transactions":
+		start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		f.create("subscriptions", "sub", map[string]any{
+			"status":      "active",
+			"customer_id": body["customer_id"],
+			"items":       body["items"],
+			"custom_data": body["custom_data"],
+			"started_at":  start,
+			"current_billing_period": map[string]any{
+				"starts_at": start,
+				"ends_at":   start.AddDate(0, 1, 0),
+			},
+		})
+		o = f.create(coll, "txn", body)
+		o["checkout"] = map[string]any{"url": "https://pay.example.com/" + o["id"].(string)}
+	case r.Method == "POST":
+		body["status"] = "active"
+		o = f.create(coll, coll[:3], body)
+	default:
+		f.error(w, 405, "method_not_allowed")
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]any{"data": o})
+}
+
+// list lists coll two items at a time, to exercise pagination.
+func (f *fake) list(w http.ResponseWriter, r *http.Request, coll string) {
+	q := r.URL.Query()
+	var matched []map[string]any
+	for _, o := range f.objects[coll] {
+		if s := q.Get("status"); s != "" && !slices.Contains(strings.Split(s, ","), o["status"].(string)) {
+			continue
+		}
+		if c := q.Get("customer_id"); c != "" && o["customer_id"] != c {
+			continue
+		}
+		matched = append(matched, o)
+	}
+	after, _ := strconv.Atoi(q.Get("after"))
+	page := matched[after:]
+	hasMore := len(page) > 2
+	if hasMore {
+		page = page[:2]
+	}
+	q.Set("after", strconv.Itoa(after+len(page)))
+	json.NewEncoder(w).Encode(map[string]any{
+		"data": append([]map[string]any{}, page...),
+		"meta": map[string]any{"pagination": map[string]any{
+			"next":     f.url + r.URL.Path + "?" + q.Encode(),
+			"has_more": hasMore,
+		}},
+	})
+}
+
+func (f *fake) error(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{"type": "request_error", "code": code},
+		"meta":  map[string]any{"request_id": "req_1"},
+	})
+}
+
+func TestProvider(t *testing.T) {
+	ctx := context.Background()
+	fk := newFake(t)
+	p := &Provider{
+		Paddle: &Client{APIKey: "test_key", BaseURL: fk.url},
+		Logf:   t.Logf,
+	}
+
+	fs := []control.Feature{
+		{
+			FeaturePlan: refs.MustParseFeaturePlan("feature:base@plan:pro@0"),
+			Interval:    "@monthly",
+			Currency:    "usd",
+			Base:        1000,
+		},
+		{
+			FeaturePlan: refs.MustParseFeaturePlan("feature:calls@plan:pro@0"),
+			Interval:    "@monthly",
+			Currency:    "usd",
+			Mode:        "graduated",
+			Tiers:       []control.Tier{{Upto: control.Inf, Price: values.MustParseDecimal("2")}},
+		},
+		{
+			FeaturePlan: refs.MustParseFeaturePlan("feature:seats@plan:pro@0"),
+			Interval:    "@monthly",
+			Currency:    "usd",
+			Mode:        "graduated",
+			Tiers:       []control.Tier{{Upto: 3}},
+		},
+	}
+	push := func(fs []control.Feature) []error {
+		var errs []error
+		p.Push(ctx, fs, func(_ control.Feature, err error) {
+			errs = append(errs, err)
+		})
+		return errs
+	}
+	diff.Test(t, t.Errorf, push(fs), []error{nil, nil, nil})
+	for _, err := range push(fs) {
+		if !errors.Is(err, control.ErrPlanExists) {
+			t.Errorf("pushing again: err = %v; want ErrPlanExists", err)
+		}
+	}
+	bad := fs[1]
+	bad.FeaturePlan = refs.MustParseFeaturePlan("feature:calls@plan:pro@1")
+	bad.Tiers = []control.Tier{{Upto: 10}, {Upto: control.Inf, Price: values.MustParseDecimal("1")}}
+	if errs := push([]control.Feature{bad}); len(errs) != 1 || !errors.Is(errs[0], control.ErrInvalidPrice) {
+		t.Errorf("pushing two tiers: errs = %v; want ErrInvalidPrice", errs)
+	}
+
+	pulled, err := p.PullWithOptions(ctx, control.PullOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pulled) != 3 || pulled[1].Limit() != control.Inf || pulled[1].ProviderID == "" {
+		t.Errorf("pulled = %+v; want 3 features, calls unlimited", pulled)
+	}
+	cs, err := p.Diff(ctx, fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, cs, []control.Change(nil))
+
+	org := "org:example"
+	if err := p.ScheduleNow(ctx, org, nil, nil); !errors.Is(err, control.ErrInvalidEmail) {
+		t.Errorf("ScheduleNow without email: err = %v; want ErrInvalidEmail", err)
+	}
+	err = p.ScheduleNow(ctx, org, &control.OrgInfo{Email: "a@example.com"}, []control.Phase{{
+		Features: control.FeaturePlans(fs),
+	}})
+	diff.Test(t, t.Errorf, err, error(&control.CheckoutRequiredError{
+		Org: org,
+		URL: "https://pay.example.com/txn_01",
+	}))
+	info, err := p.LookupOrg(ctx, org)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, info.Email, "a@example.com")
+
+	ps, err := p.LookupPhases(ctx, org)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 1 || !ps[0].Current || len(ps[0].Features) != 3 {
+		t.Errorf("phases = %+v; want one current phase with 3 features", ps)
+	}
+
+	report := func(feature string, n int, clobber bool) error {
+		return p.ReportUsage(ctx, org, refs.MustParseName(feature), control.Report{N: n, Clobber: clobber})
+	}
+	if err := report("feature:calls", 5, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := report("feature:calls", 7, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := report("feature:seats", 2, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := report("feature:calls", 1, true); err == nil {
+		t.Error("decreasing usage: err = nil; want error")
+	}
+	if err := report("feature:base", 1, false); !errors.Is(err, control.ErrFeatureNotMetered) {
+		t.Errorf("reporting base: err = %v; want ErrFeatureNotMetered", err)
+	}
+	if err := report("feature:calls", maxQuantity+1, false); err == nil {
+		t.Error("reporting more than maxQuantity: err = nil; want error")
+	}
+	// Reports of the same org are counted one after another.
+	var g errgroup.Group
+	for i := 0; i < 2; i++ {
+		g.Go(func() error { return report("feature:calls", 1, false) })
+	}
+	if err := g.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	// Usage that fails to be counted or charged is neither, so that it
+	// is charged once when reported again.
+	failing := func(charges, patches bool) {
+		fk.mu.Lock()
+		defer fk.mu.Unlock()
+		fk.failCharges, fk.failPatches = charges, patches
+	}
+	failing(true, false)
+	if err := report("feature:calls", 1, false); err == nil {
+		t.Error("failed charge: err = nil; want error")
+	}
+	failing(false, true)
+	if err := report("feature:calls", 1, false); err == nil {
+		t.Error("failed count: err = nil; want error")
+	}
+	failing(false, false)
+	diff.Test(t, t.Errorf, fk.charges, []item{
+		{PriceID: pulled[1].ProviderID, Quantity: 5},
+		{PriceID: pulled[1].ProviderID, Quantity: 2},
+		{PriceID: pulled[1].ProviderID, Quantity: 1},
+		{PriceID: pulled[1].ProviderID, Quantity: 1},
+	})
+
+	us, err := p.LookupLimits(ctx, org)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type limit struct {
+		Feature     string
+		Used, Limit int
+	}
+	var got []limit
+	for _, u := range us {
+		got = append(got, limit{u.Feature.String(), u.Used, u.Limit})
+	}
+	diff.Test(t, t.Errorf, got, []limit{
+		{"feature:base@plan:pro@0", 1, control.Inf},
+		{"feature:calls@plan:pro@0", 9, control.Inf},
+		{"feature:seats@plan:pro@0", 2, 3},
+	})
+
+	if err := p.ScheduleNow(ctx, org, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	ps, err = p.LookupPhases(ctx, org)
+	if err != nil || ps != nil {
+		t.Errorf("phases after cancel = %v, %v; want nil, nil", ps, err)
+	}
+
+	if _, err := p.WhoIs(ctx, "org:unknown"); !errors.Is(err, control.ErrOrgNotFound) {
+		t.Errorf("WhoIs unknown: err = %v; want ErrOrgNotFound", err)
+	}
+	err = p.Paddle.Do(ctx, "GET", "/prices/pri_missing", nil, nil)
+	var e *Error
+	if !errors.As(err, &e) || !errors.Is(err, ErrNotFound) || e.RequestID != "req_1" {
+		t.Errorf("err = %#v; want not found *Error with request ID", err)
+	}
+}
+
+func TestPushAddOns(t *testing.T) {
+	ctx := context.Background()
+	fk := newFake(t)
+	p := &Provider{
+		Paddle: &Client{APIKey: "test_key", BaseURL: fk.url},
+		Logf:   t.Logf,
+	}
+
+	fs := []control.Feature{{
+		FeaturePlan: refs.MustParseFeaturePlan("feature:support@0"),
+		Interval:    "@monthly",
+		Currency:    "usd",
+		Base:        500,
+	}}
+	var errs []error
+	push := func() {
+		t.Helper()
+		errs = nil
+		p.Push(ctx, fs, func(_ control.Feature, err error) {
+			errs = append(errs, err)
+		})
+	}
+	push()
+	diff.Test(t, t.Errorf, errs, []error{nil})
+	push()
+	if len(errs) != 1 || !errors.Is(errs[0], control.ErrFeatureExists) {
+		t.Errorf("pushing again: errs = %v; want ErrFeatureExists", errs)
+	}
+	if n := len(fk.objects["prices"]); n != 1 {
+		t.Errorf("pushed %d prices; want 1", n)
+	}
+}
@@ -20,6 +20,11 @@ type Profile struct {
 	TestAPIKey         string `json:"testmode_key_secret"`
 	TestPublishableKey string `json:"testmode_key_publishable"`
 
+	// SidecarURL, if set, is the base URL of a running sidecar, such as
+	// one serving staging, that commands using the sidecar API are sent
+	// to instead of to a sidecar run in process with the profile's keys.
+	SidecarURL string `json:"sidecar_url,omitempty"`
+
 	Source string `json:"-"`
 }
 
@@ -27,6 +32,60 @@ type Profiles map[string]*Profile
 
 type Config struct {
 	Profiles Profiles `json:"profiles"`
+
+	// Current is the name of the profile selected with SetCurrent, if
+	// any.
+	Current string `json:"current,omitempty"`
+}
+
+// DefaultName is the name of the profile used if none is selected. It is
+// the profile saved by "tier connect" before profiles could be named.
+const DefaultName = "tier"
+
+// CurrentName returns the name of the profile in use: the one named by
+// TIER_PROFILE, if set, or else the one selected with SetCurrent, or else
+// DefaultName.
+func CurrentName() string {
+	if name := os.Getenv("TIER_PROFILE"); name != "" {
+		return name
+	}
+	c, err := LoadConfig()
+	if err != nil || c.Current == "" {
+		return DefaultName
+	}
+	return c.Current
+}
+
+// SetCurrent selects the profile name for use by later commands. It
+// returns ErrProfileNotFound if there is no profile name.
+func SetCurrent(name string) error {
+	c, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	if c.Profiles[name] == nil {
+		return ErrProfileNotFound
+	}
+	c.Current = name
+	return saveConfig(c)
+}
+
+// Delete deletes the profile name. If it is selected, DefaultName is
+// selected instead. It returns ErrProfileNotFound if there is no profile
+// name.
+func Delete(name string) error {
+	c, err := LoadConfig()
+	if err != nil {
+		return err
+	}
+	if c.Profiles[name] == nil {
+		return ErrProfileNotFound
+	}
+	delete(c.Profiles, name)
+	if c.Current == name {
+		c.Current = ""
+	}
+	return saveConfig(c)
 }
 
 func Load(name string) (*Profile, error) {
@@ -54,6 +113,9 @@ func LoadConfig() (*Config, error) {
 		}
 		return nil, err
 	}
+	if c == nil {
+		c = &Config{}
+	}
 	host, err := os.Hostname()
 	if err != nil {
 		return nil, err
@@ -75,25 +137,37 @@ func Save(name string, p *Profile) error {
 		c.Profiles = make(Profiles)
 	}
 	c.Profiles[name] = p
+	return saveConfig(c)
+}
 
+func saveConfig(c *Config) error {
 	f, err := open()
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
+	// Truncate, so no trailing bytes of a longer config remain.
+	if err := f.Truncate(0); err != nil {
+		return err
+	}
 	e := json.NewEncoder(f)
 	e.SetIndent("", "    ")
 	return e.Encode(c)
 }
 
-// ConfigPath returns the path to the config file.
+// ConfigPath returns the path to the config file, in the tier directory
+// of XDG_CONFIG_HOME, or of ~/.config if XDG_CONFIG_HOME is not set.
 func ConfigPath() string {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		panic(err)
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			panic(err)
+		}
+		dir = filepath.Join(home, ".config")
 	}
-	return filepath.Join(home, ".config", "tier", "config.json")
+	return filepath.Join(dir, "tier", "config.json")
 }
 
 func open() (*os.File, error) {
@@ -0,0 +1,61 @@
+package profile
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestProfiles(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", dir)
+	t.Setenv("TIER_PROFILE", "")
+
+	if got, want := ConfigPath(), filepath.Join(dir, "tier", "config.json"); got != want {
+		t.Errorf("ConfigPath() = %q; want %q", got, want)
+	}
+	if got := CurrentName(); got != DefaultName {
+		t.Errorf("CurrentName() = %q; want %q", got, DefaultName)
+	}
+	if err := SetCurrent("staging"); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("selecting missing profile: err = %v; want %v", err, ErrProfileNotFound)
+	}
+
+	long := &Profile{TestAPIKey: "sk_test_a_long_key_to_leave_trailing_bytes", SidecarURL: "https://staging.example.com"}
+	if err := Save("staging", long); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetCurrent("staging"); err != nil {
+		t.Fatal(err)
+	}
+	if got := CurrentName(); got != "staging" {
+		t.Errorf("CurrentName() = %q; want staging", got)
+	}
+	t.Setenv("TIER_PROFILE", "production")
+	if got := CurrentName(); got != "production" {
+		t.Errorf("CurrentName() with TIER_PROFILE = %q; want production", got)
+	}
+	t.Setenv("TIER_PROFILE", "")
+
+	// A shorter config must replace a longer one entirely.
+	if err := Save("staging", &Profile{TestAPIKey: "sk_test_b"}); err != nil {
+		t.Fatal(err)
+	}
+	p, err := Load("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.TestAPIKey != "sk_test_b" || p.SidecarURL != "" {
+		t.Errorf("profile = %+v; want only the new test key", p)
+	}
+
+	if err := Delete("staging"); err != nil {
+		t.Fatal(err)
+	}
+	if got := CurrentName(); got != DefaultName {
+		t.Errorf("CurrentName() after deleting current = %q; want %q", got, DefaultName)
+	}
+	if _, err := Load("staging"); !errors.Is(err, ErrProfileNotFound) {
+		t.Errorf("loading deleted profile: err = %v; want %v", err, ErrProfileNotFound)
+	}
+}
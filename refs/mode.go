@@ -0,0 +1,98 @@
+package refs
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// ParseMode controls how strictly refs are parsed.
+type ParseMode int
+
+const (
+	// Strict parses refs exactly as ParseName, ParsePlan, and
+	// ParseFeaturePlan do.
+	Strict ParseMode = iota
+
+	// Lenient normalizes refs before parsing them: surrounding space is
+	// trimmed, letters are lowercased, "." and "/" separators outside of
+	// versions become ":", "-", "_", and spaces are removed, and missing
+	// "feature:" or "plan:" prefixes are added. Normalization is
+	// deterministic, so equal inputs always parse to equal refs.
+	Lenient
+)
+
+func (m ParseMode) String() string {
+	switch m {
+	case Strict:
+		return "strict"
+	case Lenient:
+		return "lenient"
+	default:
+		return fmt.Sprintf("ParseMode(%d)", int(m))
+	}
+}
+
+// ParseName parses s as a feature name per m. It also returns a description
+// of each change made to s before parsing, if any.
+func (m ParseMode) ParseName(s string) (Name, []string, error) {
+	s, changes := m.normalize(s, "feature:")
+	n, err := ParseName(s)
+	return n, changes, err
+}
+
+// ParsePlan parses s as a plan per m. It also returns a description of each
+// change made to s before parsing, if any.
+func (m ParseMode) ParsePlan(s string) (Plan, []string, error) {
+	s, changes := m.normalize(s, "plan:")
+	p, err := ParsePlan(s)
+	return p, changes, err
+}
+
+// ParseFeaturePlan parses s as a feature plan per m. It also returns a
+// description of each change made to s before parsing, if any.
+func (m ParseMode) ParseFeaturePlan(s string) (FeaturePlan, []string, error) {
+	s, changes := m.normalize(s, "feature:")
+	fp, err := ParseFeaturePlan(s)
+	return fp, changes, err
+}
+
+// normalize returns s normalized per m, adding prefix if s has no prefix,
+// along with a description of each change made.
+func (m ParseMode) normalize(s, prefix string) (string, []string) {
+	if m != Lenient {
+		return s, nil
+	}
+
+	var changes []string
+	change := func(to, format string, args ...any) {
+		if to != s {
+			changes = append(changes, fmt.Sprintf(format, args...))
+			s = to
+		}
+	}
+
+	change(strings.TrimSpace(s), "trimmed surrounding space")
+	change(strings.ToLower(s), "lowercased")
+	// Dots after the last "@" separate version parts, so are kept.
+	head, version := s, ""
+	if i := strings.LastIndexByte(s, '@'); i >= 0 {
+		head, version = s[:i], s[i:]
+	}
+	change(strings.Map(func(r rune) rune {
+		if r == '.' || r == '/' {
+			return ':'
+		}
+		return r
+	}, head)+version, `replaced "." and "/" with ":"`)
+	change(strings.Map(func(r rune) rune {
+		if r == '-' || r == '_' || unicode.IsSpace(r) {
+			return -1
+		}
+		return r
+	}, s), `removed "-", "_", and spaces`)
+	if !strings.HasPrefix(s, "feature:") && !strings.HasPrefix(s, "plan:") {
+		change(prefix+s, "added %q prefix", prefix)
+	}
+	return s, changes
+}
@@ -1,9 +1,12 @@
 package refs
 
 import (
+	"database/sql"
+	"database/sql/driver"
 	"encoding"
 	"encoding/json"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"golang.org/x/exp/slices"
@@ -17,14 +20,36 @@ var (
 	_ encoding.TextUnmarshaler = (*Plan)(nil)
 	_ encoding.TextUnmarshaler = (*Name)(nil)
 	_ encoding.TextUnmarshaler = (*FeaturePlan)(nil)
+	_ encoding.TextMarshaler   = (*Pattern)(nil)
+	_ encoding.TextUnmarshaler = (*Pattern)(nil)
 )
 
+// sql
+var (
+	_ sql.Scanner   = (*Plan)(nil)
+	_ sql.Scanner   = (*Name)(nil)
+	_ sql.Scanner   = (*FeaturePlan)(nil)
+	_ sql.Scanner   = (*Pattern)(nil)
+	_ driver.Valuer = Plan{}
+	_ driver.Valuer = Name{}
+	_ driver.Valuer = FeaturePlan{}
+	_ driver.Valuer = Pattern{}
+)
+
+// ParseError describes a ref that could not be parsed.
 type ParseError struct {
-	ID      string
+	ID      string // the input being parsed
 	Message string
+
+	Offset     int    // byte offset in ID at which parsing failed
+	Expected   string // what was expected at Offset, if known
+	Suggestion string // a valid ref the input may have meant, if any
 }
 
 func (e *ParseError) Error() string {
+	if e.Suggestion != "" {
+		return fmt.Sprintf("%s: %s (did you mean %q?)", e.Message, e.ID, e.Suggestion)
+	}
 	return fmt.Sprintf("%s: %s", e.Message, e.ID)
 }
 
@@ -46,41 +71,132 @@ func (p Plan) GoString() string { return fmt.Sprintf("<%s>", p) }
 
 func (p Plan) IsZero() bool { return p == Plan{} }
 
+// LatestVersion is the plan version that refers to the latest version of a
+// plan, such as "plan:pro@latest". It must be resolved to a pushed version,
+// as chosen by CompareVersions, before use.
+const LatestVersion = "latest"
+
+// Name returns the name of the plan without its version.
+func (p Plan) Name() string { return p.name }
+
+// Version returns the version of the plan.
+func (p Plan) Version() string { return p.version }
+
+// IsLatest reports whether p refers to the latest version of a plan.
+func (p Plan) IsLatest() bool { return p.version == LatestVersion }
+
+// WithVersion returns p with its version replaced by version. It panics if
+// version is not a valid plan version.
+func (p Plan) WithVersion(version string) Plan {
+	if isIllegalVersion(version) {
+		panic(invalid("plan version must match "+versionPattern, version, illegalVersionAt(version), versionPattern))
+	}
+	return Plan{name: p.name, version: version}
+}
+
+// CompareVersions returns -1, 0, or +1 depending on whether plan version a
+// is lower than, equal to, or higher than version b.
+//
+// Versions are compared part by part, where parts are separated by ".", so
+// that "1.2.0" is lower than "1.10.0". Parts that are both integers are
+// compared numerically, so "10" is higher than "9", and all other parts are
+// compared lexically, with integer parts lower than non-integer parts. If
+// all parts of the shorter version are equal to those of the longer, the
+// shorter version is lower, so "1.2" is lower than "1.2.0".
+func CompareVersions(a, b string) int {
+	for {
+		ap, arest, aMore := strings.Cut(a, ".")
+		bp, brest, bMore := strings.Cut(b, ".")
+		if c := compareVersionParts(ap, bp); c != 0 {
+			return c
+		}
+		switch {
+		case !aMore && !bMore:
+			return 0
+		case !aMore:
+			return -1
+		case !bMore:
+			return +1
+		}
+		a, b = arest, brest
+	}
+}
+
+func compareVersionParts(a, b string) int {
+	ai, aErr := strconv.ParseUint(a, 10, 64)
+	bi, bErr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aErr == nil && bErr == nil:
+		return compare(ai, bi)
+	case aErr == nil:
+		return -1
+	case bErr == nil:
+		return +1
+	}
+	return compare(a, b)
+}
+
+func compare[T uint64 | string](a, b T) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return +1
+	}
+	return 0
+}
+
 func (p *Plan) UnmarshalJSON(b []byte) error {
 	return unmarshal(p, ParsePlan, b)
 }
 
 func (p *Plan) UnmarshalText(b []byte) error {
-	np, err := ParsePlan(string(b))
-	if err != nil {
-		return err
-	}
-	*p = np
-	return nil
+	return unmarshalText(p, ParsePlan, b)
 }
 
 func (p Plan) MarshalJSON() ([]byte, error) {
-	return json.Marshal(p.String())
+	return json.Marshal(string(marshalText(p)))
 }
 
 func (p Plan) MarshalText() ([]byte, error) {
-	return []byte(p.String()), nil
+	return marshalText(p), nil
+}
+
+// Scan implements sql.Scanner. NULL scans as the zero Plan.
+func (p *Plan) Scan(src any) error {
+	return scan(p, ParsePlan, src)
 }
 
+// Value implements driver.Valuer. The zero Plan is stored as NULL.
+func (p Plan) Value() (driver.Value, error) {
+	return value(p), nil
+}
+
+// ParsePlan parses s as a plan, such as "plan:pro@1". If s is invalid, the
+// error is a *ParseError.
 func ParsePlan(s string) (Plan, error) {
+	p, err := parsePlan(s)
+	if err != nil {
+		return Plan{}, suggest(err, s, "plan:", parsePlan)
+	}
+	return p, nil
+}
+
+func parsePlan(s string) (Plan, error) {
 	prefix, rest, hasPrefix := strings.Cut(s, ":")
 	if !hasPrefix || prefix != "plan" {
-		return Plan{}, invalid("plan name must start with 'plan:'", s)
+		return Plan{}, invalid("plan name must start with 'plan:'", s, 0, "'plan:'")
 	}
+	const start = len("plan:")
 	name, version, _ := strings.Cut(rest, "@")
 	if version == "" {
-		return Plan{}, invalid("plan must have version", s)
+		return Plan{}, invalid("plan must have version", s, len(s), "'@' followed by a version")
 	}
-	if isIllegalName(name) {
-		return Plan{}, invalid("plan name must match [a-zA-Z0-9:]+", s)
+	if i := illegalNameAt(name); i >= 0 {
+		return Plan{}, invalid("plan name must match "+namePattern, s, start+i, namePattern)
 	}
-	if isIllegalVersion(version) {
-		return Plan{}, invalid("plan version must match [a-zA-Z0-9]+", s)
+	if i := illegalVersionAt(version); i >= 0 {
+		return Plan{}, invalid("plan version must match "+versionPattern, s, start+len(name)+1+i, versionPattern)
 	}
 	return Plan{name: name, version: version}, nil
 }
@@ -114,38 +230,106 @@ func (n Name) GoString() string            { return fmt.Sprintf("<%s>", n) }
 func (n Name) WithPlan(p Plan) FeaturePlan { return FeaturePlan{name: n.name, plan: p} }
 func (n Name) Less(o Name) bool            { return n.name < o.name }
 
+// Parent returns the name of the feature n is a child of, and true, or the
+// zero Name and false if n has no parent. Feature names are hierarchical,
+// with levels separated by colons: "feature:api:reads" is a child of
+// "feature:api".
+func (n Name) Parent() (Name, bool) {
+	i := strings.LastIndexByte(n.name, ':')
+	if i <= 0 {
+		return Name{}, false
+	}
+	return Name{name: n.name[:i]}, true
+}
+
+// Ancestors returns the names of the ancestors of n, starting with its
+// parent and ending with the root of its hierarchy.
+func (n Name) Ancestors() []Name {
+	var ns []Name
+	for p, ok := n.Parent(); ok; p, ok = p.Parent() {
+		ns = append(ns, p)
+	}
+	return ns
+}
+
+// IsDescendantOf reports whether n is a child of a, or of any of a's
+// descendants.
+func (n Name) IsDescendantOf(a Name) bool {
+	return strings.HasPrefix(n.name, a.name+":")
+}
+
 func (fp *Name) UnmarshalJSON(b []byte) error {
 	return unmarshal(fp, ParseName, b)
 }
 
 func (fp *Name) UnmarshalText(b []byte) error {
-	n, err := ParseName(string(b))
-	if err != nil {
-		return err
-	}
-	*fp = n
-	return nil
+	return unmarshalText(fp, ParseName, b)
 }
 
 func (fp Name) MarshalJSON() ([]byte, error) {
-	return json.Marshal(fp.String())
+	return json.Marshal(string(marshalText(fp)))
 }
 
 func (fp Name) MarshalText() ([]byte, error) {
-	return []byte(fp.String()), nil
+	return marshalText(fp), nil
+}
+
+// Scan implements sql.Scanner. NULL scans as the zero Name.
+func (fp *Name) Scan(src any) error {
+	return scan(fp, ParseName, src)
 }
 
+// Value implements driver.Valuer. The zero Name is stored as NULL.
+func (fp Name) Value() (driver.Value, error) {
+	return value(fp), nil
+}
+
+// ParseName parses s as a feature name, such as "feature:seats". If s is
+// invalid, the error is a *ParseError.
 func ParseName(s string) (Name, error) {
+	n, err := parseName(s)
+	if err != nil {
+		return Name{}, suggest(err, s, "feature:", parseName)
+	}
+	return n, nil
+}
+
+func parseName(s string) (Name, error) {
 	prefix, name, hasPrefix := strings.Cut(s, ":")
 	if !hasPrefix || prefix != "feature" {
-		return Name{}, invalid("feature name must start with 'feature:'", s)
+		return Name{}, invalid("feature name must start with 'feature:'", s, 0, "'feature:'")
 	}
-	if isIllegalName(name) {
-		return Name{}, invalid("feature name must match [a-zA-Z0-9:]+", s)
+	if i := illegalNameAt(name); i >= 0 {
+		return Name{}, invalid("feature name must match "+namePattern, s, len("feature:")+i, namePattern)
 	}
 	return Name{name: name}, nil
 }
 
+// IsValidTag reports whether s is a valid plan tag: one or more lowercase
+// letters, digits, or "-". Tags label plans for tooling, such as by audience
+// ("beta") or region ("eu"), and do not affect billing.
+func IsValidTag(s string) bool {
+	if s == "" {
+		return false
+	}
+	return strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-')
+	}) == -1
+}
+
+// Aliases maps alternate names for features to the names they stand for,
+// so that features can be renamed without breaking code that uses the old
+// name.
+type Aliases map[Name]Name
+
+// Resolve returns the name n is an alias for, or n if it is not an alias.
+func (a Aliases) Resolve(n Name) Name {
+	if to, ok := a[n]; ok {
+		return to
+	}
+	return n
+}
+
 type FeaturePlan struct {
 	name    string
 	version string // empty if plan is non-empty
@@ -164,26 +348,65 @@ func ParseFeaturePlans(s ...string) ([]FeaturePlan, error) {
 	return fps, nil
 }
 
+// IndexError records an error parsing the input at Index in a bulk parse.
+type IndexError struct {
+	Index int
+	Err   error
+}
+
+func (e *IndexError) Error() string { return fmt.Sprintf("[%d]: %v", e.Index, e.Err) }
+func (e *IndexError) Unwrap() error { return e.Err }
+
+// ParseFeaturePlansAll parses each string in s, continuing past failures so
+// that all problems can be reported at once. The returned feature plans are
+// positional, with the zero FeaturePlan in place of each input that failed
+// to parse, and errs holds an *IndexError for each failure, in order. If all
+// inputs parse, errs is nil.
+func ParseFeaturePlansAll(s []string) (fps []FeaturePlan, errs []error) {
+	fps = make([]FeaturePlan, len(s))
+	for i, s := range s {
+		fp, err := ParseFeaturePlan(s)
+		if err != nil {
+			errs = append(errs, &IndexError{Index: i, Err: err})
+			continue
+		}
+		fps[i] = fp
+	}
+	return fps, errs
+}
+
+// ParseFeaturePlan parses s as a feature plan, such as
+// "feature:seats@plan:pro@1" or "feature:seats@1". If s is invalid, the
+// error is a *ParseError.
 func ParseFeaturePlan(s string) (FeaturePlan, error) {
+	fp, err := parseFeaturePlan(s)
+	if err != nil {
+		return FeaturePlan{}, suggest(err, s, "feature:", parseFeaturePlan)
+	}
+	return fp, nil
+}
+
+func parseFeaturePlan(s string) (FeaturePlan, error) {
 	prefix, rest, hasPrefix := strings.Cut(s, ":")
 	if !hasPrefix || prefix != "feature" {
-		return FeaturePlan{}, invalid("feature plan must start with 'feature:'", s)
+		return FeaturePlan{}, invalid("feature plan must start with 'feature:'", s, 0, "'feature:'")
 	}
+	const start = len("feature:")
 	name, version, hasVersion := strings.Cut(rest, "@")
-	if isIllegalName(name) {
-		return FeaturePlan{}, invalid("feature plan name must match [a-zA-Z0-9:]+", s)
+	if i := illegalNameAt(name); i >= 0 {
+		return FeaturePlan{}, invalid("feature plan name must match "+namePattern, s, start+i, namePattern)
 	}
 	if !hasVersion {
-		return FeaturePlan{}, invalid("feature plan must have version", s)
+		return FeaturePlan{}, invalid("feature plan must have version", s, len(s), "'@' followed by a version or plan")
 	}
 
 	fp := FeaturePlan{name: name}
-	if p, err := ParsePlan(version); err == nil {
+	if p, err := parsePlan(version); err == nil {
 		fp.plan = p
 		return fp, nil
 	}
-	if isIllegalVersion(version) {
-		return FeaturePlan{}, invalid("feature plan version must match [a-zA-Z0-9]+ or be a valid plan", s)
+	if i := illegalVersionAt(version); i >= 0 {
+		return FeaturePlan{}, invalid("feature plan version must match "+versionPattern+" or be a valid plan", s, start+len(name)+1+i, versionPattern+" or a plan")
 	}
 	fp.version = version
 	return fp, nil
@@ -205,6 +428,67 @@ func MustParseFeaturePlans(s ...string) []FeaturePlan {
 	return fps
 }
 
+// ParseFeatureID parses the Stripe product or price ID of a feature plan,
+// such as "tier__feature-api-reads-plan-pro-1", as made by control's
+// Feature.ID, back into the feature plan it was made from. IDs of variants,
+// suffixed with their interval, parse to the feature plan they are a
+// variant of.
+//
+// IDs do not record where a feature name ends and its plan begins, so a
+// "plan" segment is taken to start the plan. If there is more than one,
+// as in the IDs of features with "plan" as a segment of their name or
+// their plan's, the ID is ambiguous, and the error is a *ParseError.
+func ParseFeatureID(id string) (FeaturePlan, error) {
+	const prefix = "tier__feature-"
+	if !strings.HasPrefix(id, prefix) {
+		return FeaturePlan{}, invalid("feature ID must start with 'tier__feature-'", id, 0, "'tier__feature-'")
+	}
+	rest, _, _ := strings.Cut(id[len(prefix):], "__") // drop any variant interval
+
+	// Names may only contain letters, digits, and colons, so each "-"
+	// in an ID was either a colon or an "@", and each "_" in a version
+	// was a ".".
+	colons := func(s string) string { return strings.ReplaceAll(s, "-", ":") }
+	dots := func(s string) string { return strings.ReplaceAll(s, "_", ".") }
+	var s string
+	switch plans := indexAll(rest, "-plan-"); len(plans) {
+	case 0:
+		i := strings.LastIndexByte(rest, '-')
+		if i < 0 {
+			return FeaturePlan{}, invalid("feature ID must have version", id, len(id), "'-' followed by a version")
+		}
+		s = "feature:" + colons(rest[:i]) + "@" + dots(rest[i+1:])
+	case 1:
+		name, plan := rest[:plans[0]], rest[plans[0]+len("-plan-"):]
+		i := strings.LastIndexByte(plan, '-')
+		if i < 0 {
+			return FeaturePlan{}, invalid("feature ID plan must have version", id, len(id), "'-' followed by a version")
+		}
+		s = "feature:" + colons(name) + "@plan:" + colons(plan[:i]) + "@" + dots(plan[i+1:])
+	default:
+		return FeaturePlan{}, invalid("feature ID is ambiguous: more than one 'plan' segment", id, len(prefix)+plans[1]+1, "one 'plan' segment")
+	}
+	fp, err := ParseFeaturePlan(s)
+	if err != nil {
+		return FeaturePlan{}, invalid("invalid feature ID", id, 0, "an ID made from a feature plan")
+	}
+	return fp, nil
+}
+
+// indexAll returns the indexes of each instance of sep in s, including
+// those overlapping others.
+func indexAll(s, sep string) []int {
+	var is []int
+	for off := 0; ; {
+		i := strings.Index(s[off:], sep)
+		if i < 0 {
+			return is
+		}
+		is = append(is, off+i)
+		off += i + 1
+	}
+}
+
 func ByName(a, b FeaturePlan) bool {
 	return a.name < b.name
 }
@@ -214,20 +498,25 @@ func (fp *FeaturePlan) UnmarshalJSON(b []byte) error {
 }
 
 func (fp *FeaturePlan) UnmarshalText(b []byte) error {
-	p, err := ParseFeaturePlan(string(b))
-	if err != nil {
-		return err
-	}
-	*fp = p
-	return nil
+	return unmarshalText(fp, ParseFeaturePlan, b)
 }
 
 func (fp FeaturePlan) MarshalJSON() ([]byte, error) {
-	return json.Marshal(fp.String())
+	return json.Marshal(string(marshalText(fp)))
 }
 
 func (fp FeaturePlan) MarshalText() ([]byte, error) {
-	return []byte(fp.String()), nil
+	return marshalText(fp), nil
+}
+
+// Scan implements sql.Scanner. NULL scans as the zero FeaturePlan.
+func (fp *FeaturePlan) Scan(src any) error {
+	return scan(fp, ParseFeaturePlan, src)
+}
+
+// Value implements driver.Valuer. The zero FeaturePlan is stored as NULL.
+func (fp FeaturePlan) Value() (driver.Value, error) {
+	return value(fp), nil
 }
 
 func (fp FeaturePlan) IsZero() bool {
@@ -255,6 +544,48 @@ func (fp FeaturePlan) IsVersionOf(p Name) bool {
 	return fp.name == p.name
 }
 
+// GroupByPlan returns fs grouped by plan, preserving the order of fs within
+// each group. Feature plans with a version instead of a plan are grouped
+// under the zero Plan.
+func GroupByPlan(fs []FeaturePlan) map[Plan][]FeaturePlan {
+	m := make(map[Plan][]FeaturePlan)
+	for _, fp := range fs {
+		m[fp.plan] = append(m[fp.plan], fp)
+	}
+	return m
+}
+
+// ByPlan orders feature plans by plan name, then by plan version as ordered
+// by CompareVersions, and then by feature name. It is for use with
+// slices.SortFunc.
+func ByPlan(a, b FeaturePlan) bool {
+	if a.plan.name != b.plan.name {
+		return a.plan.name < b.plan.name
+	}
+	if c := CompareVersions(a.plan.version, b.plan.version); c != 0 {
+		return c < 0
+	}
+	return a.name < b.name
+}
+
+// ByVersion orders feature plans by version, as ordered by
+// CompareVersions, and then by feature name. Feature plans in a plan are
+// ordered by the plan's version. It is for use with slices.SortFunc.
+func ByVersion(a, b FeaturePlan) bool {
+	if c := CompareVersions(a.versionOnly(), b.versionOnly()); c != 0 {
+		return c < 0
+	}
+	return a.name < b.name
+}
+
+// versionOnly returns the version of fp, or of its plan.
+func (fp FeaturePlan) versionOnly() string {
+	if fp.version != "" {
+		return fp.version
+	}
+	return fp.plan.version
+}
+
 func SortGroupedByVersion(fs []FeaturePlan) {
 	slices.SortFunc(fs, func(a, b FeaturePlan) bool {
 		if a.Version() < b.Version() {
@@ -264,29 +595,175 @@ func SortGroupedByVersion(fs []FeaturePlan) {
 	})
 }
 
-func invalid(msg string, id string) error {
-	return &ParseError{Message: msg, ID: id}
+// Pattern matches groups of hierarchically named features. A pattern is a
+// feature name in which any colon separated segment may be "*". A "*"
+// matches any single segment, except when it is the last segment of the
+// pattern, in which case it matches one or more segments. For example,
+// "feature:reports:*" matches "feature:reports:daily" and
+// "feature:reports:daily:pdf", but not "feature:reports". A pattern without
+// any "*" matches only the feature with the same name.
+type Pattern struct {
+	pattern string
+}
+
+// ParsePattern parses s as a feature pattern, such as "feature:reports:*".
+// If s is invalid, the error is a *ParseError.
+func ParsePattern(s string) (Pattern, error) {
+	p, err := parsePattern(s)
+	if err != nil {
+		return Pattern{}, suggest(err, s, "feature:", parsePattern)
+	}
+	return p, nil
+}
+
+func parsePattern(s string) (Pattern, error) {
+	prefix, rest, hasPrefix := strings.Cut(s, ":")
+	if !hasPrefix || prefix != "feature" {
+		return Pattern{}, invalid("feature pattern must start with 'feature:'", s, 0, "'feature:'")
+	}
+	off := len("feature:")
+	for _, seg := range strings.Split(rest, ":") {
+		if seg != "*" {
+			if i := illegalNameAt(seg); i >= 0 {
+				return Pattern{}, invalid("feature pattern segments must match [a-zA-Z0-9]+ or be '*'", s, off+i, "[a-zA-Z0-9]+ or '*'")
+			}
+		}
+		off += len(seg) + 1
+	}
+	return Pattern{pattern: rest}, nil
+}
+
+func MustParsePattern(s string) Pattern {
+	p, err := ParsePattern(s)
+	if err != nil {
+		panic(err)
+	}
+	return p
+}
+
+func (p Pattern) String() string   { return "feature:" + p.pattern }
+func (p Pattern) GoString() string { return fmt.Sprintf("<%s>", p) }
+func (p Pattern) IsZero() bool     { return p == Pattern{} }
+
+// IsWildcard reports whether p contains a "*" and so may match more than
+// one feature.
+func (p Pattern) IsWildcard() bool { return strings.Contains(p.pattern, "*") }
+
+// Match reports whether the feature named n matches p.
+func (p Pattern) Match(n Name) bool {
+	if p.IsZero() {
+		return false
+	}
+	psegs := strings.Split(p.pattern, ":")
+	segs := strings.Split(n.name, ":")
+	for i, ps := range psegs {
+		if i >= len(segs) {
+			return false
+		}
+		if ps == "*" && i == len(psegs)-1 {
+			return true
+		}
+		if ps != "*" && ps != segs[i] {
+			return false
+		}
+	}
+	return len(segs) == len(psegs)
+}
+
+func (p *Pattern) UnmarshalJSON(b []byte) error {
+	return unmarshal(p, ParsePattern, b)
+}
+
+func (p *Pattern) UnmarshalText(b []byte) error {
+	return unmarshalText(p, ParsePattern, b)
+}
+
+func (p Pattern) MarshalJSON() ([]byte, error) {
+	return json.Marshal(string(marshalText(p)))
+}
+
+func (p Pattern) MarshalText() ([]byte, error) {
+	return marshalText(p), nil
 }
 
-func isIllegalName(s string) bool {
-	if len(s) == 0 {
-		return true
+// Scan implements sql.Scanner. NULL scans as the zero Pattern.
+func (p *Pattern) Scan(src any) error {
+	return scan(p, ParsePattern, src)
+}
+
+// Value implements driver.Valuer. The zero Pattern is stored as NULL.
+func (p Pattern) Value() (driver.Value, error) {
+	return value(p), nil
+}
+
+const (
+	namePattern    = "[a-zA-Z0-9]+(:[a-zA-Z0-9]+)*"
+	versionPattern = "[a-zA-Z0-9]+(.[a-zA-Z0-9]+)*"
+)
+
+func invalid(msg string, id string, offset int, expected string) error {
+	return &ParseError{Message: msg, ID: id, Offset: offset, Expected: expected}
+}
+
+// suggest adds a suggestion to err, a *ParseError, if s parses with parse
+// after normalizing it as Lenient does, using prefix if s has none.
+func suggest[T fmt.Stringer](err error, s, prefix string, parse func(string) (T, error)) error {
+	pe, ok := err.(*ParseError)
+	if !ok {
+		return err
+	}
+	norm, _ := Lenient.normalize(s, prefix)
+	if v, err := parse(norm); err == nil && v.String() != s {
+		pe.Suggestion = v.String()
+	}
+	return pe
+}
+
+// illegalNameAt returns the byte offset at which the name s becomes
+// illegal, or -1 if s is a legal name: one or more segments separated by
+// ":", where each segment matches [a-zA-Z0-9]+. Empty segments, as in
+// "a::b" or "a:", are illegal, since Parent and Ancestors split names on
+// ":".
+func illegalNameAt(s string) int {
+	off := 0
+	for _, seg := range strings.Split(s, ":") {
+		if len(seg) == 0 {
+			return off
+		}
+		if i := strings.IndexFunc(seg, isIllegalNameRune); i >= 0 {
+			return off + i
+		}
+		off += len(seg) + 1
 	}
-	return strings.IndexFunc(s, isIllegalNameRune) != -1
+	return -1
 }
 
 func isIllegalNameRune(r rune) bool {
 	return !(r >= 'a' && r <= 'z' ||
 		r >= 'A' && r <= 'Z' ||
-		r >= '0' && r <= '9' ||
-		r == ':')
+		r >= '0' && r <= '9')
 }
 
+// isIllegalVersion reports whether s is not one or more parts separated by
+// ".", where each part matches [a-zA-Z0-9]+.
 func isIllegalVersion(s string) bool {
-	if len(s) == 0 {
-		return true
+	return illegalVersionAt(s) >= 0
+}
+
+// illegalVersionAt returns the byte offset at which the version s becomes
+// illegal, or -1 if s is a legal version.
+func illegalVersionAt(s string) int {
+	off := 0
+	for _, part := range strings.Split(s, ".") {
+		if len(part) == 0 {
+			return off
+		}
+		if i := strings.IndexFunc(part, isIllegalVersionRune); i >= 0 {
+			return off + i
+		}
+		off += len(part) + 1
 	}
-	return strings.IndexFunc(s, isIllegalVersionRune) != -1
+	return -1
 }
 
 func isIllegalVersionRune(r rune) bool {
@@ -295,12 +772,58 @@ func isIllegalVersionRune(r rune) bool {
 		r >= '0' && r <= '9')
 }
 
+// The refs types share the same text form in JSON, text, and SQL encodings:
+// the string form of the value, or the empty string (NULL in SQL) for the
+// zero value. Only NULL decodes as the zero value; empty strings are parsed,
+// and so rejected, like any other invalid ref, so that a missing ref in JSON
+// or text is not mistaken for an intended zero value.
+
+type ref interface {
+	comparable
+	fmt.Stringer
+}
+
+func marshalText[T ref](v T) []byte {
+	var zero T
+	if v == zero {
+		return []byte{}
+	}
+	return []byte(v.String())
+}
+
+func unmarshalText[T any](v *T, parse func(s string) (T, error), b []byte) error {
+	var err error
+	*v, err = parse(string(b))
+	return err
+}
+
 func unmarshal[T any](v *T, f func(s string) (T, error), b []byte) error {
 	var s string
 	if err := json.Unmarshal(b, &s); err != nil {
 		return err
 	}
-	var err error
-	*v, err = f(s)
-	return err
+	return unmarshalText(v, f, []byte(s))
+}
+
+func scan[T any](v *T, parse func(s string) (T, error), src any) error {
+	switch src := src.(type) {
+	case nil:
+		var zero T
+		*v = zero
+		return nil
+	case string:
+		return unmarshalText(v, parse, []byte(src))
+	case []byte:
+		return unmarshalText(v, parse, src)
+	default:
+		return fmt.Errorf("refs: cannot scan %T into %T", src, v)
+	}
+}
+
+func value[T ref](v T) driver.Value {
+	var zero T
+	if v == zero {
+		return nil
+	}
+	return v.String()
 }
@@ -1,11 +1,14 @@
 package refs
 
 import (
+	"database/sql/driver"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
 	"testing"
 
+	"golang.org/x/exp/slices"
 	"kr.dev/diff"
 )
 
@@ -23,7 +26,9 @@ func TestParsePlan(t *testing.T) {
 		{in: "plan:foo@!", errMatch: `plan version must match \[a-zA-Z0-9\]\+`},
 		{in: "plan:foo@-", errMatch: `plan version must match \[a-zA-Z0-9\]\+`},
 		{in: "plan:foo@0", errMatch: "^$"},
-		{in: "plan:fo!@0", errMatch: `plan name must match \[a-zA-Z0-9:\]\+`},
+		{in: "plan:fo!@0", errMatch: `plan name must match \[a-zA-Z0-9\]\+\(:\[a-zA-Z0-9\]\+\)\*`},
+		{in: "plan:a::b@0", errMatch: `plan name must match`},
+		{in: "plan:a:@0", errMatch: `plan name must match`},
 	}
 
 	for _, tt := range cases {
@@ -53,14 +58,17 @@ func TestParseName(t *testing.T) {
 	}{
 		{in: "", errMatch: "feature name must start with 'feature:'"},
 		{in: "f", errMatch: "feature name must start with 'feature:'"},
-		{in: "feature:", errMatch: `feature name must match \[a-zA-Z0-9:\]\+`},
+		{in: "feature:", errMatch: `feature name must match \[a-zA-Z0-9\]\+\(:\[a-zA-Z0-9\]\+\)\*`},
 		{in: "feature:foo", errMatch: "^$"},
 		{in: "feature:foo:bar", errMatch: "^$"},
-		{in: "feature:foo@", errMatch: `feature name must match \[a-zA-Z0-9:\]\+`},
-		{in: "feature:foo@_", errMatch: `feature name must match \[a-zA-Z0-9:\]\+`},
-		{in: "feature:foo@!", errMatch: `feature name must match \[a-zA-Z0-9:\]\+`},
-		{in: "feature:foo@-", errMatch: `feature name must match \[a-zA-Z0-9:\]\+`},
-		{in: "feature:fo!@0", errMatch: `feature name must match \[a-zA-Z0-9:\]\+`},
+		{in: "feature:foo@", errMatch: `feature name must match \[a-zA-Z0-9\]\+\(:\[a-zA-Z0-9\]\+\)\*`},
+		{in: "feature:foo@_", errMatch: `feature name must match \[a-zA-Z0-9\]\+\(:\[a-zA-Z0-9\]\+\)\*`},
+		{in: "feature:foo@!", errMatch: `feature name must match \[a-zA-Z0-9\]\+\(:\[a-zA-Z0-9\]\+\)\*`},
+		{in: "feature:foo@-", errMatch: `feature name must match \[a-zA-Z0-9\]\+\(:\[a-zA-Z0-9\]\+\)\*`},
+		{in: "feature:fo!@0", errMatch: `feature name must match \[a-zA-Z0-9\]\+\(:\[a-zA-Z0-9\]\+\)\*`},
+		{in: "feature:a::b", errMatch: `feature name must match`},
+		{in: "feature:a:", errMatch: `feature name must match`},
+		{in: "feature::a", errMatch: `feature name must match`},
 	}
 
 	for i, tt := range cases {
@@ -92,15 +100,17 @@ func TestParseFeaturePlan(t *testing.T) {
 		{in: "f", errMatch: "feature plan must start with 'feature:'"},
 		{in: "feature", errMatch: "feature plan must start with 'feature:'"},
 		{in: "plan:test@0", errMatch: "feature plan must start with 'feature:'"},
-		{in: "feature:", errMatch: `feature plan name must match \[a-zA-Z0-9:\]\+`},
-		{in: "feature:foo@", errMatch: `feature plan version must match \[a-zA-Z0-9\]\+ or be a valid plan`},
-		{in: "feature:foo@_", errMatch: `feature plan version must match \[a-zA-Z0-9\]\+ or be a valid plan`},
-		{in: "feature:foo@!", errMatch: `feature plan version must match \[a-zA-Z0-9\]\+ or be a valid plan`},
-		{in: "feature:foo@-", errMatch: `feature plan version must match \[a-zA-Z0-9\]\+ or be a valid plan`},
-		{in: "feature:foo@plan:", errMatch: `feature plan version must match \[a-zA-Z0-9\]\+ or be a valid plan`},
-		{in: "feature:foo@plan:0", errMatch: `feature plan version must match \[a-zA-Z0-9\]\+ or be a valid plan`},
-		{in: "feature:foo@plan:@", errMatch: `feature plan version must match \[a-zA-Z0-9\]\+ or be a valid plan`},
-		{in: "feature:fo!@0", errMatch: `feature plan name must match \[a-zA-Z0-9:\]\+`},
+		{in: "feature:", errMatch: `feature plan name must match \[a-zA-Z0-9\]\+\(:\[a-zA-Z0-9\]\+\)\*`},
+		{in: "feature:foo@", errMatch: `feature plan version must match \[a-zA-Z0-9\]\+\(\.\[a-zA-Z0-9\]\+\)\* or be a valid plan`},
+		{in: "feature:foo@_", errMatch: `feature plan version must match \[a-zA-Z0-9\]\+\(\.\[a-zA-Z0-9\]\+\)\* or be a valid plan`},
+		{in: "feature:foo@!", errMatch: `feature plan version must match \[a-zA-Z0-9\]\+\(\.\[a-zA-Z0-9\]\+\)\* or be a valid plan`},
+		{in: "feature:foo@-", errMatch: `feature plan version must match \[a-zA-Z0-9\]\+\(\.\[a-zA-Z0-9\]\+\)\* or be a valid plan`},
+		{in: "feature:foo@plan:", errMatch: `feature plan version must match \[a-zA-Z0-9\]\+\(\.\[a-zA-Z0-9\]\+\)\* or be a valid plan`},
+		{in: "feature:foo@plan:0", errMatch: `feature plan version must match \[a-zA-Z0-9\]\+\(\.\[a-zA-Z0-9\]\+\)\* or be a valid plan`},
+		{in: "feature:foo@plan:@", errMatch: `feature plan version must match \[a-zA-Z0-9\]\+\(\.\[a-zA-Z0-9\]\+\)\* or be a valid plan`},
+		{in: "feature:fo!@0", errMatch: `feature plan name must match \[a-zA-Z0-9\]\+\(:\[a-zA-Z0-9\]\+\)\*`},
+		{in: "feature:a::b@0", errMatch: `feature plan name must match`},
+		{in: "feature:a:@plan:free@0", errMatch: `feature plan name must match`},
 
 		{in: "feature:foo:bar@foo"},
 		{in: "feature:foo@abc1223"},
@@ -151,11 +161,333 @@ func TestParseFeaturePlan(t *testing.T) {
 	})
 }
 
+func TestParseErrorDetails(t *testing.T) {
+	cases := []struct {
+		in    string
+		parse func(string) error
+		want  *ParseError
+	}{
+		{"plan:pro@1.x!", func(s string) error { _, err := ParsePlan(s); return err }, &ParseError{
+			ID:       "plan:pro@1.x!",
+			Message:  "plan version must match [a-zA-Z0-9]+(.[a-zA-Z0-9]+)*",
+			Offset:   12,
+			Expected: "[a-zA-Z0-9]+(.[a-zA-Z0-9]+)*",
+		}},
+		{"Pro@1", func(s string) error { _, err := ParsePlan(s); return err }, &ParseError{
+			ID:         "Pro@1",
+			Message:    "plan name must start with 'plan:'",
+			Offset:     0,
+			Expected:   "'plan:'",
+			Suggestion: "plan:pro@1",
+		}},
+		{"feature:max_seats", func(s string) error { _, err := ParseName(s); return err }, &ParseError{
+			ID:         "feature:max_seats",
+			Message:    "feature name must match [a-zA-Z0-9]+(:[a-zA-Z0-9]+)*",
+			Offset:     11,
+			Expected:   "[a-zA-Z0-9]+(:[a-zA-Z0-9]+)*",
+			Suggestion: "feature:maxseats",
+		}},
+		{"feature:x", func(s string) error { _, err := ParseFeaturePlan(s); return err }, &ParseError{
+			ID:       "feature:x",
+			Message:  "feature plan must have version",
+			Offset:   9,
+			Expected: "'@' followed by a version or plan",
+		}},
+		{"feature:a::b", func(s string) error { _, err := ParseName(s); return err }, &ParseError{
+			ID:       "feature:a::b",
+			Message:  "feature name must match [a-zA-Z0-9]+(:[a-zA-Z0-9]+)*",
+			Offset:   10,
+			Expected: "[a-zA-Z0-9]+(:[a-zA-Z0-9]+)*",
+		}},
+		{"feature:a:", func(s string) error { _, err := ParseName(s); return err }, &ParseError{
+			ID:       "feature:a:",
+			Message:  "feature name must match [a-zA-Z0-9]+(:[a-zA-Z0-9]+)*",
+			Offset:   10,
+			Expected: "[a-zA-Z0-9]+(:[a-zA-Z0-9]+)*",
+		}},
+		{"feature:a:b!:*", func(s string) error { _, err := ParsePattern(s); return err }, &ParseError{
+			ID:       "feature:a:b!:*",
+			Message:  "feature pattern segments must match [a-zA-Z0-9]+ or be '*'",
+			Offset:   11,
+			Expected: "[a-zA-Z0-9]+ or '*'",
+		}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.in, func(t *testing.T) {
+			diff.Test(t, t.Errorf, tt.parse(tt.in), tt.want)
+		})
+	}
+
+	err := &ParseError{ID: "Pro@1", Message: "m", Suggestion: "plan:pro@1"}
+	if got, want := err.Error(), `m: Pro@1 (did you mean "plan:pro@1"?)`; got != want {
+		t.Errorf("Error() = %q; want %q", got, want)
+	}
+}
+
+func TestPattern(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"feature:foo", "feature:foo", true},
+		{"feature:foo", "feature:foo:bar", false},
+		{"feature:foo:*", "feature:foo", false},
+		{"feature:foo:*", "feature:foo:bar", true},
+		{"feature:foo:*", "feature:foo:bar:baz", true},
+		{"feature:foo:*", "feature:foobar:baz", false},
+		{"feature:*", "feature:foo", true},
+		{"feature:*:read", "feature:api:read", true},
+		{"feature:*:read", "feature:api:write", false},
+		{"feature:*:read", "feature:api:v1:read", false},
+	}
+	for _, tt := range cases {
+		p := MustParsePattern(tt.pattern)
+		if got := p.Match(MustParseName(tt.name)); got != tt.want {
+			t.Errorf("%q.Match(%q) = %v; want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+
+	for _, s := range []string{"", "feature:", "plan:foo", "feature:foo:", "feature:fo*", "feature:foo@0"} {
+		if _, err := ParsePattern(s); err == nil {
+			t.Errorf("ParsePattern(%q) = nil; want error", s)
+		}
+	}
+	if MustParsePattern("feature:foo").IsWildcard() {
+		t.Error("feature:foo: IsWildcard = true; want false")
+	}
+}
+
+func TestAncestors(t *testing.T) {
+	n := MustParseName("feature:api:reads:bulk")
+	p, ok := n.Parent()
+	if !ok || p != MustParseName("feature:api:reads") {
+		t.Errorf("Parent() = %v, %v; want feature:api:reads, true", p, ok)
+	}
+	want := []Name{
+		MustParseName("feature:api:reads"),
+		MustParseName("feature:api"),
+	}
+	diff.Test(t, t.Errorf, n.Ancestors(), want)
+
+	if p, ok := MustParseName("feature:api").Parent(); ok {
+		t.Errorf("Parent() = %v, true; want false", p)
+	}
+	if !n.IsDescendantOf(MustParseName("feature:api")) {
+		t.Error("IsDescendantOf(feature:api) = false; want true")
+	}
+	if n.IsDescendantOf(MustParseName("feature:ap")) {
+		t.Error("IsDescendantOf(feature:ap) = true; want false")
+	}
+	if n.IsDescendantOf(n) {
+		t.Error("IsDescendantOf(self) = true; want false")
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"0", "0", 0},
+		{"1", "2", -1},
+		{"10", "9", +1},
+		{"9", "a", -1},
+		{"b", "10", +1},
+		{"a", "b", -1},
+		{"1.2.0", "1.2.0", 0},
+		{"1.10.0", "1.9.0", +1},
+		{"1.2", "1.2.0", -1},
+		{"2", "1.9.9", +1},
+		{"1.0.0", "1.0.rc1", -1},
+	}
+	for _, tt := range cases {
+		if got := CompareVersions(tt.a, tt.b); got != tt.want {
+			t.Errorf("CompareVersions(%q, %q) = %d; want %d", tt.a, tt.b, got, tt.want)
+		}
+	}
+
+	p := MustParsePlan("plan:pro@latest")
+	if !p.IsLatest() {
+		t.Error("IsLatest() = false; want true")
+	}
+	if got := p.WithVersion("3"); got != MustParsePlan("plan:pro@3") {
+		t.Errorf("WithVersion(3) = %v", got)
+	}
+
+	for _, s := range []string{"plan:pro@1.", "plan:pro@.1", "plan:pro@1..2"} {
+		if _, err := ParsePlan(s); err == nil {
+			t.Errorf("ParsePlan(%q): expected error", s)
+		}
+	}
+	if got := MustParsePlan("plan:pro@1.2.0").Version(); got != "1.2.0" {
+		t.Errorf("Version() = %q; want 1.2.0", got)
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	cases := []struct {
+		in      string
+		want    string
+		changes int
+	}{
+		{"feature:api:reads", "feature:api:reads", 0},
+		{" Feature:API.Reads ", "feature:api:reads", 3},
+		{"api/reads", "feature:api:reads", 2},
+		{"max_seats", "feature:maxseats", 2},
+		{"feature:Max-Seats", "feature:maxseats", 2},
+	}
+	for _, tt := range cases {
+		n, changes, err := Lenient.ParseName(tt.in)
+		if err != nil {
+			t.Errorf("Lenient.ParseName(%q): %v", tt.in, err)
+			continue
+		}
+		if n.String() != tt.want || len(changes) != tt.changes {
+			t.Errorf("Lenient.ParseName(%q) = %v, %q; want %v with %d changes", tt.in, n, changes, tt.want, tt.changes)
+		}
+	}
+
+	fp, _, err := Lenient.ParseFeaturePlan("Seats@Plan:Pro@1")
+	if err != nil || fp != MustParseFeaturePlan("feature:seats@plan:pro@1") {
+		t.Errorf("Lenient.ParseFeaturePlan = %v, %v", fp, err)
+	}
+	p, _, err := Lenient.ParsePlan("pro@1")
+	if err != nil || p != MustParsePlan("plan:pro@1") {
+		t.Errorf("Lenient.ParsePlan = %v, %v", p, err)
+	}
+	p, _, err = Lenient.ParsePlan("Pro.EU@1.2.0")
+	if err != nil || p != MustParsePlan("plan:pro:eu@1.2.0") {
+		t.Errorf("Lenient.ParsePlan = %v, %v", p, err)
+	}
+
+	// Strict parses exactly as ParseName.
+	for _, s := range []string{"feature:API", "api", " feature:x"} {
+		n, changes, err := Strict.ParseName(s)
+		wn, werr := ParseName(s)
+		if n != wn || (err == nil) != (werr == nil) || changes != nil {
+			t.Errorf("Strict.ParseName(%q) = %v, %q, %v; want %v, nil, %v", s, n, changes, err, wn, werr)
+		}
+	}
+}
+
+func TestParseFeaturePlansAll(t *testing.T) {
+	fps, errs := ParseFeaturePlansAll([]string{
+		"feature:a@0",
+		"feature:b",
+		"feature:c@plan:free@1",
+		"plan:free@1",
+	})
+	want := []FeaturePlan{
+		MustParseFeaturePlan("feature:a@0"),
+		{},
+		MustParseFeaturePlan("feature:c@plan:free@1"),
+		{},
+	}
+	diff.Test(t, t.Errorf, fps, want)
+
+	if len(errs) != 2 {
+		t.Fatalf("got %d errors; want 2: %v", len(errs), errs)
+	}
+	for i, wantIndex := range []int{1, 3} {
+		var ie *IndexError
+		if !errors.As(errs[i], &ie) || ie.Index != wantIndex {
+			t.Errorf("errs[%d] = %v; want error at index %d", i, errs[i], wantIndex)
+		}
+		var pe *ParseError
+		if !errors.As(errs[i], &pe) {
+			t.Errorf("errs[%d] = %v; want *ParseError", i, errs[i])
+		}
+	}
+
+	if _, errs := ParseFeaturePlansAll([]string{"feature:a@0"}); errs != nil {
+		t.Errorf("errs = %v; want nil", errs)
+	}
+}
+
+func TestParseFeatureID(t *testing.T) {
+	cases := []struct {
+		id   string
+		want string
+	}{
+		{"tier__feature-test-plan-free-theVersion", "feature:test@plan:free@theVersion"},
+		{"tier__feature-api-reads-plan-pro-eu-10", "feature:api:reads@plan:pro:eu@10"},
+		{"tier__feature-x-plan-pro-1_2_0", "feature:x@plan:pro@1.2.0"},
+		{"tier__feature-x-plan-pro-1__yearly", "feature:x@plan:pro@1"},
+		{"tier__feature-x-1", "feature:x@1"},
+	}
+	for _, tt := range cases {
+		got, err := ParseFeatureID(tt.id)
+		if err != nil {
+			t.Errorf("ParseFeatureID(%q): %v", tt.id, err)
+			continue
+		}
+		if got != MustParseFeaturePlan(tt.want) {
+			t.Errorf("ParseFeatureID(%q) = %v; want %s", tt.id, got, tt.want)
+		}
+	}
+
+	for _, id := range []string{
+		"",
+		"feature-x-1",
+		"tier__feature-x",
+		"tier__feature-x-plan-pro",
+		"tier__feature--plan-pro-1",
+		"tier__feature-a-plan-b-plan-pro-1",
+		"tier__feature-x-plan-plan-y-1",
+	} {
+		got, err := ParseFeatureID(id)
+		var pe *ParseError
+		if !errors.As(err, &pe) {
+			t.Errorf("ParseFeatureID(%q) = %v, %v; want *ParseError", id, got, err)
+		}
+	}
+}
+
+func TestGroupByPlan(t *testing.T) {
+	fs := MustParseFeaturePlans(
+		"feature:b@plan:pro@10",
+		"feature:a@plan:free@0",
+		"feature:a@plan:pro@10",
+		"feature:x@1",
+		"feature:a@plan:pro@9",
+	)
+	got := GroupByPlan(fs)
+	want := map[Plan][]FeaturePlan{
+		MustParsePlan("plan:pro@10"): MustParseFeaturePlans("feature:b@plan:pro@10", "feature:a@plan:pro@10"),
+		MustParsePlan("plan:pro@9"):  MustParseFeaturePlans("feature:a@plan:pro@9"),
+		MustParsePlan("plan:free@0"): MustParseFeaturePlans("feature:a@plan:free@0"),
+		{}:                           MustParseFeaturePlans("feature:x@1"),
+	}
+	diff.Test(t, t.Errorf, got, want)
+
+	byPlan := slices.Clone(fs)
+	slices.SortFunc(byPlan, ByPlan)
+	diff.Test(t, t.Errorf, byPlan, MustParseFeaturePlans(
+		"feature:x@1",
+		"feature:a@plan:free@0",
+		"feature:a@plan:pro@9",
+		"feature:a@plan:pro@10",
+		"feature:b@plan:pro@10",
+	))
+
+	byVersion := slices.Clone(fs)
+	slices.SortFunc(byVersion, ByVersion)
+	diff.Test(t, t.Errorf, byVersion, MustParseFeaturePlans(
+		"feature:a@plan:free@0",
+		"feature:x@1",
+		"feature:a@plan:pro@9",
+		"feature:a@plan:pro@10",
+		"feature:b@plan:pro@10",
+	))
+}
+
 func TestRoundTrips(t *testing.T) {
 	testRoundTrip(t, ParseFeaturePlan, "feature:foo@0")
 	testRoundTrip(t, ParseFeaturePlan, "feature:foo@plan:free@0")
 	testRoundTrip(t, ParseName, "feature:foo")
 	testRoundTrip(t, ParsePlan, "plan:foo@0")
+	testRoundTrip(t, ParsePattern, "feature:foo:*")
 }
 
 func testRoundTrip[T fmt.Stringer](t *testing.T, parse func(string) (T, error), s string) {
@@ -192,6 +524,70 @@ func TestJSON(t *testing.T) {
 	testJSON(t, ParseFeaturePlan, "feature:foo@plan:free@0")
 	testJSON(t, ParseName, "feature:foo")
 	testJSON(t, ParsePlan, "plan:foo@0")
+	testJSON(t, ParsePattern, "feature:*:bar")
+}
+
+func TestSQL(t *testing.T) {
+	testSQL(t, ParseFeaturePlan, "feature:foo@plan:free@0")
+	testSQL(t, ParseName, "feature:foo")
+	testSQL(t, ParsePlan, "plan:foo@0")
+	testSQL(t, ParsePattern, "feature:foo:*")
+
+	var p Plan
+	if err := p.Scan(42); err == nil {
+		t.Error("Scan(42): expected error")
+	}
+	if err := p.Scan("plan:"); err == nil {
+		t.Error("Scan(plan:): expected error")
+	}
+}
+
+func testSQL[T ref](t *testing.T, f func(string) (T, error), s string) {
+	t.Helper()
+	n, err := f(s)
+	if err != nil {
+		t.Fatalf("%q: %v", s, err)
+	}
+	check := func(v any, src any, want T) {
+		t.Helper()
+		sc := v.(interface{ Scan(any) error })
+		if err := sc.Scan(src); err != nil {
+			t.Errorf("Scan(%#v): %v", src, err)
+		}
+		if got := *v.(*T); got != want {
+			t.Errorf("Scan(%#v) = %v; want %v", src, got, want)
+		}
+	}
+
+	v, err := any(n).(driver.Valuer).Value()
+	if err != nil || v != s {
+		t.Errorf("Value() = %#v, %v; want %q", v, err, s)
+	}
+	check(new(T), v, n)
+	check(new(T), []byte(s), n)
+
+	var zero T
+	v, _ = any(zero).(driver.Valuer).Value()
+	if v != nil {
+		t.Errorf("zero Value() = %#v; want nil", v)
+	}
+	got := n
+	check(&got, nil, zero)
+
+	// Only NULL is the zero value; empty strings are parsed.
+	if err := any(&got).(interface{ Scan(any) error }).Scan(""); err == nil {
+		t.Errorf("Scan(\"\"): expected error")
+	}
+	b, err := json.Marshal(zero)
+	if err != nil || string(b) != `""` {
+		t.Errorf("zero MarshalJSON() = %s, %v; want \"\"", b, err)
+	}
+	if err := json.Unmarshal(b, &got); err == nil {
+		t.Errorf("Unmarshal(%s): expected error", b)
+	}
+	if err := any(&got).(interface{ UnmarshalText([]byte) error }).UnmarshalText(nil); err == nil {
+		t.Errorf("UnmarshalText(nil): expected error")
+	}
 }
 
 func TestAsMapKeyWithJSON(t *testing.T) {
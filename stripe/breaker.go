@@ -0,0 +1,153 @@
+package stripe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 10 * time.Second
+
+	// The retry budget allows a burst of maxRetryTokens retries, after
+	// which retries are allowed at a rate of retryTokenRatio per request
+	// made. This keeps retries from multiplying load on Stripe when it is
+	// already failing.
+	maxRetryTokens  = 10
+	retryTokenRatio = 0.1
+)
+
+// breaker tracks the health of Stripe as seen by a Client and its clones.
+// It opens after a run of consecutive failures, failing requests fast with
+// ErrStripeUnavailable until a cooldown has passed, and then allows a
+// single probe request through to decide whether to close again.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int       // consecutive failures
+	openUntil time.Time // zero if closed
+	probing   bool      // a probe request is in flight
+	lastErr   error     // last failure
+	tokens    float64   // retry budget
+}
+
+// breaker returns the breaker shared by c and its clones, creating it if
+// needed.
+func (c *Client) breaker() *breaker {
+	c.limMu.Lock()
+	defer c.limMu.Unlock()
+	if c.brk == nil {
+		c.brk = &breaker{tokens: maxRetryTokens}
+	}
+	return c.brk
+}
+
+func (c *Client) breakerThreshold() int {
+	if c.BreakerThreshold == 0 {
+		return defaultBreakerThreshold
+	}
+	return c.BreakerThreshold
+}
+
+func (c *Client) breakerCooldown() time.Duration {
+	if c.BreakerCooldown <= 0 {
+		return defaultBreakerCooldown
+	}
+	return c.BreakerCooldown
+}
+
+// allow reports an error wrapping ErrStripeUnavailable if c's breaker is
+// open, and otherwise nil, in which case the caller must report the outcome
+// of its request to record.
+func (c *Client) allow() error {
+	if c.breakerThreshold() < 0 {
+		return nil
+	}
+	b := c.breaker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) || b.probing {
+		return fmt.Errorf("%w: %v", ErrStripeUnavailable, b.lastErr)
+	}
+	b.probing = true
+	return nil
+}
+
+// record records the outcome of a request allowed by allow. Requests that
+// fail because ctx is done are not counted for or against Stripe.
+func (c *Client) record(ctx context.Context, err error) {
+	b := c.breaker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.tokens = min(b.tokens+retryTokenRatio, maxRetryTokens)
+	if c.breakerThreshold() < 0 {
+		return
+	}
+	b.probing = false
+	if ctx.Err() != nil {
+		return
+	}
+	if !isUnavailable(err) {
+		if err == nil || isStripeError(err) {
+			b.failures = 0
+			b.openUntil = time.Time{}
+		}
+		return
+	}
+	b.failures++
+	b.lastErr = err
+	if b.failures >= c.breakerThreshold() {
+		if b.openUntil.IsZero() {
+			c.logf("stripe: %d consecutive failures; failing fast for %v: %v", b.failures, c.breakerCooldown(), err)
+		}
+		b.openUntil = time.Now().Add(c.breakerCooldown())
+	}
+}
+
+// spendRetry reports whether the retry budget allows another retry, and if
+// so, spends it.
+func (c *Client) spendRetry() bool {
+	b := c.breaker()
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// IsUnavailable reports whether err indicates Stripe could not be reached:
+// a network error, a 5xx response, or ErrStripeUnavailable.
+func IsUnavailable(err error) bool {
+	return errors.Is(err, ErrStripeUnavailable) || isUnavailable(err)
+}
+
+// isUnavailable reports whether err indicates Stripe is unavailable: a
+// network error or a 5xx response.
+func isUnavailable(err error) bool {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Status >= 500
+	}
+	var ue *url.Error
+	return errors.As(err, &ue)
+}
+
+func isStripeError(err error) bool {
+	var e *Error
+	return errors.As(err, &e) || errors.Is(err, ErrInvalidAPIKey)
+}
+
+func min[T float64 | time.Duration](a, b T) T {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,89 @@
+package stripe
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	var requests int
+	var failing bool
+	c := newTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		if failing {
+			w.WriteHeader(500)
+			w.Write([]byte(`{"error": {"type": "api_error", "message": "oops"}}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+	c.BreakerThreshold = 3
+	c.BreakerCooldown = 50 * time.Millisecond
+
+	ctx := context.Background()
+	check := func(wantRequests int, wantErr error) {
+		t.Helper()
+		requests = 0
+		err := c.Do(ctx, "GET", "/", Form{}, nil)
+		if !errors.Is(err, wantErr) {
+			t.Errorf("got %v; want %v", err, wantErr)
+		}
+		if requests != wantRequests {
+			t.Errorf("requests = %d; want %d", requests, wantRequests)
+		}
+	}
+
+	failing = true
+	check(1, ErrAPI)
+	check(1, ErrAPI)
+	check(1, ErrAPI)
+	check(0, ErrStripeUnavailable)
+
+	// Clones share the breaker.
+	if err := c.CloneAs("acct_123").Do(ctx, "GET", "/", Form{}, nil); !errors.Is(err, ErrStripeUnavailable) {
+		t.Errorf("clone: got %v; want %v", err, ErrStripeUnavailable)
+	}
+
+	// A failed probe reopens the breaker.
+	time.Sleep(60 * time.Millisecond)
+	check(1, ErrAPI)
+	check(0, ErrStripeUnavailable)
+
+	// A successful probe closes it.
+	time.Sleep(60 * time.Millisecond)
+	failing = false
+	check(1, nil)
+	check(1, nil)
+
+	c = newTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		w.WriteHeader(500)
+		w.Write([]byte(`{"error": {"type": "api_error", "message": "oops"}}`))
+	})
+	c.BreakerThreshold = -1
+	for i := 0; i < 10; i++ {
+		check(1, ErrAPI)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	var requests int
+	c := newTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+		w.Write([]byte(`{"error": {"code": "rate_limit", "message": "Too many requests"}}`))
+	})
+	c.MaxRetries = 100
+
+	err := c.Do(context.Background(), "GET", "/", Form{}, nil)
+	if !errors.Is(err, ErrRateLimited) {
+		t.Errorf("got %v; want %v", err, ErrRateLimited)
+	}
+	if want := 1 + maxRetryTokens; requests != want {
+		t.Errorf("requests = %d; want %d", requests, want)
+	}
+}
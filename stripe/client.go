@@ -1,8 +1,10 @@
 package stripe
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
@@ -11,29 +13,70 @@ import (
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 	"unicode"
 
 	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"tier.run/metrics"
+	"tier.run/trace"
 	"tier.run/trutil"
 )
 
 var (
 	ErrInvalidAPIKey = errors.New("stripe: Invalid API Key")
+	ErrLiveModeGuard = errors.New("stripe: destructive operation in live mode requires AllowLive")
+
+	// ErrStripeUnavailable is returned, wrapping the last failure seen,
+	// when requests fail fast because Stripe has recently failed repeatedly
+	// with network errors or 5xx responses.
+	ErrStripeUnavailable = errors.New("stripe: unavailable")
+)
+
+// Error families
+//
+// An *Error matches these errors using errors.Is if it belongs to the family.
+var (
+	ErrRateLimited     = errors.New("stripe: rate limited")          // status 429
+	ErrCardDeclined    = errors.New("stripe: card declined")         // type "card_error"
+	ErrIdempotency     = errors.New("stripe: idempotency error")     // type "idempotency_error"
+	ErrResourceMissing = errors.New("stripe: resource missing")      // code "resource_missing"
+	ErrPermission      = errors.New("stripe: permission denied")     // status 403
+	ErrAPI             = errors.New("stripe: internal stripe error") // type "api_error"
 )
 
 var debugMode = os.Getenv("STRIPE_DEBUG") == "1"
 
+// APIVersion is the Stripe API version the package is written against. It is
+// sent with every request, unless overridden by Client.APIVersion, so that
+// upgrading the account's default version in the Stripe dashboard does not
+// change the shape of responses.
+const APIVersion = "2022-11-15"
+
 // Meta represents metadata in Stripe.
 type Meta map[string]string
 
 func (m Meta) Get(k string) string { return m[k] }
 
+// Limits Stripe places on values sent to it.
+const (
+	MaxLookupKeyLen     = 200 // price lookup keys
+	MaxMetadataValueLen = 500 // metadata values
+)
+
+// MakeID returns a Stripe ID made from parts. Characters not allowed in IDs
+// are replaced with "-", except for "." which is replaced with "_" so that
+// versions such as "1.2.0" do not make the same IDs as names using ":".
 func MakeID(parts ...string) string {
 	id := []rune(strings.Join(parts, "__"))
 	for i, r := range id {
-		if r != '_' && !unicode.IsDigit(r) && !unicode.IsLetter(r) {
+		switch {
+		case r == '.':
+			id[i] = '_'
+		case r != '_' && !unicode.IsDigit(r) && !unicode.IsLetter(r):
 			id[i] = '-'
 		}
 	}
@@ -55,14 +98,42 @@ func Link(live bool, accountID string, parts ...string) (string, error) {
 	return url.JoinPath(base, append([]string{accountID}, parts...)...)
 }
 
+// Error is an error response from Stripe. See
+// https://stripe.com/docs/api/errors for more information.
 type Error struct {
-	AccountID string
-	Type      string
-	Code      string
-	Param     string
-	Message   string
-	DocURL    string
-	RequestID string
+	AccountID   string
+	Type        string // (e.g. "card_error", "invalid_request_error")
+	Code        string // (e.g. "resource_missing")
+	DeclineCode string `json:"decline_code"` // set for card errors (e.g. "insufficient_funds")
+	Param       string
+	Message     string
+	DocURL      string `json:"doc_url"`
+	RequestID   string
+
+	// Status is the HTTP status code of the response the error was
+	// decoded from.
+	Status int
+}
+
+// Is reports if e belongs to the error family target. Known families are
+// ErrRateLimited, ErrCardDeclined, ErrIdempotency, ErrResourceMissing,
+// ErrPermission, and ErrAPI.
+func (e *Error) Is(target error) bool {
+	switch target {
+	case ErrRateLimited:
+		return e.Status == http.StatusTooManyRequests
+	case ErrCardDeclined:
+		return e.Type == "card_error"
+	case ErrIdempotency:
+		return e.Type == "idempotency_error"
+	case ErrResourceMissing:
+		return e.Code == "resource_missing"
+	case ErrPermission:
+		return e.Status == http.StatusForbidden
+	case ErrAPI:
+		return e.Type == "api_error"
+	}
+	return false
 }
 
 func (e *Error) Error() string {
@@ -78,6 +149,10 @@ func (e *Error) Error() string {
 		b.WriteString(" code:")
 		b.WriteString(e.Code)
 	}
+	if e.DeclineCode != "" {
+		b.WriteString(" decline_code:")
+		b.WriteString(e.DeclineCode)
+	}
 	if e.Type != "" {
 		b.WriteString(" type:")
 		b.WriteString(e.Type)
@@ -98,17 +173,62 @@ func (e *Error) Error() string {
 type Form struct {
 	v              url.Values
 	idempotencyKey string
+	account        string
 }
 
 // Clone returns a clone f.
 func (f Form) Clone() Form {
-	return Form{v: maps.Clone(f.v)}
+	return Form{
+		v:              maps.Clone(f.v),
+		idempotencyKey: f.idempotencyKey,
+		account:        f.account,
+	}
 }
 
 func (f *Form) SetIdempotencyKey(key string) {
 	f.idempotencyKey = key
 }
 
+// SetAccount sets the connected account the request is made on behalf of,
+// overriding any account set in the request context or on the Client.
+func (f *Form) SetAccount(accountID string) {
+	f.account = accountID
+}
+
+type accountKey struct{}
+
+// WithAccount returns a copy of ctx that causes requests made with it to be
+// made on behalf of the connected account with the provided ID, overriding
+// the Client's AccountID. An account set with Form.SetAccount takes
+// precedence.
+func WithAccount(ctx context.Context, accountID string) context.Context {
+	return context.WithValue(ctx, accountKey{}, accountID)
+}
+
+type idempotencyKey struct{}
+
+// WithIdempotencyKey returns a copy of ctx that causes POST and DELETE
+// requests made with it, and without their own idempotency key, to use a key
+// derived from key and the request. The derived key is stable for identical
+// requests, so retrying an entire operation with the same key is safe.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKey{}, key)
+}
+
+// IdempotencyKeyFromContext returns the key set in ctx using
+// WithIdempotencyKey, if any.
+func IdempotencyKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKey{}).(string)
+	return key
+}
+
+// AccountFromContext returns the account set in ctx using WithAccount, if
+// any.
+func AccountFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(accountKey{}).(string)
+	return id
+}
+
 // Add creates a key and value from args and adds the value to the key. The key
 // is constructed from all values in args up until the final, which will be
 // used as the value.
@@ -144,6 +264,35 @@ func MaybeSet[T comparable](f *Form, key string, val T) {
 	}
 }
 
+// maxExpandDepth is the maximum number of levels Stripe will expand in a
+// single expand[] parameter.
+const maxExpandDepth = 4
+
+// Expand adds each path to the expand[] parameters of the request, skipping
+// any already present. Paths are dot-separated field names, and are
+// prefixed with "data." for list requests.
+//
+// Expand panics if a path is empty or has more levels than Stripe allows.
+//
+// Example mapping:
+//
+//	f.Expand("data.tiers", "data.product") // => "expand[]=data.tiers&expand[]=data.product"
+func (f *Form) Expand(paths ...string) {
+	for _, path := range paths {
+		if path == "" || strings.Contains(path, "..") ||
+			strings.HasPrefix(path, ".") || strings.HasSuffix(path, ".") {
+			panic(fmt.Sprintf("stripe: invalid expand path %q", path))
+		}
+		if n := strings.Count(path, ".") + 1; n > maxExpandDepth {
+			panic(fmt.Sprintf("stripe: expand path %q has %d levels; max is %d", path, n, maxExpandDepth))
+		}
+		if slices.Contains(f.v["expand[]"], path) {
+			continue
+		}
+		f.Add("expand[]", path)
+	}
+}
+
 // Encode encodes the values into “URL encoded” form ("bar=baz&foo=quux")
 // sorted by key.
 func (f *Form) Encode() string {
@@ -186,18 +335,108 @@ type Client struct {
 	AccountID  string
 	Logf       func(format string, args ...any)
 
+	// SecondaryAPIKey, if set, replaces APIKey once Stripe rejects APIKey
+	// as invalid, so that keys can be rotated without downtime. APIKey and
+	// SecondaryAPIKey set the initial keys of c and the clones made by
+	// CloneAs, which share them. Use SetKey to replace them once c is in
+	// use; it checks the keys, and is safe for concurrent use.
+	SecondaryAPIKey string
+
 	// KeyPrefix is prepended to all idempotentcy keys. Use a new key prefix
 	// after deleting test data. It is not recommended for use with live mode.
 	KeyPrefix string
+
+	// MaxRetries is the maximum number of times an idempotent request is
+	// retried after being rate limited by Stripe. If zero,
+	// defaultMaxRetries is used. If negative, requests are not retried.
+	MaxRetries int
+
+	// MaxInFlight is the maximum number of requests c and its clones may
+	// have in flight at once. If zero, there is no limit.
+	MaxInFlight int
+
+	// MaxInFlightPerAccount is the maximum number of requests c and its
+	// clones may have in flight at once on behalf of any one account. If
+	// zero, a default of 20 in test mode, or 50 in live mode, is used. If
+	// negative, there is no limit.
+	//
+	// Stripe rejects requests beyond its concurrency limits, or times out
+	// waiting on locks held by other requests, so parallel work is best
+	// kept under them.
+	MaxInFlightPerAccount int
+
+	// AllowLive must be true for destructive operations to be made using
+	// a live key. Without it they fail with ErrLiveModeGuard, preventing
+	// test tooling from mutating production billing data.
+	//
+	// Only operations that cannot be undone are guarded: DELETE requests,
+	// and, in package control, canceling subscriptions immediately,
+	// voiding invoices or marking them uncollectible, refunds, credit
+	// notes, and archiving features. Scheduling orgs, which later
+	// schedules replace, is not guarded.
+	AllowLive bool
+
+	// APIVersion, if set, is sent in the Stripe-Version header in place of
+	// the package's pinned APIVersion.
+	APIVersion string
+
+	// Debug, if true, causes each request and response to be logged to
+	// Logf. API keys, emails, and payment details are redacted from the
+	// logged data. Debug logging is also enabled if STRIPE_DEBUG=1.
+	Debug bool
+
+	// Tracer, if non-nil, traces each request made to Stripe as a span
+	// named for its method, with its path, account, status, and Stripe
+	// request ID.
+	Tracer trace.Tracer
+
+	// Metrics, if non-nil, records the duration of each request made to
+	// Stripe in the histogram tier_stripe_request_duration_seconds,
+	// labeled by method and status, with status "0" for requests that
+	// got no response.
+	Metrics metrics.Sink
+
+	// Transport configures the connections made to Stripe when HTTPClient
+	// is nil. The zero value selects defaults suited to high request
+	// volumes; see TransportConfig.
+	Transport TransportConfig
+
+	// BreakerThreshold is the number of consecutive requests failing with
+	// network errors or 5xx responses after which c and its clones fail
+	// requests fast with ErrStripeUnavailable, until BreakerCooldown has
+	// passed. If zero, 5 is used. If negative, requests never fail fast.
+	BreakerThreshold int
+
+	// BreakerCooldown is how long requests fail fast once
+	// BreakerThreshold is reached. If zero, 10 seconds is used.
+	BreakerCooldown time.Duration
+
+	limMu sync.Mutex
+	lim   *limiter // shared with clones; see limiter
+	brk   *breaker // shared with clones; see breaker
+	keys  *keyring // shared with clones; see keyring
+
+	keysFrom [2]string // the APIKey and SecondaryAPIKey keys last took
+
+	hcMu sync.Mutex
+	hc   *http.Client // shared with clones; see client
 }
 
+const defaultMaxRetries = 3
+
 func FromEnv() (*Client, error) {
 	key := os.Getenv("STRIPE_API_KEY")
 	if key == "" {
 		return nil, errors.New("stripe: missing STRIPE_API_KEY")
 	}
 	baseURL := os.Getenv("STRIPE_BASE_API_URL")
-	return &Client{APIKey: key, BaseURL: baseURL}, nil
+	c := &Client{
+		APIKey:          key,
+		SecondaryAPIKey: os.Getenv("STRIPE_API_KEY_SECONDARY"),
+		BaseURL:         baseURL,
+	}
+	c.keyring()
+	return c, nil
 }
 
 func IsLiveKey(key string) bool {
@@ -205,14 +444,17 @@ func IsLiveKey(key string) bool {
 }
 
 func (c *Client) Live() bool {
-	return IsLiveKey(c.APIKey)
+	return IsLiveKey(c.apiKey())
 }
 
-func (c *Client) client() *http.Client {
-	if c.HTTPClient != nil {
-		return c.HTTPClient
+// GuardLive returns ErrLiveModeGuard if c is using a live key and AllowLive
+// is not set. Callers should check it before any operation that cannot be
+// undone; see AllowLive.
+func (c *Client) GuardLive() error {
+	if c.Live() && !c.AllowLive {
+		return ErrLiveModeGuard
 	}
-	return http.DefaultClient
+	return nil
 }
 
 func (c *Client) baseURL() string {
@@ -222,18 +464,91 @@ func (c *Client) baseURL() string {
 	return "https://api.stripe.com"
 }
 
+// Do sends a request to Stripe and decodes the response into out, if out is
+// non-nil.
+//
+// Requests that are rate limited by Stripe are retried after the delay
+// indicated in the Retry-After header, or a short backoff if none, but only
+// if they are safe to retry: GET and DELETE requests, and requests with an
+// idempotency key. If a request is still rate limited after MaxRetries, or
+// is not safe to retry, the returned error matches ErrRateLimited. Retries
+// are also limited by a budget shared with c's clones, which allows a burst
+// of retries and then one retry for every ten requests.
+//
+// Requests fail fast with ErrStripeUnavailable after repeated network
+// errors or 5xx responses; see BreakerThreshold.
+//
+// POST and DELETE requests without an idempotency key set using
+// Form.SetIdempotencyKey are sent with one derived from the key set in ctx
+// using WithIdempotencyKey, or else a random key, making them safe to retry.
+//
+// DELETE requests fail with ErrLiveModeGuard if GuardLive does.
 func (c *Client) Do(ctx context.Context, method, path string, f Form, out any) error {
+	if method == "DELETE" {
+		if err := c.GuardLive(); err != nil {
+			return err
+		}
+	}
+	if f.idempotencyKey == "" && (method == "POST" || method == "DELETE") {
+		f.idempotencyKey = c.newIdempotencyKey(ctx, method, path, f)
+	}
+	for attempt := 0; ; attempt++ {
+		// Make no request once ctx is done, so that operations making
+		// many requests stop between them.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := c.allow(); err != nil {
+			return err
+		}
+		wait, err := c.doWithKey(ctx, method, path, f, out)
+		c.record(ctx, err)
+		if !errors.Is(err, ErrRateLimited) || !isRetryable(method, f) || attempt >= c.maxRetries() {
+			return err
+		}
+		if !c.spendRetry() {
+			c.logf("stripe: retry budget exhausted; not retrying %s %s", method, path)
+			return err
+		}
+		if wait < 0 {
+			wait = (250 * time.Millisecond) << attempt
+		}
+		c.logf("stripe: rate limited; retrying %s %s in %v", method, path, wait)
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// do performs a single request. If the request was rate limited, wait is the
+// delay requested by Stripe in the Retry-After header, or -1 if none.
+func (c *Client) do(ctx context.Context, key, method, path string, f Form, out any) (wait time.Duration, err error) {
+	ctx, span := trace.Start(ctx, c.Tracer, "stripe "+method, trace.String("stripe.path", path))
+	defer trace.End(span, &err)
+	start, status := time.Now(), 0
+	defer func() {
+		metrics.Since(c.Metrics, "tier_stripe_request_duration_seconds", start,
+			metrics.L("method", method),
+			metrics.L("status", strconv.Itoa(status)),
+		)
+	}()
+
 	urlStr, err := url.JoinPath(c.baseURL(), path)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, urlStr, strings.NewReader(f.Encode()))
 	if err != nil {
-		return err
+		return 0, err
 	}
-	req.SetBasicAuth(c.APIKey, "")
+	req.SetBasicAuth(key, "")
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Stripe-Version", c.apiVersion())
 	if f.idempotencyKey != "" {
 		key := f.idempotencyKey
 		if c.KeyPrefix != "" {
@@ -241,18 +556,39 @@ func (c *Client) Do(ctx context.Context, method, path string, f Form, out any) e
 		}
 		req.Header.Set("Idempotency-Key", key)
 	}
-	if c.AccountID != "" {
-		req.Header.Set("Stripe-Account", c.AccountID)
+	accountID := c.accountID(ctx, f)
+	if accountID != "" {
+		req.Header.Set("Stripe-Account", accountID)
+		span.SetAttributes(trace.String("stripe.account", accountID))
+	}
+
+	release, err := c.acquire(ctx, accountID)
+	if err != nil {
+		return 0, err
 	}
+	defer release()
 
 	resp, err := c.client().Do(req)
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer resp.Body.Close()
+	status = resp.StatusCode
+	span.SetAttributes(
+		trace.Int("http.status_code", resp.StatusCode),
+		trace.String("stripe.request_id", resp.Header.Get("Request-Id")),
+	)
 
 	body := io.Reader(resp.Body)
-	if debugMode {
+	if c.debug() {
+		// Buffer the response so it can be logged whole, after
+		// redaction, before it is decoded.
+		data, err := io.ReadAll(body)
+		if err != nil {
+			return 0, err
+		}
+		body = bytes.NewReader(data)
+
 		requestID := resp.Header.Get("Request-Id")
 		traceID := randomString()
 		w := &trutil.LineWriter{
@@ -260,19 +596,17 @@ func (c *Client) Do(ctx context.Context, method, path string, f Form, out any) e
 			Logf:      c.Logf,
 			AutoFlush: true,
 		}
-		fmt.Fprintf(w, "%s %s\n", method, urlStr)
-		writeIndentedJSON(w, f.v)
+		fmt.Fprintf(w, "%s %s account=%q\n", method, urlStr, accountID)
+		writeIndentedJSON(w, redactForm(f.v))
 
-		c.Logf("STRIPE: -- %s: %s:", traceID, requestID)
+		c.Logf("STRIPE: -- %s: %s: status=%d", traceID, requestID, resp.StatusCode)
 
-		body = io.TeeReader(body, &trutil.LineWriter{
+		w = &trutil.LineWriter{
 			Prefix:    fmt.Sprintf("STRIPE: << %s: %s: ", traceID, requestID),
 			Logf:      c.Logf,
 			AutoFlush: true,
-		})
-		defer func() {
-			io.Copy(io.Discard, body) // flush out any remaining data (e.g. errors or unread body)
-		}()
+		}
+		w.Write(redactJSON(data))
 	}
 
 	if resp.StatusCode/100 != 2 {
@@ -280,35 +614,125 @@ func (c *Client) Do(ctx context.Context, method, path string, f Form, out any) e
 			Error *Error
 		}
 		if err := json.NewDecoder(body).Decode(&e); err != nil {
-			return fmt.Errorf("stripe: error parsing error response: %w", err)
+			return 0, fmt.Errorf("stripe: error parsing error response: %w", err)
 		}
 		err := e.Error
 		if err != nil {
-			err.AccountID = c.AccountID
+			err.AccountID = accountID
 			err.RequestID = resp.Header.Get("Request-Id")
+			err.Status = resp.StatusCode
 			if isInvalidAPIKey(err) {
-				return ErrInvalidAPIKey
+				return 0, ErrInvalidAPIKey
 			}
-			return err
+			return retryAfter(resp.Header), err
 		} else {
-			return fmt.Errorf("stripe: expected error in response: %s", resp.Status)
+			return 0, fmt.Errorf("stripe: expected error in response: %s", resp.Status)
 		}
 	}
 	if out != nil {
-		return json.NewDecoder(body).Decode(out)
+		return 0, json.NewDecoder(body).Decode(out)
 	}
-	return nil
+	return 0, nil
 }
 
 func (c *Client) CloneAs(accountID string) *Client {
 	return &Client{
-		APIKey:     c.APIKey,
-		BaseURL:    c.BaseURL,
-		HTTPClient: c.HTTPClient,
-		AccountID:  accountID,
-		KeyPrefix:  c.KeyPrefix,
-		Logf:       c.Logf,
+		lim:      c.limiter(),
+		brk:      c.breaker(),
+		keys:     c.keyring(),
+		keysFrom: [2]string{c.APIKey, c.SecondaryAPIKey},
+		hc:       c.sharedClient(),
+
+		APIKey:                c.APIKey,
+		SecondaryAPIKey:       c.SecondaryAPIKey,
+		BaseURL:               c.BaseURL,
+		HTTPClient:            c.HTTPClient,
+		AccountID:             accountID,
+		KeyPrefix:             c.KeyPrefix,
+		Logf:                  c.Logf,
+		MaxRetries:            c.MaxRetries,
+		MaxInFlight:           c.MaxInFlight,
+		MaxInFlightPerAccount: c.MaxInFlightPerAccount,
+		AllowLive:             c.AllowLive,
+		APIVersion:            c.APIVersion,
+		Debug:                 c.Debug,
+		Transport:             c.Transport,
+		BreakerThreshold:      c.BreakerThreshold,
+		BreakerCooldown:       c.BreakerCooldown,
+		Tracer:                c.Tracer,
+		Metrics:               c.Metrics,
+	}
+}
+
+func (c *Client) debug() bool {
+	return (c.Debug || debugMode) && c.Logf != nil
+}
+
+// accountID returns the account a request with ctx and f is made on behalf
+// of, if any.
+func (c *Client) accountID(ctx context.Context, f Form) string {
+	if f.account != "" {
+		return f.account
+	}
+	if id := AccountFromContext(ctx); id != "" {
+		return id
+	}
+	return c.AccountID
+}
+
+func (c *Client) apiVersion() string {
+	if c.APIVersion != "" {
+		return c.APIVersion
+	}
+	return APIVersion
+}
+
+// newIdempotencyKey returns a key for a request with method, path, and f. If
+// ctx has a key set using WithIdempotencyKey, the returned key is derived
+// from it and the request; otherwise it is random.
+func (c *Client) newIdempotencyKey(ctx context.Context, method, path string, f Form) string {
+	key := IdempotencyKeyFromContext(ctx)
+	if key == "" {
+		return randomString()
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s", method, path, c.accountID(ctx, f), f.Encode())
+	return key + ":" + hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+func (c *Client) maxRetries() int {
+	if c.MaxRetries == 0 {
+		return defaultMaxRetries
+	}
+	return c.MaxRetries
+}
+
+func (c *Client) logf(format string, args ...any) {
+	if c.Logf != nil {
+		c.Logf(format, args...)
+	}
+}
+
+// isRetryable reports if a request with method and f may be safely sent
+// to Stripe more than once.
+func isRetryable(method string, f Form) bool {
+	return method == "GET" || method == "DELETE" || f.idempotencyKey != ""
+}
+
+// retryAfter returns the delay specified by the Retry-After header in h, or
+// -1 if there is none. The header may be in seconds or an HTTP date.
+func retryAfter(h http.Header) time.Duration {
+	v := h.Get("Retry-After")
+	if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+		return time.Duration(n) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+		return 0
 	}
+	return -1
 }
 
 func writeIndentedJSON(w io.Writer, v any) error {
@@ -2,9 +2,14 @@ package stripe
 
 import (
 	"context"
+	"errors"
 	"net/http"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"kr.dev/diff"
 	"tier.run/fetch/fetchtest"
 )
 
@@ -93,3 +98,374 @@ func TestInvalidAPIKey(t *testing.T) {
 		t.Errorf("got %v; want %v", err, ErrInvalidAPIKey)
 	}
 }
+
+func TestRateLimited(t *testing.T) {
+	var requests, limited int
+	c := newTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+		requests++
+		if requests <= limited {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error": {"code": "rate_limit", "message": "Too many requests"}}`))
+			return
+		}
+		w.Write([]byte(`{}`))
+	})
+
+	ctx := context.Background()
+	check := func(method string, f Form, limit, wantRequests int, wantErr error) {
+		t.Helper()
+		requests, limited = 0, limit
+		err := c.Do(ctx, method, "/", f, nil)
+		if !errors.Is(err, wantErr) {
+			t.Errorf("got %v; want %v", err, wantErr)
+		}
+		if requests != wantRequests {
+			t.Errorf("requests = %d; want %d", requests, wantRequests)
+		}
+	}
+
+	var withKey Form
+	withKey.SetIdempotencyKey("foo")
+
+	check("GET", Form{}, 2, 3, nil)
+	check("POST", withKey, 2, 3, nil)
+	check("POST", Form{}, 2, 3, nil) // given an idempotency key by Do
+	check("GET", Form{}, 100, 4, ErrRateLimited)
+
+	c.MaxRetries = -1
+	check("GET", Form{}, 100, 1, ErrRateLimited)
+}
+
+func TestAccountOverride(t *testing.T) {
+	var got string
+	c := newTestClient(t, func(_ http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Stripe-Account")
+	})
+
+	check := func(ctx context.Context, f Form, want string) {
+		t.Helper()
+		if err := c.Do(ctx, "GET", "/", f, nil); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("Stripe-Account = %q; want %q", got, want)
+		}
+	}
+
+	ctx := context.Background()
+	var withAccount Form
+	withAccount.SetAccount("acct_form")
+
+	check(ctx, Form{}, "")
+	check(ctx, withAccount, "acct_form")
+	check(WithAccount(ctx, "acct_ctx"), Form{}, "acct_ctx")
+	check(WithAccount(ctx, "acct_ctx"), withAccount, "acct_form")
+
+	c.AccountID = "acct_client"
+	check(ctx, Form{}, "acct_client")
+	check(WithAccount(ctx, "acct_ctx"), Form{}, "acct_ctx")
+	check(ctx, withAccount.Clone(), "acct_form")
+}
+
+func TestErrorDecoding(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Request-Id", "req_123")
+		w.WriteHeader(http.StatusPaymentRequired)
+		w.Write([]byte(`{"error": {
+			"type": "card_error",
+			"code": "card_declined",
+			"decline_code": "insufficient_funds",
+			"param": "source",
+			"message": "Your card has insufficient funds.",
+			"doc_url": "https://stripe.com/docs/error-codes/card-declined"
+		}}`))
+	})
+
+	err := c.Do(context.Background(), "POST", "/", Form{}, nil)
+	var got *Error
+	if !errors.As(err, &got) {
+		t.Fatalf("got %T; want *Error", err)
+	}
+	want := &Error{
+		Type:        "card_error",
+		Code:        "card_declined",
+		DeclineCode: "insufficient_funds",
+		Param:       "source",
+		Message:     "Your card has insufficient funds.",
+		DocURL:      "https://stripe.com/docs/error-codes/card-declined",
+		RequestID:   "req_123",
+		Status:      http.StatusPaymentRequired,
+	}
+	if *got != *want {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+
+	if !errors.Is(err, ErrCardDeclined) {
+		t.Errorf("errors.Is(%v, ErrCardDeclined) = false; want true", err)
+	}
+	for _, target := range []error{ErrRateLimited, ErrIdempotency, ErrResourceMissing, ErrPermission, ErrAPI} {
+		if errors.Is(err, target) {
+			t.Errorf("errors.Is(%v, %v) = true; want false", err, target)
+		}
+	}
+}
+
+func TestAPIVersion(t *testing.T) {
+	var got string
+	c := newTestClient(t, func(_ http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Stripe-Version")
+	})
+
+	ctx := context.Background()
+	if err := c.Do(ctx, "GET", "/", Form{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got != APIVersion {
+		t.Errorf("Stripe-Version = %q; want %q", got, APIVersion)
+	}
+
+	c.APIVersion = "2020-08-27"
+	if err := c.CloneAs("acct_123").Do(ctx, "GET", "/", Form{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got != "2020-08-27" {
+		t.Errorf("Stripe-Version = %q; want %q", got, "2020-08-27")
+	}
+}
+
+func TestAutoIdempotencyKey(t *testing.T) {
+	var got string
+	c := newTestClient(t, func(_ http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Idempotency-Key")
+	})
+
+	do := func(ctx context.Context, method, path string, f Form) string {
+		t.Helper()
+		if err := c.Do(ctx, method, path, f, nil); err != nil {
+			t.Fatal(err)
+		}
+		return got
+	}
+
+	ctx := context.Background()
+	if key := do(ctx, "GET", "/", Form{}); key != "" {
+		t.Errorf("GET Idempotency-Key = %q; want none", key)
+	}
+	a, b := do(ctx, "POST", "/", Form{}), do(ctx, "POST", "/", Form{})
+	if a == "" || a == b {
+		t.Errorf("random keys = %q, %q; want distinct, non-empty", a, b)
+	}
+	if key := do(ctx, "DELETE", "/", Form{}); key == "" {
+		t.Error("DELETE Idempotency-Key missing")
+	}
+
+	var withKey Form
+	withKey.SetIdempotencyKey("foo")
+	if key := do(WithIdempotencyKey(ctx, "ctx"), "POST", "/", withKey); key != "foo" {
+		t.Errorf("Idempotency-Key = %q; want %q", key, "foo")
+	}
+
+	var f1, f2 Form
+	f1.Set("a", 1)
+	f2.Set("a", 2)
+	kctx := WithIdempotencyKey(ctx, "op")
+	k1, k1again, k2 := do(kctx, "POST", "/", f1), do(kctx, "POST", "/", f1), do(kctx, "POST", "/", f2)
+	if !strings.HasPrefix(k1, "op:") {
+		t.Errorf("derived key = %q; want prefix %q", k1, "op:")
+	}
+	if k1 != k1again {
+		t.Errorf("derived keys differ for identical requests: %q, %q", k1, k1again)
+	}
+	if k1 == k2 {
+		t.Errorf("derived keys equal for different requests: %q", k1)
+	}
+}
+
+func TestLiveModeGuard(t *testing.T) {
+	var requests int
+	c := newTestClient(t, func(_ http.ResponseWriter, r *http.Request) {
+		requests++
+	})
+	c.APIKey = "sk_live_123"
+
+	ctx := context.Background()
+	if err := c.Do(ctx, "DELETE", "/v1/products/foo", Form{}, nil); err != ErrLiveModeGuard {
+		t.Errorf("got %v; want %v", err, ErrLiveModeGuard)
+	}
+	if err := c.Do(ctx, "GET", "/v1/products/foo", Form{}, nil); err != nil {
+		t.Errorf("GET: unexpected error: %v", err)
+	}
+
+	c.AllowLive = true
+	if err := c.CloneAs("acct_123").Do(ctx, "DELETE", "/v1/products/foo", Form{}, nil); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if requests != 2 {
+		t.Errorf("requests = %d; want 2", requests)
+	}
+
+	if err := c.SetKey("sk_test_123", ""); err != nil {
+		t.Fatal(err)
+	}
+	c.AllowLive = false
+	if err := c.GuardLive(); err != nil {
+		t.Errorf("GuardLive with test key: %v", err)
+	}
+}
+
+func TestMaxInFlight(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, peak int
+	h := func(_ http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > peak {
+			peak = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+	}
+
+	check := func(c *Client, accounts []string, want int) {
+		t.Helper()
+		peak = 0
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			for _, id := range accounts {
+				wg.Add(1)
+				go func(c *Client) {
+					defer wg.Done()
+					if err := c.Do(context.Background(), "GET", "/", Form{}, nil); err != nil {
+						t.Error(err)
+					}
+				}(c.CloneAs(id))
+			}
+		}
+		wg.Wait()
+		if peak > want {
+			t.Errorf("peak in-flight requests = %d; want at most %d", peak, want)
+		}
+	}
+
+	c := newTestClient(t, h)
+	c.MaxInFlightPerAccount = 2
+	check(c, []string{"acct_1"}, 2)
+	check(c, []string{"acct_1", "acct_2"}, 4)
+
+	c = newTestClient(t, h)
+	c.MaxInFlightPerAccount = 2
+	c.MaxInFlight = 3
+	check(c, []string{"acct_1", "acct_2"}, 3)
+}
+
+func TestAcquireCanceled(t *testing.T) {
+	c := &Client{MaxInFlightPerAccount: 1}
+	release, err := c.acquire(context.Background(), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := c.acquire(ctx, ""); err != context.Canceled {
+		t.Errorf("got %v; want %v", err, context.Canceled)
+	}
+	release()
+}
+
+func TestExpand(t *testing.T) {
+	var f Form
+	f.Expand("data.tiers", "data.phases.items.price")
+	f.Expand("data.tiers")
+	got := f.v["expand[]"]
+	want := []string{"data.tiers", "data.phases.items.price"}
+	diff.Test(t, t.Errorf, got, want)
+
+	for _, path := range []string{"", "data..tiers", ".tiers", "data.", "data.a.b.c.d"} {
+		t.Run(path, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expand(%q) did not panic", path)
+				}
+			}()
+			var f Form
+			f.Expand(path)
+		})
+	}
+}
+
+func TestSecondaryAPIKey(t *testing.T) {
+	var keys []string
+	h := func(w http.ResponseWriter, r *http.Request) {
+		key, _, _ := r.BasicAuth()
+		keys = append(keys, key)
+		if key == "sk_test_old" || key == "sk_test_new" {
+			w.Write([]byte(`{}`))
+			return
+		}
+		w.WriteHeader(401)
+		w.Write([]byte(`{"error": {"message": "Invalid API Key provided: sk_test_***"}}`))
+	}
+	c := newTestClient(t, h)
+	c.APIKey = "sk_test_bad"
+	c.SecondaryAPIKey = "sk_test_old"
+
+	ctx := context.Background()
+	check := func(c *Client, wantKeys []string, wantErr error) {
+		t.Helper()
+		keys = nil
+		if err := c.Do(ctx, "GET", "/", Form{}, nil); !errors.Is(err, wantErr) {
+			t.Errorf("err = %v; want %v", err, wantErr)
+		}
+		diff.Test(t, t.Errorf, keys, wantKeys)
+	}
+
+	check(c, []string{"sk_test_bad", "sk_test_old"}, nil)
+	check(c, []string{"sk_test_old"}, nil) // promoted
+
+	// Clones share keys.
+	clone := c.CloneAs("acct_123")
+	if err := c.SetKey("sk_test_new", ""); err != nil {
+		t.Fatal(err)
+	}
+	check(clone, []string{"sk_test_new"}, nil)
+
+	if err := c.SetKey("sk_test_revoked", ""); err != nil {
+		t.Fatal(err)
+	}
+	check(c, []string{"sk_test_revoked"}, ErrInvalidAPIKey)
+
+	if err := c.SetKey("sk_live_new", "sk_test_old"); err == nil {
+		t.Error("SetKey with mixed modes: err = nil; want error")
+	}
+	if err := c.SetKey("", "sk_test_old"); err == nil {
+		t.Error("SetKey without primary: err = nil; want error")
+	}
+	if c.Live() {
+		t.Error("Live() = true after failed SetKey")
+	}
+	if err := c.SetKey("sk_live_new", ""); err != nil {
+		t.Fatal(err)
+	}
+	if !c.Live() {
+		t.Error("Live() = false; want true")
+	}
+
+	// Writes to APIKey once c is in use are not ignored, and reach
+	// clones.
+	c.APIKey = "sk_test_old"
+	check(c, []string{"sk_test_old"}, nil)
+	check(clone, []string{"sk_test_old"}, nil)
+
+	// Clones made before c is used share its keys.
+	c2 := newTestClient(t, h)
+	c2.APIKey = "sk_test_bad"
+	clone2 := c2.CloneAs("acct_123")
+	if err := c2.SetKey("sk_test_new", ""); err != nil {
+		t.Fatal(err)
+	}
+	check(clone2, []string{"sk_test_new"}, nil)
+}
@@ -0,0 +1,120 @@
+package stripe
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrClockFailed is returned by WaitClock when Stripe fails to advance a
+// test clock.
+var ErrClockFailed = errors.New("stripe: test clock failed to advance")
+
+// Clock is a Stripe test clock.
+//
+// See https://stripe.com/docs/api/test_clocks
+type Clock struct {
+	ID      string
+	Name    string
+	Present time.Time // the frozen time of the clock
+	Status  string    // "ready", "advancing", or "internal_failure"
+}
+
+// Ready reports whether the clock has finished advancing.
+func (c Clock) Ready() bool { return c.Status == "ready" }
+
+type stripeClock struct {
+	ID
+	Name       string
+	Status     string
+	FrozenTime int64 `json:"frozen_time"`
+}
+
+func (c stripeClock) clock() Clock {
+	return Clock{
+		ID:      c.ProviderID(),
+		Name:    c.Name,
+		Present: time.Unix(c.FrozenTime, 0).UTC(),
+		Status:  c.Status,
+	}
+}
+
+const clocksPath = "/v1/test_helpers/test_clocks"
+
+// CreateClock creates a new test clock frozen at start.
+func (c *Client) CreateClock(ctx context.Context, name string, start time.Time) (Clock, error) {
+	var f Form
+	f.Set("name", name)
+	f.Set("frozen_time", start)
+	return c.doClock(ctx, "POST", clocksPath, f)
+}
+
+// AdvanceClock moves the test clock with the provided id forward to t.
+// Advancing is asynchronous in Stripe; use WaitClock to wait for the clock
+// to become ready.
+func (c *Client) AdvanceClock(ctx context.Context, id string, t time.Time) (Clock, error) {
+	var f Form
+	f.Set("frozen_time", t)
+	return c.doClock(ctx, "POST", clocksPath+"/"+id+"/advance", f)
+}
+
+// RetrieveClock retrieves the current state of the test clock with the
+// provided id.
+func (c *Client) RetrieveClock(ctx context.Context, id string) (Clock, error) {
+	return c.doClock(ctx, "GET", clocksPath+"/"+id, Form{})
+}
+
+// WaitClock polls the test clock with the provided id until it has finished
+// advancing, or ctx is done. It returns ErrClockFailed if Stripe reports the
+// clock failed to advance.
+func (c *Client) WaitClock(ctx context.Context, id string) (Clock, error) {
+	wait := 250 * time.Millisecond
+	for {
+		cl, err := c.RetrieveClock(ctx, id)
+		if err != nil {
+			return Clock{}, err
+		}
+		switch cl.Status {
+		case "ready":
+			return cl, nil
+		case "internal_failure":
+			return cl, ErrClockFailed
+		}
+		c.logf("stripe: clock %s: %s; checking again in %v", id, cl.Status, wait)
+		t := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return Clock{}, ctx.Err()
+		case <-t.C:
+		}
+		wait = min(wait*2, 5*time.Second)
+	}
+}
+
+// ListClocks returns the test clocks of the account, newest first.
+func (c *Client) ListClocks(ctx context.Context) ([]Clock, error) {
+	var cs []Clock
+	err := Iter(ctx, c, "GET", clocksPath, Form{}, func(v stripeClock) bool {
+		cs = append(cs, v.clock())
+		return true
+	})
+	return cs, err
+}
+
+// DeleteClock deletes the test clock with the provided id, along with the
+// customers and subscriptions attached to it.
+//
+// There is usually no need to delete clocks made in isolated test accounts,
+// since deleting the account deletes its clocks.
+func (c *Client) DeleteClock(ctx context.Context, id string) error {
+	return c.Do(ctx, "DELETE", clocksPath+"/"+id, Form{}, nil)
+}
+
+func (c *Client) doClock(ctx context.Context, method, path string, f Form) (Clock, error) {
+	var v stripeClock
+	if err := c.Do(ctx, method, path, f, &v); err != nil {
+		return Clock{}, err
+	}
+	return v.clock(), nil
+}
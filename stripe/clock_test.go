@@ -0,0 +1,84 @@
+package stripe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+)
+
+func TestClock(t *testing.T) {
+	var polls int
+	status := "advancing"
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method + " " + r.URL.Path {
+		case "POST /v1/test_helpers/test_clocks":
+			if err := r.ParseForm(); err != nil {
+				t.Fatal(err)
+			}
+			fmt.Fprintf(w, `{"id": "clock_1", "name": %q, "status": "ready", "frozen_time": %s}`,
+				r.Form.Get("name"), r.Form.Get("frozen_time"))
+		case "POST /v1/test_helpers/test_clocks/clock_1/advance":
+			polls = 0
+			w.Write([]byte(`{"id": "clock_1", "status": "advancing", "frozen_time": 10}`))
+		case "GET /v1/test_helpers/test_clocks/clock_1":
+			polls++
+			s := status
+			if polls < 2 {
+				s = "advancing"
+			}
+			fmt.Fprintf(w, `{"id": "clock_1", "status": %q, "frozen_time": 20}`, s)
+		case "DELETE /v1/test_helpers/test_clocks/clock_1":
+			w.Write([]byte(`{"id": "clock_1", "deleted": true}`))
+		default:
+			w.WriteHeader(404)
+			w.Write([]byte(`{"error": {"code": "resource_missing"}}`))
+		}
+	})
+
+	ctx := context.Background()
+	cl, err := c.CreateClock(ctx, "test", time.Unix(10, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, cl, Clock{
+		ID:      "clock_1",
+		Name:    "test",
+		Present: time.Unix(10, 0).UTC(),
+		Status:  "ready",
+	})
+
+	cl, err = c.AdvanceClock(ctx, cl.ID, time.Unix(20, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.Ready() {
+		t.Error("clock ready before wait")
+	}
+
+	status = "ready"
+	cl, err = c.WaitClock(ctx, cl.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cl.Ready() || polls != 2 {
+		t.Errorf("status = %q after %d polls; want ready after 2", cl.Status, polls)
+	}
+	diff.Test(t, t.Errorf, cl.Present, time.Unix(20, 0).UTC())
+
+	status, polls = "internal_failure", 1
+	if _, err := c.WaitClock(ctx, cl.ID); !errors.Is(err, ErrClockFailed) {
+		t.Errorf("err = %v; want %v", err, ErrClockFailed)
+	}
+
+	if err := c.DeleteClock(ctx, cl.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.RetrieveClock(ctx, "clock_2"); !errors.Is(err, ErrResourceMissing) {
+		t.Errorf("err = %v; want %v", err, ErrResourceMissing)
+	}
+}
@@ -0,0 +1,169 @@
+package stripe
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// EncodeStruct sets a form value in f for each field in the struct v, or
+// pointer to a struct, flattening nested values into Stripe's bracketed
+// form encoding. See SetJSON for the encoding rules.
+func (f *Form) EncodeStruct(v any) error {
+	rv := indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("stripe: EncodeStruct: expected struct; got %T", v)
+	}
+	return f.encode("", rv)
+}
+
+// SetJSON sets the value v in f under key, flattening nested values into
+// Stripe's bracketed form encoding.
+//
+// Struct fields are named using their json tags, and follow the same rules
+// as encoding/json: fields tagged "-" and unexported fields are skipped,
+// untagged fields use the field name, and embedded structs are flattened
+// into their parent. Fields tagged "omitempty" are skipped if they hold
+// the zero value for their type, or are empty maps or slices.
+//
+// Maps are encoded using their keys, and slices and arrays using their
+// indexes. Nil pointers and interfaces are skipped. Values implementing
+// encoding.TextMarshaler are encoded using MarshalText, time.Time values are
+// encoded as unix time, and floats are encoded without exponents.
+//
+// Example mapping:
+//
+//	type Tier struct {
+//		UpTo  any     `json:"up_to"`
+//		Price float64 `json:"unit_amount_decimal"`
+//	}
+//	f.SetJSON("tiers", []Tier{{UpTo: 10, Price: 0.5}}) // => "tiers[0][up_to]=10&tiers[0][unit_amount_decimal]=0.5"
+func (f *Form) SetJSON(key string, v any) error {
+	return f.encode(key, reflect.ValueOf(v))
+}
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func (f *Form) encode(key string, v reflect.Value) error {
+	v = indirect(v)
+	if !v.IsValid() {
+		return nil
+	}
+
+	if v.Type() == timeType {
+		f.Set(key, v.Interface().(time.Time).Unix())
+		return nil
+	}
+	if v.Type().Implements(textMarshalerType) {
+		b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err
+		}
+		f.Set(key, string(b))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		return f.encodeStruct(key, v)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			return fmt.Errorf("stripe: unsupported map key type %s for %q", v.Type().Key(), key)
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			if err := f.encode(subKey(key, iter.Key().String()), iter.Value()); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := f.encode(subKey(key, strconv.Itoa(i)), v.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	case reflect.Float32, reflect.Float64:
+		f.Set(key, strconv.FormatFloat(v.Float(), 'f', -1, v.Type().Bits()))
+		return nil
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if key == "" {
+			return fmt.Errorf("stripe: cannot encode %s without a key", v.Type())
+		}
+		f.Set(key, v.Interface())
+		return nil
+	default:
+		return fmt.Errorf("stripe: unsupported type %s for %q", v.Type(), key)
+	}
+}
+
+func (f *Form) encodeStruct(key string, v reflect.Value) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() && !sf.Anonymous {
+			continue
+		}
+		tag := sf.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		fv := v.Field(i)
+		if slices.Contains(strings.Split(opts, ","), "omitempty") && isEmpty(fv) {
+			continue
+		}
+		if sf.Anonymous && name == "" && indirect(fv).Kind() == reflect.Struct {
+			if err := f.encode(key, fv); err != nil {
+				return err
+			}
+			continue
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = sf.Name
+		}
+		if err := f.encode(subKey(key, name), fv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func subKey(key, name string) string {
+	if key == "" {
+		return name
+	}
+	return key + "[" + name + "]"
+}
+
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice:
+		return v.Len() == 0
+	}
+	return v.IsZero()
+}
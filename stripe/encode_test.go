@@ -0,0 +1,100 @@
+package stripe
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+)
+
+type testEmbedded struct {
+	Embedded string `json:"embedded"`
+}
+
+type testParams struct {
+	ID
+	testEmbedded
+
+	Name     string            `json:"name"`
+	Skip     string            `json:"-"`
+	Omit     string            `json:"omit,omitempty"`
+	Active   bool              `json:"active"`
+	Price    float64           `json:"price"`
+	Count    *int              `json:"count,omitempty"`
+	Limit    int               `json:"limit,string,omitempty"`
+	Start    time.Time         `json:"start"`
+	Metadata map[string]string `json:"metadata"`
+	Tiers    []struct {
+		UpTo any `json:"up_to"`
+	} `json:"tiers"`
+	Untagged int
+
+	unexported string
+}
+
+func TestEncodeStruct(t *testing.T) {
+	p := testParams{
+		ID:           "id_123",
+		testEmbedded: testEmbedded{"e"},
+		Name:         "n",
+		Skip:         "x",
+		Price:        0.00001,
+		Start:        time.Unix(10, 0),
+		Metadata:     map[string]string{"a": "1", "b.c": "2"},
+		Untagged:     7,
+		unexported:   "x",
+	}
+	p.Tiers = append(p.Tiers, struct {
+		UpTo any `json:"up_to"`
+	}{10}, struct {
+		UpTo any `json:"up_to"`
+	}{"inf"})
+
+	var f Form
+	if err := f.EncodeStruct(&p); err != nil {
+		t.Fatal(err)
+	}
+	want := url.Values{
+		"ID":              {"id_123"},
+		"embedded":        {"e"},
+		"name":            {"n"},
+		"active":          {"false"},
+		"price":           {"0.00001"},
+		"start":           {"10"},
+		"metadata[a]":     {"1"},
+		"metadata[b.c]":   {"2"},
+		"tiers[0][up_to]": {"10"},
+		"tiers[1][up_to]": {"inf"},
+		"Untagged":        {"7"},
+	}
+	diff.Test(t, t.Errorf, f.v, want)
+
+	if err := f.EncodeStruct("foo"); err == nil {
+		t.Error("expected error encoding non-struct")
+	}
+}
+
+func TestSetJSON(t *testing.T) {
+	var f Form
+	if err := f.SetJSON("items", []map[string]any{
+		{"price": "price_1", "quantity": 2},
+		{"price": "price_2", "deleted": true, "missing": nil},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	want := url.Values{
+		"items[0][price]":    {"price_1"},
+		"items[0][quantity]": {"2"},
+		"items[1][price]":    {"price_2"},
+		"items[1][deleted]":  {"true"},
+	}
+	diff.Test(t, t.Errorf, f.v, want)
+
+	if err := f.SetJSON("bad", map[int]string{1: "x"}); err == nil {
+		t.Error("expected error for non-string map keys")
+	}
+	if err := f.SetJSON("", 1); err == nil {
+		t.Error("expected error for value without key")
+	}
+}
@@ -0,0 +1,93 @@
+package stripe
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// keyring holds the API keys used by a Client and its clones.
+type keyring struct {
+	mu        sync.RWMutex
+	primary   string
+	secondary string
+}
+
+// keyring returns the keyring shared by c and its clones, creating it from
+// APIKey and SecondaryAPIKey if needed. If APIKey or SecondaryAPIKey was
+// written since the keyring took them, the keyring takes them again, as
+// SetKey would, so that writes to them are not silently ignored.
+func (c *Client) keyring() *keyring {
+	c.limMu.Lock()
+	defer c.limMu.Unlock()
+	fields := [2]string{c.APIKey, c.SecondaryAPIKey}
+	if c.keys == nil {
+		c.keys = &keyring{primary: c.APIKey, secondary: c.SecondaryAPIKey}
+		c.keysFrom = fields
+	}
+	if c.keysFrom != fields {
+		c.keys.mu.Lock()
+		c.keys.primary, c.keys.secondary = c.APIKey, c.SecondaryAPIKey
+		c.keys.mu.Unlock()
+		c.keysFrom = fields
+	}
+	return c.keys
+}
+
+// apiKey returns the key requests are currently made with.
+func (c *Client) apiKey() string {
+	k := c.keyring()
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.primary
+}
+
+// SetKey replaces the keys used by c and its clones for all future
+// requests. The secondary key is optional, and is used if Stripe rejects the
+// primary as invalid, so that a new key can be rolled out before the old one
+// is expired. SetKey is safe for concurrent use.
+//
+// It returns an error if primary is empty, or the keys are not both live or
+// both test mode keys.
+func (c *Client) SetKey(primary, secondary string) error {
+	if primary == "" {
+		return errors.New("stripe: SetKey: missing primary key")
+	}
+	if secondary != "" && IsLiveKey(primary) != IsLiveKey(secondary) {
+		return errors.New("stripe: SetKey: primary and secondary keys must both be live or test mode keys")
+	}
+	k := c.keyring()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.primary, k.secondary = primary, secondary
+	return nil
+}
+
+// fallback makes the secondary key primary if key, which Stripe rejected as
+// invalid, is still the primary key. It reports whether a request rejected
+// using key should be retried with the new primary key.
+func (k *keyring) fallback(key string) bool {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.primary != key {
+		return true // changed since the request was made
+	}
+	if k.secondary == "" {
+		return false
+	}
+	k.primary, k.secondary = k.secondary, ""
+	return true
+}
+
+// doWithKey calls do with the primary key, and if Stripe rejects it as
+// invalid, retries once with the secondary key, if any.
+func (c *Client) doWithKey(ctx context.Context, method, path string, f Form, out any) (wait time.Duration, err error) {
+	key := c.apiKey()
+	wait, err = c.do(ctx, key, method, path, f, out)
+	if errors.Is(err, ErrInvalidAPIKey) && c.keyring().fallback(key) {
+		c.logf("stripe: API key rejected as invalid; retrying with secondary key")
+		return c.do(ctx, c.apiKey(), method, path, f, out)
+	}
+	return wait, err
+}
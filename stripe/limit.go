@@ -0,0 +1,89 @@
+package stripe
+
+import (
+	"context"
+	"sync"
+)
+
+// Default limits on in-flight requests per account; a little under the
+// concurrency limits Stripe applies in each mode.
+const (
+	defaultMaxInFlightPerAccount     = 20
+	defaultMaxInFlightPerAccountLive = 50
+)
+
+// limiter limits the number of in-flight requests made by a Client and its
+// clones, in total and per account.
+type limiter struct {
+	mu       sync.Mutex
+	total    chan struct{} // nil if unlimited
+	accounts map[string]chan struct{}
+}
+
+// limiter returns the limiter shared by c and its clones, creating it if
+// needed.
+func (c *Client) limiter() *limiter {
+	c.limMu.Lock()
+	defer c.limMu.Unlock()
+	if c.lim == nil {
+		c.lim = &limiter{}
+		if c.MaxInFlight > 0 {
+			c.lim.total = make(chan struct{}, c.MaxInFlight)
+		}
+	}
+	return c.lim
+}
+
+func (c *Client) maxInFlightPerAccount() int {
+	if c.MaxInFlightPerAccount == 0 {
+		if c.Live() {
+			return defaultMaxInFlightPerAccountLive
+		}
+		return defaultMaxInFlightPerAccount
+	}
+	return c.MaxInFlightPerAccount
+}
+
+// acquire blocks until a request may be made on behalf of accountID, or ctx
+// is done. If it returns a nil error, the caller must call the returned
+// release func once the request is complete.
+func (c *Client) acquire(ctx context.Context, accountID string) (release func(), err error) {
+	l := c.limiter()
+
+	// Wait for the account before the total, so that requests for a busy
+	// account do not hold slots needed by requests for other accounts.
+	var sems []chan struct{}
+	if n := c.maxInFlightPerAccount(); n > 0 {
+		l.mu.Lock()
+		if l.accounts == nil {
+			l.accounts = map[string]chan struct{}{}
+		}
+		sem := l.accounts[accountID]
+		if sem == nil {
+			sem = make(chan struct{}, n)
+			l.accounts[accountID] = sem
+		}
+		l.mu.Unlock()
+		sems = append(sems, sem)
+	}
+	if l.total != nil {
+		sems = append(sems, l.total)
+	}
+
+	release = func() {
+		for _, sem := range sems {
+			<-sem
+		}
+	}
+	for i, sem := range sems {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			for _, sem := range sems[:i] {
+				<-sem
+			}
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
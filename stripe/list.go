@@ -3,6 +3,7 @@ package stripe
 import (
 	"context"
 	"errors"
+	"strings"
 )
 
 var ErrNotFound = errors.New("stripe: not found")
@@ -107,21 +108,91 @@ func (i *Iterator[I]) refill() error {
 	return nil
 }
 
-// Slurp returns each I over all pages ln a list, or an error if any.
+// Iter calls yield for each I over all pages in a list. Pages are fetched
+// lazily, only as yield consumes the items before them. If yield returns
+// false, iteration stops without fetching any further pages.
+//
+// It returns the first error encountered, if any.
+func Iter[I Identifiable](ctx context.Context, c *Client, method, path string, f Form, yield func(I) bool) error {
+	l := List[I](ctx, c, method, path, f)
+	for l.Next() {
+		if !yield(l.Value()) {
+			return nil
+		}
+	}
+	return l.Err()
+}
+
+// Pages calls fn with each page of I in a list, fetching the next page
+// only after fn returns. If fn returns an error, iteration stops and Pages
+// returns that error. Pages holds no more than one page in memory at a time,
+// unless fn retains them.
+//
+// It returns the first error encountered, if any.
+func Pages[I Identifiable](ctx context.Context, c *Client, method, path string, f Form, fn func(page []I) error) error {
+	l := List[I](ctx, c, method, path, f)
+	for l.hasMore {
+		if err := l.refill(); err != nil {
+			return err
+		}
+		if len(l.data) == 0 {
+			return nil
+		}
+		if err := fn(l.data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Search calls yield for each I matching query, in Stripe's search query
+// language, over all pages of the results of the search API at path, such
+// as "/v1/customers/search". Pages are fetched lazily, as Iter fetches
+// them. Stripe's search results lag behind changes by up to a minute.
+//
+// It returns the first error encountered, if any.
+func Search[I Identifiable](ctx context.Context, c *Client, path, query string, f Form, yield func(I) bool) error {
+	f = f.Clone()
+	f.Set("query", query)
+	f.Set("limit", 100)
+	for {
+		var t struct {
+			HasMore  bool   `json:"has_more"`
+			NextPage string `json:"next_page"`
+			Data     []I
+		}
+		if err := c.Do(ctx, "GET", path, f, &t); err != nil {
+			return err
+		}
+		for _, v := range t.Data {
+			if !yield(v) {
+				return nil
+			}
+		}
+		if !t.HasMore || t.NextPage == "" {
+			return nil
+		}
+		f.Set("page", t.NextPage)
+	}
+}
+
+// QuoteSearch returns s quoted as a string in Stripe's search query
+// language.
+func QuoteSearch(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(s) + "'"
+}
+
+// Slurp returns each I over all pages ln a list, or an error if any. If
+// fetching a page fails, such as when ctx is canceled, Slurp returns the
+// items of the pages fetched before it along with the error.
 func Slurp[I Identifiable](ctx context.Context, c *Client, method, path string, f Form) ([]I, error) {
 	// TODO(bmizerany): respect some rate-limiter (maybe in c?)
 	f.Set("limit", 100)
 
-	// TODO(bmizerany): grow slice as needed? currently it grows by one per
-	// thing. that could be a lot of allocs.
-
 	var tt []I
-	l := List[I](ctx, c, method, path, f)
-	for l.Next() {
-		tt = append(tt, l.Value())
-	}
-	if err := l.Err(); err != nil {
-		return nil, err
-	}
-	return tt, nil
+	err := Pages(ctx, c, method, path, f, func(page []I) error {
+		tt = append(tt, page...)
+		return nil
+	})
+	return tt, err
 }
@@ -1,8 +1,11 @@
 package stripe
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"strings"
@@ -147,3 +150,105 @@ func testList(t *testing.T, in string, wantOffsets, wantIDs []string) {
 		diff.Test(t, t.Errorf, gotIDs, wantIDs)
 	})
 }
+
+func TestIterStopsEarly(t *testing.T) {
+	var requests int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		io.WriteString(w, `{"has_more": true, "data": ["1", "2"]}`)
+	})
+
+	ctx := context.Background()
+	var got []string
+	err := Iter(ctx, c, "GET", "/test", Form{}, func(id ID) bool {
+		got = append(got, string(id))
+		return len(got) < 3
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, []string{"1", "2", "1"})
+	if requests != 2 {
+		t.Errorf("requests = %d; want 2", requests)
+	}
+}
+
+func TestPages(t *testing.T) {
+	var requests int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			io.WriteString(w, `{"has_more": true, "data": ["1", "2"]}`)
+		} else {
+			io.WriteString(w, `{"has_more": false, "data": ["3"]}`)
+		}
+	})
+
+	ctx := context.Background()
+	var got [][]ID
+	err := Pages(ctx, c, "GET", "/test", Form{}, func(page []ID) error {
+		got = append(got, page)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, got, [][]ID{{"1", "2"}, {"1", "2"}, {"3"}})
+
+	requests = 0
+	errStop := errors.New("stop")
+	err = Pages(ctx, c, "GET", "/test", Form{}, func(page []ID) error {
+		return errStop
+	})
+	if err != errStop {
+		t.Errorf("got %v; want %v", err, errStop)
+	}
+	if requests != 1 {
+		t.Errorf("requests = %d; want 1", requests)
+	}
+}
+
+func TestSlurpCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var requests int
+	c := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		io.WriteString(w, `{"has_more": true, "data": ["1", "2"]}`)
+	})
+	hc := *c.HTTPClient
+	hc.Transport = cancelAfter{hc.Transport, cancel}
+	c.HTTPClient = &hc
+
+	got, err := Slurp[ID](ctx, c, "GET", "/test", Form{})
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v; want %v", err, context.Canceled)
+	}
+	diff.Test(t, t.Errorf, got, []ID{"1", "2"})
+	if requests != 1 {
+		t.Errorf("requests = %d; want 1", requests)
+	}
+}
+
+// cancelAfter is a RoundTripper that calls cancel after each response is
+// read in full.
+type cancelAfter struct {
+	rt     http.RoundTripper
+	cancel func()
+}
+
+func (c cancelAfter) RoundTrip(r *http.Request) (*http.Response, error) {
+	res, err := c.rt.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+	c.cancel()
+	return res, nil
+}
@@ -0,0 +1,106 @@
+package stripe
+
+import (
+	"encoding/json"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// sensitiveKeys are the names of form fields and JSON object keys whose values
+// are never logged. Form keys are matched by their final bracketed segment
+// (e.g. "card[number]" matches "number").
+var sensitiveKeys = map[string]bool{
+	"account_number": true,
+	"address":        true,
+	"bank_account":   true,
+	"card":           true,
+	"client_secret":  true,
+	"cvc":            true,
+	"email":          true,
+	"exp_month":      true,
+	"exp_year":       true,
+	"fingerprint":    true,
+	"iban":           true,
+	"last4":          true,
+	"name":           true,
+	"number":         true,
+	"phone":          true,
+	"routing_number": true,
+	"secret":         true,
+	"shipping":       true,
+	"tax_id":         true,
+}
+
+var (
+	apiKeyPattern = regexp.MustCompile(`\b(sk|rk|pk)_(test|live)_[0-9a-zA-Z]+`)
+	emailPattern  = regexp.MustCompile(`[^\s@"'<>]+@[^\s@"'<>]+\.[a-zA-Z]{2,}`)
+)
+
+// redactString replaces API keys and email addresses in s.
+func redactString(s string) string {
+	s = apiKeyPattern.ReplaceAllString(s, redacted)
+	return emailPattern.ReplaceAllString(s, redacted)
+}
+
+func isSensitiveFormKey(key string) bool {
+	if i := strings.LastIndexByte(key, '['); i >= 0 {
+		key = strings.TrimSuffix(key[i+1:], "]")
+	}
+	return sensitiveKeys[key]
+}
+
+// redactForm returns a copy of v with sensitive values replaced.
+func redactForm(v url.Values) url.Values {
+	out := make(url.Values, len(v))
+	for k, vv := range v {
+		if isSensitiveFormKey(k) {
+			out[k] = []string{redacted}
+			continue
+		}
+		for _, s := range vv {
+			out[k] = append(out[k], redactString(s))
+		}
+	}
+	return out
+}
+
+// redactJSON returns data with the values of sensitive keys replaced, and
+// any API keys or email addresses in the remaining strings replaced. If data
+// is not valid JSON, it is redacted as a string.
+func redactJSON(data []byte) []byte {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return []byte(redactString(string(data)))
+	}
+	b, err := json.MarshalIndent(redactValue(v), "", "  ")
+	if err != nil {
+		return []byte(redacted)
+	}
+	return b
+}
+
+func redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			if sensitiveKeys[k] && e != nil {
+				v[k] = redacted
+			} else {
+				v[k] = redactValue(e)
+			}
+		}
+		return v
+	case []any:
+		for i, e := range v {
+			v[i] = redactValue(e)
+		}
+		return v
+	case string:
+		return redactString(v)
+	default:
+		return v
+	}
+}
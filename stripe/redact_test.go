@@ -0,0 +1,63 @@
+package stripe
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestRedactString(t *testing.T) {
+	cases := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"nothing to see", "nothing to see"},
+		{"key sk_test_abc123 used", "key [REDACTED] used"},
+		{"rk_live_XyZ", "[REDACTED]"},
+		{"contact a.b+c@example.com now", "contact [REDACTED] now"},
+	}
+	for _, tc := range cases {
+		if got := redactString(tc.in); got != tc.want {
+			t.Errorf("redactString(%q) = %q; want %q", tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestDebugLogRedacted(t *testing.T) {
+	c := newTestClient(t, func(w http.ResponseWriter, _ *http.Request) {
+		w.Write([]byte(`{
+			"id": "cus_123",
+			"email": "secret@example.com",
+			"sources": {"data": [{"last4": "4242", "note": "sk_live_abc"}]}
+		}`))
+	})
+
+	var logs strings.Builder
+	c.Debug = true
+	c.Logf = func(format string, args ...any) {
+		fmt.Fprintf(&logs, format+"\n", args...)
+	}
+
+	var f Form
+	f.Set("email", "x@example.com")
+	f.Set("card", "number", "4242424242424242")
+	f.Set("metadata", "tier.org", "org:test")
+	if err := c.Do(context.Background(), "POST", "/v1/customers", f, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	got := logs.String()
+	t.Log(got)
+	for _, leaked := range []string{"x@example.com", "4242", "secret@example.com", "sk_live_abc"} {
+		if strings.Contains(got, leaked) {
+			t.Errorf("log contains %q", leaked)
+		}
+	}
+	for _, want := range []string{"cus_123", "org:test", "/v1/customers"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("log missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,2076 @@
+package stripefake
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/maps"
+	"golang.org/x/exp/slices"
+	"tier.run/stripe"
+)
+
+type clock struct {
+	id     string
+	name   string
+	frozen int64
+}
+
+func (c *clock) render() map[string]any {
+	return map[string]any{
+		"id":          c.id,
+		"object":      "test_helpers.test_clock",
+		"name":        c.name,
+		"frozen_time": c.frozen,
+		"status":      "ready", // advancing is instant
+	}
+}
+
+func (s *Server) createClock(a *account, f url.Values) (any, error) {
+	frozen, err := formInt(f, "frozen_time")
+	if err != nil {
+		return nil, err
+	}
+	c := &clock{id: s.newID("clock"), name: f.Get("name"), frozen: frozen}
+	a.clocks[c.id] = c
+	return c.render(), nil
+}
+
+func (a *account) lookupClock(id string) (any, error) {
+	c := a.clocks[id]
+	if c == nil {
+		return nil, missing("id", "test_clock", id)
+	}
+	return c.render(), nil
+}
+
+func (a *account) listClocks(f url.Values) (any, error) {
+	cs := maps.Values(a.clocks)
+	slices.SortFunc(cs, func(x, y *clock) bool { return x.id > y.id }) // IDs are sequential
+	return list(f, cs, func(c *clock) string { return c.id }, func(c *clock, _ expansions) map[string]any {
+		return c.render()
+	})
+}
+
+func (s *Server) advanceClock(a *account, id string, f url.Values) (any, error) {
+	c := a.clocks[id]
+	if c == nil {
+		return nil, missing("id", "test_clock", id)
+	}
+	frozen, err := formInt(f, "frozen_time")
+	if err != nil {
+		return nil, err
+	}
+	if frozen <= c.frozen {
+		return nil, invalid("frozen_time", "The test clock can only be advanced forward in time.")
+	}
+	c.frozen = frozen
+	s.syncSchedules(a)
+	return c.render(), nil
+}
+
+func (a *account) deleteClock(id string) (any, error) {
+	if a.clocks[id] == nil {
+		return nil, missing("id", "test_clock", id)
+	}
+	delete(a.clocks, id)
+	return map[string]any{
+		"id":      id,
+		"object":  "test_helpers.test_clock",
+		"deleted": true,
+	}, nil
+}
+
+// customerNow returns the current time for the customer with id, which is
+// the frozen time of its test clock, if any.
+func (s *Server) customerNow(a *account, id string) time.Time {
+	if c := a.customer(id); c != nil && c.clock != "" {
+		if cl := a.clocks[c.clock]; cl != nil {
+			return time.Unix(cl.frozen, 0)
+		}
+	}
+	return s.now()
+}
+
+type product struct {
+	id       string
+	name     string
+	active   bool
+	metadata map[string]string
+	created  int64
+}
+
+func (p *product) render() map[string]any {
+	return map[string]any{
+		"id":       p.id,
+		"object":   "product",
+		"name":     p.name,
+		"active":   p.active,
+		"metadata": p.metadata,
+		"created":  p.created,
+	}
+}
+
+func (s *Server) createProduct(a *account, f url.Values) (any, error) {
+	p, err := s.newProduct(a, f.Get("id"), f.Get("name"), f.Get("active") != "false", "")
+	if err != nil {
+		return nil, err
+	}
+	p.metadata = updateMetadata(p.metadata, f)
+	return p.render(), nil
+}
+
+// listProducts lists products, newest first, filtered by the active
+// parameter if present.
+func (a *account) listProducts(f url.Values) (any, error) {
+	var ps []*product
+	for _, id := range newestFirst(a.productID) {
+		p := a.products[id]
+		if v := f.Get("active"); v != "" && p.active != (v != "false") {
+			continue
+		}
+		ps = append(ps, p)
+	}
+	return list(f, ps, func(p *product) string { return p.id }, func(p *product, _ expansions) map[string]any {
+		return p.render()
+	})
+}
+
+func (s *Server) newProduct(a *account, id, name string, active bool, param string) (*product, error) {
+	if name == "" {
+		return nil, invalid(param+"name", "Missing required param: %sname.", param)
+	}
+	if id == "" {
+		id = s.newID("prod")
+	}
+	if a.products[id] != nil {
+		return nil, &apiError{400, &stripe.Error{
+			Type:    "invalid_request_error",
+			Code:    "resource_already_exists",
+			Param:   param + "id",
+			Message: "Product already exists.",
+		}}
+	}
+	p := &product{
+		id:       id,
+		name:     name,
+		active:   active,
+		metadata: map[string]string{},
+		created:  s.now().Unix(),
+	}
+	a.products[id] = p
+	a.productID = append(a.productID, id)
+	return p, nil
+}
+
+func (a *account) updateProduct(id string, f url.Values) (any, error) {
+	p := a.products[id]
+	if p == nil {
+		return nil, missing("id", "product", id)
+	}
+	if v := f.Get("active"); v != "" {
+		p.active = v != "false"
+	}
+	if v := f.Get("name"); v != "" {
+		p.name = v
+	}
+	p.metadata = updateMetadata(p.metadata, f)
+	return p.render(), nil
+}
+
+func (a *account) lookupProduct(id string) (any, error) {
+	p := a.products[id]
+	if p == nil {
+		return nil, missing("id", "product", id)
+	}
+	return p.render(), nil
+}
+
+type price struct {
+	id        string
+	product   string
+	currency  string
+	lookupKey string
+	nickname  string
+	metadata  map[string]string
+	active    bool
+	created   int64
+
+	oneTime        bool // if set, the recurring fields are empty
+	interval       string
+	intervalCount  int
+	usageType      string
+	aggregateUsage string
+
+	billingScheme string
+	tiersMode     string
+	unitAmount    string // decimal
+	tiers         []priceTier
+
+	divideBy int64  // transform_quantity[divide_by], or 0 if not set
+	round    string // transform_quantity[round]
+}
+
+type priceTier struct {
+	upTo       int64 // or -1 for "inf"
+	unitAmount string
+	flatAmount int64
+}
+
+func (p *price) metered() bool { return p.usageType == "metered" }
+
+// addInterval returns the time n billing intervals of p after t.
+func (p *price) addInterval(t time.Time, n int) time.Time {
+	n *= p.intervalCount
+	switch p.interval {
+	case "day":
+		return t.AddDate(0, 0, n)
+	case "week":
+		return t.AddDate(0, 0, 7*n)
+	case "year":
+		return addMonths(t, 12*n)
+	default:
+		return addMonths(t, n)
+	}
+}
+
+// addMonths adds n months to t, clamping the day to the end of the
+// resulting month, as Stripe does for billing periods.
+func addMonths(t time.Time, n int) time.Time {
+	y, m, d := t.Date()
+	last := time.Date(y, m+time.Month(n)+1, 0, 0, 0, 0, 0, t.Location()).Day()
+	if d > last {
+		d = last
+	}
+	return time.Date(y, m+time.Month(n), d, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+}
+
+// renderPrice renders p, with tiers and product expanded if requested in e.
+func (a *account) renderPrice(p *price, e expansions) map[string]any {
+	v := map[string]any{
+		"id":             p.id,
+		"object":         "price",
+		"active":         p.active,
+		"type":           "recurring",
+		"product":        p.product,
+		"currency":       p.currency,
+		"lookup_key":     nullable(p.lookupKey),
+		"nickname":       nullable(p.nickname),
+		"metadata":       p.metadata,
+		"created":        p.created,
+		"billing_scheme": p.billingScheme,
+		"tiers_mode":     nullable(p.tiersMode),
+		"recurring": map[string]any{
+			"interval":        p.interval,
+			"interval_count":  p.intervalCount,
+			"usage_type":      p.usageType,
+			"aggregate_usage": nullable(p.aggregateUsage),
+		},
+		"unit_amount":         wholeAmount(p.unitAmount),
+		"unit_amount_decimal": nullable(p.unitAmount),
+	}
+	if p.oneTime {
+		v["type"] = "one_time"
+		v["recurring"] = nil
+	}
+	v["transform_quantity"] = nil
+	if p.divideBy > 0 {
+		v["transform_quantity"] = map[string]any{
+			"divide_by": p.divideBy,
+			"round":     p.round,
+		}
+	}
+	if e["product"] {
+		v["product"] = a.products[p.product].render()
+	}
+	if p.billingScheme == "tiered" && e["tiers"] {
+		var tiers []map[string]any
+		for _, t := range p.tiers {
+			var upTo any
+			if t.upTo >= 0 {
+				upTo = t.upTo
+			}
+			tiers = append(tiers, map[string]any{
+				"up_to":               upTo,
+				"unit_amount":         wholeAmount(t.unitAmount),
+				"unit_amount_decimal": nullable(t.unitAmount),
+				"flat_amount":         t.flatAmount,
+				"flat_amount_decimal": strconv.FormatInt(t.flatAmount, 10),
+			})
+		}
+		v["tiers"] = tiers
+	}
+	return v
+}
+
+func (s *Server) createPrice(a *account, f url.Values) (any, error) {
+	p := &price{
+		id:             s.newID("price"),
+		currency:       strings.ToLower(f.Get("currency")),
+		lookupKey:      f.Get("lookup_key"),
+		nickname:       f.Get("nickname"),
+		metadata:       updateMetadata(nil, f),
+		active:         f.Get("active") != "false",
+		created:        s.now().Unix(),
+		interval:       f.Get("recurring[interval]"),
+		usageType:      orDefault(f.Get("recurring[usage_type]"), "licensed"),
+		aggregateUsage: f.Get("recurring[aggregate_usage]"),
+		billingScheme:  orDefault(f.Get("billing_scheme"), "per_unit"),
+		tiersMode:      f.Get("tiers_mode"),
+	}
+
+	if p.currency == "" {
+		return nil, invalid("currency", "Missing required param: currency.")
+	}
+	p.oneTime = true
+	for k := range f {
+		if strings.HasPrefix(k, "recurring[") {
+			p.oneTime = false
+		}
+	}
+	if p.oneTime {
+		if p.billingScheme != "per_unit" {
+			return nil, invalid("billing_scheme", "Prices with `billing_scheme=%s` must be recurring.", p.billingScheme)
+		}
+		p.usageType = ""
+		return s.finishPrice(a, p, f)
+	}
+
+	switch p.interval {
+	case "day", "week", "month", "year":
+	case "":
+		return nil, invalid("recurring[interval]", "Missing required param: recurring[interval].")
+	default:
+		return nil, invalid("recurring[interval]", "Invalid recurring[interval]: %s", p.interval)
+	}
+	n, err := formIntDefault(f, "recurring[interval_count]", 1)
+	if err != nil {
+		return nil, err
+	}
+	p.intervalCount = int(n)
+	switch p.usageType {
+	case "licensed":
+		if p.aggregateUsage != "" {
+			return nil, invalid("recurring[aggregate_usage]", "Aggregate usage is only supported for metered prices.")
+		}
+	case "metered":
+		p.aggregateUsage = orDefault(p.aggregateUsage, "sum")
+	default:
+		return nil, invalid("recurring[usage_type]", "Invalid recurring[usage_type]: %s", p.usageType)
+	}
+
+	switch p.billingScheme {
+	case "per_unit":
+		// The amount is set by finishPrice.
+	case "tiered":
+		if p.tiersMode != "graduated" && p.tiersMode != "volume" {
+			return nil, invalid("tiers_mode", "Invalid tiers_mode: %q", p.tiersMode)
+		}
+		for _, i := range formIndexes(f, "tiers") {
+			key := fmt.Sprintf("tiers[%d]", i)
+			var t priceTier
+			if v := f.Get(key + "[up_to]"); v == "inf" {
+				t.upTo = -1
+			} else if t.upTo, err = formInt(f, key+"[up_to]"); err != nil {
+				return nil, err
+			}
+			if t.unitAmount, err = formDecimal(f, key+"[unit_amount]", key+"[unit_amount_decimal]"); err != nil {
+				return nil, err
+			}
+			if t.flatAmount, err = formIntDefault(f, key+"[flat_amount]", 0); err != nil {
+				return nil, err
+			}
+			p.tiers = append(p.tiers, t)
+		}
+		if len(p.tiers) == 0 {
+			return nil, invalid("tiers", "Missing required param: tiers.")
+		}
+		if p.tiers[len(p.tiers)-1].upTo >= 0 {
+			return nil, invalid("tiers", "The last tier must have `up_to` set to `inf`.")
+		}
+	default:
+		return nil, invalid("billing_scheme", "Invalid billing_scheme: %s", p.billingScheme)
+	}
+	return s.finishPrice(a, p, f)
+}
+
+// finishPrice sets the amount of per unit prices, checks the lookup key,
+// and creates the product of p before adding it to a.
+func (s *Server) finishPrice(a *account, p *price, f url.Values) (any, error) {
+	if f.Has("transform_quantity[divide_by]") {
+		if p.billingScheme != "per_unit" {
+			return nil, invalid("transform_quantity", "Transform quantity is only supported for prices with `billing_scheme=per_unit`.")
+		}
+		var err error
+		p.divideBy, err = formInt(f, "transform_quantity[divide_by]")
+		if err != nil {
+			return nil, err
+		}
+		if p.divideBy < 1 {
+			return nil, invalid("transform_quantity[divide_by]", "Invalid transform_quantity[divide_by]: must be greater than 0")
+		}
+		switch p.round = f.Get("transform_quantity[round]"); p.round {
+		case "up", "down":
+		default:
+			return nil, invalid("transform_quantity[round]", "Invalid transform_quantity[round]: %s", p.round)
+		}
+	}
+
+	if p.billingScheme == "per_unit" {
+		var err error
+		p.unitAmount, err = formDecimal(f, "unit_amount", "unit_amount_decimal")
+		if err != nil {
+			return nil, err
+		}
+		if p.unitAmount == "" {
+			return nil, invalid("unit_amount", "Missing required param: unit_amount.")
+		}
+	}
+
+	if p.lookupKey != "" {
+		for _, q := range a.prices {
+			if q.lookupKey == p.lookupKey {
+				return nil, invalid("lookup_key", "A price (`%s`) already uses that lookup key.", q.id)
+			}
+		}
+	}
+
+	if name := f.Get("product_data[name]"); name != "" {
+		prod, err := s.newProduct(a, f.Get("product_data[id]"), name, true, "product_data[")
+		if err != nil {
+			return nil, err
+		}
+		p.product = prod.id
+	} else {
+		p.product = f.Get("product")
+		if a.products[p.product] == nil {
+			return nil, missing("product", "product", p.product)
+		}
+	}
+
+	a.prices = append(a.prices, p)
+	return a.renderPrice(p, expandParam(f)), nil
+}
+
+func (a *account) price(id string) *price {
+	for _, p := range a.prices {
+		if p.id == id {
+			return p
+		}
+	}
+	return nil
+}
+
+func (a *account) lookupPrice(id string, f url.Values) (any, error) {
+	p := a.price(id)
+	if p == nil {
+		return nil, missing("id", "price", id)
+	}
+	return a.renderPrice(p, expandParam(f)), nil
+}
+
+// listPrices lists prices, newest first, or in the order of the
+// lookup_keys[] parameter if present.
+func (a *account) listPrices(f url.Values) (any, error) {
+	var ps []*price
+	if keys := f["lookup_keys[]"]; len(keys) > 0 {
+		for _, k := range keys {
+			for _, p := range a.prices {
+				if p.lookupKey == k {
+					ps = append(ps, p)
+				}
+			}
+		}
+	} else {
+		ps = newestFirst(a.prices)
+	}
+	if v := f.Get("active"); v != "" {
+		active := v != "false"
+		var filtered []*price
+		for _, p := range ps {
+			if p.active == active {
+				filtered = append(filtered, p)
+			}
+		}
+		ps = filtered
+	}
+	return list(f, ps, func(p *price) string { return p.id }, a.renderPrice)
+}
+
+func (a *account) updatePrice(id string, f url.Values) (any, error) {
+	p := a.price(id)
+	if p == nil {
+		return nil, missing("id", "price", id)
+	}
+	if v := f.Get("active"); v != "" {
+		p.active = v != "false"
+	}
+	if key, ok := f["lookup_key"]; ok && key[0] != p.lookupKey {
+		for _, q := range a.prices {
+			if key[0] != "" && q.lookupKey == key[0] {
+				return nil, invalid("lookup_key", "A price (`%s`) already uses that lookup key.", q.id)
+			}
+		}
+		p.lookupKey = key[0]
+	}
+	if v, ok := f["nickname"]; ok {
+		p.nickname = v[0]
+	}
+	p.metadata = updateMetadata(p.metadata, f)
+	return a.renderPrice(p, expandParam(f)), nil
+}
+
+type customer struct {
+	id          string
+	email       string
+	name        string
+	phone       string
+	description string
+	metadata    map[string]string
+	clock       string
+	created     int64
+
+	// balance is the amount added to the next invoice of the customer,
+	// in currency, or credited to it if negative.
+	balance  int64
+	currency string
+
+	// delinquent reports whether the last payment of an invoice of the
+	// customer failed.
+	delinquent bool
+
+	paymentMethod string // the default payment method of invoices
+}
+
+func (c *customer) render() map[string]any {
+	return map[string]any{
+		"id":          c.id,
+		"object":      "customer",
+		"email":       nullable(c.email),
+		"name":        nullable(c.name),
+		"phone":       nullable(c.phone),
+		"description": nullable(c.description),
+		"metadata":    c.metadata,
+		"test_clock":  nullable(c.clock),
+		"created":     c.created,
+		"balance":     c.balance,
+		"currency":    nullable(c.currency),
+		"delinquent":  c.delinquent,
+		"invoice_settings": map[string]any{
+			"default_payment_method": nullable(c.paymentMethod),
+		},
+	}
+}
+
+// createBalanceTransaction adjusts the balance of the customer id. The
+// currency sets the customer's currency if not yet set, and must match it
+// otherwise.
+func (s *Server) createBalanceTransaction(a *account, id string, f url.Values) (any, error) {
+	c := a.customer(id)
+	if c == nil {
+		return nil, missing("id", "customer", id)
+	}
+	amount, err := formInt(f, "amount")
+	if err != nil {
+		return nil, err
+	}
+	currency := f.Get("currency")
+	if currency == "" {
+		return nil, invalid("currency", "Missing required param: currency.")
+	}
+	if c.currency != "" && c.currency != currency {
+		return nil, invalid("currency", "Customer balance transactions must be in the customer's currency (%s).", c.currency)
+	}
+	c.currency = currency
+	c.balance += amount
+	return map[string]any{
+		"id":             s.newID("cbtxn"),
+		"object":         "customer_balance_transaction",
+		"type":           "adjustment",
+		"customer":       c.id,
+		"amount":         amount,
+		"currency":       currency,
+		"description":    nullable(f.Get("description")),
+		"ending_balance": c.balance,
+		"created":        s.customerNow(a, c.id).Unix(),
+	}, nil
+}
+
+func (s *Server) createCustomer(a *account, f url.Values) (any, error) {
+	c := &customer{
+		id:       s.newID("cus"),
+		metadata: map[string]string{},
+		clock:    f.Get("test_clock"),
+		created:  s.now().Unix(),
+	}
+	if c.clock != "" {
+		cl := a.clocks[c.clock]
+		if cl == nil {
+			return nil, missing("test_clock", "test_clock", c.clock)
+		}
+		c.created = cl.frozen
+	}
+	if err := c.update(f); err != nil {
+		return nil, err
+	}
+	a.customers = append(a.customers, c)
+	return c.render(), nil
+}
+
+func (c *customer) update(f url.Values) error {
+	if f.Has("email") {
+		email := f.Get("email")
+		if email != "" && !validEmail(email) {
+			return &apiError{400, &stripe.Error{
+				Type:    "invalid_request_error",
+				Code:    "email_invalid",
+				Param:   "email",
+				Message: fmt.Sprintf("Invalid email address: %s", email),
+			}}
+		}
+		c.email = email
+	}
+	for key, field := range map[string]*string{
+		"name":        &c.name,
+		"phone":       &c.phone,
+		"description": &c.description,
+
+		"invoice_settings[default_payment_method]": &c.paymentMethod,
+	} {
+		if f.Has(key) {
+			*field = f.Get(key)
+		}
+	}
+	c.metadata = updateMetadata(c.metadata, f)
+	return nil
+}
+
+func validEmail(s string) bool {
+	local, domain, ok := strings.Cut(s, "@")
+	if !ok || local == "" || strings.Contains(domain, "@") {
+		return false
+	}
+	i := strings.LastIndexByte(domain, '.')
+	return i > 0 && i < len(domain)-1
+}
+
+func (a *account) customer(id string) *customer {
+	for _, c := range a.customers {
+		if c.id == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (a *account) lookupCustomer(id string) (any, error) {
+	c := a.customer(id)
+	if c == nil {
+		return nil, missing("id", "customer", id)
+	}
+	return c.render(), nil
+}
+
+func (a *account) updateCustomer(id string, f url.Values) (any, error) {
+	c := a.customer(id)
+	if c == nil {
+		return nil, missing("id", "customer", id)
+	}
+	// validate before modifying c, so that a failed update has no effect
+	cc := *c
+	cc.metadata = cloneMap(c.metadata)
+	if err := cc.update(f); err != nil {
+		return nil, err
+	}
+	*c = cc
+	return c.render(), nil
+}
+
+func (a *account) listCustomers(f url.Values) (any, error) {
+	var cs []*customer
+	for _, c := range newestFirst(a.customers) {
+		if email := f.Get("email"); email != "" && c.email != email {
+			continue
+		}
+		ok, err := createdInRange(f, c.created)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			cs = append(cs, c)
+		}
+	}
+	return list(f, cs, func(c *customer) string { return c.id }, func(c *customer, _ expansions) map[string]any {
+		return c.render()
+	})
+}
+
+// searchCustomers searches the customers with the query in f, which
+// supports only metadata clauses, such as metadata['k']:'v', joined by AND.
+// Unlike Stripe, results are not delayed.
+func (a *account) searchCustomers(f url.Values) (any, error) {
+	want, err := parseMetadataQuery(f.Get("query"))
+	if err != nil {
+		return nil, err
+	}
+	var cs []*customer
+	for _, c := range newestFirst(a.customers) {
+		match := true
+		for k, v := range want {
+			if c.metadata[k] != v {
+				match = false
+			}
+		}
+		if match {
+			cs = append(cs, c)
+		}
+	}
+	page := url.Values{"limit": f["limit"], "starting_after": f["page"]}
+	v, err := list(page, cs, func(c *customer) string { return c.id }, func(c *customer, _ expansions) map[string]any {
+		return c.render()
+	})
+	if err != nil {
+		return nil, err
+	}
+	m := v.(map[string]any)
+	m["object"] = "search_result"
+	if data := m["data"].([]map[string]any); m["has_more"].(bool) {
+		m["next_page"] = data[len(data)-1]["id"]
+	}
+	return m, nil
+}
+
+// parseMetadataQuery parses a search query of metadata clauses joined by
+// AND into the metadata they match.
+func parseMetadataQuery(q string) (map[string]string, error) {
+	bad := invalid("query", "Unsupported search query: "+q)
+	m := map[string]string{}
+	for q != "" {
+		var ok bool
+		if q, ok = cutPrefix(q, "metadata["); !ok {
+			return nil, bad
+		}
+		k, rest, ok := cutQuoted(q)
+		if !ok {
+			return nil, bad
+		}
+		if q, ok = cutPrefix(rest, "]:"); !ok {
+			return nil, bad
+		}
+		v, rest, ok := cutQuoted(q)
+		if !ok {
+			return nil, bad
+		}
+		m[k] = v
+		if rest == "" {
+			break
+		}
+		if q, ok = cutPrefix(rest, " AND "); !ok || q == "" {
+			return nil, bad
+		}
+	}
+	if len(m) == 0 {
+		return nil, bad
+	}
+	return m, nil
+}
+
+func cutPrefix(s, prefix string) (string, bool) {
+	if !strings.HasPrefix(s, prefix) {
+		return s, false
+	}
+	return s[len(prefix):], true
+}
+
+// cutQuoted returns the unescaped contents of the single-quoted string at
+// the start of s, and the rest of s.
+func cutQuoted(s string) (v, rest string, ok bool) {
+	if !strings.HasPrefix(s, "'") {
+		return "", s, false
+	}
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", s, false
+			}
+			b.WriteByte(s[i])
+		case '\'':
+			return b.String(), s[i+1:], true
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return "", s, false
+}
+
+type schedule struct {
+	id           string
+	customer     string
+	metadata     map[string]string
+	phases       []phase
+	status       string // "not_started", "active", "released", or "canceled"
+	subscription string
+	created      int64
+}
+
+type phase struct {
+	start, end   int64
+	prices       []string
+	invoiceItems []string // one-time prices billed when the phase begins
+	trialEnd     int64    // zero if the phase has no trial
+}
+
+// current returns the index of the phase in effect at now, or -1 if none.
+func (s *schedule) current(now int64) int {
+	for i, p := range s.phases {
+		if p.start <= now && now < p.end {
+			return i
+		}
+	}
+	return -1
+}
+
+// renderSchedule renders sch, with the prices of phase items and invoice
+// items expanded if requested in e.
+func (s *Server) renderSchedule(a *account, sch *schedule, e expansions) map[string]any {
+	var phases []map[string]any
+	for _, p := range sch.phases {
+		var items []map[string]any
+		for _, id := range p.prices {
+			var price any = id
+			if e["phases.items.price"] {
+				price = a.renderPrice(a.price(id), e.sub("phases.items.price"))
+			}
+			items = append(items, map[string]any{
+				"price":    price,
+				"quantity": quantity(a.price(id)),
+			})
+		}
+		invoiceItems := []map[string]any{}
+		for _, id := range p.invoiceItems {
+			var price any = id
+			if e["phases.add_invoice_items.price"] {
+				price = a.renderPrice(a.price(id), e.sub("phases.add_invoice_items.price"))
+			}
+			invoiceItems = append(invoiceItems, map[string]any{
+				"price":    price,
+				"quantity": 1,
+			})
+		}
+		var trialEnd any
+		if p.trialEnd != 0 {
+			trialEnd = p.trialEnd
+		}
+		phases = append(phases, map[string]any{
+			"start_date":        p.start,
+			"end_date":          p.end,
+			"items":             items,
+			"add_invoice_items": invoiceItems,
+			"trial_end":         trialEnd,
+		})
+	}
+	var current any
+	if sch.status == "active" {
+		if i := sch.current(s.customerNow(a, sch.customer).Unix()); i >= 0 {
+			current = map[string]any{
+				"start_date": sch.phases[i].start,
+				"end_date":   sch.phases[i].end,
+			}
+		}
+	}
+	v := map[string]any{
+		"id":            sch.id,
+		"object":        "subscription_schedule",
+		"customer":      sch.customer,
+		"metadata":      sch.metadata,
+		"status":        sch.status,
+		"end_behavior":  "release",
+		"phases":        phases,
+		"current_phase": current,
+		"created":       sch.created,
+
+		"subscription":          nil,
+		"released_subscription": nil,
+	}
+	if sch.status == "released" {
+		v["released_subscription"] = nullable(sch.subscription)
+	} else {
+		v["subscription"] = nullable(sch.subscription)
+	}
+	return v
+}
+
+func (s *Server) createSchedule(a *account, f url.Values) (any, error) {
+	sch := &schedule{
+		id:       s.newID("sub_sched"),
+		metadata: updateMetadata(nil, f),
+		status:   "not_started",
+		created:  s.now().Unix(),
+	}
+
+	if id := f.Get("from_subscription"); id != "" {
+		if len(sch.metadata) > 0 {
+			return nil, invalid("metadata", "You cannot set `metadata` if `from_subscription` is set.")
+		}
+		sub := a.subscription(id)
+		if sub == nil {
+			return nil, missing("from_subscription", "subscription", id)
+		}
+		if sub.schedule != "" {
+			return nil, invalid("from_subscription", "You cannot migrate a subscription that is already attached to a schedule: `%s`.", sub.schedule)
+		}
+		var prices []string
+		for _, it := range sub.items {
+			prices = append(prices, it.price)
+		}
+		sch.customer = sub.customer
+		sch.phases = []phase{{start: sub.periodStart, end: sub.periodEnd, prices: prices}}
+		sch.status = "active"
+		sch.subscription = sub.id
+		sub.schedule = sch.id
+	} else {
+		sch.customer = f.Get("customer")
+		if a.customer(sch.customer) == nil {
+			return nil, missing("customer", "customer", sch.customer)
+		}
+		now := s.customerNow(a, sch.customer)
+		start, err := formTime(f, "start_date", now)
+		if err != nil {
+			return nil, err
+		}
+		sch.phases, err = parsePhases(a, f, start)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	a.schedules = append(a.schedules, sch)
+	s.syncSchedules(a)
+	return s.renderSchedule(a, sch, expandParam(f)), nil
+}
+
+func (s *Server) updateSchedule(a *account, id string, f url.Values) (any, error) {
+	sch, err := a.updatableSchedule(id)
+	if err != nil {
+		return nil, err
+	}
+
+	var phases []phase
+	if len(formIndexes(f, "phases")) > 0 {
+		start, err := formTime(f, "phases[0][start_date]", s.customerNow(a, sch.customer))
+		if err != nil {
+			return nil, err
+		}
+		phases, err = parsePhases(a, f, start)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sch.metadata = updateMetadata(sch.metadata, f)
+	if phases != nil {
+		sch.phases = phases
+	}
+	s.syncSchedules(a)
+	return s.renderSchedule(a, sch, expandParam(f)), nil
+}
+
+const maxItems = 20
+
+// parsePhases parses the phases parameter in f, with the first phase
+// starting at start.
+func parsePhases(a *account, f url.Values, start int64) ([]phase, error) {
+	idx := formIndexes(f, "phases")
+	if len(idx) == 0 {
+		return nil, invalid("phases", "Missing required param: phases.")
+	}
+	var phases []phase
+	for n, i := range idx {
+		key := fmt.Sprintf("phases[%d]", i)
+		if i != n {
+			return nil, invalid(key, "Invalid array index: %d", i)
+		}
+
+		items := formIndexes(f, key+"[items]")
+		if len(items) == 0 {
+			return nil, invalid(key+"[items]", "Missing required param: %s[items].", key)
+		}
+		if len(items) > maxItems {
+			return nil, invalid(key+"[items]", "A phase may have a maximum number of items of %d.", maxItems)
+		}
+		p := phase{start: start}
+		for _, j := range items {
+			param := fmt.Sprintf("%s[items][%d][price]", key, j)
+			id := f.Get(param)
+			pr := a.price(id)
+			if pr == nil {
+				return nil, missing(param, "price", id)
+			}
+			if pr.oneTime {
+				return nil, invalid(param, "The price specified is set to `type=one_time` but this field only accepts prices with `type=recurring`.")
+			}
+			p.prices = append(p.prices, id)
+		}
+		for _, j := range formIndexes(f, key+"[add_invoice_items]") {
+			param := fmt.Sprintf("%s[add_invoice_items][%d][price]", key, j)
+			id := f.Get(param)
+			pr := a.price(id)
+			if pr == nil {
+				return nil, missing(param, "price", id)
+			}
+			if !pr.oneTime {
+				return nil, invalid(param, "The price specified is set to `type=recurring` but this field only accepts prices with `type=one_time`.")
+			}
+			p.invoiceItems = append(p.invoiceItems, id)
+		}
+
+		var err error
+		if i > 0 {
+			p.start, err = formIntDefault(f, key+"[start_date]", phases[i-1].end)
+			if err != nil {
+				return nil, err
+			}
+		}
+		first := a.price(p.prices[0])
+		p.end, err = formIntDefault(f, key+"[end_date]", first.addInterval(time.Unix(p.start, 0), 1).Unix())
+		if err != nil {
+			return nil, err
+		}
+		if p.end <= p.start {
+			return nil, invalid(key+"[end_date]", "The phase end_date must be after its start_date.")
+		}
+		p.trialEnd, err = formIntDefault(f, key+"[trial_end]", 0)
+		if err != nil {
+			return nil, err
+		}
+		if p.trialEnd != 0 && p.trialEnd <= p.start {
+			return nil, invalid(key+"[trial_end]", "The phase trial_end must be after its start_date.")
+		}
+		phases = append(phases, p)
+		start = p.end
+	}
+	return phases, nil
+}
+
+// cancelSchedule cancels the schedule id, and its subscription, if any.
+func (s *Server) cancelSchedule(a *account, id string, f url.Values) (any, error) {
+	sch, err := a.updatableSchedule(id)
+	if err != nil {
+		return nil, err
+	}
+	sch.status = "canceled"
+	if sub := a.subscription(sch.subscription); sub != nil {
+		sub.canceled = true
+	}
+	return s.renderSchedule(a, sch, expandParam(f)), nil
+}
+
+// releaseSchedule releases the subscription of the schedule id, leaving it
+// as it is, but no longer managed by the schedule.
+func (s *Server) releaseSchedule(a *account, id string, f url.Values) (any, error) {
+	sch, err := a.updatableSchedule(id)
+	if err != nil {
+		return nil, err
+	}
+	sch.status = "released"
+	if sub := a.subscription(sch.subscription); sub != nil {
+		sub.schedule = ""
+	}
+	return s.renderSchedule(a, sch, expandParam(f)), nil
+}
+
+// updatableSchedule returns the schedule id, or an error if it does not
+// exist or has been released or canceled.
+func (a *account) updatableSchedule(id string) (*schedule, error) {
+	sch := a.schedule(id)
+	if sch == nil {
+		return nil, missing("id", "subscription_schedule", id)
+	}
+	if sch.status != "not_started" && sch.status != "active" {
+		return nil, invalid("", "You cannot update a subscription schedule that is currently in the `%s` status. It must be in one of the following statuses: `not_started`, `active`.", sch.status)
+	}
+	return sch, nil
+}
+
+func (a *account) schedule(id string) *schedule {
+	for _, s := range a.schedules {
+		if s.id == id {
+			return s
+		}
+	}
+	return nil
+}
+
+func (s *Server) listSchedules(a *account, f url.Values) (any, error) {
+	var ss []*schedule
+	for _, sch := range newestFirst(a.schedules) {
+		if cid := f.Get("customer"); cid != "" && sch.customer != cid {
+			continue
+		}
+		ss = append(ss, sch)
+	}
+	return list(f, ss, func(sch *schedule) string { return sch.id }, func(sch *schedule, e expansions) map[string]any {
+		return s.renderSchedule(a, sch, e)
+	})
+}
+
+// syncSchedules moves each schedule and subscription in a forward to the
+// current time of its customer, starting, updating, and releasing
+// subscriptions as phases begin and end.
+func (s *Server) syncSchedules(a *account) {
+	for _, sch := range a.schedules {
+		if sch.status != "not_started" && sch.status != "active" {
+			continue
+		}
+		now := s.customerNow(a, sch.customer).Unix()
+		last := sch.phases[len(sch.phases)-1]
+		i := sch.current(now)
+		switch {
+		case i >= 0:
+			sch.status = "active"
+			s.putSubscription(a, sch, sch.phases[i])
+		case now >= last.end:
+			s.putSubscription(a, sch, last)
+			sch.status = "released"
+			a.subscription(sch.subscription).schedule = ""
+		}
+	}
+	for _, sub := range a.subs {
+		sub.sync(a, s.customerNow(a, sub.customer))
+	}
+}
+
+type subscription struct {
+	id       string
+	customer string
+	schedule string
+	items    []*item
+	created  int64
+	metadata map[string]string
+
+	anchor, periodStart, periodEnd int64
+
+	canceled          bool
+	cancelAtPeriodEnd bool
+	pauseBehavior     string // pause_collection[behavior], or empty if not paused
+}
+
+type item struct {
+	id    string
+	price string
+	usage int64
+}
+
+// putSubscription creates or updates the subscription of sch with the
+// prices in p.
+func (s *Server) putSubscription(a *account, sch *schedule, p phase) {
+	sub := a.subscription(sch.subscription)
+	if sub == nil {
+		sub = &subscription{
+			id:       s.newID("sub"),
+			customer: sch.customer,
+			schedule: sch.id,
+			created:  p.start,
+			metadata: map[string]string{},
+			anchor:   p.start,
+		}
+		a.subs = append(a.subs, sub)
+		sch.subscription = sub.id
+	}
+	items := make([]*item, 0, len(p.prices))
+	for _, id := range p.prices {
+		i := slices.IndexFunc(sub.items, func(it *item) bool { return it.price == id })
+		if i >= 0 {
+			items = append(items, sub.items[i])
+		} else {
+			items = append(items, &item{id: s.newID("si"), price: id})
+		}
+	}
+	sub.items = items
+}
+
+// sync moves the current period of sub forward to now, resetting usage when
+// a new period begins.
+func (sub *subscription) sync(a *account, now time.Time) {
+	if len(sub.items) == 0 || sub.canceled {
+		return
+	}
+	if sub.cancelAtPeriodEnd && sub.periodEnd != 0 && now.Unix() >= sub.periodEnd {
+		sub.canceled = true
+		return
+	}
+	p := a.price(sub.items[0].price)
+	anchor := time.Unix(sub.anchor, 0).UTC()
+	start, end := anchor, p.addInterval(anchor, 1)
+	for n := 1; !now.Before(end); n++ {
+		start, end = end, p.addInterval(anchor, n+1)
+	}
+	if sub.periodStart != 0 && sub.periodStart != start.Unix() {
+		for _, it := range sub.items {
+			it.usage = 0
+		}
+	}
+	sub.periodStart, sub.periodEnd = start.Unix(), end.Unix()
+}
+
+func (a *account) subscription(id string) *subscription {
+	for _, sub := range a.subs {
+		if sub.id == id {
+			return sub
+		}
+	}
+	return nil
+}
+
+// renderSubscription renders sub, with its schedule expanded if requested
+// in e.
+func (s *Server) renderSubscription(a *account, sub *subscription, e expansions) map[string]any {
+	var items []map[string]any
+	for _, it := range sub.items {
+		items = append(items, map[string]any{
+			"id":           it.id,
+			"object":       "subscription_item",
+			"price":        a.renderPrice(a.price(it.price), e.sub("items.data.price")),
+			"quantity":     quantity(a.price(it.price)),
+			"subscription": sub.id,
+		})
+	}
+	var sch any
+	status := "active"
+	if sub.canceled {
+		status = "canceled"
+	}
+	var pause any
+	if sub.pauseBehavior != "" {
+		pause = map[string]any{"behavior": sub.pauseBehavior}
+	}
+	if sub.schedule != "" && !sub.canceled {
+		sch = sub.schedule
+		if e["schedule"] {
+			sch = s.renderSchedule(a, a.schedule(sub.schedule), e.sub("schedule"))
+		}
+		ss := a.schedule(sub.schedule)
+		now := s.customerNow(a, sub.customer).Unix()
+		if i := ss.current(now); i >= 0 && now < ss.phases[i].trialEnd {
+			status = "trialing"
+		}
+	}
+	return map[string]any{
+		"id":                   sub.id,
+		"object":               "subscription",
+		"customer":             sub.customer,
+		"status":               status,
+		"schedule":             sch,
+		"created":              sub.created,
+		"current_period_start": sub.periodStart,
+		"current_period_end":   sub.periodEnd,
+		"cancel_at_period_end": sub.cancelAtPeriodEnd,
+		"pause_collection":     pause,
+		"metadata":             sub.metadata,
+		"items": map[string]any{
+			"object":   "list",
+			"data":     items,
+			"has_more": false,
+		},
+	}
+}
+
+// listSubscriptions lists subscriptions, newest first, filtered by
+// customer. Canceled subscriptions are listed only if status is "all" or
+// "canceled", as Stripe does.
+func (s *Server) listSubscriptions(a *account, f url.Values) (any, error) {
+	var subs []*subscription
+	for _, sub := range newestFirst(a.subs) {
+		if cid := f.Get("customer"); cid != "" && sub.customer != cid {
+			continue
+		}
+		switch status := f.Get("status"); {
+		case status == "all":
+		case status == "canceled" && !sub.canceled:
+			continue
+		case status != "canceled" && sub.canceled:
+			continue
+		}
+		subs = append(subs, sub)
+	}
+	return list(f, subs, func(sub *subscription) string { return sub.id }, func(sub *subscription, e expansions) map[string]any {
+		return s.renderSubscription(a, sub, e)
+	})
+}
+
+// updateSubscription updates the metadata, cancel_at_period_end, and
+// pause_collection of the subscription id. Stripe does not allow canceling
+// subscriptions managed by a schedule this way.
+func (s *Server) updateSubscription(a *account, id string, f url.Values) (any, error) {
+	sub, err := a.activeSubscription(id)
+	if err != nil {
+		return nil, err
+	}
+	if f.Has("cancel_at_period_end") {
+		if sub.schedule != "" {
+			return nil, invalid("cancel_at_period_end", "The subscription is managed by the subscription schedule `%s`, and updating any cancelation behavior directly is not allowed. Please update the schedule instead.", sub.schedule)
+		}
+		sub.cancelAtPeriodEnd = f.Get("cancel_at_period_end") == "true"
+	}
+	sub.metadata = updateMetadata(sub.metadata, f)
+	if f.Has("pause_collection") && f.Get("pause_collection") == "" {
+		sub.pauseBehavior = ""
+	}
+	if f.Has("pause_collection[behavior]") {
+		switch b := f.Get("pause_collection[behavior]"); b {
+		case "keep_as_draft", "mark_uncollectible", "void":
+			sub.pauseBehavior = b
+		default:
+			return nil, invalid("pause_collection[behavior]", "Invalid pause_collection[behavior]: must be one of keep_as_draft, mark_uncollectible, or void")
+		}
+	}
+	return s.renderSubscription(a, sub, expandParam(f)), nil
+}
+
+// cancelSubscription cancels the subscription id immediately, and its
+// schedule, if any.
+func (s *Server) cancelSubscription(a *account, id string, f url.Values) (any, error) {
+	sub, err := a.activeSubscription(id)
+	if err != nil {
+		return nil, err
+	}
+	sub.canceled = true
+	if sch := a.schedule(sub.schedule); sch != nil {
+		sch.status = "canceled"
+	}
+	return s.renderSubscription(a, sub, expandParam(f)), nil
+}
+
+// activeSubscription returns the subscription id, or an error if it does
+// not exist or is canceled.
+func (a *account) activeSubscription(id string) (*subscription, error) {
+	sub := a.subscription(id)
+	if sub == nil {
+		return nil, missing("id", "subscription", id)
+	}
+	if sub.canceled {
+		return nil, invalid("", "A canceled subscription can only update its cancellation_details and metadata.")
+	}
+	return sub, nil
+}
+
+func (s *Server) createUsageRecord(a *account, itemID string, f url.Values) (any, error) {
+	var sub *subscription
+	var it *item
+	for _, x := range a.subs {
+		for _, y := range x.items {
+			if y.id == itemID {
+				sub, it = x, y
+			}
+		}
+	}
+	if it == nil {
+		return nil, missing("id", "subscription_item", itemID)
+	}
+	if !a.price(it.price).metered() {
+		return nil, invalid("", "Usage records can only be created for subscription items with metered prices.")
+	}
+
+	n, err := formInt(f, "quantity")
+	if err != nil {
+		return nil, err
+	}
+	ts, err := formTime(f, "timestamp", s.customerNow(a, sub.customer))
+	if err != nil {
+		return nil, err
+	}
+	if ts < sub.periodStart {
+		return nil, invalid("timestamp", "Cannot create the usage record with this timestamp because timestamps must be after the subscription's current period start time.")
+	}
+
+	switch action := orDefault(f.Get("action"), "increment"); action {
+	case "increment":
+		it.usage += n
+	case "set":
+		it.usage = n
+	default:
+		return nil, invalid("action", "Invalid action: %s", action)
+	}
+
+	return map[string]any{
+		"id":                s.newID("mbur"),
+		"object":            "usage_record",
+		"quantity":          n,
+		"subscription_item": it.id,
+		"timestamp":         ts,
+	}, nil
+}
+
+// upcomingLines lists the lines of the upcoming invoice for a customer.
+// Metered items are billed in arrears for the current period, and licensed
+// items in advance for the next period.
+func (s *Server) upcomingLines(a *account, f url.Values) (any, error) {
+	cid := f.Get("customer")
+	if a.customer(cid) == nil {
+		return nil, missing("customer", "customer", cid)
+	}
+
+	type line struct {
+		id string
+		v  func(expansions) map[string]any
+	}
+	var lines []line
+	for _, sub := range a.subs {
+		if sub.customer != cid {
+			continue
+		}
+		for _, it := range sub.items {
+			p := a.price(it.price)
+			start, end, n := sub.periodStart, sub.periodEnd, it.usage
+			if !p.metered() {
+				start, n = end, 1
+				end = p.addInterval(time.Unix(sub.anchor, 0).UTC(), periodsSince(p, sub.anchor, end)+1).Unix()
+			}
+			id := "il_" + it.id
+			lines = append(lines, line{id, func(e expansions) map[string]any {
+				return map[string]any{
+					"id":                id,
+					"object":            "line_item",
+					"price":             a.renderPrice(p, e.sub("price")),
+					"quantity":          n,
+					"subscription":      sub.id,
+					"subscription_item": it.id,
+					"period": map[string]any{
+						"start": start,
+						"end":   end,
+					},
+				}
+			}})
+		}
+	}
+	if len(lines) == 0 {
+		return nil, &apiError{404, &stripe.Error{
+			Type:    "invalid_request_error",
+			Code:    "invoice_upcoming_none",
+			Message: fmt.Sprintf("No upcoming invoices for customer: %s", cid),
+		}}
+	}
+	return list(f, lines, func(l line) string { return l.id }, func(l line, e expansions) map[string]any { return l.v(e) })
+}
+
+// An invoice is an invoice created with POST /v1/invoices. Invoices total
+// the amounts of the items added to them, less the amounts of their credit
+// notes, and are never paid or finalized automatically.
+type invoice struct {
+	id       string
+	customer string
+	currency string
+	status   string // "draft", "open", "paid", "void", or "uncollectible"
+	total    int64
+	credited int64  // by credit notes
+	charge   string // the charge paying the invoice, once paid
+	created  int64
+	metadata map[string]string
+}
+
+func (in *invoice) render() map[string]any {
+	var hosted any
+	if in.status != "draft" {
+		hosted = "https://invoice.stripe.com/i/" + in.id
+	}
+	return map[string]any{
+		"id":                 in.id,
+		"object":             "invoice",
+		"customer":           in.customer,
+		"currency":           in.currency,
+		"status":             in.status,
+		"total":              in.total,
+		"amount_due":         in.total - in.credited,
+		"charge":             nullable(in.charge),
+		"created":            in.created,
+		"hosted_invoice_url": hosted,
+		"metadata":           in.metadata,
+	}
+}
+
+func (s *Server) createInvoice(a *account, f url.Values) (any, error) {
+	cid := f.Get("customer")
+	if a.customer(cid) == nil {
+		return nil, missing("customer", "customer", cid)
+	}
+	in := &invoice{
+		id:       s.newID("in"),
+		customer: cid,
+		currency: orDefault(f.Get("currency"), "usd"),
+		status:   "draft",
+		created:  s.customerNow(a, cid).Unix(),
+		metadata: updateMetadata(map[string]string{}, f),
+	}
+	a.invoices = append(a.invoices, in)
+	return in.render(), nil
+}
+
+// createInvoiceItem adds an amount to a draft invoice of a customer.
+// Items not added to an invoice are not supported.
+func (s *Server) createInvoiceItem(a *account, f url.Values) (any, error) {
+	id := f.Get("invoice")
+	in := a.invoice(id)
+	if in == nil {
+		return nil, missing("invoice", "invoice", id)
+	}
+	if in.customer != f.Get("customer") {
+		return nil, invalid("invoice", "The invoice %s does not belong to customer %s.", id, f.Get("customer"))
+	}
+	if in.status != "draft" {
+		return nil, invalid("invoice", "You can only add invoice items to draft invoices.")
+	}
+	amount, err := formInt(f, "amount")
+	if err != nil {
+		return nil, err
+	}
+	in.total += amount
+	return map[string]any{
+		"id":       s.newID("ii"),
+		"object":   "invoiceitem",
+		"customer": in.customer,
+		"invoice":  in.id,
+		"amount":   amount,
+		"currency": in.currency,
+	}, nil
+}
+
+func (a *account) invoice(id string) *invoice {
+	for _, in := range a.invoices {
+		if in.id == id {
+			return in
+		}
+	}
+	return nil
+}
+
+func (a *account) lookupInvoice(id string) (any, error) {
+	in := a.invoice(id)
+	if in == nil {
+		return nil, missing("id", "invoice", id)
+	}
+	return in.render(), nil
+}
+
+func (a *account) listInvoices(f url.Values) (any, error) {
+	var ins []*invoice
+	for _, in := range newestFirst(a.invoices) {
+		if cid := f.Get("customer"); cid != "" && in.customer != cid {
+			continue
+		}
+		if status := f.Get("status"); status != "" && in.status != status {
+			continue
+		}
+		ins = append(ins, in)
+	}
+	return list(f, ins, func(in *invoice) string { return in.id }, func(in *invoice, _ expansions) map[string]any {
+		return in.render()
+	})
+}
+
+// invoiceTransitions maps invoice actions to the statuses they apply to,
+// and the status they result in.
+var invoiceTransitions = map[string]struct {
+	from []string
+	to   string
+}{
+	"finalize":           {[]string{"draft"}, "open"},
+	"void":               {[]string{"open", "uncollectible"}, "void"},
+	"mark_uncollectible": {[]string{"open"}, "uncollectible"},
+	"pay":                {[]string{"open", "uncollectible"}, "paid"},
+}
+
+// declinedPaymentMethod is the test payment method Stripe declines.
+const declinedPaymentMethod = "pm_card_chargeDeclined"
+
+// transitionInvoice applies the action (e.g. "finalize") to the invoice
+// id, if allowed in its status.
+//
+// Paying with declinedPaymentMethod fails, and marks the customer
+// delinquent until an invoice is paid or marked uncollectible.
+func (s *Server) transitionInvoice(a *account, id, action string, f url.Values) (any, error) {
+	in := a.invoice(id)
+	if in == nil {
+		return nil, missing("id", "invoice", id)
+	}
+	t, ok := invoiceTransitions[action]
+	if !ok {
+		return nil, &apiError{404, &stripe.Error{
+			Type:    "invalid_request_error",
+			Message: fmt.Sprintf("Unrecognized request URL (POST: /v1/invoices/%s/%s).", id, action),
+		}}
+	}
+	if !slices.Contains(t.from, in.status) {
+		return nil, &apiError{400, &stripe.Error{
+			Type:    "invalid_request_error",
+			Code:    "invoice_not_editable",
+			Message: fmt.Sprintf("You can only %s %s invoices, but this invoice is %s.", strings.ReplaceAll(action, "_", " "), strings.Join(t.from, " or "), in.status),
+		}}
+	}
+	if action == "pay" && f.Get("payment_method") == declinedPaymentMethod {
+		if c := a.customer(in.customer); c != nil {
+			c.delinquent = true
+		}
+		return nil, &apiError{402, &stripe.Error{
+			Type:        "card_error",
+			Code:        "card_declined",
+			DeclineCode: "generic_decline",
+			Message:     "Your card was declined.",
+		}}
+	}
+	in.status = t.to
+	if c := a.customer(in.customer); c != nil && (action == "pay" || action == "mark_uncollectible") {
+		c.delinquent = false
+	}
+	if action == "pay" {
+		ch := &charge{
+			id:       s.newID("ch"),
+			customer: in.customer,
+			invoice:  in.id,
+			currency: in.currency,
+			amount:   in.total - in.credited,
+			created:  s.customerNow(a, in.customer).Unix(),
+		}
+		a.charges = append(a.charges, ch)
+		in.charge = ch.id
+	}
+	return in.render(), nil
+}
+
+// A charge is a payment of an invoice, made by paying it with POST
+// /v1/invoices/:id/pay.
+type charge struct {
+	id       string
+	customer string
+	invoice  string
+	currency string
+	amount   int64
+	refunded int64
+	created  int64
+}
+
+func (ch *charge) render() map[string]any {
+	return map[string]any{
+		"id":              ch.id,
+		"object":          "charge",
+		"customer":        ch.customer,
+		"invoice":         ch.invoice,
+		"currency":        ch.currency,
+		"amount":          ch.amount,
+		"amount_refunded": ch.refunded,
+		"refunded":        ch.refunded == ch.amount,
+		"status":          "succeeded",
+		"created":         ch.created,
+	}
+}
+
+func (a *account) charge(id string) *charge {
+	for _, ch := range a.charges {
+		if ch.id == id {
+			return ch
+		}
+	}
+	return nil
+}
+
+func (a *account) lookupCharge(id string) (any, error) {
+	ch := a.charge(id)
+	if ch == nil {
+		return nil, missing("id", "charge", id)
+	}
+	return ch.render(), nil
+}
+
+// createRefund refunds part or all of the unrefunded amount of a charge.
+func (s *Server) createRefund(a *account, f url.Values) (any, error) {
+	id := f.Get("charge")
+	ch := a.charge(id)
+	if ch == nil {
+		return nil, missing("charge", "charge", id)
+	}
+	remaining := ch.amount - ch.refunded
+	if remaining == 0 {
+		return nil, &apiError{400, &stripe.Error{
+			Type:    "invalid_request_error",
+			Code:    "charge_already_refunded",
+			Message: fmt.Sprintf("Charge %s has already been refunded.", id),
+		}}
+	}
+	amount, err := formIntDefault(f, "amount", remaining)
+	if err != nil {
+		return nil, err
+	}
+	if amount < 1 || amount > remaining {
+		return nil, invalid("amount", "Refund amount (%d) is greater than unrefunded amount on charge (%d)", amount, remaining)
+	}
+	reason := f.Get("reason")
+	switch reason {
+	case "", "duplicate", "fraudulent", "requested_by_customer":
+	default:
+		return nil, invalid("reason", "Invalid reason: must be one of duplicate, fraudulent, or requested_by_customer")
+	}
+	ch.refunded += amount
+	return map[string]any{
+		"id":       s.newID("re"),
+		"object":   "refund",
+		"charge":   ch.id,
+		"amount":   amount,
+		"currency": ch.currency,
+		"reason":   nullable(reason),
+		"status":   "succeeded",
+		"created":  s.customerNow(a, ch.customer).Unix(),
+	}, nil
+}
+
+// createCreditNote credits an amount to an open or paid invoice. Credit
+// notes for open invoices reduce the amount due. Credit notes for paid
+// invoices must say how the amount is returned, using credit_amount or
+// out_of_band_amount; refund_amount is not supported.
+func (s *Server) createCreditNote(a *account, f url.Values) (any, error) {
+	id := f.Get("invoice")
+	in := a.invoice(id)
+	if in == nil {
+		return nil, missing("invoice", "invoice", id)
+	}
+	if in.status != "open" && in.status != "paid" {
+		return nil, &apiError{400, &stripe.Error{
+			Type:    "invalid_request_error",
+			Code:    "invoice_not_editable",
+			Message: fmt.Sprintf("You can only create credit notes for open or paid invoices, but this invoice is %s.", in.status),
+		}}
+	}
+	amount, err := formInt(f, "amount")
+	if err != nil {
+		return nil, err
+	}
+	if amount < 1 || amount > in.total-in.credited {
+		return nil, invalid("amount", "The credit note amount (%d) must be between 1 and the remaining invoice amount (%d).", amount, in.total-in.credited)
+	}
+	if in.status == "paid" {
+		credit, err := formIntDefault(f, "credit_amount", 0)
+		if err != nil {
+			return nil, err
+		}
+		outOfBand, err := formIntDefault(f, "out_of_band_amount", 0)
+		if err != nil {
+			return nil, err
+		}
+		if credit+outOfBand != amount {
+			return nil, invalid("amount", "The sum of credit amount, refund amount and out of band amount must equal the credit note amount.")
+		}
+	}
+	reason := f.Get("reason")
+	switch reason {
+	case "", "duplicate", "fraudulent", "order_change", "product_unsatisfactory":
+	default:
+		return nil, invalid("reason", "Invalid reason: must be one of duplicate, fraudulent, order_change, or product_unsatisfactory")
+	}
+	in.credited += amount
+	return map[string]any{
+		"id":       s.newID("cn"),
+		"object":   "credit_note",
+		"invoice":  in.id,
+		"customer": in.customer,
+		"amount":   amount,
+		"currency": in.currency,
+		"reason":   nullable(reason),
+		"memo":     nullable(f.Get("memo")),
+		"status":   "issued",
+		"created":  s.customerNow(a, in.customer).Unix(),
+	}, nil
+}
+
+// periodsSince returns the number of billing periods of p between anchor
+// and t.
+func periodsSince(p *price, anchor, t int64) int {
+	a := time.Unix(anchor, 0).UTC()
+	n := 0
+	for p.addInterval(a, n).Unix() < t {
+		n++
+	}
+	return n
+}
+
+// list returns a page of items as a Stripe list object, using the limit,
+// starting_after, and expand[] parameters in f.
+func list[T any](f url.Values, items []T, id func(T) string, render func(T, expansions) map[string]any) (any, error) {
+	limit, err := formIntDefault(f, "limit", 10)
+	if err != nil {
+		return nil, err
+	}
+	if limit < 1 || limit > 100 {
+		return nil, invalid("limit", "Invalid limit: must be between 1 and 100")
+	}
+	if after := f.Get("starting_after"); after != "" {
+		i := slices.IndexFunc(items, func(v T) bool { return id(v) == after })
+		if i < 0 {
+			return nil, missing("starting_after", "object", after)
+		}
+		items = items[i+1:]
+	}
+	hasMore := len(items) > int(limit)
+	if hasMore {
+		items = items[:limit]
+	}
+	e := expandParam(f).sub("data")
+	data := make([]map[string]any, 0, len(items))
+	for _, v := range items {
+		data = append(data, render(v, e))
+	}
+	return map[string]any{
+		"object":   "list",
+		"data":     data,
+		"has_more": hasMore,
+	}, nil
+}
+
+// expansions is a set of paths to expand, as requested with expand[]
+// parameters, relative to the object being rendered.
+type expansions map[string]bool
+
+func expandParam(f url.Values) expansions {
+	e := expansions{}
+	for _, path := range f["expand[]"] {
+		e[path] = true
+	}
+	return e
+}
+
+// sub returns the expansions in e relative to the field at path.
+func (e expansions) sub(path string) expansions {
+	sub := expansions{}
+	for p := range e {
+		if strings.HasPrefix(p, path+".") {
+			sub[p[len(path)+1:]] = true
+		}
+	}
+	return sub
+}
+
+func newestFirst[T any](s []T) []T {
+	s = slices.Clone(s)
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+	return s
+}
+
+// formIndexes returns the sorted, distinct indexes i of keys in f of the
+// form prefix[i] or prefix[i][...].
+func formIndexes(f url.Values, prefix string) []int {
+	seen := map[int]bool{}
+	for k := range f {
+		if !strings.HasPrefix(k, prefix+"[") {
+			continue
+		}
+		s, _, ok := strings.Cut(k[len(prefix)+1:], "]")
+		if !ok {
+			continue
+		}
+		if i, err := strconv.Atoi(s); err == nil {
+			seen[i] = true
+		}
+	}
+	var idx []int
+	for i := range seen {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+	return idx
+}
+
+func formInt(f url.Values, key string) (int64, error) {
+	if !f.Has(key) {
+		return 0, invalid(key, "Missing required param: %s.", key)
+	}
+	n, err := strconv.ParseInt(f.Get(key), 10, 64)
+	if err != nil {
+		return 0, invalid(key, "Invalid integer: %s", f.Get(key))
+	}
+	return n, nil
+}
+
+func formIntDefault(f url.Values, key string, def int64) (int64, error) {
+	if !f.Has(key) {
+		return def, nil
+	}
+	return formInt(f, key)
+}
+
+// createdInRange reports whether the unix time created is within the
+// created[gt], created[gte], created[lt], and created[lte] parameters in
+// f, if any.
+func createdInRange(f url.Values, created int64) (bool, error) {
+	for _, c := range []struct {
+		op string
+		ok func(t int64) bool
+	}{
+		{"gt", func(t int64) bool { return created > t }},
+		{"gte", func(t int64) bool { return created >= t }},
+		{"lt", func(t int64) bool { return created < t }},
+		{"lte", func(t int64) bool { return created <= t }},
+	} {
+		key := "created[" + c.op + "]"
+		if !f.Has(key) {
+			continue
+		}
+		t, err := formInt(f, key)
+		if err != nil {
+			return false, err
+		}
+		if !c.ok(t) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// formTime returns the unix time in f at key, or the unix time of now if
+// the value is "now".
+func formTime(f url.Values, key string, now time.Time) (int64, error) {
+	if f.Get(key) == "now" {
+		return now.Unix(), nil
+	}
+	return formInt(f, key)
+}
+
+// formDecimal returns the amount in f at decimalKey, or else at key, as a
+// decimal string. It returns the empty string if neither is set.
+func formDecimal(f url.Values, key, decimalKey string) (string, error) {
+	s := f.Get(decimalKey)
+	if s == "" {
+		s = f.Get(key)
+	}
+	if s == "" {
+		return "", nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v < 0 {
+		return "", invalid(key, "Invalid decimal: %s", s)
+	}
+	d := strconv.FormatFloat(v, 'f', -1, 64)
+	if _, frac, _ := strings.Cut(d, "."); len(frac) > 12 {
+		return "", invalid(decimalKey, "Invalid decimal: %s; must contain at most 12 decimal places", s)
+	}
+	return d, nil
+}
+
+// updateMetadata returns m updated with the metadata[...] parameters in f.
+// Keys set to the empty string are removed.
+func updateMetadata(m map[string]string, f url.Values) map[string]string {
+	if m == nil {
+		m = map[string]string{}
+	}
+	for k := range f {
+		if !strings.HasPrefix(k, "metadata[") || !strings.HasSuffix(k, "]") {
+			continue
+		}
+		key := k[len("metadata[") : len(k)-1]
+		if v := f.Get(k); v == "" {
+			delete(m, key)
+		} else {
+			m[key] = v
+		}
+	}
+	return m
+}
+
+func cloneMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// nullable returns nil if s is empty, so that it is encoded as null.
+func nullable(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// wholeAmount returns the decimal amount d as an integer, or nil if d is
+// empty or not a whole number, as Stripe does for unit_amount.
+func wholeAmount(d string) any {
+	v, err := strconv.ParseFloat(d, 64)
+	if err != nil || v != math.Trunc(v) {
+		return nil
+	}
+	return int64(v)
+}
+
+// quantity returns the quantity of a subscription item with p, which is
+// nil for metered prices.
+func quantity(p *price) any {
+	if p.metered() {
+		return nil
+	}
+	return 1
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// createCheckoutSession creates a checkout session in subscription or setup
+// mode. The session is not kept, since there is no customer to complete it.
+func (s *Server) createCheckoutSession(a *account, f url.Values) (any, error) {
+	if f.Get("success_url") == "" {
+		return nil, invalid("success_url", "Missing required param: success_url.")
+	}
+	cid := f.Get("customer")
+	if cid != "" && a.customer(cid) == nil {
+		return nil, missing("customer", "customer", cid)
+	}
+	mode := f.Get("mode")
+	lines := formIndexes(f, "line_items")
+	switch mode {
+	case "subscription":
+		if len(lines) == 0 {
+			return nil, invalid("line_items", "You must provide at least one recurring price in `subscription` mode when using prices.")
+		}
+		for _, i := range lines {
+			key := fmt.Sprintf("line_items[%d]", i)
+			id := f.Get(key + "[price]")
+			p := a.price(id)
+			if p == nil {
+				return nil, missing(key+"[price]", "price", id)
+			}
+			if p.metered() && f.Has(key+"[quantity]") {
+				return nil, invalid(key+"[quantity]", "Quantity should not be specified where usage_type is `metered`. Remove quantity from `%s`.", key)
+			}
+			if !p.metered() && !f.Has(key+"[quantity]") {
+				return nil, invalid(key+"[quantity]", "Quantity is required. Add `quantity` to `%s`.", key)
+			}
+		}
+	case "setup":
+		if len(lines) > 0 {
+			return nil, invalid("line_items", "You cannot pass `line_items` in `setup` mode.")
+		}
+	default:
+		return nil, invalid("mode", "Invalid mode: must be one of payment, setup, or subscription")
+	}
+	id := s.newID("cs_test")
+	return map[string]any{
+		"id":          id,
+		"object":      "checkout.session",
+		"mode":        mode,
+		"customer":    nullable(cid),
+		"status":      "open",
+		"success_url": f.Get("success_url"),
+		"cancel_url":  nullable(f.Get("cancel_url")),
+		"url":         "https://checkout.stripe.com/c/pay/" + id,
+		"created":     s.now().Unix(),
+	}, nil
+}
@@ -0,0 +1,375 @@
+// Package stripefake provides an in-memory fake of the subset of the Stripe
+// API used by tier.run/control, for tests that must run without network
+// access or a Stripe test account.
+//
+// The fake implements accounts, test clocks, products, prices, customers
+// and their balances, subscription schedules, subscriptions, usage
+// records, invoices and their items, charges for paid invoices, refunds,
+// credit notes, upcoming invoice lines, and the creation of checkout
+// sessions, which cannot be completed. It models the behavior control
+// depends on, such as resource_already_exists errors for duplicate product
+// IDs, idempotent requests, and schedules advancing with test clocks, but
+// it is not a complete or exact model of Stripe. Tests that depend on
+// finer details of Stripe's behavior should use a real Stripe test
+// account.
+package stripefake
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"tier.run/fetch/fetchtest"
+	"tier.run/stripe"
+)
+
+// Client returns a new stripe.Client backed by a new Server that is shut
+// down when t and its subtests complete.
+func Client(t *testing.T) *stripe.Client {
+	s := &Server{}
+	hc := fetchtest.NewServer(t, s.ServeHTTP)
+	return &stripe.Client{
+		APIKey:     "sk_test_stripefake",
+		BaseURL:    fetchtest.BaseURL(hc),
+		HTTPClient: hc,
+		Logf:       t.Logf,
+	}
+}
+
+// Server is a fake Stripe API server. The zero value is ready for use.
+//
+// Objects are stored separately for each account, as selected by the
+// Stripe-Account header, so clients made with stripe.Client.CloneAs using
+// the ID of an account created with POST /v1/accounts are isolated from
+// each other.
+type Server struct {
+	// Now returns the current time for objects not attached to a test
+	// clock. If nil, time.Now is used.
+	Now func() time.Time
+
+	mu       sync.Mutex
+	n        int // last ID number
+	requests int // last request number, for Request-Id headers
+	accounts map[string]*account
+}
+
+// account holds the objects of a single Stripe account.
+type account struct {
+	id      string
+	created int64
+
+	clocks    map[string]*clock
+	products  map[string]*product
+	productID []string // products IDs in order of creation
+	prices    []*price // in order of creation
+	customers []*customer
+	schedules []*schedule
+	subs      []*subscription
+	invoices  []*invoice
+	charges   []*charge
+
+	idempotent map[string]*idempotentResponse
+}
+
+type idempotentResponse struct {
+	request string // method, path, and body of the original request
+	status  int
+	body    []byte
+}
+
+// ServeHTTP implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, 400, &stripe.Error{Type: "invalid_request_error", Message: err.Error()})
+		return
+	}
+	f, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeError(w, 400, &stripe.Error{Type: "invalid_request_error", Message: err.Error()})
+		return
+	}
+	for k, vv := range r.URL.Query() {
+		f[k] = append(f[k], vv...)
+	}
+
+	if key, _, _ := r.BasicAuth(); key == "" {
+		writeError(w, 401, &stripe.Error{
+			Type:    "invalid_request_error",
+			Message: "Invalid API Key provided: (empty)",
+		})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.requests++
+	w.Header().Set("Request-Id", fmt.Sprintf("req_fake%010d", s.requests))
+
+	a := s.account(r.Header.Get("Stripe-Account"))
+	if a == nil {
+		writeError(w, 403, &stripe.Error{
+			Type:    "invalid_request_error",
+			Code:    "account_invalid",
+			Message: "The provided key does not have access to account or that account does not exist.",
+		})
+		return
+	}
+
+	key := r.Header.Get("Idempotency-Key")
+	if key != "" && r.Method == "POST" {
+		req := r.Method + " " + r.URL.Path + "\n" + f.Encode()
+		if res, ok := a.idempotent[key]; ok {
+			if res.request != req {
+				writeError(w, 400, &stripe.Error{
+					Type:    "idempotency_error",
+					Message: "Keys for idempotent requests can only be used with the same parameters they were first used with.",
+				})
+				return
+			}
+			w.Header().Set("Idempotent-Replayed", "true")
+			w.WriteHeader(res.status)
+			w.Write(res.body)
+			return
+		}
+		rec := &recorder{status: 200}
+		s.handle(rec, r.Method, r.URL.Path, a, f)
+		if a.idempotent == nil {
+			a.idempotent = map[string]*idempotentResponse{}
+		}
+		a.idempotent[key] = &idempotentResponse{req, rec.status, rec.body.Bytes()}
+		w.WriteHeader(rec.status)
+		w.Write(rec.body.Bytes())
+		return
+	}
+
+	s.handle(w, r.Method, r.URL.Path, a, f)
+}
+
+// recorder records a response for replaying idempotent requests.
+type recorder struct {
+	status int
+	body   bytes.Buffer
+}
+
+func (r *recorder) Header() http.Header         { return http.Header{} }
+func (r *recorder) WriteHeader(status int)      { r.status = status }
+func (r *recorder) Write(b []byte) (int, error) { return r.body.Write(b) }
+
+// account returns the account with id, or the platform account if id is
+// empty. It returns nil if no such account exists.
+func (s *Server) account(id string) *account {
+	if s.accounts == nil {
+		s.accounts = map[string]*account{}
+	}
+	if id == "" {
+		a := s.accounts[""]
+		if a == nil {
+			a = s.newAccount()
+			s.accounts[""] = a
+		}
+		return a
+	}
+	return s.accounts[id]
+}
+
+func (s *Server) newAccount() *account {
+	return &account{
+		id:       s.newID("acct"),
+		created:  s.now().Unix(),
+		clocks:   map[string]*clock{},
+		products: map[string]*product{},
+	}
+}
+
+func (s *Server) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+	return time.Now()
+}
+
+func (s *Server) newID(prefix string) string {
+	s.n++
+	return fmt.Sprintf("%s_fake%010d", prefix, s.n)
+}
+
+func (s *Server) handle(w http.ResponseWriter, method, path string, a *account, f url.Values) {
+	s.syncSchedules(a)
+
+	parts := strings.Split(strings.TrimPrefix(path, "/v1/"), "/")
+	route := method + " " + parts[0]
+	var id string
+	if len(parts) > 1 {
+		id = parts[1]
+	}
+
+	var v any
+	var err error
+	switch {
+	case route == "GET account" && len(parts) == 1:
+		v = a.render()
+	case route == "POST accounts" && len(parts) == 1:
+		na := s.newAccount()
+		s.accounts[na.id] = na
+		v = na.render()
+
+	case route == "POST test_helpers" && path == "/v1/test_helpers/test_clocks":
+		v, err = s.createClock(a, f)
+	case route == "GET test_helpers" && path == "/v1/test_helpers/test_clocks":
+		v, err = a.listClocks(f)
+	case route == "GET test_helpers" && len(parts) == 3:
+		v, err = a.lookupClock(parts[2])
+	case route == "POST test_helpers" && len(parts) == 4 && parts[3] == "advance":
+		v, err = s.advanceClock(a, parts[2], f)
+	case route == "DELETE test_helpers" && len(parts) == 3:
+		v, err = a.deleteClock(parts[2])
+
+	case route == "POST products" && len(parts) == 1:
+		v, err = s.createProduct(a, f)
+	case route == "GET products" && len(parts) == 1:
+		v, err = a.listProducts(f)
+	case route == "GET products" && len(parts) == 2:
+		v, err = a.lookupProduct(id)
+	case route == "POST products" && len(parts) == 2:
+		v, err = a.updateProduct(id, f)
+
+	case route == "POST prices" && len(parts) == 1:
+		v, err = s.createPrice(a, f)
+	case route == "GET prices" && len(parts) == 1:
+		v, err = a.listPrices(f)
+	case route == "GET prices" && len(parts) == 2:
+		v, err = a.lookupPrice(id, f)
+	case route == "POST prices" && len(parts) == 2:
+		v, err = a.updatePrice(id, f)
+
+	case route == "POST customers" && len(parts) == 1:
+		v, err = s.createCustomer(a, f)
+	case route == "GET customers" && len(parts) == 1:
+		v, err = a.listCustomers(f)
+	case route == "GET customers" && path == "/v1/customers/search":
+		v, err = a.searchCustomers(f)
+	case route == "GET customers" && len(parts) == 2:
+		v, err = a.lookupCustomer(id)
+	case route == "POST customers" && len(parts) == 2:
+		v, err = a.updateCustomer(id, f)
+	case route == "POST customers" && len(parts) == 3 && parts[2] == "balance_transactions":
+		v, err = s.createBalanceTransaction(a, id, f)
+
+	case route == "POST subscription_schedules" && len(parts) == 1:
+		v, err = s.createSchedule(a, f)
+	case route == "POST subscription_schedules" && len(parts) == 2:
+		v, err = s.updateSchedule(a, id, f)
+	case route == "GET subscription_schedules" && len(parts) == 1:
+		v, err = s.listSchedules(a, f)
+	case route == "POST subscription_schedules" && len(parts) == 3 && parts[2] == "cancel":
+		v, err = s.cancelSchedule(a, id, f)
+	case route == "POST subscription_schedules" && len(parts) == 3 && parts[2] == "release":
+		v, err = s.releaseSchedule(a, id, f)
+
+	case route == "GET subscriptions" && len(parts) == 1:
+		v, err = s.listSubscriptions(a, f)
+	case route == "POST subscriptions" && len(parts) == 2:
+		v, err = s.updateSubscription(a, id, f)
+	case route == "POST subscriptions" && len(parts) == 3 && parts[2] == "cancel":
+		v, err = s.cancelSubscription(a, id, f)
+
+	case route == "POST subscription_items" && len(parts) == 3 && parts[2] == "usage_records":
+		v, err = s.createUsageRecord(a, id, f)
+
+	case route == "GET invoices" && path == "/v1/invoices/upcoming/lines":
+		v, err = s.upcomingLines(a, f)
+	case route == "POST invoices" && len(parts) == 1:
+		v, err = s.createInvoice(a, f)
+	case route == "GET invoices" && len(parts) == 1:
+		v, err = a.listInvoices(f)
+	case route == "GET invoices" && len(parts) == 2:
+		v, err = a.lookupInvoice(id)
+	case route == "POST invoices" && len(parts) == 3:
+		v, err = s.transitionInvoice(a, id, parts[2], f)
+	case route == "POST invoiceitems" && len(parts) == 1:
+		v, err = s.createInvoiceItem(a, f)
+	case route == "GET charges" && len(parts) == 2:
+		v, err = a.lookupCharge(id)
+	case route == "POST refunds" && len(parts) == 1:
+		v, err = s.createRefund(a, f)
+	case route == "POST credit_notes" && len(parts) == 1:
+		v, err = s.createCreditNote(a, f)
+	case route == "POST checkout" && path == "/v1/checkout/sessions":
+		v, err = s.createCheckoutSession(a, f)
+
+	default:
+		err = &apiError{404, &stripe.Error{
+			Type:    "invalid_request_error",
+			Message: fmt.Sprintf("Unrecognized request URL (%s: %s).", method, path),
+		}}
+	}
+	if err != nil {
+		if e, ok := err.(*apiError); ok {
+			writeError(w, e.status, e.err)
+		} else {
+			writeError(w, 400, &stripe.Error{Type: "invalid_request_error", Message: err.Error()})
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		panic(err)
+	}
+}
+
+// apiError is an error with the HTTP status to report it with.
+type apiError struct {
+	status int
+	err    *stripe.Error
+}
+
+func (e *apiError) Error() string { return e.err.Error() }
+
+func invalid(param, format string, args ...any) error {
+	return &apiError{400, &stripe.Error{
+		Type:    "invalid_request_error",
+		Param:   param,
+		Message: fmt.Sprintf(format, args...),
+	}}
+}
+
+func missing(param, kind, id string) error {
+	return &apiError{404, &stripe.Error{
+		Type:    "invalid_request_error",
+		Code:    "resource_missing",
+		Param:   param,
+		Message: fmt.Sprintf("No such %s: '%s'", kind, id),
+	}}
+}
+
+func writeError(w http.ResponseWriter, status int, e *stripe.Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]any{
+		"error": map[string]any{
+			"type":    e.Type,
+			"code":    e.Code,
+			"param":   e.Param,
+			"message": e.Message,
+		},
+	})
+}
+
+func (a *account) render() map[string]any {
+	return map[string]any{
+		"id":      a.id,
+		"object":  "account",
+		"email":   "stripefake@example.com",
+		"created": a.created,
+	}
+}
@@ -0,0 +1,153 @@
+package stripefake
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"tier.run/stripe"
+)
+
+type testCustomer struct {
+	stripe.ID
+	Email string
+}
+
+func TestIdempotency(t *testing.T) {
+	c := Client(t)
+	ctx := context.Background()
+
+	create := func(email string) (testCustomer, error) {
+		var f stripe.Form
+		f.SetIdempotencyKey("create")
+		f.Set("email", email)
+		var v testCustomer
+		err := c.Do(ctx, "POST", "/v1/customers", f, &v)
+		return v, err
+	}
+
+	a, err := create("a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := create("a@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.ID != b.ID {
+		t.Errorf("replayed request created a new customer: %q != %q", a.ID, b.ID)
+	}
+	if _, err := create("b@example.com"); !errors.Is(err, stripe.ErrIdempotency) {
+		t.Errorf("got %v; want %v", err, stripe.ErrIdempotency)
+	}
+
+	cs, err := stripe.Slurp[testCustomer](ctx, c, "GET", "/v1/customers", stripe.Form{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cs) != 1 {
+		t.Errorf("got %d customers; want 1", len(cs))
+	}
+}
+
+func TestListPages(t *testing.T) {
+	c := Client(t)
+	ctx := context.Background()
+
+	const n = 25
+	for i := 0; i < n; i++ {
+		var f stripe.Form
+		f.Set("email", fmt.Sprintf("%d@example.com", i))
+		if err := c.Do(ctx, "POST", "/v1/customers", f, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var f stripe.Form
+	f.Set("limit", 10)
+	var got []string
+	err := stripe.Iter(ctx, c, "GET", "/v1/customers", f, func(v testCustomer) bool {
+		got = append(got, v.Email)
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != n {
+		t.Fatalf("got %d customers; want %d", len(got), n)
+	}
+	if got[0] != "24@example.com" {
+		t.Errorf("got[0] = %q; want newest first", got[0])
+	}
+}
+
+func TestSearchCustomers(t *testing.T) {
+	c := Client(t)
+	ctx := context.Background()
+
+	const n = 120
+	for i := 0; i < n; i++ {
+		var f stripe.Form
+		f.Set("email", fmt.Sprintf("%d@example.com", i))
+		f.Set("metadata", "tier.parent", "org:it's")
+		if err := c.Do(ctx, "POST", "/v1/customers", f, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var f stripe.Form
+	f.Set("metadata", "tier.parent", "org:other")
+	if err := c.Do(ctx, "POST", "/v1/customers", f, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	q := "metadata['tier.parent']:" + stripe.QuoteSearch("org:it's")
+	seen := map[string]bool{}
+	err := stripe.Search(ctx, c, "/v1/customers/search", q, stripe.Form{}, func(v testCustomer) bool {
+		if seen[v.Email] {
+			t.Errorf("%s found twice", v.Email)
+		}
+		seen[v.Email] = true
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != n {
+		t.Errorf("found %d customers; want %d", len(seen), n)
+	}
+}
+
+func TestAccountIsolation(t *testing.T) {
+	c := Client(t)
+	ctx := context.Background()
+
+	var acct struct{ stripe.ID }
+	if err := c.Do(ctx, "POST", "/v1/accounts", stripe.Form{}, &acct); err != nil {
+		t.Fatal(err)
+	}
+	ca := c.CloneAs(acct.ProviderID())
+
+	var f stripe.Form
+	f.Set("id", "prod_1")
+	f.Set("name", "Product")
+	if err := ca.Do(ctx, "POST", "/v1/products", f, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := ca.Do(ctx, "POST", "/v1/products", f, nil); !isExists(err) {
+		t.Errorf("got %v; want resource_already_exists", err)
+	}
+	if err := c.Do(ctx, "GET", "/v1/products/prod_1", stripe.Form{}, nil); !errors.Is(err, stripe.ErrResourceMissing) {
+		t.Errorf("got %v; want %v", err, stripe.ErrResourceMissing)
+	}
+
+	bad := c.CloneAs("acct_nope")
+	if err := bad.Do(ctx, "GET", "/v1/account", stripe.Form{}, nil); !errors.Is(err, stripe.ErrPermission) {
+		t.Errorf("got %v; want %v", err, stripe.ErrPermission)
+	}
+}
+
+func isExists(err error) bool {
+	var e *stripe.Error
+	return errors.As(err, &e) && e.Code == "resource_already_exists"
+}
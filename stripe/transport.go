@@ -0,0 +1,108 @@
+package stripe
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Default transport settings. Stripe requests all go to a single host, so
+// the idle pool per host is kept much larger than net/http's default of 2,
+// which otherwise causes a new TLS connection for most requests made in
+// parallel, such as when reporting usage at high volume.
+const (
+	defaultMaxIdleConnsPerHost = 64
+	defaultDialTimeout         = 10 * time.Second
+	defaultTLSHandshakeTimeout = 10 * time.Second
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultTLSSessionCacheSize = 64
+)
+
+// TransportConfig configures the HTTP transport a Client uses when its
+// HTTPClient is nil. For each field, zero selects a default suited to high
+// request volumes, and a negative value disables the feature.
+type TransportConfig struct {
+	// MaxIdleConnsPerHost is the number of idle connections kept for
+	// reuse. If zero, 64 is used. If negative, connections are not reused.
+	MaxIdleConnsPerHost int
+
+	// IdleConnTimeout is how long an idle connection is kept before being
+	// closed. If zero, 90 seconds is used. If negative, idle connections
+	// are kept until closed by Stripe.
+	IdleConnTimeout time.Duration
+
+	// DialTimeout limits the time spent establishing a TCP connection. If
+	// zero, 10 seconds is used. If negative, there is no limit.
+	DialTimeout time.Duration
+
+	// TLSHandshakeTimeout limits the time spent on a TLS handshake. If
+	// zero, 10 seconds is used. If negative, there is no limit.
+	TLSHandshakeTimeout time.Duration
+
+	// TLSSessionCacheSize is the number of TLS sessions cached for
+	// resumption when new connections are made. If zero, 64 is used. If
+	// negative, sessions are not resumed.
+	TLSSessionCacheSize int
+}
+
+// orDefault returns d if v is zero, zero if v is negative, or v otherwise.
+func orDefault[T int | time.Duration](v, d T) T {
+	switch {
+	case v == 0:
+		return d
+	case v < 0:
+		return 0
+	}
+	return v
+}
+
+// NewTransport returns a new http.Transport configured by tc.
+func (tc TransportConfig) NewTransport() *http.Transport {
+	d := &net.Dialer{
+		Timeout:   orDefault(tc.DialTimeout, defaultDialTimeout),
+		KeepAlive: 30 * time.Second,
+	}
+	t := &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           d.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConnsPerHost:   orDefault(tc.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost),
+		IdleConnTimeout:       orDefault(tc.IdleConnTimeout, defaultIdleConnTimeout),
+		TLSHandshakeTimeout:   orDefault(tc.TLSHandshakeTimeout, defaultTLSHandshakeTimeout),
+		ExpectContinueTimeout: 1 * time.Second,
+		TLSClientConfig:       &tls.Config{MinVersion: tls.VersionTLS12},
+	}
+	t.MaxIdleConns = t.MaxIdleConnsPerHost
+	if tc.MaxIdleConnsPerHost < 0 {
+		t.DisableKeepAlives = true
+	}
+	if n := orDefault(tc.TLSSessionCacheSize, defaultTLSSessionCacheSize); n > 0 {
+		t.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(n)
+	}
+	return t
+}
+
+// client returns c.HTTPClient, or if it is nil, the client returned by
+// sharedClient.
+func (c *Client) client() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return c.sharedClient()
+}
+
+// sharedClient returns an http.Client using a transport configured by
+// c.Transport, shared by c and its clones so they reuse connections. It
+// returns nil if c.HTTPClient is set.
+func (c *Client) sharedClient() *http.Client {
+	if c.HTTPClient != nil {
+		return nil
+	}
+	c.hcMu.Lock()
+	defer c.hcMu.Unlock()
+	if c.hc == nil {
+		c.hc = &http.Client{Transport: c.Transport.NewTransport()}
+	}
+	return c.hc
+}
@@ -0,0 +1,58 @@
+package stripe
+
+import (
+	"testing"
+	"time"
+
+	"kr.dev/diff"
+)
+
+func TestTransportConfig(t *testing.T) {
+	tr := TransportConfig{}.NewTransport()
+	if tr.MaxIdleConnsPerHost != defaultMaxIdleConnsPerHost {
+		t.Errorf("MaxIdleConnsPerHost = %d; want %d", tr.MaxIdleConnsPerHost, defaultMaxIdleConnsPerHost)
+	}
+	if tr.TLSHandshakeTimeout != defaultTLSHandshakeTimeout {
+		t.Errorf("TLSHandshakeTimeout = %v; want %v", tr.TLSHandshakeTimeout, defaultTLSHandshakeTimeout)
+	}
+	if tr.TLSClientConfig.ClientSessionCache == nil {
+		t.Error("ClientSessionCache = nil; want cache")
+	}
+	if tr.DisableKeepAlives {
+		t.Error("DisableKeepAlives = true; want false")
+	}
+
+	tr = TransportConfig{
+		MaxIdleConnsPerHost: -1,
+		IdleConnTimeout:     time.Minute,
+		TLSHandshakeTimeout: -1,
+		TLSSessionCacheSize: -1,
+	}.NewTransport()
+	if !tr.DisableKeepAlives {
+		t.Error("DisableKeepAlives = false; want true")
+	}
+	diff.Test(t, t.Errorf, tr.IdleConnTimeout, time.Minute)
+	diff.Test(t, t.Errorf, tr.TLSHandshakeTimeout, time.Duration(0))
+	if tr.TLSClientConfig.ClientSessionCache != nil {
+		t.Error("ClientSessionCache != nil; want nil")
+	}
+}
+
+func TestSharedClient(t *testing.T) {
+	c := &Client{}
+	hc := c.client()
+	if hc == nil || hc.Transport == nil {
+		t.Fatal("client() returned no transport")
+	}
+	if c.client() != hc {
+		t.Error("client() not reused")
+	}
+	if c.CloneAs("acct_123").client() != hc {
+		t.Error("clone does not share client")
+	}
+
+	c = newTestClient(t, nil)
+	if c.CloneAs("acct_123").client() != c.HTTPClient {
+		t.Error("clone does not use HTTPClient")
+	}
+}
@@ -0,0 +1,145 @@
+package stripe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Webhook errors
+var (
+	ErrInvalidSignature = errors.New("stripe: invalid webhook signature")
+	ErrSignatureExpired = errors.New("stripe: webhook timestamp outside of tolerance")
+)
+
+// DefaultWebhookTolerance is the maximum age of a webhook signature accepted by
+// VerifyWebhook. It matches the tolerance used by Stripe's own libraries.
+const DefaultWebhookTolerance = 5 * time.Minute
+
+// VerifyWebhook verifies that payload was signed by Stripe using secret, as
+// reported in sigHeader, the value of the Stripe-Signature header sent with
+// the webhook request. Signatures are compared in constant time.
+//
+// It returns ErrInvalidSignature if sigHeader is malformed or contains no
+// matching signature, or ErrSignatureExpired if the signature timestamp is
+// more than DefaultWebhookTolerance away from the current time.
+func VerifyWebhook(payload []byte, sigHeader, secret string) error {
+	return VerifyWebhookTolerance(payload, sigHeader, secret, DefaultWebhookTolerance)
+}
+
+// VerifyWebhookTolerance is like VerifyWebhook, but accepts signatures
+// timestamped up to tolerance away from the current time, such as for
+// receivers reading webhooks from a queue that may delay them. If tolerance
+// is zero or negative, the timestamp is not checked, leaving replayed
+// webhooks undetected.
+func VerifyWebhookTolerance(payload []byte, sigHeader, secret string, tolerance time.Duration) error {
+	return verifyWebhook(payload, sigHeader, secret, time.Now(), tolerance)
+}
+
+func verifyWebhook(payload []byte, sigHeader, secret string, now time.Time, tolerance time.Duration) error {
+	var ts int64 = -1
+	var sigs [][]byte
+	for _, part := range strings.Split(sigHeader, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return ErrInvalidSignature
+			}
+			ts = n
+		case "v1":
+			sig, err := hex.DecodeString(v)
+			if err != nil {
+				continue // ignore malformed signatures
+			}
+			sigs = append(sigs, sig)
+		}
+	}
+	if ts < 0 || len(sigs) == 0 {
+		return ErrInvalidSignature
+	}
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(ts, 10)))
+	mac.Write([]byte{'.'})
+	mac.Write(payload)
+	want := mac.Sum(nil)
+
+	var ok bool
+	for _, sig := range sigs {
+		if hmac.Equal(sig, want) {
+			ok = true
+		}
+	}
+	if !ok {
+		return ErrInvalidSignature
+	}
+
+	age := now.Sub(time.Unix(ts, 0))
+	if age < 0 {
+		age = -age
+	}
+	if tolerance > 0 && age > tolerance {
+		return ErrSignatureExpired
+	}
+	return nil
+}
+
+// Event is the envelope of a Stripe event as sent to webhook endpoints.
+type Event struct {
+	ID       string
+	Type     string    // (e.g. "customer.subscription.updated")
+	Account  string    // the connected account, if any
+	Livemode bool      // true if the event occurred in live mode
+	Created  time.Time // the time the event was created
+
+	// Object is the raw JSON of the object the event is about. Use
+	// Decode to unmarshal it into a typed value.
+	Object json.RawMessage
+
+	// Previous is the raw JSON of the previous values of any updated
+	// attributes, for "*.updated" events.
+	Previous json.RawMessage
+}
+
+// ParseEvent decodes the event envelope in payload. It does not verify the
+// signature; use VerifyWebhook first for payloads from untrusted sources.
+func ParseEvent(payload []byte) (*Event, error) {
+	var v struct {
+		ID       string
+		Type     string
+		Account  string
+		Livemode bool
+		Created  int64
+		Data     struct {
+			Object   json.RawMessage
+			Previous json.RawMessage `json:"previous_attributes"`
+		}
+	}
+	if err := json.Unmarshal(payload, &v); err != nil {
+		return nil, err
+	}
+	if v.ID == "" || v.Type == "" {
+		return nil, errors.New("stripe: invalid event: missing id or type")
+	}
+	return &Event{
+		ID:       v.ID,
+		Type:     v.Type,
+		Account:  v.Account,
+		Livemode: v.Livemode,
+		Created:  time.Unix(v.Created, 0),
+		Object:   v.Data.Object,
+		Previous: v.Data.Previous,
+	}, nil
+}
+
+// Decode unmarshals the event's object into v.
+func (e *Event) Decode(v any) error {
+	return json.Unmarshal(e.Object, v)
+}
@@ -0,0 +1,100 @@
+package stripe
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func sign(payload []byte, secret string, ts int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.%s", ts, payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyWebhook(t *testing.T) {
+	const secret = "whsec_test"
+	payload := []byte(`{"id":"evt_1","type":"customer.created"}`)
+	now := time.Unix(1600000000, 0)
+	ts := now.Unix()
+	good := sign(payload, secret, ts)
+
+	cases := []struct {
+		header string
+		now    time.Time
+		want   error
+	}{
+		{fmt.Sprintf("t=%d,v1=%s", ts, good), now, nil},
+		{fmt.Sprintf("t=%d,v1=bad,v1=%s,v0=xxx", ts, good), now, nil},
+		{fmt.Sprintf("t=%d, v1=%s", ts, good), now, nil},
+		{fmt.Sprintf("t=%d,v1=%s", ts, good), now.Add(4 * time.Minute), nil},
+		{fmt.Sprintf("t=%d,v1=%s", ts, good), now.Add(-4 * time.Minute), nil},
+		{fmt.Sprintf("t=%d,v1=%s", ts, good), now.Add(6 * time.Minute), ErrSignatureExpired},
+		{fmt.Sprintf("t=%d,v1=%s", ts, sign(payload, "wrong", ts)), now, ErrInvalidSignature},
+		{fmt.Sprintf("t=%d,v1=%s", ts+1, good), now, ErrInvalidSignature},
+		{fmt.Sprintf("v1=%s", good), now, ErrInvalidSignature},
+		{fmt.Sprintf("t=%d", ts), now, ErrInvalidSignature},
+		{"t=x,v1=00", now, ErrInvalidSignature},
+		{"", now, ErrInvalidSignature},
+	}
+	for _, tc := range cases {
+		got := verifyWebhook(payload, tc.header, secret, tc.now, DefaultWebhookTolerance)
+		if got != tc.want {
+			t.Errorf("verifyWebhook(%q) = %v; want %v", tc.header, got, tc.want)
+		}
+	}
+
+	old := time.Now().Add(-10 * time.Minute).Unix()
+	header := fmt.Sprintf("t=%d,v1=%s", old, sign(payload, secret, old))
+	if err := VerifyWebhook(payload, header, secret); err != ErrSignatureExpired {
+		t.Errorf("VerifyWebhook(10m old) = %v; want %v", err, ErrSignatureExpired)
+	}
+	if err := VerifyWebhookTolerance(payload, header, secret, time.Hour); err != nil {
+		t.Errorf("VerifyWebhookTolerance(10m old, 1h) = %v; want nil", err)
+	}
+	if err := VerifyWebhookTolerance(payload, header, "wrong", time.Hour); err != ErrInvalidSignature {
+		t.Errorf("VerifyWebhookTolerance(wrong secret) = %v; want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestParseEvent(t *testing.T) {
+	payload := []byte(`{
+		"id": "evt_1",
+		"type": "customer.updated",
+		"account": "acct_1",
+		"livemode": true,
+		"created": 1600000000,
+		"data": {
+			"object": {"id": "cus_1", "email": "a@b.com"},
+			"previous_attributes": {"email": "old@b.com"}
+		}
+	}`)
+	e, err := ParseEvent(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.ID != "evt_1" || e.Type != "customer.updated" || e.Account != "acct_1" || !e.Livemode {
+		t.Errorf("unexpected event: %+v", e)
+	}
+	if !e.Created.Equal(time.Unix(1600000000, 0)) {
+		t.Errorf("Created = %v", e.Created)
+	}
+
+	var cus struct {
+		ID    string
+		Email string
+	}
+	if err := e.Decode(&cus); err != nil {
+		t.Fatal(err)
+	}
+	if cus.ID != "cus_1" || cus.Email != "a@b.com" {
+		t.Errorf("unexpected object: %+v", cus)
+	}
+
+	if _, err := ParseEvent([]byte(`{}`)); err == nil {
+		t.Error("expected error for event without id")
+	}
+}
@@ -0,0 +1,61 @@
+// Package trace defines the hooks the api, control, and stripe packages use
+// to trace requests, from the API handler down to each request made to
+// Stripe.
+//
+// The interfaces follow the shape of OpenTelemetry's tracing API, so a
+// TracerProvider from go.opentelemetry.io/otel can be adapted to a Tracer
+// in a few lines, without tier depending on OpenTelemetry. Spans are
+// expected to be parented using ctx, as OpenTelemetry does.
+package trace
+
+import "context"
+
+// An Attr is a key-value pair describing a span, such as the org or
+// feature a request concerns.
+type Attr struct {
+	Key   string
+	Value any // a string, int, or bool
+}
+
+// String returns an Attr with a string value.
+func String(key, value string) Attr { return Attr{key, value} }
+
+// Int returns an Attr with an int value.
+func Int(key string, value int) Attr { return Attr{key, value} }
+
+// A Tracer starts spans.
+type Tracer interface {
+	// Start starts a span named name as a child of the span in ctx, if
+	// any, and returns a context holding the new span.
+	Start(ctx context.Context, name string, attrs ...Attr) (context.Context, Span)
+}
+
+// A Span is a traced operation.
+type Span interface {
+	SetAttributes(attrs ...Attr)
+	RecordError(err error)
+	End()
+}
+
+// Start starts a span using t, or a span doing nothing if t is nil.
+func Start(ctx context.Context, t Tracer, name string, attrs ...Attr) (context.Context, Span) {
+	if t == nil {
+		return ctx, nopSpan{}
+	}
+	return t.Start(ctx, name, attrs...)
+}
+
+// End records err in s, if non-nil, and ends s. It is meant to be deferred
+// with a pointer to a named error result.
+func End(s Span, err *error) {
+	if *err != nil {
+		s.RecordError(*err)
+	}
+	s.End()
+}
+
+type nopSpan struct{}
+
+func (nopSpan) SetAttributes(...Attr) {}
+func (nopSpan) RecordError(error)     {}
+func (nopSpan) End()                  {}
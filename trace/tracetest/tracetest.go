@@ -0,0 +1,78 @@
+// Package tracetest provides a trace.Tracer recording spans for tests.
+package tracetest
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/exp/maps"
+	"tier.run/trace"
+)
+
+// A Span is a span recorded by a Recorder.
+type Span struct {
+	Name   string
+	Parent string // the name of the parent span, if any
+	Attrs  map[string]any
+	Err    error
+	Ended  bool
+}
+
+// A Recorder is a trace.Tracer that records the spans it starts. The zero
+// value is ready for use.
+type Recorder struct {
+	mu    sync.Mutex
+	spans []*Span
+}
+
+type spanKey struct{}
+
+// Start implements trace.Tracer.
+func (r *Recorder) Start(ctx context.Context, name string, attrs ...trace.Attr) (context.Context, trace.Span) {
+	s := &recorderSpan{r: r, s: &Span{Name: name, Attrs: map[string]any{}}}
+	if p, ok := ctx.Value(spanKey{}).(*recorderSpan); ok {
+		s.s.Parent = p.s.Name
+	}
+	s.SetAttributes(attrs...)
+	r.mu.Lock()
+	r.spans = append(r.spans, s.s)
+	r.mu.Unlock()
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Spans returns copies of the spans recorded, in the order started.
+func (r *Recorder) Spans() []Span {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ss := make([]Span, len(r.spans))
+	for i, s := range r.spans {
+		ss[i] = *s
+		ss[i].Attrs = maps.Clone(s.Attrs)
+	}
+	return ss
+}
+
+type recorderSpan struct {
+	r *Recorder
+	s *Span
+}
+
+func (s *recorderSpan) SetAttributes(attrs ...trace.Attr) {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	for _, a := range attrs {
+		s.s.Attrs[a.Key] = a.Value
+	}
+}
+
+func (s *recorderSpan) RecordError(err error) {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.s.Err = err
+}
+
+func (s *recorderSpan) End() {
+	s.r.mu.Lock()
+	defer s.r.mu.Unlock()
+	s.s.Ended = true
+}
@@ -14,6 +14,7 @@ type HTTPError struct {
 	Status  int    `json:"status"`
 	Code    string `json:"code"` // (e.g. "invalid_request")
 	Message string `json:"message"`
+	Details any    `json:"details,omitempty"` // additional error specific detail
 }
 
 var (
@@ -25,7 +26,7 @@ var (
 )
 
 func Error(status int, code string, message string) error {
-	return &HTTPError{"", status, code, message}
+	return &HTTPError{Status: status, Code: code, Message: message}
 }
 
 // WriteError encodes err to w, setting the approriate headers, if the underlying
@@ -72,7 +73,7 @@ func jsonErr(err error) error {
 	}
 	switch err.(type) {
 	case *json.SyntaxError:
-		return &HTTPError{"", 400, "invalid_request", "invalid json syntax"}
+		return &HTTPError{Status: 400, Code: "invalid_request", Message: "invalid json syntax"}
 	default:
 		if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
 			msg = strings.TrimPrefix(msg, "json: ")
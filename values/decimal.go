@@ -0,0 +1,130 @@
+package values
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// A Decimal is an exact decimal number, such as a price in fractions of the
+// smallest currency unit, which float64 cannot hold exactly. Decimals are
+// kept in a canonical form, so that equal Decimals are equal with ==. The
+// zero Decimal is 0.
+type Decimal struct {
+	s string // canonical; "" for zero
+}
+
+// ParseDecimal parses s as a decimal number, such as "0.07", "-3", or
+// "7e-2", as JSON numbers are written.
+func ParseDecimal(s string) (Decimal, error) {
+	bad := func() (Decimal, error) {
+		return Decimal{}, fmt.Errorf("values: invalid decimal %q", s)
+	}
+	mant, neg := s, strings.HasPrefix(s, "-")
+	if neg {
+		mant = s[1:]
+	}
+	exp := 0
+	if i := strings.IndexAny(mant, "eE"); i >= 0 {
+		e, err := strconv.Atoi(mant[i+1:])
+		if err != nil || e < -1000 || e > 1000 {
+			return bad()
+		}
+		mant, exp = mant[:i], e
+	}
+	ip, fp, _ := strings.Cut(mant, ".")
+	if ip == "" && fp == "" || !isDigits(ip) || !isDigits(fp) {
+		return bad()
+	}
+
+	// Move the decimal point exp places right in the digits.
+	digits, point := ip+fp, len(ip)+exp
+	if point < 0 {
+		digits, point = strings.Repeat("0", -point)+digits, 0
+	}
+	if point > len(digits) {
+		digits += strings.Repeat("0", point-len(digits))
+	}
+	ip = strings.TrimLeft(digits[:point], "0")
+	fp = strings.TrimRight(digits[point:], "0")
+	if ip == "" && fp == "" {
+		return Decimal{}, nil
+	}
+	d := Coalesce(ip, "0")
+	if fp != "" {
+		d += "." + fp
+	}
+	if neg {
+		d = "-" + d
+	}
+	return Decimal{d}, nil
+}
+
+// MustParseDecimal is like ParseDecimal but panics if s is invalid.
+func MustParseDecimal(s string) Decimal {
+	d, err := ParseDecimal(s)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+// DecimalOf returns n as a Decimal.
+func DecimalOf(n int) Decimal {
+	if n == 0 {
+		return Decimal{}
+	}
+	return Decimal{strconv.Itoa(n)}
+}
+
+func isDigits(s string) bool {
+	return strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' }) < 0
+}
+
+// String returns d as a decimal without an exponent, and with the fewest
+// digits that represent it.
+func (d Decimal) String() string { return Coalesce(d.s, "0") }
+
+func (d Decimal) IsZero() bool { return d.s == "" }
+
+// Sign returns -1, 0, or 1 as d is negative, zero, or positive.
+func (d Decimal) Sign() int {
+	switch {
+	case d.s == "":
+		return 0
+	case d.s[0] == '-':
+		return -1
+	default:
+		return 1
+	}
+}
+
+// Places returns the number of digits of d after the decimal point.
+func (d Decimal) Places() int {
+	_, fp, _ := strings.Cut(d.s, ".")
+	return len(fp)
+}
+
+// MarshalJSON implements json.Marshaler, writing d as a number.
+func (d Decimal) MarshalJSON() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalJSON implements json.Unmarshaler, accepting numbers, decimal
+// strings, such as Stripe's unit_amount_decimal, and null, as zero.
+func (d *Decimal) UnmarshalJSON(data []byte) error {
+	s := string(data)
+	if s == "null" {
+		*d = Decimal{}
+		return nil
+	}
+	if len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"' {
+		s = s[1 : len(s)-1]
+	}
+	v, err := ParseDecimal(s)
+	if err != nil {
+		return err
+	}
+	*d = v
+	return nil
+}
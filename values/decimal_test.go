@@ -0,0 +1,63 @@
+package values
+
+import (
+	"encoding/json"
+	"testing"
+
+	"kr.dev/diff"
+)
+
+func TestParseDecimal(t *testing.T) {
+	cases := []struct {
+		in     string
+		want   string
+		places int
+	}{
+		{"0", "0", 0},
+		{"-0.0", "0", 0},
+		{"007", "7", 0},
+		{"0.07", "0.07", 2},
+		{"0.070", "0.07", 2},
+		{".5", "0.5", 1},
+		{"-1.50", "-1.5", 1},
+		{"1e-7", "0.0000001", 7},
+		{"7E2", "700", 0},
+		{"1.25e1", "12.5", 1},
+		{"0.000000000001", "0.000000000001", 12},
+		{"0.1111111111111111", "0.1111111111111111", 16},
+	}
+	for _, tt := range cases {
+		d, err := ParseDecimal(tt.in)
+		if err != nil {
+			t.Errorf("ParseDecimal(%q): %v", tt.in, err)
+			continue
+		}
+		if d.String() != tt.want || d.Places() != tt.places {
+			t.Errorf("ParseDecimal(%q) = %s with %d places; want %s with %d", tt.in, d, d.Places(), tt.want, tt.places)
+		}
+		if d != MustParseDecimal(tt.want) {
+			t.Errorf("ParseDecimal(%q) != ParseDecimal(%q)", tt.in, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "-", ".", "1.2.3", "1/3", "+1", "0x10", "1e", "1e9999", "NaN"} {
+		if d, err := ParseDecimal(in); err == nil {
+			t.Errorf("ParseDecimal(%q) = %s; want error", in, d)
+		}
+	}
+}
+
+func TestDecimalJSON(t *testing.T) {
+	var got []Decimal
+	if err := json.Unmarshal([]byte(`[0.07, "0.0700", 100, null, 1e-7]`), &got); err != nil {
+		t.Fatal(err)
+	}
+	want := []Decimal{MustParseDecimal("0.07"), MustParseDecimal("0.07"), DecimalOf(100), {}, MustParseDecimal("0.0000001")}
+	diff.Test(t, t.Errorf, got, want)
+
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diff.Test(t, t.Errorf, string(data), `[0.07,0.07,100,0,0.0000001]`)
+}